@@ -22,6 +22,8 @@ import (
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/auth"
@@ -31,31 +33,87 @@ import (
 	driver "github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/csi_driver"
 	csimounter "github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/csi_mounter"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/metrics"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
 	"k8s.io/klog/v2"
 	"k8s.io/mount-utils"
 )
 
 var (
-	endpoint                  = flag.String("endpoint", "unix:/tmp/csi.sock", "CSI endpoint")
-	nodeID                    = flag.String("nodeid", "", "node id")
-	runController             = flag.Bool("controller", false, "run controller service")
-	runNode                   = flag.Bool("node", false, "run node service")
-	kubeconfigPath            = flag.String("kubeconfig-path", "", "The kubeconfig path.")
-	identityPool              = flag.String("identity-pool", "", "The Identity Pool to authenticate with GCS API.")
-	identityProvider          = flag.String("identity-provider", "", "The Identity Provider to authenticate with GCS API.")
-	enableProfiling           = flag.Bool("enable-profiling", false, "enable the golang pprof at port 6060")
-	informerResyncDurationSec = flag.Int("informer-resync-duration-sec", 1800, "informer resync duration in seconds")
-	fuseSocketDir             = flag.String("fuse-socket-dir", "/sockets", "FUSE socket directory")
-	metricsEndpoint           = flag.String("metrics-endpoint", "", "The TCP network address where the Prometheus metrics endpoint will listen (example: `:8080`). The default is empty string, which means that the metrics endpoint is disabled.")
+	endpoint                   = flag.String("endpoint", "unix:/tmp/csi.sock", "CSI endpoint")
+	driverName                 = flag.String("driver-name", driver.DefaultName, "The name of the CSI driver. Set a distinct name to run multiple driver installations on one cluster.")
+	nodeID                     = flag.String("nodeid", "", "node id")
+	runController              = flag.Bool("controller", false, "run controller service")
+	runNode                    = flag.Bool("node", false, "run node service")
+	kubeconfigPath             = flag.String("kubeconfig-path", "", "The kubeconfig path.")
+	identityPool               = flag.String("identity-pool", "", "The Identity Pool to authenticate with GCS API.")
+	identityProvider           = flag.String("identity-provider", "", "The Identity Provider to authenticate with GCS API.")
+	enableProfiling            = flag.Bool("enable-profiling", false, "enable the golang pprof at port 6060")
+	informerResyncDurationSec  = flag.Int("informer-resync-duration-sec", 1800, "informer resync duration in seconds")
+	fuseSocketDir              = flag.String("fuse-socket-dir", "/sockets", "FUSE socket directory")
+	experimentalFlagsAllowlist = flag.String("gcsfuse-experimental-flags-allowlist", "", "A comma-separated list of gcsfuse flags that volumes may set using the gcsfuseExperimentalFlags volume attribute, for example `experimental-enable-json-read,write:enable-streaming-writes`. The default is empty string, which means that experimental flags are rejected.")
+	nodeOpsPerSecBudget        = flag.Int("gcsfuse-node-ops-per-sec-budget", 0, "The total GCS operations per second that the gcsfuse volumes on a node may use. Each new volume reserves an equal share of the budget, capped at the budget that the mounted volumes have not reserved and at least 1, passed as the gcsfuse limit-ops-per-sec flag unless the volume sets the flag, and returns it when it is unmounted. gcsfuse cannot change the limit of a running volume. The volumes mounted before the driver restarted are not counted. The default is 0, which means that the operations are not limited.")
+	nodeMemoryBudgetMB         = flag.Int64("gcsfuse-node-memory-budget-mb", 0, "The total memory in MiB that the gcsfuse sidecar containers on a node may use before the node service refuses to mount new volumes. A refused mount fails with a warning event on the Pod, and kubelet retries it until the sidecar containers of the other Pods use less memory. The default is 0, which means that new volumes are always mounted.")
+	volumeStatsMaxObjects      = flag.Int("volume-stats-max-objects", 0, "The maximum number of objects that the node service lists in the background to count the used bytes and inodes of a volume, which kubelet exports as the kubelet_volume_stats metrics. The objects are counted again every 10 minutes using the identity of the Pod. A volume with more objects reports the usage of the first objects only. The default 0 disables counting the objects, which reports the usage as zero.")
+	sidecarImage               = flag.String("sidecar-image", "", "The sidecar container image injected by the webhook. It is only used to report versions.")
+	metricsEndpoint            = flag.String("metrics-endpoint", "", "The TCP network address where the Prometheus metrics endpoint will listen (example: `:8080`). The default is empty string, which means that the metrics endpoint is disabled.")
+	loggingFormat              = flag.String("logging-format", util.LoggingFormatText, "The log output format, either `text` or `json`. The json format writes one JSON object per line, which Cloud Logging parses into structured fields.")
+	downscopeReadOnlyTokens    = flag.Bool("downscope-read-only-volume-tokens", false, "Make the sidecar container authenticate read-only volumes with downscoped tokens that only grant roles/storage.objectViewer on the volume bucket. The sidecar container image must be from the same release as the driver or later.")
+	orphanedBucketGCProject    = flag.String("orphaned-bucket-gc-project", "", "The project where the controller service looks for the buckets it provisioned in this cluster for PersistentVolumes that no longer exist. The default is empty string, which means that orphaned buckets are not collected.")
+	orphanedBucketGCSA         = flag.String("orphaned-bucket-gc-service-account", "", "The Kubernetes ServiceAccount, in the form `namespace/name`, whose credentials are used to list and delete orphaned buckets. The default is empty string, which means that the default credentials of the controller service are used.")
+	orphanedBucketGCPolicy     = flag.String("orphaned-bucket-gc-policy", driver.OrphanedBucketPolicyReport, "What the controller service does with orphaned buckets, either `Report` to log them and export the number of orphaned buckets as a metric, or `Delete` to delete them together with their objects.")
+	sidecarMinVersion          = flag.String("sidecar-min-version", "", "The oldest sidecar mounter version, for example `v1.15.0`, that the node service accepts without a warning event on the Pod. Sidecar mounters that do not report their version are accepted. The default is empty string, which means that any sidecar mounter version is accepted.")
+	storageEndpoint            = flag.String("storage-endpoint", storage.EndpointDefault, "The Google APIs endpoint that the driver and gcsfuse reach Cloud Storage through, either `default` for storage.googleapis.com, `private` for private.googleapis.com in Private Google Access environments, or `restricted` for restricted.googleapis.com in VPC Service Controls perimeters.")
+	startupTaintKey            = flag.String("startup-taint-key", driver.DefaultStartupTaintKey, "The key of the taint that the node service removes from its node once kubelet has registered the driver. Add the taint to new nodes to keep Pods with gcsfuse volumes off the node until the volumes can be mounted. Set to empty string to disable the removal.")
+	firstMountRetryBudget      = flag.Duration("first-mount-retry-budget", 0, "How long the node service retries the bucket access check of a volume that is mounted to a Pod for the first time, so that new Workload Identity bindings have time to propagate, for example 30s. Permission errors are not retried. The default is 0, which disables the retries.")
+	firstMountRetryInterval    = flag.Duration("first-mount-retry-interval", 2*time.Second, "The interval between the retries of the bucket access check of a volume that is mounted to a Pod for the first time.")
+	remountRetryBudget         = flag.Duration("remount-retry-budget", 0, "How long the node service retries the bucket access check of a volume that was already mounted, for example after the driver restarted, for example 1m. Each failure is recorded as a warning event on the Pod, and permission errors are not retried. The default is 0, which disables the retries.")
+	remountRetryInitialBackoff = flag.Duration("remount-retry-initial-backoff", 5*time.Second, "The delay before the first retry of the bucket access check of a remounted volume, doubled before each following retry.")
+	unmountFlushThreshold      = flag.Duration("unmount-flush-warning-threshold", 15*time.Second, "How long gcsfuse can take to flush the pending writes of a volume to GCS after the sidecar container received SIGTERM, as measured by the sidecar container, before the node service records a warning event on the Pod when the volume is unmounted. Compare the flush durations with the terminationGracePeriodSeconds of the Pods. Set to 0 to disable the events.")
+	targetPathDataPolicy       = flag.String("target-path-data-policy", driver.TargetPathDataPolicyMountOver, "What the node service does when the target path of a volume that is not mounted yet contains data, for example left by a failed cleanup: `Fail` to fail the mount, `Clean` to remove the data before mounting, or `MountOver` to mount the volume over the data. All the policies record a warning event on the Pod.")
+	clusterName                = flag.String("cluster-name", "", "The name of the cluster that the driver reports in the User-Agent of its Cloud Storage requests, so that the Cloud Storage access logs attribute the requests to the cluster. The default is the cluster of the --identity-provider flag, or empty string if the flag is not set.")
+	storageCustomAuditInfo     = flag.String("storage-custom-audit-info", "", "A comma-separated list of at most 4 `key=value` pairs that the driver sends as x-goog-custom-audit-<key> headers with its Cloud Storage requests, which Cloud Storage records in the Data Access audit logs. Keys and values may contain lowercase letters, digits, underscores, and dashes. gcsfuse does not send the headers. The default is empty string, which means that no custom audit headers are sent.")
+	publishConfigHash          = flag.Bool("publish-config-hash", false, "Annotate the Pods with a hash of the resolved gcsfuse configuration of each of their volumes, so that fleet tooling can detect the nodes that mount the same PersistentVolume with a different configuration, for example during a driver upgrade.")
+	endSidecarCPUBoost         = flag.Bool("end-sidecar-cpu-boost", false, "Restore the CPU resources of the sidecar containers with in-place Pod resize once the CPU boost window set by the gke-gcsfuse/cpu-boost-duration annotation ends. Requires the node service to be granted the patch permission on pods and pods/resize.")
+	checkFUSECompatibility     = flag.Bool("check-fuse-compatibility", true, "Check at startup of the node service whether the node can mount gcsfuse volumes, with the /dev/fuse device, the fuse kernel file system, a supported kernel release and the CAP_SYS_ADMIN capability. On incompatible nodes, such as nodes without the fuse kernel module, the node service sets the GCSFuseUnsupported node condition to True, and checks the node again on each mount, which fails right away with the reason while the node is still incompatible.")
+	sandboxedRuntimeHandlers   = flag.String("sandboxed-runtime-handlers", "", "A comma-separated list of RuntimeClass handlers that run the Pods in a sandbox, such as `runsc` of gVisor in GKE Sandbox, where the sidecar container cannot serve the gcsfuse volumes. The node service fails the mounts of the Pods whose RuntimeClass has one of these handlers with the reason, instead of mounts that hang. The default is empty string, which mounts the volumes of all the Pods.")
+	auditLogSink               = flag.String("audit-log-sink", "", "Where the node service writes a structured record of every volume mount and unmount, with the Pod, the Kubernetes ServiceAccount, the bucket and the mount options, either `cloud-logging` for the standard output in the Cloud Logging structured format, or `file:<path>` to append JSON lines to a file on the node. The default is empty string, which means that no audit records are written.")
 
 	// These are set at compile time.
-	version = "unknown"
+	version        = "unknown"
+	gcsfuseVersion = "unknown"
 )
 
+// enabledFeatures returns the optional driver features that the flags enable, which the driver reports in the User-Agent
+// of its Cloud Storage requests.
+func enabledFeatures() []string {
+	features := []string{}
+	for feature, enabled := range map[string]bool{
+		"downscoped-read-only-tokens":          *downscopeReadOnlyTokens,
+		"orphaned-bucket-gc":                   *orphanedBucketGCProject != "",
+		"node-ops-per-sec-budget":              *nodeOpsPerSecBudget > 0,
+		"node-memory-budget":                   *nodeMemoryBudgetMB > 0,
+		"experimental-flags":                   *experimentalFlagsAllowlist != "",
+		"audit-log":                            *auditLogSink != "",
+		"config-hash":                          *publishConfigHash,
+		"storage-endpoint-" + *storageEndpoint: *storageEndpoint != storage.EndpointDefault,
+	} {
+		if enabled {
+			features = append(features, feature)
+		}
+	}
+	slices.Sort(features)
+
+	return features
+}
+
 func main() {
 	klog.InitFlags(nil)
 	flag.Parse()
 
+	if err := util.InitLogging(*loggingFormat); err != nil {
+		klog.Fatalf("failed to initialize logging: %v", err)
+	}
+
 	if *enableProfiling {
 		mux := http.NewServeMux()
 		mux.HandleFunc("/debug/pprof/", pprof.Index)
@@ -88,13 +146,33 @@ func main() {
 	}
 
 	tm := auth.NewTokenManager(meta, clientset)
-	ssm, err := storage.NewGCSServiceManager()
+	storageEndpointURL, err := storage.EndpointURL(*storageEndpoint)
+	if err != nil {
+		klog.Fatalf("Invalid flag --storage-endpoint: %v", err)
+	}
+	customAuditInfo, err := util.ConvertLabelsStringToMap(*storageCustomAuditInfo)
+	if err != nil {
+		klog.Fatalf("Invalid flag --storage-custom-audit-info: %v", err)
+	}
+	cluster := *clusterName
+	if i := strings.LastIndex(*identityProvider, "/clusters/"); cluster == "" && i >= 0 {
+		cluster = (*identityProvider)[i+len("/clusters/"):]
+	}
+	ssm, err := storage.NewGCSServiceManager(storageEndpointURL, storage.RequestLabels{
+		UserAgent:       storage.UserAgent(version, cluster, enabledFeatures()),
+		CustomAuditInfo: customAuditInfo,
+	})
 	if err != nil {
 		klog.Fatalf("Failed to set up storage service manager: %v", err)
 	}
 
 	var mounter mount.Interface
 	var mm metrics.Manager
+	var auditSink driver.AuditSink
+	if *runController {
+		clientset.ConfigurePVLister()
+	}
+
 	if *runNode {
 		if *nodeID == "" {
 			klog.Fatalf("NodeID cannot be empty for node service")
@@ -108,23 +186,67 @@ func main() {
 			klog.Fatalf("Failed to prepare CSI mounter: %v", err)
 		}
 
-		if *metricsEndpoint != "" {
-			mm = metrics.NewMetricsManager(*metricsEndpoint, *fuseSocketDir, clientset)
-			mm.InitializeHTTPHandler()
+		if *auditLogSink != "" {
+			auditSink, err = driver.NewAuditSink(*auditLogSink)
+			if err != nil {
+				klog.Fatalf("Failed to set up the audit log sink: %v", err)
+			}
+		}
+	}
+
+	if *metricsEndpoint != "" {
+		mm = metrics.NewMetricsManager(*metricsEndpoint, *fuseSocketDir, clientset)
+		mm.InitializeHTTPHandler()
+		mm.RegisterBuildInfo(version, gcsfuseVersion, *sidecarImage)
+		if *runController {
+			mm.RegisterProvisioningMetrics()
+		}
+		if *runNode {
+			mm.RegisterUnmountMetrics()
+			mm.RegisterNodeMemoryMetrics()
+			mm.RegisterNodeFUSEMetrics()
 		}
 	}
 
 	config := &driver.GCSDriverConfig{
-		Name:                  driver.DefaultName,
-		Version:               version,
-		NodeID:                *nodeID,
-		RunController:         *runController,
-		RunNode:               *runNode,
-		StorageServiceManager: ssm,
-		TokenManager:          tm,
-		Mounter:               mounter,
-		K8sClients:            clientset,
-		MetricsManager:        mm,
+		Name:                           *driverName,
+		Version:                        version,
+		GcsfuseVersion:                 gcsfuseVersion,
+		SidecarImage:                   *sidecarImage,
+		GcsfuseNodeOpsPerSecBudget:     *nodeOpsPerSecBudget,
+		GcsfuseNodeMemoryBudgetBytes:   *nodeMemoryBudgetMB * 1024 * 1024,
+		VolumeStatsMaxObjects:          *volumeStatsMaxObjects,
+		StartupTaintKey:                *startupTaintKey,
+		DownscopeReadOnlyVolumeTokens:  *downscopeReadOnlyTokens,
+		OrphanedBucketGCProject:        *orphanedBucketGCProject,
+		OrphanedBucketGCServiceAccount: *orphanedBucketGCSA,
+		OrphanedBucketGCPolicy:         *orphanedBucketGCPolicy,
+		SidecarMinVersion:              *sidecarMinVersion,
+		StorageEndpointURL:             storageEndpointURL,
+		FirstMountRetryBudget:          *firstMountRetryBudget,
+		FirstMountRetryInterval:        *firstMountRetryInterval,
+		RemountRetryBudget:             *remountRetryBudget,
+		RemountRetryInitialBackoff:     *remountRetryInitialBackoff,
+		NodeID:                         *nodeID,
+		RunController:                  *runController,
+		RunNode:                        *runNode,
+		StorageServiceManager:          ssm,
+		TokenManager:                   tm,
+		Mounter:                        mounter,
+		K8sClients:                     clientset,
+		MetricsManager:                 mm,
+		UnmountFlushWarningThreshold:   *unmountFlushThreshold,
+		AuditSink:                      auditSink,
+		TargetPathDataPolicy:           *targetPathDataPolicy,
+		PublishConfigHash:              *publishConfigHash,
+		CheckFUSECompatibility:         *checkFUSECompatibility,
+		EndSidecarCPUBoost:             *endSidecarCPUBoost,
+	}
+	if *sandboxedRuntimeHandlers != "" {
+		config.SandboxedRuntimeHandlers = strings.Split(*sandboxedRuntimeHandlers, ",")
+	}
+	if *experimentalFlagsAllowlist != "" {
+		config.GcsfuseExperimentalFlagsAllowlist = strings.Split(*experimentalFlagsAllowlist, ",")
 	}
 
 	gcfsDriver, err := driver.NewGCSDriver(config)
@@ -132,7 +254,7 @@ func main() {
 		klog.Fatalf("Failed to initialize Google Cloud Storage FUSE CSI Driver: %v", err)
 	}
 
-	klog.Infof("Running Google Cloud Storage FUSE CSI driver version %v", version)
+	klog.Infof("Running Google Cloud Storage FUSE CSI driver version %v, gcsfuse version %v", version, gcsfuseVersion)
 	gcfsDriver.Run(*endpoint)
 
 	os.Exit(0)
@@ -0,0 +1,117 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/debugbundle"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const collectDebugUsage = `Usage: gcsfusecsi collect-debug [flags] NAMESPACE/POD
+
+Gathers what is needed to troubleshoot the gcsfuse volumes of a Pod into a gzipped tarball: the Pod, its PersistentVolumeClaims
+and PersistentVolumes, their events, the logs of the sidecar containers and of the driver node service on the Pod node,
+and the gcsfuse mount table and volume socket states of the node. What cannot be collected is listed in errors.txt.
+
+The node state is read by a short-lived privileged Pod that runs the driver image on the node of the Pod, in the driver namespace.
+Set --node-state=false if you cannot create privileged Pods there.
+
+Flags:
+`
+
+func runCollectDebug(args []string) {
+	flags := flag.NewFlagSet("collect-debug", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), collectDebugUsage)
+		flags.PrintDefaults()
+	}
+	kubeconfig := flags.String("kubeconfig", "", "The kubeconfig file. The default is the kubeconfig that kubectl uses.")
+	driverNamespace := flags.String("driver-namespace", "gcs-fuse-csi-driver", "The namespace of the driver node service Pods. It is kube-system for the driver that GKE manages.")
+	since := flags.Duration("since", time.Hour, "How far back the logs are collected. Set to 0 to collect all the logs.")
+	output := flags.String("output", "", "The path of the tarball. The default is gcsfuse-debug-NAMESPACE-POD-TIMESTAMP.tar.gz in the current directory.")
+	nodeState := flags.Bool("node-state", true, "Collect the node state with a short-lived Pod on the node of the Pod.")
+	nodeStateTimeout := flags.Duration("node-state-timeout", 2*time.Minute, "How long to wait for the node state collector Pod to complete.")
+	nodeLocal := flags.Bool("node-local", false, "Print the node state of the Pod with the UID set by --pod-uid, instead of collecting a tarball. This is what the node state collector Pod runs.")
+	podUID := flags.String("pod-uid", "", "The UID of the Pod whose node state is printed with --node-local.")
+	mountInfo := flags.String("mountinfo", "/proc/1/mountinfo", "The mount table of the node, read with --node-local.")
+	_ = flags.Parse(args)
+
+	if *nodeLocal {
+		if err := debugbundle.WriteNodeState(os.Stdout, *mountInfo, debugbundle.KubeletPodsDir, *podUID); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	namespace, name, ok := strings.Cut(flags.Arg(0), "/")
+	if flags.NArg() != 1 || !ok || namespace == "" || name == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = *kubeconfig
+	rc, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read kubeconfig: %v\n", err)
+		os.Exit(2)
+	}
+	client, err := kubernetes.NewForConfig(rc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to configure k8s client: %v\n", err)
+		os.Exit(2)
+	}
+
+	path := *output
+	if path == "" {
+		path = fmt.Sprintf("gcsfuse-debug-%s-%s-%s.tar.gz", namespace, name, time.Now().UTC().Format("20060102T150405Z"))
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	c := &debugbundle.Collector{
+		Client:           client,
+		DriverNamespace:  *driverNamespace,
+		Since:            *since,
+		NodeState:        *nodeState,
+		NodeStateTimeout: *nodeStateTimeout,
+	}
+	err = c.Collect(context.Background(), f, namespace, name)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		_ = os.Remove(path)
+		os.Exit(1)
+	}
+	fmt.Printf("wrote %s\n", path)
+}
@@ -0,0 +1,322 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// The gcsfusecsi command is a development tool for the Cloud Storage FUSE CSI driver.
+// The lint subcommand reports how the driver and the webhook interpret the PersistentVolumes and Pods in YAML files.
+// The collect-debug subcommand gathers the logs, events and node state of a Pod with gcsfuse volumes into a tarball.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	driver "github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/csi_driver"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+	wh "github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/webhook"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const usage = `Usage: gcsfusecsi COMMAND [flags]
+
+Commands:
+  lint           Report how the driver interprets the PersistentVolumes and Pods in YAML files.
+  collect-debug  Gather the logs, events and node state of a Pod with gcsfuse volumes into a tarball.
+`
+
+const lintUsage = `Usage: gcsfusecsi lint [flags] FILE...
+
+Reports how the Cloud Storage FUSE CSI driver interprets the PersistentVolumes, Pods and Pod templates in the YAML files,
+including the effective gcsfuse mount options, and the errors and warnings for their mountOptions, volume attributes and annotations.
+Use - to read from the standard input. The command exits with status 1 if any object would be rejected.
+
+Flags:
+`
+
+// accessModes maps the PersistentVolume access modes to the CSI access modes that kubelet requests.
+var accessModes = map[corev1.PersistentVolumeAccessMode]csi.VolumeCapability_AccessMode_Mode{
+	corev1.ReadWriteOnce:    csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+	corev1.ReadOnlyMany:     csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+	corev1.ReadWriteMany:    csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+	corev1.ReadWriteOncePod: csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "lint":
+		runLint(os.Args[2:])
+	case "collect-debug":
+		runCollectDebug(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func runLint(args []string) {
+	// The webhook logs the injection steps, which are not part of the report.
+	klog.LogToStderr(false)
+	klog.SetOutput(io.Discard)
+
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), lintUsage)
+		flags.PrintDefaults()
+	}
+	driverName := flags.String("driver-name", driver.DefaultName, "The name of the CSI driver whose volumes are linted.")
+	experimentalFlagsAllowlist := flags.String("gcsfuse-experimental-flags-allowlist", "", "The value of the --gcsfuse-experimental-flags-allowlist flag of the driver node service.")
+	namespace := flags.String("namespace", "default", "The namespace of the objects that do not set one.")
+	_ = flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	objects := []runtime.Object{}
+	for _, path := range flags.Args() {
+		o, err := readObjects(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		objects = append(objects, o...)
+	}
+
+	allowlist := []string{}
+	if *experimentalFlagsAllowlist != "" {
+		allowlist = strings.Split(*experimentalFlagsAllowlist, ",")
+	}
+	l := &linter{
+		out:                        os.Stdout,
+		driverName:                 *driverName,
+		namespace:                  *namespace,
+		experimentalFlagsAllowlist: allowlist,
+	}
+	if !l.lint(objects) {
+		os.Exit(1)
+	}
+}
+
+// readObjects decodes the Kubernetes objects in the YAML or JSON documents of the file.
+func readObjects(path string) ([]runtime.Object, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	objects := []runtime.Object{}
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(r))
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %q: %w", path, err)
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+
+		o, _, err := scheme.Codecs.UniversalDeserializer().Decode(doc, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %q: %w", path, err)
+		}
+		objects = append(objects, o)
+	}
+}
+
+type linter struct {
+	out                        io.Writer
+	driverName                 string
+	namespace                  string
+	experimentalFlagsAllowlist []string
+}
+
+// lint prints the report of the objects, and returns false if any of them would be rejected.
+func (l *linter) lint(objects []runtime.Object) bool {
+	pvIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	pvcIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, o := range objects {
+		switch o := o.(type) {
+		case *corev1.PersistentVolume:
+			_ = pvIndexer.Add(o)
+		case *corev1.PersistentVolumeClaim:
+			if o.Namespace == "" {
+				o.Namespace = l.namespace
+			}
+			_ = pvcIndexer.Add(o)
+		}
+	}
+
+	si := &wh.SidecarInjector{
+		Config:                 wh.LoadConfig("", string(corev1.PullIfNotPresent), "250m", "250m", "256Mi", "256Mi", "5Gi", "5Gi"),
+		MetadataPrefetchConfig: wh.LoadConfig("", string(corev1.PullIfNotPresent), "10m", "50m", "10Mi", "10Mi", "10Mi", "10Mi"),
+		PvLister:               listersv1.NewPersistentVolumeLister(pvIndexer),
+		PvcLister:              listersv1.NewPersistentVolumeClaimLister(pvcIndexer),
+		DriverName:             l.driverName,
+	}
+
+	ok := true
+	for _, o := range objects {
+		switch o := o.(type) {
+		case *corev1.PersistentVolume:
+			if o.Spec.CSI == nil || o.Spec.CSI.Driver != l.driverName {
+				continue
+			}
+			ok = l.lintVolume(fmt.Sprintf("PersistentVolume %s", o.Name), persistentVolumeRequest(o)) && ok
+		default:
+			kind, meta, template := podTemplate(o)
+			if template == nil {
+				continue
+			}
+			pod := &corev1.Pod{ObjectMeta: template.ObjectMeta, Spec: template.Spec}
+			pod.Name, pod.Namespace = meta.Name, meta.Namespace
+			if pod.Namespace == "" {
+				pod.Namespace = l.namespace
+			}
+			ok = l.lintPod(fmt.Sprintf("%s %s/%s", kind, pod.Namespace, pod.Name), si, pod) && ok
+		}
+	}
+
+	return ok
+}
+
+func (l *linter) lintPod(title string, si *wh.SidecarInjector, pod *corev1.Pod) bool {
+	errs, warnings := si.LintPod(pod)
+	l.report(title, nil, errs, warnings)
+	ok := len(errs) == 0
+
+	for _, v := range pod.Spec.Volumes {
+		if v.CSI == nil || v.CSI.Driver != l.driverName {
+			continue
+		}
+		ok = l.lintVolume(fmt.Sprintf("%s, volume %s", title, v.Name), ephemeralVolumeRequest(v.CSI)) && ok
+	}
+
+	return ok
+}
+
+func (l *linter) lintVolume(title string, req *csi.NodePublishVolumeRequest) bool {
+	result := driver.LintVolume(req, l.experimentalFlagsAllowlist)
+	details := []string{}
+	if len(result.Errors) == 0 {
+		details = append(details, "bucket: "+result.BucketName, "mount options: "+strings.Join(result.MountOptions, ","))
+	}
+	l.report(title, details, result.Errors, result.Warnings)
+
+	return len(result.Errors) == 0
+}
+
+func (l *linter) report(title string, details, errs, warnings []string) {
+	fmt.Fprintf(l.out, "%s:\n", title)
+	for _, d := range details {
+		fmt.Fprintf(l.out, "  %s\n", d)
+	}
+	for _, e := range errs {
+		fmt.Fprintf(l.out, "  error: %s\n", e)
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(l.out, "  warning: %s\n", w)
+	}
+	if len(details)+len(errs)+len(warnings) == 0 {
+		fmt.Fprintln(l.out, "  ok")
+	}
+}
+
+// persistentVolumeRequest returns the NodePublishVolume request that kubelet sends for a Pod volume that uses the PersistentVolume.
+func persistentVolumeRequest(pv *corev1.PersistentVolume) *csi.NodePublishVolumeRequest {
+	accessMode := csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER
+	if len(pv.Spec.AccessModes) > 0 {
+		accessMode = accessModes[pv.Spec.AccessModes[0]]
+	}
+
+	return &csi.NodePublishVolumeRequest{
+		VolumeId: pv.Spec.CSI.VolumeHandle,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{MountFlags: pv.Spec.MountOptions}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: accessMode},
+		},
+		VolumeContext: pv.Spec.CSI.VolumeAttributes,
+		Readonly:      pv.Spec.CSI.ReadOnly,
+	}
+}
+
+// ephemeralVolumeRequest returns the NodePublishVolume request that kubelet sends for a CSI ephemeral volume.
+func ephemeralVolumeRequest(v *corev1.CSIVolumeSource) *csi.NodePublishVolumeRequest {
+	vc := map[string]string{driver.VolumeContextKeyEphemeral: util.TrueStr}
+	for k, v := range v.VolumeAttributes {
+		vc[k] = v
+	}
+
+	return &csi.NodePublishVolumeRequest{
+		VolumeId: "csi-ephemeral",
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+		VolumeContext: vc,
+		Readonly:      v.ReadOnly != nil && *v.ReadOnly,
+	}
+}
+
+// podTemplate returns the kind, the object metadata and the Pod template of the objects that create Pods.
+func podTemplate(o runtime.Object) (string, metav1.ObjectMeta, *corev1.PodTemplateSpec) {
+	switch o := o.(type) {
+	case *corev1.Pod:
+		return "Pod", o.ObjectMeta, &corev1.PodTemplateSpec{ObjectMeta: o.ObjectMeta, Spec: o.Spec}
+	case *appsv1.Deployment:
+		return "Deployment", o.ObjectMeta, &o.Spec.Template
+	case *appsv1.StatefulSet:
+		return "StatefulSet", o.ObjectMeta, &o.Spec.Template
+	case *appsv1.DaemonSet:
+		return "DaemonSet", o.ObjectMeta, &o.Spec.Template
+	case *appsv1.ReplicaSet:
+		return "ReplicaSet", o.ObjectMeta, &o.Spec.Template
+	case *batchv1.Job:
+		return "Job", o.ObjectMeta, &o.Spec.Template
+	case *batchv1.CronJob:
+		return "CronJob", o.ObjectMeta, &o.Spec.JobTemplate.Spec.Template
+	default:
+		return "", metav1.ObjectMeta{}, nil
+	}
+}
@@ -19,27 +19,66 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
-	"os/exec"
 	"os/signal"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 )
 
 const (
 	mountPathsLocation = "/volumes/"
+	metricsPath        = "/metrics"
+	readinessPath      = "/ready"
+	// refreshJitterFactor spreads the refreshes of the Pods that start together, so that they do not list the buckets at the same time.
+	refreshJitterFactor = 0.1
+	// cacheEntryBytes is about the memory that gcsfuse uses for the stat cache entry of each listed file or directory.
+	cacheEntryBytes = 1640
+)
+
+var (
+	loggingFormat    = flag.String("logging-format", util.LoggingFormatText, "The log output format, either `text` or `json`. The json format writes one JSON object per line, which Cloud Logging parses into structured fields.")
+	maxOpsPerSecond  = flag.Int("max-ops-per-second", 0, "The maximum number of directory listings and file stats per second of the prefetch, shared by all the volumes, so that the cache warmup does not add latency to the workload reads. The default is 0, which does not throttle the prefetch.")
+	concurrency      = flag.Int("concurrency", 16, "The maximum number of directory listings and file stats that the prefetch sends to gcsfuse at the same time, shared by all the volumes, so that the volumes and their top-level directories are listed in parallel. Lower it, together with --max-ops-per-second, so that the prefetch leaves the gcsfuse threads to the workload I/O.")
+	prefetchWorkers  = flag.Int("prefetch-workers", 0, "Deprecated: use --concurrency instead. A positive value overrides --concurrency.")
+	includePaths     = flag.String("include-paths", "", "A comma-separated list of path patterns, relative to the root of each volume, such as `data/train,models/*`. Only the matching directories, their subdirectories, and the directories on the way to them are listed. The patterns use the syntax of Go path.Match, where each pattern element matches one path element. The default is empty string, which lists all the directories.")
+	excludePaths     = flag.String("exclude-paths", "", "A comma-separated list of path patterns, relative to the root of each volume, such as `logs,*/tmp`. The matching directories and their subdirectories are not listed, even if they match --include-paths. The default is empty string, which does not exclude any directory.")
+	selectedVolumes  = flag.String("volumes", "", "A comma-separated list of the volume names, which are the directories under /volumes/, whose metadata is prefetched, such as `vol-a,vol-c`. The webhook sets it from the gke-gcsfuse/metadata-prefetch-volumes Pod annotation. The default is empty string, which prefetches all the volumes.")
+	maxDepth         = flag.Int("max-depth", 0, "The number of directory levels below the root of each volume whose metadata is prefetched, like find -maxdepth. With 1, only the root directory is listed. Use it on very deep hierarchies, whose full listing takes too long or exceeds the metadata cache capacity. The default is 0, which lists all the levels.")
+	refreshInterval  = flag.Duration("refresh-interval", 0, "How long the prefetch waits after a walk of the volumes before it walks them again, with up to 10% jitter, so that the metadata caches stay warm after their TTL expires. The default is 0, which walks the volumes once.")
+	statFiles        = flag.Bool("stat-files", false, "Stat every file of the listed directories, so that gcsfuse also fills its stat cache for the files, and the first open of each file by the workload does not wait for Cloud Storage. It sends one request per file to gcsfuse, so the prefetch takes longer on large volumes. Each stat counts as one operation of --max-ops-per-second, and towards --concurrency.")
+	metricsAddress   = flag.String("metrics-address", "", "The TCP address, such as `:9920`, where the prefetch serves the Prometheus metrics of its progress on the /metrics path, with the entries walked, the directories remaining, the walk duration, and the listing errors of each volume. The default is empty string, which does not serve the metrics.")
+	readinessAddress = flag.String("readiness-address", "", "The TCP address, such as `:9921`, where the prefetch serves the /ready path, which responds with status 200 once the first walk of the volumes completed, and 503 before, so that the workload or an init gate can wait for the metadata caches to be warm before it starts. It can be the same address as --metrics-address. The default is empty string, which does not serve the readiness endpoint.")
+	watchInterval    = flag.Duration("watch-interval", 10*time.Second, "How often the prefetch checks the volumes for mounts that were remounted after the gcsfuse sidecar container restarted, or that could not be walked yet, and prefetches their metadata. Set to 0 to only walk the volumes at startup.")
+	maxEntries       = flag.Int("max-entries", 0, "The maximum number of files and directories that each walk of the volumes lists, shared by all the volumes, so that the prefetch does not fill the gcsfuse metadata caches beyond the memory of the sidecar container. Once the listings returned this many entries, the directories that are not listed yet are skipped and logged. The default is 0, which does not limit the entries.")
+	memoryBudgetMB   = flag.Int("memory-budget-mb", 0, "The memory in MiB that the gcsfuse metadata caches may use for the entries listed by each walk of the volumes, converted to a maximum number of entries at about 1640 bytes per entry. With --max-entries, the lower maximum applies. The default is 0, which does not limit the memory.")
+	latencyThreshold = flag.Duration("latency-threshold", 200*time.Millisecond, "With --max-ops-per-second and without --io-token-port, a directory listing slower than the threshold halves the prefetch rate, because gcsfuse is busy serving the workload. Each faster listing grows the rate back towards the maximum.")
+	ioTokenPort      = flag.Int("io-token-port", 0, "With --max-ops-per-second, the loopback port of the io token API of the sidecar mounter, set by the webhook. Each directory listing then takes a token from the bucket that the sidecar mounter shares between the prefetch and the file system operations of the workload, so that the prefetch only uses the capacity that the workload leaves. The default is 0, which adapts the rate to the latency of the listings instead.")
 )
 
 func main() {
 	klog.InitFlags(nil)
 	flag.Parse()
 
-	// Create cancellable context to pass into exec.
+	if err := util.InitLogging(*loggingFormat); err != nil {
+		klog.Fatalf("failed to initialize logging: %v", err)
+	}
+
+	// Create cancellable context to stop the prefetch.
 	ctx, cancel := context.WithCancel(context.Background())
 
-	// Handle SIGTERM signal.
+	// Handle SIGTERM signal. The main goroutine exits once the in-flight walk stopped.
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGTERM)
 
@@ -47,39 +86,140 @@ func main() {
 		<-sigs
 		klog.Info("Caught SIGTERM signal: Terminating...")
 		cancel()
-
-		os.Exit(0) // Exit gracefully
 	}()
 
-	// Start the "ls" command in the background.
-	// All our volumes are mounted under the /volumes/ directory.
-	cmd := exec.CommandContext(ctx, "ls", "-R", mountPathsLocation)
-	cmd.Stdout = nil // Connects file descriptor to the null device (os.DevNull).
-
-	// TODO(hime): We should research stratergies to parallelize ls execution and speed up cache population.
-	err := cmd.Start()
-	if err == nil {
-		mountPaths, err := getDirectoryNames(mountPathsLocation)
-		if err == nil {
-			klog.Infof("Running ls on mountPath(s): %s", strings.Join(mountPaths, ", "))
-		} else {
-			klog.Warningf("failed to get mountPaths: %v", err)
+	filter, err := util.NewPrefetchFilter(splitPatterns(*includePaths), splitPatterns(*excludePaths), *maxDepth)
+	if err != nil {
+		klog.Fatalf("invalid prefetch filter: %v", err)
+	}
+
+	workers := *concurrency
+	if *prefetchWorkers > 0 {
+		klog.Warning("The --prefetch-workers flag is deprecated, use --concurrency instead")
+		workers = *prefetchWorkers
+	}
+	if workers < 1 {
+		klog.Fatalf("--concurrency must be a positive integer, got %d", workers)
+	}
+
+	if *maxEntries < 0 || *memoryBudgetMB < 0 {
+		klog.Fatalf("--max-entries and --memory-budget-mb must not be negative, got %d and %d", *maxEntries, *memoryBudgetMB)
+	}
+	entries := *maxEntries
+	if budget := *memoryBudgetMB * 1024 * 1024 / cacheEntryBytes; budget > 0 && (entries == 0 || budget < entries) {
+		entries = budget
+	}
+
+	// All our volumes are mounted under the /volumes/ directory. The throttle is shared by the workers of all the volumes,
+	// so that the total rate of the prefetch stays below the maximum, and with the workload through the sidecar mounter.
+	volumes := newPrefetchVolumes(mountPathsLocation, splitPatterns(*selectedVolumes), filter, util.PrefetchOptions{
+		Throttle:   util.NewPrefetchThrottle(*maxOpsPerSecond, *latencyThreshold, *ioTokenPort),
+		Workers:    workers,
+		StatFiles:  *statFiles,
+		MaxEntries: entries,
+	})
+	volumes.discover()
+	prefetchMountPaths := volumes.names()
+	for _, volume := range volumes.selected {
+		if !slices.Contains(prefetchMountPaths, volume) && !volumes.skipped[volume] {
+			klog.Warningf("volume %q of --volumes is not mounted under %s", volume, mountPathsLocation)
+		}
+	}
+
+	// The metrics and the readiness endpoint share the server of the address they are both served on.
+	muxes := map[string]*http.ServeMux{}
+	if *metricsAddress != "" {
+		registry := prometheus.NewRegistry()
+		if volumes.opts.Metrics, err = util.NewPrefetchMetrics(registry, prefetchMountPaths); err != nil {
+			klog.Fatalf("failed to create the metrics: %v", err)
+		}
+		serveMux(muxes, *metricsAddress).Handle(metricsPath, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	}
+
+	var ready atomic.Bool
+	if *readinessAddress != "" {
+		serveMux(muxes, *readinessAddress).HandleFunc(readinessPath, func(w http.ResponseWriter, _ *http.Request) {
+			if !ready.Load() {
+				http.Error(w, "the metadata prefetch is in progress", http.StatusServiceUnavailable)
+
+				return
+			}
+			fmt.Fprintln(w, "the metadata prefetch is complete")
+		})
+	}
+	for address, mux := range muxes {
+		serve(address, mux)
+	}
+
+	// The watch loop starts after the first walk, which walks all the volumes found at startup.
+	startWatch := sync.OnceFunc(func() {
+		if *watchInterval > 0 {
+			go wait.UntilWithContext(ctx, func(ctx context.Context) { volumes.walk(ctx, false) }, *watchInterval)
 		}
+	})
 
-		err = cmd.Wait()
-		if err != nil {
-			klog.Errorf("Error while executing ls command: %v", err)
-		} else {
-			klog.Info("Metadata prefetch complete")
+	prefetch := func(ctx context.Context) {
+		volumes.walk(ctx, true)
+		if ctx.Err() == nil {
+			ready.Store(true)
 		}
+		startWatch()
+	}
+
+	if *refreshInterval > 0 {
+		// The interval starts when a walk completes, so that slow walks of large volumes do not overlap.
+		wait.JitterUntilWithContext(ctx, prefetch, *refreshInterval, refreshJitterFactor, true)
 	} else {
-		klog.Errorf("Error starting ls command: %v.", err)
+		prefetch(ctx)
 	}
 
 	klog.Info("Going to sleep...")
 
-	// Keep the process running.
-	select {}
+	// Keep the process running until SIGTERM, then wait for the workers of the in-flight walk to stop before exiting,
+	// so that no listing of the volumes outlives the process.
+	<-ctx.Done()
+	volumes.shutdown()
+	klog.Info("Metadata prefetch terminated")
+	klog.Flush()
+}
+
+// splitPatterns splits a comma-separated list of path patterns, ignoring the empty patterns.
+func splitPatterns(s string) []string {
+	patterns := []string{}
+	for _, pattern := range strings.Split(s, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	return patterns
+}
+
+// serveMux returns the mux of the server at the address, and creates it on the first call.
+func serveMux(muxes map[string]*http.ServeMux, address string) *http.ServeMux {
+	if _, ok := muxes[address]; !ok {
+		muxes[address] = http.NewServeMux()
+	}
+
+	return muxes[address]
+}
+
+// serve serves the mux at the address in the background.
+func serve(address string, mux *http.ServeMux) {
+	server := &http.Server{
+		Addr:           address,
+		Handler:        mux,
+		ReadTimeout:    10 * time.Second,
+		WriteTimeout:   10 * time.Second,
+		MaxHeaderBytes: 1 << 20,
+	}
+
+	go func() {
+		klog.Infof("HTTP server listening at %q", address)
+		if err := server.ListenAndServe(); err != nil {
+			klog.Errorf("failed to serve at %q: %v", address, err)
+		}
+	}()
 }
 
 // getDirectoryNames returns a list of strings representing the names of
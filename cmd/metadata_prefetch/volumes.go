@@ -0,0 +1,206 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+	"k8s.io/klog/v2"
+)
+
+// prefetchVolume is a volume mounted under the /volumes/ directory whose metadata is prefetched.
+type prefetchVolume struct {
+	name   string
+	root   string
+	filter *util.PrefetchFilter
+}
+
+// prefetchVolumes tracks the volumes mounted under the /volumes/ directory, and the mounts that their metadata was prefetched from,
+// so that the volumes remounted after the gcsfuse sidecar container restarted are walked again. The volume mounts of the
+// container are fixed when it is created, so the volumes are discovered once at startup.
+type prefetchVolumes struct {
+	location string
+	// selected are the volume names of the volumes flag, or empty to prefetch all the volumes.
+	selected []string
+	filter   *util.PrefetchFilter
+	// opts hold the throttle, the metrics of the volumes, the workers and the stat files mode shared by the walks.
+	opts util.PrefetchOptions
+
+	// mu serializes the walks, so that the walks of the watch loop and of the refresh interval do not overlap.
+	mu      sync.Mutex
+	volumes []prefetchVolume
+	skipped map[string]bool
+	// devices are the devices of the mounts that the volumes were last walked on, keyed by the volume name.
+	// A remount gets a new device, and a volume without a device is walked once its mount can be stat'ed.
+	devices map[string]uint64
+	// totals count the work of all the walks of each volume, keyed by the volume name, and are logged on shutdown.
+	totals map[string]util.PrefetchStats
+	// stopped is set on shutdown, so that no walk starts after the in-flight walk stopped.
+	stopped bool
+}
+
+func newPrefetchVolumes(location string, selected []string, filter *util.PrefetchFilter, opts util.PrefetchOptions) *prefetchVolumes {
+	return &prefetchVolumes{
+		location: location,
+		selected: selected,
+		filter:   filter,
+		opts:     opts,
+		skipped:  map[string]bool{},
+		devices:  map[string]uint64{},
+		totals:   map[string]util.PrefetchStats{},
+	}
+}
+
+// discover adds the volumes mounted under the location. The caller must hold mu, or be the only goroutine.
+func (v *prefetchVolumes) discover() {
+	names, err := getDirectoryNames(v.location)
+	if err != nil {
+		klog.Errorf("failed to get mountPaths: %v", err)
+
+		return
+	}
+
+	for _, name := range names {
+		if v.skipped[name] || slices.ContainsFunc(v.volumes, func(p prefetchVolume) bool { return p.name == name }) {
+			continue
+		}
+		if len(v.selected) > 0 && !slices.Contains(v.selected, name) {
+			klog.Infof("Skipping mountPath %s, the volume is not selected by --volumes", name)
+			v.skipped[name] = true
+
+			continue
+		}
+		v.volumes = append(v.volumes, prefetchVolume{name: name, root: filepath.Join(v.location, name), filter: v.filter})
+	}
+}
+
+// names returns the names of the discovered volumes.
+func (v *prefetchVolumes) names() []string {
+	names := make([]string, 0, len(v.volumes))
+	for _, p := range v.volumes {
+		names = append(names, p.name)
+	}
+
+	return names
+}
+
+// walk prefetches the metadata of the volumes. With all set, all the volumes that can be stat'ed are walked, otherwise only
+// the volumes that were remounted since their last walk.
+func (v *prefetchVolumes) walk(ctx context.Context, all bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.stopped {
+		return
+	}
+
+	walked, devices := []prefetchVolume{}, []uint64{}
+	for _, p := range v.volumes {
+		device, err := mountDevice(p.root)
+		if err != nil {
+			// The mount is not served, for example while the gcsfuse sidecar container restarts, so it is walked once it is served again.
+			if _, ok := v.devices[p.name]; ok {
+				klog.Warningf("mountPath %s is unavailable, prefetching its metadata again once it is remounted: %v", p.name, err)
+				delete(v.devices, p.name)
+			}
+
+			continue
+		}
+		if last, ok := v.devices[p.name]; ok && last == device && !all {
+			continue
+		}
+		walked = append(walked, p)
+		devices = append(devices, device)
+	}
+	if len(walked) == 0 {
+		return
+	}
+
+	roots, filters, names := []string{}, []*util.PrefetchFilter{}, []string{}
+	for _, p := range walked {
+		roots = append(roots, p.root)
+		filters = append(filters, p.filter)
+		names = append(names, p.name)
+	}
+	opts := v.opts
+	opts.Filters = filters
+	opts.Metrics = v.opts.Metrics.ForVolumes(names)
+
+	klog.Infof("Prefetching metadata of mountPaths %v with concurrency %d", names, opts.Workers)
+	start := time.Now()
+	stats, err := util.PrefetchMetadata(ctx, roots, opts)
+	if err != nil {
+		klog.Errorf("Error while prefetching metadata: %v", err)
+	}
+	for i, p := range walked {
+		klog.Infof("Listed %d directories with %d entries and stat'ed %d files of mountPath %s, %d listings or stats failed, %d directories skipped", stats[i].Directories, stats[i].Entries, stats[i].Files, p.name, stats[i].Errors, stats[i].Skipped)
+		total := v.totals[p.name]
+		total.Directories += stats[i].Directories
+		total.Entries += stats[i].Entries
+		total.Files += stats[i].Files
+		total.Errors += stats[i].Errors
+		total.Skipped += stats[i].Skipped
+		v.totals[p.name] = total
+		// A volume whose root could not be listed is walked again by the next check.
+		if ctx.Err() != nil || (stats[i].Directories == 0 && stats[i].Errors > 0) {
+			delete(v.devices, p.name)
+
+			continue
+		}
+		v.devices[p.name] = devices[i]
+	}
+	if ctx.Err() != nil {
+		klog.Infof("Metadata prefetch canceled after %v", time.Since(start))
+
+		return
+	}
+	klog.Infof("Metadata prefetch complete in %v", time.Since(start))
+}
+
+// shutdown waits for the in-flight walk, whose context the caller canceled, to stop its workers, prevents further walks,
+// and logs the work of all the walks of each volume.
+func (v *prefetchVolumes) shutdown() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.stopped = true
+
+	for _, name := range v.names() {
+		total := v.totals[name]
+		klog.Infof("Listed %d directories with %d entries and stat'ed %d files of mountPath %s in total, %d listings or stats failed, %d directories skipped", total.Directories, total.Entries, total.Files, name, total.Errors, total.Skipped)
+	}
+}
+
+// mountDevice returns the device of the file system mounted at the path, which changes when the volume is remounted.
+func mountDevice(path string) (uint64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("failed to get the device of %q", path)
+	}
+
+	return uint64(stat.Dev), nil //nolint:unconvert // The type of the device differs between platforms.
+}
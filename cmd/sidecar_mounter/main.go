@@ -29,14 +29,25 @@ import (
 	"time"
 
 	sidecarmounter "github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/sidecar_mounter"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/webhook"
 	"k8s.io/klog/v2"
 )
 
 var (
-	gcsfusePath    = flag.String("gcsfuse-path", "/gcsfuse", "gcsfuse path")
+	gcsfusePath    = flag.String("gcsfuse-path", "/gcsfuse", "The path of the FUSE client binary, gcsfuse by default.")
+	fuseClient     = flag.String("fuse-client", sidecarmounter.GcsfuseClientName, "The FUSE client that serves the volumes.")
 	volumeBasePath = flag.String("volume-base-path", webhook.SidecarContainerTmpVolumeMountPath+"/.volumes", "volume base path")
 	_              = flag.Int("grace-period", 0, "grace period for gcsfuse termination. This flag has been deprecated, has no effect and will be removed in the future.")
+	loggingFormat  = flag.String("logging-format", util.LoggingFormatText, "The log output format, either `text` or `json`. The json format writes one JSON object per line, which Cloud Logging parses into structured fields.")
+	// The pressure stall information is only available on cgroup v2 nodes, the flags have no effect on cgroup v1 nodes.
+	pressureThreshold   = flag.Float64("pressure-threshold", 0, "The share of time, in percent, that tasks in the sidecar container stalled on memory or IO over the last 10 seconds, above which the sidecar container is under pressure and a warning is logged, for example 20. The default is 0, which disables the pressure monitoring.")
+	pressureWaitTimeout = flag.Duration("pressure-wait-timeout", 0, "How long the sidecar mounter waits for the memory pressure to be relieved before starting the next gcsfuse process, when the pressure monitoring is enabled. The default is 0, which starts the gcsfuse processes without waiting.")
+	uploadBarrierPort   = flag.Int("upload-barrier-port", 0, "The loopback port where the sidecar mounter serves the upload barrier API, which blocks until the writes staged by gcsfuse are uploaded to GCS. The default is 0, which means that the API is disabled.")
+	waitForUploads      = flag.Bool("wait-for-uploads", false, "Call the upload barrier API at the upload-barrier-port and exit once the staged writes are uploaded, instead of mounting the volumes. The sidecar container preStop hook uses it.")
+	ioTokenPort         = flag.Int("io-token-port", 0, "The loopback port where the sidecar mounter serves the io token API, which the metadata prefetch calls before each directory listing, with --io-ops-per-second. The default is 0, which means that the API is disabled.")
+	drainTimeout        = flag.Duration("drain-timeout", 0, "How long the sidecar mounter of a native sidecar container waits on SIGTERM for gcsfuse to upload the staged writes before it terminates gcsfuse. The webhook sets it below the termination grace period of the Pod, so that gcsfuse still exits before the sidecar container is killed. The default is 0, which terminates gcsfuse right away.")
+	ioOpsPerSecond      = flag.Int("io-ops-per-second", 0, "The file system operations per second of the token bucket that the metadata prefetch shares with the workload. The operations that gcsfuse serves to the workload are charged to the bucket from the gcsfuse metrics, so that the prefetch only uses the capacity that the workload leaves.")
 	// This is set at compile time.
 	version = "unknown"
 )
@@ -45,6 +56,19 @@ func main() {
 	klog.InitFlags(nil)
 	flag.Parse()
 
+	if err := util.InitLogging(*loggingFormat); err != nil {
+		klog.Fatalf("failed to initialize logging: %v", err)
+	}
+
+	if *waitForUploads {
+		if err := sidecarmounter.CallUploadBarrier(context.Background(), *uploadBarrierPort); err != nil {
+			klog.Fatalf("failed to wait for the staged writes to be uploaded: %v", err)
+		}
+		klog.Info("the staged writes are uploaded")
+
+		return
+	}
+
 	klog.Infof("Running Google Cloud Storage FUSE CSI driver sidecar mounter version %v", version)
 	socketPathPattern := *volumeBasePath + "/*/socket"
 	socketPaths, err := filepath.Glob(socketPathPattern)
@@ -52,15 +76,35 @@ func main() {
 		klog.Fatalf("failed to look up socket paths: %v", err)
 	}
 
-	mounter := sidecarmounter.New(*gcsfusePath)
+	// The emptyDir volumes outlive the sidecar container, so clean up the credential sockets of a previous run.
+	sidecarmounter.SweepStaleTokenSockets(*volumeBasePath)
+
+	client, err := sidecarmounter.NewFUSEClient(*fuseClient)
+	if err != nil {
+		klog.Fatalf("failed to select the FUSE client: %v", err)
+	}
+	mounter := sidecarmounter.New(*gcsfusePath, client)
 	ctx, cancel := context.WithCancel(context.Background())
+	pressureMonitor := sidecarmounter.NewPressureMonitor(sidecarmounter.CgroupV2Dir, *pressureThreshold)
+	if *uploadBarrierPort != 0 {
+		go mounter.ServeUploadBarrier(*uploadBarrierPort)
+	}
+	if *ioTokenPort != 0 {
+		if mounter.IOTokens = sidecarmounter.NewIOTokenBucket(*ioOpsPerSecond); mounter.IOTokens == nil {
+			klog.Fatalf("--io-token-port requires a positive --io-ops-per-second, got %d", *ioOpsPerSecond)
+		}
+		go mounter.IOTokens.ServeIOTokens(*ioTokenPort)
+	}
 
 	for _, sp := range socketPaths {
 		// sleep 1.5 seconds before launch the next gcsfuse to avoid
 		// 1. different gcsfuse logs mixed together.
 		// 2. memory usage peak.
 		time.Sleep(1500 * time.Millisecond)
-		mc := sidecarmounter.NewMountConfig(sp)
+		if pressureMonitor != nil && *pressureWaitTimeout > 0 {
+			pressureMonitor.Wait(ctx, *pressureWaitTimeout)
+		}
+		mc := mounter.NewMountConfig(sp, version)
 		if mc != nil {
 			if err := mounter.Mount(ctx, mc); err != nil {
 				mc.ErrWriter.WriteMsg(fmt.Sprintf("failed to mount bucket %q for volume %q: %v\n", mc.BucketName, mc.VolumeName, err))
@@ -68,6 +112,10 @@ func main() {
 		}
 	}
 
+	if pressureMonitor != nil {
+		go pressureMonitor.Run(ctx)
+	}
+
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGTERM)
 	klog.Info("waiting for SIGTERM signal...")
@@ -83,6 +131,7 @@ func main() {
 
 				// After the Kubernetes native sidecar container feature is adopted,
 				// we should propagate the SIGTERM signal outside of this goroutine.
+				mounter.Terminate()
 				cancel()
 
 				if err := os.Remove(*volumeBasePath + "/exit"); err != nil {
@@ -107,13 +156,30 @@ func main() {
 	}
 
 	<-c // blocking the process
-	klog.Info("received SIGTERM signal, waiting for all the gcsfuse processes exit...")
 
+	// The time from SIGTERM until gcsfuse exited is how long the pending writes took to flush,
+	// which the terminationGracePeriodSeconds of the Pod must exceed for the writes to reach GCS.
+	// The flush duration of each volume is reported to the CSI driver.
+	terminated := time.Now()
+	mounter.Terminate()
 	if isNativeSidecar {
+		// The workload containers have exited, so gcsfuse is only terminated once it uploaded the staged writes,
+		// or the drain timeout expires before the termination grace period of the Pod.
+		if *drainTimeout > 0 {
+			klog.Infof("received SIGTERM signal, waiting up to %v for the staged writes to be uploaded...", *drainTimeout)
+			drainCtx, drainCancel := context.WithTimeout(context.Background(), *drainTimeout)
+			if err := mounter.Drain(drainCtx); err != nil {
+				klog.Errorf("failed to wait for the staged writes to be uploaded: %v", err)
+			} else {
+				klog.Infof("the staged writes were uploaded in %v", time.Since(terminated).Round(time.Millisecond))
+			}
+			drainCancel()
+		}
 		cancel()
 	}
+	klog.Info("waiting for all the gcsfuse processes exit...")
 
 	mounter.WaitGroup.Wait()
 
-	klog.Info("exiting sidecar mounter...")
+	klog.Infof("all the gcsfuse processes exited %v after SIGTERM, exiting sidecar mounter...", time.Since(terminated).Round(time.Millisecond))
 }
@@ -18,22 +18,34 @@ limitations under the License.
 package main
 
 import (
+	gocontext "context"
+	"errors"
 	"flag"
+	"fmt"
 	"net/http"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/volumespec"
 	wh "github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/webhook"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
@@ -41,6 +53,7 @@ import (
 var (
 	port                                    = flag.Int("port", 443, "The port that the webhook server serves at.")
 	healthProbeBindAddress                  = flag.String("health-probe-bind-address", ":8080", "The TCP address that the controller should bind to for serving health probes.")
+	metricsBindAddress                      = flag.String("metrics-bind-address", ":22032", "The TCP address that the controller should bind to for serving the Prometheus metrics of the admission requests. Set to 0 to disable the metrics endpoint.")
 	certDir                                 = flag.String("cert-dir", "/etc/tls-certs", "The directory that contains the server key and certificate.")
 	certName                                = flag.String("cert-name", "cert.pem", "The server certificate name.")
 	keyName                                 = flag.String("key-name", "key.pem", "The server key name.")
@@ -60,6 +73,23 @@ var (
 	metadataPrefetchCPULimit                = flag.String("metadata-sidecar-cpu-limit", "50m", "Flag to use default value for gcsfuse memory prefetch sidecar container cpu limit.")
 	metadataPrefetchEphemeralStorageRequest = flag.String("metadata-sidecar-ephemeral-storage-request", "10Mi", "The default value for gcsfuse memory prefetch sidecar ephemeral storage request.")
 	metadataPrefetchEphemeralStorageLimit   = flag.String("metadata-sidecar-ephemeral-storage-limit", "10Mi", "The default value for gcsfuse memory prefetch sidecar ephemeral storage limit.")
+	driverName                              = flag.String("driver-name", wh.DefaultCSIDriverName, "The name of the CSI driver whose volumes the webhook injects the sidecar container for.")
+	lookupCacheTTL                          = flag.Duration("lookup-cache-ttl", wh.DefaultLookupCacheTTL, "How long the results of cluster-wide lookups, such as native sidecar support and the project ID, are reused across admission requests. Set to 0 to disable caching.")
+	prepullImages                           = flag.Bool("prepull-images", false, "Create a DaemonSet that pre-pulls the sidecar container images on nodes, so that the first Pod on a node does not wait for the image pulls.")
+	prepullNamespace                        = flag.String("prepull-namespace", "gcs-fuse-csi-driver", "The namespace of the image pre-pull DaemonSet.")
+	prepullNodeSelector                     = flag.String("prepull-node-selector", "", "A comma-separated list of key=value node labels. The sidecar container images are only pre-pulled on nodes that have all the labels.")
+	prepullPauseImage                       = flag.String("prepull-pause-image", wh.DefaultPrepullPauseImage, "The image of the container that keeps the image pre-pull Pods running.")
+	loggingFormat                           = flag.String("logging-format", util.LoggingFormatText, "The log output format, either `text` or `json`. The json format writes one JSON object per line, which Cloud Logging parses into structured fields.")
+	webhookConfigFile                       = flag.String("config-file", "", "The path of a YAML file that overrides the default sidecar container configs of the flags, under the `sidecar` and `metadata-prefetch-sidecar` keys, and that sets default Pod annotations per namespace under the `default-annotations` key. The file is reloaded when it changes, so all the webhook replicas that mount the same ConfigMap serve the new defaults without a restart. The default is empty string, which means that only the flags are used.")
+	failurePolicy                           = flag.String("failure-policy", "", "The failurePolicy that the webhook sets on its MutatingWebhookConfiguration, either `Ignore` to create Pods without the sidecar container while no webhook replica is available, or `Fail` to reject them. The default is empty string, which means that the webhook does not change the MutatingWebhookConfiguration.")
+	failurePolicyExcludedNamespaces         = flag.String("failure-policy-excluded-namespaces", "kube-system", "A comma-separated list of namespaces that the webhook excludes with the namespaceSelector of its MutatingWebhookConfiguration when --failure-policy is set, so that their Pods can be created while no webhook replica is available.")
+	mutatingWebhookConfigurationName        = flag.String("mutating-webhook-configuration-name", wh.DefaultMutatingWebhookConfigurationName, "The name of the MutatingWebhookConfiguration of the webhook.")
+	oomProtectionPriorityClasses            = flag.String("sidecar-oom-protection-priority-classes", "", "A comma-separated list of priority classes. The sidecar container memory request of the Pods in these priority classes is raised to the highest memory request of the workload containers, so that under node memory pressure the kernel kills a workload container before the sidecar container. The gke-gcsfuse/oom-protection Pod annotation overrides it.")
+	sandboxedRuntimeHandlers                = flag.String("sandboxed-runtime-handlers", "", "A comma-separated list of RuntimeClass handlers that run the Pods in a sandbox, such as `runsc` of gVisor in GKE Sandbox, where the sidecar container cannot serve the Cloud Storage FUSE volumes. The webhook rejects the Pods whose RuntimeClass has one of these handlers and that request the sidecar container with the reason, instead of admitting Pods whose volume mounts hang. The default is empty string, which admits them.")
+	certExpiryWarningThreshold              = flag.Duration("cert-expiry-warning-threshold", 30*24*time.Hour, "The webhook records a warning event on its MutatingWebhookConfiguration when its serving certificate or a certificate of the caBundle expires within the threshold. Set to 0 to only warn about expired certificates.")
+	shutdownDelay                           = flag.Duration("shutdown-delay", 5*time.Second, "How long the webhook keeps serving admission requests after it receives a termination signal. The readiness check fails during the delay, so that the API server stops sending requests to the replica before the webhook server stops.")
+	maxConcurrentInjections                 = flag.Int("max-concurrent-injections", 0, "The maximum number of Pods that the webhook injects the sidecar container into concurrently. The other Pods that use the driver wait for a free slot within --injection-timeout, or are rejected so that their controllers retry the creation, and the Pods that do not use the driver are admitted without waiting. Bare Pods without a controller are not retried. The default is 0, which does not limit the injections.")
+	injectionTimeout                        = flag.Duration("injection-timeout", 0, "How long the webhook waits for a free injection slot and the sidecar injection of a Pod before it rejects the Pod. Keep it shorter than the timeoutSeconds of the MutatingWebhookConfiguration, 3 seconds in the manifests, so that the API server receives the rejection instead of timing out and applying the failure policy. The default is 0, which does not bound the injection time.")
 	// These are set at compile time.
 	webhookVersion = "unknown"
 )
@@ -72,6 +102,10 @@ func main() {
 	klog.InitFlags(nil)
 	flag.Parse()
 
+	if err := util.InitLogging(*loggingFormat); err != nil {
+		klog.Fatalf("failed to initialize logging: %v", err)
+	}
+
 	// Thanks to the PR https://github.com/solo-io/gloo/pull/8549
 	// This line prevents controller-runtime from complaining about log.SetLogger never being called
 	log.SetLogger(logr.New(log.NullLogSink{}))
@@ -85,6 +119,12 @@ func main() {
 
 	metadataPrefetchSideCarConfig := wh.LoadConfig(*metadataSidecarImage, *imagePullPolicy, *metadataPrefetchCPURequest, *metadataPrefetchCPULimit, *metadataMemoryRequest, *metadataMemoryLimit, *metadataPrefetchEphemeralStorageRequest, *metadataPrefetchEphemeralStorageLimit)
 
+	// The sidecar containers log in the same format as the webhook.
+	if *loggingFormat != util.LoggingFormatText {
+		fuseSideCarConfig.LoggingFormat = *loggingFormat
+		metadataPrefetchSideCarConfig.LoggingFormat = *loggingFormat
+	}
+
 	// Load config for manager, informers, listers
 	kubeConfig := config.GetConfigOrDie()
 
@@ -109,22 +149,66 @@ func main() {
 	}
 
 	// Setup stop channel
-	context := signals.SetupSignalHandler()
+	signalContext := signals.SetupSignalHandler()
+	context := delayShutdown(signalContext, *shutdownDelay)
 
 	// Setup Informer
 	informerFactory := informers.NewSharedInformerFactory(client, resyncDuration)
 	nodeLister := informerFactory.Core().V1().Nodes().Lister()
 	pvcLister := informerFactory.Core().V1().PersistentVolumeClaims().Lister()
 	pvLister := informerFactory.Core().V1().PersistentVolumes().Lister()
+	namespaceLister := informerFactory.Core().V1().Namespaces().Lister()
 
 	informerFactory.Start(context.Done())
 	informerFactory.WaitForCacheSync(context.Done())
 
+	if *prepullImages {
+		nodeSelector, err := parseNodeSelector(*prepullNodeSelector)
+		if err != nil {
+			klog.Fatalf("Invalid --prepull-node-selector: %v", err)
+		}
+		ds := wh.PrepullDaemonSet(*prepullNamespace, wh.SidecarPrepullImages(*sidecarImage, *metadataSidecarImage), nodeSelector, *prepullPauseImage)
+		go wait.UntilWithContext(context, func(ctx gocontext.Context) {
+			if err := wh.EnsurePrepullDaemonSet(ctx, client, ds); err != nil {
+				klog.Errorf("Failed to reconcile the image pre-pull DaemonSet: %v", err)
+			}
+		}, resyncDuration)
+	}
+
+	// Check the failure policy before serving, so that a fail-closed webhook that could keep the cluster from recovering is reported.
+	if *failurePolicy != "" {
+		policy, err := wh.ParseFailurePolicy(*failurePolicy, splitList(*failurePolicyExcludedNamespaces))
+		if err != nil {
+			klog.Fatalf("Invalid --failure-policy: %v", err)
+		}
+		if err := wh.EnsureFailurePolicy(context, client, *mutatingWebhookConfigurationName, policy); err != nil {
+			klog.Errorf("Failed to set the failure policy: %v", err)
+		}
+		go wait.UntilWithContext(context, func(ctx gocontext.Context) {
+			if err := wh.EnsureFailurePolicy(ctx, client, *mutatingWebhookConfigurationName, policy); err != nil {
+				klog.Errorf("Failed to reconcile the failure policy: %v", err)
+			}
+		}, resyncDuration)
+	}
+	if webhookConfig, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context, *mutatingWebhookConfigurationName, metav1.GetOptions{}); err != nil {
+		klog.Warningf("Unable to check the failure policy of MutatingWebhookConfiguration %q: %v", *mutatingWebhookConfigurationName, err)
+	} else {
+		for _, warning := range wh.FailurePolicyWarnings(webhookConfig) {
+			klog.Warningf("MutatingWebhookConfiguration %q could deadlock cluster recovery: %s", *mutatingWebhookConfigurationName, warning)
+		}
+		for _, w := range webhookConfig.Webhooks {
+			if w.TimeoutSeconds != nil && *injectionTimeout >= time.Duration(*w.TimeoutSeconds)*time.Second {
+				klog.Warningf("MutatingWebhookConfiguration %q: the --injection-timeout %v is not shorter than the timeoutSeconds %d of webhook %q, so the API server times out before the webhook rejects the overloaded requests", *mutatingWebhookConfigurationName, *injectionTimeout, *w.TimeoutSeconds, w.Name)
+			}
+		}
+	}
+
 	// Setup a Manager
 	klog.Info("Setting up manager.")
 	mgr, err := manager.New(kubeConfig, manager.Options{
 		HealthProbeBindAddress: *healthProbeBindAddress,
 		ReadinessEndpointName:  "/readyz",
+		Metrics:                metricsserver.Options{BindAddress: *metricsBindAddress},
 		WebhookServer: webhook.NewServer(webhook.Options{
 			Port:     *port,
 			CertDir:  *certDir,
@@ -136,27 +220,80 @@ func main() {
 		klog.Fatalf("Unable to set up overall controller manager: %v", err)
 	}
 
-	if err = mgr.AddReadyzCheck("readyz", func(_ *http.Request) error {
+	// Setup Webhooks
+	klog.Info("Setting up webhook server.")
+	hookServer := mgr.GetWebhookServer()
+
+	// The replica is only ready while the webhook server serves a valid certificate and is not shutting down,
+	// so that the API server sends the admission requests to the other replicas.
+	if err = mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		klog.Errorf("Unable to set up healthz endpoint: %v", err)
+	}
+	if err = mgr.AddReadyzCheck("webhook-server", hookServer.StartedChecker()); err != nil {
+		klog.Errorf("Unable to set up readyz endpoint: %v", err)
+	}
+	if err = mgr.AddReadyzCheck("serving-cert", wh.CertValidityChecker(filepath.Join(*certDir, *certName))); err != nil {
+		klog.Errorf("Unable to set up readyz endpoint: %v", err)
+	}
+	if err = mgr.AddReadyzCheck("shutdown", func(_ *http.Request) error {
+		if signalContext.Err() != nil {
+			return errors.New("the webhook is shutting down")
+		}
+
 		return nil
 	}); err != nil {
 		klog.Errorf("Unable to set up readyz endpoint: %v", err)
 	}
 
-	// Setup Webhooks
-	klog.Info("Setting up webhook server.")
-	hookServer := mgr.GetWebhookServer()
+	// Report the certificates periodically, because an expired or mismatched certificate only shows up as failed Pod creations.
+	certMonitor, err := wh.NewCertMonitor(filepath.Join(*certDir, *certName), client, *mutatingWebhookConfigurationName, *certExpiryWarningThreshold, mgr.GetEventRecorderFor("gcsfuse-sidecar-injector"), crmetrics.Registry)
+	if err != nil {
+		klog.Errorf("Unable to set up the certificate monitor: %v", err)
+	} else {
+		go wait.UntilWithContext(context, func(ctx gocontext.Context) {
+			certMonitor.Check(ctx, time.Now())
+		}, resyncDuration)
+	}
+
+	injector := &wh.SidecarInjector{
+		Client:                       mgr.GetClient(),
+		Config:                       fuseSideCarConfig,
+		MetadataPrefetchConfig:       metadataPrefetchSideCarConfig,
+		Decoder:                      admission.NewDecoder(runtime.NewScheme()),
+		NodeLister:                   nodeLister,
+		PvLister:                     pvLister,
+		PvcLister:                    pvcLister,
+		NamespaceLister:              namespaceLister,
+		ServerVersion:                serverVersion,
+		LookupCacheTTL:               *lookupCacheTTL,
+		DriverName:                   *driverName,
+		OOMProtectionPriorityClasses: splitList(*oomProtectionPriorityClasses),
+		SandboxedRuntimeHandlers:     splitList(*sandboxedRuntimeHandlers),
+		RuntimeClasses:               client.NodeV1().RuntimeClasses(),
+	}
+	if *webhookConfigFile != "" {
+		sidecarConfig, metadataPrefetchConfig, defaultAnnotations, err := wh.LoadConfigFile(*webhookConfigFile, fuseSideCarConfig, metadataPrefetchSideCarConfig)
+		if err != nil {
+			klog.Fatalf("Invalid --config-file: %v", err)
+		}
+		injector.SetDefaultConfigs(sidecarConfig, metadataPrefetchConfig, defaultAnnotations)
+		go injector.WatchConfigFile(context, *webhookConfigFile, fuseSideCarConfig, metadataPrefetchSideCarConfig)
+	}
+
+	limiter, err := wh.NewAdmissionLimiter(injector, *maxConcurrentInjections, *injectionTimeout, crmetrics.Registry)
+	if err != nil {
+		klog.Fatalf("Unable to set up the admission limiter: %v", err)
+	}
 
 	klog.Info("Registering webhooks to the webhook server.")
 	hookServer.Register("/inject", &webhook.Admission{
-		Handler: &wh.SidecarInjector{
-			Client:                 mgr.GetClient(),
-			Config:                 fuseSideCarConfig,
-			MetadataPrefetchConfig: metadataPrefetchSideCarConfig,
-			Decoder:                admission.NewDecoder(runtime.NewScheme()),
-			NodeLister:             nodeLister,
-			PvLister:               pvLister,
-			PvcLister:              pvcLister,
-			ServerVersion:          serverVersion,
+		Handler: limiter,
+	})
+	hookServer.Register("/validate-storageclass", &webhook.Admission{
+		Handler: &wh.StorageClassValidator{
+			Decoder:            admission.NewDecoder(runtime.NewScheme()),
+			DriverName:         *driverName,
+			ValidateParameters: volumespec.ValidateStorageClassParameters,
 		},
 	})
 
@@ -165,3 +302,47 @@ func main() {
 		klog.Fatalf("Unable to run manager: %v", err)
 	}
 }
+
+// delayShutdown returns a context that is canceled the delay after ctx, so that the webhook keeps serving
+// the admission requests that the API server sends before it observes that the replica is terminating.
+func delayShutdown(ctx gocontext.Context, delay time.Duration) gocontext.Context {
+	delayed, cancel := gocontext.WithCancel(gocontext.WithoutCancel(ctx))
+	go func() {
+		<-ctx.Done()
+		klog.Infof("Received a termination signal, stopping the webhook server in %v", delay)
+		time.Sleep(delay)
+		cancel()
+	}()
+
+	return delayed
+}
+
+// splitList splits a comma-separated list, and skips empty items.
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// parseNodeSelector parses a comma-separated list of key=value pairs.
+func parseNodeSelector(s string) (map[string]string, error) {
+	nodeSelector := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", pair)
+		}
+		nodeSelector[kv[0]] = kv[1]
+	}
+
+	return nodeSelector, nil
+}
@@ -152,10 +152,45 @@ func (ts *GCPTokenSource) fetchGCPSAToken(ctx context.Context, identityBindingTo
 		return identityBindingToken, nil
 	}
 
-	gcpSAClient, err := credentials.NewIamCredentialsClient(
-		ctx,
-		option.WithTokenSource(oauth2.StaticTokenSource(identityBindingToken)),
-	)
+	return generateAccessToken(ctx, oauth2.StaticTokenSource(identityBindingToken), gcpSAName, []string{storage.ScopeFullControl})
+}
+
+// ImpersonatedTokenSource generates tokens of a GCP service account with the tokens of another identity,
+// which needs the Service Account Token Creator role on the service account.
+// The tokens have full control of Cloud Storage, unless Scopes is set.
+type ImpersonatedTokenSource struct {
+	Base           oauth2.TokenSource
+	ServiceAccount string
+	Scopes         []string
+}
+
+// Token generates a GCP service account token with a token of the base token source.
+func (ts *ImpersonatedTokenSource) Token() (*oauth2.Token, error) {
+	return ts.TokenWithContext(context.Background())
+}
+
+// TokenWithContext generates a GCP service account token with a token of the base token source,
+// and gives up when ctx is done.
+func (ts *ImpersonatedTokenSource) TokenWithContext(ctx context.Context) (*oauth2.Token, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	scopes := ts.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{storage.ScopeFullControl}
+	}
+
+	token, err := generateAccessToken(ctx, ts.Base, ts.ServiceAccount, scopes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to impersonate GCP service account %q: %w", ts.ServiceAccount, err)
+	}
+
+	return token, nil
+}
+
+// generateAccessToken calls the IAM credentials endpoint with the tokens of ts to generate a token of the GCP service account.
+func generateAccessToken(ctx context.Context, ts oauth2.TokenSource, gcpSAName string, scopes []string) (*oauth2.Token, error) {
+	gcpSAClient, err := credentials.NewIamCredentialsClient(ctx, option.WithTokenSource(ts))
 	if err != nil {
 		return nil, fmt.Errorf("create credentials client error: %w", err)
 	}
@@ -164,10 +199,8 @@ func (ts *GCPTokenSource) fetchGCPSAToken(ctx context.Context, identityBindingTo
 	resp, err := gcpSAClient.GenerateAccessToken(
 		ctx,
 		&credentialspb.GenerateAccessTokenRequest{
-			Name: "projects/-/serviceAccounts/" + gcpSAName,
-			Scope: []string{
-				storage.ScopeFullControl,
-			},
+			Name:  "projects/-/serviceAccounts/" + gcpSAName,
+			Scope: scopes,
 		},
 	)
 	if err != nil {
@@ -19,6 +19,7 @@ package clientset
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -26,24 +27,45 @@ import (
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/webhook"
 	authenticationv1 "k8s.io/api/authentication/v1"
 	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	listersv1 "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 )
 
 type Interface interface {
 	ConfigurePodLister(nodeName string)
 	ConfigureNodeLister(nodeName string)
+	ConfigurePVLister()
 	GetPod(namespace, name string) (*corev1.Pod, error)
+	ListPods() ([]*corev1.Pod, error)
 	CreateServiceAccountToken(ctx context.Context, namespace, name string, tokenRequest *authenticationv1.TokenRequest) (*authenticationv1.TokenRequest, error)
 	GetGCPServiceAccountName(ctx context.Context, namespace, name string) (string, error)
 	GetNode(name string) (*corev1.Node, error)
+	GetPVC(ctx context.Context, namespace, name string) (*corev1.PersistentVolumeClaim, error)
+	GetStorageClass(ctx context.Context, name string) (*storagev1.StorageClass, error)
+	ListPVs(ctx context.Context) ([]corev1.PersistentVolume, error)
+	GetPVByVolumeHandle(driverName, volumeHandle string) (*corev1.PersistentVolume, error)
+	GetNamespaceUID(ctx context.Context, name string) (string, error)
+	GetRuntimeClassHandler(ctx context.Context, name string) (string, error)
+	Eventf(object runtime.Object, eventType, reason, messageFmt string, args ...interface{})
+	ResizePodContainer(ctx context.Context, pod *corev1.Pod, containerName string, isInitContainer bool, resources corev1.ResourceRequirements) error
+	AnnotatePod(ctx context.Context, pod *corev1.Pod, annotations map[string]string) error
+	IsDriverRegistered(ctx context.Context, nodeName, driverName string) (bool, error)
+	RemoveNodeTaint(ctx context.Context, nodeName, taintKey string) (bool, error)
+	SetNodeCondition(ctx context.Context, nodeName string, condition corev1.NodeCondition) error
 }
 
 type PodInfo struct {
@@ -53,12 +75,18 @@ type PodInfo struct {
 
 type Clientset struct {
 	k8sClients                kubernetes.Interface
+	eventRecorder             record.EventRecorder
 	podLister                 listersv1.PodLister
 	nodeLister                listersv1.NodeLister
+	pvLister                  listersv1.PersistentVolumeLister
 	informerResyncDurationSec int
 }
 
-const GkeMetaDataServerKey = "iam.gke.io/gke-metadata-server-enabled"
+const (
+	GkeMetaDataServerKey = "iam.gke.io/gke-metadata-server-enabled"
+
+	eventSourceComponent = "gcs-fuse-csi-driver"
+)
 
 func (c *Clientset) ConfigureNodeLister(nodeName string) {
 	trim := func(obj interface{}) (interface{}, error) {
@@ -107,6 +135,44 @@ func (c *Clientset) ConfigureNodeLister(nodeName string) {
 	c.nodeLister = nodeLister
 }
 
+func (c *Clientset) ConfigurePVLister() {
+	trim := func(obj interface{}) (interface{}, error) {
+		if accessor, err := meta.Accessor(obj); err == nil {
+			if accessor.GetManagedFields() != nil {
+				accessor.SetManagedFields(nil)
+			}
+		}
+
+		// We are filtering only for the CSI volume source to optimize memory usage.
+		// Relevant info is for DeleteVolume calls, which look up the PersistentVolume of the volume handle.
+		pvObj, ok := obj.(*corev1.PersistentVolume)
+		if !ok {
+			return obj, nil
+		}
+
+		csi := pvObj.Spec.CSI
+		pvObj.Spec = corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: csi},
+		}
+		pvObj.Status = corev1.PersistentVolumeStatus{}
+
+		return obj, nil
+	}
+
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(
+		c.k8sClients,
+		time.Duration(c.informerResyncDurationSec)*time.Second,
+		informers.WithTransform(trim),
+	)
+	pvLister := informerFactory.Core().V1().PersistentVolumes().Lister()
+
+	ctx := context.Background()
+	informerFactory.Start(ctx.Done())
+	informerFactory.WaitForCacheSync(ctx.Done())
+
+	c.pvLister = pvLister
+}
+
 func New(kubeconfigPath string, informerResyncDurationSec int) (Interface, error) {
 	var err error
 	var rc *rest.Config
@@ -127,7 +193,11 @@ func New(kubeconfigPath string, informerResyncDurationSec int) (Interface, error
 		return nil, fmt.Errorf("failed to configure k8s client: %w", err)
 	}
 
-	return &Clientset{k8sClients: clientset, informerResyncDurationSec: informerResyncDurationSec}, nil
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	eventRecorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: eventSourceComponent})
+
+	return &Clientset{k8sClients: clientset, eventRecorder: eventRecorder, informerResyncDurationSec: informerResyncDurationSec}, nil
 }
 
 func (c *Clientset) ConfigurePodLister(nodeName string) {
@@ -148,6 +218,11 @@ func (c *Clientset) ConfigurePodLister(nodeName string) {
 
 		var newContainers []corev1.Container
 		for _, cont := range podObj.Spec.Containers {
+			if cont.Name == webhook.GcsFuseSidecarName {
+				newContainers = append(newContainers, cont)
+
+				continue
+			}
 			container := corev1.Container{
 				Name:            cont.Name,
 				SecurityContext: cont.SecurityContext,
@@ -175,13 +250,15 @@ func (c *Clientset) ConfigurePodLister(nodeName string) {
 		volumes := podObj.Spec.Volumes
 		restartPolicy := podObj.Spec.RestartPolicy
 		hostNetwork := podObj.Spec.HostNetwork
+		runtimeClassName := podObj.Spec.RuntimeClassName
 		podObj.Spec = corev1.PodSpec{
-			NodeName:       nodeName,
-			Volumes:        volumes,
-			Containers:     newContainers,
-			InitContainers: newInitContainers,
-			RestartPolicy:  restartPolicy,
-			HostNetwork:    hostNetwork,
+			NodeName:         nodeName,
+			Volumes:          volumes,
+			Containers:       newContainers,
+			InitContainers:   newInitContainers,
+			RestartPolicy:    restartPolicy,
+			HostNetwork:      hostNetwork,
+			RuntimeClassName: runtimeClassName,
 		}
 
 		return obj, nil
@@ -212,6 +289,15 @@ func (c *Clientset) GetPod(namespace, name string) (*corev1.Pod, error) {
 	return c.podLister.Pods(namespace).Get(name)
 }
 
+// ListPods returns the Pods on the node of the Pod informer.
+func (c *Clientset) ListPods() ([]*corev1.Pod, error) {
+	if c.podLister == nil {
+		return nil, errors.New("pod informer is not ready")
+	}
+
+	return c.podLister.List(labels.Everything())
+}
+
 func (c *Clientset) GetNode(name string) (*corev1.Node, error) {
 	if c.nodeLister == nil {
 		return nil, errors.New("node informer is not ready")
@@ -249,3 +335,216 @@ func (c *Clientset) GetGCPServiceAccountName(ctx context.Context, namespace, nam
 
 	return resp.Annotations["iam.gke.io/gcp-service-account"], nil
 }
+
+func (c *Clientset) GetPVC(ctx context.Context, namespace, name string) (*corev1.PersistentVolumeClaim, error) {
+	return c.k8sClients.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+func (c *Clientset) GetStorageClass(ctx context.Context, name string) (*storagev1.StorageClass, error) {
+	return c.k8sClients.StorageV1().StorageClasses().Get(ctx, name, metav1.GetOptions{})
+}
+
+// ListPVs lists the PersistentVolumes from the API server, so that volumes provisioned by concurrent requests are included.
+func (c *Clientset) ListPVs(ctx context.Context) ([]corev1.PersistentVolume, error) {
+	pvs, err := c.k8sClients.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return pvs.Items, nil
+}
+
+// GetPVByVolumeHandle returns the PersistentVolume of the CSI driver with the volume handle from the PersistentVolume informer,
+// or nil if there is none.
+func (c *Clientset) GetPVByVolumeHandle(driverName, volumeHandle string) (*corev1.PersistentVolume, error) {
+	if c.pvLister == nil {
+		return nil, errors.New("persistent volume informer is not ready")
+	}
+
+	pvs, err := c.pvLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, pv := range pvs {
+		if source := pv.Spec.CSI; source != nil && source.Driver == driverName && source.VolumeHandle == volumeHandle {
+			return pv, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// GetRuntimeClassHandler returns the handler of the RuntimeClass, such as runsc for the RuntimeClasses of gVisor.
+func (c *Clientset) GetRuntimeClassHandler(ctx context.Context, name string) (string, error) {
+	runtimeClass, err := c.k8sClients.NodeV1().RuntimeClasses().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	return runtimeClass.Handler, nil
+}
+
+// GetNamespaceUID returns the UID of the namespace. The UID of the kube-system namespace identifies the cluster.
+func (c *Clientset) GetNamespaceUID(ctx context.Context, name string) (string, error) {
+	ns, err := c.k8sClients.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	return string(ns.UID), nil
+}
+
+// Eventf records an event on the given object. Events are sent to the API server asynchronously.
+func (c *Clientset) Eventf(object runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	c.eventRecorder.Eventf(object, eventType, reason, messageFmt, args...)
+}
+
+// ResizePodContainer updates the resources of a running container in place.
+// The resize subresource is used when the API server supports it, otherwise the Pod is patched directly.
+func (c *Clientset) ResizePodContainer(ctx context.Context, pod *corev1.Pod, containerName string, isInitContainer bool, resources corev1.ResourceRequirements) error {
+	containersField := "containers"
+	if isInitContainer {
+		containersField = "initContainers"
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			containersField: []map[string]interface{}{
+				{
+					"name":      containerName,
+					"resources": resources,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal the resize patch: %w", err)
+	}
+
+	pods := c.k8sClients.CoreV1().Pods(pod.Namespace)
+	_, err = pods.Patch(ctx, pod.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{}, "resize")
+	if apierrors.IsNotFound(err) {
+		_, err = pods.Patch(ctx, pod.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to resize container %q of pod %s/%s: %w", containerName, pod.Namespace, pod.Name, err)
+	}
+
+	return nil
+}
+
+// AnnotatePod sets the given annotations on the Pod, leaving its other annotations unchanged.
+func (c *Clientset) AnnotatePod(ctx context.Context, pod *corev1.Pod, annotations map[string]string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal the annotation patch: %w", err)
+	}
+
+	if _, err := c.k8sClients.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to annotate pod %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+
+	return nil
+}
+
+// IsDriverRegistered returns true if kubelet has registered the CSI driver on the node.
+func (c *Clientset) IsDriverRegistered(ctx context.Context, nodeName, driverName string) (bool, error) {
+	csiNode, err := c.k8sClients.StorageV1().CSINodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get CSINode %q: %w", nodeName, err)
+	}
+
+	for _, d := range csiNode.Spec.Drivers {
+		if d.Name == driverName {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// RemoveNodeTaint removes the taints with the given key from the node, and returns true if any taint was removed.
+// The node resource version is part of the patch, so the patch fails instead of overwriting taints that changed concurrently.
+func (c *Clientset) RemoveNodeTaint(ctx context.Context, nodeName, taintKey string) (bool, error) {
+	node, err := c.k8sClients.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to get node %q: %w", nodeName, err)
+	}
+
+	taints := removeTaint(node.Spec.Taints, taintKey)
+	if len(taints) == len(node.Spec.Taints) {
+		return false, nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"resourceVersion": node.ResourceVersion,
+		},
+		"spec": map[string]interface{}{
+			"taints": taints,
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal the taint patch: %w", err)
+	}
+
+	if _, err := c.k8sClients.CoreV1().Nodes().Patch(ctx, nodeName, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return false, fmt.Errorf("failed to remove taint %q from node %q: %w", taintKey, nodeName, err)
+	}
+
+	return true, nil
+}
+
+// SetNodeCondition adds the condition to the node status, or replaces the condition of the same type.
+// The last transition time of the condition is kept if its status did not change.
+func (c *Clientset) SetNodeCondition(ctx context.Context, nodeName string, condition corev1.NodeCondition) error {
+	node, err := c.k8sClients.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get node %q: %w", nodeName, err)
+	}
+	condition.LastTransitionTime = transitionTime(node.Status.Conditions, condition)
+
+	// The node conditions are merged by type, so that the patch does not overwrite the conditions of kubelet.
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []corev1.NodeCondition{condition},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal the node condition patch: %w", err)
+	}
+
+	if _, err := c.k8sClients.CoreV1().Nodes().PatchStatus(ctx, nodeName, patch); err != nil {
+		return fmt.Errorf("failed to set condition %q of node %q: %w", condition.Type, nodeName, err)
+	}
+
+	return nil
+}
+
+// transitionTime returns the last transition time of the condition of the same type and status, or the heartbeat time of the condition.
+func transitionTime(conditions []corev1.NodeCondition, condition corev1.NodeCondition) metav1.Time {
+	for _, c := range conditions {
+		if c.Type == condition.Type && c.Status == condition.Status {
+			return c.LastTransitionTime
+		}
+	}
+
+	return condition.LastHeartbeatTime
+}
+
+func removeTaint(taints []corev1.Taint, taintKey string) []corev1.Taint {
+	remaining := []corev1.Taint{}
+	for _, t := range taints {
+		if t.Key != taintKey {
+			remaining = append(remaining, t)
+		}
+	}
+
+	return remaining
+}
@@ -19,16 +19,31 @@ package clientset
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/webhook"
 	authenticationv1 "k8s.io/api/authentication/v1"
 	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 type FakeClientset struct {
-	fakePod  *corev1.Pod
-	fakeNode *corev1.Node
+	fakePod            *corev1.Pod
+	fakeNode           *corev1.Node
+	fakePVCs           map[string]*corev1.PersistentVolumeClaim
+	fakePVs            []corev1.PersistentVolume
+	fakeStorageClasses map[string]*storagev1.StorageClass
+	Events             []string
+	ResizedContainers  map[string]corev1.ResourceRequirements
+	PodAnnotations     map[string]string
+	RegisteredDrivers  []string
+	// NamespaceUIDErr is returned by GetNamespaceUID, unless it is nil.
+	NamespaceUIDErr error
+	// RuntimeClassHandlers are the handlers that GetRuntimeClassHandler returns, keyed by the RuntimeClass name.
+	RuntimeClassHandlers map[string]string
 }
 
 func NewFakeClientset() *FakeClientset {
@@ -44,6 +59,8 @@ func (c *FakeClientset) ConfigurePodLister(_ string) {}
 
 func (c *FakeClientset) ConfigureNodeLister(_ string) {}
 
+func (c *FakeClientset) ConfigurePVLister() {}
+
 func (c *FakeClientset) CreatePod(hostNetworkEnabled bool) {
 	config := webhook.FakeConfig()
 	c.fakePod = &corev1.Pod{
@@ -94,6 +111,10 @@ func (c *FakeClientset) GetPod(namespace, name string) (*corev1.Pod, error) {
 	return c.fakePod, nil
 }
 
+func (c *FakeClientset) ListPods() ([]*corev1.Pod, error) {
+	return []*corev1.Pod{c.fakePod}, nil
+}
+
 func (c *FakeClientset) GetNode(name string) (*corev1.Node, error) {
 	c.fakeNode.ObjectMeta.Name = name
 
@@ -107,3 +128,124 @@ func (c *FakeClientset) CreateServiceAccountToken(_ context.Context, _, _ string
 func (c *FakeClientset) GetGCPServiceAccountName(_ context.Context, _, _ string) (string, error) {
 	return "", nil
 }
+
+func (c *FakeClientset) CreatePVC(pvc *corev1.PersistentVolumeClaim) {
+	if c.fakePVCs == nil {
+		c.fakePVCs = map[string]*corev1.PersistentVolumeClaim{}
+	}
+	c.fakePVCs[pvc.Namespace+"/"+pvc.Name] = pvc
+}
+
+func (c *FakeClientset) GetPVC(_ context.Context, namespace, name string) (*corev1.PersistentVolumeClaim, error) {
+	if pvc, ok := c.fakePVCs[namespace+"/"+name]; ok {
+		return pvc, nil
+	}
+
+	return nil, apierrors.NewNotFound(corev1.Resource("persistentvolumeclaims"), name)
+}
+
+func (c *FakeClientset) CreateStorageClass(sc *storagev1.StorageClass) {
+	if c.fakeStorageClasses == nil {
+		c.fakeStorageClasses = map[string]*storagev1.StorageClass{}
+	}
+	c.fakeStorageClasses[sc.Name] = sc
+}
+
+func (c *FakeClientset) GetStorageClass(_ context.Context, name string) (*storagev1.StorageClass, error) {
+	if sc, ok := c.fakeStorageClasses[name]; ok {
+		return sc, nil
+	}
+
+	return nil, apierrors.NewNotFound(storagev1.Resource("storageclasses"), name)
+}
+
+func (c *FakeClientset) CreatePV(pv *corev1.PersistentVolume) {
+	c.fakePVs = append(c.fakePVs, *pv)
+}
+
+func (c *FakeClientset) ListPVs(_ context.Context) ([]corev1.PersistentVolume, error) {
+	return c.fakePVs, nil
+}
+
+func (c *FakeClientset) GetPVByVolumeHandle(driverName, volumeHandle string) (*corev1.PersistentVolume, error) {
+	for i := range c.fakePVs {
+		if source := c.fakePVs[i].Spec.CSI; source != nil && source.Driver == driverName && source.VolumeHandle == volumeHandle {
+			return &c.fakePVs[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (c *FakeClientset) GetRuntimeClassHandler(_ context.Context, name string) (string, error) {
+	handler, ok := c.RuntimeClassHandlers[name]
+	if !ok {
+		return "", fmt.Errorf("runtimeclass %q not found", name)
+	}
+
+	return handler, nil
+}
+
+func (c *FakeClientset) GetNamespaceUID(_ context.Context, name string) (string, error) {
+	if c.NamespaceUIDErr != nil {
+		return "", c.NamespaceUIDErr
+	}
+
+	return "fake-uid-" + name, nil
+}
+
+func (c *FakeClientset) Eventf(_ runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	c.Events = append(c.Events, eventType+" "+reason+" "+fmt.Sprintf(messageFmt, args...))
+}
+
+func (c *FakeClientset) ResizePodContainer(_ context.Context, _ *corev1.Pod, containerName string, _ bool, resources corev1.ResourceRequirements) error {
+	if c.ResizedContainers == nil {
+		c.ResizedContainers = map[string]corev1.ResourceRequirements{}
+	}
+	c.ResizedContainers[containerName] = resources
+
+	return nil
+}
+
+func (c *FakeClientset) AnnotatePod(_ context.Context, _ *corev1.Pod, annotations map[string]string) error {
+	if c.PodAnnotations == nil {
+		c.PodAnnotations = map[string]string{}
+	}
+	for k, v := range annotations {
+		c.PodAnnotations[k] = v
+	}
+
+	return nil
+}
+
+func (c *FakeClientset) IsDriverRegistered(_ context.Context, _, driverName string) (bool, error) {
+	for _, d := range c.RegisteredDrivers {
+		if d == driverName {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (c *FakeClientset) RemoveNodeTaint(_ context.Context, _, taintKey string) (bool, error) {
+	taints := removeTaint(c.fakeNode.Spec.Taints, taintKey)
+	removed := len(taints) != len(c.fakeNode.Spec.Taints)
+	c.fakeNode.Spec.Taints = taints
+
+	return removed, nil
+}
+
+func (c *FakeClientset) SetNodeCondition(_ context.Context, _ string, condition corev1.NodeCondition) error {
+	condition.LastTransitionTime = transitionTime(c.fakeNode.Status.Conditions, condition)
+	for i := range c.fakeNode.Status.Conditions {
+		if c.fakeNode.Status.Conditions[i].Type == condition.Type {
+			c.fakeNode.Status.Conditions[i] = condition
+
+			return nil
+		}
+	}
+	c.fakeNode.Status.Conditions = append(c.fakeNode.Status.Conditions, condition)
+
+	return nil
+}
@@ -19,17 +19,23 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"strings"
 
 	"cloud.google.com/go/storage"
 	"golang.org/x/oauth2"
 )
 
+var errFoldersNotSupported = errors.New("folders are only supported in buckets with hierarchical namespace enabled")
+
 type fakeService struct {
 	sm fakeServiceManager
 }
 
 type fakeServiceManager struct {
 	createdBuckets map[string]*ServiceBucket
+	// createdFolders are the folders of the buckets with hierarchical namespace enabled, keyed by bucket name and folder name.
+	createdFolders map[string]bool
 }
 
 func (manager *fakeServiceManager) SetupService(_ context.Context, _ oauth2.TokenSource) (Service, error) {
@@ -41,16 +47,18 @@ func (manager *fakeServiceManager) SetupServiceWithDefaultCredential(_ context.C
 }
 
 func NewFakeServiceManager() ServiceManager {
-	return &fakeServiceManager{createdBuckets: map[string]*ServiceBucket{}}
+	return &fakeServiceManager{createdBuckets: map[string]*ServiceBucket{}, createdFolders: map[string]bool{}}
 }
 
 func (service *fakeService) CreateBucket(_ context.Context, obj *ServiceBucket) (*ServiceBucket, error) {
 	sb := &ServiceBucket{
-		Project:   obj.Project,
-		Location:  obj.Location,
-		Name:      obj.Name,
-		SizeBytes: obj.SizeBytes,
-		Labels:    obj.Labels,
+		Project:                     obj.Project,
+		Location:                    obj.Location,
+		Name:                        obj.Name,
+		SizeBytes:                   obj.SizeBytes,
+		Labels:                      obj.Labels,
+		EnableHierarchicalNamespace: obj.EnableHierarchicalNamespace,
+		Created:                     obj.Created,
 	}
 
 	service.sm.createdBuckets[obj.Name] = sb
@@ -58,7 +66,9 @@ func (service *fakeService) CreateBucket(_ context.Context, obj *ServiceBucket)
 	return sb, nil
 }
 
-func (service *fakeService) DeleteBucket(_ context.Context, _ *ServiceBucket) error {
+func (service *fakeService) DeleteBucket(_ context.Context, obj *ServiceBucket) error {
+	delete(service.sm.createdBuckets, obj.Name)
+
 	return nil
 }
 
@@ -70,6 +80,17 @@ func (service *fakeService) GetBucket(_ context.Context, obj *ServiceBucket) (*S
 	return nil, storage.ErrBucketNotExist
 }
 
+func (service *fakeService) ListBuckets(_ context.Context, projectID string) ([]*ServiceBucket, error) {
+	buckets := []*ServiceBucket{}
+	for _, sb := range service.sm.createdBuckets {
+		if sb.Project == projectID {
+			buckets = append(buckets, sb)
+		}
+	}
+
+	return buckets, nil
+}
+
 func (service *fakeService) SetIAMPolicy(_ context.Context, _ *ServiceBucket, _, _ string) error {
 	return nil
 }
@@ -78,6 +99,89 @@ func (service *fakeService) RemoveIAMPolicy(_ context.Context, _ *ServiceBucket,
 	return nil
 }
 
+func (service *fakeService) SetPrefixIAMPolicy(_ context.Context, obj *ServiceBucket, _, _, _ string) error {
+	if _, ok := service.sm.createdBuckets[obj.Name]; !ok {
+		return storage.ErrBucketNotExist
+	}
+
+	return nil
+}
+
+func (service *fakeService) RemovePrefixIAMPolicy(_ context.Context, obj *ServiceBucket, _ string) error {
+	if _, ok := service.sm.createdBuckets[obj.Name]; !ok {
+		return storage.ErrBucketNotExist
+	}
+
+	return nil
+}
+
+func (service *fakeService) DeleteObjects(_ context.Context, obj *ServiceBucket, _ string) error {
+	if _, ok := service.sm.createdBuckets[obj.Name]; !ok {
+		return storage.ErrBucketNotExist
+	}
+
+	return nil
+}
+
+func (service *fakeService) CreateDirObject(_ context.Context, obj *ServiceBucket, _ string) error {
+	if _, ok := service.sm.createdBuckets[obj.Name]; !ok {
+		return storage.ErrBucketNotExist
+	}
+
+	return nil
+}
+
+func (service *fakeService) CreateFolder(_ context.Context, obj *ServiceBucket, folder string) error {
+	sb, ok := service.sm.createdBuckets[obj.Name]
+	if !ok {
+		return storage.ErrBucketNotExist
+	}
+	if !sb.EnableHierarchicalNamespace {
+		return errFoldersNotSupported
+	}
+
+	service.sm.createdFolders[obj.Name+"/"+folder] = true
+
+	return nil
+}
+
+func (service *fakeService) DeleteFolder(_ context.Context, obj *ServiceBucket, folder string) error {
+	if _, ok := service.sm.createdBuckets[obj.Name]; !ok {
+		return storage.ErrBucketNotExist
+	}
+
+	for f := range service.sm.createdFolders {
+		if strings.HasPrefix(f, obj.Name+"/"+folder) {
+			delete(service.sm.createdFolders, f)
+		}
+	}
+
+	return nil
+}
+
+func (service *fakeService) RenameFolder(_ context.Context, obj *ServiceBucket, src, dst string) error {
+	if _, ok := service.sm.createdBuckets[obj.Name]; !ok {
+		return storage.ErrBucketNotExist
+	}
+
+	if !service.sm.createdFolders[obj.Name+"/"+src] {
+		return storage.ErrObjectNotExist
+	}
+
+	renamed := map[string]string{}
+	for f := range service.sm.createdFolders {
+		if rest, ok := strings.CutPrefix(f, obj.Name+"/"+src); ok {
+			renamed[f] = obj.Name + "/" + dst + rest
+		}
+	}
+	for from, to := range renamed {
+		delete(service.sm.createdFolders, from)
+		service.sm.createdFolders[to] = true
+	}
+
+	return nil
+}
+
 func (service *fakeService) CheckBucketExists(_ context.Context, obj *ServiceBucket) (bool, error) {
 	if _, ok := service.sm.createdBuckets[obj.Name]; ok {
 		return true, nil
@@ -86,5 +190,50 @@ func (service *fakeService) CheckBucketExists(_ context.Context, obj *ServiceBuc
 	return false, storage.ErrBucketNotExist
 }
 
+func (service *fakeService) CopyObjects(_ context.Context, src, dst *ServiceBucket, _ string) error {
+	if _, ok := service.sm.createdBuckets[src.Name]; !ok {
+		return storage.ErrBucketNotExist
+	}
+
+	if _, ok := service.sm.createdBuckets[dst.Name]; !ok {
+		return storage.ErrBucketNotExist
+	}
+
+	return nil
+}
+
+func (service *fakeService) FindImplicitDir(_ context.Context, obj *ServiceBucket, _ string, _ int) (string, bool, error) {
+	if _, ok := service.sm.createdBuckets[obj.Name]; !ok {
+		return "", false, storage.ErrBucketNotExist
+	}
+
+	return "", false, nil
+}
+
+func (service *fakeService) GetObjectUsage(_ context.Context, obj *ServiceBucket, _ string, _ int) (*ObjectUsage, error) {
+	if _, ok := service.sm.createdBuckets[obj.Name]; !ok {
+		return nil, storage.ErrBucketNotExist
+	}
+
+	return &ObjectUsage{Complete: true}, nil
+}
+
+func (service *fakeService) IsHierarchicalNamespaceEnabled(_ context.Context, obj *ServiceBucket, _ string) (bool, error) {
+	sb, ok := service.sm.createdBuckets[obj.Name]
+	if !ok {
+		return false, storage.ErrBucketNotExist
+	}
+
+	return sb.EnableHierarchicalNamespace, nil
+}
+
+func (service *fakeService) VerifyRead(_ context.Context, obj *ServiceBucket, _, _ string) error {
+	if _, ok := service.sm.createdBuckets[obj.Name]; !ok {
+		return storage.ErrBucketNotExist
+	}
+
+	return nil
+}
+
 func (service *fakeService) Close() {
 }
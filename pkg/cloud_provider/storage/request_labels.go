@@ -0,0 +1,93 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// userAgentProduct is the product token of the User-Agent of the requests that the CSI driver sends to Cloud Storage.
+	userAgentProduct = "gcs-fuse-csi-driver"
+	// customAuditHeaderPrefix is the prefix of the request headers that Cloud Storage records in the Data Access audit logs.
+	customAuditHeaderPrefix = "x-goog-custom-audit-"
+	// maxCustomAuditHeaders is the number of custom audit headers that Cloud Storage accepts on a request.
+	maxCustomAuditHeaders = 4
+)
+
+// RequestLabels describe the CSI driver in the requests that it sends to Cloud Storage,
+// so that the access logs and the audit logs attribute the requests to the driver version and features.
+type RequestLabels struct {
+	// UserAgent is prepended to the User-Agent of the requests. Empty keeps the User-Agent of the client libraries.
+	UserAgent string
+	// CustomAuditInfo is sent as x-goog-custom-audit-<key> headers, which Cloud Storage records in the Data Access audit logs.
+	CustomAuditInfo map[string]string
+}
+
+// UserAgent returns the User-Agent of the requests of a CSI driver version, running in a cluster with features enabled.
+func UserAgent(version, cluster string, features []string) string {
+	comments := []string{}
+	if cluster != "" {
+		comments = append(comments, "cluster:"+cluster)
+	}
+	if len(features) > 0 {
+		comments = append(comments, "features:"+strings.Join(features, ","))
+	}
+
+	userAgent := userAgentProduct + "/" + version
+	if len(comments) > 0 {
+		userAgent += " (" + strings.Join(comments, "; ") + ")"
+	}
+
+	return userAgent
+}
+
+// headers returns the custom audit headers of the labels.
+func (l RequestLabels) headers() (http.Header, error) {
+	if len(l.CustomAuditInfo) > maxCustomAuditHeaders {
+		return nil, fmt.Errorf("got %d custom audit entries, Cloud Storage accepts at most %d", len(l.CustomAuditInfo), maxCustomAuditHeaders)
+	}
+
+	headers := http.Header{}
+	for k, v := range l.CustomAuditInfo {
+		headers.Set(customAuditHeaderPrefix+k, v)
+	}
+
+	return headers, nil
+}
+
+// labelingTransport adds the User-Agent and the custom audit headers of the CSI driver to the requests.
+type labelingTransport struct {
+	base      http.RoundTripper
+	userAgent string
+	headers   http.Header
+}
+
+func (t *labelingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if t.userAgent != "" {
+		req.Header.Set("User-Agent", strings.TrimSpace(t.userAgent+" "+req.Header.Get("User-Agent")))
+	}
+	for k, v := range t.headers {
+		req.Header[k] = v
+	}
+
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUserAgent(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name     string
+		cluster  string
+		features []string
+		expected string
+	}{
+		{
+			name:     "version only",
+			expected: "gcs-fuse-csi-driver/v1.2.3",
+		},
+		{
+			name:     "cluster",
+			cluster:  "test-cluster",
+			expected: "gcs-fuse-csi-driver/v1.2.3 (cluster:test-cluster)",
+		},
+		{
+			name:     "cluster and features",
+			cluster:  "test-cluster",
+			features: []string{"audit-log", "config-hash"},
+			expected: "gcs-fuse-csi-driver/v1.2.3 (cluster:test-cluster; features:audit-log,config-hash)",
+		},
+	}
+
+	for _, tc := range cases {
+		if got := UserAgent("v1.2.3", tc.cluster, tc.features); got != tc.expected {
+			t.Errorf("%v: got User-Agent %q, expected %q", tc.name, got, tc.expected)
+		}
+	}
+}
+
+func TestNewGCSServiceManagerCustomAuditInfo(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewGCSServiceManager("", RequestLabels{CustomAuditInfo: map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"}}); err != nil {
+		t.Errorf("expected 4 custom audit entries to be accepted, got error: %v", err)
+	}
+	if _, err := NewGCSServiceManager("", RequestLabels{CustomAuditInfo: map[string]string{"a": "1", "b": "2", "c": "3", "d": "4", "e": "5"}}); err == nil {
+		t.Error("expected an error for 5 custom audit entries")
+	}
+}
+
+func TestLabelingTransport(t *testing.T) {
+	t.Parallel()
+
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer server.Close()
+
+	m, err := NewGCSServiceManager("", RequestLabels{UserAgent: "gcs-fuse-csi-driver/v1.2.3", CustomAuditInfo: map[string]string{"ticket": "abc-123"}})
+	if err != nil {
+		t.Fatalf("failed to create the service manager: %v", err)
+	}
+	manager, _ := m.(*gcsServiceManager)
+	client := manager.labelClient(&http.Client{})
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create the request: %v", err)
+	}
+	req.Header.Set("User-Agent", "google-api-go-client/0.5")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("failed to send the request: %v", err)
+	}
+	resp.Body.Close()
+
+	if ua := got.Get("User-Agent"); ua != "gcs-fuse-csi-driver/v1.2.3 google-api-go-client/0.5" {
+		t.Errorf("got User-Agent %q", ua)
+	}
+	if v := got.Get("X-Goog-Custom-Audit-Ticket"); v != "abc-123" {
+		t.Errorf("got custom audit header %q, expected %q", v, "abc-123")
+	}
+	if req.Header.Get("User-Agent") != "google-api-go-client/0.5" {
+		t.Errorf("the transport modified the original request")
+	}
+}
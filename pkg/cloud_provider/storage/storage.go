@@ -21,15 +21,25 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"cloud.google.com/go/iam"
+	"cloud.google.com/go/iam/apiv1/iampb"
 	"cloud.google.com/go/storage"
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+	storagev1 "google.golang.org/api/storage/v1"
+	"google.golang.org/genproto/googleapis/type/expr"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 )
@@ -42,15 +52,41 @@ type ServiceBucket struct {
 	Labels                         map[string]string
 	EnableUniformBucketLevelAccess bool
 	EnableHierarchicalNamespace    bool
+	EnableRequesterPays            bool
+	// BillingProject is the project billed for the requests to a requester pays bucket.
+	BillingProject string
+	// Created is the time the bucket was created. It is only set for existing buckets.
+	Created time.Time
+}
+
+// ObjectUsage is the number and the total size of the objects under a prefix of a bucket.
+type ObjectUsage struct {
+	Objects int64
+	Bytes   int64
+	// Complete is false if there are more objects under the prefix than were counted.
+	Complete bool
 }
 
 type Service interface {
 	CreateBucket(ctx context.Context, b *ServiceBucket) (*ServiceBucket, error)
 	GetBucket(ctx context.Context, b *ServiceBucket) (*ServiceBucket, error)
+	ListBuckets(ctx context.Context, projectID string) ([]*ServiceBucket, error)
 	DeleteBucket(ctx context.Context, b *ServiceBucket) error
 	SetIAMPolicy(ctx context.Context, obj *ServiceBucket, member, roleName string) error
 	RemoveIAMPolicy(ctx context.Context, obj *ServiceBucket, member, roleName string) error
+	SetPrefixIAMPolicy(ctx context.Context, obj *ServiceBucket, prefix, member, roleName string) error
+	RemovePrefixIAMPolicy(ctx context.Context, obj *ServiceBucket, prefix string) error
+	DeleteObjects(ctx context.Context, obj *ServiceBucket, prefix string) error
+	CreateDirObject(ctx context.Context, obj *ServiceBucket, dir string) error
+	CreateFolder(ctx context.Context, obj *ServiceBucket, folder string) error
+	DeleteFolder(ctx context.Context, obj *ServiceBucket, folder string) error
+	RenameFolder(ctx context.Context, obj *ServiceBucket, src, dst string) error
 	CheckBucketExists(ctx context.Context, obj *ServiceBucket) (bool, error)
+	CopyObjects(ctx context.Context, src, dst *ServiceBucket, prefix string) error
+	FindImplicitDir(ctx context.Context, obj *ServiceBucket, prefix string, maxObjects int) (string, bool, error)
+	GetObjectUsage(ctx context.Context, obj *ServiceBucket, prefix string, maxObjects int) (*ObjectUsage, error)
+	IsHierarchicalNamespaceEnabled(ctx context.Context, obj *ServiceBucket, prefix string) (bool, error)
+	VerifyRead(ctx context.Context, obj *ServiceBucket, prefix, object string) error
 	Close()
 }
 
@@ -61,12 +97,75 @@ type ServiceManager interface {
 
 type gcsService struct {
 	storageClient *storage.Client
+	// rawService calls the JSON API methods that the storage client does not support.
+	rawService *storagev1.Service
 }
 
-type gcsServiceManager struct{}
+// Names of the Google APIs endpoints that the storage clients and gcsfuse reach Cloud Storage through.
+const (
+	// EndpointDefault is storage.googleapis.com.
+	EndpointDefault = "default"
+	// EndpointPrivate is private.googleapis.com, which is routed through Private Google Access.
+	EndpointPrivate = "private"
+	// EndpointRestricted is restricted.googleapis.com, which only serves the APIs supported by VPC Service Controls.
+	EndpointRestricted = "restricted"
+)
 
-func NewGCSServiceManager() (ServiceManager, error) {
-	return &gcsServiceManager{}, nil
+// EndpointURL returns the URL of the Cloud Storage JSON API on the named endpoint,
+// or empty string for the default endpoint, which the storage clients use without configuration.
+func EndpointURL(endpoint string) (string, error) {
+	switch endpoint {
+	case "", EndpointDefault:
+		return "", nil
+	case EndpointPrivate, EndpointRestricted:
+		return "https://" + endpoint + ".googleapis.com/storage/v1/", nil
+	default:
+		return "", fmt.Errorf("unknown storage endpoint %q, expected %q, %q, or %q", endpoint, EndpointDefault, EndpointPrivate, EndpointRestricted)
+	}
+}
+
+type gcsServiceManager struct {
+	// endpointURL is the URL of the Cloud Storage JSON API. Empty uses the default endpoint.
+	endpointURL string
+	// userAgent and headers label the requests of the storage clients.
+	userAgent string
+	headers   http.Header
+}
+
+func NewGCSServiceManager(endpointURL string, labels RequestLabels) (ServiceManager, error) {
+	headers, err := labels.headers()
+	if err != nil {
+		return nil, fmt.Errorf("invalid custom audit info: %w", err)
+	}
+
+	return &gcsServiceManager{endpointURL: endpointURL, userAgent: labels.UserAgent, headers: headers}, nil
+}
+
+// labeled returns whether the requests of the storage clients are labeled.
+func (manager *gcsServiceManager) labeled() bool {
+	return manager.userAgent != "" || len(manager.headers) > 0
+}
+
+// labelClient makes the client label its requests with the User-Agent and the custom audit headers of the manager.
+func (manager *gcsServiceManager) labelClient(client *http.Client) *http.Client {
+	if manager.labeled() {
+		base := client.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		client.Transport = &labelingTransport{base: base, userAgent: manager.userAgent, headers: manager.headers}
+	}
+
+	return client
+}
+
+// clientOptions returns the options of the storage clients that send the requests to the configured endpoint.
+func (manager *gcsServiceManager) clientOptions(opts ...option.ClientOption) []option.ClientOption {
+	if manager.endpointURL != "" {
+		opts = append(opts, option.WithEndpoint(manager.endpointURL))
+	}
+
+	return opts
 }
 
 func (manager *gcsServiceManager) SetupService(ctx context.Context, ts oauth2.TokenSource) (Service, error) {
@@ -82,33 +181,58 @@ func (manager *gcsServiceManager) SetupService(ctx context.Context, ts oauth2.To
 		return nil, err
 	}
 
-	client := oauth2.NewClient(ctx, ts)
-	storageClient, err := storage.NewClient(ctx, option.WithHTTPClient(client))
+	client := manager.labelClient(oauth2.NewClient(ctx, ts))
+	storageClient, err := storage.NewClient(ctx, manager.clientOptions(option.WithHTTPClient(client))...)
+	if err != nil {
+		return nil, err
+	}
+
+	rawService, err := storagev1.NewService(ctx, manager.clientOptions(option.WithHTTPClient(client))...)
 	if err != nil {
+		storageClient.Close()
+
 		return nil, err
 	}
 
-	return &gcsService{storageClient: storageClient}, nil
+	return &gcsService{storageClient: storageClient, rawService: rawService}, nil
 }
 
 func (manager *gcsServiceManager) SetupServiceWithDefaultCredential(ctx context.Context) (Service, error) {
-	storageClient, err := storage.NewClient(ctx)
+	opts := []option.ClientOption{}
+	// The labels are added by the transport of the HTTP client, so the client is created from the default credentials.
+	if manager.labeled() {
+		client, err := google.DefaultClient(ctx, storage.ScopeFullControl)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create a client with the default credentials: %w", err)
+		}
+		opts = append(opts, option.WithHTTPClient(manager.labelClient(client)))
+	}
+
+	storageClient, err := storage.NewClient(ctx, manager.clientOptions(opts...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	rawService, err := storagev1.NewService(ctx, manager.clientOptions(opts...)...)
 	if err != nil {
+		storageClient.Close()
+
 		return nil, err
 	}
 
-	return &gcsService{storageClient: storageClient}, nil
+	return &gcsService{storageClient: storageClient, rawService: rawService}, nil
 }
 
 func (service *gcsService) CreateBucket(ctx context.Context, obj *ServiceBucket) (*ServiceBucket, error) {
 	klog.V(4).Infof("Creating bucket %q: project %q, location %q", obj.Name, obj.Project, obj.Location)
 	// Create the bucket
-	bkt := service.storageClient.Bucket(obj.Name)
+	bkt := service.bucketHandle(obj)
 	bktAttrs := &storage.BucketAttrs{
 		Location:                 obj.Location,
 		Labels:                   obj.Labels,
 		UniformBucketLevelAccess: storage.UniformBucketLevelAccess{Enabled: obj.EnableUniformBucketLevelAccess},
 		HierarchicalNamespace:    &storage.HierarchicalNamespace{Enabled: obj.EnableHierarchicalNamespace},
+		RequesterPays:            obj.EnableRequesterPays,
 	}
 	if err := bkt.Create(ctx, obj.Project, bktAttrs); err != nil {
 		return nil, fmt.Errorf("CreateBucket operation failed for bucket %q: %w", obj.Name, err)
@@ -135,7 +259,7 @@ func (service *gcsService) DeleteBucket(ctx context.Context, obj *ServiceBucket)
 	}
 
 	// Delete all objects in the bucket first
-	bkt := service.storageClient.Bucket(obj.Name)
+	bkt := service.bucketHandle(obj)
 	it := bkt.Objects(ctx, nil)
 	for {
 		attrs, err := it.Next()
@@ -160,7 +284,7 @@ func (service *gcsService) DeleteBucket(ctx context.Context, obj *ServiceBucket)
 }
 
 func (service *gcsService) GetBucket(ctx context.Context, obj *ServiceBucket) (*ServiceBucket, error) {
-	bkt := service.storageClient.Bucket(obj.Name)
+	bkt := service.bucketHandle(obj)
 	attrs, err := bkt.Attrs(ctx)
 	if err != nil {
 		klog.Errorf("Failed to get bucket %q: %v", obj.Name, err)
@@ -175,8 +299,31 @@ func (service *gcsService) GetBucket(ctx context.Context, obj *ServiceBucket) (*
 	return nil, fmt.Errorf("failed to get bucket %q: got empty attrs", obj.Name)
 }
 
+// ListBuckets returns the buckets in the project.
+func (service *gcsService) ListBuckets(ctx context.Context, projectID string) ([]*ServiceBucket, error) {
+	buckets := []*ServiceBucket{}
+	it := service.storageClient.Buckets(ctx, projectID)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate next bucket: %w", err)
+		}
+
+		bucket, err := cloudBucketToServiceBucket(attrs)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets, nil
+}
+
 func (service *gcsService) CheckBucketExists(ctx context.Context, obj *ServiceBucket) (bool, error) {
-	bkt := service.storageClient.Bucket(obj.Name)
+	bkt := service.bucketHandle(obj)
 	_, err := bkt.Objects(ctx, &storage.Query{Prefix: ""}).Next()
 
 	if err == nil || errors.Is(err, iterator.Done) {
@@ -186,8 +333,195 @@ func (service *gcsService) CheckBucketExists(ctx context.Context, obj *ServiceBu
 	return false, err
 }
 
+// CopyObjects copies the objects under prefix in the src bucket to the root of the dst bucket.
+// The prefix is a directory, so a prefix without a trailing slash gets one.
+// Objects that already exist in the dst bucket are skipped, so an interrupted copy can be resumed by calling it again.
+func (service *gcsService) CopyObjects(ctx context.Context, src, dst *ServiceBucket, prefix string) error {
+	if prefix = strings.TrimPrefix(prefix, "/"); prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	klog.V(4).Infof("Copying objects with prefix %q from bucket %q to bucket %q", prefix, src.Name, dst.Name)
+	srcBkt := service.bucketHandle(src)
+	dstBkt := service.bucketHandle(dst)
+
+	var copied, skipped int
+	it := srcBkt.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list objects in bucket %q: %w", src.Name, err)
+		}
+
+		dstName := strings.TrimPrefix(attrs.Name, prefix)
+		if dstName == "" {
+			continue
+		}
+
+		dstObj := dstBkt.Object(dstName)
+		_, err = dstObj.Attrs(ctx)
+		if err == nil {
+			skipped++
+
+			continue
+		}
+		if !errors.Is(err, storage.ErrObjectNotExist) {
+			return fmt.Errorf("failed to get object %q in bucket %q: %w", dstName, dst.Name, err)
+		}
+
+		srcObj := srcBkt.Object(attrs.Name)
+		if _, err := dstObj.CopierFrom(srcObj).Run(ctx); err != nil {
+			return fmt.Errorf("failed to copy object %q from bucket %q to bucket %q: %w", attrs.Name, src.Name, dst.Name, err)
+		}
+		copied++
+	}
+
+	klog.V(4).Infof("Copied %v objects from bucket %q to bucket %q, skipped %v existing objects", copied, src.Name, dst.Name, skipped)
+
+	return nil
+}
+
+// FindImplicitDir returns the name of the first directory under prefix that contains objects
+// but has no directory placeholder object, which gcsfuse only lists with the implicit-dirs flag.
+// At most maxObjects objects are listed, so a large bucket is not fully scanned.
+func (service *gcsService) FindImplicitDir(ctx context.Context, obj *ServiceBucket, prefix string, maxObjects int) (string, bool, error) {
+	q := &storage.Query{Prefix: prefix}
+	if err := q.SetAttrSelection([]string{"Name"}); err != nil {
+		return "", false, fmt.Errorf("failed to set the query attributes: %w", err)
+	}
+
+	finder := newImplicitDirFinder(prefix)
+	it := service.bucketHandle(obj).Objects(ctx, q)
+	for i := 0; i < maxObjects; i++ {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return "", false, fmt.Errorf("failed to iterate next object: %w", err)
+		}
+
+		if dir, ok := finder.add(attrs.Name); ok {
+			return dir, true, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+// GetObjectUsage counts the objects under prefix and their total size.
+// At most maxObjects objects are counted, so a large bucket is not fully scanned.
+func (service *gcsService) GetObjectUsage(ctx context.Context, obj *ServiceBucket, prefix string, maxObjects int) (*ObjectUsage, error) {
+	q := &storage.Query{Prefix: prefix}
+	if err := q.SetAttrSelection([]string{"Name", "Size"}); err != nil {
+		return nil, fmt.Errorf("failed to set the query attributes: %w", err)
+	}
+
+	usage := &ObjectUsage{Complete: true}
+	it := service.bucketHandle(obj).Objects(ctx, q)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate next object: %w", err)
+		}
+
+		if usage.Objects >= int64(maxObjects) {
+			usage.Complete = false
+
+			break
+		}
+		usage.Objects++
+		usage.Bytes += attrs.Size
+	}
+
+	return usage, nil
+}
+
+// IsHierarchicalNamespaceEnabled returns whether the bucket has hierarchical namespace enabled.
+// It gets the storage layout of the bucket, which only requires permission to list the objects under prefix,
+// instead of the bucket metadata, which workload identities usually cannot read.
+func (service *gcsService) IsHierarchicalNamespaceEnabled(ctx context.Context, obj *ServiceBucket, prefix string) (bool, error) {
+	call := service.rawService.Buckets.GetStorageLayout(obj.Name).Context(ctx)
+	if prefix != "" {
+		call = call.Prefix(prefix)
+	}
+
+	layout, err := call.Do()
+	if err != nil {
+		return false, err
+	}
+
+	return layout.HierarchicalNamespace != nil && layout.HierarchicalNamespace.Enabled, nil
+}
+
+// VerifyRead reads the first byte of object to verify that it can be read, or if object is empty,
+// lists the first object under prefix, which only verifies that the objects can be listed.
+func (service *gcsService) VerifyRead(ctx context.Context, obj *ServiceBucket, prefix, object string) error {
+	bkt := service.bucketHandle(obj)
+	if object != "" {
+		r, err := bkt.Object(object).NewRangeReader(ctx, 0, 1)
+		if err != nil {
+			return fmt.Errorf("failed to read object %q: %w", object, err)
+		}
+		defer r.Close()
+
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return fmt.Errorf("failed to read object %q: %w", object, err)
+		}
+
+		return nil
+	}
+
+	q := &storage.Query{Prefix: prefix, Delimiter: "/"}
+	if err := q.SetAttrSelection([]string{"Name"}); err != nil {
+		return fmt.Errorf("failed to set the query attributes: %w", err)
+	}
+	if _, err := bkt.Objects(ctx, q).Next(); err != nil && !errors.Is(err, iterator.Done) {
+		return fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	return nil
+}
+
+// implicitDirFinder finds implicit directories in object names listed in lexicographic order.
+// A directory placeholder object "dir/" is listed before the objects in the directory.
+type implicitDirFinder struct {
+	prefix string
+	dirs   map[string]bool
+}
+
+func newImplicitDirFinder(prefix string) *implicitDirFinder {
+	return &implicitDirFinder{prefix: prefix, dirs: map[string]bool{}}
+}
+
+// add returns the first parent directory of the object name that has no directory placeholder object.
+// The prefix itself does not need a placeholder object.
+func (f *implicitDirFinder) add(name string) (string, bool) {
+	rel := strings.TrimPrefix(name, f.prefix)
+	if strings.HasSuffix(rel, "/") {
+		f.dirs[rel] = true
+	}
+
+	for i := 0; i < len(rel)-1; i++ {
+		if rel[i] != '/' {
+			continue
+		}
+
+		if dir := rel[:i+1]; !f.dirs[dir] {
+			return f.prefix + dir, true
+		}
+	}
+
+	return "", false
+}
+
 func (service *gcsService) SetIAMPolicy(ctx context.Context, obj *ServiceBucket, member, roleName string) error {
-	bkt := service.storageClient.Bucket(obj.Name)
+	bkt := service.bucketHandle(obj)
 	policy, err := bkt.IAM().Policy(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get bucket %q IAM policy: %w", obj.Name, err)
@@ -202,7 +536,7 @@ func (service *gcsService) SetIAMPolicy(ctx context.Context, obj *ServiceBucket,
 }
 
 func (service *gcsService) RemoveIAMPolicy(ctx context.Context, obj *ServiceBucket, member, roleName string) error {
-	bkt := service.storageClient.Bucket(obj.Name)
+	bkt := service.bucketHandle(obj)
 	policy, err := bkt.IAM().Policy(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get bucket %q IAM policy: %w", obj.Name, err)
@@ -216,18 +550,232 @@ func (service *gcsService) RemoveIAMPolicy(ctx context.Context, obj *ServiceBuck
 	return nil
 }
 
+// SetPrefixIAMPolicy grants the role to the member on the objects under prefix, using an IAM condition.
+// IAM conditions require uniform bucket-level access to be enabled on the bucket.
+func (service *gcsService) SetPrefixIAMPolicy(ctx context.Context, obj *ServiceBucket, prefix, member, roleName string) error {
+	handle := service.bucketHandle(obj).IAM().V3()
+	policy, err := handle.Policy(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get bucket %q IAM policy: %w", obj.Name, err)
+	}
+
+	title := prefixIAMConditionTitle(prefix)
+	for _, b := range policy.Bindings {
+		if b.GetRole() == roleName && b.GetCondition().GetTitle() == title && slices.Contains(b.GetMembers(), member) {
+			return nil
+		}
+	}
+
+	policy.Bindings = append(policy.Bindings, &iampb.Binding{
+		Role:    roleName,
+		Members: []string{member},
+		Condition: &expr.Expr{
+			Title:      title,
+			Expression: prefixIAMConditionExpression(obj.Name, prefix),
+		},
+	})
+	if err := handle.SetPolicy(ctx, policy); err != nil {
+		return fmt.Errorf("failed to set bucket %q IAM policy: %w", obj.Name, err)
+	}
+
+	return nil
+}
+
+// RemovePrefixIAMPolicy removes the IAM bindings granted by SetPrefixIAMPolicy on the objects under prefix.
+func (service *gcsService) RemovePrefixIAMPolicy(ctx context.Context, obj *ServiceBucket, prefix string) error {
+	handle := service.bucketHandle(obj).IAM().V3()
+	policy, err := handle.Policy(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get bucket %q IAM policy: %w", obj.Name, err)
+	}
+
+	title := prefixIAMConditionTitle(prefix)
+	bindings := slices.DeleteFunc(slices.Clone(policy.Bindings), func(b *iampb.Binding) bool {
+		return b.GetCondition().GetTitle() == title
+	})
+	if len(bindings) == len(policy.Bindings) {
+		return nil
+	}
+
+	policy.Bindings = bindings
+	if err := handle.SetPolicy(ctx, policy); err != nil {
+		return fmt.Errorf("failed to set bucket %q IAM policy: %w", obj.Name, err)
+	}
+
+	return nil
+}
+
+// prefixIAMConditionTitle identifies the IAM bindings granted on an object prefix.
+func prefixIAMConditionTitle(prefix string) string {
+	return "gcsfuse-csi-prefix-" + prefix
+}
+
+// prefixIAMConditionExpression limits object access to the objects under prefix.
+// Object listing is checked against the list prefix instead of the object name, so only listing under prefix is allowed.
+func prefixIAMConditionExpression(bucketName, prefix string) string {
+	return fmt.Sprintf(`resource.name.startsWith("projects/_/buckets/%s/objects/%s") || api.getAttribute("storage.googleapis.com/objectListPrefix", "").startsWith("%s")`, bucketName, prefix, prefix)
+}
+
+// DeleteObjects deletes the objects under prefix.
+func (service *gcsService) DeleteObjects(ctx context.Context, obj *ServiceBucket, prefix string) error {
+	bkt := service.bucketHandle(obj)
+	q := &storage.Query{Prefix: prefix}
+	if err := q.SetAttrSelection([]string{"Name"}); err != nil {
+		return fmt.Errorf("failed to set the query attributes: %w", err)
+	}
+
+	it := bkt.Objects(ctx, q)
+	deleted := 0
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to iterate next object: %w", err)
+		}
+
+		if err := bkt.Object(attrs.Name).Delete(ctx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			return fmt.Errorf("failed to delete object %q in bucket %q: %w", attrs.Name, obj.Name, err)
+		}
+		deleted++
+	}
+
+	klog.V(4).Infof("Deleted %v objects with prefix %q in bucket %q", deleted, prefix, obj.Name)
+
+	return nil
+}
+
+// CreateDirObject creates the empty placeholder object of directory dir, which ends with a slash, unless the object already exists.
+func (service *gcsService) CreateDirObject(ctx context.Context, obj *ServiceBucket, dir string) error {
+	w := service.bucketHandle(obj).Object(dir).If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+	if err := w.Close(); err != nil {
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+			return nil
+		}
+
+		return fmt.Errorf("failed to create directory object %q in bucket %q: %w", dir, obj.Name, err)
+	}
+
+	return nil
+}
+
+// CreateFolder creates folder, which ends with a slash, and its missing parent folders in a bucket with hierarchical namespace enabled,
+// unless the folder already exists.
+func (service *gcsService) CreateFolder(ctx context.Context, obj *ServiceBucket, folder string) error {
+	_, err := service.rawService.Folders.Insert(obj.Name, &storagev1.Folder{Name: folder}).Recursive(true).Context(ctx).Do()
+	if err != nil {
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict {
+			return nil
+		}
+
+		return fmt.Errorf("failed to create folder %q in bucket %q: %w", folder, obj.Name, err)
+	}
+
+	return nil
+}
+
+// DeleteFolder deletes folder and its subfolders in a bucket with hierarchical namespace enabled.
+// The folders must not contain objects, so the objects under folder must be deleted first.
+func (service *gcsService) DeleteFolder(ctx context.Context, obj *ServiceBucket, folder string) error {
+	folders := []string{folder}
+	err := service.rawService.Folders.List(obj.Name).Prefix(folder).Context(ctx).Pages(ctx, func(page *storagev1.Folders) error {
+		for _, f := range page.Items {
+			if f.Name != folder {
+				folders = append(folders, f.Name)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list folders with prefix %q in bucket %q: %w", folder, obj.Name, err)
+	}
+
+	// A folder can only be deleted once its subfolders are deleted, so the deepest folders are deleted first.
+	slices.SortFunc(folders, func(a, b string) int {
+		return strings.Count(b, "/") - strings.Count(a, "/")
+	})
+	for _, f := range folders {
+		if err := service.rawService.Folders.Delete(obj.Name, f).Context(ctx).Do(); err != nil {
+			var apiErr *googleapi.Error
+			if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+				continue
+			}
+
+			return fmt.Errorf("failed to delete folder %q in bucket %q: %w", f, obj.Name, err)
+		}
+	}
+
+	klog.V(4).Infof("Deleted %v folders with prefix %q in bucket %q", len(folders), folder, obj.Name)
+
+	return nil
+}
+
+// renameFolderTimeout is how long RenameFolder waits for the rename operation, which moves all the objects in the folder.
+const renameFolderTimeout = 10 * time.Minute
+
+// RenameFolder renames folder src to dst in a bucket with hierarchical namespace enabled,
+// and waits for the long-running rename operation to finish.
+func (service *gcsService) RenameFolder(ctx context.Context, obj *ServiceBucket, src, dst string) error {
+	op, err := service.rawService.Folders.Rename(obj.Name, src, dst).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to rename folder %q to %q in bucket %q: %w", src, dst, obj.Name, err)
+	}
+
+	// The operation name is in the form of projects/_/buckets/<bucket>/operations/<operation-id>.
+	operationID := op.Name[strings.LastIndex(op.Name, "/")+1:]
+	err = wait.PollUntilContextTimeout(ctx, time.Second, renameFolderTimeout, true, func(ctx context.Context) (bool, error) {
+		if op.Done {
+			return true, nil
+		}
+
+		latest, err := service.rawService.Operations.Get(obj.Name, operationID).Context(ctx).Do()
+		if err != nil {
+			return false, err
+		}
+		op = latest
+
+		return op.Done, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to wait for the operation %q renaming folder %q to %q in bucket %q: %w", operationID, src, dst, obj.Name, err)
+	}
+
+	if op.Error != nil {
+		return fmt.Errorf("failed to rename folder %q to %q in bucket %q: %w", src, dst, obj.Name, status.Error(codes.Code(op.Error.Code), op.Error.Message)) //nolint:gosec
+	}
+
+	return nil
+}
+
 func (service *gcsService) Close() {
 	service.storageClient.Close()
 }
 
 func cloudBucketToServiceBucket(attrs *storage.BucketAttrs) (*ServiceBucket, error) {
 	return &ServiceBucket{
-		Location: attrs.Location,
-		Name:     attrs.Name,
-		Labels:   attrs.Labels,
+		Location:                    attrs.Location,
+		Name:                        attrs.Name,
+		Labels:                      attrs.Labels,
+		EnableHierarchicalNamespace: attrs.HierarchicalNamespace != nil && attrs.HierarchicalNamespace.Enabled,
+		EnableRequesterPays:         attrs.RequesterPays,
+		Created:                     attrs.Created,
 	}, nil
 }
 
+// bucketHandle returns the handle of the bucket, which bills the requests to the billing project if it is set.
+func (service *gcsService) bucketHandle(obj *ServiceBucket) *storage.BucketHandle {
+	bkt := service.storageClient.Bucket(obj.Name)
+	if obj.BillingProject != "" {
+		bkt = bkt.UserProject(obj.BillingProject)
+	}
+
+	return bkt
+}
+
 func CompareBuckets(a, b *ServiceBucket) error {
 	mismatches := []string{}
 	if a.Name != b.Name {
@@ -258,14 +806,66 @@ func isPermissionDeniedErr(err error) bool {
 	return strings.Contains(err.Error(), "googleapi: Error 403")
 }
 
+// vpcSCViolationMarkers are parts of the error messages of requests denied by a VPC Service Controls perimeter.
+// The GCS JSON API returns them with the vpcServiceControls reason, and the Security Token Service with the SECURITY_POLICY_VIOLATED reason.
+var vpcSCViolationMarkers = []string{
+	"vpcServiceControls",
+	"securityPolicyViolated",
+	"SECURITY_POLICY_VIOLATED",
+	"Request is prohibited by organization's policy",
+}
+
+// IsVPCSCViolationErr returns true if the request was denied by a VPC Service Controls perimeter.
+// The denial is a 403 error, so it is easily mistaken for missing IAM permissions.
+func IsVPCSCViolationErr(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := err.Error()
+	for _, marker := range vpcSCViolationMarkers {
+		if strings.Contains(msg, marker) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func isCanceledErr(err error) bool {
 	return strings.Contains(err.Error(), "context canceled") || strings.Contains(err.Error(), "context deadline exceeded")
 }
 
+// IsQuotaExhaustedErr returns true if the GCS request was rejected because a quota or rate limit was exceeded.
+func IsQuotaExhaustedErr(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusTooManyRequests {
+		return true
+	}
+
+	return status.Code(err) == codes.ResourceExhausted
+}
+
+// ErrorCode returns the HTTP status code of a GCS JSON API error, or the gRPC code of a GCS gRPC API error.
+func ErrorCode(err error) string {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return strconv.Itoa(apiErr.Code)
+	}
+	if IsNotExistErr(err) {
+		return strconv.Itoa(http.StatusNotFound)
+	}
+	if s, ok := status.FromError(err); ok {
+		return s.Code().String()
+	}
+
+	return "unknown"
+}
+
 // ParseErrCode parses error and returns a gRPC code.
 func ParseErrCode(err error) codes.Code {
 	code := codes.Internal
-	if IsNotExistErr(err) {
+	if IsNotExistErr(err) || errors.Is(err, storage.ErrObjectNotExist) {
 		code = codes.NotFound
 	}
 
@@ -18,10 +18,17 @@ limitations under the License.
 package storage
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
 	"strings"
 	"testing"
 
+	"cloud.google.com/go/storage"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestCompareBuckets(t *testing.T) {
@@ -93,3 +100,175 @@ func TestCompareBuckets(t *testing.T) {
 		}
 	}
 }
+
+func TestImplicitDirFinder(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name          string
+		prefix        string
+		objects       []string
+		expectedDir   string
+		expectedFound bool
+	}{
+		{
+			name:    "flat bucket",
+			objects: []string{"a.txt", "b.txt"},
+		},
+		{
+			name:    "directories with placeholder objects",
+			objects: []string{"a/", "a/b/", "a/b/c.txt", "a/d.txt"},
+		},
+		{
+			name:          "directory without placeholder object",
+			objects:       []string{"a.txt", "data/file.txt"},
+			expectedDir:   "data/",
+			expectedFound: true,
+		},
+		{
+			name:          "nested directory without placeholder object",
+			objects:       []string{"a/", "a/b/c.txt"},
+			expectedDir:   "a/b/",
+			expectedFound: true,
+		},
+		{
+			name:    "only-dir prefix without placeholder object",
+			prefix:  "tenant/",
+			objects: []string{"tenant/a.txt", "tenant/b/", "tenant/b/c.txt"},
+		},
+		{
+			name:          "directory under only-dir prefix without placeholder object",
+			prefix:        "tenant/",
+			objects:       []string{"tenant/a.txt", "tenant/b/c.txt"},
+			expectedDir:   "tenant/b/",
+			expectedFound: true,
+		},
+	}
+
+	for _, test := range cases {
+		finder := newImplicitDirFinder(test.prefix)
+		dir, found := "", false
+		for _, name := range test.objects {
+			if dir, found = finder.add(name); found {
+				break
+			}
+		}
+
+		if dir != test.expectedDir || found != test.expectedFound {
+			t.Errorf("test %q failed: got %q, %v, expected %q, %v", test.name, dir, found, test.expectedDir, test.expectedFound)
+		}
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name                string
+		err                 error
+		expectedCode        string
+		expectQuotaExceeded bool
+	}{
+		{
+			name:                "JSON API rate limit error",
+			err:                 fmt.Errorf("failed to create bucket: %w", &googleapi.Error{Code: http.StatusTooManyRequests}),
+			expectedCode:        "429",
+			expectQuotaExceeded: true,
+		},
+		{
+			name:         "JSON API permission error",
+			err:          &googleapi.Error{Code: http.StatusForbidden},
+			expectedCode: "403",
+		},
+		{
+			name:         "bucket not exist error",
+			err:          storage.ErrBucketNotExist,
+			expectedCode: "404",
+		},
+		{
+			name:                "gRPC API quota error",
+			err:                 status.Error(codes.ResourceExhausted, "quota exceeded"),
+			expectedCode:        "ResourceExhausted",
+			expectQuotaExceeded: true,
+		},
+		{
+			name:         "unknown error",
+			err:          errors.New("connection reset"),
+			expectedCode: "unknown",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Logf("test case: %s", tc.name)
+		if code := ErrorCode(tc.err); code != tc.expectedCode {
+			t.Errorf("got error code %q, expected %q", code, tc.expectedCode)
+		}
+		if quotaExceeded := IsQuotaExhaustedErr(tc.err); quotaExceeded != tc.expectQuotaExceeded {
+			t.Errorf("got quota exhausted %v, expected %v", quotaExceeded, tc.expectQuotaExceeded)
+		}
+	}
+}
+
+func TestEndpointURL(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		endpoint    string
+		expectedURL string
+		expectErr   bool
+	}{
+		{endpoint: ""},
+		{endpoint: EndpointDefault},
+		{endpoint: EndpointPrivate, expectedURL: "https://private.googleapis.com/storage/v1/"},
+		{endpoint: EndpointRestricted, expectedURL: "https://restricted.googleapis.com/storage/v1/"},
+		{endpoint: "storage.googleapis.com", expectErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Logf("test case: %q", tc.endpoint)
+		url, err := EndpointURL(tc.endpoint)
+		if (err != nil) != tc.expectErr {
+			t.Errorf("got error %v, expected error %v", err, tc.expectErr)
+		}
+		if url != tc.expectedURL {
+			t.Errorf("got URL %q, expected %q", url, tc.expectedURL)
+		}
+	}
+}
+
+func TestIsVPCSCViolationErr(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{
+			name: "JSON API perimeter denial",
+			err: fmt.Errorf("failed to list objects: %w", &googleapi.Error{
+				Code:    http.StatusForbidden,
+				Message: "Request is prohibited by organization's policy. vpcServiceControlsUniqueIdentifier: abc123",
+				Errors:  []googleapi.ErrorItem{{Reason: "vpcServiceControls"}},
+			}),
+			expected: true,
+		},
+		{
+			name:     "token exchange perimeter denial",
+			err:      errors.New(`oauth2: "access_denied" "Request is prohibited by organization's policy." details: SECURITY_POLICY_VIOLATED`),
+			expected: true,
+		},
+		{
+			name:     "IAM denial",
+			err:      &googleapi.Error{Code: http.StatusForbidden, Message: "caller does not have storage.objects.list access to the Google Cloud Storage bucket."},
+			expected: false,
+		},
+		{
+			name:     "no error",
+			expected: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Logf("test case: %s", tc.name)
+		if got := IsVPCSCViolationErr(tc.err); got != tc.expected {
+			t.Errorf("got %v, expected %v", got, tc.expected)
+		}
+	}
+}
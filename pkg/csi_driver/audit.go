@@ -0,0 +1,134 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AuditOperationMount   = "mount"
+	AuditOperationUnmount = "unmount"
+
+	// AuditSinkCloudLogging writes the audit records to the standard output in the structured format of Cloud Logging.
+	AuditSinkCloudLogging = "cloud-logging"
+	// AuditSinkFilePrefix is the prefix of the sinks that append the audit records to a file on the node.
+	AuditSinkFilePrefix = "file:"
+
+	// auditLogLabel is the Cloud Logging label of the audit records, so that they can be told apart from the driver logs.
+	auditLogLabel = DefaultName + "/audit"
+)
+
+// AuditRecord describes a volume mount or unmount on a node, so that security teams can track
+// which Pods and Kubernetes ServiceAccounts access which buckets.
+type AuditRecord struct {
+	Time           time.Time `json:"time"`
+	Operation      string    `json:"operation"`
+	Node           string    `json:"node"`
+	VolumeID       string    `json:"volumeID"`
+	TargetPath     string    `json:"targetPath"`
+	PodNamespace   string    `json:"podNamespace,omitempty"`
+	PodName        string    `json:"podName,omitempty"`
+	ServiceAccount string    `json:"serviceAccount,omitempty"`
+	BucketName     string    `json:"bucketName,omitempty"`
+	ReadOnly       bool      `json:"readOnly"`
+	MountOptions   []string  `json:"mountOptions,omitempty"`
+}
+
+// AuditSink writes the audit records of volume mounts and unmounts. Records are only appended, never rewritten.
+type AuditSink interface {
+	Write(record *AuditRecord) error
+}
+
+// NewAuditSink returns the audit sink of the --audit-log-sink flag value, either "cloud-logging" or "file:<path>".
+func NewAuditSink(sink string) (AuditSink, error) {
+	if sink == AuditSinkCloudLogging {
+		return &cloudLoggingAuditSink{w: os.Stdout}, nil
+	}
+
+	path, ok := strings.CutPrefix(sink, AuditSinkFilePrefix)
+	if !ok || path == "" {
+		return nil, fmt.Errorf("invalid audit log sink %q, must be either %q or %q followed by a file path", sink, AuditSinkCloudLogging, AuditSinkFilePrefix)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the audit log file %q: %w", path, err)
+	}
+
+	return &fileAuditSink{w: f}, nil
+}
+
+// fileAuditSink appends one JSON object per record to a file.
+type fileAuditSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *fileAuditSink) Write(record *AuditRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the audit record: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// A single write per record, so that the records of a file opened in append mode are never interleaved.
+	_, err = s.w.Write(append(b, '\n'))
+
+	return err
+}
+
+// cloudLoggingAuditEntry is a log entry in the structured format that the GKE logging agent parses into a Cloud Logging entry.
+type cloudLoggingAuditEntry struct {
+	Severity    string            `json:"severity"`
+	Message     string            `json:"message"`
+	Labels      map[string]string `json:"logging.googleapis.com/labels"` //nolint:tagliatelle
+	AuditRecord *AuditRecord      `json:"auditRecord"`
+}
+
+// cloudLoggingAuditSink writes the records to the standard output of the driver container, which the GKE logging agent
+// sends to Cloud Logging. The records have the audit label, so that a log sink can route them to a separate destination.
+type cloudLoggingAuditSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *cloudLoggingAuditSink) Write(record *AuditRecord) error {
+	b, err := json.Marshal(&cloudLoggingAuditEntry{
+		Severity:    "NOTICE",
+		Message:     fmt.Sprintf("Volume %q for bucket %q %sed at target path %q", record.VolumeID, record.BucketName, record.Operation, record.TargetPath),
+		Labels:      map[string]string{auditLogLabel: record.Operation},
+		AuditRecord: record,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal the audit record: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(b, '\n'))
+
+	return err
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewAuditSink(t *testing.T) {
+	t.Parallel()
+
+	for _, sink := range []string{"", "stdout", "file:", "/var/log/audit.log"} {
+		if _, err := NewAuditSink(sink); err == nil {
+			t.Errorf("expected an error for the audit log sink %q", sink)
+		}
+	}
+	if _, err := NewAuditSink(AuditSinkCloudLogging); err != nil {
+		t.Errorf("unexpected error for the audit log sink %q: %v", AuditSinkCloudLogging, err)
+	}
+}
+
+func TestFileAuditSink(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	// The records of a restarted driver are appended to the existing file.
+	for _, operation := range []string{AuditOperationMount, AuditOperationUnmount} {
+		sink, err := NewAuditSink(AuditSinkFilePrefix + path)
+		if err != nil {
+			t.Fatalf("failed to create the file audit sink: %v", err)
+		}
+		if err := sink.Write(&AuditRecord{Operation: operation, VolumeID: "test-volume", BucketName: "test-bucket"}); err != nil {
+			t.Fatalf("failed to write the %v audit record: %v", operation, err)
+		}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read the audit log file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d audit records, expected 2: %q", len(lines), content)
+	}
+	for i, operation := range []string{AuditOperationMount, AuditOperationUnmount} {
+		record := &AuditRecord{}
+		if err := json.Unmarshal([]byte(lines[i]), record); err != nil {
+			t.Fatalf("failed to unmarshal the audit record %q: %v", lines[i], err)
+		}
+		if record.Operation != operation || record.BucketName != "test-bucket" {
+			t.Errorf("unexpected audit record %+v, expected a %v record", record, operation)
+		}
+	}
+}
+
+func TestCloudLoggingAuditSink(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	sink := &cloudLoggingAuditSink{w: buf}
+	if err := sink.Write(&AuditRecord{Operation: AuditOperationMount, VolumeID: "test-volume", BucketName: "test-bucket", TargetPath: "/target"}); err != nil {
+		t.Fatalf("failed to write the audit record: %v", err)
+	}
+
+	entry := &cloudLoggingAuditEntry{}
+	if err := json.Unmarshal(buf.Bytes(), entry); err != nil {
+		t.Fatalf("failed to unmarshal the log entry %q: %v", buf.String(), err)
+	}
+	if entry.Severity != "NOTICE" || entry.Labels[auditLogLabel] != AuditOperationMount || entry.AuditRecord.BucketName != "test-bucket" {
+		t.Errorf("unexpected log entry %+v", entry)
+	}
+	if expected := `Volume "test-volume" for bucket "test-bucket" mounted at target path "/target"`; entry.Message != expected {
+		t.Errorf("got message %q, expected %q", entry.Message, expected)
+	}
+}
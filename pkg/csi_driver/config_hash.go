@@ -0,0 +1,89 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+)
+
+// configHashAnnotationPrefix is the prefix of the Pod annotations that hold the config hash of the Pod volumes,
+// followed by the driver name. The name of the annotation is the volume name, like the AppArmor annotations of containers.
+const configHashAnnotationPrefix = "config-hash."
+
+// configHash returns a hash of the resolved gcsfuse configuration of a volume, which is the same on all the nodes that
+// mount the volume with the same driver version.
+// The mount options that depend on the other volumes of the node, like the share of the node GCS operations budget,
+// are not part of the hash.
+func configHash(bucketName string, fuseMountOptions []string) string {
+	options := slices.Clone(fuseMountOptions)
+	slices.Sort(options)
+
+	h := sha256.New()
+	h.Write([]byte(bucketName))
+	for _, o := range options {
+		h.Write([]byte{0})
+		h.Write([]byte(o))
+	}
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// configHashAnnotationKey returns the key of the Pod annotation that holds the config hash of the volume at the target path.
+// The volume name is the PersistentVolume name, or the Pod volume name for CSI ephemeral volumes.
+func (s *nodeServer) configHashAnnotationKey(targetPath string) (string, bool) {
+	_, volumeName, err := util.ParsePodIDVolumeFromTargetpath(targetPath)
+	if err != nil {
+		return "", false
+	}
+	key := configHashAnnotationPrefix + s.driver.config.Name + "/" + volumeName
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		klog.V(4).InfoS("skipping the config hash annotation, because the volume name cannot be used in an annotation key", util.LogKeyTargetPath, targetPath, "errors", errs)
+
+		return "", false
+	}
+
+	return key, true
+}
+
+// publishConfigHash annotates the Pod with the config hash of the volume at the target path, so that fleet tooling can compare
+// the configuration of the nodes that mount the same PersistentVolume. Failures are logged and do not fail the mount.
+func (s *nodeServer) publishConfigHash(ctx context.Context, pod *corev1.Pod, targetPath, bucketName string, fuseMountOptions []string) {
+	if !s.driver.config.PublishConfigHash {
+		return
+	}
+	key, ok := s.configHashAnnotationKey(targetPath)
+	if !ok {
+		return
+	}
+	hash := configHash(bucketName, fuseMountOptions)
+	if pod.Annotations[key] == hash {
+		return
+	}
+
+	if err := s.k8sClients.AnnotatePod(ctx, pod, map[string]string{key: hash}); err != nil {
+		klog.Warningf("failed to publish the config hash of the volume at target path %q: %v", targetPath, err)
+	}
+}
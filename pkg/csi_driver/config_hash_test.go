@@ -0,0 +1,108 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/clientset"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestConfigHash(t *testing.T) {
+	t.Parallel()
+
+	hash := configHash("test-bucket", []string{"implicit-dirs", "uid=1001"})
+	if !strings.HasPrefix(hash, "sha256:") {
+		t.Errorf("got hash %q, want the sha256: prefix", hash)
+	}
+	if got := configHash("test-bucket", []string{"uid=1001", "implicit-dirs"}); got != hash {
+		t.Errorf("got hash %q for the reordered mount options, want %q", got, hash)
+	}
+	if got := configHash("other-bucket", []string{"implicit-dirs", "uid=1001"}); got == hash {
+		t.Errorf("got the same hash %q for another bucket", got)
+	}
+	if got := configHash("test-bucket", []string{"implicit-dirs", "uid=1002"}); got == hash {
+		t.Errorf("got the same hash %q for other mount options", got)
+	}
+	if got := configHash("test-bucket", []string{"implicit-dirsuid=1001"}); got == hash {
+		t.Errorf("got the same hash %q for joined mount options", got)
+	}
+}
+
+func TestPublishConfigHash(t *testing.T) {
+	t.Parallel()
+
+	const podTargetPath = "/var/lib/kubelet/pods/d2013878-3d56-45f9-89ec-0826612c89b6/volumes/kubernetes.io~csi/"
+	options := []string{"implicit-dirs"}
+	hash := configHash("test-bucket", options)
+
+	testCases := []struct {
+		name              string
+		disabled          bool
+		volumeName        string
+		podAnnotations    map[string]string
+		expectAnnotations map[string]string
+	}{
+		{
+			name:              "persistent volume",
+			volumeName:        "test-pv",
+			expectAnnotations: map[string]string{"config-hash.test-driver/test-pv": hash},
+		},
+		{
+			name:     "disabled",
+			disabled: true,
+		},
+		{
+			name:           "unchanged hash",
+			volumeName:     "test-pv",
+			podAnnotations: map[string]string{"config-hash.test-driver/test-pv": hash},
+		},
+		{
+			name:              "changed hash",
+			volumeName:        "test-pv",
+			podAnnotations:    map[string]string{"config-hash.test-driver/test-pv": "sha256:old"},
+			expectAnnotations: map[string]string{"config-hash.test-driver/test-pv": hash},
+		},
+		{
+			name:       "volume name too long for an annotation key",
+			volumeName: strings.Repeat("v", 64),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fakeClientset := clientset.NewFakeClientset()
+			testEnv := initTestNodeServerWithCustomClientset(t, fakeClientset)
+			ns, _ := testEnv.ns.(*nodeServer)
+			ns.driver.config.PublishConfigHash = !tc.disabled
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "test-ns", Annotations: tc.podAnnotations}}
+
+			ns.publishConfigHash(context.Background(), pod, podTargetPath+tc.volumeName+"/mount", "test-bucket", options)
+			if diff := cmp.Diff(tc.expectAnnotations, fakeClientset.PodAnnotations); diff != "" {
+				t.Errorf("unexpected pod annotations (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -18,15 +18,25 @@ limitations under the License.
 package driver
 
 import (
+	"errors"
 	"fmt"
+	"maps"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/storage"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/volumespec"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/flowcontrol"
 	"k8s.io/klog/v2"
 )
 
@@ -41,14 +51,38 @@ const (
 	ParameterKeyPVCNamespace = "csi.storage.k8s.io/pvc/namespace"
 	ParameterKeyPVName       = "csi.storage.k8s.io/pv/name"
 
-	// User provided labels.
-	ParameterKeyLabels = "labels"
+	// StorageClass parameters, validated by volumespec.ValidateStorageClassParameters.
+	ParameterKeyLabels                   = volumespec.ParameterLabels
+	ParameterKeySeedBucketName           = volumespec.ParameterSeedBucketName
+	ParameterKeySeedObjectPrefix         = volumespec.ParameterSeedObjectPrefix
+	ParameterKeySharedBucketName         = volumespec.ParameterSharedBucketName
+	ParameterKeyPrefixIAMMember          = volumespec.ParameterPrefixIAMMember
+	ParameterKeyPrefixIAMRole            = volumespec.ParameterPrefixIAMRole
+	ParameterKeyCreateDir                = volumespec.ParameterCreateDir
+	ParameterKeyAdoptExistingBucket      = volumespec.ParameterAdoptExistingBucket
+	ParameterKeyPreventDestroyNonEmpty   = volumespec.ParameterPreventDestroyNonEmpty
+	ParameterKeyPreventDestroyMaxObjects = volumespec.ParameterPreventDestroyMaxObjects
+
+	defaultPrefixIAMRole = "roles/storage.objectUser"
 
 	// Keys for tags to attach to the provisioned disk.
 	tagKeyCreatedForClaimNamespace = "kubernetes_io_created-for_pvc_namespace"
 	tagKeyCreatedForClaimName      = "kubernetes_io_created-for_pvc_name"
 	tagKeyCreatedForVolumeName     = "kubernetes_io_created-for_pv_name"
 	tagKeyCreatedBy                = "storage_gke_io_created-by"
+	tagKeyCreatedForCluster        = "storage_gke_io_created-for_cluster-uid"
+	tagKeyReclaimPolicy            = "storage_gke_io_reclaim-policy"
+
+	// orphanedBucketGracePeriod protects new buckets from the garbage collection of orphaned buckets,
+	// because the external-provisioner creates the PersistentVolume after CreateVolume returns.
+	orphanedBucketGracePeriod = time.Hour
+
+	// Backoff of the bucket creation in a project after a GCS quota or rate limit was exceeded,
+	// so that the provisioning retries of the external-provisioner do not keep calling the GCS API.
+	bucketCreationInitialBackoff = 10 * time.Second
+	bucketCreationMaxBackoff     = 10 * time.Minute
+
+	eventReasonBucketQuotaExhausted = "GCSBucketQuotaExhausted"
 )
 
 // controllerServer handles volume provisioning.
@@ -57,6 +91,16 @@ type controllerServer struct {
 	driver                *GCSDriver
 	storageServiceManager storage.ServiceManager
 	volumeLocks           *util.VolumeLocks
+
+	// bucketCreationBackoff is keyed by the project ID.
+	bucketCreationBackoff *flowcontrol.Backoff
+
+	clusterUIDMux sync.Mutex
+	clusterUID    string
+
+	// seedCopies are the names of the buckets that the objects of a seed are being copied to.
+	seedCopiesMux sync.Mutex
+	seedCopies    map[string]bool
 }
 
 func newControllerServer(driver *GCSDriver, storageServiceManager storage.ServiceManager) csi.ControllerServer {
@@ -64,6 +108,8 @@ func newControllerServer(driver *GCSDriver, storageServiceManager storage.Servic
 		driver:                driver,
 		storageServiceManager: storageServiceManager,
 		volumeLocks:           util.NewVolumeLocks(),
+		bucketCreationBackoff: flowcontrol.NewBackOff(bucketCreationInitialBackoff, bucketCreationMaxBackoff),
+		seedCopies:            map[string]bool{},
 	}
 }
 
@@ -116,6 +162,14 @@ func (s *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req *
 }
 
 func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	start := time.Now()
+	resp, err := s.createVolume(ctx, req)
+	s.recordProvisioningOperation("CreateVolume", start, err)
+
+	return resp, err
+}
+
+func (s *controllerServer) createVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
 	// Validate arguments
 	name := req.GetName()
 	if len(name) == 0 {
@@ -144,6 +198,48 @@ func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 	defer s.volumeLocks.Release(volumeID)
 
 	param := req.GetParameters()
+	if err := volumespec.ValidateStorageClassParameters(param); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	seed, hasSeed, err := extractSeedDataSource(param)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if sharedBucketName := param[ParameterKeySharedBucketName]; sharedBucketName != "" {
+		if hasSeed {
+			return nil, status.Errorf(codes.InvalidArgument, "volumes in shared bucket %q cannot be pre-populated", sharedBucketName)
+		}
+
+		return s.createPrefixVolume(ctx, req, sharedBucketName, volumeID, capBytes)
+	}
+	// The parameters are validated, so the value is a valid bool if set.
+	adoptExistingBucket, _ := strconv.ParseBool(param[ParameterKeyAdoptExistingBucket])
+	if adoptExistingBucket && hasSeed {
+		return nil, status.Errorf(codes.InvalidArgument, "adopted buckets cannot be pre-populated")
+	}
+
+	// Add labels
+	labels, err := extractLabels(param, s.driver.config.Name)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// The cluster UID tells the buckets of this cluster apart in the garbage collection of orphaned buckets,
+	// and in the adoption of existing buckets.
+	uid, clusterUIDErr := s.getClusterUID(ctx)
+	if clusterUIDErr != nil {
+		klog.Warningf("failed to get the cluster UID, bucket %q will not be garbage collected if its PersistentVolume is deleted: %v", volumeID, clusterUIDErr)
+	} else {
+		labels[tagKeyCreatedForCluster] = uid
+	}
+	// Only the buckets of PersistentVolumes with the Delete reclaim policy are garbage collected once they are orphaned,
+	// because the buckets of PersistentVolumes with the Retain reclaim policy are kept on purpose.
+	if policy, err := s.reclaimPolicy(ctx, param); err != nil {
+		klog.Warningf("failed to get the reclaim policy, bucket %q will not be garbage collected if its PersistentVolume is deleted: %v", volumeID, err)
+	} else {
+		labels[tagKeyReclaimPolicy] = strings.ToLower(string(policy))
+	}
+
 	newBucket := &storage.ServiceBucket{
 		Project:                        projectID,
 		Name:                           volumeID,
@@ -162,33 +258,84 @@ func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 	if err != nil && !storage.IsNotExistErr(err) {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	if bucket != nil {
+	switch {
+	case bucket != nil && adoptExistingBucket:
+		klog.V(4).Infof("Found existing bucket %+v to adopt for volume %q, current bucket %+v", bucket, volumeID, newBucket)
+		// Without the cluster UID, a bucket provisioned for another cluster cannot be told apart.
+		if clusterUIDErr != nil {
+			return nil, status.Errorf(codes.Unavailable, "failed to get the cluster UID to adopt existing bucket %q: %v", volumeID, clusterUIDErr)
+		}
+		if err := validateAdoptedBucket(bucket, param, labels); err != nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "failed to adopt existing bucket %q: %v", volumeID, err)
+		}
+		// The size of a bucket is not stored in GCS, report the requested capacity.
+		bucket.SizeBytes = capBytes
+		// The StorageClass does not request a location, and GCS does not return the project ID of a bucket.
+		requested := *newBucket
+		requested.Location = bucket.Location
+		if bucket.Project == "" {
+			requested.Project = ""
+		}
+		if err = storage.CompareBuckets(&requested, bucket); err != nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "failed to adopt existing bucket %q: %v", volumeID, err)
+		}
+	case bucket != nil:
 		klog.V(4).Infof("Found existing bucket %+v, current bucket %+v\n", bucket, newBucket)
 		// Bucket already exists, check if it meets the request
 		if err = storage.CompareBuckets(newBucket, bucket); err != nil {
 			return nil, status.Error(codes.AlreadyExists, err.Error())
 		}
-	} else {
-		// Add labels
-		labels, err := extractLabels(param, s.driver.config.Name)
-		if err != nil {
-			return nil, status.Error(codes.InvalidArgument, err.Error())
-		}
+	default:
 		newBucket.Labels = labels
 
+		// Fail fast while the project is backing off, instead of exhausting the quota further.
+		if backoff := s.bucketCreationBackoff; backoff.IsInBackOffSinceUpdate(projectID, backoff.Clock.Now()) {
+			return nil, status.Errorf(codes.ResourceExhausted, "bucket creation in project %q is backing off for %v after exceeding a GCS quota or rate limit", projectID, backoff.Get(projectID))
+		}
+
 		// Create the bucket
 		var createErr error
 		bucket, createErr = storageService.CreateBucket(ctx, newBucket)
 		if createErr != nil {
-			return nil, status.Error(codes.Internal, createErr.Error())
+			if s.driver.config.MetricsManager != nil {
+				s.driver.config.MetricsManager.RecordBucketCreationFailure(storage.ErrorCode(createErr))
+			}
+
+			if storage.IsQuotaExhaustedErr(createErr) {
+				return nil, s.handleBucketQuotaExhausted(ctx, param, projectID, createErr)
+			}
+
+			return nil, status.Error(provisioningErrCode(createErr), createErr.Error())
 		}
+		s.bucketCreationBackoff.Reset(projectID)
 	}
+
+	// Seed the bucket on every call, including retries against an existing bucket,
+	// so that a copy interrupted by a previous failure is resumed. The seed bucket is checked before the volume is created,
+	// and its objects are copied in the background.
+	if hasSeed {
+		seedBucket := &storage.ServiceBucket{Name: seed.BucketName}
+		if _, err := storageService.CheckBucketExists(ctx, seedBucket); err != nil {
+			return nil, status.Errorf(storage.ParseErrCode(err), "failed to populate bucket %q from seed bucket %q: %v", newBucket.Name, seed.BucketName, err)
+		}
+		s.startSeedCopy(param, secrets, newBucket, seed)
+	}
+
 	resp := &csi.CreateVolumeResponse{Volume: bucketToCSIVolume(bucket)}
+	maps.Copy(resp.Volume.VolumeContext, deletionProtectionVolumeContext(param))
 
 	return resp, nil
 }
 
 func (s *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	start := time.Now()
+	resp, err := s.deleteVolume(ctx, req)
+	s.recordProvisioningOperation("DeleteVolume", start, err)
+
+	return resp, err
+}
+
+func (s *controllerServer) deleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
 	// Validate arguments
 	volumeID := req.GetVolumeId()
 	if len(volumeID) == 0 {
@@ -205,15 +352,199 @@ func (s *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolu
 		return nil, status.Errorf(codes.Unauthenticated, "failed to prepare storage service: %v", err)
 	}
 
+	if err := s.checkDeletionProtection(ctx, storageService, volumeID); err != nil {
+		storageService.Close()
+
+		return nil, err
+	}
+
+	if bucketName, prefix, ok := parsePrefixVolumeID(volumeID); ok {
+		return s.deletePrefixVolume(ctx, storageService, bucketName, prefix)
+	}
+
 	// Delete the volume
 	err = storageService.DeleteBucket(ctx, &storage.ServiceBucket{Name: volumeID})
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, status.Error(provisioningErrCode(err), err.Error())
+	}
+
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+// createPrefixVolume provisions the volume as an object prefix in a shared bucket. The volume is mounted with the only-dir flag.
+// Tenants are isolated by rejecting prefixes that overlap with another volume in the bucket, and optionally by an IAM condition.
+func (s *controllerServer) createPrefixVolume(ctx context.Context, req *csi.CreateVolumeRequest, bucketName, name string, capBytes int64) (*csi.CreateVolumeResponse, error) {
+	prefix := name + "/"
+	volumeID := prefixVolumeID(bucketName, prefix)
+	param := req.GetParameters()
+	createDir := false
+	if value, ok := param[ParameterKeyCreateDir]; ok {
+		var err error
+		if createDir, err = strconv.ParseBool(value); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "parameter %q only accepts a valid bool value, got %q", ParameterKeyCreateDir, value)
+		}
+	}
+
+	if err := s.validatePrefixIsolation(ctx, bucketName, prefix, volumeID); err != nil {
+		return nil, err
+	}
+
+	storageService, err := s.prepareStorageService(ctx, req.GetSecrets())
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "failed to prepare storage service: %v", err)
+	}
+	defer storageService.Close()
+
+	bucket := &storage.ServiceBucket{Name: bucketName}
+	if exist, err := storageService.CheckBucketExists(ctx, bucket); !exist {
+		return nil, status.Errorf(storage.ParseErrCode(err), "failed to get shared GCS bucket %q: %v", bucketName, err)
+	}
+
+	volumeContext := map[string]string{
+		VolumeContextKeyMountOptions: "only-dir=" + name,
+	}
+	maps.Copy(volumeContext, deletionProtectionVolumeContext(param))
+
+	// Workloads can branch on whether renames are atomic, so the layout of the shared bucket is recorded in the volume context.
+	// If it cannot be detected now, it is detected when the volume is mounted.
+	hnsEnabled, err := storageService.IsHierarchicalNamespaceEnabled(ctx, bucket, prefix)
+	if err != nil {
+		klog.Warningf("failed to detect hierarchical namespace of GCS bucket %q, detecting it when the volume is mounted: %v", bucketName, err)
+		volumeContext[VolumeContextKeyHierarchicalNamespace] = hierarchicalNamespaceAuto
+	} else {
+		volumeContext[VolumeContextKeyHierarchicalNamespace] = strconv.FormatBool(hnsEnabled)
+	}
+
+	// Without the placeholder object, the directory only exists as an implicit directory once objects are written in it,
+	// so gcsfuse mounts it as a missing directory unless the implicit-dirs flag is set.
+	// Buckets with hierarchical namespace enabled have real folders instead.
+	if createDir {
+		createDirFunc := storageService.CreateDirObject
+		if hnsEnabled {
+			createDirFunc = storageService.CreateFolder
+		}
+		if err := createDirFunc(ctx, bucket, prefix); err != nil {
+			return nil, status.Errorf(storage.ParseErrCode(err), "failed to create directory %q in GCS bucket %q: %v", prefix, bucketName, err)
+		}
+	}
+
+	if member := param[ParameterKeyPrefixIAMMember]; member != "" {
+		member = strings.NewReplacer("${pvc.namespace}", param[ParameterKeyPVCNamespace], "${pvc.name}", param[ParameterKeyPVCName]).Replace(member)
+		role := param[ParameterKeyPrefixIAMRole]
+		if role == "" {
+			role = defaultPrefixIAMRole
+		}
+
+		if err := storageService.SetPrefixIAMPolicy(ctx, bucket, prefix, member, role); err != nil {
+			return nil, status.Errorf(storage.ParseErrCode(err), "failed to grant %q role %q on prefix %q in GCS bucket %q: %v", member, role, prefix, bucketName, err)
+		}
+
+		// The bucket access check of the node service lists the whole bucket, which the IAM condition denies.
+		volumeContext[VolumeContextKeySkipCSIBucketAccessCheck] = util.TrueStr
+	}
+
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			CapacityBytes: capBytes,
+			VolumeId:      volumeID,
+			VolumeContext: volumeContext,
+		},
+	}, nil
+}
+
+// validatePrefixIsolation returns an error if the prefix overlaps with the only-dir prefix of another PersistentVolume in the bucket,
+// including PersistentVolumes that mount the whole bucket.
+func (s *controllerServer) validatePrefixIsolation(ctx context.Context, bucketName, prefix, volumeID string) error {
+	pvs, err := s.driver.config.K8sClients.ListPVs(ctx)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to list PersistentVolumes: %v", err)
+	}
+
+	for _, pv := range pvs {
+		source := pv.Spec.CSI
+		if source == nil || source.Driver != s.driver.config.Name || source.VolumeHandle == volumeID || parseVolumeID(source.VolumeHandle) != bucketName {
+			continue
+		}
+
+		pvPrefix := onlyDirPrefix(persistentVolumeMountOptions(&pv))
+		if pvPrefix == "" {
+			return status.Errorf(codes.FailedPrecondition, "PersistentVolume %q mounts the whole GCS bucket %q, so volumes cannot be isolated in the bucket", pv.Name, bucketName)
+		}
+		if strings.HasPrefix(prefix, pvPrefix) || strings.HasPrefix(pvPrefix, prefix) {
+			return status.Errorf(codes.FailedPrecondition, "prefix %q in GCS bucket %q overlaps with prefix %q of PersistentVolume %q", prefix, bucketName, pvPrefix, pv.Name)
+		}
+	}
+
+	return nil
+}
+
+// deletePrefixVolume deletes the objects under the prefix of a volume in a shared bucket, and the IAM bindings granted on the prefix.
+func (s *controllerServer) deletePrefixVolume(ctx context.Context, storageService storage.Service, bucketName, prefix string) (*csi.DeleteVolumeResponse, error) {
+	defer storageService.Close()
+
+	bucket := &storage.ServiceBucket{Name: bucketName}
+	if err := storageService.RemovePrefixIAMPolicy(ctx, bucket, prefix); err != nil {
+		if storage.IsNotExistErr(err) {
+			return &csi.DeleteVolumeResponse{}, nil
+		}
+
+		return nil, status.Errorf(storage.ParseErrCode(err), "failed to remove the IAM bindings on prefix %q in GCS bucket %q: %v", prefix, bucketName, err)
+	}
+
+	if err := storageService.DeleteObjects(ctx, bucket, prefix); err != nil {
+		return nil, status.Errorf(storage.ParseErrCode(err), "failed to delete objects with prefix %q in GCS bucket %q: %v", prefix, bucketName, err)
+	}
+
+	// Deleting the objects does not delete the folders of a bucket with hierarchical namespace enabled.
+	// If the layout cannot be detected, the empty folders are left behind, which does not affect other volumes.
+	if enabled, err := storageService.IsHierarchicalNamespaceEnabled(ctx, bucket, prefix); err != nil {
+		klog.Warningf("failed to detect hierarchical namespace of GCS bucket %q, not deleting the folders with prefix %q: %v", bucketName, prefix, err)
+	} else if enabled {
+		if err := storageService.DeleteFolder(ctx, bucket, prefix); err != nil {
+			return nil, status.Errorf(storage.ParseErrCode(err), "failed to delete folder %q in GCS bucket %q: %v", prefix, bucketName, err)
+		}
 	}
 
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
+func (s *controllerServer) recordProvisioningOperation(operation string, start time.Time, err error) {
+	if s.driver.config.MetricsManager != nil {
+		s.driver.config.MetricsManager.RecordProvisioningOperation(operation, time.Since(start), status.Code(err))
+	}
+}
+
+// handleBucketQuotaExhausted backs off the bucket creation in the project, and emits a warning event on the PVC being provisioned
+// that suggests provisioning the volumes in a shared bucket, which does not create a bucket for each volume.
+func (s *controllerServer) handleBucketQuotaExhausted(ctx context.Context, parameters map[string]string, projectID string, createErr error) error {
+	backoff := s.bucketCreationBackoff
+	backoff.Next(projectID, backoff.Clock.Now())
+	delay := backoff.Get(projectID)
+
+	pvcName, pvcNamespace := parameters[ParameterKeyPVCName], parameters[ParameterKeyPVCNamespace]
+	if pvcName != "" && pvcNamespace != "" && s.driver.config.K8sClients != nil {
+		if pvc, err := s.driver.config.K8sClients.GetPVC(ctx, pvcNamespace, pvcName); err != nil {
+			klog.Warningf("failed to get PVC %s/%s to record the bucket quota event: %v", pvcNamespace, pvcName, err)
+		} else {
+			s.driver.config.K8sClients.Eventf(pvc, corev1.EventTypeWarning, eventReasonBucketQuotaExhausted,
+				"Bucket creation in project %q exceeded a GCS quota or rate limit, retrying after %v. To provision many volumes, consider setting the StorageClass parameter %q to provision the volumes as sub-directories of a shared bucket.",
+				projectID, delay, ParameterKeySharedBucketName)
+		}
+	}
+
+	return status.Errorf(codes.ResourceExhausted, "failed to create bucket in project %q, backing off for %v after exceeding a GCS quota or rate limit: %v", projectID, delay, createErr)
+}
+
+// provisioningErrCode returns the gRPC code of a failed GCS bucket operation.
+// Exhausted quotas are reported as ResourceExhausted, so that they can be told apart from other internal errors.
+func provisioningErrCode(err error) codes.Code {
+	if storage.IsQuotaExhaustedErr(err) {
+		return codes.ResourceExhausted
+	}
+
+	return codes.Internal
+}
+
 // prepareStorageService prepares the GCS Storage Service using CreateVolume/DeleteVolume sercets.
 func (s *controllerServer) prepareStorageService(ctx context.Context, secrets map[string]string) (storage.Service, error) {
 	serviceAccountName, ok := secrets["serviceAccountName"]
@@ -234,11 +565,151 @@ func (s *controllerServer) prepareStorageService(ctx context.Context, secrets ma
 	return storageService, nil
 }
 
+// getClusterUID returns the UID of the kube-system namespace, which identifies the cluster.
+func (s *controllerServer) getClusterUID(ctx context.Context) (string, error) {
+	s.clusterUIDMux.Lock()
+	defer s.clusterUIDMux.Unlock()
+
+	if s.clusterUID == "" {
+		uid, err := s.driver.config.K8sClients.GetNamespaceUID(ctx, metav1.NamespaceSystem)
+		if err != nil {
+			return "", err
+		}
+		s.clusterUID = uid
+	}
+
+	return s.clusterUID, nil
+}
+
+// collectOrphanedBuckets finds the buckets that the controller provisioned in this cluster for PersistentVolumes with the Delete
+// reclaim policy that no longer exist, for example because the PersistentVolume was deleted out of band.
+// The buckets are reported, and deleted if the orphaned bucket policy is Delete.
+func (s *controllerServer) collectOrphanedBuckets() {
+	ctx := context.Background()
+	config := s.driver.config
+
+	clusterUID, err := s.getClusterUID(ctx)
+	if err != nil {
+		klog.Errorf("failed to get the cluster UID to collect orphaned buckets: %v", err)
+
+		return
+	}
+
+	storageService, err := s.prepareOrphanedBucketStorageService(ctx)
+	if err != nil {
+		klog.Errorf("failed to prepare storage service to collect orphaned buckets: %v", err)
+
+		return
+	}
+	defer storageService.Close()
+
+	buckets, err := storageService.ListBuckets(ctx, config.OrphanedBucketGCProject)
+	if err != nil {
+		klog.Errorf("failed to list the buckets in project %q: %v", config.OrphanedBucketGCProject, err)
+
+		return
+	}
+
+	// The PersistentVolumes are listed after the buckets, so that the PersistentVolumes of all the listed buckets are included.
+	pvs, err := config.K8sClients.ListPVs(ctx)
+	if err != nil {
+		klog.Errorf("failed to list PersistentVolumes to collect orphaned buckets: %v", err)
+
+		return
+	}
+	volumeHandles := sets.NewString()
+	for _, pv := range pvs {
+		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == config.Name {
+			volumeHandles.Insert(pv.Spec.CSI.VolumeHandle)
+		}
+	}
+
+	orphaned := 0
+	createdBy := strings.ReplaceAll(config.Name, ".", "_")
+	for _, bucket := range buckets {
+		if bucket.Labels[tagKeyCreatedBy] != createdBy || bucket.Labels[tagKeyCreatedForCluster] != clusterUID {
+			continue
+		}
+		if bucket.Labels[tagKeyReclaimPolicy] != strings.ToLower(string(corev1.PersistentVolumeReclaimDelete)) {
+			continue
+		}
+		if volumeHandles.Has(bucket.Name) || time.Since(bucket.Created) < orphanedBucketGracePeriod {
+			continue
+		}
+
+		pvName := bucket.Labels[tagKeyCreatedForVolumeName]
+		if config.OrphanedBucketGCPolicy != OrphanedBucketPolicyDelete {
+			klog.Warningf("bucket %q was provisioned for PersistentVolume %q, which no longer exists", bucket.Name, pvName)
+			orphaned++
+
+			continue
+		}
+
+		// Skip the bucket if a CreateVolume or DeleteVolume call is in progress for it.
+		if acquired := s.volumeLocks.TryAcquire(bucket.Name); !acquired {
+			orphaned++
+
+			continue
+		}
+		klog.Infof("deleting bucket %q provisioned for PersistentVolume %q, which no longer exists", bucket.Name, pvName)
+		err := storageService.DeleteBucket(ctx, &storage.ServiceBucket{Name: bucket.Name})
+		s.volumeLocks.Release(bucket.Name)
+		if err != nil {
+			klog.Errorf("failed to delete orphaned bucket %q: %v", bucket.Name, err)
+			orphaned++
+		}
+	}
+
+	if config.MetricsManager != nil {
+		config.MetricsManager.RecordOrphanedBuckets(orphaned)
+	}
+}
+
+// reclaimPolicy returns the reclaim policy of the StorageClass of the PersistentVolumeClaim that the volume is provisioned for.
+func (s *controllerServer) reclaimPolicy(ctx context.Context, param map[string]string) (corev1.PersistentVolumeReclaimPolicy, error) {
+	namespace, name := param[ParameterKeyPVCNamespace], param[ParameterKeyPVCName]
+	if namespace == "" || name == "" {
+		return "", errors.New("the PersistentVolumeClaim is unknown, the external-provisioner must run with --extra-create-metadata")
+	}
+	pvc, err := s.driver.config.K8sClients.GetPVC(ctx, namespace, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to get PersistentVolumeClaim %s/%s: %w", namespace, name, err)
+	}
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return "", fmt.Errorf("PersistentVolumeClaim %s/%s has no StorageClass", namespace, name)
+	}
+	sc, err := s.driver.config.K8sClients.GetStorageClass(ctx, *pvc.Spec.StorageClassName)
+	if err != nil {
+		return "", fmt.Errorf("failed to get StorageClass %q: %w", *pvc.Spec.StorageClassName, err)
+	}
+	if sc.ReclaimPolicy == nil {
+		return corev1.PersistentVolumeReclaimDelete, nil
+	}
+
+	return *sc.ReclaimPolicy, nil
+}
+
+// prepareOrphanedBucketStorageService prepares the GCS Storage Service using the credentials of the orphaned bucket
+// garbage collection Kubernetes ServiceAccount, or the default credentials if it is not set.
+func (s *controllerServer) prepareOrphanedBucketStorageService(ctx context.Context) (storage.Service, error) {
+	namespace, name, ok := strings.Cut(s.driver.config.OrphanedBucketGCServiceAccount, "/")
+	if !ok {
+		return s.storageServiceManager.SetupServiceWithDefaultCredential(ctx)
+	}
+
+	ts := s.driver.config.TokenManager.GetTokenSourceFromK8sServiceAccount(namespace, name, "")
+
+	return s.storageServiceManager.SetupService(ctx, ts)
+}
+
 // bucketToCSIVolume generates a CSI volume spec from the Google Cloud Storage Bucket.
 func bucketToCSIVolume(bucket *storage.ServiceBucket) *csi.Volume {
 	resp := &csi.Volume{
 		CapacityBytes: bucket.SizeBytes,
 		VolumeId:      bucket.Name,
+		VolumeContext: map[string]string{
+			VolumeContextKeyHierarchicalNamespace: strconv.FormatBool(bucket.EnableHierarchicalNamespace),
+		},
 	}
 
 	return resp
@@ -310,7 +781,56 @@ func extractLabels(parameters map[string]string, driverName string) (map[string]
 		labels[k] = strings.ReplaceAll(v, ".", "_")
 	}
 
-	return mergeLabels(scLabels, labels)
+	return labels, nil
+}
+
+// validateAdoptedBucket checks that an existing bucket can be adopted by a new volume with the given labels.
+// The bucket must carry all the labels of the labels parameter, which identify the buckets that are meant to be adopted,
+// and must not have been provisioned for another cluster or PersistentVolumeClaim.
+func validateAdoptedBucket(bucket *storage.ServiceBucket, parameters, labels map[string]string) error {
+	scLabels, err := util.ConvertLabelsStringToMap(parameters[ParameterKeyLabels])
+	if err != nil {
+		return fmt.Errorf("parameters contain invalid labels parameter: %w", err)
+	}
+	if len(scLabels) == 0 {
+		return fmt.Errorf("parameter %q requires parameter %q to identify the buckets that can be adopted", ParameterKeyAdoptExistingBucket, ParameterKeyLabels)
+	}
+
+	for k := range scLabels {
+		if v, ok := bucket.Labels[k]; !ok || v != labels[k] {
+			return fmt.Errorf("bucket label %q is %q, expected %q", k, v, labels[k])
+		}
+	}
+
+	for _, k := range []string{tagKeyCreatedForCluster, tagKeyCreatedForClaimNamespace, tagKeyCreatedForClaimName} {
+		if v, ok := bucket.Labels[k]; ok && v != labels[k] {
+			return fmt.Errorf("bucket was provisioned for another cluster or PersistentVolumeClaim, label %q is %q, expected %q", k, v, labels[k])
+		}
+	}
+
+	return nil
+}
+
+// seedSource is the bucket and object prefix that a new bucket is pre-populated from.
+type seedSource struct {
+	BucketName   string
+	ObjectPrefix string
+}
+
+// extractSeedDataSource returns the seed data source from the CreateVolume parameters,
+// and false if the new bucket should not be pre-populated.
+func extractSeedDataSource(parameters map[string]string) (*seedSource, bool, error) {
+	seedBucketName := parameters[ParameterKeySeedBucketName]
+	seedObjectPrefix := parameters[ParameterKeySeedObjectPrefix]
+	if seedBucketName == "" {
+		if seedObjectPrefix != "" {
+			return nil, false, fmt.Errorf("parameter %q requires parameter %q to be set", ParameterKeySeedObjectPrefix, ParameterKeySeedBucketName)
+		}
+
+		return nil, false, nil
+	}
+
+	return &seedSource{BucketName: seedBucketName, ObjectPrefix: seedObjectPrefix}, true, nil
 }
 
 func mergeLabels(scLabels map[string]string, metedataLabels map[string]string) (map[string]string, error) {
@@ -19,14 +19,27 @@ package driver
 
 import (
 	"errors"
+	"fmt"
+	"net/http"
 	"reflect"
+	"slices"
 	"testing"
+	"time"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/clientset"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/storage"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/metrics"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
 	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
 )
 
 const (
@@ -72,6 +85,7 @@ func TestCreateVolume(t *testing.T) {
 				Volume: &csi.Volume{
 					CapacityBytes: 1 * util.Mb,
 					VolumeId:      testVolumeID,
+					VolumeContext: map[string]string{VolumeContextKeyHierarchicalNamespace: util.FalseStr},
 				},
 			},
 		},
@@ -91,6 +105,56 @@ func TestCreateVolume(t *testing.T) {
 			},
 			expectErr: status.Error(codes.InvalidArgument, "CreateVolume name must be provided"),
 		},
+		{
+			name: "seed object prefix without seed bucket",
+			req: &csi.CreateVolumeRequest{
+				Name: testVolumeID,
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					ParameterKeySeedObjectPrefix: "datasets/",
+				},
+				Secrets: map[string]string{
+					"projectID":               "test-project",
+					"serviceAccountName":      "test-sa-name",
+					"serviceAccountNamespace": "test-sa-namespace",
+				},
+			},
+			expectErr: status.Error(codes.InvalidArgument, `parameter "seedObjectPrefix" requires parameter "seedBucketName" to be set`),
+		},
+		{
+			name: "seed bucket does not exist",
+			req: &csi.CreateVolumeRequest{
+				Name: testVolumeID,
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					ParameterKeySeedBucketName: "test-seed-bucket",
+				},
+				Secrets: map[string]string{
+					"projectID":               "test-project",
+					"serviceAccountName":      "test-sa-name",
+					"serviceAccountNamespace": "test-sa-namespace",
+				},
+			},
+			expectErr: status.Error(codes.NotFound, `failed to populate bucket "test-volume-id" from seed bucket "test-seed-bucket": storage: bucket doesn't exist`),
+		},
 	}
 
 	for _, test := range cases {
@@ -108,6 +172,470 @@ func TestCreateVolume(t *testing.T) {
 	}
 }
 
+func TestCreateVolumeWithSeedBucket(t *testing.T) {
+	t.Parallel()
+	cs := initTestController(t)
+	volumeCapabilities := []*csi.VolumeCapability{
+		{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		},
+	}
+	secrets := map[string]string{
+		"projectID":               "test-project",
+		"serviceAccountName":      "test-sa-name",
+		"serviceAccountNamespace": "test-sa-namespace",
+	}
+
+	// Create the seed bucket first.
+	if _, err := cs.CreateVolume(context.TODO(), &csi.CreateVolumeRequest{Name: "test-seed-bucket", VolumeCapabilities: volumeCapabilities, Secrets: secrets}); err != nil {
+		t.Fatalf("failed to create seed bucket: %v", err)
+	}
+
+	req := &csi.CreateVolumeRequest{
+		Name:               testVolumeID,
+		VolumeCapabilities: volumeCapabilities,
+		Parameters: map[string]string{
+			ParameterKeySeedBucketName:   "test-seed-bucket",
+			ParameterKeySeedObjectPrefix: "datasets/",
+		},
+		Secrets: secrets,
+	}
+	expectedResp := &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			CapacityBytes: 1 * util.Mb,
+			VolumeId:      testVolumeID,
+			VolumeContext: map[string]string{VolumeContextKeyHierarchicalNamespace: util.FalseStr},
+		},
+	}
+
+	// The second call verifies that seeding an existing bucket is idempotent.
+	for range 2 {
+		resp, err := cs.CreateVolume(context.TODO(), req)
+		if err != nil {
+			t.Errorf("got error %q, expected error nil", err)
+		}
+		if !reflect.DeepEqual(resp, expectedResp) {
+			t.Errorf("got resp %+v, expected resp %+v", resp, expectedResp)
+		}
+	}
+
+	// The objects are copied in the background after CreateVolume returns.
+	controller, _ := cs.(*controllerServer)
+	deadline := time.Now().Add(10 * time.Second)
+	for controller.seedCopyInFlight(testVolumeID) {
+		if time.Now().After(deadline) {
+			t.Fatalf("the seed copy to bucket %q did not complete", testVolumeID)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCreateVolumeAdoptExistingBucket(t *testing.T) {
+	t.Parallel()
+	volumeCapabilities := []*csi.VolumeCapability{
+		{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		},
+	}
+	secrets := map[string]string{
+		"projectID":               "test-project",
+		"serviceAccountName":      "test-sa-name",
+		"serviceAccountNamespace": "test-sa-namespace",
+	}
+	expectedResp := &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			CapacityBytes: 1 * util.Mb,
+			VolumeId:      testVolumeID,
+			VolumeContext: map[string]string{VolumeContextKeyHierarchicalNamespace: util.FalseStr},
+		},
+	}
+
+	cases := []struct {
+		name          string
+		bucketLabels  map[string]string
+		bucketProject string
+		clusterUIDErr error
+		parameters    map[string]string
+		expectErr     error
+	}{
+		{
+			name:         "adopt bucket with ownership labels",
+			bucketLabels: map[string]string{"team": "ml"},
+			parameters:   map[string]string{ParameterKeyAdoptExistingBucket: util.TrueStr, ParameterKeyLabels: "team=ml"},
+		},
+		{
+			name:         "adopt bucket provisioned for the same claim",
+			bucketLabels: map[string]string{"team": "ml", tagKeyCreatedForCluster: "fake-uid-kube-system", tagKeyCreatedForClaimName: "test-pvc"},
+			parameters:   map[string]string{ParameterKeyAdoptExistingBucket: util.TrueStr, ParameterKeyLabels: "team=ml", ParameterKeyPVCName: "test-pvc"},
+		},
+		{
+			name:         "existing bucket without adoption",
+			bucketLabels: map[string]string{"team": "ml"},
+			parameters:   map[string]string{ParameterKeyLabels: "team=ml"},
+			expectErr:    status.Error(codes.AlreadyExists, `bucket "test-volume-id" and bucket "test-volume-id" do not match: [bucket size]`),
+		},
+		{
+			name:          "bucket in another project",
+			bucketLabels:  map[string]string{"team": "ml"},
+			bucketProject: "other-project",
+			parameters:    map[string]string{ParameterKeyAdoptExistingBucket: util.TrueStr, ParameterKeyLabels: "team=ml"},
+			expectErr:     status.Error(codes.FailedPrecondition, `failed to adopt existing bucket "test-volume-id": bucket "test-volume-id" and bucket "test-volume-id" do not match: [bucket project]`),
+		},
+		{
+			name:          "unknown cluster UID",
+			bucketLabels:  map[string]string{"team": "ml"},
+			clusterUIDErr: errors.New("namespaces \"kube-system\" is forbidden"),
+			parameters:    map[string]string{ParameterKeyAdoptExistingBucket: util.TrueStr, ParameterKeyLabels: "team=ml"},
+			expectErr:     status.Error(codes.Unavailable, `failed to get the cluster UID to adopt existing bucket "test-volume-id": namespaces "kube-system" is forbidden`),
+		},
+		{
+			name:         "missing ownership label",
+			bucketLabels: map[string]string{"team": "web"},
+			parameters:   map[string]string{ParameterKeyAdoptExistingBucket: util.TrueStr, ParameterKeyLabels: "team=ml"},
+			expectErr:    status.Error(codes.FailedPrecondition, `failed to adopt existing bucket "test-volume-id": bucket label "team" is "web", expected "ml"`),
+		},
+		{
+			name:         "no labels parameter",
+			bucketLabels: map[string]string{"team": "ml"},
+			parameters:   map[string]string{ParameterKeyAdoptExistingBucket: util.TrueStr},
+			expectErr:    status.Error(codes.InvalidArgument, `parameter "adoptExistingBucket" requires parameter "labels" to identify the buckets that can be adopted`),
+		},
+		{
+			name:         "bucket of another cluster",
+			bucketLabels: map[string]string{"team": "ml", tagKeyCreatedForCluster: "other-uid"},
+			parameters:   map[string]string{ParameterKeyAdoptExistingBucket: util.TrueStr, ParameterKeyLabels: "team=ml"},
+			expectErr: status.Error(codes.FailedPrecondition, `failed to adopt existing bucket "test-volume-id": bucket was provisioned for another cluster or PersistentVolumeClaim, `+
+				`label "storage_gke_io_created-for_cluster-uid" is "other-uid", expected "fake-uid-kube-system"`),
+		},
+		{
+			name:         "bucket of another claim",
+			bucketLabels: map[string]string{"team": "ml", tagKeyCreatedForClaimName: "other-pvc"},
+			parameters:   map[string]string{ParameterKeyAdoptExistingBucket: util.TrueStr, ParameterKeyLabels: "team=ml", ParameterKeyPVCName: "test-pvc"},
+			expectErr: status.Error(codes.FailedPrecondition, `failed to adopt existing bucket "test-volume-id": bucket was provisioned for another cluster or PersistentVolumeClaim, `+
+				`label "kubernetes_io_created-for_pvc_name" is "other-pvc", expected "test-pvc"`),
+		},
+		{
+			name:         "bucket of a claim without the claim parameters",
+			bucketLabels: map[string]string{"team": "ml", tagKeyCreatedForClaimName: "other-pvc"},
+			parameters:   map[string]string{ParameterKeyAdoptExistingBucket: util.TrueStr, ParameterKeyLabels: "team=ml"},
+			expectErr: status.Error(codes.FailedPrecondition, `failed to adopt existing bucket "test-volume-id": bucket was provisioned for another cluster or PersistentVolumeClaim, `+
+				`label "kubernetes_io_created-for_pvc_name" is "other-pvc", expected ""`),
+		},
+		{
+			name:       "invalid adoptExistingBucket",
+			parameters: map[string]string{ParameterKeyAdoptExistingBucket: "maybe"},
+			expectErr:  status.Error(codes.InvalidArgument, `parameter "adoptExistingBucket" only accepts a valid bool value, got "maybe"`),
+		},
+		{
+			name:       "adoptExistingBucket with shared bucket",
+			parameters: map[string]string{ParameterKeyAdoptExistingBucket: util.TrueStr, ParameterKeyLabels: "team=ml", ParameterKeySharedBucketName: "test-shared-bucket"},
+			expectErr:  status.Error(codes.InvalidArgument, `parameter "adoptExistingBucket" cannot be used together with parameter "sharedBucketName"`),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Logf("test case: %s", tc.name)
+		driver := initTestDriver(t, nil)
+		driver.config.K8sClients.(*clientset.FakeClientset).NamespaceUIDErr = tc.clusterUIDErr
+		cs := newControllerServer(driver, driver.config.StorageServiceManager)
+		if tc.bucketLabels != nil {
+			ss, err := driver.config.StorageServiceManager.SetupServiceWithDefaultCredential(context.TODO())
+			if err != nil {
+				t.Fatalf("failed to set up storage service: %v", err)
+			}
+			project := tc.bucketProject
+			if project == "" {
+				project = "test-project"
+			}
+			if _, err := ss.CreateBucket(context.TODO(), &storage.ServiceBucket{Name: testVolumeID, Project: project, Labels: tc.bucketLabels}); err != nil {
+				t.Fatalf("failed to create bucket: %v", err)
+			}
+		}
+
+		req := &csi.CreateVolumeRequest{Name: testVolumeID, VolumeCapabilities: volumeCapabilities, Parameters: tc.parameters, Secrets: secrets}
+		resp, err := cs.CreateVolume(context.TODO(), req)
+		if tc.expectErr == nil {
+			if err != nil {
+				t.Errorf("got error %q, expected error nil", err)
+			}
+			if !reflect.DeepEqual(resp, expectedResp) {
+				t.Errorf("got resp %+v, expected resp %+v", resp, expectedResp)
+			}
+		} else if !errors.Is(err, tc.expectErr) {
+			t.Errorf("got error %q, expected error %q", err, tc.expectErr)
+		}
+	}
+}
+
+func TestCreateVolumeInSharedBucket(t *testing.T) {
+	t.Parallel()
+	volumeCapabilities := []*csi.VolumeCapability{
+		{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			},
+		},
+	}
+	secrets := map[string]string{
+		"projectID":               "test-project",
+		"serviceAccountName":      "test-sa-name",
+		"serviceAccountNamespace": "test-sa-namespace",
+	}
+	newPV := func(name, volumeHandle string, mountOptions ...string) *corev1.PersistentVolume {
+		return &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: corev1.PersistentVolumeSpec{
+				MountOptions: mountOptions,
+				PersistentVolumeSource: corev1.PersistentVolumeSource{
+					CSI: &corev1.CSIPersistentVolumeSource{Driver: "test-driver", VolumeHandle: volumeHandle},
+				},
+			},
+		}
+	}
+
+	cases := []struct {
+		name          string
+		pvs           []*corev1.PersistentVolume
+		parameters    map[string]string
+		resp          *csi.CreateVolumeResponse
+		expectDirs    []string
+		expectFolders []string
+		expectErr     error
+	}{
+		{
+			name: "valid",
+			pvs: []*corev1.PersistentVolume{
+				newPV("other-bucket-pv", "other-bucket"),
+				newPV("other-prefix-pv", "test-shared-bucket:other", "only-dir=other"),
+			},
+			parameters: map[string]string{ParameterKeySharedBucketName: "test-shared-bucket", ParameterKeyCreateDir: util.TrueStr},
+			resp: &csi.CreateVolumeResponse{
+				Volume: &csi.Volume{
+					CapacityBytes: 1 * util.Mb,
+					VolumeId:      "test-shared-bucket:test-volume-id/",
+					VolumeContext: map[string]string{VolumeContextKeyMountOptions: "only-dir=test-volume-id", VolumeContextKeyHierarchicalNamespace: util.FalseStr},
+				},
+			},
+			expectDirs: []string{"test-shared-bucket/test-volume-id/"},
+		},
+		{
+			name:       "valid in a bucket with hierarchical namespace",
+			parameters: map[string]string{ParameterKeySharedBucketName: "test-hns-bucket", ParameterKeyCreateDir: util.TrueStr},
+			resp: &csi.CreateVolumeResponse{
+				Volume: &csi.Volume{
+					CapacityBytes: 1 * util.Mb,
+					VolumeId:      "test-hns-bucket:test-volume-id/",
+					VolumeContext: map[string]string{VolumeContextKeyMountOptions: "only-dir=test-volume-id", VolumeContextKeyHierarchicalNamespace: util.TrueStr},
+				},
+			},
+			expectFolders: []string{"test-hns-bucket/test-volume-id/"},
+		},
+		{
+			name:       "valid without creating the directory by default",
+			parameters: map[string]string{ParameterKeySharedBucketName: "test-shared-bucket"},
+			resp: &csi.CreateVolumeResponse{
+				Volume: &csi.Volume{
+					CapacityBytes: 1 * util.Mb,
+					VolumeId:      "test-shared-bucket:test-volume-id/",
+					VolumeContext: map[string]string{VolumeContextKeyMountOptions: "only-dir=test-volume-id", VolumeContextKeyHierarchicalNamespace: util.FalseStr},
+				},
+			},
+		},
+		{
+			name:       "invalid createDir",
+			parameters: map[string]string{ParameterKeySharedBucketName: "test-shared-bucket", ParameterKeyCreateDir: "yes please"},
+			expectErr:  status.Error(codes.InvalidArgument, `parameter "createDir" only accepts a valid bool value, got "yes please"`),
+		},
+		{
+			name:       "createDir without shared bucket",
+			parameters: map[string]string{ParameterKeyCreateDir: "true"},
+			expectErr:  status.Error(codes.InvalidArgument, `parameter "createDir" can only be used together with parameter "sharedBucketName"`),
+		},
+		{
+			name: "valid with prefix IAM member",
+			parameters: map[string]string{
+				ParameterKeySharedBucketName: "test-shared-bucket",
+				ParameterKeyCreateDir:        util.TrueStr,
+				ParameterKeyPrefixIAMMember:  "principalSet://iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/test-project.svc.id.goog/namespace/${pvc.namespace}",
+				ParameterKeyPVCNamespace:     "test-ns",
+			},
+			resp: &csi.CreateVolumeResponse{
+				Volume: &csi.Volume{
+					CapacityBytes: 1 * util.Mb,
+					VolumeId:      "test-shared-bucket:test-volume-id/",
+					VolumeContext: map[string]string{VolumeContextKeyMountOptions: "only-dir=test-volume-id", VolumeContextKeyHierarchicalNamespace: util.FalseStr, VolumeContextKeySkipCSIBucketAccessCheck: util.TrueStr},
+				},
+			},
+			expectDirs: []string{"test-shared-bucket/test-volume-id/"},
+		},
+		{
+			name:       "overlapping prefix",
+			pvs:        []*corev1.PersistentVolume{newPV("nested-prefix-pv", "test-shared-bucket:nested", "only-dir=test-volume-id/nested")},
+			parameters: map[string]string{ParameterKeySharedBucketName: "test-shared-bucket"},
+			expectErr:  status.Error(codes.FailedPrecondition, `prefix "test-volume-id/" in GCS bucket "test-shared-bucket" overlaps with prefix "test-volume-id/nested/" of PersistentVolume "nested-prefix-pv"`),
+		},
+		{
+			name:       "whole bucket mounted",
+			pvs:        []*corev1.PersistentVolume{newPV("whole-bucket-pv", "test-shared-bucket")},
+			parameters: map[string]string{ParameterKeySharedBucketName: "test-shared-bucket"},
+			expectErr:  status.Error(codes.FailedPrecondition, `PersistentVolume "whole-bucket-pv" mounts the whole GCS bucket "test-shared-bucket", so volumes cannot be isolated in the bucket`),
+		},
+		{
+			name:       "shared bucket does not exist",
+			parameters: map[string]string{ParameterKeySharedBucketName: "missing-bucket"},
+			expectErr:  status.Error(codes.NotFound, `failed to get shared GCS bucket "missing-bucket": storage: bucket doesn't exist`),
+		},
+		{
+			name:       "seed bucket",
+			parameters: map[string]string{ParameterKeySharedBucketName: "test-shared-bucket", ParameterKeySeedBucketName: "test-shared-bucket"},
+			expectErr:  status.Error(codes.InvalidArgument, `volumes in shared bucket "test-shared-bucket" cannot be pre-populated`),
+		},
+	}
+
+	for _, test := range cases {
+		fakeClientset := clientset.NewFakeClientset()
+		for _, pv := range test.pvs {
+			fakeClientset.CreatePV(pv)
+		}
+		driver := initTestDriverWithCustomNodeServer(t, nil, fakeClientset)
+		sm := &dirRecordingServiceManager{ServiceManager: driver.config.StorageServiceManager}
+		cs := newControllerServer(driver, sm)
+
+		// Create the shared buckets first.
+		if _, err := cs.CreateVolume(context.TODO(), &csi.CreateVolumeRequest{Name: "test-shared-bucket", VolumeCapabilities: volumeCapabilities, Secrets: secrets}); err != nil {
+			t.Fatalf("failed to create shared bucket: %v", err)
+		}
+		ss, _ := driver.config.StorageServiceManager.SetupService(context.TODO(), nil)
+		if _, err := ss.CreateBucket(context.TODO(), &storage.ServiceBucket{Name: "test-hns-bucket", EnableHierarchicalNamespace: true}); err != nil {
+			t.Fatalf("failed to create shared bucket: %v", err)
+		}
+
+		resp, err := cs.CreateVolume(context.TODO(), &csi.CreateVolumeRequest{
+			Name:               testVolumeID,
+			VolumeCapabilities: volumeCapabilities,
+			Parameters:         test.parameters,
+			Secrets:            secrets,
+		})
+		if test.expectErr == nil && err != nil {
+			t.Errorf("test %q failed:\ngot error %q,\nexpected error nil", test.name, err)
+		}
+		if test.expectErr != nil && !errors.Is(err, test.expectErr) {
+			t.Errorf("test %q failed:\ngot error %q,\nexpected error %q", test.name, err, test.expectErr)
+		}
+		if !reflect.DeepEqual(resp, test.resp) {
+			t.Errorf("test %q failed:\ngot resp %+v,\nexpected resp %+v", test.name, resp, test.resp)
+		}
+		if !reflect.DeepEqual(sm.dirs, test.expectDirs) {
+			t.Errorf("test %q failed:\ngot directory objects %v,\nexpected directory objects %v", test.name, sm.dirs, test.expectDirs)
+		}
+		if !reflect.DeepEqual(sm.folders, test.expectFolders) {
+			t.Errorf("test %q failed:\ngot folders %v,\nexpected folders %v", test.name, sm.folders, test.expectFolders)
+		}
+	}
+}
+
+// dirRecordingServiceManager sets up storage services that record the created directory objects and folders,
+// and the deleted folders.
+type dirRecordingServiceManager struct {
+	storage.ServiceManager
+	dirs           []string
+	folders        []string
+	deletedFolders []string
+}
+
+type dirRecordingService struct {
+	storage.Service
+	sm *dirRecordingServiceManager
+}
+
+func (m *dirRecordingServiceManager) SetupService(ctx context.Context, ts oauth2.TokenSource) (storage.Service, error) {
+	ss, err := m.ServiceManager.SetupService(ctx, ts)
+
+	return &dirRecordingService{Service: ss, sm: m}, err
+}
+
+func (s *dirRecordingService) CreateDirObject(ctx context.Context, obj *storage.ServiceBucket, dir string) error {
+	if err := s.Service.CreateDirObject(ctx, obj, dir); err != nil {
+		return err
+	}
+	s.sm.dirs = append(s.sm.dirs, obj.Name+"/"+dir)
+
+	return nil
+}
+
+func (s *dirRecordingService) CreateFolder(ctx context.Context, obj *storage.ServiceBucket, folder string) error {
+	if err := s.Service.CreateFolder(ctx, obj, folder); err != nil {
+		return err
+	}
+	s.sm.folders = append(s.sm.folders, obj.Name+"/"+folder)
+
+	return nil
+}
+
+func (s *dirRecordingService) DeleteFolder(ctx context.Context, obj *storage.ServiceBucket, folder string) error {
+	if err := s.Service.DeleteFolder(ctx, obj, folder); err != nil {
+		return err
+	}
+	s.sm.deletedFolders = append(s.sm.deletedFolders, obj.Name+"/"+folder)
+
+	return nil
+}
+
+func TestDeleteVolumeInSharedBucket(t *testing.T) {
+	t.Parallel()
+	secrets := map[string]string{
+		"projectID":               "test-project",
+		"serviceAccountName":      "test-sa-name",
+		"serviceAccountNamespace": "test-sa-namespace",
+	}
+
+	cases := []struct {
+		name                 string
+		bucket               *storage.ServiceBucket
+		expectDeletedFolders []string
+	}{
+		{
+			name:   "flat namespace",
+			bucket: &storage.ServiceBucket{Name: "test-shared-bucket"},
+		},
+		{
+			name:                 "hierarchical namespace",
+			bucket:               &storage.ServiceBucket{Name: "test-shared-bucket", EnableHierarchicalNamespace: true},
+			expectDeletedFolders: []string{"test-shared-bucket/test-volume-id/"},
+		},
+	}
+
+	for _, test := range cases {
+		driver := initTestDriver(t, nil)
+		sm := &dirRecordingServiceManager{ServiceManager: driver.config.StorageServiceManager}
+		cs := newControllerServer(driver, sm)
+		ss, _ := driver.config.StorageServiceManager.SetupService(context.TODO(), nil)
+		if _, err := ss.CreateBucket(context.TODO(), test.bucket); err != nil {
+			t.Fatalf("failed to create shared bucket: %v", err)
+		}
+
+		if _, err := cs.DeleteVolume(context.TODO(), &csi.DeleteVolumeRequest{VolumeId: "test-shared-bucket:test-volume-id/", Secrets: secrets}); err != nil {
+			t.Errorf("test %q failed: got error %q, expected error nil", test.name, err)
+		}
+		if !reflect.DeepEqual(sm.deletedFolders, test.expectDeletedFolders) {
+			t.Errorf("test %q failed:\ngot deleted folders %v,\nexpected deleted folders %v", test.name, sm.deletedFolders, test.expectDeletedFolders)
+		}
+	}
+}
+
 func TestDeleteVolume(t *testing.T) {
 	t.Parallel()
 	cases := []struct {
@@ -140,6 +668,18 @@ func TestDeleteVolume(t *testing.T) {
 			},
 			resp: &csi.DeleteVolumeResponse{},
 		},
+		{
+			name: "prefix volume in a deleted shared bucket",
+			req: &csi.DeleteVolumeRequest{
+				VolumeId: "test-shared-bucket:test-volume-id/",
+				Secrets: map[string]string{
+					"projectID":               "test-project",
+					"serviceAccountName":      "test-sa-name",
+					"serviceAccountNamespace": "test-sa-namespace",
+				},
+			},
+			resp: &csi.DeleteVolumeResponse{},
+		},
 		{
 			name:      "empty id",
 			req:       &csi.DeleteVolumeRequest{},
@@ -161,3 +701,258 @@ func TestDeleteVolume(t *testing.T) {
 		}
 	}
 }
+
+func TestProvisioningMetrics(t *testing.T) {
+	t.Parallel()
+	driver := initTestDriver(t, nil)
+	cs := newControllerServer(driver, driver.config.StorageServiceManager)
+	secrets := map[string]string{
+		"projectID":               "test-project",
+		"serviceAccountName":      "test-sa-name",
+		"serviceAccountNamespace": "test-sa-namespace",
+	}
+	volumeCapabilities := []*csi.VolumeCapability{
+		{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		},
+	}
+
+	_, _ = cs.CreateVolume(context.TODO(), &csi.CreateVolumeRequest{Name: testVolumeID, VolumeCapabilities: volumeCapabilities, Secrets: secrets})
+	_, _ = cs.CreateVolume(context.TODO(), &csi.CreateVolumeRequest{VolumeCapabilities: volumeCapabilities, Secrets: secrets})
+	_, _ = cs.DeleteVolume(context.TODO(), &csi.DeleteVolumeRequest{VolumeId: testVolumeID, Secrets: secrets})
+
+	mm, _ := driver.config.MetricsManager.(*metrics.FakeMetricsManager)
+	expected := []string{"CreateVolume OK", "CreateVolume InvalidArgument", "DeleteVolume OK"}
+	if !reflect.DeepEqual(mm.ProvisioningOperations, expected) {
+		t.Errorf("got provisioning operations %v, expected %v", mm.ProvisioningOperations, expected)
+	}
+	if len(mm.BucketCreationFailures) != 0 {
+		t.Errorf("got bucket creation failures %v, expected none", mm.BucketCreationFailures)
+	}
+}
+
+func TestCreateVolumeReclaimPolicyLabel(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name          string
+		reclaimPolicy *corev1.PersistentVolumeReclaimPolicy
+		expectedLabel string
+	}{
+		{
+			name:          "default reclaim policy",
+			expectedLabel: "delete",
+		},
+		{
+			name:          "retain reclaim policy",
+			reclaimPolicy: ptr.To(corev1.PersistentVolumeReclaimRetain),
+			expectedLabel: "retain",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Logf("test case: %s", tc.name)
+		fakeClientset := clientset.NewFakeClientset()
+		fakeClientset.CreatePVC(&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "test-ns"},
+			Spec:       corev1.PersistentVolumeClaimSpec{StorageClassName: ptr.To("test-sc")},
+		})
+		fakeClientset.CreateStorageClass(&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "test-sc"}, ReclaimPolicy: tc.reclaimPolicy})
+		driver := initTestDriverWithCustomNodeServer(t, nil, fakeClientset)
+		cs := newControllerServer(driver, driver.config.StorageServiceManager)
+		req := &csi.CreateVolumeRequest{
+			Name: testVolumeID,
+			VolumeCapabilities: []*csi.VolumeCapability{
+				{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+			},
+			Parameters: map[string]string{
+				ParameterKeyPVCName:      "test-pvc",
+				ParameterKeyPVCNamespace: "test-ns",
+			},
+			Secrets: map[string]string{
+				"projectID":               "test-project",
+				"serviceAccountName":      "test-sa-name",
+				"serviceAccountNamespace": "test-sa-namespace",
+			},
+		}
+		if _, err := cs.CreateVolume(context.TODO(), req); err != nil {
+			t.Fatalf("CreateVolume failed: %v", err)
+		}
+
+		ss, err := driver.config.StorageServiceManager.SetupServiceWithDefaultCredential(context.TODO())
+		if err != nil {
+			t.Fatalf("failed to set up storage service: %v", err)
+		}
+		bucket, err := ss.GetBucket(context.TODO(), &storage.ServiceBucket{Name: testVolumeID})
+		if err != nil {
+			t.Fatalf("failed to get bucket: %v", err)
+		}
+		if got := bucket.Labels[tagKeyReclaimPolicy]; got != tc.expectedLabel {
+			t.Errorf("Got reclaim policy label %q, but expected %q", got, tc.expectedLabel)
+		}
+	}
+}
+
+func TestCollectOrphanedBuckets(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name             string
+		policy           string
+		expectedBuckets  []string
+		expectedOrphaned int
+	}{
+		{
+			name:             "report orphaned buckets",
+			policy:           OrphanedBucketPolicyReport,
+			expectedBuckets:  []string{"bound-bucket", "new-bucket", "orphaned-bucket", "other-cluster-bucket", "retained-bucket", "unlabeled-bucket"},
+			expectedOrphaned: 1,
+		},
+		{
+			name:             "delete orphaned buckets",
+			policy:           OrphanedBucketPolicyDelete,
+			expectedBuckets:  []string{"bound-bucket", "new-bucket", "other-cluster-bucket", "retained-bucket", "unlabeled-bucket"},
+			expectedOrphaned: 0,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Logf("test case: %s", tc.name)
+		fakeClientset := clientset.NewFakeClientset()
+		fakeClientset.CreatePV(&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "bound-pv"},
+			Spec: corev1.PersistentVolumeSpec{
+				PersistentVolumeSource: corev1.PersistentVolumeSource{
+					CSI: &corev1.CSIPersistentVolumeSource{Driver: "test-driver", VolumeHandle: "bound-bucket"},
+				},
+			},
+		})
+		driver := initTestDriverWithCustomNodeServer(t, nil, fakeClientset)
+		driver.config.OrphanedBucketGCProject = "test-project"
+		driver.config.OrphanedBucketGCPolicy = tc.policy
+		cs, _ := newControllerServer(driver, driver.config.StorageServiceManager).(*controllerServer)
+
+		ss, err := driver.config.StorageServiceManager.SetupServiceWithDefaultCredential(context.TODO())
+		if err != nil {
+			t.Fatalf("failed to set up storage service: %v", err)
+		}
+		labels := map[string]string{tagKeyCreatedBy: "test-driver", tagKeyCreatedForCluster: "fake-uid-kube-system", tagKeyReclaimPolicy: "delete"}
+		retainLabels := map[string]string{tagKeyCreatedBy: "test-driver", tagKeyCreatedForCluster: "fake-uid-kube-system", tagKeyReclaimPolicy: "retain"}
+		otherClusterLabels := map[string]string{tagKeyCreatedBy: "test-driver", tagKeyCreatedForCluster: "other-uid", tagKeyReclaimPolicy: "delete"}
+		created := time.Now().Add(-2 * orphanedBucketGracePeriod)
+		for _, b := range []*storage.ServiceBucket{
+			{Name: "orphaned-bucket", Labels: labels, Created: created},
+			{Name: "bound-bucket", Labels: labels, Created: created},
+			{Name: "new-bucket", Labels: labels, Created: time.Now()},
+			{Name: "other-cluster-bucket", Labels: otherClusterLabels, Created: created},
+			{Name: "retained-bucket", Labels: retainLabels, Created: created},
+			{Name: "unlabeled-bucket", Labels: map[string]string{tagKeyCreatedBy: "test-driver"}, Created: created},
+		} {
+			b.Project = "test-project"
+			if _, err := ss.CreateBucket(context.TODO(), b); err != nil {
+				t.Fatalf("failed to create bucket %q: %v", b.Name, err)
+			}
+		}
+
+		cs.collectOrphanedBuckets()
+
+		buckets, err := ss.ListBuckets(context.TODO(), "test-project")
+		if err != nil {
+			t.Fatalf("failed to list buckets: %v", err)
+		}
+		names := []string{}
+		for _, b := range buckets {
+			names = append(names, b.Name)
+		}
+		slices.Sort(names)
+		if !reflect.DeepEqual(names, tc.expectedBuckets) {
+			t.Errorf("Got buckets %v, but expected %v", names, tc.expectedBuckets)
+		}
+
+		mm, _ := driver.config.MetricsManager.(*metrics.FakeMetricsManager)
+		if mm.OrphanedBuckets != tc.expectedOrphaned {
+			t.Errorf("Got %v orphaned buckets, but expected %v", mm.OrphanedBuckets, tc.expectedOrphaned)
+		}
+	}
+}
+
+// quotaExhaustedServiceManager sets up storage services that fail to create buckets with a rate limit error.
+type quotaExhaustedServiceManager struct {
+	storage.ServiceManager
+	createCalls int
+}
+
+type quotaExhaustedService struct {
+	storage.Service
+	sm *quotaExhaustedServiceManager
+}
+
+func (m *quotaExhaustedServiceManager) SetupService(ctx context.Context, ts oauth2.TokenSource) (storage.Service, error) {
+	ss, err := m.ServiceManager.SetupService(ctx, ts)
+
+	return &quotaExhaustedService{Service: ss, sm: m}, err
+}
+
+func (s *quotaExhaustedService) CreateBucket(_ context.Context, _ *storage.ServiceBucket) (*storage.ServiceBucket, error) {
+	s.sm.createCalls++
+
+	return nil, &googleapi.Error{Code: http.StatusTooManyRequests, Message: "The project exceeded the rate limit for creating and deleting buckets."}
+}
+
+func TestCreateVolumeBucketQuotaExhausted(t *testing.T) {
+	t.Parallel()
+	fakeClientset := clientset.NewFakeClientset()
+	fakeClientset.CreatePVC(&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "test-ns"}})
+	driver := initTestDriverWithCustomNodeServer(t, nil, fakeClientset)
+	sm := &quotaExhaustedServiceManager{ServiceManager: driver.config.StorageServiceManager}
+	cs := newControllerServer(driver, sm)
+	req := &csi.CreateVolumeRequest{
+		Name: testVolumeID,
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			},
+		},
+		Parameters: map[string]string{
+			ParameterKeyPVCName:      "test-pvc",
+			ParameterKeyPVCNamespace: "test-ns",
+		},
+		Secrets: map[string]string{
+			"projectID":               "test-project",
+			"serviceAccountName":      "test-sa-name",
+			"serviceAccountNamespace": "test-sa-namespace",
+		},
+	}
+
+	for i := range 2 {
+		if _, err := cs.CreateVolume(context.TODO(), req); status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("call %v: Got error %v, but expected code %v", i, err, codes.ResourceExhausted)
+		}
+	}
+
+	// The second call backs off without calling the GCS API.
+	if sm.createCalls != 1 {
+		t.Errorf("Got %v bucket creation calls, but expected 1", sm.createCalls)
+	}
+
+	expectedEvent := fmt.Sprintf("Warning %v Bucket creation in project %q exceeded a GCS quota or rate limit, retrying after %v. To provision many volumes, consider setting the StorageClass parameter %q to provision the volumes as sub-directories of a shared bucket.",
+		eventReasonBucketQuotaExhausted, "test-project", bucketCreationInitialBackoff, ParameterKeySharedBucketName)
+	if !reflect.DeepEqual(fakeClientset.Events, []string{expectedEvent}) {
+		t.Errorf("Got events %v, but expected %v", fakeClientset.Events, []string{expectedEvent})
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/storage"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// AnnotationAllowDestroyNonEmpty on a PersistentVolume overrides the deletion protection of its volume,
+	// so that DeleteVolume deletes the bucket, or the objects under the prefix, however many objects it holds.
+	AnnotationAllowDestroyNonEmpty = "gcsfuse.csi.storage.gke.io/allow-destroy-non-empty"
+
+	eventReasonDeletionProtected = "GCSFuseDeletionProtected"
+)
+
+// deletionProtectionVolumeContext returns the volume attributes that record the deletion protection of the StorageClass parameters
+// in the PersistentVolume, because DeleteVolume does not receive the parameters.
+func deletionProtectionVolumeContext(parameters map[string]string) map[string]string {
+	// The parameters are validated, so the values are valid if set.
+	if prevent, _ := strconv.ParseBool(parameters[ParameterKeyPreventDestroyNonEmpty]); !prevent {
+		return nil
+	}
+
+	volumeContext := map[string]string{VolumeContextKeyPreventDestroyNonEmpty: util.TrueStr}
+	if maxObjects, ok := parameters[ParameterKeyPreventDestroyMaxObjects]; ok {
+		volumeContext[VolumeContextKeyPreventDestroyMaxObjects] = maxObjects
+	}
+
+	return volumeContext
+}
+
+// checkDeletionProtection returns an error, and records a warning event on the PersistentVolume, if the volume is protected
+// from deletion and holds more objects than the protection allows.
+// The external-provisioner keeps the PersistentVolume until DeleteVolume succeeds, so the deletion is retried until the objects
+// are removed or the PersistentVolume is annotated with AnnotationAllowDestroyNonEmpty. Volumes without a PersistentVolume are not protected.
+func (s *controllerServer) checkDeletionProtection(ctx context.Context, storageService storage.Service, volumeID string) error {
+	pv, err := s.driver.config.K8sClients.GetPVByVolumeHandle(s.driver.config.Name, volumeID)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "failed to look up the PersistentVolume of volume %q: %v", volumeID, err)
+	}
+	if pv == nil {
+		return nil
+	}
+
+	attributes := pv.Spec.CSI.VolumeAttributes
+	if prevent, _ := strconv.ParseBool(attributes[VolumeContextKeyPreventDestroyNonEmpty]); !prevent {
+		return nil
+	}
+	if allow, _ := strconv.ParseBool(pv.Annotations[AnnotationAllowDestroyNonEmpty]); allow {
+		klog.Infof("PersistentVolume %q is annotated with %q, deleting volume %q regardless of its objects", pv.Name, AnnotationAllowDestroyNonEmpty, volumeID)
+
+		return nil
+	}
+	maxObjects := 0
+	if value, ok := attributes[VolumeContextKeyPreventDestroyMaxObjects]; ok {
+		if maxObjects, err = strconv.Atoi(value); err != nil || maxObjects < 0 {
+			return status.Errorf(codes.FailedPrecondition, "volume attribute %v of PersistentVolume %q only accepts a non-negative int value, got %q", VolumeContextKeyPreventDestroyMaxObjects, pv.Name, value)
+		}
+	}
+
+	bucketName, prefix, ok := parsePrefixVolumeID(volumeID)
+	if !ok {
+		bucketName = volumeID
+	}
+	// One more object than allowed is enough to refuse the deletion, so large buckets are not fully listed.
+	limit := maxObjects
+	if limit < math.MaxInt {
+		limit++
+	}
+	usage, err := storageService.GetObjectUsage(ctx, &storage.ServiceBucket{Name: bucketName}, prefix, limit)
+	if storage.IsNotExistErr(err) {
+		return nil
+	}
+	if err != nil {
+		return status.Errorf(storage.ParseErrCode(err), "failed to count the objects of volume %q in GCS bucket %q: %v", volumeID, bucketName, err)
+	}
+	if usage.Objects <= int64(maxObjects) {
+		return nil
+	}
+
+	s.driver.config.K8sClients.Eventf(pv, corev1.EventTypeWarning, eventReasonDeletionProtected,
+		"Volume %q in GCS bucket %q is not deleted, because it holds more than %d objects and its StorageClass sets the parameter %q. Remove the objects, or annotate the PersistentVolume with %s=true to delete them.",
+		volumeID, bucketName, maxObjects, ParameterKeyPreventDestroyNonEmpty, AnnotationAllowDestroyNonEmpty)
+
+	return status.Errorf(codes.FailedPrecondition, "volume %q holds more than %d objects and is protected from deletion, annotate PersistentVolume %q with %s=true to delete it", volumeID, maxObjects, pv.Name, AnnotationAllowDestroyNonEmpty)
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/google/go-cmp/cmp"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/clientset"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/storage"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// objectCountServiceManager sets up storage services that report the given number of objects in every bucket.
+type objectCountServiceManager struct {
+	storage.ServiceManager
+	objects int64
+}
+
+type objectCountService struct {
+	storage.Service
+	objects int64
+}
+
+func (m *objectCountServiceManager) SetupService(ctx context.Context, ts oauth2.TokenSource) (storage.Service, error) {
+	ss, err := m.ServiceManager.SetupService(ctx, ts)
+
+	return &objectCountService{Service: ss, objects: m.objects}, err
+}
+
+func (s *objectCountService) GetObjectUsage(ctx context.Context, obj *storage.ServiceBucket, prefix string, maxObjects int) (*storage.ObjectUsage, error) {
+	if _, err := s.Service.GetObjectUsage(ctx, obj, prefix, maxObjects); err != nil {
+		return nil, err
+	}
+
+	return &storage.ObjectUsage{Objects: min(s.objects, int64(maxObjects)), Complete: s.objects <= int64(maxObjects)}, nil
+}
+
+func TestDeletionProtectionVolumeContext(t *testing.T) {
+	t.Parallel()
+
+	secrets := map[string]string{
+		"projectID":               "test-project",
+		"serviceAccountName":      "test-sa-name",
+		"serviceAccountNamespace": "test-sa-namespace",
+	}
+	volumeCapabilities := []*csi.VolumeCapability{
+		{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+		},
+	}
+	parameters := map[string]string{ParameterKeyPreventDestroyNonEmpty: "true", ParameterKeyPreventDestroyMaxObjects: "10"}
+
+	cs := initTestController(t)
+	resp, err := cs.CreateVolume(context.TODO(), &csi.CreateVolumeRequest{Name: testVolumeID, VolumeCapabilities: volumeCapabilities, Secrets: secrets, Parameters: parameters})
+	if err != nil {
+		t.Fatalf("failed to create volume: %v", err)
+	}
+	expected := map[string]string{
+		VolumeContextKeyHierarchicalNamespace:    util.FalseStr,
+		VolumeContextKeyPreventDestroyNonEmpty:   util.TrueStr,
+		VolumeContextKeyPreventDestroyMaxObjects: "10",
+	}
+	if diff := cmp.Diff(expected, resp.GetVolume().GetVolumeContext()); diff != "" {
+		t.Errorf("unexpected volume context (-want +got):\n%s", diff)
+	}
+
+	parameters = map[string]string{ParameterKeySharedBucketName: testVolumeID, ParameterKeyPreventDestroyNonEmpty: "true"}
+	resp, err = cs.CreateVolume(context.TODO(), &csi.CreateVolumeRequest{Name: "test-prefix", VolumeCapabilities: volumeCapabilities, Secrets: secrets, Parameters: parameters})
+	if err != nil {
+		t.Fatalf("failed to create volume in shared bucket: %v", err)
+	}
+	if got := resp.GetVolume().GetVolumeContext()[VolumeContextKeyPreventDestroyNonEmpty]; got != util.TrueStr {
+		t.Errorf("got volume attribute %v %q for a volume in a shared bucket, want %q", VolumeContextKeyPreventDestroyNonEmpty, got, util.TrueStr)
+	}
+}
+
+func TestDeleteVolumeDeletionProtection(t *testing.T) {
+	t.Parallel()
+
+	secrets := map[string]string{
+		"projectID":               "test-project",
+		"serviceAccountName":      "test-sa-name",
+		"serviceAccountNamespace": "test-sa-namespace",
+	}
+
+	testCases := []struct {
+		name        string
+		volumeID    string
+		attributes  map[string]string
+		annotations map[string]string
+		noPV        bool
+		objects     int64
+		expectCode  codes.Code
+	}{
+		{
+			name:     "not protected",
+			volumeID: testVolumeID,
+			objects:  3,
+		},
+		{
+			name:       "protected empty bucket",
+			volumeID:   testVolumeID,
+			attributes: map[string]string{VolumeContextKeyPreventDestroyNonEmpty: util.TrueStr},
+		},
+		{
+			name:       "protected non-empty bucket",
+			volumeID:   testVolumeID,
+			attributes: map[string]string{VolumeContextKeyPreventDestroyNonEmpty: util.TrueStr},
+			objects:    3,
+			expectCode: codes.FailedPrecondition,
+		},
+		{
+			name:       "protected bucket below the maximum objects",
+			volumeID:   testVolumeID,
+			attributes: map[string]string{VolumeContextKeyPreventDestroyNonEmpty: util.TrueStr, VolumeContextKeyPreventDestroyMaxObjects: "3"},
+			objects:    3,
+		},
+		{
+			name:       "protected bucket above the maximum objects",
+			volumeID:   testVolumeID,
+			attributes: map[string]string{VolumeContextKeyPreventDestroyNonEmpty: util.TrueStr, VolumeContextKeyPreventDestroyMaxObjects: "3"},
+			objects:    4,
+			expectCode: codes.FailedPrecondition,
+		},
+		{
+			name:       "protected bucket with the largest maximum objects",
+			volumeID:   testVolumeID,
+			attributes: map[string]string{VolumeContextKeyPreventDestroyNonEmpty: util.TrueStr, VolumeContextKeyPreventDestroyMaxObjects: strconv.Itoa(math.MaxInt)},
+			objects:    3,
+		},
+		{
+			name:        "override annotation",
+			volumeID:    testVolumeID,
+			attributes:  map[string]string{VolumeContextKeyPreventDestroyNonEmpty: util.TrueStr},
+			annotations: map[string]string{AnnotationAllowDestroyNonEmpty: util.TrueStr},
+			objects:     3,
+		},
+		{
+			name:     "no PersistentVolume",
+			volumeID: testVolumeID,
+			noPV:     true,
+			objects:  3,
+		},
+		{
+			name:       "protected non-empty prefix",
+			volumeID:   testVolumeID + ":test-prefix/",
+			attributes: map[string]string{VolumeContextKeyPreventDestroyNonEmpty: util.TrueStr},
+			objects:    1,
+			expectCode: codes.FailedPrecondition,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fakeClientset := clientset.NewFakeClientset()
+			if !tc.noPV {
+				fakeClientset.CreatePV(&corev1.PersistentVolume{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-pv", Annotations: tc.annotations},
+					Spec: corev1.PersistentVolumeSpec{
+						PersistentVolumeSource: corev1.PersistentVolumeSource{
+							CSI: &corev1.CSIPersistentVolumeSource{Driver: "test-driver", VolumeHandle: tc.volumeID, VolumeAttributes: tc.attributes},
+						},
+					},
+				})
+			}
+			driver := initTestDriverWithCustomNodeServer(t, nil, fakeClientset)
+			sm := &objectCountServiceManager{ServiceManager: driver.config.StorageServiceManager, objects: tc.objects}
+			cs := newControllerServer(driver, sm)
+			ss, _ := sm.SetupService(context.TODO(), nil)
+			if _, err := ss.CreateBucket(context.TODO(), &storage.ServiceBucket{Name: testVolumeID}); err != nil {
+				t.Fatalf("failed to create bucket: %v", err)
+			}
+
+			_, err := cs.DeleteVolume(context.TODO(), &csi.DeleteVolumeRequest{VolumeId: tc.volumeID, Secrets: secrets})
+			if code := status.Code(err); code != tc.expectCode {
+				t.Fatalf("got error %v, want code %v", err, tc.expectCode)
+			}
+
+			exists, _ := ss.CheckBucketExists(context.TODO(), &storage.ServiceBucket{Name: testVolumeID})
+			if isBucket := !strings.Contains(tc.volumeID, ":"); isBucket && exists != (tc.expectCode != codes.OK) {
+				t.Errorf("got bucket exists %v after the deletion, want %v", exists, tc.expectCode != codes.OK)
+			}
+			protected := len(fakeClientset.Events) == 1 && strings.HasPrefix(fakeClientset.Events[0], "Warning "+eventReasonDeletionProtected)
+			if protected != (tc.expectCode != codes.OK) {
+				t.Errorf("got events %q, want a %v warning event: %v", fakeClientset.Events, eventReasonDeletionProtected, tc.expectCode != codes.OK)
+			}
+		})
+	}
+}
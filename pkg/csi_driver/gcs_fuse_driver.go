@@ -18,25 +18,47 @@ limitations under the License.
 package driver
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/auth"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/clientset"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/storage"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/metrics"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/volumespec"
+	"golang.org/x/mod/semver"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 	"k8s.io/mount-utils"
 )
 
-const DefaultName = "gcsfuse.csi.storage.gke.io"
+const (
+	DefaultName = volumespec.DefaultDriverName
+
+	// DefaultStartupTaintKey is the key of the taint that keeps Pods off a node until the driver is registered on it.
+	DefaultStartupTaintKey = DefaultName + "/agent-not-ready"
+
+	startupTaintPollInterval = 5 * time.Second
+
+	// Policies for the buckets that the controller provisioned for PersistentVolumes that no longer exist.
+	OrphanedBucketPolicyReport = "Report"
+	OrphanedBucketPolicyDelete = "Delete"
+
+	// orphanedBucketGCInterval is how often the controller looks for orphaned buckets.
+	orphanedBucketGCInterval = time.Hour
+)
 
 type GCSDriverConfig struct {
 	Name                  string // Driver name
 	Version               string // Driver version
+	GcsfuseVersion        string // Version of the gcsfuse binary bundled in the sidecar container image
+	SidecarImage          string // Sidecar container image
 	NodeID                string // Node name
 	RunController         bool   // Run CSI controller service
 	RunNode               bool   // Run CSI node service
@@ -45,6 +67,63 @@ type GCSDriverConfig struct {
 	Mounter               mount.Interface
 	K8sClients            clientset.Interface
 	MetricsManager        metrics.Manager
+	// GcsfuseExperimentalFlagsAllowlist lists the gcsfuse flags that volumes may set using the gcsfuseExperimentalFlags volume attribute.
+	GcsfuseExperimentalFlagsAllowlist []string
+	// GcsfuseNodeOpsPerSecBudget caps the total GCS operations per second of the volumes on the node, from which each volume
+	// reserves its limit when it is mounted. Zero means no cap.
+	GcsfuseNodeOpsPerSecBudget int
+	// GcsfuseNodeMemoryBudgetBytes is the total memory of the sidecar containers on the node above which new volumes are not mounted.
+	// Zero means no budget.
+	GcsfuseNodeMemoryBudgetBytes int64
+	// VolumeStatsMaxObjects is the maximum number of objects counted for the usage of a volume reported by NodeGetVolumeStats.
+	// Zero disables counting the objects, and the usage is reported as zero.
+	VolumeStatsMaxObjects int
+	// StartupTaintKey is the key of the taint removed from the node once kubelet has registered the driver. Empty disables the removal.
+	StartupTaintKey string
+	// DownscopeReadOnlyVolumeTokens makes the sidecar authenticate read-only volumes with tokens that only grant
+	// roles/storage.objectViewer on the volume bucket.
+	DownscopeReadOnlyVolumeTokens bool
+	// OrphanedBucketGCProject is the project where the controller looks for the buckets it provisioned for PersistentVolumes
+	// that no longer exist. Empty disables the garbage collection of orphaned buckets.
+	OrphanedBucketGCProject string
+	// OrphanedBucketGCServiceAccount is the Kubernetes ServiceAccount, in the form namespace/name, used to list and delete
+	// orphaned buckets. Empty uses the default credentials of the controller.
+	OrphanedBucketGCServiceAccount string
+	// OrphanedBucketGCPolicy is either Report or Delete.
+	OrphanedBucketGCPolicy string
+	// SidecarMinVersion is the oldest sidecar mounter version that the node service accepts without a Pod warning event.
+	// Empty accepts any sidecar mounter version.
+	SidecarMinVersion string
+	// StorageEndpointURL is the URL of the Cloud Storage JSON API that gcsfuse sends the requests to,
+	// passed as the gcsfuse custom-endpoint flag. Empty uses the default endpoint.
+	StorageEndpointURL string
+	// FirstMountRetryBudget is how long the bucket access check of a volume mounted to a target path for the first time
+	// is retried, at FirstMountRetryInterval. Zero disables the retries.
+	FirstMountRetryBudget   time.Duration
+	FirstMountRetryInterval time.Duration
+	// RemountRetryBudget is how long the bucket access check of a volume that was already mounted to the target path
+	// is retried, with an exponential backoff starting at RemountRetryInitialBackoff. Zero disables the retries.
+	RemountRetryBudget         time.Duration
+	RemountRetryInitialBackoff time.Duration
+	// UnmountFlushWarningThreshold is how long the unmount of a volume, during which gcsfuse flushes the pending writes,
+	// can take before a warning event is recorded on the Pod. Zero disables the events.
+	UnmountFlushWarningThreshold time.Duration
+	// AuditSink receives a record of every volume mount and unmount on the node. Nil disables the audit records.
+	AuditSink AuditSink
+	// TargetPathDataPolicy is what the node service does when the target path of a volume that is not mounted contains data,
+	// either Fail, Clean or MountOver. Empty means MountOver.
+	TargetPathDataPolicy string
+	// PublishConfigHash makes the node service annotate the Pods with a hash of the resolved gcsfuse configuration of each volume.
+	PublishConfigHash bool
+	// EndSidecarCPUBoost makes the node service restore the sidecar container CPU resources with in-place Pod resize
+	// once the CPU boost window of the Pod ends.
+	EndSidecarCPUBoost bool
+	// CheckFUSECompatibility makes the node service check at startup whether the node can mount gcsfuse volumes,
+	// and publish the result as a node condition.
+	CheckFUSECompatibility bool
+	// SandboxedRuntimeHandlers are the RuntimeClass handlers of the Pods whose volumes the node service does not mount,
+	// because the sidecar container of a sandboxed Pod cannot serve them.
+	SandboxedRuntimeHandlers []string
 }
 
 type GCSDriver struct {
@@ -71,6 +150,31 @@ func NewGCSDriver(config *GCSDriverConfig) (*GCSDriver, error) {
 	if !config.RunController && !config.RunNode {
 		return nil, errors.New("must run at least one controller or node service")
 	}
+	if config.OrphanedBucketGCPolicy != "" && config.OrphanedBucketGCPolicy != OrphanedBucketPolicyReport && config.OrphanedBucketGCPolicy != OrphanedBucketPolicyDelete {
+		return nil, fmt.Errorf("orphaned bucket policy must be either %q or %q, got %q", OrphanedBucketPolicyReport, OrphanedBucketPolicyDelete, config.OrphanedBucketGCPolicy)
+	}
+	switch config.TargetPathDataPolicy {
+	case "", TargetPathDataPolicyFail, TargetPathDataPolicyClean, TargetPathDataPolicyMountOver:
+	default:
+		return nil, fmt.Errorf("target path data policy must be one of %q, %q or %q, got %q", TargetPathDataPolicyFail, TargetPathDataPolicyClean, TargetPathDataPolicyMountOver, config.TargetPathDataPolicy)
+	}
+	if sa := config.OrphanedBucketGCServiceAccount; sa != "" {
+		if namespace, name, ok := strings.Cut(sa, "/"); !ok || namespace == "" || name == "" {
+			return nil, fmt.Errorf("orphaned bucket service account must be in the form namespace/name, got %q", sa)
+		}
+	}
+	if config.SidecarMinVersion != "" && !semver.IsValid(config.SidecarMinVersion) {
+		return nil, fmt.Errorf("sidecar minimum version %q is not a semantic version", config.SidecarMinVersion)
+	}
+	if config.FirstMountRetryBudget < 0 || config.RemountRetryBudget < 0 {
+		return nil, errors.New("mount retry budgets must not be negative")
+	}
+	if config.FirstMountRetryBudget > 0 && config.FirstMountRetryInterval <= 0 {
+		return nil, fmt.Errorf("first mount retry interval must be positive when the retry budget is %v", config.FirstMountRetryBudget)
+	}
+	if config.RemountRetryBudget > 0 && config.RemountRetryInitialBackoff <= 0 {
+		return nil, fmt.Errorf("remount retry initial backoff must be positive when the retry budget is %v", config.RemountRetryBudget)
+	}
 
 	driver := &GCSDriver{
 		config: config,
@@ -83,6 +187,8 @@ func NewGCSDriver(config *GCSDriverConfig) (*GCSDriver, error) {
 		csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
 		csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
 		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
 	}
 	driver.addVolumeCapabilityAccessModes(vcam)
 
@@ -91,6 +197,9 @@ func NewGCSDriver(config *GCSDriverConfig) (*GCSDriver, error) {
 	if config.RunNode {
 		nscap := []csi.NodeServiceCapability_RPC_Type{
 			csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP,
+			csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+			csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+			csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
 		}
 		driver.ns = newNodeServer(driver, config.Mounter)
 		driver.addNodeServiceCapabilities(nscap)
@@ -98,6 +207,7 @@ func NewGCSDriver(config *GCSDriverConfig) (*GCSDriver, error) {
 	if config.RunController {
 		csc := []csi.ControllerServiceCapability_RPC_Type{
 			csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+			csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
 		}
 		driver.addControllerServiceCapabilities(csc)
 
@@ -192,7 +302,61 @@ func (driver *GCSDriver) ValidateControllerServiceRequest(c csi.ControllerServic
 func (driver *GCSDriver) Run(endpoint string) {
 	klog.Infof("Running driver: %v", driver.config.Name)
 
+	// The FUSE compatibility is checked before the node service serves its first mount.
+	if ns, ok := driver.ns.(*nodeServer); ok && driver.config.CheckFUSECompatibility {
+		ns.checkFUSECompatibility()
+		go ns.publishFUSECompatibility(context.Background())
+	}
+
 	s := NewNonBlockingGRPCServer()
 	s.Start(endpoint, driver.ids, driver.cs, driver.ns)
+
+	if driver.config.RunNode && driver.config.StartupTaintKey != "" {
+		go driver.removeStartupTaint(context.Background())
+	}
+
+	if ns, ok := driver.ns.(*nodeServer); ok && driver.config.MetricsManager != nil {
+		go wait.Until(ns.recordGcsfuseMemory, gcsfuseMemoryInterval, wait.NeverStop)
+	}
+
+	if cs, ok := driver.cs.(*controllerServer); ok && driver.config.OrphanedBucketGCProject != "" {
+		go wait.Until(cs.collectOrphanedBuckets, orphanedBucketGCInterval, wait.NeverStop)
+	}
+
 	s.Wait()
 }
+
+// removeStartupTaint removes the startup taint from the node once kubelet has registered the driver,
+// so that Pods with gcsfuse volumes are not scheduled to the node before the volumes can be mounted.
+// Cluster admins add the taint to new nodes, for example using node pool taints.
+func (driver *GCSDriver) removeStartupTaint(ctx context.Context) {
+	nodeID, taintKey := driver.config.NodeID, driver.config.StartupTaintKey
+	err := wait.PollUntilContextCancel(ctx, startupTaintPollInterval, true, func(ctx context.Context) (bool, error) {
+		registered, err := driver.config.K8sClients.IsDriverRegistered(ctx, nodeID, driver.config.Name)
+		if err != nil {
+			klog.Warningf("failed to check if driver %q is registered on node %q: %v", driver.config.Name, nodeID, err)
+
+			return false, nil
+		}
+		if !registered {
+			klog.V(4).Infof("waiting for driver %q to be registered on node %q before removing the startup taint %q", driver.config.Name, nodeID, taintKey)
+
+			return false, nil
+		}
+
+		removed, err := driver.config.K8sClients.RemoveNodeTaint(ctx, nodeID, taintKey)
+		if err != nil {
+			klog.Warningf("failed to remove the startup taint: %v", err)
+
+			return false, nil
+		}
+		if removed {
+			klog.Infof("removed the startup taint %q from node %q", taintKey, nodeID)
+		}
+
+		return true, nil
+	})
+	if err != nil {
+		klog.Errorf("stopped removing the startup taint %q from node %q: %v", taintKey, nodeID, err)
+	}
+}
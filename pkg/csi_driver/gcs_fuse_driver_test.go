@@ -18,14 +18,17 @@ limitations under the License.
 package driver
 
 import (
+	"context"
 	"errors"
 	"testing"
+	"time"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/auth"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/clientset"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/storage"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
 	mount "k8s.io/mount-utils"
 )
 
@@ -167,6 +170,28 @@ func TestDriverValidateVolumeCapability(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "mount, snsw ",
+			capability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+				},
+			},
+		},
+		{
+			name: "mount, snmw ",
+			capability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
+				},
+			},
+		},
 		// {
 		// 	name: "mount, invalid fstype",
 		// 	capability: &csi.VolumeCapability{
@@ -285,3 +310,48 @@ func TestDriverValidateVolumeCapabilities(t *testing.T) {
 		}
 	}
 }
+
+func TestRemoveStartupTaint(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name              string
+		registeredDrivers []string
+		expectedTaints    int
+	}{
+		{
+			name:              "driver registered",
+			registeredDrivers: []string{"test-driver"},
+			expectedTaints:    1,
+		},
+		{
+			name:           "driver not registered",
+			expectedTaints: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fakeClientSet := clientset.NewFakeClientset()
+			fakeClientSet.RegisteredDrivers = tc.registeredDrivers
+			node, _ := fakeClientSet.GetNode("test-node")
+			node.Spec.Taints = []corev1.Taint{
+				{Key: DefaultStartupTaintKey, Effect: corev1.TaintEffectNoSchedule},
+				{Key: "other-taint", Effect: corev1.TaintEffectNoSchedule},
+			}
+
+			driver := initTestDriverWithCustomNodeServer(t, mount.NewFakeMounter([]mount.MountPoint{}), fakeClientSet)
+			driver.config.StartupTaintKey = DefaultStartupTaintKey
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			driver.removeStartupTaint(ctx)
+
+			if len(node.Spec.Taints) != tc.expectedTaints || node.Spec.Taints[len(node.Spec.Taints)-1].Key != "other-taint" {
+				t.Errorf("got taints %v, expected %d taints", node.Spec.Taints, tc.expectedTaints)
+			}
+		})
+	}
+}
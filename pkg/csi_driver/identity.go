@@ -31,10 +31,21 @@ func newIdentityServer(driver *GCSDriver) csi.IdentityServer {
 	return &identityServer{driver: driver}
 }
 
+// GetPluginInfo reports the gcsfuse version and the sidecar container image in the plugin manifest.
+// They are not reported as NodeGetInfo accessible topology, because kubelet rejects topology value changes on driver upgrades.
 func (s *identityServer) GetPluginInfo(_ context.Context, _ *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
+	manifest := map[string]string{}
+	if s.driver.config.GcsfuseVersion != "" {
+		manifest["gcsfuse-version"] = s.driver.config.GcsfuseVersion
+	}
+	if s.driver.config.SidecarImage != "" {
+		manifest["sidecar-image"] = s.driver.config.SidecarImage
+	}
+
 	return &csi.GetPluginInfoResponse{
 		Name:          s.driver.config.Name,
 		VendorVersion: s.driver.config.Version,
+		Manifest:      manifest,
 	}, nil
 }
 
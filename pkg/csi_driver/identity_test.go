@@ -57,6 +57,27 @@ func TestGetPluginInfo(t *testing.T) {
 	}
 }
 
+func TestGetPluginInfoManifest(t *testing.T) {
+	t.Parallel()
+	driver := initTestDriver(t, nil)
+	driver.config.GcsfuseVersion = "v2.11.1-gke.0"
+	driver.config.SidecarImage = "gke.gcr.io/gcs-fuse-csi-driver-sidecar-mounter:v1.0.0"
+	s := newIdentityServer(driver)
+
+	resp, err := s.GetPluginInfo(context.TODO(), nil)
+	if err != nil {
+		t.Fatalf("GetPluginInfo failed: %v", err)
+	}
+
+	if got := resp.GetManifest()["gcsfuse-version"]; got != driver.config.GcsfuseVersion {
+		t.Errorf("got gcsfuse version %q, expected %q", got, driver.config.GcsfuseVersion)
+	}
+
+	if got := resp.GetManifest()["sidecar-image"]; got != driver.config.SidecarImage {
+		t.Errorf("got sidecar image %q, expected %q", got, driver.config.SidecarImage)
+	}
+}
+
 func TestGetPluginCapabilities(t *testing.T) {
 	t.Parallel()
 	s := initTestIdentityServer(t)
@@ -0,0 +1,134 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/volumespec"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// lintTargetPath is the target path of the requests built by LintVolume. The target path is set by kubelet, so it is not linted.
+const lintTargetPath = "/lint"
+
+// kubeletVolumeContextPrefix is the prefix of the volume attributes that kubelet sets when podInfoOnMount is enabled.
+const kubeletVolumeContextPrefix = "csi.storage.k8s.io/"
+
+// LintResult describes how the node service interprets a volume.
+type LintResult struct {
+	// BucketName is the bucket that the volume mounts, or "_" to mount all the buckets the identity can access.
+	BucketName string
+	// MountOptions are the gcsfuse mount options that the volume is mounted with,
+	// before the options that depend on the bucket or the node are added.
+	MountOptions []string
+	// Errors are the reasons the node service rejects the volume.
+	Errors []string
+	// Warnings are the deprecated or conflicting settings that the node service accepts.
+	Warnings []string
+}
+
+// LintVolume validates a NodePublishVolume request the way the node service does, without calling Cloud Storage or the API server,
+// so that users can check the mountOptions and volume attributes of a volume before deploying it.
+// The experimental flags allowlist is the value of the --gcsfuse-experimental-flags-allowlist flag of the node service.
+func LintVolume(req *csi.NodePublishVolumeRequest, experimentalFlagsAllowlist []string) *LintResult {
+	result := &LintResult{Errors: []string{}, Warnings: []string{}}
+	for _, k := range sets.List(sets.KeySet(req.GetVolumeContext())) {
+		_, deprecated := deprecatedVolumeAttributes[k]
+		if !volumespec.IsKnownAttribute(k) && !deprecated && !strings.HasPrefix(k, kubeletVolumeContextPrefix) {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("volume attribute %v is unknown and ignored", k))
+		}
+	}
+
+	req, deprecations, err := translateVolumeAttributes(req)
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+
+		return result
+	}
+	result.Warnings = append(result.Warnings, deprecations...)
+	vc := req.GetVolumeContext()
+
+	_, bucketName, fuseMountOptions, _, _, err := parseRequestArguments(&csi.NodePublishVolumeRequest{
+		VolumeId:         req.GetVolumeId(),
+		TargetPath:       lintTargetPath,
+		VolumeCapability: req.GetVolumeCapability(),
+		VolumeContext:    vc,
+		Readonly:         req.GetReadonly(),
+	})
+	if err != nil {
+		// The volume attributes are validated together, and each invalid one is reported.
+		result.Errors = append(result.Errors, strings.Split(err.Error(), "\n")...)
+
+		return result
+	}
+	result.BucketName = bucketName
+
+	if hnsEnabled, _ := parseHierarchicalNamespace(vc); hnsEnabled {
+		if hasMountOption(fuseMountOptions, implicitDirsFlag) {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("mount option %v is removed, because the volume attribute %v is true", implicitDirsFlag, VolumeContextKeyHierarchicalNamespace))
+		}
+		fuseMountOptions = hierarchicalNamespaceMountOptions(fuseMountOptions)
+	}
+
+	experimentalFlags, err := parseExperimentalFlags(vc, sets.New(experimentalFlagsAllowlist...))
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+	} else if len(experimentalFlags) > 0 {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("volume uses experimental gcsfuse flags %v, which may change or be removed in any gcsfuse release", experimentalFlags))
+		fuseMountOptions = joinMountOptions(fuseMountOptions, experimentalFlags)
+	}
+
+	result.Warnings = append(result.Warnings, multiWriterMountOptionWarnings(req.GetVolumeCapability().GetAccessMode().GetMode(), fuseMountOptions)...)
+	result.Warnings = append(result.Warnings, mountOptionWarnings(fuseMountOptions)...)
+	result.MountOptions = fuseMountOptions
+
+	return result
+}
+
+// mountOptionWarnings returns warnings for the gcsfuse flags that are set more than once with different values,
+// of which gcsfuse only uses one.
+func mountOptionWarnings(fuseMountOptions []string) []string {
+	warnings := []string{}
+	values := map[string][]string{}
+	for _, o := range fuseMountOptions {
+		name := experimentalFlagName(o)
+		if name == "rw" {
+			name = "ro"
+		}
+		values[name] = append(values[name], o)
+	}
+
+	for _, name := range sets.List(sets.KeySet(values)) {
+		if len(values[name]) > 1 {
+			warnings = append(warnings, fmt.Sprintf("mount options %q conflict with each other, only one of them takes effect", values[name]))
+		}
+	}
+
+	return warnings
+}
+
+// hasMountOption returns true if the gcsfuse flag is set in the mount options, with or without a value.
+func hasMountOption(fuseMountOptions []string, flag string) bool {
+	return slices.ContainsFunc(fuseMountOptions, func(o string) bool {
+		return o == flag || strings.HasPrefix(o, flag+"=")
+	})
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"strings"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/google/go-cmp/cmp"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/volumespec"
+)
+
+func TestLintVolume(t *testing.T) {
+	t.Parallel()
+
+	request := func(volumeID string, accessMode csi.VolumeCapability_AccessMode_Mode, mountFlags []string, vc map[string]string) *csi.NodePublishVolumeRequest {
+		return &csi.NodePublishVolumeRequest{
+			VolumeId: volumeID,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{MountFlags: mountFlags}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: accessMode},
+			},
+			VolumeContext: vc,
+		}
+	}
+	singleWriter := csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER
+
+	testCases := []struct {
+		name                 string
+		req                  *csi.NodePublishVolumeRequest
+		allowlist            []string
+		expectedBucketName   string
+		expectedMountOptions []string
+		expectedErrors       []string
+		expectedWarnings     []string
+	}{
+		{
+			name:                 "valid volume",
+			req:                  request("test-bucket", singleWriter, []string{"implicit-dirs"}, map[string]string{VolumeContextKeyFileCacheCapacity: "1Gi"}),
+			expectedBucketName:   "test-bucket",
+			expectedMountOptions: []string{"file-cache:max-size-mb:1024", "implicit-dirs"},
+		},
+		{
+			name:                 "ephemeral volume",
+			req:                  request("csi-ephemeral", singleWriter, nil, map[string]string{VolumeContextKeyEphemeral: util.TrueStr, VolumeContextKeyBucketName: "test-bucket", VolumeContextKeyMountOptions: "ro"}),
+			expectedBucketName:   "test-bucket",
+			expectedMountOptions: []string{"ro"},
+		},
+		{
+			name:           "ephemeral volume without bucket name",
+			req:            request("csi-ephemeral", singleWriter, nil, map[string]string{VolumeContextKeyEphemeral: util.TrueStr}),
+			expectedErrors: []string{`VolumeContext "bucketName" must be provided`},
+		},
+		{
+			name:                 "deprecated and unknown volume attributes",
+			req:                  request("test-bucket", singleWriter, nil, map[string]string{VolumeContextKeyMetadataCacheTtlSeconds: "60", "fileCacheSize": "1Gi"}),
+			expectedBucketName:   "test-bucket",
+			expectedMountOptions: []string{"metadata-cache:ttl-secs:60"},
+			expectedWarnings:     []string{"volume attribute fileCacheSize is unknown", "volume attribute metadataCacheTtlSeconds is deprecated"},
+		},
+		{
+			name:                 "deprecated and conflicting mount options",
+			req:                  request("test-bucket", singleWriter, []string{"stat-cache-ttl=60s", "metadata-cache:ttl-secs:30"}, map[string]string{VolumeContextKeyMetadataCacheTTLSeconds: "60"}),
+			expectedBucketName:   "test-bucket",
+			expectedMountOptions: []string{"metadata-cache:ttl-secs:30", "metadata-cache:ttl-secs:60"},
+			expectedWarnings:     []string{"mount option stat-cache-ttl is deprecated and ignored", `mount options ["metadata-cache:ttl-secs:30" "metadata-cache:ttl-secs:60"] conflict`},
+		},
+		{
+			name:                 "deprecated mount options translated",
+			req:                  request("test-bucket", singleWriter, []string{"stat-cache-ttl=2m", "type-cache-ttl=30s", "stat-cache-capacity=1000"}, nil),
+			expectedBucketName:   "test-bucket",
+			expectedMountOptions: []string{"metadata-cache:ttl-secs:30", "stat-cache-capacity=1000"},
+			expectedWarnings:     []string{"mount option stat-cache-ttl is deprecated", "mount option type-cache-ttl is deprecated", "mount option stat-cache-capacity is deprecated"},
+		},
+		{
+			name:           "deprecated settings with the current schema version",
+			req:            request("test-bucket", singleWriter, []string{"stat-cache-ttl=2m"}, map[string]string{VolumeContextKeyVolumeAttributesVersion: "v1"}),
+			expectedErrors: []string{"volume attribute volumeAttributesVersion is \"v1\", which does not accept deprecated settings"},
+		},
+		{
+			name:                 "implicit dirs with hierarchical namespace",
+			req:                  request("test-bucket", singleWriter, []string{"implicit-dirs"}, map[string]string{VolumeContextKeyHierarchicalNamespace: util.TrueStr}),
+			expectedBucketName:   "test-bucket",
+			expectedMountOptions: []string{"enable-hns"},
+			expectedWarnings:     []string{"mount option implicit-dirs is removed"},
+		},
+		{
+			name:               "invalid volume attributes",
+			req:                request("test-bucket", singleWriter, nil, map[string]string{VolumeContextKeyImplicitDirsAutoDetect: "yes", VolumeContextKeyHierarchicalNamespace: "sometimes"}),
+			expectedBucketName: "",
+			expectedErrors:     []string{"volume attribute hierarchicalNamespace only accepts a valid bool value", "volume attribute implicitDirsAutoDetect only accepts a valid bool value"},
+		},
+		{
+			name:               "volume attributes not allowed when mounting all the buckets",
+			req:                request("_", singleWriter, nil, map[string]string{VolumeContextKeyVerifyReadOnMount: util.TrueStr, VolumeContextKeyHierarchicalNamespace: "auto"}),
+			expectedBucketName: "",
+			expectedErrors:     []string{"volume attribute hierarchicalNamespace cannot be used when mounting all the buckets", "volume attribute verifyReadOnMount cannot be used when mounting all the buckets"},
+		},
+		{
+			name:               "experimental flag not allowed",
+			req:                request("test-bucket", singleWriter, nil, map[string]string{VolumeContextKeyGcsfuseExperimentalFlags: "experimental-enable-json-read"}),
+			expectedBucketName: "test-bucket",
+			expectedErrors:     []string{`gcsfuse flag "experimental-enable-json-read" that is not allowed`},
+		},
+		{
+			name:                 "experimental flag allowed",
+			req:                  request("test-bucket", singleWriter, nil, map[string]string{VolumeContextKeyGcsfuseExperimentalFlags: "experimental-enable-json-read"}),
+			allowlist:            []string{"experimental-enable-json-read"},
+			expectedBucketName:   "test-bucket",
+			expectedMountOptions: []string{"experimental-enable-json-read"},
+			expectedWarnings:     []string{"volume uses experimental gcsfuse flags"},
+		},
+		{
+			name:                 "multi-writer unsafe mount option",
+			req:                  request("test-bucket", csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, nil, map[string]string{VolumeContextKeyMetadataCacheTTLSeconds: "-1"}),
+			expectedBucketName:   "test-bucket",
+			expectedWarnings:     []string{`mount option "metadata-cache:ttl-secs:-1" with access mode MULTI_NODE_MULTI_WRITER can lose data`},
+			expectedMountOptions: []string{"metadata-cache:ttl-secs:-1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			result := LintVolume(tc.req, tc.allowlist)
+			if result.BucketName != tc.expectedBucketName {
+				t.Errorf("got bucket name %q, expected %q", result.BucketName, tc.expectedBucketName)
+			}
+			if len(tc.expectedErrors) == 0 {
+				if diff := cmp.Diff(tc.expectedMountOptions, result.MountOptions); diff != "" {
+					t.Errorf("unexpected mount options (-want +got):\n%s", diff)
+				}
+			}
+			assertContainsAll(t, "errors", result.Errors, tc.expectedErrors)
+			assertContainsAll(t, "warnings", result.Warnings, tc.expectedWarnings)
+		})
+	}
+}
+
+func assertContainsAll(t *testing.T, kind string, got, expected []string) {
+	t.Helper()
+
+	if len(got) != len(expected) {
+		t.Fatalf("got %s %q, expected %d %s", kind, got, len(expected), kind)
+	}
+	for i, e := range expected {
+		if !strings.Contains(got[i], e) {
+			t.Errorf("got %q, expected it to contain %q", got[i], e)
+		}
+	}
+}
+
+func TestKnownVolumeAttributesInVolumeSpec(t *testing.T) {
+	t.Parallel()
+
+	// The volume attributes that the node service translates to mount options, or reads itself.
+	attributes := []string{
+		VolumeContextKeyMountOptions,
+		VolumeContextKeyBucketName,
+		VolumeContextKeyVolumeAttributesVersion,
+		VolumeContextKeyGcsfuseExperimentalFlags,
+		VolumeContextKeyImplicitDirsAutoDetect,
+		VolumeContextKeyVerifyReadOnMount,
+		VolumeContextKeyVerifyReadObject,
+		VolumeContextKeyHierarchicalNamespace,
+	}
+	for attribute := range volumeAttributesToMountOptionsMapping {
+		attributes = append(attributes, attribute)
+	}
+
+	for _, attribute := range attributes {
+		if _, deprecated := deprecatedVolumeAttributes[attribute]; deprecated {
+			if volumespec.IsKnownAttribute(attribute) {
+				t.Errorf("deprecated volume attribute %v should not be generated by the volumespec package", attribute)
+			}
+
+			continue
+		}
+		if !volumespec.IsKnownAttribute(attribute) {
+			t.Errorf("volume attribute %v is unknown to the volumespec package", attribute)
+		}
+	}
+}
@@ -20,10 +20,15 @@ package driver
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/auth"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/clientset"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/storage"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
@@ -33,6 +38,7 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog/v2"
 	mount "k8s.io/mount-utils"
 )
@@ -40,6 +46,29 @@ import (
 const (
 	UmountTimeout = time.Second * 5
 
+	// bucketHealthCheckInterval is how often NodePublishVolume checks that the bucket of a published volume is still accessible.
+	bucketHealthCheckInterval = 5 * time.Minute
+
+	// opsPerSecLimitFlag is the gcsfuse flag that limits the GCS operations per second of a volume.
+	opsPerSecLimitFlag = "limit-ops-per-sec"
+
+	// implicitDirsFlag is the gcsfuse flag that lists directories without directory placeholder objects.
+	implicitDirsFlag = "implicit-dirs"
+	// implicitDirsProbeMaxObjects is the maximum number of objects listed to detect implicit directories.
+	implicitDirsProbeMaxObjects = 1000
+	// customEndpointFlag is the gcsfuse flag that sets the URL of the Cloud Storage JSON API.
+	customEndpointFlag = "custom-endpoint"
+	// enableHNSFlag is the gcsfuse flag that uses the folder APIs of buckets with hierarchical namespace enabled.
+	enableHNSFlag = "enable-hns"
+
+	eventReasonVolumeAbnormal  = "GCSFuseVolumeAbnormal"
+	eventReasonVolumeRecovered = "GCSFuseVolumeRecovered"
+	eventReasonVPCSCDenied     = "GCSFuseVPCServiceControlsDenied"
+	eventReasonRemountRetry    = "GCSFuseRemountRetry"
+	eventReasonSlowUnmount     = "GCSFuseSlowUnmount"
+	eventReasonRecommendations = "GCSFuseWorkloadRecommendations"
+	eventReasonUnflushedWrites = "GCSFuseUnflushedWrites"
+
 	FuseMountType = "fuse"
 )
 
@@ -53,17 +82,34 @@ type nodeServer struct {
 	k8sClients            clientset.Interface
 	limiter               rate.Limiter
 	volumeStateStore      *util.VolumeStateStore
+	// experimentalFlagsAllowlist is the set of gcsfuse flags allowed in the gcsfuseExperimentalFlags volume attribute.
+	experimentalFlagsAllowlist sets.Set[string]
+	// cgroupRoot is where the memory usage of the sidecar containers is read.
+	cgroupRoot string
+	// fuseHost is where the FUSE compatibility of the node is checked.
+	fuseHost fuseHost
+	// fuseIncompatibility is why the last check found that the node cannot mount gcsfuse volumes, or nil.
+	fuseIncompatibility atomic.Pointer[fuseIncompatibility]
+	// opsPerSecBudget holds the limits of the GCS operations per second that the volumes reserved from the node budget.
+	opsPerSecBudget *opsPerSecBudget
+	// memoryBudget holds the memory that the sidecar containers reserved from the node memory budget.
+	memoryBudget *memoryBudget
 }
 
 func newNodeServer(driver *GCSDriver, mounter mount.Interface) csi.NodeServer {
 	return &nodeServer{
-		driver:                driver,
-		storageServiceManager: driver.config.StorageServiceManager,
-		mounter:               mounter,
-		volumeLocks:           util.NewVolumeLocks(),
-		k8sClients:            driver.config.K8sClients,
-		limiter:               *rate.NewLimiter(rate.Every(time.Second), 10),
-		volumeStateStore:      util.NewVolumeStateStore(),
+		driver:                     driver,
+		storageServiceManager:      driver.config.StorageServiceManager,
+		mounter:                    mounter,
+		volumeLocks:                util.NewVolumeLocks(),
+		k8sClients:                 driver.config.K8sClients,
+		limiter:                    *rate.NewLimiter(rate.Every(time.Second), 10),
+		volumeStateStore:           util.NewVolumeStateStore(),
+		experimentalFlagsAllowlist: sets.New(driver.config.GcsfuseExperimentalFlagsAllowlist...),
+		cgroupRoot:                 defaultCgroupRoot,
+		fuseHost:                   defaultFUSEHost,
+		opsPerSecBudget:            newOpsPerSecBudget(),
+		memoryBudget:               newMemoryBudget(),
 	}
 }
 
@@ -85,12 +131,40 @@ func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 		return nil, status.Errorf(codes.Aborted, "NodePublishVolume request is aborted due to rate limit: %v", err)
 	}
 
+	if err := s.fuseUnsupportedError(); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	// Translate the deprecated volume attributes and mount options, so that the volumes written for older driver versions keep working.
+	req, deprecations, err := translateVolumeAttributes(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	// Validate arguments
 	targetPath, bucketName, fuseMountOptions, skipBucketAccessCheck, disableMetricsCollection, err := parseRequestArguments(req)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
-	klog.V(6).Infof("NodePublishVolume on volume %q has skipBucketAccessCheck %t", bucketName, skipBucketAccessCheck)
+	klog.V(6).InfoS("NodePublishVolume bucket access check", util.LogKeyBucket, bucketName, util.LogKeyTargetPath, targetPath, "skipBucketAccessCheck", skipBucketAccessCheck)
+
+	if err := util.ValidateTargetPath(targetPath); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	implicitDirsAutoDetect := parseImplicitDirsAutoDetect(req.GetVolumeContext())
+
+	hnsEnabled, hnsAutoDetect := parseHierarchicalNamespace(req.GetVolumeContext())
+	verifyRead, verifyReadObject := parseVerifyReadOnMount(req.GetVolumeContext())
+
+	experimentalFlags, err := parseExperimentalFlags(req.GetVolumeContext(), s.experimentalFlagsAllowlist)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if len(experimentalFlags) > 0 {
+		klog.Warningf("NodePublishVolume on volume %q uses experimental gcsfuse flags %v", bucketName, experimentalFlags)
+		fuseMountOptions = joinMountOptions(fuseMountOptions, experimentalFlags)
+	}
 
 	if err := s.driver.validateVolumeCapabilities([]*csi.VolumeCapability{req.GetVolumeCapability()}); err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
@@ -100,7 +174,21 @@ func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 	if acquired := s.volumeLocks.TryAcquire(targetPath); !acquired {
 		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, targetPath)
 	}
-	defer s.volumeLocks.Release(targetPath)
+	// The lock is released while the bucket access check waits to be retried, and may be taken by another operation meanwhile.
+	lockHeld := true
+	defer func() {
+		if lockHeld {
+			s.volumeLocks.Release(targetPath)
+		}
+	}()
+
+	requestedMountOptions := fuseMountOptions
+	// Reject requests for another bucket than the volume already published to the target path.
+	if vs, ok := s.volumeStateStore.Load(targetPath); ok && vs.Published {
+		if err := comparePublishedVolume(vs, bucketName); err != nil {
+			return nil, status.Errorf(codes.AlreadyExists, "volume %q is already published to target path %q: %v", req.GetVolumeId(), targetPath, err)
+		}
+	}
 
 	vc := req.GetVolumeContext()
 
@@ -116,14 +204,37 @@ func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 		}
 
 		if !vs.BucketAccessCheckPassed {
-			storageService, err := s.prepareStorageService(ctx, vc)
-			if err != nil {
-				return nil, status.Errorf(codes.Unauthenticated, "failed to prepare storage service: %v", err)
+			// Volumes that were mounted before, for example before the CSI driver restarted, are remounted.
+			remount := vs.Published
+			if !remount {
+				remount, _ = s.isDirMounted(targetPath)
 			}
-			defer storageService.Close()
 
-			if exist, err := storageService.CheckBucketExists(ctx, &storage.ServiceBucket{Name: bucketName}); !exist {
-				return nil, status.Errorf(storage.ParseErrCode(err), "failed to get GCS bucket %q: %v", bucketName, err)
+			var bucketErr error
+			var err error
+			lockHeld, err = s.retryMountCheck(ctx, targetPath, req.GetVolumeId(), bucketName, vc, remount, func() error {
+				bucketErr = nil
+				storageService, err := s.prepareStorageService(ctx, vc)
+				if err != nil {
+					return status.Errorf(codes.Unauthenticated, "failed to prepare storage service: %v", err)
+				}
+				defer storageService.Close()
+
+				var exist bool
+				if exist, bucketErr = storageService.CheckBucketExists(ctx, &storage.ServiceBucket{Name: bucketName, BillingProject: billingProject(fuseMountOptions)}); !exist {
+					return status.Errorf(storage.ParseErrCode(bucketErr), "failed to get GCS bucket %q: %v", bucketName, bucketErr)
+				}
+
+				return nil
+			})
+			if err != nil {
+				if storage.IsVPCSCViolationErr(bucketErr) {
+					if pod, podErr := s.k8sClients.GetPod(vc[VolumeContextKeyPodNamespace], vc[VolumeContextKeyPodName]); podErr == nil {
+						s.recordVPCSCDenial(pod, bucketName, bucketErr)
+					}
+				}
+
+				return nil, err
 			}
 
 			vs.BucketAccessCheckPassed = true
@@ -135,17 +246,38 @@ func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 	if err != nil {
 		return nil, status.Errorf(codes.NotFound, "failed to get pod: %v", err)
 	}
+	if err := s.podSandboxError(ctx, pod); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	s.reportMountOptionsChange(pod, req.GetVolumeId(), targetPath, requestedMountOptions)
+
+	fuseMountOptions, err = podDefaultMountOptions(fuseMountOptions, pod)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 
 	if s.shouldStartTokenServer(pod) && pod.Spec.HostNetwork {
 		identityProvider := s.driver.config.TokenManager.GetIdentityProvider()
 		fuseMountOptions = joinMountOptions(fuseMountOptions, []string{"token-server-identity-provider=" + identityProvider})
 	}
 
+	// Read-only volumes of a single bucket get tokens from the sidecar token server that only allow reading the bucket.
+	if s.driver.config.DownscopeReadOnlyVolumeTokens && bucketName != "_" && slices.Contains(fuseMountOptions, "ro") {
+		fuseMountOptions = joinMountOptions(fuseMountOptions, []string{"token-server-read-only"})
+	}
+
+	// Volumes reach Cloud Storage through the same endpoint as the driver, unless they set their own.
+	if endpointURL := s.driver.config.StorageEndpointURL; endpointURL != "" && !slices.ContainsFunc(fuseMountOptions, func(o string) bool { return strings.HasPrefix(o, customEndpointFlag+"=") }) {
+		fuseMountOptions = joinMountOptions(fuseMountOptions, []string{customEndpointFlag + "=" + endpointURL})
+	}
+
 	node, err := s.k8sClients.GetNode(s.driver.config.NodeID)
 	if err != nil {
 		return nil, status.Errorf(codes.NotFound, "failed to get node: %v", err)
 	}
 
+	fuseMountOptions = connectionPoolMountOptions(fuseMountOptions, node.Labels[corev1.LabelInstanceTypeStable])
+
 	val, ok := node.Labels[clientset.GkeMetaDataServerKey]
 	// If Workload Identity is not enabled, the key should be missing; the check for "val == false" is just for extra caution
 	isWorkloadIdentityDisabled := val != "true" || !ok
@@ -168,7 +300,7 @@ func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 	// Register metrics collecter.
 	// It is idempotent to register the same collector in node republish calls.
 	if s.driver.config.MetricsManager != nil && !disableMetricsCollection {
-		klog.V(6).Infof("NodePublishVolume enabling metrics collector for target path %q", targetPath)
+		klog.V(6).InfoS("NodePublishVolume enabling metrics collector", util.LogKeyTargetPath, targetPath)
 		s.driver.config.MetricsManager.RegisterMetricsCollector(targetPath, pod.Namespace, pod.Name, bucketName)
 	}
 
@@ -181,11 +313,29 @@ func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 		}
 	}
 
+	// Periodically check that the bucket of a published volume still exists and is accessible,
+	// so that a deleted bucket or revoked access is reported as an abnormal volume condition.
+	if vs, ok := s.volumeStateStore.Load(targetPath); ok && vs.Published && bucketName != "_" && !skipBucketAccessCheck && time.Since(vs.BucketHealthCheckedAt) >= bucketHealthCheckInterval {
+		s.checkBucketHealth(ctx, vc, pod, vs, bucketName)
+	}
+
+	// Periodically count the objects of a published volume, which NodeGetVolumeStats reports as the used bytes and inodes.
+	if vs, ok := s.volumeStateStore.Load(targetPath); ok && vs.Published && !vs.Abnormal && bucketName != "_" && s.driver.config.VolumeStatsMaxObjects > 0 && time.Since(vs.UsageCountedAt) >= volumeUsageInterval {
+		s.startVolumeUsageCount(ctx, vc, vs, bucketName)
+	}
+
 	// Check if there is any error from the gcsfuse
 	code, err := checkGcsFuseErr(isInitContainer, pod, targetPath)
 	if code != codes.OK {
+		if code == codes.PermissionDenied && storage.IsVPCSCViolationErr(err) {
+			s.recordVPCSCDenial(pod, bucketName, err)
+		}
+		if vs, ok := s.volumeStateStore.Load(targetPath); ok && vs.Published && (code == codes.NotFound || code == codes.PermissionDenied) {
+			s.setVolumeCondition(pod, vs, true, err.Error())
+		}
+
 		if code == codes.Canceled {
-			klog.V(4).Infof("NodePublishVolume on volume %q to target path %q is not needed because the gcsfuse has terminated.", bucketName, targetPath)
+			klog.V(4).InfoS("NodePublishVolume is not needed because the gcsfuse has terminated", util.LogKeyBucket, bucketName, util.LogKeyTargetPath, targetPath)
 
 			return &csi.NodePublishVolumeResponse{}, nil
 		}
@@ -199,6 +349,10 @@ func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 		return nil, status.Error(code, err.Error())
 	}
 
+	// Scale the sidecar container down once the CPU boost window ends.
+	// NodePublishVolume is called periodically because the CSI driver requires republish.
+	s.endSidecarCPUBoost(ctx, pod, isInitContainer)
+
 	// TODO: Check if the socket listener timed out
 
 	// Check if the target path is already mounted
@@ -208,26 +362,239 @@ func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 	}
 
 	if mounted {
-		klog.V(4).Infof("NodePublishVolume succeeded on volume %q to target path %q, mount already exists.", bucketName, targetPath)
+		// Adopt mounts created before the CSI driver restarted.
+		s.markVolumePublished(targetPath, bucketName, pod, requestedMountOptions, fuseMountOptions)
+		s.checkSidecarVersion(pod, targetPath)
+		klog.V(4).InfoS("NodePublishVolume succeeded, mount already exists", util.LogKeyVolume, req.GetVolumeId(), util.LogKeyBucket, bucketName, util.LogKeyPod, klog.KObj(pod), util.LogKeyTargetPath, targetPath)
 
 		return &csi.NodePublishVolumeResponse{}, nil
 	}
 
-	klog.V(4).Infof("NodePublishVolume attempting mkdir for path %q", targetPath)
+	// Refuse new mounts while the gcsfuse sidecar containers on the node use more memory than the node budget.
+	if err := s.checkGcsfuseMemoryBudget(pod, targetPath); err != nil {
+		return nil, err
+	}
+
+	klog.V(4).InfoS("NodePublishVolume attempting mkdir", util.LogKeyTargetPath, targetPath)
 	if err := os.MkdirAll(targetPath, 0o750); err != nil {
 		return nil, status.Errorf(codes.Internal, "mkdir failed for path %q: %v", targetPath, err)
 	}
+	if err := s.checkTargetPathData(pod, req.GetVolumeId(), targetPath); err != nil {
+		return nil, err
+	}
+
+	effectiveMountOptions := fuseMountOptions
+	// The detection of hierarchical namespace is best effort, so the flags are not part of the published mount options
+	// that republish requests are compared with.
+	if hnsAutoDetect {
+		hnsEnabled = s.detectHierarchicalNamespace(ctx, vc, bucketName, fuseMountOptions)
+	}
+	if hnsEnabled {
+		effectiveMountOptions = hierarchicalNamespaceMountOptions(effectiveMountOptions)
+	}
+	// The implicit directories found in the bucket can change, so the flag is not part of the published mount options either.
+	// Buckets with hierarchical namespace enabled have no implicit directories.
+	if implicitDirsAutoDetect && bucketName != "_" && !hnsEnabled {
+		if opt, ok := s.implicitDirsMountOption(ctx, vc, pod, req.GetVolumeId(), bucketName, fuseMountOptions); ok {
+			effectiveMountOptions = joinMountOptions(effectiveMountOptions, []string{opt})
+		}
+	}
+
+	// The sidecar container only starts after the volume is mounted, so the bucket is read using the GCS API
+	// with the Pod credentials, instead of through the mount.
+	if verifyRead {
+		storageService, err := s.prepareStorageService(ctx, vc)
+		if err != nil {
+			return nil, status.Errorf(codes.Unauthenticated, "failed to prepare storage service: %v", err)
+		}
+		defer storageService.Close()
+
+		prefix := onlyDirPrefix(fuseMountOptions)
+		object := ""
+		if verifyReadObject != "" {
+			object = prefix + verifyReadObject
+		}
+		if err := storageService.VerifyRead(ctx, &storage.ServiceBucket{Name: bucketName, BillingProject: billingProject(fuseMountOptions)}, prefix, object); err != nil {
+			return nil, status.Errorf(storage.ParseErrCode(err), "failed to verify reading GCS bucket %q: %v", bucketName, err)
+		}
+	}
+
+	// The share of the node GCS operations budget depends on the other volumes on the node,
+	// so it is not part of the published mount options either.
+	if opt, ok := s.opsPerSecLimitMountOption(targetPath, fuseMountOptions); ok {
+		effectiveMountOptions = joinMountOptions(effectiveMountOptions, []string{opt})
+	}
 
 	// Start to mount
-	if err = s.mounter.Mount(bucketName, targetPath, FuseMountType, fuseMountOptions); err != nil {
+	if err = s.mounter.Mount(bucketName, targetPath, FuseMountType, effectiveMountOptions); err != nil {
+		s.opsPerSecBudget.release(targetPath)
+
 		return nil, status.Errorf(codes.Internal, "failed to mount volume %q to target path %q: %v", bucketName, targetPath, err)
 	}
+	s.markVolumePublished(targetPath, bucketName, pod, requestedMountOptions, fuseMountOptions)
+	s.publishConfigHash(ctx, pod, targetPath, bucketName, fuseMountOptions)
+	s.audit(&AuditRecord{
+		Operation:      AuditOperationMount,
+		VolumeID:       req.GetVolumeId(),
+		TargetPath:     targetPath,
+		PodNamespace:   pod.Namespace,
+		PodName:        pod.Name,
+		ServiceAccount: pod.Spec.ServiceAccountName,
+		BucketName:     bucketName,
+		ReadOnly:       slices.Contains(effectiveMountOptions, "ro"),
+		MountOptions:   effectiveMountOptions,
+	})
+
+	// Record the effective mount options on the Pod, so users can audit the options the volume is served with.
+	s.k8sClients.Eventf(pod, corev1.EventTypeNormal, eventReasonGcsFuseMountOptions, "Volume %q for bucket %q is mounted with gcsfuse mount options %q", req.GetVolumeId(), bucketName, effectiveMountOptions)
+	// The event is only recorded once the volume is mounted, so that retried mounts and republish requests do not repeat it.
+	if hnsEnabled {
+		s.k8sClients.Eventf(pod, corev1.EventTypeNormal, eventReasonHierarchicalNS, "Volume %q is mounted with the gcsfuse %v flag, because bucket %q has hierarchical namespace enabled. Directory renames on the volume are atomic.", req.GetVolumeId(), enableHNSFlag, bucketName)
+	}
+	if len(deprecations) > 0 {
+		s.k8sClients.Eventf(pod, corev1.EventTypeWarning, eventReasonDeprecatedVolumeAttributes, "Volume %q uses deprecated settings, which are translated for now: %v. Update the volume, and set the volume attribute %v to %q to reject deprecated settings.", req.GetVolumeId(), strings.Join(deprecations, "; "), VolumeContextKeyVolumeAttributesVersion, volumeAttributesVersionV1)
+	}
+	if warnings := multiWriterMountOptionWarnings(req.GetVolumeCapability().GetAccessMode().GetMode(), fuseMountOptions); len(warnings) > 0 {
+		s.k8sClients.Eventf(pod, corev1.EventTypeWarning, eventReasonMultiWriterOptions, "Volume %q for bucket %q: %s", req.GetVolumeId(), bucketName, strings.Join(warnings, "; "))
+	}
 
-	klog.V(4).Infof("NodePublishVolume succeeded on volume %q to target path %q", bucketName, targetPath)
+	klog.V(4).InfoS("NodePublishVolume succeeded", util.LogKeyVolume, req.GetVolumeId(), util.LogKeyBucket, bucketName, util.LogKeyPod, klog.KObj(pod), util.LogKeyTargetPath, targetPath)
 
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
+// recordUnmountFlush records how long gcsfuse took to flush the pending writes of a volume to GCS when the Pod terminated.
+// The sidecar mounter measures the time from SIGTERM until the gcsfuse process of the volume exited, because the node
+// service only unmounts the volume after the sidecar container exited, so the duration tells users how much of the Pod
+// termination grace period the flush needs.
+func (s *nodeServer) recordUnmountFlush(volumeID, targetPath string, vs *util.VolumeState) {
+	if vs == nil || !vs.Published {
+		return
+	}
+
+	emptyDirBasePath, err := util.PrepareEmptyDir(targetPath, false)
+	if err != nil {
+		klog.Warningf("failed to get emptyDir path of volume %q: %v", volumeID, err)
+
+		return
+	}
+
+	path := filepath.Join(emptyDirBasePath, util.FlushDurationFile)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.Warningf("failed to read the flush duration of volume %q: %v", volumeID, err)
+		}
+
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		klog.Warningf("failed to remove the flush duration file %q: %v", path, err)
+	}
+	duration, err := time.ParseDuration(strings.TrimSpace(string(content)))
+	if err != nil {
+		klog.Warningf("invalid flush duration %q of volume %q: %v", content, volumeID, err)
+
+		return
+	}
+
+	_, volumeName, _ := util.ParsePodIDVolumeFromTargetpath(targetPath)
+	if s.driver.config.MetricsManager != nil {
+		s.driver.config.MetricsManager.RecordUnmountFlush(vs.PublishedPodNamespace, volumeName, duration)
+	}
+
+	threshold := s.driver.config.UnmountFlushWarningThreshold
+	if threshold <= 0 || duration < threshold {
+		return
+	}
+
+	pod, err := s.k8sClients.GetPod(vs.PublishedPodNamespace, vs.PublishedPodName)
+	if err != nil {
+		klog.Warningf("gcsfuse took %v to flush the pending writes of volume %q, but the event cannot be recorded on pod %v/%v: %v", duration, volumeID, vs.PublishedPodNamespace, vs.PublishedPodName, err)
+
+		return
+	}
+	s.k8sClients.Eventf(pod, corev1.EventTypeWarning, eventReasonSlowUnmount, "gcsfuse took %v to flush the pending writes of volume %q for bucket %q after the Pod terminated, above the threshold %v. Make sure that terminationGracePeriodSeconds leaves enough time for the flush.", duration.Round(time.Millisecond), volumeID, vs.PublishedBucketName, threshold)
+}
+
+// recordWorkloadRecommendations records the I/O summary and the recommended settings that the sidecar mounter wrote
+// for a volume that opted in to the workload analysis. The file is removed, so that a remount does not report it again.
+func (s *nodeServer) recordWorkloadRecommendations(volumeID, targetPath string, vs *util.VolumeState) {
+	if vs == nil || !vs.Published || !slices.Contains(vs.PublishedMountOptions, util.WorkloadRecommendations+"="+util.TrueStr) {
+		return
+	}
+
+	emptyDirBasePath, err := util.PrepareEmptyDir(targetPath, false)
+	if err != nil {
+		klog.Warningf("failed to get emptyDir path of volume %q: %v", volumeID, err)
+
+		return
+	}
+
+	path := filepath.Join(emptyDirBasePath, util.RecommendationsFile)
+	recommendations, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.Warningf("failed to read the workload recommendations of volume %q: %v", volumeID, err)
+		}
+
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		klog.Warningf("failed to remove the workload recommendations file %q: %v", path, err)
+	}
+	if len(recommendations) == 0 {
+		return
+	}
+
+	pod, err := s.k8sClients.GetPod(vs.PublishedPodNamespace, vs.PublishedPodName)
+	if err != nil {
+		klog.Warningf("the workload recommendations of volume %q cannot be recorded on pod %v/%v: %v", volumeID, vs.PublishedPodNamespace, vs.PublishedPodName, err)
+
+		return
+	}
+	s.k8sClients.Eventf(pod, corev1.EventTypeNormal, eventReasonRecommendations, "Volume %q for bucket %q: %s", volumeID, vs.PublishedBucketName, recommendations)
+}
+
+// recordUnflushedWrites records the staged writes that the sidecar mounter had not uploaded yet when the sidecar container
+// was killed, because the termination grace period of the Pod expired while it waited for the uploads.
+func (s *nodeServer) recordUnflushedWrites(volumeID, targetPath string, vs *util.VolumeState) {
+	if vs == nil || !vs.Published {
+		return
+	}
+
+	emptyDirBasePath, err := util.PrepareEmptyDir(targetPath, false)
+	if err != nil {
+		klog.Warningf("failed to get emptyDir path of volume %q: %v", volumeID, err)
+
+		return
+	}
+
+	path := filepath.Join(emptyDirBasePath, util.DrainStatusFile)
+	drainStatus, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.Warningf("failed to read the drain status of volume %q: %v", volumeID, err)
+		}
+
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		klog.Warningf("failed to remove the drain status file %q: %v", path, err)
+	}
+	if len(drainStatus) == 0 {
+		return
+	}
+
+	pod, err := s.k8sClients.GetPod(vs.PublishedPodNamespace, vs.PublishedPodName)
+	if err != nil {
+		klog.Warningf("the unflushed writes of volume %q cannot be recorded on pod %v/%v: %v", volumeID, vs.PublishedPodNamespace, vs.PublishedPodName, err)
+
+		return
+	}
+	s.k8sClients.Eventf(pod, corev1.EventTypeWarning, eventReasonUnflushedWrites, "The sidecar container was terminated before gcsfuse uploaded the writes of volume %q for bucket %q to Cloud Storage: %s. Increase terminationGracePeriodSeconds so that the uploads can complete.", volumeID, vs.PublishedBucketName, drainStatus)
+}
+
 func (s *nodeServer) NodeUnpublishVolume(_ context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
 	// Validate arguments
 	targetPath := req.GetTargetPath()
@@ -247,12 +614,15 @@ func (s *nodeServer) NodeUnpublishVolume(_ context.Context, req *csi.NodeUnpubli
 		s.driver.config.MetricsManager.UnregisterMetricsCollector(targetPath)
 	}
 
+	vs, _ := s.volumeStateStore.Load(targetPath)
 	s.volumeStateStore.Delete(targetPath)
+	s.opsPerSecBudget.release(targetPath)
 
 	// Check if the target path is already mounted
-	if mounted, err := s.isDirMounted(targetPath); mounted || err != nil {
+	mounted, err := s.isDirMounted(targetPath)
+	if mounted || err != nil {
 		if err != nil {
-			klog.Errorf("failed to check if path %q is already mounted: %v", targetPath, err)
+			klog.ErrorS(err, "failed to check if the target path is already mounted", util.LogKeyTargetPath, targetPath)
 		}
 		// Force unmount the target path
 		// Try to do force unmount firstly because if the file descriptor was not closed,
@@ -269,17 +639,389 @@ func (s *nodeServer) NodeUnpublishVolume(_ context.Context, req *csi.NodeUnpubli
 			}
 		}
 	}
+	s.recordWorkloadRecommendations(req.GetVolumeId(), targetPath, vs)
+	s.recordUnflushedWrites(req.GetVolumeId(), targetPath, vs)
+	s.recordUnmountFlush(req.GetVolumeId(), targetPath, vs)
 
 	// Cleanup the mount point
 	if err := mount.CleanupMountPoint(targetPath, s.mounter, false /* bind mount */); err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to cleanup the mount point %q: %v", targetPath, err)
 	}
 
-	klog.V(4).Infof("NodeUnpublishVolume succeeded on target path %q", targetPath)
+	if mounted {
+		record := &AuditRecord{Operation: AuditOperationUnmount, VolumeID: req.GetVolumeId(), TargetPath: targetPath}
+		if vs != nil && vs.Published {
+			record.PodNamespace, record.PodName, record.ServiceAccount = vs.PublishedPodNamespace, vs.PublishedPodName, vs.PublishedServiceAccount
+			record.BucketName, record.MountOptions = vs.PublishedBucketName, vs.PublishedMountOptions
+			record.ReadOnly = slices.Contains(vs.PublishedMountOptions, "ro")
+		}
+		s.audit(record)
+	}
+
+	klog.V(4).InfoS("NodeUnpublishVolume succeeded", util.LogKeyVolume, req.GetVolumeId(), util.LogKeyTargetPath, targetPath)
 
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
+// NodeGetVolumeStats reports the condition and usage of a volume from the snapshot that NodePublishVolume takes.
+// It does not take the volume lock, so that kubelet polling the stats never makes a concurrent NodePublishVolume fail with Aborted.
+func (s *nodeServer) NodeGetVolumeStats(_ context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
+	if len(req.GetVolumeId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodeGetVolumeStats volume ID must be provided")
+	}
+
+	volumePath := req.GetVolumePath()
+	if len(volumePath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodeGetVolumeStats volume path must be provided")
+	}
+
+	condition := &csi.VolumeCondition{Message: "volume is healthy"}
+	var stats *util.VolumeStats
+	var usage *util.VolumeUsage
+	if vs, ok := s.volumeStateStore.Load(volumePath); ok {
+		stats, usage = vs.Stats(), vs.Usage()
+	}
+	if stats != nil {
+		if stats.Abnormal {
+			condition = &csi.VolumeCondition{Abnormal: true, Message: stats.ConditionMessage}
+		}
+	} else {
+		// The volume state is lost when the CSI driver restarts, until the volume is republished.
+		mounted, err := s.isDirMounted(volumePath)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to check if path %q is mounted: %v", volumePath, err)
+		}
+		if !mounted {
+			return nil, status.Errorf(codes.NotFound, "volume path %q is not mounted", volumePath)
+		}
+	}
+
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage:           volumeUsage(usage),
+		VolumeCondition: condition,
+	}, nil
+}
+
+// endSidecarCPUBoost restores the sidecar container CPU resources using in-place Pod resize
+// after the sidecar container has been running for the CPU boost duration.
+// Failures are logged and retried on the next NodePublishVolume call. It requires the EndSidecarCPUBoost option,
+// because the node service needs the RBAC permission to patch Pods.
+func (s *nodeServer) endSidecarCPUBoost(ctx context.Context, pod *corev1.Pod, isInitContainer bool) {
+	if !s.driver.config.EndSidecarCPUBoost {
+		return
+	}
+	boost, ok, err := webhook.ParseCPUBoost(pod)
+	if err != nil {
+		klog.Errorf("failed to get the sidecar container CPU boost of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+
+		return
+	}
+	if !ok {
+		return
+	}
+
+	containers, statuses := pod.Spec.Containers, pod.Status.ContainerStatuses
+	if isInitContainer {
+		containers, statuses = pod.Spec.InitContainers, pod.Status.InitContainerStatuses
+	}
+
+	for _, c := range containers {
+		if c.Name != webhook.GcsFuseSidecarName {
+			continue
+		}
+
+		if request := c.Resources.Requests[corev1.ResourceCPU]; request.Equal(boost.Request) {
+			return
+		}
+
+		break
+	}
+
+	var startedAt time.Time
+	for _, cs := range statuses {
+		if cs.Name == webhook.GcsFuseSidecarName && cs.State.Running != nil {
+			startedAt = cs.State.Running.StartedAt.Time
+		}
+	}
+	if startedAt.IsZero() || time.Since(startedAt) < boost.Duration {
+		return
+	}
+
+	resources := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: boost.Request},
+	}
+	if boost.Limit != nil {
+		resources.Limits = corev1.ResourceList{corev1.ResourceCPU: *boost.Limit}
+	}
+
+	if err := s.k8sClients.ResizePodContainer(ctx, pod, webhook.GcsFuseSidecarName, isInitContainer, resources); err != nil {
+		klog.Errorf("failed to end the sidecar container CPU boost of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+
+		return
+	}
+
+	klog.V(4).Infof("ended the sidecar container CPU boost of pod %s/%s, CPU request restored to %q", pod.Namespace, pod.Name, boost.Request.String())
+}
+
+// checkBucketHealth checks that the bucket of a published volume exists and is accessible, and updates the volume condition.
+// Errors other than a missing bucket or denied access, such as failures to get a token, do not change the volume condition.
+func (s *nodeServer) checkBucketHealth(ctx context.Context, vc map[string]string, pod *corev1.Pod, vs *util.VolumeState, bucketName string) {
+	vs.BucketHealthCheckedAt = time.Now()
+	storageService, err := s.prepareStorageService(ctx, vc)
+	if err != nil {
+		klog.Warningf("failed to prepare storage service to check GCS bucket %q: %v", bucketName, err)
+
+		return
+	}
+	defer storageService.Close()
+
+	if exist, err := storageService.CheckBucketExists(ctx, &storage.ServiceBucket{Name: bucketName, BillingProject: billingProject(vs.PublishedMountOptions)}); !exist {
+		if code := storage.ParseErrCode(err); code == codes.NotFound || code == codes.PermissionDenied {
+			if storage.IsVPCSCViolationErr(err) && !vs.Abnormal {
+				s.recordVPCSCDenial(pod, bucketName, err)
+			}
+			s.setVolumeCondition(pod, vs, true, fmt.Sprintf("GCS bucket %q is not accessible: %v", bucketName, err))
+		} else {
+			klog.Warningf("failed to check GCS bucket %q: %v", bucketName, err)
+		}
+
+		return
+	}
+
+	s.setVolumeCondition(pod, vs, false, "")
+}
+
+// setVolumeCondition updates the volume condition, and records an event on the Pod when the condition changes.
+func (s *nodeServer) setVolumeCondition(pod *corev1.Pod, vs *util.VolumeState, abnormal bool, message string) {
+	if vs.Abnormal == abnormal && vs.ConditionMessage == message {
+		return
+	}
+
+	wasAbnormal := vs.Abnormal
+	vs.Abnormal, vs.ConditionMessage = abnormal, message
+	vs.PublishStats()
+	switch {
+	case abnormal:
+		s.k8sClients.Eventf(pod, corev1.EventTypeWarning, eventReasonVolumeAbnormal, "Volume for bucket %q is abnormal: %v", vs.PublishedBucketName, message)
+	case wasAbnormal:
+		s.k8sClients.Eventf(pod, corev1.EventTypeNormal, eventReasonVolumeRecovered, "Volume for bucket %q is accessible again", vs.PublishedBucketName)
+	}
+}
+
+// recordVPCSCDenial records a warning event on the Pod explaining that a VPC Service Controls perimeter denied the access to the bucket,
+// because the 403 error looks like missing IAM permissions.
+func (s *nodeServer) recordVPCSCDenial(pod *corev1.Pod, bucketName string, err error) {
+	s.k8sClients.Eventf(pod, corev1.EventTypeWarning, eventReasonVPCSCDenied,
+		"Access to GCS bucket %q was denied by a VPC Service Controls perimeter, not by IAM. Make sure that the projects of the bucket and the cluster are in the same service perimeter, or that an ingress rule allows the identity of the Pod. Find the violation in the audit logs with the vpcServiceControlsUniqueIdentifier of the error: %v",
+		bucketName, err)
+}
+
+// retryMountCheck runs a check of NodePublishVolume before the volume is mounted, and retries it within the configured budget
+// while it fails with an error that may be transient. The first mount of a volume is retried at a short fixed interval,
+// to mask the propagation delay of new Workload Identity bindings. A remount, for example after the CSI driver restarted,
+// is retried with an exponential backoff, and each failure is recorded as a warning event on the Pod.
+// The lock on the target path is released while the check waits to be retried. It returns false if the lock
+// could not be acquired again, because another operation on the target path took it meanwhile.
+func (s *nodeServer) retryMountCheck(ctx context.Context, targetPath, volumeID, bucketName string, vc map[string]string, remount bool, check func() error) (bool, error) {
+	budget, delay, factor := s.driver.config.FirstMountRetryBudget, s.driver.config.FirstMountRetryInterval, 1.0
+	if remount {
+		budget, delay, factor = s.driver.config.RemountRetryBudget, s.driver.config.RemountRetryInitialBackoff, 2.0
+	}
+	deadline := time.Now().Add(budget)
+
+	var pod *corev1.Pod
+	for {
+		err := check()
+		if err == nil || !isRetryableMountCheckErr(err) || delay <= 0 || time.Now().Add(delay).After(deadline) {
+			return true, err
+		}
+
+		if remount {
+			if pod == nil {
+				pod, _ = s.k8sClients.GetPod(vc[VolumeContextKeyPodNamespace], vc[VolumeContextKeyPodName])
+			}
+			if pod != nil {
+				s.k8sClients.Eventf(pod, corev1.EventTypeWarning, eventReasonRemountRetry, "Remounting volume %q for bucket %q failed, retrying in %v: %v", volumeID, bucketName, delay, status.Convert(err).Message())
+			}
+		}
+		klog.Warningf("NodePublishVolume on volume %q for bucket %q failed (remount %t), retrying in %v: %v", volumeID, bucketName, remount, delay, err)
+
+		s.volumeLocks.Release(targetPath)
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+		if !s.volumeLocks.TryAcquire(targetPath) {
+			return false, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, targetPath)
+		}
+		if ctx.Err() != nil {
+			return true, err
+		}
+		delay = time.Duration(float64(delay) * factor)
+	}
+}
+
+// isRetryableMountCheckErr returns true if the check of NodePublishVolume may pass when it is retried,
+// for example while a new Workload Identity binding propagates to the token exchange. Permission errors,
+// including the denials of VPC Service Controls perimeters, and missing buckets are not retried.
+func isRetryableMountCheckErr(err error) bool {
+	if storage.IsVPCSCViolationErr(err) {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.Unauthenticated, codes.Internal:
+		return true
+	default:
+		return false
+	}
+}
+
+// opsPerSecLimitMountOption reserves the limit of the GCS operations per second of a new volume from the node budget,
+// and returns it as a gcsfuse mount option. Volumes that set their own limit do not take part in the budget.
+func (s *nodeServer) opsPerSecLimitMountOption(targetPath string, fuseMountOptions []string) (string, bool) {
+	budget := s.driver.config.GcsfuseNodeOpsPerSecBudget
+	if budget <= 0 {
+		return "", false
+	}
+
+	for _, o := range fuseMountOptions {
+		if strings.HasPrefix(o, opsPerSecLimitFlag+"=") {
+			return "", false
+		}
+	}
+
+	return opsPerSecLimitFlag + "=" + strconv.Itoa(s.opsPerSecBudget.reserve(targetPath, budget)), true
+}
+
+// implicitDirsMountOption returns the implicit-dirs flag if the bucket has directories without directory placeholder objects,
+// which gcsfuse does not list otherwise. The detection is best effort, and the volume is mounted without the flag if it fails.
+func (s *nodeServer) implicitDirsMountOption(ctx context.Context, vc map[string]string, pod *corev1.Pod, volumeID, bucketName string, fuseMountOptions []string) (string, bool) {
+	for _, o := range fuseMountOptions {
+		if o == implicitDirsFlag || strings.HasPrefix(o, implicitDirsFlag+"=") {
+			return "", false
+		}
+	}
+
+	storageService, err := s.prepareStorageService(ctx, vc)
+	if err != nil {
+		klog.Warningf("failed to prepare storage service to detect implicit directories in GCS bucket %q: %v", bucketName, err)
+
+		return "", false
+	}
+	defer storageService.Close()
+
+	prefix := onlyDirPrefix(fuseMountOptions)
+	dir, found, err := storageService.FindImplicitDir(ctx, &storage.ServiceBucket{Name: bucketName, BillingProject: billingProject(fuseMountOptions)}, prefix, implicitDirsProbeMaxObjects)
+	if err != nil {
+		klog.Warningf("failed to detect implicit directories with prefix %q in GCS bucket %q: %v", prefix, bucketName, err)
+
+		return "", false
+	}
+	if !found {
+		klog.V(4).InfoS("no implicit directories found in GCS bucket", util.LogKeyBucket, bucketName, "prefix", prefix)
+
+		return "", false
+	}
+
+	s.k8sClients.Eventf(pod, corev1.EventTypeNormal, eventReasonImplicitDirsFound, "Volume %q is mounted with the gcsfuse %v flag, because directory %q in bucket %q has no directory placeholder object. Buckets with hierarchical namespace enabled list directories without the flag and perform better.", volumeID, implicitDirsFlag, dir, bucketName)
+
+	return implicitDirsFlag, true
+}
+
+// detectHierarchicalNamespace returns whether the bucket has hierarchical namespace enabled.
+// The detection is best effort, and the bucket is treated as a flat bucket if it fails.
+func (s *nodeServer) detectHierarchicalNamespace(ctx context.Context, vc map[string]string, bucketName string, fuseMountOptions []string) bool {
+	storageService, err := s.prepareStorageService(ctx, vc)
+	if err != nil {
+		klog.Warningf("failed to prepare storage service to detect hierarchical namespace of GCS bucket %q: %v", bucketName, err)
+
+		return false
+	}
+	defer storageService.Close()
+
+	enabled, err := storageService.IsHierarchicalNamespaceEnabled(ctx, &storage.ServiceBucket{Name: bucketName}, onlyDirPrefix(fuseMountOptions))
+	if err != nil {
+		klog.Warningf("failed to detect hierarchical namespace of GCS bucket %q: %v", bucketName, err)
+
+		return false
+	}
+	klog.V(4).InfoS("detected the hierarchical namespace of GCS bucket", util.LogKeyBucket, bucketName, "enabled", enabled)
+
+	return enabled
+}
+
+// checkSidecarVersion emits a Pod event if the sidecar mounter that connected to the volume socket is too old for the CSI driver.
+// The version is checked once per published volume, after the CSI driver recorded it at the socket handshake.
+func (s *nodeServer) checkSidecarVersion(pod *corev1.Pod, targetPath string) {
+	vs, ok := s.volumeStateStore.Load(targetPath)
+	if !ok || vs.SidecarVersionChecked {
+		return
+	}
+
+	// The file is missing until the sidecar mounter connects to the socket, and for volumes mounted by older CSI drivers.
+	version, err := os.ReadFile(filepath.Join(util.GetSidecarEmptyDirPath(targetPath, webhook.SidecarContainerTmpVolumeName), util.SidecarVersionFile))
+	if err != nil {
+		return
+	}
+	vs.SidecarVersionChecked = true
+
+	if reason, tooOld := sidecarVersionTooOld(string(version), s.driver.config.SidecarMinVersion); tooOld {
+		klog.Warningf("sidecar container image of Pod %v is too old: %v", klog.KObj(pod), reason)
+		s.k8sClients.Eventf(pod, corev1.EventTypeWarning, eventReasonSidecarTooOld, "Sidecar container image %q is too old for the CSI driver version %v: %v. Please recreate the Pod to use a newer sidecar container image.", sidecarContainerImage(pod), s.driver.config.Version, reason)
+	}
+}
+
+// reportMountOptionsChange records a warning event on the Pod, once per change, when the mount options of a volume
+// already published to the target path were edited. The volume keeps serving the published mount options until it is remounted.
+func (s *nodeServer) reportMountOptionsChange(pod *corev1.Pod, volumeID, targetPath string, requestedMountOptions []string) {
+	vs, ok := s.volumeStateStore.Load(targetPath)
+	if !ok || !vs.Published {
+		return
+	}
+	change := mountOptionsChange(vs, requestedMountOptions)
+	if change == "" || change == vs.ReportedMountOptionsChange {
+		return
+	}
+
+	vs.ReportedMountOptionsChange = change
+	s.k8sClients.Eventf(pod, corev1.EventTypeWarning, eventReasonMountOptionsChanged, "The mount options of volume %q changed after it was mounted to the Pod: %s. The volume keeps the mount options it was mounted with until the Pod is recreated.", volumeID, change)
+}
+
+// markVolumePublished records the bucket name, the Pod and the mount options the target path is published with,
+// unless they have been recorded already.
+func (s *nodeServer) markVolumePublished(targetPath, bucketName string, pod *corev1.Pod, requestedMountOptions, fuseMountOptions []string) {
+	vs, ok := s.volumeStateStore.Load(targetPath)
+	if !ok {
+		s.volumeStateStore.Store(targetPath, &util.VolumeState{})
+		vs, _ = s.volumeStateStore.Load(targetPath)
+	}
+
+	if vs.Published {
+		return
+	}
+
+	vs.Published = true
+	vs.PublishedBucketName = bucketName
+	vs.PublishedMountOptions = fuseMountOptions
+	vs.RequestedMountOptions = requestedMountOptions
+	vs.PublishedPodNamespace, vs.PublishedPodName, vs.PublishedServiceAccount = pod.Namespace, pod.Name, pod.Spec.ServiceAccountName
+	vs.PublishStats()
+}
+
+// audit writes the audit record of a volume mount or unmount to the audit sink, if it is configured.
+// Failures are logged, and do not fail the mount or unmount.
+func (s *nodeServer) audit(record *AuditRecord) {
+	if s.driver.config.AuditSink == nil {
+		return
+	}
+
+	record.Time = time.Now().UTC()
+	record.Node = s.driver.config.NodeID
+	if err := s.driver.config.AuditSink.Write(record); err != nil {
+		klog.ErrorS(err, "failed to write the audit record", "operation", record.Operation, util.LogKeyVolume, record.VolumeID, util.LogKeyTargetPath, record.TargetPath)
+	}
+}
+
 // isDirMounted checks if the path is already a mount point.
 func (s *nodeServer) isDirMounted(targetPath string) (bool, error) {
 	mps, err := s.mounter.List()
@@ -295,9 +1037,13 @@ func (s *nodeServer) isDirMounted(targetPath string) (bool, error) {
 	return false, nil
 }
 
-// prepareStorageService prepares the GCS Storage Service using the Kubernetes Service Account from VolumeContext.
+// prepareStorageService prepares the GCS Storage Service using the Kubernetes Service Account from VolumeContext,
+// or the GCP service account that the volume impersonates, so that the checks use the same identity as gcsfuse.
 func (s *nodeServer) prepareStorageService(ctx context.Context, vc map[string]string) (storage.Service, error) {
 	ts := s.driver.config.TokenManager.GetTokenSourceFromK8sServiceAccount(vc[VolumeContextKeyPodNamespace], vc[VolumeContextKeyServiceAccountName], vc[VolumeContextKeyServiceAccountToken])
+	if gcpSA := vc[VolumeContextKeyGCPServiceAccount]; gcpSA != "" {
+		ts = &auth.ImpersonatedTokenSource{Base: ts, ServiceAccount: gcpSA}
+	}
 	storageService, err := s.storageServiceManager.SetupService(ctx, ts)
 	if err != nil {
 		return nil, fmt.Errorf("storage service manager failed to setup service: %w", err)
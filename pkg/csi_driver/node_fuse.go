@@ -0,0 +1,244 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// NodeConditionFUSEUnsupported is the node condition that the node service sets to True when the startup check finds
+	// that the node cannot mount gcsfuse volumes, for example without the fuse kernel module, and to False otherwise.
+	NodeConditionFUSEUnsupported corev1.NodeConditionType = "GCSFuseUnsupported"
+
+	fuseReasonSupported              = "FUSESupported"
+	fuseReasonDeviceUnavailable      = "FUSEDeviceUnavailable"
+	fuseReasonFilesystemUnavailable  = "FUSEFilesystemUnavailable"
+	fuseReasonKernelUnsupported      = "KernelUnsupported"
+	fuseReasonMountCapabilityMissing = "MountCapabilityMissing"
+
+	// minFUSEKernelMajor and minFUSEKernelMinor are the oldest kernel release whose FUSE protocol version gcsfuse supports.
+	// The protocol version is only negotiated at mount time, so the check uses the kernel release.
+	minFUSEKernelMajor = 4
+	minFUSEKernelMinor = 4
+	// capSysAdmin is the bit of CAP_SYS_ADMIN in the capability sets, which the mount system call requires.
+	capSysAdmin = 21
+
+	fuseConditionPollInterval = 5 * time.Second
+)
+
+// fuseHost holds where the node service finds the FUSE device and the kernel information, which tests replace.
+type fuseHost struct {
+	devicePath string
+	procRoot   string
+}
+
+var defaultFUSEHost = fuseHost{devicePath: "/dev/fuse", procRoot: "/proc"}
+
+// fuseIncompatibility is why the node cannot mount gcsfuse volumes.
+type fuseIncompatibility struct {
+	reason  string
+	message string
+}
+
+// check returns why the node cannot mount gcsfuse volumes, or nil if it found the FUSE device, the FUSE file system,
+// a supported kernel and the mount capability.
+func (h fuseHost) check() *fuseIncompatibility {
+	device, err := os.OpenFile(h.devicePath, os.O_RDWR, 0)
+	if err != nil {
+		return &fuseIncompatibility{fuseReasonDeviceUnavailable, fmt.Sprintf("failed to open the FUSE device %s: %v", h.devicePath, err)}
+	}
+	device.Close()
+
+	filesystems, err := os.ReadFile(filepath.Join(h.procRoot, "filesystems"))
+	if err != nil {
+		return &fuseIncompatibility{fuseReasonFilesystemUnavailable, fmt.Sprintf("failed to read the file systems of the kernel: %v", err)}
+	}
+	if !hasFilesystem(string(filesystems), "fuse") {
+		return &fuseIncompatibility{fuseReasonFilesystemUnavailable, "the kernel does not support the fuse file system, load the fuse kernel module"}
+	}
+
+	release, err := os.ReadFile(filepath.Join(h.procRoot, "sys/kernel/osrelease"))
+	if err != nil {
+		return &fuseIncompatibility{fuseReasonKernelUnsupported, fmt.Sprintf("failed to read the kernel release: %v", err)}
+	}
+	if major, minor, ok := parseKernelRelease(string(release)); !ok || major < minFUSEKernelMajor || (major == minFUSEKernelMajor && minor < minFUSEKernelMinor) {
+		return &fuseIncompatibility{fuseReasonKernelUnsupported, fmt.Sprintf("kernel release %q is older than %d.%d", strings.TrimSpace(string(release)), minFUSEKernelMajor, minFUSEKernelMinor)}
+	}
+
+	capabilities, err := effectiveCapabilities(filepath.Join(h.procRoot, "self/status"))
+	if err != nil {
+		return &fuseIncompatibility{fuseReasonMountCapabilityMissing, fmt.Sprintf("failed to read the capabilities of the node service: %v", err)}
+	}
+	if capabilities&(1<<capSysAdmin) == 0 {
+		return &fuseIncompatibility{fuseReasonMountCapabilityMissing, "the node service does not have the CAP_SYS_ADMIN capability to mount volumes, run it as a privileged container"}
+	}
+
+	return nil
+}
+
+// hasFilesystem returns true if the kernel file systems, in the format of /proc/filesystems, include the file system.
+func hasFilesystem(filesystems, name string) bool {
+	for _, line := range strings.Split(filesystems, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[len(fields)-1] == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseKernelRelease returns the major and minor versions of a kernel release, such as 6.1.85+ or 5.15.0-1049-gke.
+func parseKernelRelease(release string) (int, int, bool) {
+	parts := strings.SplitN(strings.TrimSpace(release), ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	minor, err := strconv.Atoi(strings.TrimRightFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' }))
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return major, minor, true
+}
+
+// effectiveCapabilities returns the effective capability set of the CapEff line of a process status file.
+func effectiveCapabilities(statusPath string) (uint64, error) {
+	status, err := os.ReadFile(statusPath)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		if value, ok := strings.CutPrefix(line, "CapEff:"); ok {
+			return strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		}
+	}
+
+	return 0, fmt.Errorf("no CapEff in %s", statusPath)
+}
+
+// checkFUSECompatibility checks at startup whether the node can mount gcsfuse volumes, so that NodePublishVolume fails fast
+// with the reason on incompatible nodes, and records the result in the node metrics.
+func (s *nodeServer) checkFUSECompatibility() {
+	incompatibility := s.fuseHost.check()
+	s.fuseIncompatibility.Store(incompatibility)
+	if incompatibility != nil {
+		klog.Errorf("node %q cannot mount gcsfuse volumes (%s): %s", s.driver.config.NodeID, incompatibility.reason, incompatibility.message)
+	} else {
+		klog.Infof("node %q supports the FUSE mounts of gcsfuse volumes", s.driver.config.NodeID)
+	}
+	if mm := s.driver.config.MetricsManager; mm != nil {
+		mm.RecordNodeFUSESupported(incompatibility == nil)
+	}
+}
+
+// publishFUSECompatibility sets the NodeConditionFUSEUnsupported condition of the node to the result of checkFUSECompatibility,
+// so that cluster admins and schedulers can find the nodes that cannot mount gcsfuse volumes. It retries until the condition is set.
+func (s *nodeServer) publishFUSECompatibility(ctx context.Context) {
+	nodeID := s.driver.config.NodeID
+	err := wait.PollUntilContextCancel(ctx, fuseConditionPollInterval, true, func(ctx context.Context) (bool, error) {
+		// The result is read on each attempt, so that a retry does not overwrite the result of a later check.
+		condition := corev1.NodeCondition{
+			Type:              NodeConditionFUSEUnsupported,
+			Status:            corev1.ConditionFalse,
+			Reason:            fuseReasonSupported,
+			Message:           "The node supports the FUSE mounts of gcsfuse volumes",
+			LastHeartbeatTime: metav1.Now(),
+		}
+		if incompatibility := s.fuseIncompatibility.Load(); incompatibility != nil {
+			condition.Status = corev1.ConditionTrue
+			condition.Reason = incompatibility.reason
+			condition.Message = incompatibility.message
+		}
+		if err := s.k8sClients.SetNodeCondition(ctx, nodeID, condition); err != nil {
+			klog.Warningf("failed to set the %s condition of node %q: %v", NodeConditionFUSEUnsupported, nodeID, err)
+
+			return false, nil
+		}
+
+		return true, nil
+	})
+	if err != nil {
+		klog.Errorf("stopped setting the %s condition of node %q: %v", NodeConditionFUSEUnsupported, nodeID, err)
+	}
+}
+
+// fuseUnsupportedError returns the error of the mounts on a node that cannot mount gcsfuse volumes, or nil.
+// A failed check can be transient, for example when the FUSE device is created after the node service started,
+// so the node is checked again before the mount is refused, and the node condition is cleared once the check passes.
+func (s *nodeServer) fuseUnsupportedError() error {
+	incompatibility := s.fuseIncompatibility.Load()
+	if incompatibility == nil {
+		return nil
+	}
+
+	current := s.fuseHost.check()
+	if current == nil {
+		if s.fuseIncompatibility.CompareAndSwap(incompatibility, nil) {
+			klog.Infof("node %q supports the FUSE mounts of gcsfuse volumes after a failed check", s.driver.config.NodeID)
+			if mm := s.driver.config.MetricsManager; mm != nil {
+				mm.RecordNodeFUSESupported(true)
+			}
+			go s.publishFUSECompatibility(context.Background())
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("node %q cannot mount gcsfuse volumes (%s): %s, see the %s condition of the node", s.driver.config.NodeID, current.reason, current.message, NodeConditionFUSEUnsupported)
+}
+
+// podSandboxError returns the error of the mounts of a Pod whose RuntimeClass has one of the sandboxed handlers, or nil.
+// The FUSE check of the node cannot detect the sandboxes, which are set per Pod, and the webhook only checks the Pods
+// at admission when it is configured to, so the node service checks the Pod of each mount.
+func (s *nodeServer) podSandboxError(ctx context.Context, pod *corev1.Pod) error {
+	handlers := s.driver.config.SandboxedRuntimeHandlers
+	if len(handlers) == 0 || pod.Spec.RuntimeClassName == nil {
+		return nil
+	}
+
+	name := *pod.Spec.RuntimeClassName
+	handler, err := s.k8sClients.GetRuntimeClassHandler(ctx, name)
+	if err != nil {
+		klog.Warningf("failed to get the handler of RuntimeClass %q of Pod %s/%s, skipping the sandbox check: %v", name, pod.Namespace, pod.Name, err)
+
+		return nil
+	}
+	if !slices.Contains(handlers, handler) {
+		return nil
+	}
+
+	return fmt.Errorf("the Pod uses RuntimeClass %q with handler %q, which runs the Pod in a sandbox where the sidecar container cannot serve gcsfuse volumes, remove the runtimeClassName of the Pod", name, handler)
+}
@@ -0,0 +1,282 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/clientset"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	testFilesystems = "nodev\tsysfs\nnodev\tproc\n\text4\nnodev\tfuse\n\tfuseblk\n"
+	// testCapEff has all the capabilities of a privileged container.
+	testCapEff = "Name:\tgcs-fuse-csi-dr\nCapInh:\t0000000000000000\nCapEff:\t000001ffffffffff\n"
+)
+
+// newTestFUSEHost creates the FUSE device and the proc files of a fuseHost in a temporary directory.
+// Empty contents skip the file.
+func newTestFUSEHost(t *testing.T, device bool, filesystems, release, status string) fuseHost {
+	t.Helper()
+	dir := t.TempDir()
+	host := fuseHost{devicePath: filepath.Join(dir, "fuse"), procRoot: filepath.Join(dir, "proc")}
+	files := map[string]string{
+		filepath.Join(host.procRoot, "filesystems"):          filesystems,
+		filepath.Join(host.procRoot, "sys/kernel/osrelease"): release,
+		filepath.Join(host.procRoot, "self/status"):          status,
+	}
+	if device {
+		files[host.devicePath] = "device"
+	}
+	for path, content := range files {
+		if content == "" {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create the directory of %q: %v", path, err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %q: %v", path, err)
+		}
+	}
+
+	return host
+}
+
+func TestFUSEHostCheck(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		device       bool
+		filesystems  string
+		release      string
+		status       string
+		expectReason string
+	}{
+		{
+			name:        "supported",
+			device:      true,
+			filesystems: testFilesystems,
+			release:     "6.1.85+\n",
+			status:      testCapEff,
+		},
+		{
+			name:        "supported distribution kernel",
+			device:      true,
+			filesystems: testFilesystems,
+			release:     "5.15.0-1049-gke\n",
+			status:      testCapEff,
+		},
+		{
+			name:         "no FUSE device",
+			filesystems:  testFilesystems,
+			release:      "6.1.85+\n",
+			status:       testCapEff,
+			expectReason: fuseReasonDeviceUnavailable,
+		},
+		{
+			name:         "no fuse file system",
+			device:       true,
+			filesystems:  "nodev\tsysfs\n\text4\n\tfuseblk\n",
+			release:      "6.1.85+\n",
+			status:       testCapEff,
+			expectReason: fuseReasonFilesystemUnavailable,
+		},
+		{
+			name:         "old kernel",
+			device:       true,
+			filesystems:  testFilesystems,
+			release:      "3.10.0-1160.el7.x86_64\n",
+			status:       testCapEff,
+			expectReason: fuseReasonKernelUnsupported,
+		},
+		{
+			name:         "invalid kernel release",
+			device:       true,
+			filesystems:  testFilesystems,
+			release:      "unknown\n",
+			status:       testCapEff,
+			expectReason: fuseReasonKernelUnsupported,
+		},
+		{
+			name:         "no CAP_SYS_ADMIN",
+			device:       true,
+			filesystems:  testFilesystems,
+			release:      "6.1.85+\n",
+			status:       "Name:\tgcs-fuse-csi-dr\nCapEff:\t00000000a80425fb\n",
+			expectReason: fuseReasonMountCapabilityMissing,
+		},
+		{
+			name:         "no CapEff",
+			device:       true,
+			filesystems:  testFilesystems,
+			release:      "6.1.85+\n",
+			status:       "Name:\tgcs-fuse-csi-dr\n",
+			expectReason: fuseReasonMountCapabilityMissing,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			incompatibility := newTestFUSEHost(t, tc.device, tc.filesystems, tc.release, tc.status).check()
+			reason := ""
+			if incompatibility != nil {
+				reason = incompatibility.reason
+			}
+			if reason != tc.expectReason {
+				t.Errorf("got reason %q, want %q", reason, tc.expectReason)
+			}
+		})
+	}
+}
+
+func TestFUSECompatibility(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		device bool
+		// deviceLater creates the FUSE device after the startup check.
+		deviceLater   bool
+		expectStatus  corev1.ConditionStatus
+		expectReason  string
+		expectMountOK bool
+	}{
+		{
+			name:          "supported",
+			device:        true,
+			expectStatus:  corev1.ConditionFalse,
+			expectReason:  fuseReasonSupported,
+			expectMountOK: true,
+		},
+		{
+			name:         "unsupported",
+			expectStatus: corev1.ConditionTrue,
+			expectReason: fuseReasonDeviceUnavailable,
+		},
+		{
+			name:          "supported after the startup check",
+			deviceLater:   true,
+			expectStatus:  corev1.ConditionTrue,
+			expectReason:  fuseReasonDeviceUnavailable,
+			expectMountOK: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fakeClientset := clientset.NewFakeClientset()
+			testEnv := initTestNodeServerWithCustomClientset(t, fakeClientset)
+			ns, _ := testEnv.ns.(*nodeServer)
+			ns.fuseHost = newTestFUSEHost(t, tc.device, testFilesystems, "6.1.85+\n", testCapEff)
+
+			ns.checkFUSECompatibility()
+			ns.publishFUSECompatibility(context.Background())
+
+			node, _ := fakeClientset.GetNode("test-node")
+			if len(node.Status.Conditions) != 1 {
+				t.Fatalf("got node conditions %+v, want one condition", node.Status.Conditions)
+			}
+			condition := node.Status.Conditions[0]
+			if condition.Type != NodeConditionFUSEUnsupported || condition.Status != tc.expectStatus || condition.Reason != tc.expectReason {
+				t.Errorf("got node condition %+v, want type %q, status %q and reason %q", condition, NodeConditionFUSEUnsupported, tc.expectStatus, tc.expectReason)
+			}
+
+			if tc.deviceLater {
+				if err := os.WriteFile(ns.fuseHost.devicePath, []byte("device"), 0o644); err != nil {
+					t.Fatalf("failed to write %q: %v", ns.fuseHost.devicePath, err)
+				}
+			}
+
+			// The mount fails right away on unsupported nodes, before the request arguments are validated.
+			_, err := ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{})
+			if code := status.Code(err); (code == codes.FailedPrecondition) == tc.expectMountOK {
+				t.Errorf("got NodePublishVolume error %v, want FailedPrecondition %t", err, !tc.expectMountOK)
+			}
+			if incompatibility := ns.fuseIncompatibility.Load(); (incompatibility == nil) != tc.expectMountOK {
+				t.Errorf("got FUSE incompatibility %+v after the mount, want none %t", incompatibility, tc.expectMountOK)
+			}
+		})
+	}
+}
+
+func TestPodSandboxError(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name             string
+		handlers         []string
+		runtimeClassName *string
+		expectErr        bool
+	}{
+		{
+			name:     "no runtime class",
+			handlers: []string{"runsc"},
+		},
+		{
+			name:             "other runtime class",
+			handlers:         []string{"runsc"},
+			runtimeClassName: ptr.To("nvidia"),
+		},
+		{
+			name:             "sandboxed runtime class",
+			handlers:         []string{"runsc"},
+			runtimeClassName: ptr.To("sandbox"),
+			expectErr:        true,
+		},
+		{
+			name:             "unknown runtime class",
+			handlers:         []string{"runsc"},
+			runtimeClassName: ptr.To("missing"),
+		},
+		{
+			name:             "no sandboxed runtime handlers",
+			runtimeClassName: ptr.To("sandbox"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fakeClientset := clientset.NewFakeClientset()
+			fakeClientset.RuntimeClassHandlers = map[string]string{"sandbox": "runsc", "nvidia": "nvidia"}
+			testEnv := initTestNodeServerWithCustomClientset(t, fakeClientset)
+			ns, _ := testEnv.ns.(*nodeServer)
+			ns.driver.config.SandboxedRuntimeHandlers = tc.handlers
+
+			pod := &corev1.Pod{Spec: corev1.PodSpec{RuntimeClassName: tc.runtimeClassName}}
+			if err := ns.podSandboxError(context.Background(), pod); (err != nil) != tc.expectErr {
+				t.Errorf("got error %v, expected error %v", err, tc.expectErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/webhook"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+const (
+	eventReasonMemoryBudgetExceeded = "GCSFuseNodeMemoryBudgetExceeded"
+
+	// defaultCgroupRoot is where the node service reads the memory usage of the sidecar containers,
+	// from the host sysfs mounted into its container.
+	defaultCgroupRoot = "/sys/fs/cgroup"
+	// maxCgroupDepth is how deep the container cgroups are below the cgroup root, for example
+	// kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod<UID>.slice/cri-containerd-<ID>.scope with cgroup v2,
+	// or memory/kubepods/burstable/pod<UID>/<ID> with cgroup v1.
+	maxCgroupDepth = 5
+	// gcsfuseMemoryInterval is how often the node service records the gcsfuse memory on the node.
+	gcsfuseMemoryInterval = time.Minute
+)
+
+// cgroupMemoryFiles are the files with the memory usage of a cgroup, and the memory.stat keys of its inactive page cache,
+// with cgroup v2 and cgroup v1.
+var cgroupMemoryFiles = []struct{ usage, inactiveFileKey string }{
+	{"memory.current", "inactive_file"},
+	{"memory.usage_in_bytes", "total_inactive_file"},
+}
+
+// memoryBudget holds the memory reserved by the sidecar containers of the Pods whose volumes passed the node memory budget check,
+// so that the mounts of the Pods that are checked concurrently, before their gcsfuse processes use any memory, do not all pass.
+type memoryBudget struct {
+	mu       sync.Mutex
+	reserved map[types.UID]int64
+}
+
+func newMemoryBudget() *memoryBudget {
+	return &memoryBudget{reserved: map[types.UID]int64{}}
+}
+
+// gcsfuseMemoryBytes returns the total memory used by the sidecar containers of the Pods running on the node, except the Pod
+// with the UID, and the number of sidecar containers counted. The Pods are found through the Pod informer of the node, so the
+// memory of the sidecar containers started before the node service restarted is counted too. A sidecar container that uses
+// less memory than its reservation counts as its reservation, and the sidecar containers that have not started, or whose cgroup
+// cannot be read, only count their reservation. The caller must hold the lock of the memory budget.
+func (s *nodeServer) gcsfuseMemoryBytes(excludedPodUID types.UID) (int64, int) {
+	pods, listErr := s.k8sClients.ListPods()
+	if listErr != nil {
+		klog.V(4).Infof("failed to list the Pods on the node to measure the memory of the sidecar containers: %v", listErr)
+	}
+
+	listed := map[types.UID]bool{}
+	containerIDs := map[string]types.NamespacedName{}
+	podUIDs := map[string]types.UID{}
+	for _, pod := range pods {
+		listed[pod.UID] = true
+		if pod.UID == excludedPodUID {
+			continue
+		}
+		if id := sidecarContainerID(pod); id != "" {
+			containerIDs[id] = types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+			podUIDs[id] = pod.UID
+		}
+	}
+
+	usage := map[string]int64{}
+	if len(containerIDs) > 0 {
+		var err error
+		if usage, err = cgroupMemoryUsage(s.cgroupRoot, containerIDs); err != nil {
+			klog.V(4).Infof("failed to read the memory usage of the sidecar containers: %v", err)
+		}
+	}
+
+	perPod := map[types.UID]int64{}
+	for id, bytes := range usage {
+		perPod[podUIDs[id]] = bytes
+	}
+	for uid, reserved := range s.memoryBudget.reserved {
+		// The reservations of the Pods that left the node are released. They are kept if the Pods cannot be listed.
+		if listErr == nil && !listed[uid] {
+			delete(s.memoryBudget.reserved, uid)
+
+			continue
+		}
+		if uid != excludedPodUID {
+			perPod[uid] = max(perPod[uid], reserved)
+		}
+	}
+
+	var total int64
+	for _, bytes := range perPod {
+		total += bytes
+	}
+
+	return total, len(perPod)
+}
+
+// checkGcsfuseMemoryBudget returns a ResourceExhausted error, and records a warning event on the Pod,
+// if the memory used by the sidecar containers of the other Pods on the node, plus the memory request of the sidecar container
+// of the Pod, exceeds the node budget, so that kubelet retries the mount once memory is released instead of adding more gcsfuse processes to the node.
+// Otherwise, the memory request of the sidecar container of the Pod is reserved from the budget in the same critical section,
+// so that the Pods checked concurrently see each other before their gcsfuse processes start.
+func (s *nodeServer) checkGcsfuseMemoryBudget(pod *corev1.Pod, targetPath string) error {
+	budget := s.driver.config.GcsfuseNodeMemoryBudgetBytes
+	if budget <= 0 {
+		return nil
+	}
+
+	s.memoryBudget.mu.Lock()
+	defer s.memoryBudget.mu.Unlock()
+
+	used, measured := s.gcsfuseMemoryBytes(pod.UID)
+	request := sidecarMemoryRequest(pod)
+	if used+request <= budget {
+		if _, ok := s.memoryBudget.reserved[pod.UID]; !ok {
+			s.memoryBudget.reserved[pod.UID] = request
+		}
+
+		return nil
+	}
+
+	message := fmt.Sprintf("the %d gcsfuse sidecar containers on node %s use %s of memory, which with the %s memory request of the sidecar container of the Pod exceeds the node budget of %s; the volume is mounted once they use less memory",
+		measured, s.driver.config.NodeID, formatMiB(used), formatMiB(request), formatMiB(budget))
+	klog.Warningf("NodePublishVolume refused to mount target path %q for Pod %s: %s", targetPath, klog.KObj(pod), message)
+	s.k8sClients.Eventf(pod, corev1.EventTypeWarning, eventReasonMemoryBudgetExceeded, "Volume %s is not mounted: %s", volumeNameFromTargetPath(targetPath), message)
+	if mm := s.driver.config.MetricsManager; mm != nil {
+		mm.RecordGcsfuseMemoryBudgetRejection()
+	}
+
+	return status.Error(codes.ResourceExhausted, message)
+}
+
+// sidecarMemoryRequest returns the memory request of the sidecar container of the Pod, or its memory limit if the request is not set.
+func sidecarMemoryRequest(pod *corev1.Pod) int64 {
+	for _, c := range slices.Concat(pod.Spec.InitContainers, pod.Spec.Containers) {
+		if c.Name != webhook.GcsFuseSidecarName {
+			continue
+		}
+		if q, ok := c.Resources.Requests[corev1.ResourceMemory]; ok {
+			return q.Value()
+		}
+
+		return c.Resources.Limits.Memory().Value()
+	}
+
+	return 0
+}
+
+// recordGcsfuseMemory records the gcsfuse memory on the node as a metric.
+func (s *nodeServer) recordGcsfuseMemory() {
+	s.memoryBudget.mu.Lock()
+	used, _ := s.gcsfuseMemoryBytes("")
+	s.memoryBudget.mu.Unlock()
+
+	s.driver.config.MetricsManager.RecordNodeGcsfuseMemory(used, s.driver.config.GcsfuseNodeMemoryBudgetBytes)
+}
+
+// sidecarContainerID returns the ID of the running sidecar container of the Pod, without the container runtime prefix.
+func sidecarContainerID(pod *corev1.Pod) string {
+	for _, cs := range slices.Concat(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses) {
+		if cs.Name != webhook.GcsFuseSidecarName || cs.State.Running == nil {
+			continue
+		}
+		_, id, _ := strings.Cut(cs.ContainerID, "://")
+
+		return id
+	}
+
+	return ""
+}
+
+// cgroupMemoryUsage returns the working set memory of the cgroups of the containers, by container ID. The cgroup of a container
+// is the directory below the cgroup root whose name contains the container ID. The containers whose cgroup is not found are omitted.
+func cgroupMemoryUsage(cgroupRoot string, containerIDs map[string]types.NamespacedName) (map[string]int64, error) {
+	usage := map[string]int64{}
+	err := filepath.WalkDir(cgroupRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if strings.Count(strings.TrimPrefix(path, cgroupRoot), string(filepath.Separator)) > maxCgroupDepth {
+			return filepath.SkipDir
+		}
+
+		for id, pod := range containerIDs {
+			if _, found := usage[id]; found || !strings.Contains(d.Name(), id) {
+				continue
+			}
+			for _, f := range cgroupMemoryFiles {
+				data, err := os.ReadFile(filepath.Join(path, f.usage))
+				if err != nil {
+					continue
+				}
+				bytes, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+				if err != nil {
+					klog.V(4).Infof("failed to parse the memory usage of the sidecar container of Pod %s: %v", pod, err)
+
+					break
+				}
+				usage[id] = max(bytes-cgroupInactiveFileBytes(path, f.inactiveFileKey), 0)
+
+				break
+			}
+		}
+		if len(usage) == len(containerIDs) {
+			return filepath.SkipAll
+		}
+
+		return nil
+	})
+
+	return usage, err
+}
+
+// cgroupInactiveFileBytes returns the inactive page cache of the cgroup from its memory.stat file, or 0 if it cannot be read.
+// The kernel reclaims the inactive page cache under memory pressure, so like kubelet, the usage without it is the working set.
+func cgroupInactiveFileBytes(cgroupDir, key string) int64 {
+	data, err := os.ReadFile(filepath.Join(cgroupDir, "memory.stat"))
+	if err != nil {
+		return 0
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		k, v, ok := strings.Cut(line, " ")
+		if !ok || k != key {
+			continue
+		}
+		bytes, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			return 0
+		}
+
+		return bytes
+	}
+
+	return 0
+}
+
+func formatMiB(bytes int64) string {
+	return strconv.FormatInt(bytes/1024/1024, 10) + "MiB"
+}
+
+func volumeNameFromTargetPath(targetPath string) string {
+	_, volumeName, err := util.ParsePodIDVolumeFromTargetpath(targetPath)
+	if err != nil {
+		return targetPath
+	}
+
+	return volumeName
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/clientset"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/metrics"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/webhook"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func writeCgroupMemory(t *testing.T, dir, file string, bytes int64) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, file), []byte(strconv.FormatInt(bytes, 10)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCgroupMemoryUsage(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	dir := filepath.Join(root, "kubepods.slice", "kubepods-burstable.slice", "kubepods-burstable-pod1.slice", "cri-containerd-aaa.scope")
+	writeCgroupMemory(t, dir, "memory.current", 150)
+	// The inactive page cache is not part of the working set.
+	if err := os.WriteFile(filepath.Join(dir, "memory.stat"), []byte("active_file 30\ninactive_file 50\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dir = filepath.Join(root, "memory", "kubepods", "besteffort", "pod2", "bbb")
+	writeCgroupMemory(t, dir, "memory.usage_in_bytes", 200)
+	if err := os.WriteFile(filepath.Join(dir, "memory.stat"), []byte("inactive_file 500\ntotal_inactive_file 0\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// The cgroups of the other controllers have no memory usage file.
+	if err := os.MkdirAll(filepath.Join(root, "cpu", "kubepods", "besteffort", "pod2", "bbb"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	// The cgroups deeper than the container cgroups are not searched.
+	writeCgroupMemory(t, filepath.Join(root, "a", "b", "c", "d", "e", "f", "ccc"), "memory.current", 300)
+
+	containerIDs := map[string]types.NamespacedName{
+		"aaa": {Namespace: "ns", Name: "pod-1"},
+		"bbb": {Namespace: "ns", Name: "pod-2"},
+		"ccc": {Namespace: "ns", Name: "pod-3"},
+	}
+	usage, err := cgroupMemoryUsage(root, containerIDs)
+	if err != nil {
+		t.Fatalf("cgroupMemoryUsage() got error %v", err)
+	}
+	if diff := cmp.Diff(map[string]int64{"aaa": 100, "bbb": 200}, usage); diff != "" {
+		t.Errorf("unexpected memory usage (-want +got):\n%s", diff)
+	}
+}
+
+func TestCheckGcsfuseMemoryBudget(t *testing.T) {
+	t.Parallel()
+
+	fakeClientset := clientset.NewFakeClientset()
+	testEnv := initTestNodeServerWithCustomClientset(t, fakeClientset)
+	ns, _ := testEnv.ns.(*nodeServer)
+	ns.cgroupRoot = t.TempDir()
+	ns.driver.config.GcsfuseNodeMemoryBudgetBytes = 256 * 1024 * 1024
+	mm, _ := ns.driver.config.MetricsManager.(*metrics.FakeMetricsManager)
+
+	// The fake clientset lists a single Pod on the node, whose sidecar container runs in the cgroup below.
+	other, _ := fakeClientset.GetPod("other-ns", "other-pod")
+	other.UID = "other-uid"
+	other.Status.ContainerStatuses[0].ContainerID = "containerd://abc123"
+	other.Spec.Containers[0].Resources.Requests = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")}
+	cgroupDir := filepath.Join(ns.cgroupRoot, "kubepods.slice", "kubepods-pod.slice", "cri-containerd-abc123.scope")
+	writeCgroupMemory(t, cgroupDir, "memory.current", 300*1024*1024)
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "new-pod", Namespace: "new-ns", UID: "new-uid"}}
+	targetPath := "/var/lib/kubelet/pods/new-uid/volumes/kubernetes.io~csi/data/mount"
+
+	err := ns.checkGcsfuseMemoryBudget(pod, targetPath)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got error %v, want ResourceExhausted", err)
+	}
+	if !strings.Contains(err.Error(), "use 300MiB of memory, which with the 0MiB memory request of the sidecar container of the Pod exceeds the node budget of 256MiB") {
+		t.Errorf("unexpected error message: %v", err)
+	}
+	if len(fakeClientset.Events) != 1 || !strings.HasPrefix(fakeClientset.Events[0], "Warning GCSFuseNodeMemoryBudgetExceeded Volume data is not mounted") {
+		t.Errorf("unexpected events %q", fakeClientset.Events)
+	}
+	if mm.MemoryBudgetRejections != 1 {
+		t.Errorf("got %d rejections, want 1", mm.MemoryBudgetRejections)
+	}
+
+	// The sidecar container of the Pod itself is not counted.
+	if err := ns.checkGcsfuseMemoryBudget(other, targetPath); err != nil {
+		t.Errorf("got error %v for the Pod whose sidecar container uses the memory", err)
+	}
+
+	ns.recordGcsfuseMemory()
+	if mm.NodeGcsfuseMemory != 300*1024*1024 {
+		t.Errorf("got recorded memory %d, want %d", mm.NodeGcsfuseMemory, 300*1024*1024)
+	}
+
+	// The memory request of the sidecar container of the Pod is what pushes the memory past the budget.
+	pod.Spec.Containers = []corev1.Container{{
+		Name:      webhook.GcsFuseSidecarName,
+		Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")}},
+	}}
+	writeCgroupMemory(t, cgroupDir, "memory.current", 200*1024*1024)
+	err = ns.checkGcsfuseMemoryBudget(pod, targetPath)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got error %v with the memory request of the sidecar container past the budget, want ResourceExhausted", err)
+	}
+	if !strings.Contains(err.Error(), "use 200MiB of memory, which with the 64MiB memory request of the sidecar container of the Pod exceeds the node budget of 256MiB") {
+		t.Errorf("unexpected error message: %v", err)
+	}
+
+	writeCgroupMemory(t, cgroupDir, "memory.current", 100*1024*1024)
+	if err := ns.checkGcsfuseMemoryBudget(pod, targetPath); err != nil {
+		t.Errorf("got error %v once the memory is below the budget", err)
+	}
+
+	// The sidecar container that has not started yet counts as its memory request, reserved when its volume passed the check.
+	if err := os.Remove(filepath.Join(cgroupDir, "memory.current")); err != nil {
+		t.Fatal(err)
+	}
+	other.Spec.Containers[0].Resources.Requests = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("200Mi")}
+	ns.memoryBudget = newMemoryBudget()
+	if err := ns.checkGcsfuseMemoryBudget(other, targetPath); err != nil {
+		t.Errorf("got error %v before the sidecar container started", err)
+	}
+	if err := ns.checkGcsfuseMemoryBudget(pod, targetPath); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("got error %v with the memory reserved by the other Pod, want ResourceExhausted", err)
+	}
+
+	ns.driver.config.GcsfuseNodeMemoryBudgetBytes = 0
+	writeCgroupMemory(t, cgroupDir, "memory.current", 300*1024*1024)
+	if err := ns.checkGcsfuseMemoryBudget(pod, targetPath); err != nil {
+		t.Errorf("got error %v without a budget", err)
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import "sync"
+
+// opsPerSecBudget is the node GCS operations budget, one pool shared by all the gcsfuse volumes on the node.
+// gcsfuse only takes a fixed limit when it starts, so each volume reserves its limit from the pool when it is mounted,
+// and returns it when it is unmounted. The limits of the volumes never add up to more than the budget,
+// except for the minimum of one operation per second of each volume.
+type opsPerSecBudget struct {
+	mu       sync.Mutex
+	reserved map[string]int
+}
+
+func newOpsPerSecBudget() *opsPerSecBudget {
+	return &opsPerSecBudget{reserved: map[string]int{}}
+}
+
+// reserve reserves the limit of the volume at the target path from a budget of total operations per second:
+// an equal share of the budget for the volumes that hold a reservation and the new volume, capped at the unreserved budget,
+// and at least one operation per second. A volume that already holds a reservation keeps it.
+func (b *opsPerSecBudget) reserve(targetPath string, total int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if limit, ok := b.reserved[targetPath]; ok {
+		return limit
+	}
+
+	unreserved := total
+	for _, limit := range b.reserved {
+		unreserved -= limit
+	}
+	limit := max(min(total/(len(b.reserved)+1), unreserved), 1)
+	b.reserved[targetPath] = limit
+
+	return limit
+}
+
+// release returns the limit of the volume at the target path to the budget.
+func (b *opsPerSecBudget) release(targetPath string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.reserved, targetPath)
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import "testing"
+
+func TestOpsPerSecBudget(t *testing.T) {
+	t.Parallel()
+
+	type step struct {
+		release       bool
+		targetPath    string
+		expectedLimit int
+	}
+	testCases := []struct {
+		name   string
+		budget int
+		steps  []step
+	}{
+		{
+			name:   "single volume",
+			budget: 1000,
+			steps:  []step{{targetPath: "a", expectedLimit: 1000}},
+		},
+		{
+			name:   "the limits never exceed the budget",
+			budget: 90,
+			steps: []step{
+				{targetPath: "a", expectedLimit: 90},
+				{targetPath: "b", expectedLimit: 1},
+				{release: true, targetPath: "a"},
+				{targetPath: "c", expectedLimit: 45},
+				{targetPath: "d", expectedLimit: 30},
+				{targetPath: "e", expectedLimit: 14},
+			},
+		},
+		{
+			name:   "reserving again keeps the limit",
+			budget: 100,
+			steps: []step{
+				{targetPath: "a", expectedLimit: 100},
+				{targetPath: "a", expectedLimit: 100},
+			},
+		},
+		{
+			name:   "more volumes than the budget",
+			budget: 1,
+			steps: []step{
+				{targetPath: "a", expectedLimit: 1},
+				{targetPath: "b", expectedLimit: 1},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			b := newOpsPerSecBudget()
+			for i, s := range tc.steps {
+				if s.release {
+					b.release(s.targetPath)
+
+					continue
+				}
+				if limit := b.reserve(s.targetPath, tc.budget); limit != s.expectedLimit {
+					t.Errorf("step %d: got limit %d for %q, expected %d", i, limit, s.targetPath, s.expectedLimit)
+				}
+			}
+		})
+	}
+}
@@ -19,20 +19,31 @@ package driver
 
 import (
 	"errors"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/clientset"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/storage"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/metrics"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/webhook"
 	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	mount "k8s.io/mount-utils"
 )
 
@@ -120,6 +131,25 @@ func TestNodePublishVolume(t *testing.T) {
 			},
 			expectedMount: &mount.MountPoint{Device: testVolumeID, Path: testTargetPath, Type: "fuse", Opts: []string{}},
 		},
+		{
+			name: "target path with parent path elements",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:         testVolumeID,
+				TargetPath:       base + "/../escape/mount",
+				VolumeCapability: testVolumeCapability,
+			},
+			expectErr: status.Errorf(codes.InvalidArgument, "target path %q is not canonical, expected %q", base+"/../escape/mount", filepath.Join(tmpDir, "escape/mount")),
+		},
+		{
+			name: "only-dir outside of the bucket",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:         testVolumeID,
+				TargetPath:       testTargetPath,
+				VolumeCapability: testVolumeCapability,
+				VolumeContext:    map[string]string{VolumeContextKeyMountOptions: "only-dir=data/../../other"},
+			},
+			expectErr: status.Error(codes.InvalidArgument, `mount option only-dir cannot contain ".." path elements, got "data/../../other"`),
+		},
 		{
 			name:   "valid request already mounted",
 			mounts: []mount.MountPoint{{Device: "/test-device", Path: testTargetPath}},
@@ -131,68 +161,1021 @@ func TestNodePublishVolume(t *testing.T) {
 			expectedMount: &mount.MountPoint{Device: "/test-device", Path: testTargetPath},
 		},
 		{
-			name: "valid request with user mount options",
-			req: &csi.NodePublishVolumeRequest{
-				VolumeId:   testVolumeID,
-				TargetPath: testTargetPath,
-				VolumeCapability: &csi.VolumeCapability{
-					AccessType: &csi.VolumeCapability_Mount{
-						Mount: &csi.VolumeCapability_MountVolume{
-							MountFlags: []string{"foo", "bar"},
-						},
-					},
-					AccessMode: &csi.VolumeCapability_AccessMode{
-						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
-					},
-				},
-			},
-			expectedMount: &mount.MountPoint{Device: testVolumeID, Path: testTargetPath, Type: "fuse", Opts: []string{"foo", "bar"}},
+			name: "valid request with user mount options",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:   testVolumeID,
+				TargetPath: testTargetPath,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{
+							MountFlags: []string{"foo", "bar"},
+						},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+			},
+			expectedMount: &mount.MountPoint{Device: testVolumeID, Path: testTargetPath, Type: "fuse", Opts: []string{"foo", "bar"}},
+		},
+		{
+			name: "valid request read only",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:         testVolumeID,
+				TargetPath:       testTargetPath,
+				VolumeCapability: testVolumeCapability,
+				Readonly:         true,
+			},
+			expectedMount: &mount.MountPoint{Device: testVolumeID, Path: testTargetPath, Type: "fuse", Opts: []string{"ro"}},
+		},
+		{
+			name: "experimental flags not allowed",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:         testVolumeID,
+				TargetPath:       testTargetPath,
+				VolumeCapability: testVolumeCapability,
+				VolumeContext:    map[string]string{VolumeContextKeyGcsfuseExperimentalFlags: "experimental-enable-json-read=true"},
+			},
+			expectErr: status.Error(codes.InvalidArgument, `volume attribute gcsfuseExperimentalFlags got gcsfuse flag "experimental-enable-json-read" that is not allowed by the cluster admin, allowed flags: []`),
+		},
+		{
+			name: "valid request with implicit dirs auto-detection",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:         testVolumeID,
+				TargetPath:       testTargetPath,
+				VolumeCapability: testVolumeCapability,
+				VolumeContext:    map[string]string{VolumeContextKeyImplicitDirsAutoDetect: "true"},
+			},
+			expectedMount: &mount.MountPoint{Device: testVolumeID, Path: testTargetPath, Type: "fuse", Opts: []string{}},
+		},
+		{
+			name: "invalid implicit dirs auto-detection",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:         testVolumeID,
+				TargetPath:       testTargetPath,
+				VolumeCapability: testVolumeCapability,
+				VolumeContext:    map[string]string{VolumeContextKeyImplicitDirsAutoDetect: "maybe"},
+			},
+			expectErr: status.Error(codes.InvalidArgument, `volume attribute implicitDirsAutoDetect only accepts a valid bool value, got "maybe"`),
+		},
+		{
+			name: "valid request with hierarchical namespace",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:         testVolumeID,
+				TargetPath:       testTargetPath,
+				VolumeCapability: testVolumeCapability,
+				VolumeContext:    map[string]string{VolumeContextKeyMountOptions: "implicit-dirs", VolumeContextKeyHierarchicalNamespace: "true"},
+			},
+			expectedMount: &mount.MountPoint{Device: testVolumeID, Path: testTargetPath, Type: "fuse", Opts: []string{"enable-hns"}},
+		},
+		{
+			name: "valid request with hierarchical namespace auto-detection in a flat bucket",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:         testVolumeID,
+				TargetPath:       testTargetPath,
+				VolumeCapability: testVolumeCapability,
+				VolumeContext:    map[string]string{VolumeContextKeyMountOptions: "implicit-dirs", VolumeContextKeyHierarchicalNamespace: "auto"},
+			},
+			expectedMount: &mount.MountPoint{Device: testVolumeID, Path: testTargetPath, Type: "fuse", Opts: []string{"implicit-dirs"}},
+		},
+		{
+			name: "invalid hierarchical namespace",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:         testVolumeID,
+				TargetPath:       testTargetPath,
+				VolumeCapability: testVolumeCapability,
+				VolumeContext:    map[string]string{VolumeContextKeyHierarchicalNamespace: "maybe"},
+			},
+			expectErr: status.Error(codes.InvalidArgument, `volume attribute hierarchicalNamespace only accepts a valid bool value or "auto", got "maybe"`),
+		},
+		{
+			name: "hierarchical namespace auto-detection when mounting all the buckets",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:         "_",
+				TargetPath:       testTargetPath,
+				VolumeCapability: testVolumeCapability,
+				VolumeContext:    map[string]string{VolumeContextKeyHierarchicalNamespace: "auto"},
+			},
+			expectErr: status.Error(codes.InvalidArgument, "volume attribute hierarchicalNamespace cannot be used when mounting all the buckets"),
+		},
+		{
+			name: "valid request verifying read on mount",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:         testVolumeID,
+				TargetPath:       testTargetPath,
+				VolumeCapability: testVolumeCapability,
+				VolumeContext:    map[string]string{VolumeContextKeyVerifyReadOnMount: "true", VolumeContextKeyVerifyReadObject: "canary"},
+			},
+			expectedMount: &mount.MountPoint{Device: testVolumeID, Path: testTargetPath, Type: "fuse", Opts: []string{}},
+		},
+		{
+			name: "verify read on mount fails",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:         "missing-bucket",
+				TargetPath:       testTargetPath,
+				VolumeCapability: testVolumeCapability,
+				VolumeContext:    map[string]string{VolumeContextKeyVerifyReadOnMount: "true", VolumeContextKeySkipCSIBucketAccessCheck: "true"},
+			},
+			expectErr: status.Error(codes.NotFound, `failed to verify reading GCS bucket "missing-bucket": storage: bucket doesn't exist`),
+		},
+		{
+			name: "empty target path",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:         testVolumeID,
+				VolumeCapability: testVolumeCapability,
+			},
+			expectErr: status.Error(codes.InvalidArgument, "NodePublishVolume target path must be provided"),
+		},
+		{
+			name: "invalid volume capability",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:   testVolumeID,
+				TargetPath: testTargetPath,
+			},
+			expectErr: status.Error(codes.InvalidArgument, "volume capability must be provided"),
+		},
+	}
+
+	for _, test := range cases {
+		testEnv := initTestNodeServer(t)
+		if test.mounts != nil {
+			testEnv.fm.MountPoints = test.mounts
+		}
+		_, err := testEnv.ns.NodePublishVolume(context.TODO(), test.req)
+		if test.expectErr == nil && err != nil {
+			t.Errorf("test %q failed:\ngot error %q,\nexpected error nil", test.name, err)
+		}
+		if test.expectErr != nil && !errors.Is(err, test.expectErr) {
+			t.Errorf("test %q failed:\ngot error %q,\nexpected error %q", test.name, err, test.expectErr)
+		}
+		validateMountPoint(t, test.name, testEnv.fm, test.expectedMount)
+	}
+}
+
+func TestNodePublishVolumeWIDisabledOnNode(t *testing.T) {
+	t.Parallel()
+	defaultPerm := os.FileMode(0o750) + os.ModeDir
+	// Setup mount target path
+	tmpDir := "/tmp/var/lib/kubelet/pods/test-pod-id/volumes/kubernetes.io~csi/"
+	if err := os.MkdirAll(tmpDir, defaultPerm); err != nil {
+		t.Fatalf("failed to setup tmp dir path: %v", err)
+	}
+	base, err := os.MkdirTemp(tmpDir, "node-publish-")
+	if err != nil {
+		t.Fatalf("failed to setup testdir: %v", err)
+	}
+	testTargetPath := filepath.Join(base, "mount")
+	if err = os.MkdirAll(testTargetPath, defaultPerm); err != nil {
+		t.Fatalf("failed to setup target path: %v", err)
+	}
+	defer os.RemoveAll(base)
+
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:         testVolumeID,
+		TargetPath:       testTargetPath,
+		VolumeCapability: testVolumeCapability,
+	}
+
+	cases := []struct {
+		name                          string
+		hostNetworkEnabledOnPod       bool
+		workloadIdentityEnabledOnNode bool
+		expectErr                     error
+	}{
+		{
+			name:                          "workload identity is enabled on node + pod using hostnetwork",
+			hostNetworkEnabledOnPod:       true,
+			workloadIdentityEnabledOnNode: true,
+		},
+		{
+			name:                          "workload identity is not enabled on node + pod is not using hostnetwork, expecting error",
+			hostNetworkEnabledOnPod:       false,
+			workloadIdentityEnabledOnNode: false,
+			expectErr:                     status.Errorf(codes.FailedPrecondition, "Workload Identity Federation is not enabled on node. Please make sure this is enabled on both cluster and node pool level (https://cloud.google.com/kubernetes-engine/docs/how-to/workload-identity)"),
+		},
+		{
+			name:                          "testcase3",
+			hostNetworkEnabledOnPod:       false,
+			workloadIdentityEnabledOnNode: true,
+		},
+		{
+			name:                          "testcase4",
+			hostNetworkEnabledOnPod:       true,
+			workloadIdentityEnabledOnNode: false,
+			// TODO: confirm if this case needs to throw an error (if hostnetwork requires node to have GKE Metadata server enabled) once hostnetwork feature is available for testing
+		},
+	}
+	for _, test := range cases {
+		fakeClientSet := &clientset.FakeClientset{}
+		fakeClientSet.CreateNode( /* workloadIdentityEnabled */ test.workloadIdentityEnabledOnNode)
+		fakeClientSet.CreatePod( /* hostNetworkEnabled */ test.hostNetworkEnabledOnPod)
+		testEnv := initTestNodeServerWithCustomClientset(t, fakeClientSet)
+
+		_, err = testEnv.ns.NodePublishVolume(context.TODO(), req)
+		if test.expectErr == nil && err != nil {
+			t.Errorf("test %q failed:\ngot error %q,\nexpected error nil", test.name, err)
+		}
+		if test.expectErr != nil && !errors.Is(err, test.expectErr) {
+			t.Errorf("test %q failed:\ngot error %q,\nexpected error %q", test.name, err, test.expectErr)
+		}
+	}
+
+}
+
+func TestNodePublishVolumeRecordsMountOptionsEvent(t *testing.T) {
+	t.Parallel()
+	defaultPerm := os.FileMode(0o750) + os.ModeDir
+	// Setup mount target path
+	tmpDir := "/tmp/var/lib/kubelet/pods/test-pod-id/volumes/kubernetes.io~csi/"
+	if err := os.MkdirAll(tmpDir, defaultPerm); err != nil {
+		t.Fatalf("failed to setup tmp dir path: %v", err)
+	}
+	base, err := os.MkdirTemp(tmpDir, "node-publish-")
+	if err != nil {
+		t.Fatalf("failed to setup testdir: %v", err)
+	}
+	testTargetPath := filepath.Join(base, "mount")
+	if err = os.MkdirAll(testTargetPath, defaultPerm); err != nil {
+		t.Fatalf("failed to setup target path: %v", err)
+	}
+	defer os.RemoveAll(base)
+
+	fakeClientSet := clientset.NewFakeClientset()
+	testEnv := initTestNodeServerWithCustomClientset(t, fakeClientSet)
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:         testVolumeID,
+		TargetPath:       testTargetPath,
+		VolumeCapability: testVolumeCapability,
+		Readonly:         true,
+		VolumeContext:    map[string]string{VolumeContextKeyMetadataCacheTTLSeconds: "60"},
+	}
+
+	if _, err := testEnv.ns.NodePublishVolume(context.TODO(), req); err != nil {
+		t.Fatalf("NodePublishVolume failed: %v", err)
+	}
+
+	expectedEvents := []string{`Normal GCSFuseMountOptions Volume "test-volume-id" for bucket "test-volume-id" is mounted with gcsfuse mount options ["metadata-cache:ttl-secs:60" "ro"]`}
+	if diff := cmp.Diff(fakeClientSet.Events, expectedEvents); diff != "" {
+		t.Errorf("unexpected events (-got, +want)\n%s", diff)
+	}
+}
+
+func TestNodePublishVolumeDeprecatedVolumeAttributesEvent(t *testing.T) {
+	t.Parallel()
+	defaultPerm := os.FileMode(0o750) + os.ModeDir
+	// Setup mount target path
+	tmpDir := "/tmp/var/lib/kubelet/pods/test-pod-id/volumes/kubernetes.io~csi/"
+	if err := os.MkdirAll(tmpDir, defaultPerm); err != nil {
+		t.Fatalf("failed to setup tmp dir path: %v", err)
+	}
+	base, err := os.MkdirTemp(tmpDir, "node-publish-")
+	if err != nil {
+		t.Fatalf("failed to setup testdir: %v", err)
+	}
+	testTargetPath := filepath.Join(base, "mount")
+	if err = os.MkdirAll(testTargetPath, defaultPerm); err != nil {
+		t.Fatalf("failed to setup target path: %v", err)
+	}
+	defer os.RemoveAll(base)
+
+	fakeClientSet := clientset.NewFakeClientset()
+	testEnv := initTestNodeServerWithCustomClientset(t, fakeClientSet)
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:         testVolumeID,
+		TargetPath:       testTargetPath,
+		VolumeCapability: testVolumeCapability,
+		Readonly:         true,
+		VolumeContext:    map[string]string{VolumeContextKeyMetadataCacheTtlSeconds: "60"},
+	}
+
+	if _, err := testEnv.ns.NodePublishVolume(context.TODO(), req); err != nil {
+		t.Fatalf("NodePublishVolume failed: %v", err)
+	}
+
+	expectedEvents := []string{
+		`Normal GCSFuseMountOptions Volume "test-volume-id" for bucket "test-volume-id" is mounted with gcsfuse mount options ["metadata-cache:ttl-secs:60" "ro"]`,
+		`Warning GCSFuseDeprecatedVolumeAttributes Volume "test-volume-id" uses deprecated settings, which are translated for now: volume attribute metadataCacheTtlSeconds is deprecated, use metadataCacheTTLSeconds instead. Update the volume, and set the volume attribute volumeAttributesVersion to "v1" to reject deprecated settings.`,
+	}
+	if diff := cmp.Diff(fakeClientSet.Events, expectedEvents); diff != "" {
+		t.Errorf("unexpected events (-got, +want)\n%s", diff)
+	}
+
+	// With the current schema version, the deprecated settings are rejected.
+	req.VolumeContext[VolumeContextKeyVolumeAttributesVersion] = volumeAttributesVersionV1
+	if _, err := testEnv.ns.NodePublishVolume(context.TODO(), req); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v, expected code %v", err, codes.InvalidArgument)
+	}
+}
+
+// recordingAuditSink keeps the audit records in memory.
+type recordingAuditSink struct {
+	records []*AuditRecord
+}
+
+func (s *recordingAuditSink) Write(record *AuditRecord) error {
+	s.records = append(s.records, record)
+
+	return nil
+}
+
+func TestNodePublishVolumeAuditRecords(t *testing.T) {
+	t.Parallel()
+	defaultPerm := os.FileMode(0o750) + os.ModeDir
+	// Setup mount target path
+	tmpDir := "/tmp/var/lib/kubelet/pods/test-pod-id/volumes/kubernetes.io~csi/"
+	if err := os.MkdirAll(tmpDir, defaultPerm); err != nil {
+		t.Fatalf("failed to setup tmp dir path: %v", err)
+	}
+	base, err := os.MkdirTemp(tmpDir, "node-publish-")
+	if err != nil {
+		t.Fatalf("failed to setup testdir: %v", err)
+	}
+	testTargetPath := filepath.Join(base, "mount")
+	if err = os.MkdirAll(testTargetPath, defaultPerm); err != nil {
+		t.Fatalf("failed to setup target path: %v", err)
+	}
+	defer os.RemoveAll(base)
+
+	testEnv := initTestNodeServerWithCustomClientset(t, clientset.NewFakeClientset())
+	ns, _ := testEnv.ns.(*nodeServer)
+	sink := &recordingAuditSink{}
+	ns.driver.config.AuditSink = sink
+
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:         testVolumeID,
+		TargetPath:       testTargetPath,
+		VolumeCapability: testVolumeCapability,
+		Readonly:         true,
+		VolumeContext:    map[string]string{VolumeContextKeyPodNamespace: "test-namespace", VolumeContextKeyPodName: "test-pod"},
+	}
+	// The republish of a mounted volume is not audited.
+	for range 2 {
+		if _, err := testEnv.ns.NodePublishVolume(context.TODO(), req); err != nil {
+			t.Fatalf("NodePublishVolume failed: %v", err)
+		}
+	}
+	if _, err := testEnv.ns.NodeUnpublishVolume(context.TODO(), &csi.NodeUnpublishVolumeRequest{VolumeId: testVolumeID, TargetPath: testTargetPath}); err != nil {
+		t.Fatalf("NodeUnpublishVolume failed: %v", err)
+	}
+
+	if len(sink.records) != 2 {
+		t.Fatalf("got %d audit records, expected 2", len(sink.records))
+	}
+	for i, operation := range []string{AuditOperationMount, AuditOperationUnmount} {
+		r := sink.records[i]
+		if r.Operation != operation || r.VolumeID != testVolumeID || r.TargetPath != testTargetPath || r.BucketName != testVolumeID || !r.ReadOnly || r.Node != ns.driver.config.NodeID {
+			t.Errorf("unexpected %v audit record %+v", operation, r)
+		}
+		if r.PodNamespace != "test-namespace" || r.PodName != "test-pod" {
+			t.Errorf("expected the %v audit record to identify the Pod, got %+v", operation, r)
+		}
+		if diff := cmp.Diff([]string{"ro"}, r.MountOptions); diff != "" {
+			t.Errorf("unexpected %v audit record mount options (-want +got):\n%s", operation, diff)
+		}
+	}
+}
+
+func TestNodePublishVolumeHierarchicalNamespaceAutoDetect(t *testing.T) {
+	t.Parallel()
+	defaultPerm := os.FileMode(0o750) + os.ModeDir
+	// Setup mount target path
+	tmpDir := "/tmp/var/lib/kubelet/pods/test-pod-id/volumes/kubernetes.io~csi/"
+	if err := os.MkdirAll(tmpDir, defaultPerm); err != nil {
+		t.Fatalf("failed to setup tmp dir path: %v", err)
+	}
+	base, err := os.MkdirTemp(tmpDir, "node-publish-")
+	if err != nil {
+		t.Fatalf("failed to setup testdir: %v", err)
+	}
+	testTargetPath := filepath.Join(base, "mount")
+	if err = os.MkdirAll(testTargetPath, defaultPerm); err != nil {
+		t.Fatalf("failed to setup target path: %v", err)
+	}
+	defer os.RemoveAll(base)
+
+	fakeClientSet := clientset.NewFakeClientset()
+	testEnv := initTestNodeServerWithCustomClientset(t, fakeClientSet)
+	ns, _ := testEnv.ns.(*nodeServer)
+	s, _ := ns.storageServiceManager.SetupService(context.TODO(), nil)
+	if _, err := s.CreateBucket(context.TODO(), &storage.ServiceBucket{Name: "test-hns-bucket", EnableHierarchicalNamespace: true}); err != nil {
+		t.Fatalf("failed to create the fake bucket: %v", err)
+	}
+
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:         "test-hns-bucket",
+		TargetPath:       testTargetPath,
+		VolumeCapability: testVolumeCapability,
+		VolumeContext: map[string]string{
+			VolumeContextKeyMountOptions:           "implicit-dirs",
+			VolumeContextKeyHierarchicalNamespace:  "auto",
+			VolumeContextKeyImplicitDirsAutoDetect: "true",
+		},
+	}
+
+	if _, err := testEnv.ns.NodePublishVolume(context.TODO(), req); err != nil {
+		t.Fatalf("NodePublishVolume failed: %v", err)
+	}
+
+	validateMountPoint(t, "hierarchical namespace auto-detection", testEnv.fm, &mount.MountPoint{Device: "test-hns-bucket", Path: testTargetPath, Type: "fuse", Opts: []string{"enable-hns"}})
+
+	// Republishing the mounted volume must not repeat the events.
+	if _, err := testEnv.ns.NodePublishVolume(context.TODO(), req); err != nil {
+		t.Fatalf("NodePublishVolume republish failed: %v", err)
+	}
+
+	expectedEvents := []string{
+		`Normal GCSFuseMountOptions Volume "test-hns-bucket" for bucket "test-hns-bucket" is mounted with gcsfuse mount options ["enable-hns"]`,
+		`Normal GCSFuseHierarchicalNamespace Volume "test-hns-bucket" is mounted with the gcsfuse enable-hns flag, because bucket "test-hns-bucket" has hierarchical namespace enabled. Directory renames on the volume are atomic.`,
+	}
+	if diff := cmp.Diff(fakeClientSet.Events, expectedEvents); diff != "" {
+		t.Errorf("unexpected events (-got, +want)\n%s", diff)
+	}
+}
+
+func TestNodePublishVolumeSidecarTooOldEvent(t *testing.T) {
+	t.Parallel()
+	defaultPerm := os.FileMode(0o750) + os.ModeDir
+	// Setup mount target path
+	tmpDir := "/tmp/var/lib/kubelet/pods/test-pod-id/volumes/kubernetes.io~csi/"
+	if err := os.MkdirAll(tmpDir, defaultPerm); err != nil {
+		t.Fatalf("failed to setup tmp dir path: %v", err)
+	}
+	base, err := os.MkdirTemp(tmpDir, "node-publish-")
+	if err != nil {
+		t.Fatalf("failed to setup testdir: %v", err)
+	}
+	testTargetPath := filepath.Join(base, "mount")
+	if err = os.MkdirAll(testTargetPath, defaultPerm); err != nil {
+		t.Fatalf("failed to setup target path: %v", err)
+	}
+	defer os.RemoveAll(base)
+
+	fakeClientSet := clientset.NewFakeClientset()
+	testEnv := initTestNodeServerWithCustomClientset(t, fakeClientSet)
+	ns, _ := testEnv.ns.(*nodeServer)
+	ns.driver.config.SidecarMinVersion = "v1.15.0"
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:         testVolumeID,
+		TargetPath:       testTargetPath,
+		VolumeCapability: testVolumeCapability,
+	}
+
+	if _, err := testEnv.ns.NodePublishVolume(context.TODO(), req); err != nil {
+		t.Fatalf("NodePublishVolume failed: %v", err)
+	}
+
+	// The sidecar mounter connected to the socket and reported a version older than the minimum version.
+	emptyDirBasePath := util.GetSidecarEmptyDirPath(testTargetPath, webhook.SidecarContainerTmpVolumeName)
+	if err := os.MkdirAll(emptyDirBasePath, defaultPerm); err != nil {
+		t.Fatalf("failed to setup emptyDir path: %v", err)
+	}
+	defer os.RemoveAll(emptyDirBasePath)
+	if err := os.WriteFile(filepath.Join(emptyDirBasePath, util.SidecarVersionFile), []byte("v1.14.1"), 0o600); err != nil {
+		t.Fatalf("failed to write the sidecar version file: %v", err)
+	}
+
+	// The event is only emitted once.
+	for range 2 {
+		if _, err := testEnv.ns.NodePublishVolume(context.TODO(), req); err != nil {
+			t.Fatalf("NodePublishVolume republish failed: %v", err)
+		}
+	}
+
+	sidecarImage := webhook.FakeConfig().ContainerImage
+	expectedEvents := []string{
+		`Normal GCSFuseMountOptions Volume "test-volume-id" for bucket "test-volume-id" is mounted with gcsfuse mount options []`,
+		`Warning GCSFuseSidecarTooOld Sidecar container image "` + sidecarImage + `" is too old for the CSI driver version test-version: the sidecar mounter version v1.14.1 is older than the required version v1.15.0. Please recreate the Pod to use a newer sidecar container image.`,
+	}
+	if diff := cmp.Diff(fakeClientSet.Events, expectedEvents); diff != "" {
+		t.Errorf("unexpected events (-got, +want)\n%s", diff)
+	}
+}
+
+func TestNodePublishVolumeOpsPerSecBudget(t *testing.T) {
+	t.Parallel()
+	defaultPerm := os.FileMode(0o750) + os.ModeDir
+	// Setup mount target path
+	tmpDir := "/tmp/var/lib/kubelet/pods/test-pod-id/volumes/kubernetes.io~csi/"
+	if err := os.MkdirAll(tmpDir, defaultPerm); err != nil {
+		t.Fatalf("failed to setup tmp dir path: %v", err)
+	}
+	base, err := os.MkdirTemp(tmpDir, "node-publish-")
+	if err != nil {
+		t.Fatalf("failed to setup testdir: %v", err)
+	}
+	defer os.RemoveAll(base)
+
+	testEnv := initTestNodeServer(t)
+	ns, _ := testEnv.ns.(*nodeServer)
+	ns.driver.config.GcsfuseNodeOpsPerSecBudget = 100
+
+	publish := func(name string, mountFlags ...string) []string {
+		t.Helper()
+		targetPath := filepath.Join(base+"-"+name, "mount")
+		t.Cleanup(func() { os.RemoveAll(base + "-" + name) })
+		if err := os.MkdirAll(targetPath, defaultPerm); err != nil {
+			t.Fatalf("failed to setup target path: %v", err)
+		}
+		req := &csi.NodePublishVolumeRequest{
+			VolumeId:   testVolumeID,
+			TargetPath: targetPath,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{MountFlags: mountFlags}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			},
+		}
+		if _, err := ns.NodePublishVolume(context.TODO(), req); err != nil {
+			t.Fatalf("NodePublishVolume failed: %v", err)
+		}
+		// Republishing must not conflict with the published mount options.
+		if _, err := ns.NodePublishVolume(context.TODO(), req); err != nil {
+			t.Fatalf("NodePublishVolume republish failed: %v", err)
+		}
+		for _, mp := range testEnv.fm.MountPoints {
+			if mp.Path == targetPath {
+				return mp.Opts
+			}
+		}
+		t.Fatalf("target path %q is not mounted", targetPath)
+
+		return nil
+	}
+
+	if diff := cmp.Diff(publish("first"), []string{"limit-ops-per-sec=100"}); diff != "" {
+		t.Errorf("unexpected mount options of the first volume (-got, +want)\n%s", diff)
+	}
+	if diff := cmp.Diff(publish("second"), []string{"limit-ops-per-sec=1"}); diff != "" {
+		t.Errorf("unexpected mount options of the second volume (-got, +want)\n%s", diff)
+	}
+	// Unmounting the first volume returns its limit to the budget.
+	if _, err := ns.NodeUnpublishVolume(context.TODO(), &csi.NodeUnpublishVolumeRequest{VolumeId: testVolumeID, TargetPath: filepath.Join(base+"-first", "mount")}); err != nil {
+		t.Fatalf("NodeUnpublishVolume failed: %v", err)
+	}
+	if diff := cmp.Diff(publish("third"), []string{"limit-ops-per-sec=50"}); diff != "" {
+		t.Errorf("unexpected mount options of the third volume (-got, +want)\n%s", diff)
+	}
+	if diff := cmp.Diff(publish("custom", "limit-ops-per-sec=500"), []string{"limit-ops-per-sec=500"}); diff != "" {
+		t.Errorf("unexpected mount options of the volume with a custom limit (-got, +want)\n%s", diff)
+	}
+}
+
+func TestNodePublishVolumeDownscopeReadOnlyTokens(t *testing.T) {
+	t.Parallel()
+	defaultPerm := os.FileMode(0o750) + os.ModeDir
+	// Setup mount target path
+	tmpDir := "/tmp/var/lib/kubelet/pods/test-pod-id/volumes/kubernetes.io~csi/"
+	if err := os.MkdirAll(tmpDir, defaultPerm); err != nil {
+		t.Fatalf("failed to setup tmp dir path: %v", err)
+	}
+	base, err := os.MkdirTemp(tmpDir, "node-publish-")
+	if err != nil {
+		t.Fatalf("failed to setup testdir: %v", err)
+	}
+	defer os.RemoveAll(base)
+
+	cases := []struct {
+		name         string
+		readOnly     bool
+		expectedOpts []string
+	}{
+		{
+			name:         "read-only",
+			readOnly:     true,
+			expectedOpts: []string{"ro", "token-server-read-only"},
+		},
+		{
+			name:         "read-write",
+			expectedOpts: []string{},
+		},
+	}
+
+	for _, tc := range cases {
+		testEnv := initTestNodeServer(t)
+		ns, _ := testEnv.ns.(*nodeServer)
+		ns.driver.config.DownscopeReadOnlyVolumeTokens = true
+
+		targetPath := filepath.Join(base+"-"+tc.name, "mount")
+		t.Cleanup(func() { os.RemoveAll(base + "-" + tc.name) })
+		if err := os.MkdirAll(targetPath, defaultPerm); err != nil {
+			t.Fatalf("failed to setup target path: %v", err)
+		}
+		req := &csi.NodePublishVolumeRequest{
+			VolumeId:         testVolumeID,
+			TargetPath:       targetPath,
+			VolumeCapability: testVolumeCapability,
+			Readonly:         tc.readOnly,
+		}
+		if _, err := ns.NodePublishVolume(context.TODO(), req); err != nil {
+			t.Fatalf("test %q failed: NodePublishVolume failed: %v", tc.name, err)
+		}
+		validateMountPoint(t, tc.name, testEnv.fm, &mount.MountPoint{Device: testVolumeID, Path: targetPath, Type: "fuse", Opts: tc.expectedOpts})
+	}
+}
+
+func TestNodePublishVolumeStorageEndpoint(t *testing.T) {
+	t.Parallel()
+	defaultPerm := os.FileMode(0o750) + os.ModeDir
+	// Setup mount target path
+	tmpDir := "/tmp/var/lib/kubelet/pods/test-pod-id/volumes/kubernetes.io~csi/"
+	if err := os.MkdirAll(tmpDir, defaultPerm); err != nil {
+		t.Fatalf("failed to setup tmp dir path: %v", err)
+	}
+	base, err := os.MkdirTemp(tmpDir, "node-publish-")
+	if err != nil {
+		t.Fatalf("failed to setup testdir: %v", err)
+	}
+	defer os.RemoveAll(base)
+
+	cases := []struct {
+		name          string
+		endpointURL   string
+		volumeContext map[string]string
+		expectedOpts  []string
+	}{
+		{
+			name:         "default-endpoint",
+			expectedOpts: []string{},
+		},
+		{
+			name:         "restricted-endpoint",
+			endpointURL:  "https://restricted.googleapis.com/storage/v1/",
+			expectedOpts: []string{"custom-endpoint=https://restricted.googleapis.com/storage/v1/"},
+		},
+		{
+			name:          "volume-endpoint",
+			endpointURL:   "https://restricted.googleapis.com/storage/v1/",
+			volumeContext: map[string]string{VolumeContextKeyMountOptions: "custom-endpoint=https://storage-example.p.googleapis.com/storage/v1/"},
+			expectedOpts:  []string{"custom-endpoint=https://storage-example.p.googleapis.com/storage/v1/"},
+		},
+	}
+
+	for _, tc := range cases {
+		testEnv := initTestNodeServer(t)
+		ns, _ := testEnv.ns.(*nodeServer)
+		ns.driver.config.StorageEndpointURL = tc.endpointURL
+
+		targetPath := filepath.Join(base+"-"+tc.name, "mount")
+		t.Cleanup(func() { os.RemoveAll(base + "-" + tc.name) })
+		if err := os.MkdirAll(targetPath, defaultPerm); err != nil {
+			t.Fatalf("failed to setup target path: %v", err)
+		}
+		req := &csi.NodePublishVolumeRequest{
+			VolumeId:         testVolumeID,
+			TargetPath:       targetPath,
+			VolumeCapability: testVolumeCapability,
+			VolumeContext:    tc.volumeContext,
+		}
+		if _, err := ns.NodePublishVolume(context.TODO(), req); err != nil {
+			t.Fatalf("test %q failed: NodePublishVolume failed: %v", tc.name, err)
+		}
+		validateMountPoint(t, tc.name, testEnv.fm, &mount.MountPoint{Device: testVolumeID, Path: targetPath, Type: "fuse", Opts: tc.expectedOpts})
+	}
+}
+
+// vpcSCDeniedServiceManager sets up storage services that are denied access to the buckets by a VPC Service Controls perimeter.
+type vpcSCDeniedServiceManager struct {
+	storage.ServiceManager
+}
+
+type vpcSCDeniedService struct {
+	storage.Service
+}
+
+func (m *vpcSCDeniedServiceManager) SetupService(ctx context.Context, ts oauth2.TokenSource) (storage.Service, error) {
+	ss, err := m.ServiceManager.SetupService(ctx, ts)
+
+	return &vpcSCDeniedService{Service: ss}, err
+}
+
+func (s *vpcSCDeniedService) CheckBucketExists(_ context.Context, _ *storage.ServiceBucket) (bool, error) {
+	return false, &googleapi.Error{
+		Code:    http.StatusForbidden,
+		Message: "Request is prohibited by organization's policy. vpcServiceControlsUniqueIdentifier: abc123",
+	}
+}
+
+func TestNodePublishVolumeVPCSCDenied(t *testing.T) {
+	t.Parallel()
+	defaultPerm := os.FileMode(0o750) + os.ModeDir
+	// Setup mount target path
+	tmpDir := "/tmp/var/lib/kubelet/pods/test-pod-id/volumes/kubernetes.io~csi/"
+	if err := os.MkdirAll(tmpDir, defaultPerm); err != nil {
+		t.Fatalf("failed to setup tmp dir path: %v", err)
+	}
+	base, err := os.MkdirTemp(tmpDir, "node-publish-")
+	if err != nil {
+		t.Fatalf("failed to setup testdir: %v", err)
+	}
+	testTargetPath := filepath.Join(base, "mount")
+	if err = os.MkdirAll(testTargetPath, defaultPerm); err != nil {
+		t.Fatalf("failed to setup target path: %v", err)
+	}
+	defer os.RemoveAll(base)
+
+	fakeClientSet := clientset.NewFakeClientset()
+	testEnv := initTestNodeServerWithCustomClientset(t, fakeClientSet)
+	ns, _ := testEnv.ns.(*nodeServer)
+	ns.storageServiceManager = &vpcSCDeniedServiceManager{ServiceManager: ns.storageServiceManager}
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:         testVolumeID,
+		TargetPath:       testTargetPath,
+		VolumeCapability: testVolumeCapability,
+	}
+
+	_, err = ns.NodePublishVolume(context.TODO(), req)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("got error %v, expected a PermissionDenied error", err)
+	}
+
+	expectedEvents := []string{
+		`Warning GCSFuseVPCServiceControlsDenied Access to GCS bucket "test-volume-id" was denied by a VPC Service Controls perimeter, not by IAM. Make sure that the projects of the bucket and the cluster are in the same service perimeter, or that an ingress rule allows the identity of the Pod. Find the violation in the audit logs with the vpcServiceControlsUniqueIdentifier of the error: googleapi: Error 403: Request is prohibited by organization's policy. vpcServiceControlsUniqueIdentifier: abc123`,
+	}
+	if diff := cmp.Diff(fakeClientSet.Events, expectedEvents); diff != "" {
+		t.Errorf("unexpected events (-got, +want)\n%s", diff)
+	}
+}
+
+// flakyServiceManager sets up storage services that fail the first failures bucket checks with err,
+// or with an unavailable backend if err is nil.
+type flakyServiceManager struct {
+	storage.ServiceManager
+	failures int32
+	err      error
+	calls    atomic.Int32
+}
+
+type flakyService struct {
+	storage.Service
+	manager *flakyServiceManager
+}
+
+func (m *flakyServiceManager) SetupService(ctx context.Context, ts oauth2.TokenSource) (storage.Service, error) {
+	ss, err := m.ServiceManager.SetupService(ctx, ts)
+
+	return &flakyService{Service: ss, manager: m}, err
+}
+
+func (s *flakyService) CheckBucketExists(ctx context.Context, obj *storage.ServiceBucket) (bool, error) {
+	if s.manager.calls.Add(1) <= s.manager.failures {
+		if s.manager.err != nil {
+			return false, s.manager.err
+		}
+
+		return false, &googleapi.Error{Code: http.StatusServiceUnavailable, Message: "backend unavailable"}
+	}
+
+	return s.Service.CheckBucketExists(ctx, obj)
+}
+
+func TestNodePublishVolumeMountRetry(t *testing.T) {
+	t.Parallel()
+	defaultPerm := os.FileMode(0o750) + os.ModeDir
+	// Setup mount target path
+	tmpDir := "/tmp/var/lib/kubelet/pods/test-pod-id/volumes/kubernetes.io~csi/"
+	if err := os.MkdirAll(tmpDir, defaultPerm); err != nil {
+		t.Fatalf("failed to setup tmp dir path: %v", err)
+	}
+	base, err := os.MkdirTemp(tmpDir, "node-publish-")
+	if err != nil {
+		t.Fatalf("failed to setup testdir: %v", err)
+	}
+	defer os.RemoveAll(base)
+
+	cases := []struct {
+		name               string
+		remount            bool
+		budget             time.Duration
+		interval           time.Duration
+		failures           int32
+		err                error
+		expectErrCode      codes.Code
+		expectCalls        int32
+		expectRetryEvents  []string
+		expectMountOptions bool
+	}{
+		{
+			name:          "first-mount-without-budget",
+			failures:      1,
+			expectErrCode: codes.Internal,
+			expectCalls:   1,
+		},
+		{
+			name:          "first-mount-permission-denied-not-retried",
+			budget:        time.Second,
+			interval:      time.Millisecond,
+			failures:      1,
+			err:           &googleapi.Error{Code: http.StatusForbidden, Message: "caller does not have storage.buckets.get access"},
+			expectErrCode: codes.PermissionDenied,
+			expectCalls:   1,
+		},
+		{
+			name:          "first-mount-vpc-sc-denial-not-retried",
+			budget:        time.Second,
+			interval:      time.Millisecond,
+			failures:      1,
+			err:           &googleapi.Error{Code: http.StatusBadRequest, Message: "Request violates VPC Service Controls: SECURITY_POLICY_VIOLATED"},
+			expectErrCode: codes.Internal,
+			expectCalls:   1,
+		},
+		{
+			name:               "first-mount-retried-until-access-is-granted",
+			budget:             time.Second,
+			interval:           time.Millisecond,
+			failures:           2,
+			expectCalls:        3,
+			expectMountOptions: true,
 		},
 		{
-			name: "valid request read only",
-			req: &csi.NodePublishVolumeRequest{
-				VolumeId:         testVolumeID,
-				TargetPath:       testTargetPath,
-				VolumeCapability: testVolumeCapability,
-				Readonly:         true,
-			},
-			expectedMount: &mount.MountPoint{Device: testVolumeID, Path: testTargetPath, Type: "fuse", Opts: []string{"ro"}},
+			name:          "first-mount-budget-exhausted",
+			budget:        50 * time.Millisecond,
+			interval:      30 * time.Millisecond,
+			failures:      10,
+			expectErrCode: codes.Internal,
+			expectCalls:   2,
 		},
 		{
-			name: "empty target path",
-			req: &csi.NodePublishVolumeRequest{
-				VolumeId:         testVolumeID,
-				VolumeCapability: testVolumeCapability,
+			name:        "remount-retried-with-events",
+			remount:     true,
+			budget:      time.Second,
+			interval:    time.Millisecond,
+			failures:    2,
+			expectCalls: 3,
+			expectRetryEvents: []string{
+				`Warning GCSFuseRemountRetry Remounting volume "test-volume-id" for bucket "test-volume-id" failed, retrying in 1ms: failed to get GCS bucket "test-volume-id": googleapi: Error 503: backend unavailable`,
+				`Warning GCSFuseRemountRetry Remounting volume "test-volume-id" for bucket "test-volume-id" failed, retrying in 2ms: failed to get GCS bucket "test-volume-id": googleapi: Error 503: backend unavailable`,
 			},
-			expectErr: status.Error(codes.InvalidArgument, "NodePublishVolume target path must be provided"),
 		},
 		{
-			name: "invalid volume capability",
-			req: &csi.NodePublishVolumeRequest{
-				VolumeId:   testVolumeID,
-				TargetPath: testTargetPath,
-			},
-			expectErr: status.Error(codes.InvalidArgument, "volume capability must be provided"),
+			name:          "remount-without-budget",
+			remount:       true,
+			failures:      1,
+			expectErrCode: codes.Internal,
+			expectCalls:   1,
 		},
 	}
 
-	for _, test := range cases {
-		testEnv := initTestNodeServer(t)
-		if test.mounts != nil {
-			testEnv.fm.MountPoints = test.mounts
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			testTargetPath := filepath.Join(base+"-"+tc.name, "mount")
+			if err := os.MkdirAll(testTargetPath, defaultPerm); err != nil {
+				t.Fatalf("failed to setup target path: %v", err)
+			}
+			defer os.RemoveAll(base + "-" + tc.name)
+
+			fakeClientSet := clientset.NewFakeClientset()
+			testEnv := initTestNodeServerWithCustomClientset(t, fakeClientSet)
+			ns, _ := testEnv.ns.(*nodeServer)
+			ns.driver.config.FirstMountRetryBudget, ns.driver.config.FirstMountRetryInterval = tc.budget, tc.interval
+			ns.driver.config.RemountRetryBudget, ns.driver.config.RemountRetryInitialBackoff = tc.budget, tc.interval
+			manager := &flakyServiceManager{ServiceManager: ns.storageServiceManager, failures: tc.failures, err: tc.err}
+			ns.storageServiceManager = manager
+			if tc.remount {
+				// The mount outlives the CSI driver restart that cleared the volume state.
+				testEnv.fm.MountPoints = append(testEnv.fm.MountPoints, mount.MountPoint{Device: testVolumeID, Path: testTargetPath, Type: FuseMountType})
+			}
+
+			_, err := ns.NodePublishVolume(context.TODO(), &csi.NodePublishVolumeRequest{
+				VolumeId:         testVolumeID,
+				TargetPath:       testTargetPath,
+				VolumeCapability: testVolumeCapability,
+			})
+			if status.Code(err) != tc.expectErrCode {
+				t.Fatalf("got error %v, expected code %v", err, tc.expectErrCode)
+			}
+			if calls := manager.calls.Load(); calls != tc.expectCalls {
+				t.Errorf("got %v bucket checks, expected %v", calls, tc.expectCalls)
+			}
+
+			var retryEvents []string
+			mountOptionsRecorded := false
+			for _, e := range fakeClientSet.Events {
+				switch {
+				case strings.HasPrefix(e, "Warning "+eventReasonRemountRetry+" "):
+					retryEvents = append(retryEvents, e)
+				case strings.HasPrefix(e, "Normal "+eventReasonGcsFuseMountOptions+" "):
+					mountOptionsRecorded = true
+				}
+			}
+			if diff := cmp.Diff(retryEvents, tc.expectRetryEvents); diff != "" {
+				t.Errorf("unexpected retry events (-got, +want)\n%s", diff)
+			}
+			if mountOptionsRecorded != tc.expectMountOptions {
+				t.Errorf("got mount options event %v, expected %v", mountOptionsRecorded, tc.expectMountOptions)
+			}
+		})
+	}
+}
+
+func TestNodeGetVolumeStatsCondition(t *testing.T) {
+	t.Parallel()
+	defaultPerm := os.FileMode(0o750) + os.ModeDir
+	// Setup mount target path
+	tmpDir := "/tmp/var/lib/kubelet/pods/test-pod-id/volumes/kubernetes.io~csi/"
+	if err := os.MkdirAll(tmpDir, defaultPerm); err != nil {
+		t.Fatalf("failed to setup tmp dir path: %v", err)
+	}
+	base, err := os.MkdirTemp(tmpDir, "node-publish-")
+	if err != nil {
+		t.Fatalf("failed to setup testdir: %v", err)
+	}
+	testTargetPath := filepath.Join(base, "mount")
+	if err = os.MkdirAll(testTargetPath, defaultPerm); err != nil {
+		t.Fatalf("failed to setup target path: %v", err)
+	}
+	defer os.RemoveAll(base)
+
+	fakeClientSet := clientset.NewFakeClientset()
+	testEnv := initTestNodeServerWithCustomClientset(t, fakeClientSet)
+	ns, _ := testEnv.ns.(*nodeServer)
+	storageService, _ := ns.storageServiceManager.SetupService(context.TODO(), nil)
+	publishReq := &csi.NodePublishVolumeRequest{
+		VolumeId:         testVolumeID,
+		TargetPath:       testTargetPath,
+		VolumeCapability: testVolumeCapability,
+	}
+	statsReq := &csi.NodeGetVolumeStatsRequest{VolumeId: testVolumeID, VolumePath: testTargetPath}
+
+	// republish runs the NodePublishVolume call that kubelet makes periodically, bypassing the bucket health check interval.
+	republish := func() {
+		t.Helper()
+		if vs, ok := ns.volumeStateStore.Load(testTargetPath); ok {
+			vs.BucketHealthCheckedAt = time.Time{}
 		}
-		_, err := testEnv.ns.NodePublishVolume(context.TODO(), test.req)
-		if test.expectErr == nil && err != nil {
-			t.Errorf("test %q failed:\ngot error %q,\nexpected error nil", test.name, err)
+		if _, err := ns.NodePublishVolume(context.TODO(), publishReq); err != nil {
+			t.Fatalf("NodePublishVolume failed: %v", err)
 		}
-		if test.expectErr != nil && !errors.Is(err, test.expectErr) {
-			t.Errorf("test %q failed:\ngot error %q,\nexpected error %q", test.name, err, test.expectErr)
+	}
+	expectCondition := func(abnormal bool) {
+		t.Helper()
+		resp, err := ns.NodeGetVolumeStats(context.TODO(), statsReq)
+		if err != nil {
+			t.Fatalf("NodeGetVolumeStats failed: %v", err)
 		}
-		validateMountPoint(t, test.name, testEnv.fm, test.expectedMount)
+		if resp.GetVolumeCondition().GetAbnormal() != abnormal {
+			t.Errorf("got volume condition %v, expected abnormal %v", resp.GetVolumeCondition(), abnormal)
+		}
+	}
+
+	if _, err := ns.NodeGetVolumeStats(context.TODO(), statsReq); status.Code(err) != codes.NotFound {
+		t.Errorf("got error %v before the volume is published, expected NotFound", err)
+	}
+
+	republish()
+	expectCondition(false)
+
+	// The stats are reported while a NodePublishVolume call holds the volume lock.
+	if !ns.volumeLocks.TryAcquire(testTargetPath) {
+		t.Fatalf("failed to acquire the volume lock")
+	}
+	expectCondition(false)
+	ns.volumeLocks.Release(testTargetPath)
+
+	if err := storageService.DeleteBucket(context.TODO(), &storage.ServiceBucket{Name: testVolumeID}); err != nil {
+		t.Fatalf("failed to delete the fake bucket: %v", err)
+	}
+	republish()
+	expectCondition(true)
+
+	if _, err := storageService.CreateBucket(context.TODO(), &storage.ServiceBucket{Name: testVolumeID}); err != nil {
+		t.Fatalf("failed to create the fake bucket: %v", err)
+	}
+	republish()
+	expectCondition(false)
+
+	expectedEvents := []string{
+		`Warning GCSFuseVolumeAbnormal Volume for bucket "test-volume-id" is abnormal: GCS bucket "test-volume-id" is not accessible: storage: bucket doesn't exist`,
+		`Normal GCSFuseVolumeRecovered Volume for bucket "test-volume-id" is accessible again`,
+	}
+	if diff := cmp.Diff(fakeClientSet.Events[1:], expectedEvents); diff != "" {
+		t.Errorf("unexpected events (-got, +want)\n%s", diff)
 	}
 }
 
-func TestNodePublishVolumeWIDisabledOnNode(t *testing.T) {
+// usageServiceManager sets up storage services that count the objects of the buckets as usage.
+type usageServiceManager struct {
+	storage.ServiceManager
+	usage storage.ObjectUsage
+}
+
+type usageService struct {
+	storage.Service
+	manager *usageServiceManager
+}
+
+func (m *usageServiceManager) SetupService(ctx context.Context, ts oauth2.TokenSource) (storage.Service, error) {
+	ss, err := m.ServiceManager.SetupService(ctx, ts)
+
+	return &usageService{Service: ss, manager: m}, err
+}
+
+func (s *usageService) GetObjectUsage(_ context.Context, _ *storage.ServiceBucket, _ string, _ int) (*storage.ObjectUsage, error) {
+	usage := s.manager.usage
+
+	return &usage, nil
+}
+
+func TestNodeGetVolumeStatsUsage(t *testing.T) {
 	t.Parallel()
 	defaultPerm := os.FileMode(0o750) + os.ModeDir
 	// Setup mount target path
@@ -210,56 +1193,67 @@ func TestNodePublishVolumeWIDisabledOnNode(t *testing.T) {
 	}
 	defer os.RemoveAll(base)
 
-	req := &csi.NodePublishVolumeRequest{
+	testEnv := initTestNodeServerWithCustomClientset(t, clientset.NewFakeClientset())
+	ns, _ := testEnv.ns.(*nodeServer)
+	ns.driver.config.VolumeStatsMaxObjects = 100
+	sm := &usageServiceManager{ServiceManager: ns.storageServiceManager, usage: storage.ObjectUsage{Objects: 3, Bytes: 4096, Complete: true}}
+	ns.storageServiceManager = sm
+	publishReq := &csi.NodePublishVolumeRequest{
 		VolumeId:         testVolumeID,
 		TargetPath:       testTargetPath,
 		VolumeCapability: testVolumeCapability,
 	}
+	statsReq := &csi.NodeGetVolumeStatsRequest{VolumeId: testVolumeID, VolumePath: testTargetPath}
 
-	cases := []struct {
-		name                          string
-		hostNetworkEnabledOnPod       bool
-		workloadIdentityEnabledOnNode bool
-		expectErr                     error
-	}{
-		{
-			name:                          "workload identity is enabled on node + pod using hostnetwork",
-			hostNetworkEnabledOnPod:       true,
-			workloadIdentityEnabledOnNode: true,
-		},
-		{
-			name:                          "workload identity is not enabled on node + pod is not using hostnetwork, expecting error",
-			hostNetworkEnabledOnPod:       false,
-			workloadIdentityEnabledOnNode: false,
-			expectErr:                     status.Errorf(codes.FailedPrecondition, "Workload Identity Federation is not enabled on node. Please make sure this is enabled on both cluster and node pool level (https://cloud.google.com/kubernetes-engine/docs/how-to/workload-identity)"),
-		},
-		{
-			name:                          "testcase3",
-			hostNetworkEnabledOnPod:       false,
-			workloadIdentityEnabledOnNode: true,
-		},
-		{
-			name:                          "testcase4",
-			hostNetworkEnabledOnPod:       true,
-			workloadIdentityEnabledOnNode: false,
-			// TODO: confirm if this case needs to throw an error (if hostnetwork requires node to have GKE Metadata server enabled) once hostnetwork feature is available for testing
-		},
+	// republish runs the NodePublishVolume call that kubelet makes periodically, bypassing the volume usage interval.
+	republish := func() {
+		t.Helper()
+		if vs, ok := ns.volumeStateStore.Load(testTargetPath); ok {
+			vs.UsageCountedAt = time.Time{}
+		}
+		if _, err := ns.NodePublishVolume(context.TODO(), publishReq); err != nil {
+			t.Fatalf("NodePublishVolume failed: %v", err)
+		}
+		// The objects are counted in the background.
+		vs, _ := ns.volumeStateStore.Load(testTargetPath)
+		for vs.CountingUsage() {
+			time.Sleep(10 * time.Millisecond)
+		}
 	}
-	for _, test := range cases {
-		fakeClientSet := &clientset.FakeClientset{}
-		fakeClientSet.CreateNode( /* workloadIdentityEnabled */ test.workloadIdentityEnabledOnNode)
-		fakeClientSet.CreatePod( /* hostNetworkEnabled */ test.hostNetworkEnabledOnPod)
-		testEnv := initTestNodeServerWithCustomClientset(t, fakeClientSet)
-
-		_, err = testEnv.ns.NodePublishVolume(context.TODO(), req)
-		if test.expectErr == nil && err != nil {
-			t.Errorf("test %q failed:\ngot error %q,\nexpected error nil", test.name, err)
+	expectUsage := func(usedBytes, usedInodes int64) {
+		t.Helper()
+		resp, err := ns.NodeGetVolumeStats(context.TODO(), statsReq)
+		if err != nil {
+			t.Fatalf("NodeGetVolumeStats failed: %v", err)
 		}
-		if test.expectErr != nil && !errors.Is(err, test.expectErr) {
-			t.Errorf("test %q failed:\ngot error %q,\nexpected error %q", test.name, err, test.expectErr)
+		expected := []*csi.VolumeUsage{
+			{Unit: csi.VolumeUsage_BYTES, Used: usedBytes},
+			{Unit: csi.VolumeUsage_INODES, Used: usedInodes},
+		}
+		if diff := cmp.Diff(resp.GetUsage(), expected, cmpopts.IgnoreUnexported(csi.VolumeUsage{})); diff != "" {
+			t.Errorf("unexpected volume usage (-got, +want)\n%s", diff)
 		}
 	}
 
+	// The volume is published before the objects are counted.
+	if _, err := ns.NodePublishVolume(context.TODO(), publishReq); err != nil {
+		t.Fatalf("NodePublishVolume failed: %v", err)
+	}
+	expectUsage(0, 0)
+
+	republish()
+	expectUsage(4096, 3)
+
+	// The objects are not counted again within the interval.
+	sm.usage = storage.ObjectUsage{Objects: 5, Bytes: 8192}
+	if _, err := ns.NodePublishVolume(context.TODO(), publishReq); err != nil {
+		t.Fatalf("NodePublishVolume failed: %v", err)
+	}
+	expectUsage(4096, 3)
+
+	// A bucket with more objects than the maximum reports the usage of the counted objects.
+	republish()
+	expectUsage(8192, 5)
 }
 
 func TestNodeUnpublishVolume(t *testing.T) {
@@ -367,6 +1361,96 @@ func validateMountPoint(t *testing.T, name string, fm *mount.FakeMounter, e *mou
 	}
 }
 
+func TestRecordUnmountFlush(t *testing.T) {
+	t.Parallel()
+
+	published := &util.VolumeState{Published: true, PublishedPodNamespace: "test-namespace", PublishedPodName: "test-pod", PublishedBucketName: "test-bucket"}
+
+	testCases := []struct {
+		name            string
+		vs              *util.VolumeState
+		threshold       time.Duration
+		flushDuration   string
+		expectedFlushes []string
+		expectedEvents  []string
+	}{
+		{
+			name:          "volume not published",
+			threshold:     time.Second,
+			flushDuration: "1m0s",
+		},
+		{
+			name:      "flush duration not reported by the sidecar container",
+			vs:        published,
+			threshold: time.Second,
+		},
+		{
+			name:          "invalid flush duration",
+			vs:            published,
+			threshold:     time.Second,
+			flushDuration: "soon",
+		},
+		{
+			name:            "flush below the threshold",
+			vs:              published,
+			threshold:       time.Minute,
+			flushDuration:   "1s",
+			expectedFlushes: []string{"test-namespace/test-volume"},
+		},
+		{
+			name:            "flush above the threshold",
+			vs:              published,
+			threshold:       time.Second,
+			flushDuration:   "1m30s",
+			expectedFlushes: []string{"test-namespace/test-volume"},
+			expectedEvents: []string{
+				`Warning GCSFuseSlowUnmount gcsfuse took 1m30s to flush the pending writes of volume "test-volume-id" for bucket "test-bucket" after the Pod terminated, above the threshold 1s. Make sure that terminationGracePeriodSeconds leaves enough time for the flush.`,
+			},
+		},
+		{
+			name:            "events disabled",
+			vs:              published,
+			flushDuration:   "1m30s",
+			expectedFlushes: []string{"test-namespace/test-volume"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			targetPath := filepath.Join(t.TempDir(), "/var/lib/kubelet/pods/test-pod-id/volumes/kubernetes.io~csi/test-volume/mount")
+			emptyDirBasePath, err := util.PrepareEmptyDir(targetPath, true)
+			if err != nil {
+				t.Fatalf("failed to prepare the emptyDir: %v", err)
+			}
+			flushDurationFile := filepath.Join(emptyDirBasePath, util.FlushDurationFile)
+			if tc.flushDuration != "" {
+				if err := os.WriteFile(flushDurationFile, []byte(tc.flushDuration), 0o600); err != nil {
+					t.Fatalf("failed to write the flush duration: %v", err)
+				}
+			}
+
+			fakeClientSet := clientset.NewFakeClientset()
+			testEnv := initTestNodeServerWithCustomClientset(t, fakeClientSet)
+			ns, _ := testEnv.ns.(*nodeServer)
+			mm := &metrics.FakeMetricsManager{}
+			ns.driver.config.MetricsManager = mm
+			ns.driver.config.UnmountFlushWarningThreshold = tc.threshold
+
+			ns.recordUnmountFlush(testVolumeID, targetPath, tc.vs)
+			if diff := cmp.Diff(tc.expectedFlushes, mm.UnmountFlushes); diff != "" {
+				t.Errorf("unexpected unmount flushes (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.expectedEvents, fakeClientSet.Events); diff != "" {
+				t.Errorf("unexpected events (-want +got):\n%s", diff)
+			}
+			if _, err := os.Stat(flushDurationFile); tc.vs != nil && !os.IsNotExist(err) {
+				t.Errorf("expected the flush duration file to be removed, got %v", err)
+			}
+		})
+	}
+}
 func TestConcurrentMapWrites(t *testing.T) {
 	t.Parallel()
 	// Create a shared map for the test
@@ -395,3 +1479,193 @@ func TestConcurrentMapWrites(t *testing.T) {
 		t.Errorf("expected %d entries in the map, got %d", numWrites, sharedVSS.Size())
 	}
 }
+
+func TestEndSidecarCPUBoost(t *testing.T) {
+	t.Parallel()
+	boostAnnotations := map[string]string{
+		webhook.CPUBoostRestoreRequestAnnotation: "250m",
+		webhook.CPUBoostRestoreLimitAnnotation:   "250m",
+		webhook.CPUBoostDurationAnnotation:       "1m",
+	}
+
+	cases := []struct {
+		name              string
+		annotations       map[string]string
+		cpuRequest        string
+		startedAgo        time.Duration
+		disabled          bool
+		expectedResources *corev1.ResourceRequirements
+	}{
+		{
+			name:       "no CPU boost",
+			cpuRequest: "250m",
+			startedAgo: time.Hour,
+		},
+		{
+			name:        "CPU boost window has not ended",
+			annotations: boostAnnotations,
+			cpuRequest:  "2",
+			startedAgo:  time.Second,
+		},
+		{
+			name:        "CPU boost window has ended",
+			annotations: boostAnnotations,
+			cpuRequest:  "2",
+			startedAgo:  time.Hour,
+			expectedResources: &corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m")},
+				Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m")},
+			},
+		},
+		{
+			name:        "ending the CPU boost is disabled",
+			annotations: boostAnnotations,
+			cpuRequest:  "2",
+			startedAgo:  time.Hour,
+			disabled:    true,
+		},
+		{
+			name:        "CPU boost already ended",
+			annotations: boostAnnotations,
+			cpuRequest:  "250m",
+			startedAgo:  time.Hour,
+		},
+	}
+
+	for _, test := range cases {
+		fakeClientSet := clientset.NewFakeClientset()
+		testEnv := initTestNodeServerWithCustomClientset(t, fakeClientSet)
+		ns, _ := testEnv.ns.(*nodeServer)
+		ns.driver.config.EndSidecarCPUBoost = !test.disabled
+
+		sidecar := webhook.GetSidecarContainerSpec(webhook.FakeConfig())
+		sidecar.Resources.Requests[corev1.ResourceCPU] = resource.MustParse(test.cpuRequest)
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "test-ns", Annotations: test.annotations},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{sidecar}},
+			Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{
+					{
+						Name: webhook.GcsFuseSidecarName,
+						State: corev1.ContainerState{
+							Running: &corev1.ContainerStateRunning{StartedAt: metav1.NewTime(time.Now().Add(-test.startedAgo))},
+						},
+					},
+				},
+			},
+		}
+
+		ns.endSidecarCPUBoost(context.TODO(), pod, false)
+
+		resources, resized := fakeClientSet.ResizedContainers[webhook.GcsFuseSidecarName]
+		if test.expectedResources == nil {
+			if resized {
+				t.Errorf("test %q failed: got resized resources %+v, expected no resize", test.name, resources)
+			}
+
+			continue
+		}
+		if diff := cmp.Diff(resources, *test.expectedResources); diff != "" {
+			t.Errorf("test %q failed: unexpected resources (-got, +want)\n%s", test.name, diff)
+		}
+	}
+}
+
+func TestNodePublishVolumeIdempotency(t *testing.T) {
+	t.Parallel()
+	defaultPerm := os.FileMode(0o750) + os.ModeDir
+	// Setup mount target path
+	tmpDir := "/tmp/var/lib/kubelet/pods/test-pod-id/volumes/kubernetes.io~csi/"
+	if err := os.MkdirAll(tmpDir, defaultPerm); err != nil {
+		t.Fatalf("failed to setup tmp dir path: %v", err)
+	}
+	base, err := os.MkdirTemp(tmpDir, "node-publish-")
+	if err != nil {
+		t.Fatalf("failed to setup testdir: %v", err)
+	}
+	testTargetPath := filepath.Join(base, "mount")
+	if err = os.MkdirAll(testTargetPath, defaultPerm); err != nil {
+		t.Fatalf("failed to setup target path: %v", err)
+	}
+	defer os.RemoveAll(base)
+
+	newRequest := func(volumeID string, mountFlags []string) *csi.NodePublishVolumeRequest {
+		return &csi.NodePublishVolumeRequest{
+			VolumeId:   volumeID,
+			TargetPath: testTargetPath,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{
+						MountFlags: mountFlags,
+					},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			},
+		}
+	}
+
+	cases := []struct {
+		name        string
+		endpointURL string
+		req         *csi.NodePublishVolumeRequest
+		expectErr   error
+		// expectEvents is the number of GCSFuseMountOptionsChanged events after the request is sent twice.
+		expectEvents int
+	}{
+		{
+			name: "identical request",
+			req:  newRequest(testVolumeID, []string{"implicit-dirs", "only-dir=data"}),
+		},
+		{
+			name:        "identical request with options added by the driver",
+			endpointURL: "https://restricted.googleapis.com/storage/v1/",
+			req:         newRequest(testVolumeID, []string{"implicit-dirs", "only-dir=data"}),
+		},
+		{
+			name:         "different mount options",
+			req:          newRequest(testVolumeID, []string{"implicit-dirs", "only-dir=other"}),
+			expectEvents: 1,
+		},
+		{
+			name:      "different bucket",
+			req:       newRequest("other-bucket", []string{"implicit-dirs", "only-dir=data"}),
+			expectErr: status.Error(codes.AlreadyExists, `volume "other-bucket" is already published to target path "`+testTargetPath+`": published with bucket "test-volume-id", requested bucket "other-bucket"`),
+		},
+	}
+
+	for _, test := range cases {
+		fakeClientSet := clientset.NewFakeClientset()
+		testEnv := initTestNodeServerWithCustomClientset(t, fakeClientSet)
+		ns, _ := testEnv.ns.(*nodeServer)
+		ns.driver.config.StorageEndpointURL = test.endpointURL
+		if _, err := testEnv.ns.NodePublishVolume(context.TODO(), newRequest(testVolumeID, []string{"implicit-dirs", "only-dir=data"})); err != nil {
+			t.Fatalf("test %q failed: initial NodePublishVolume failed: %v", test.name, err)
+		}
+
+		for range 2 {
+			_, err := testEnv.ns.NodePublishVolume(context.TODO(), test.req)
+			if test.expectErr == nil && err != nil {
+				t.Errorf("test %q failed:\ngot error %q,\nexpected error nil", test.name, err)
+			}
+			if test.expectErr != nil && !errors.Is(err, test.expectErr) {
+				t.Errorf("test %q failed:\ngot error %q,\nexpected error %q", test.name, err, test.expectErr)
+			}
+		}
+		events := 0
+		for _, e := range fakeClientSet.Events {
+			if strings.Contains(e, eventReasonMountOptionsChanged) {
+				events++
+			}
+		}
+		if events != test.expectEvents {
+			t.Errorf("test %q failed: got events %q, expected %d %v events", test.name, fakeClientSet.Events, test.expectEvents, eventReasonMountOptionsChanged)
+		}
+		expectedOpts := []string{"implicit-dirs", "only-dir=data"}
+		if test.endpointURL != "" {
+			expectedOpts = append(expectedOpts, customEndpointFlag+"="+test.endpointURL)
+		}
+		validateMountPoint(t, test.name, testEnv.fm, &mount.MountPoint{Device: testVolumeID, Path: testTargetPath, Type: "fuse", Opts: expectedOpts})
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"time"
+
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/storage"
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	eventReasonSeedCopied     = "GCSFuseSeedCopied"
+	eventReasonSeedCopyFailed = "GCSFuseSeedCopyFailed"
+
+	// seedCopyTimeout bounds the background copy of the seed objects, including its retries.
+	seedCopyTimeout = 6 * time.Hour
+)
+
+// seedCopyBackoff retries a failed copy, which resumes where the previous attempt stopped.
+var seedCopyBackoff = wait.Backoff{Duration: 30 * time.Second, Factor: 2, Steps: 5, Cap: 10 * time.Minute}
+
+// startSeedCopy copies the objects of the seed to the provisioned bucket in the background, so that CreateVolume
+// does not wait for large datasets, and records an event on the PVC being provisioned when the copy completes or fails.
+// Only one copy runs for each bucket, so the retries of CreateVolume do not start another copy while one is in flight.
+func (s *controllerServer) startSeedCopy(parameters, secrets map[string]string, bucket *storage.ServiceBucket, seed *seedSource) {
+	s.seedCopiesMux.Lock()
+	defer s.seedCopiesMux.Unlock()
+	if s.seedCopies[bucket.Name] {
+		klog.V(4).Infof("Objects of seed bucket %q are already being copied to bucket %q", seed.BucketName, bucket.Name)
+
+		return
+	}
+	s.seedCopies[bucket.Name] = true
+
+	go func() {
+		defer func() {
+			s.seedCopiesMux.Lock()
+			defer s.seedCopiesMux.Unlock()
+			delete(s.seedCopies, bucket.Name)
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), seedCopyTimeout)
+		defer cancel()
+
+		var copyErr error
+		err := wait.ExponentialBackoffWithContext(ctx, seedCopyBackoff, func(ctx context.Context) (bool, error) {
+			if copyErr = s.copySeed(ctx, secrets, bucket, seed); copyErr != nil {
+				klog.Warningf("failed to populate bucket %q from seed bucket %q, retrying: %v", bucket.Name, seed.BucketName, copyErr)
+
+				return false, nil
+			}
+
+			return true, nil
+		})
+		if err != nil {
+			if copyErr == nil {
+				copyErr = err
+			}
+			klog.Errorf("failed to populate bucket %q from seed bucket %q: %v", bucket.Name, seed.BucketName, copyErr)
+			s.recordSeedEvent(parameters, corev1.EventTypeWarning, eventReasonSeedCopyFailed,
+				"Failed to copy the objects of seed bucket %q to bucket %q, the volume holds part of the objects: %v", seed.BucketName, bucket.Name, copyErr)
+
+			return
+		}
+		s.recordSeedEvent(parameters, corev1.EventTypeNormal, eventReasonSeedCopied,
+			"Copied the objects of seed bucket %q to bucket %q", seed.BucketName, bucket.Name)
+	}()
+}
+
+// copySeed copies the objects of the seed to the bucket with a storage service of its own, because the storage service
+// of CreateVolume is closed when the call returns.
+func (s *controllerServer) copySeed(ctx context.Context, secrets map[string]string, bucket *storage.ServiceBucket, seed *seedSource) error {
+	storageService, err := s.prepareStorageService(ctx, secrets)
+	if err != nil {
+		return err
+	}
+	defer storageService.Close()
+
+	return storageService.CopyObjects(ctx, &storage.ServiceBucket{Name: seed.BucketName}, bucket, seed.ObjectPrefix)
+}
+
+// seedCopyInFlight returns true if the objects of a seed are being copied to the bucket.
+func (s *controllerServer) seedCopyInFlight(bucketName string) bool {
+	s.seedCopiesMux.Lock()
+	defer s.seedCopiesMux.Unlock()
+
+	return s.seedCopies[bucketName]
+}
+
+// recordSeedEvent records an event on the PVC being provisioned, identified by the parameters that the external-provisioner
+// adds when running with --extra-create-metadata. The PVC is looked up even when the copy timed out.
+func (s *controllerServer) recordSeedEvent(parameters map[string]string, eventType, reason, messageFmt string, args ...interface{}) {
+	pvcName, pvcNamespace := parameters[ParameterKeyPVCName], parameters[ParameterKeyPVCNamespace]
+	if pvcName == "" || pvcNamespace == "" || s.driver.config.K8sClients == nil {
+		return
+	}
+
+	pvc, err := s.driver.config.K8sClients.GetPVC(context.Background(), pvcNamespace, pvcName)
+	if err != nil {
+		klog.Warningf("failed to get PVC %s/%s to record the seed copy event: %v", pvcNamespace, pvcName, err)
+
+		return
+	}
+	s.driver.config.K8sClients.Eventf(pvc, eventType, reason, messageFmt, args...)
+}
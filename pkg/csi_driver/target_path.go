@@ -0,0 +1,102 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// TargetPathDataPolicyFail fails the mount of a volume whose target path contains data.
+	TargetPathDataPolicyFail = "Fail"
+	// TargetPathDataPolicyClean removes the data from the target path before the volume is mounted.
+	TargetPathDataPolicyClean = "Clean"
+	// TargetPathDataPolicyMountOver mounts the volume over the data, which the mount hides until the volume is unmounted.
+	TargetPathDataPolicyMountOver = "MountOver"
+
+	eventReasonTargetPathData = "GCSFuseTargetPathNotEmpty"
+
+	// maxTargetPathDataNames is how many of the entries in the target path are listed in the errors and events.
+	maxTargetPathDataNames = 5
+)
+
+// checkTargetPathData applies the target path data policy of the driver to the data left in the target path of a volume
+// that is not mounted yet, for example by a previous unmount whose cleanup failed, or by a container that wrote to the
+// target path while the volume was not mounted.
+func (s *nodeServer) checkTargetPathData(pod *corev1.Pod, volumeID, targetPath string) error {
+	entries, err := os.ReadDir(targetPath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to read target path %q: %v", targetPath, err)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, maxTargetPathDataNames)
+	for _, e := range entries[:min(len(entries), maxTargetPathDataNames)] {
+		names = append(names, e.Name())
+	}
+	found := fmt.Sprintf("target path %q of volume %q contains %d entries, including %q", targetPath, volumeID, len(entries), strings.Join(names, ", "))
+
+	switch s.driver.config.TargetPathDataPolicy {
+	case TargetPathDataPolicyFail:
+		s.k8sClients.Eventf(pod, corev1.EventTypeWarning, eventReasonTargetPathData, "Volume is not mounted, because the %s. Remove the data from the target path on the node, or delete the Pod.", found)
+
+		return status.Errorf(codes.FailedPrecondition, "the %s", found)
+	case TargetPathDataPolicyClean:
+		if err := s.cleanTargetPath(targetPath, entries); err != nil {
+			return status.Errorf(codes.Internal, "failed to clean the %s: %v", found, err)
+		}
+		klog.Warningf("Removed the data of the %s before mounting the volume", found)
+		s.k8sClients.Eventf(pod, corev1.EventTypeWarning, eventReasonTargetPathData, "Removed the data left in the target path before mounting the volume: the %s.", found)
+	default:
+		s.k8sClients.Eventf(pod, corev1.EventTypeWarning, eventReasonTargetPathData, "Volume is mounted over the data left in the target path, which is hidden until the volume is unmounted: the %s.", found)
+	}
+
+	return nil
+}
+
+// cleanTargetPath removes the entries of the target path. It refuses to clean a target path with a mount below it,
+// so that the data of another file system is never removed.
+func (s *nodeServer) cleanTargetPath(targetPath string, entries []os.DirEntry) error {
+	mps, err := s.mounter.List()
+	if err != nil {
+		return fmt.Errorf("failed to list the mounts: %w", err)
+	}
+	for _, m := range mps {
+		if strings.HasPrefix(m.Path, targetPath+"/") {
+			return fmt.Errorf("%q is mounted below the target path", m.Path)
+		}
+	}
+
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(targetPath, e.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
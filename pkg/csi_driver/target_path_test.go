@@ -0,0 +1,133 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/clientset"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	mount "k8s.io/mount-utils"
+)
+
+func TestCheckTargetPathData(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		policy        string
+		leftovers     []string
+		mounts        []string
+		expectCode    codes.Code
+		expectEvent   string
+		expectCleaned bool
+	}{
+		{
+			name:       "empty target path",
+			policy:     TargetPathDataPolicyFail,
+			expectCode: codes.OK,
+		},
+		{
+			name:        "fail",
+			policy:      TargetPathDataPolicyFail,
+			leftovers:   []string{"data.csv", "dir/model.bin"},
+			expectCode:  codes.FailedPrecondition,
+			expectEvent: "Warning GCSFuseTargetPathNotEmpty Volume is not mounted",
+		},
+		{
+			name:          "clean",
+			policy:        TargetPathDataPolicyClean,
+			leftovers:     []string{"data.csv", "dir/model.bin"},
+			expectCode:    codes.OK,
+			expectEvent:   "Warning GCSFuseTargetPathNotEmpty Removed the data",
+			expectCleaned: true,
+		},
+		{
+			name:       "clean with a mount below the target path",
+			policy:     TargetPathDataPolicyClean,
+			leftovers:  []string{"dir/model.bin"},
+			mounts:     []string{"dir"},
+			expectCode: codes.Internal,
+		},
+		{
+			name:        "mount over",
+			policy:      TargetPathDataPolicyMountOver,
+			leftovers:   []string{"data.csv"},
+			expectCode:  codes.OK,
+			expectEvent: "Warning GCSFuseTargetPathNotEmpty Volume is mounted over the data",
+		},
+		{
+			name:        "default policy mounts over",
+			leftovers:   []string{"data.csv"},
+			expectCode:  codes.OK,
+			expectEvent: "Warning GCSFuseTargetPathNotEmpty Volume is mounted over the data",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			targetPath := t.TempDir()
+			for _, name := range tc.leftovers {
+				p := filepath.Join(targetPath, name)
+				if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+					t.Fatalf("failed to create the directory of %q: %v", p, err)
+				}
+				if err := os.WriteFile(p, []byte("leftover"), 0o600); err != nil {
+					t.Fatalf("failed to write %q: %v", p, err)
+				}
+			}
+
+			fakeClientset := clientset.NewFakeClientset()
+			testEnv := initTestNodeServerWithCustomClientset(t, fakeClientset)
+			ns, _ := testEnv.ns.(*nodeServer)
+			ns.driver.config.TargetPathDataPolicy = tc.policy
+			for _, m := range tc.mounts {
+				testEnv.fm.MountPoints = append(testEnv.fm.MountPoints, mount.MountPoint{Path: filepath.Join(targetPath, m)})
+			}
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "test-ns"}}
+
+			err := ns.checkTargetPathData(pod, "test-volume", targetPath)
+			if code := status.Code(err); code != tc.expectCode {
+				t.Fatalf("got error %v, want code %v", err, tc.expectCode)
+			}
+
+			switch {
+			case tc.expectEvent == "" && len(fakeClientset.Events) != 0:
+				t.Errorf("got events %q, want none", fakeClientset.Events)
+			case tc.expectEvent != "" && (len(fakeClientset.Events) != 1 || !strings.HasPrefix(fakeClientset.Events[0], tc.expectEvent)):
+				t.Errorf("got events %q, want one starting with %q", fakeClientset.Events, tc.expectEvent)
+			}
+
+			entries, err := os.ReadDir(targetPath)
+			if err != nil {
+				t.Fatalf("failed to read the target path: %v", err)
+			}
+			if cleaned := len(entries) == 0; len(tc.leftovers) > 0 && cleaned != tc.expectCleaned {
+				t.Errorf("got target path cleaned %v, want %v", cleaned, tc.expectCleaned)
+			}
+		})
+	}
+}
@@ -23,11 +23,14 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"unicode"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/volumespec"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/webhook"
 	pbSanitizer "github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
 	"golang.org/x/mod/semver"
@@ -41,19 +44,44 @@ import (
 )
 
 const (
+	eventReasonGcsFuseMountOptions = "GCSFuseMountOptions"
+	eventReasonImplicitDirsFound   = "GCSFuseImplicitDirsFound"
+	eventReasonHierarchicalNS      = "GCSFuseHierarchicalNamespace"
+	eventReasonSidecarTooOld       = "GCSFuseSidecarTooOld"
+	eventReasonMultiWriterOptions  = "GCSFuseUnsafeMultiWriterMountOptions"
+	eventReasonMountOptionsChanged = "GCSFuseMountOptionsChanged"
+
 	CreateVolumeCSIFullMethod      = "/csi.v1.Controller/CreateVolume"
 	DeleteVolumeCSIFullMethod      = "/csi.v1.Controller/DeleteVolume"
 	NodePublishVolumeCSIFullMethod = "/csi.v1.Node/NodePublishVolume"
 
-	VolumeContextKeyMountOptions              = "mountOptions"
-	VolumeContextKeyFileCacheCapacity         = "fileCacheCapacity"
-	VolumeContextKeyFileCacheForRangeRead     = "fileCacheForRangeRead"
-	VolumeContextKeyMetadataStatCacheCapacity = "metadataStatCacheCapacity"
-	VolumeContextKeyMetadataTypeCacheCapacity = "metadataTypeCacheCapacity"
-	VolumeContextKeyMetadataCacheTTLSeconds   = "metadataCacheTTLSeconds"
-	VolumeContextKeyGcsfuseLoggingSeverity    = "gcsfuseLoggingSeverity"
-	VolumeContextKeySkipCSIBucketAccessCheck  = "skipCSIBucketAccessCheck"
-	VolumeContextKeyDisableMetrics            = "disableMetrics"
+	VolumeContextKeyMountOptions               = volumespec.AttributeMountOptions
+	VolumeContextKeyFileCacheCapacity          = volumespec.AttributeFileCacheCapacity
+	VolumeContextKeyFileCacheForRangeRead      = volumespec.AttributeFileCacheForRangeRead
+	VolumeContextKeyFileCacheParallelDownloads = volumespec.AttributeFileCacheParallelDownloads
+	VolumeContextKeyMetadataStatCacheCapacity  = volumespec.AttributeMetadataStatCacheCapacity
+	VolumeContextKeyMetadataTypeCacheCapacity  = volumespec.AttributeMetadataTypeCacheCapacity
+	VolumeContextKeyMetadataCacheTTLSeconds    = volumespec.AttributeMetadataCacheTTLSeconds
+	VolumeContextKeyGcsfuseLoggingSeverity     = volumespec.AttributeGcsfuseLoggingSeverity
+	VolumeContextKeySkipCSIBucketAccessCheck   = volumespec.AttributeSkipCSIBucketAccessCheck
+	VolumeContextKeyDisableMetrics             = volumespec.AttributeDisableMetrics
+	VolumeContextKeyGcsfuseExperimentalFlags   = volumespec.AttributeGcsfuseExperimentalFlags
+	VolumeContextKeyImplicitDirsAutoDetect     = volumespec.AttributeImplicitDirsAutoDetect
+	VolumeContextKeyVerifyReadOnMount          = volumespec.AttributeVerifyReadOnMount
+	VolumeContextKeyVerifyReadObject           = volumespec.AttributeVerifyReadObject
+	VolumeContextKeyHierarchicalNamespace      = volumespec.AttributeHierarchicalNamespace
+	VolumeContextKeyMaxConnsPerHost            = volumespec.AttributeMaxConnsPerHost
+	VolumeContextKeyMaxIdleConnsPerHost        = volumespec.AttributeMaxIdleConnsPerHost
+	VolumeContextKeyClientProtocol             = volumespec.AttributeClientProtocol
+	VolumeContextKeyFileMode                   = volumespec.AttributeFileMode
+	VolumeContextKeyDirMode                    = volumespec.AttributeDirMode
+	VolumeContextKeyKernelListCacheTTLSeconds  = volumespec.AttributeKernelListCacheTTLSeconds
+	VolumeContextKeyTokenRefreshSeconds        = volumespec.AttributeTokenRefreshSeconds
+	VolumeContextKeyGCPServiceAccount          = volumespec.AttributeGCPServiceAccount
+	VolumeContextKeyWorkloadRecommendations    = volumespec.AttributeWorkloadRecommendations
+	// The deletion protection volume attributes are set by CreateVolume, and read by DeleteVolume.
+	VolumeContextKeyPreventDestroyNonEmpty   = volumespec.AttributePreventDestroyNonEmpty
+	VolumeContextKeyPreventDestroyMaxObjects = volumespec.AttributePreventDestroyMaxObjects
 
 	//nolint:revive,stylecheck
 	VolumeContextKeyMetadataCacheTtlSeconds = "metadataCacheTtlSeconds"
@@ -64,8 +92,11 @@ const (
 	VolumeContextKeyPodName             = "csi.storage.k8s.io/pod.name"
 	VolumeContextKeyPodNamespace        = "csi.storage.k8s.io/pod.namespace"
 	VolumeContextKeyEphemeral           = "csi.storage.k8s.io/ephemeral"
-	VolumeContextKeyBucketName          = "bucketName"
+	VolumeContextKeyBucketName          = volumespec.AttributeBucketName
 	tokenServerSidecarMinVersion        = "v1.12.2-gke.0" // #nosec G101
+
+	// hierarchicalNamespaceAuto detects whether the bucket has hierarchical namespace enabled when the volume is mounted.
+	hierarchicalNamespaceAuto = volumespec.HierarchicalNamespaceAuto
 )
 
 var volumeIDRegEx = regexp.MustCompile(`:.*$`)
@@ -117,21 +148,39 @@ func logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, h
 		strippedReq = fmt.Sprintf("%+v", req)
 	}
 
-	klog.V(4).Infof("%s called with request: %v", info.FullMethod, strippedReq)
+	keys := volumeLogKeys(req)
+	klog.V(4).InfoS(info.FullMethod+" called", append(keys, "request", strippedReq)...)
 	resp, err := handler(ctx, req)
 	if err != nil {
-		klog.Errorf("%s failed with error: %v", info.FullMethod, err)
+		klog.ErrorS(err, info.FullMethod+" failed", keys...)
 	} else {
 		if fmt.Sprintf("%v", resp) == "" {
-			klog.V(4).Infof("%s succeeded.", info.FullMethod)
+			klog.V(4).InfoS(info.FullMethod+" succeeded", keys...)
 		} else {
-			klog.V(4).Infof("%s succeeded with response: %s", info.FullMethod, resp)
+			klog.V(4).InfoS(info.FullMethod+" succeeded", append(keys, "response", resp)...)
 		}
 	}
 
 	return resp, err
 }
 
+// volumeLogKeys returns the structured log keys of the volume and the target path of a CSI request,
+// so that the logs of all the calls for a volume can be filtered.
+func volumeLogKeys(req interface{}) []interface{} {
+	keys := []interface{}{}
+	if r, ok := req.(interface{ GetVolumeId() string }); ok && r.GetVolumeId() != "" {
+		keys = append(keys, util.LogKeyVolume, r.GetVolumeId())
+	}
+	if r, ok := req.(interface{ GetTargetPath() string }); ok && r.GetTargetPath() != "" {
+		keys = append(keys, util.LogKeyTargetPath, r.GetTargetPath())
+	}
+	if r, ok := req.(interface{ GetVolumePath() string }); ok && r.GetVolumePath() != "" {
+		keys = append(keys, util.LogKeyTargetPath, r.GetVolumePath())
+	}
+
+	return keys
+}
+
 // joinMountOptions joins mount options eliminating duplicates.
 func joinMountOptions(existingOptions []string, newOptions []string) []string {
 	overwritableOptions := map[string]string{
@@ -176,41 +225,66 @@ func joinMountOptions(existingOptions []string, newOptions []string) []string {
 }
 
 var volumeAttributesToMountOptionsMapping = map[string]string{
-	VolumeContextKeyFileCacheCapacity:         "file-cache:max-size-mb:",
-	VolumeContextKeyFileCacheForRangeRead:     "file-cache:cache-file-for-range-read:",
-	VolumeContextKeyMetadataStatCacheCapacity: "metadata-cache:stat-cache-max-size-mb:",
-	VolumeContextKeyMetadataTypeCacheCapacity: "metadata-cache:type-cache-max-size-mb:",
-	VolumeContextKeyMetadataCacheTTLSeconds:   "metadata-cache:ttl-secs:",
-	VolumeContextKeyMetadataCacheTtlSeconds:   "metadata-cache:ttl-secs:",
-	VolumeContextKeyGcsfuseLoggingSeverity:    "logging:severity:",
-	VolumeContextKeySkipCSIBucketAccessCheck:  "",
-	VolumeContextKeyDisableMetrics:            util.DisableMetricsForGKE + ":",
+	VolumeContextKeyFileCacheCapacity:          "file-cache:max-size-mb:",
+	VolumeContextKeyFileCacheForRangeRead:      "file-cache:cache-file-for-range-read:",
+	VolumeContextKeyFileCacheParallelDownloads: "file-cache:enable-parallel-downloads:",
+	VolumeContextKeyMetadataStatCacheCapacity:  "metadata-cache:stat-cache-max-size-mb:",
+	VolumeContextKeyMetadataTypeCacheCapacity:  "metadata-cache:type-cache-max-size-mb:",
+	VolumeContextKeyMetadataCacheTTLSeconds:    volumespec.MountOptionMetadataCacheTTLSeconds,
+	VolumeContextKeyMetadataCacheTtlSeconds:    volumespec.MountOptionMetadataCacheTTLSeconds,
+	VolumeContextKeyGcsfuseLoggingSeverity:     "logging:severity:",
+	VolumeContextKeySkipCSIBucketAccessCheck:   "",
+	VolumeContextKeyDisableMetrics:             util.DisableMetricsForGKE + ":",
+	VolumeContextKeyMaxConnsPerHost:            "gcs-connection:max-conns-per-host:",
+	VolumeContextKeyMaxIdleConnsPerHost:        "gcs-connection:max-idle-conns-per-host:",
+	VolumeContextKeyClientProtocol:             "gcs-connection:client-protocol:",
+	VolumeContextKeyFileMode:                   "file-mode=",
+	VolumeContextKeyDirMode:                    "dir-mode=",
+	VolumeContextKeyKernelListCacheTTLSeconds:  volumespec.MountOptionKernelListCacheTTLSeconds,
+	VolumeContextKeyTokenRefreshSeconds:        "token-server-refresh-secs=",
+	VolumeContextKeyGCPServiceAccount:          "token-server-impersonate-service-account=",
+	VolumeContextKeyWorkloadRecommendations:    util.WorkloadRecommendations + "=",
+}
+
+// validateVolumeAttributes validates the values of the volume attributes and the combinations of them with the volumespec package,
+// which the webhook and the lint command use too. The deprecated volume attributes are validated like the ones that replace them.
+func validateVolumeAttributes(volumeContext map[string]string) error {
+	for _, deprecated := range sets.List(sets.KeySet(deprecatedVolumeAttributes)) {
+		if value, ok := volumeContext[deprecated]; ok {
+			if err := volumespec.ValidateAttribute(deprecatedVolumeAttributes[deprecated], value); err != nil {
+				return fmt.Errorf("deprecated volume attribute %v: %w", deprecated, err)
+			}
+		}
+	}
+
+	return volumespec.ValidateAttributes(volumeContext)
 }
 
 // parseVolumeAttributes parses volume attributes and convert them to gcsfuse mount options.
 func parseVolumeAttributes(fuseMountOptions []string, volumeContext map[string]string) ([]string, bool, bool, error) {
+	skipCSIBucketAccessCheck := false
+	disableMetricsCollection := true
+	if err := validateVolumeAttributes(volumeContext); err != nil {
+		return nil, skipCSIBucketAccessCheck, disableMetricsCollection, err
+	}
+
 	if mountOptions, ok := volumeContext[VolumeContextKeyMountOptions]; ok {
 		fuseMountOptions = joinMountOptions(fuseMountOptions, strings.Split(mountOptions, ","))
 	}
-	skipCSIBucketAccessCheck := false
-	disableMetricsCollection := true
 	for volumeAttribute, mountOption := range volumeAttributesToMountOptionsMapping {
 		value, ok := volumeContext[volumeAttribute]
 		if !ok {
 			continue
 		}
 
+		// The values are validated above, so they are not checked again when they are converted.
 		var mountOptionWithValue string
 		switch volumeAttribute {
 		// parse Quantity volume attributes,
 		// the input value should be a valid Quantity defined in https://kubernetes.io/docs/reference/kubernetes-api/common-definitions/quantity/,
 		// convert the input to a string representation in MB.
 		case VolumeContextKeyFileCacheCapacity, VolumeContextKeyMetadataStatCacheCapacity, VolumeContextKeyMetadataTypeCacheCapacity:
-			quantity, err := resource.ParseQuantity(value)
-			if err != nil {
-				return nil, skipCSIBucketAccessCheck, disableMetricsCollection, fmt.Errorf("volume attribute %v only accepts a valid Quantity value, got %q, error: %w", volumeAttribute, value, err)
-			}
-
+			quantity := resource.MustParse(value)
 			megabytes := quantity.Value()
 			switch {
 			case megabytes < 0:
@@ -224,37 +298,44 @@ func parseVolumeAttributes(fuseMountOptions []string, volumeContext map[string]s
 			mountOptionWithValue = mountOption + value
 
 		// parse bool volume attributes
-		case VolumeContextKeyFileCacheForRangeRead, VolumeContextKeySkipCSIBucketAccessCheck, VolumeContextKeyDisableMetrics:
-			if boolVal, err := strconv.ParseBool(value); err == nil {
-				if volumeAttribute == VolumeContextKeySkipCSIBucketAccessCheck {
-					skipCSIBucketAccessCheck = boolVal
-
-					// The skipCSIBucketAccessCheck volume attribute is only for CSI driver,
-					// and there is no translation to GCSFuse mount options.
-					continue
-				}
+		case VolumeContextKeyFileCacheForRangeRead, VolumeContextKeyFileCacheParallelDownloads, VolumeContextKeySkipCSIBucketAccessCheck, VolumeContextKeyDisableMetrics, VolumeContextKeyWorkloadRecommendations:
+			boolVal, _ := strconv.ParseBool(value)
+			if volumeAttribute == VolumeContextKeySkipCSIBucketAccessCheck {
+				skipCSIBucketAccessCheck = boolVal
 
-				if volumeAttribute == VolumeContextKeyDisableMetrics {
-					disableMetricsCollection = boolVal
-				}
+				// The skipCSIBucketAccessCheck volume attribute is only for CSI driver,
+				// and there is no translation to GCSFuse mount options.
+				continue
+			}
 
-				mountOptionWithValue = mountOption + strconv.FormatBool(boolVal)
-			} else {
-				return nil, skipCSIBucketAccessCheck, disableMetricsCollection, fmt.Errorf("volume attribute %v only accepts a valid bool value, got %q", volumeAttribute, value)
+			if volumeAttribute == VolumeContextKeyDisableMetrics {
+				disableMetricsCollection = boolVal
 			}
 
-		// parse int volume attributes
-		case VolumeContextKeyMetadataCacheTTLSeconds, VolumeContextKeyMetadataCacheTtlSeconds:
-			if intVal, err := strconv.Atoi(value); err == nil {
-				if intVal < 0 {
-					intVal = -1
-				}
+			mountOptionWithValue = mountOption + strconv.FormatBool(boolVal)
 
-				mountOptionWithValue = mountOption + strconv.Itoa(intVal)
-			} else {
-				return nil, skipCSIBucketAccessCheck, disableMetricsCollection, fmt.Errorf("volume attribute %v only accepts a valid int value, got %q", volumeAttribute, value)
+		// parse int volume attributes, where negative values mean no expiration.
+		case VolumeContextKeyMetadataCacheTTLSeconds, VolumeContextKeyMetadataCacheTtlSeconds, VolumeContextKeyKernelListCacheTTLSeconds:
+			intVal, _ := strconv.Atoi(value)
+			if intVal < 0 {
+				intVal = -1
 			}
 
+			mountOptionWithValue = mountOption + strconv.Itoa(intVal)
+
+		// parse octal permission bits, which gcsfuse reports for all the files or directories of the volume.
+		case VolumeContextKeyFileMode, VolumeContextKeyDirMode:
+			mode, _ := strconv.ParseUint(value, 8, 32)
+			mountOptionWithValue = mountOption + strconv.FormatUint(mode, 8)
+
+		// parse the connection pool limits, where 0 means no limit, and the interval at which the sidecar token server
+		// makes gcsfuse fetch a new token, so that the volume picks up rotated credentials and IAM changes without a remount.
+		case VolumeContextKeyMaxConnsPerHost, VolumeContextKeyMaxIdleConnsPerHost, VolumeContextKeyTokenRefreshSeconds:
+			intVal, _ := strconv.Atoi(value)
+			mountOptionWithValue = mountOption + strconv.Itoa(intVal)
+
+		// The GCP service account that the sidecar token server impersonates for the volume,
+		// and the client protocol are passed to gcsfuse as they are.
 		default:
 			mountOptionWithValue = mountOption + value
 		}
@@ -265,6 +346,275 @@ func parseVolumeAttributes(fuseMountOptions []string, volumeContext map[string]s
 	return fuseMountOptions, skipCSIBucketAccessCheck, disableMetricsCollection, nil
 }
 
+// acceleratorCountRegEx matches the accelerator count suffix of the accelerator-optimized machine types, such as a3-highgpu-8g or ct5lp-hightpu-4t.
+var acceleratorCountRegEx = regexp.MustCompile(`^[a-z0-9]+-[a-z]+-(\d+)[gt]$`)
+
+// idleConnsPerAccelerator is the gcsfuse default of max-idle-conns-per-host, which suits a single reader.
+const idleConnsPerAccelerator = 100
+
+// connectionPoolMountOptions adds the gcsfuse connection pool mount options that suit the machine type of the node,
+// unless the volume sets them. On accelerator-optimized machines, all the accelerators read concurrently, so the default pool
+// closes the connections that exceed the idle limit between reads, and every read burst opens them again.
+func connectionPoolMountOptions(fuseMountOptions []string, machineType string) []string {
+	match := acceleratorCountRegEx.FindStringSubmatch(machineType)
+	if match == nil {
+		return fuseMountOptions
+	}
+	accelerators, err := strconv.Atoi(match[1])
+	if err != nil || accelerators <= 1 {
+		return fuseMountOptions
+	}
+
+	for _, d := range []struct {
+		volumeAttribute string
+		value           int
+	}{
+		{VolumeContextKeyMaxConnsPerHost, 0},
+		{VolumeContextKeyMaxIdleConnsPerHost, idleConnsPerAccelerator * accelerators},
+	} {
+		mountOption := volumeAttributesToMountOptionsMapping[d.volumeAttribute]
+		// The flag is also accepted in the gcsfuse command line format, such as max-conns-per-host=10.
+		flag := strings.TrimSuffix(strings.TrimPrefix(mountOption, "gcs-connection:"), ":")
+		if hasMountOption(fuseMountOptions, flag) || slices.ContainsFunc(fuseMountOptions, func(o string) bool { return strings.HasPrefix(o, mountOption) }) {
+			continue
+		}
+		fuseMountOptions = joinMountOptions(fuseMountOptions, []string{mountOption + strconv.Itoa(d.value)})
+	}
+
+	return fuseMountOptions
+}
+
+// podAnnotationsToVolumeAttributes maps the Pod annotations that set the gcsfuse defaults of all the volumes of the Pod
+// to the volume attributes that they default.
+var podAnnotationsToVolumeAttributes = map[string]string{
+	webhook.GcsfuseLoggingSeverityAnnotation:     VolumeContextKeyGcsfuseLoggingSeverity,
+	webhook.FileCacheCapacityAnnotation:          VolumeContextKeyFileCacheCapacity,
+	webhook.FileCacheParallelDownloadsAnnotation: VolumeContextKeyFileCacheParallelDownloads,
+	webhook.MetadataStatCacheCapacityAnnotation:  VolumeContextKeyMetadataStatCacheCapacity,
+	webhook.MetadataTypeCacheCapacityAnnotation:  VolumeContextKeyMetadataTypeCacheCapacity,
+}
+
+// podDefaultMountOptions adds the gcsfuse mount options that the Pod annotations set as defaults,
+// unless the volume sets them, so that tuning a Pod does not require editing its PersistentVolumes.
+func podDefaultMountOptions(fuseMountOptions []string, pod *corev1.Pod) ([]string, error) {
+	for annotation, volumeAttribute := range podAnnotationsToVolumeAttributes {
+		value, ok := pod.Annotations[annotation]
+		if !ok {
+			continue
+		}
+
+		mountOption := volumeAttributesToMountOptionsMapping[volumeAttribute]
+		if slices.ContainsFunc(fuseMountOptions, func(o string) bool { return strings.HasPrefix(o, mountOption) }) {
+			continue
+		}
+
+		options, _, _, err := parseVolumeAttributes(nil, map[string]string{volumeAttribute: value})
+		if err != nil {
+			return nil, fmt.Errorf("invalid Pod annotation %v: %w", annotation, err)
+		}
+		fuseMountOptions = joinMountOptions(fuseMountOptions, options)
+	}
+
+	return fuseMountOptions, nil
+}
+
+// parseImplicitDirsAutoDetect parses the implicitDirsAutoDetect volume attribute, which is validated by validateVolumeAttributes.
+// It returns false if the volume attribute is not set.
+func parseImplicitDirsAutoDetect(volumeContext map[string]string) bool {
+	autoDetect, _ := strconv.ParseBool(volumeContext[VolumeContextKeyImplicitDirsAutoDetect])
+
+	return autoDetect
+}
+
+// hierarchicalNamespaceMountOptions returns the mount options with the enable-hns flag, unless it is already set,
+// and without the implicit-dirs flag, which gcsfuse does not need for buckets with hierarchical namespace enabled.
+func hierarchicalNamespaceMountOptions(fuseMountOptions []string) []string {
+	options := []string{}
+	hasHNSFlag := false
+	for _, o := range fuseMountOptions {
+		if o == implicitDirsFlag || strings.HasPrefix(o, implicitDirsFlag+"=") {
+			continue
+		}
+		if o == enableHNSFlag || strings.HasPrefix(o, enableHNSFlag+"=") {
+			hasHNSFlag = true
+		}
+		options = append(options, o)
+	}
+
+	if !hasHNSFlag {
+		options = append(options, enableHNSFlag)
+	}
+
+	return options
+}
+
+// parseHierarchicalNamespace parses the hierarchicalNamespace volume attribute, which is validated by validateVolumeAttributes.
+// It returns whether the bucket has hierarchical namespace enabled, and whether it is detected when the volume is mounted instead.
+// It returns false for both if the volume attribute is not set.
+func parseHierarchicalNamespace(volumeContext map[string]string) (bool, bool) {
+	value := volumeContext[VolumeContextKeyHierarchicalNamespace]
+	if value == hierarchicalNamespaceAuto {
+		return false, true
+	}
+	enabled, _ := strconv.ParseBool(value)
+
+	return enabled, false
+}
+
+// parseVerifyReadOnMount parses the verifyReadOnMount and verifyReadObject volume attributes, which are validated by validateVolumeAttributes.
+// It returns whether the bucket is read before the volume is mounted, and the object to read, which is empty to list the bucket instead.
+func parseVerifyReadOnMount(volumeContext map[string]string) (bool, string) {
+	verify, _ := strconv.ParseBool(volumeContext[VolumeContextKeyVerifyReadOnMount])
+	if !verify {
+		return false, ""
+	}
+
+	return true, volumeContext[VolumeContextKeyVerifyReadObject]
+}
+
+// parseExperimentalFlags parses the gcsfuseExperimentalFlags volume attribute and converts it to gcsfuse mount options.
+// The value is a comma-separated list of gcsfuse flags, in the same format as the mountOptions volume attribute.
+// Every flag must be on the allowlist configured by the cluster admin, so experimental flags are rejected by default.
+func parseExperimentalFlags(volumeContext map[string]string, allowlist sets.Set[string]) ([]string, error) {
+	flags := []string{}
+	for _, f := range strings.Split(volumeContext[VolumeContextKeyGcsfuseExperimentalFlags], ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+
+		name := experimentalFlagName(f)
+		if !allowlist.Has(name) {
+			return nil, fmt.Errorf("volume attribute %v got gcsfuse flag %q that is not allowed by the cluster admin, allowed flags: %v", VolumeContextKeyGcsfuseExperimentalFlags, name, sets.List(allowlist))
+		}
+
+		flags = append(flags, f)
+	}
+
+	return flags, nil
+}
+
+// experimentalFlagName returns the gcsfuse flag name of a mount option.
+// CLI flags use the "name=value" format, where the value can contain colons. Config file flags use the
+// "section:key:value" format with any number of sections, where the value can be a URL.
+func experimentalFlagName(mountOption string) string {
+	name, _, _ := strings.Cut(mountOption, "=")
+	if !strings.Contains(name, ":") {
+		return name
+	}
+
+	key := mountOption
+	if i := strings.Index(key, "://"); i >= 0 {
+		key = key[:i]
+	}
+	if i := strings.LastIndex(key, ":"); i >= 0 {
+		return key[:i]
+	}
+
+	return key
+}
+
+// multiWriterMountOptionWarnings returns warnings for the mount options that are known to lose data
+// when a writable volume is published with a multi-writer access mode. The options are still allowed,
+// because they are safe when the writers do not modify the same objects.
+func multiWriterMountOptionWarnings(accessMode csi.VolumeCapability_AccessMode_Mode, fuseMountOptions []string) []string {
+	if accessMode != csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER && accessMode != csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER {
+		return nil
+	}
+
+	if slices.Contains(fuseMountOptions, "ro") {
+		return nil
+	}
+
+	warnings := []string{}
+	for _, o := range fuseMountOptions {
+		if reason, ok := volumespec.MultiWriterUnsafeMountOption(o); ok {
+			warnings = append(warnings, fmt.Sprintf("mount option %q with access mode %v can lose data when multiple writers modify the same objects: %s", o, accessMode, reason))
+		}
+	}
+
+	return warnings
+}
+
+// comparePublishedVolume returns an error if the requested bucket name differs from the one the volume was published with.
+// The bucket of a PersistentVolume cannot be changed, so a different bucket means another volume uses the target path.
+func comparePublishedVolume(vs *util.VolumeState, bucketName string) error {
+	if vs.PublishedBucketName != bucketName {
+		return fmt.Errorf("published with bucket %q, requested bucket %q", vs.PublishedBucketName, bucketName)
+	}
+
+	return nil
+}
+
+// mountOptionsChange describes how the requested mount options differ from the ones the volume was published with,
+// or returns an empty string if they are the same. The mount options of a PersistentVolume can be edited after it is mounted.
+func mountOptionsChange(vs *util.VolumeState, fuseMountOptions []string) string {
+	published := sets.NewString(vs.RequestedMountOptions...)
+	requested := sets.NewString(fuseMountOptions...)
+	if published.Equal(requested) {
+		return ""
+	}
+
+	return fmt.Sprintf("added %q, removed %q", requested.Difference(published).List(), published.Difference(requested).List())
+}
+
+// onlyDirPrefix returns the object prefix of the only-dir mount option, or an empty string if the whole bucket is mounted.
+func onlyDirPrefix(fuseMountOptions []string) string {
+	for _, o := range fuseMountOptions {
+		if dir, ok := strings.CutPrefix(o, "only-dir="); ok && strings.Trim(dir, "/") != "" {
+			return strings.Trim(dir, "/") + "/"
+		}
+	}
+
+	return ""
+}
+
+// validateOnlyDir returns an error if the only-dir mount option is not a relative object prefix within the bucket.
+// gcsfuse resolves the option against the bucket root, so a parent or absolute path would mount other objects than the
+// volume was provisioned with, which breaks the isolation of the volumes that share a bucket.
+func validateOnlyDir(fuseMountOptions []string) error {
+	dirs := sets.NewString()
+	for _, o := range fuseMountOptions {
+		dir, ok := strings.CutPrefix(o, "only-dir=")
+		if !ok {
+			continue
+		}
+		dirs.Insert(dir)
+
+		if strings.HasPrefix(dir, "/") {
+			return fmt.Errorf("mount option only-dir only accepts a relative path, got %q", dir)
+		}
+		if strings.ContainsFunc(dir, unicode.IsControl) {
+			return fmt.Errorf("mount option only-dir cannot contain control characters, got %q", dir)
+		}
+		for _, element := range strings.Split(dir, "/") {
+			if element == "." || element == ".." {
+				return fmt.Errorf("mount option only-dir cannot contain %q path elements, got %q", element, dir)
+			}
+		}
+	}
+	if dirs.Len() > 1 {
+		return fmt.Errorf("mount option only-dir is set more than once, got %q", dirs.List())
+	}
+
+	return nil
+}
+
+// billingProject returns the project billed for the requests to a requester pays bucket,
+// set by either the billing-project flag or the gcs-connection:billing-project config of gcsfuse.
+func billingProject(fuseMountOptions []string) string {
+	for _, o := range fuseMountOptions {
+		if project, ok := strings.CutPrefix(o, "billing-project="); ok {
+			return project
+		}
+		if project, ok := strings.CutPrefix(o, "gcs-connection:billing-project:"); ok {
+			return project
+		}
+	}
+
+	return ""
+}
+
 // parseRequestArguments parses arguments from given NodePublishVolumeRequest.
 func parseRequestArguments(req *csi.NodePublishVolumeRequest) (string, string, []string, bool, bool, error) {
 	targetPath := req.GetTargetPath()
@@ -300,6 +650,14 @@ func parseRequestArguments(req *csi.NodePublishVolumeRequest) (string, string, [
 		return "", "", nil, false, false, err
 	}
 
+	if err := validateOnlyDir(fuseMountOptions); err != nil {
+		return "", "", nil, false, false, err
+	}
+
+	if err := volumespec.ValidateBucketAttributes(bucketName, vc); err != nil {
+		return "", "", nil, false, false, err
+	}
+
 	return targetPath, bucketName, fuseMountOptions, skipCSIBucketAccessCheck, enableMetricsCollection, nil
 }
 
@@ -310,6 +668,34 @@ func parseVolumeID(bucketHandle string) string {
 	return volumeIDRegEx.ReplaceAllString(bucketHandle, "")
 }
 
+// prefixVolumeID returns the ID of a volume provisioned as an object prefix in a shared bucket.
+// parseVolumeID returns the bucket name of the ID, so the volume is mounted like a static PersistentVolume.
+func prefixVolumeID(bucketName, prefix string) string {
+	return bucketName + ":" + prefix
+}
+
+// parsePrefixVolumeID returns the bucket name and the object prefix of a volume provisioned in a shared bucket.
+func parsePrefixVolumeID(volumeID string) (string, string, bool) {
+	bucketName, prefix, ok := strings.Cut(volumeID, ":")
+	if !ok || bucketName == "" || !strings.HasSuffix(prefix, "/") || strings.Trim(prefix, "/") == "" {
+		return "", "", false
+	}
+
+	return bucketName, prefix, true
+}
+
+// persistentVolumeMountOptions returns the gcsfuse mount options of a PersistentVolume.
+func persistentVolumeMountOptions(pv *corev1.PersistentVolume) []string {
+	options := pv.Spec.MountOptions
+	if pv.Spec.CSI != nil {
+		if mountOptions, ok := pv.Spec.CSI.VolumeAttributes[VolumeContextKeyMountOptions]; ok {
+			options = joinMountOptions(options, strings.Split(mountOptions, ","))
+		}
+	}
+
+	return options
+}
+
 func putExitFile(pod *corev1.Pod, targetPath string) error {
 	podIsTerminating := pod.DeletionTimestamp != nil
 	podRestartPolicyIsNever := pod.Spec.RestartPolicy == corev1.RestartPolicyNever
@@ -472,6 +858,28 @@ func getSidecarContainerStatus(isInitContainer bool, pod *corev1.Pod) (*corev1.C
 	return nil, errors.New("the sidecar container was not found")
 }
 
+// sidecarVersionTooOld returns why the sidecar mounter version recorded at the volume socket handshake is older than minVersion.
+// Only known versions are compared, so sidecar mounters that predate the handshake and record no version, and custom builds
+// whose versions are not semantic versions, are accepted.
+func sidecarVersionTooOld(version, minVersion string) (string, bool) {
+	if minVersion != "" && semver.IsValid(version) && semver.Compare(version, minVersion) < 0 {
+		return fmt.Sprintf("the sidecar mounter version %v is older than the required version %v", version, minVersion), true
+	}
+
+	return "", false
+}
+
+// sidecarContainerImage returns the image of the sidecar container in the Pod spec.
+func sidecarContainerImage(pod *corev1.Pod) string {
+	for _, c := range slices.Concat(pod.Spec.InitContainers, pod.Spec.Containers) {
+		if c.Name == webhook.GcsFuseSidecarName {
+			return c.Image
+		}
+	}
+
+	return ""
+}
+
 func isSidecarVersionSupportedForTokenServer(imageName string) bool {
 	managedSidecarPattern := `.*/gke-release(-staging)?/gcs-fuse-csi-driver-sidecar-mounter:v\d+.\d+.\d+-gke\.\d+.*`
 	re := regexp.MustCompile(managedSidecarPattern)
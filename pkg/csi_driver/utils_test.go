@@ -20,9 +20,14 @@ package driver
 import (
 	"testing"
 
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/webhook"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 )
 
 const (
@@ -115,6 +120,54 @@ func TestJoinMountOptions(t *testing.T) {
 	})
 }
 
+func TestSidecarVersionTooOld(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name           string
+		version        string
+		minVersion     string
+		expectedTooOld bool
+	}{
+		{
+			name:           "should return not too old for sidecar mounters that do not report their version",
+			version:        "",
+			minVersion:     "v1.15.0",
+			expectedTooOld: false,
+		},
+		{
+			name:           "should return not too old without a minimum version",
+			version:        "v1.0.0",
+			expectedTooOld: false,
+		},
+		{
+			name:           "should return too old for versions older than the minimum version",
+			version:        "v1.14.1",
+			minVersion:     "v1.15.0",
+			expectedTooOld: true,
+		},
+		{
+			name:           "should return not too old for the minimum version",
+			version:        "v1.15.0",
+			minVersion:     "v1.15.0",
+			expectedTooOld: false,
+		},
+		{
+			name:           "should return not too old for custom builds",
+			version:        "unknown",
+			minVersion:     "v1.15.0",
+			expectedTooOld: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		reason, tooOld := sidecarVersionTooOld(tc.version, tc.minVersion)
+		if tooOld != tc.expectedTooOld {
+			t.Errorf("Got too old %v (%q), but expected %v", tooOld, reason, tc.expectedTooOld)
+		}
+	}
+}
+
 func TestIsSidecarVersionSupportedForTokenServer(t *testing.T) {
 	t.Parallel()
 	t.Run("checking if sidecar version is supported for token server", func(t *testing.T) {
@@ -408,6 +461,86 @@ func TestParseVolumeAttributes(t *testing.T) {
 				volumeContext:        map[string]string{VolumeContextKeyGcsfuseLoggingSeverity: "trace"},
 				expectedMountOptions: []string{volumeAttributesToMountOptionsMapping[VolumeContextKeyGcsfuseLoggingSeverity] + TraceStr},
 			},
+			{
+				name:                 "should return correct connection pool options",
+				volumeContext:        map[string]string{VolumeContextKeyMaxConnsPerHost: "0", VolumeContextKeyMaxIdleConnsPerHost: "800", VolumeContextKeyClientProtocol: "http2"},
+				expectedMountOptions: []string{"gcs-connection:max-conns-per-host:0", "gcs-connection:max-idle-conns-per-host:800", "gcs-connection:client-protocol:http2"},
+			},
+			{
+				name:          "should throw error for negative maxConnsPerHost",
+				volumeContext: map[string]string{VolumeContextKeyMaxConnsPerHost: "-1"},
+				expectedErr:   true,
+			},
+			{
+				name:          "should throw error for invalid maxIdleConnsPerHost",
+				volumeContext: map[string]string{VolumeContextKeyMaxIdleConnsPerHost: "many"},
+				expectedErr:   true,
+			},
+			{
+				name:          "should throw error for invalid clientProtocol",
+				volumeContext: map[string]string{VolumeContextKeyClientProtocol: "http3"},
+				expectedErr:   true,
+			},
+			{
+				name:                 "should return correct kernel cache and permission options",
+				volumeContext:        map[string]string{VolumeContextKeyFileMode: "0644", VolumeContextKeyDirMode: "755", VolumeContextKeyKernelListCacheTTLSeconds: "-10"},
+				expectedMountOptions: []string{"file-mode=644", "dir-mode=755", "file-system:kernel-list-cache-ttl-secs:-1"},
+			},
+			{
+				name:                 "fileMode overrides the file-mode mount option",
+				volumeContext:        map[string]string{VolumeContextKeyMountOptions: "file-mode=600", VolumeContextKeyFileMode: "640"},
+				expectedMountOptions: []string{"file-mode=640"},
+			},
+			{
+				name:          "should throw error for non-octal fileMode",
+				volumeContext: map[string]string{VolumeContextKeyFileMode: "0689"},
+				expectedErr:   true,
+			},
+			{
+				name:          "should throw error for dirMode with special bits",
+				volumeContext: map[string]string{VolumeContextKeyDirMode: "1777"},
+				expectedErr:   true,
+			},
+			{
+				name:          "should throw error for invalid kernelListCacheTTLSeconds",
+				volumeContext: map[string]string{VolumeContextKeyKernelListCacheTTLSeconds: "1h"},
+				expectedErr:   true,
+			},
+			{
+				name:                 "should return correct file cache parallel downloads option",
+				volumeContext:        map[string]string{VolumeContextKeyFileCacheParallelDownloads: "True"},
+				expectedMountOptions: []string{"file-cache:enable-parallel-downloads:true"},
+			},
+			{
+				name:                 "should return correct token refresh option",
+				volumeContext:        map[string]string{VolumeContextKeyTokenRefreshSeconds: "300"},
+				expectedMountOptions: []string{"token-server-refresh-secs=300"},
+			},
+			{
+				name:          "should throw error for non-positive tokenRefreshSeconds",
+				volumeContext: map[string]string{VolumeContextKeyTokenRefreshSeconds: "0"},
+				expectedErr:   true,
+			},
+			{
+				name:                 "should return correct workload recommendations option",
+				volumeContext:        map[string]string{VolumeContextKeyWorkloadRecommendations: "True"},
+				expectedMountOptions: []string{"workload-recommendations=true"},
+			},
+			{
+				name:          "should throw error for invalid workloadRecommendations",
+				volumeContext: map[string]string{VolumeContextKeyWorkloadRecommendations: "sometimes"},
+				expectedErr:   true,
+			},
+			{
+				name:                 "should return correct impersonated service account option",
+				volumeContext:        map[string]string{VolumeContextKeyGCPServiceAccount: "sa-name@test-project.iam.gserviceaccount.com"},
+				expectedMountOptions: []string{"token-server-impersonate-service-account=sa-name@test-project.iam.gserviceaccount.com"},
+			},
+			{
+				name:          "should throw error for invalid gcpServiceAccount",
+				volumeContext: map[string]string{VolumeContextKeyGCPServiceAccount: "sa-name@example.com"},
+				expectedErr:   true,
+			},
 			{
 				name: "should return correct mount options",
 				volumeContext: map[string]string{
@@ -510,3 +643,512 @@ func TestParseVolumeAttributes(t *testing.T) {
 		}
 	})
 }
+
+func TestParseVerifyReadOnMount(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name           string
+		volumeContext  map[string]string
+		expectedVerify bool
+		expectedObject string
+		expectedErr    bool
+	}{
+		{
+			name:          "not set",
+			volumeContext: map[string]string{},
+		},
+		{
+			name:           "list the bucket",
+			volumeContext:  map[string]string{VolumeContextKeyVerifyReadOnMount: "true"},
+			expectedVerify: true,
+		},
+		{
+			name:           "read a canary object",
+			volumeContext:  map[string]string{VolumeContextKeyVerifyReadOnMount: "true", VolumeContextKeyVerifyReadObject: "canary"},
+			expectedVerify: true,
+			expectedObject: "canary",
+		},
+		{
+			name:          "canary object without verification",
+			volumeContext: map[string]string{VolumeContextKeyVerifyReadObject: "canary"},
+			expectedErr:   true,
+		},
+		{
+			name:          "canary object with verification disabled",
+			volumeContext: map[string]string{VolumeContextKeyVerifyReadOnMount: "false", VolumeContextKeyVerifyReadObject: "canary"},
+			expectedErr:   true,
+		},
+		{
+			name:          "invalid value",
+			volumeContext: map[string]string{VolumeContextKeyVerifyReadOnMount: "sometimes"},
+			expectedErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := validateVolumeAttributes(tc.volumeContext)
+			if (err != nil) != tc.expectedErr {
+				t.Errorf("Got error %v, but expected error %v", err, tc.expectedErr)
+			}
+			if err != nil {
+				return
+			}
+			verify, object := parseVerifyReadOnMount(tc.volumeContext)
+			if verify != tc.expectedVerify || object != tc.expectedObject {
+				t.Errorf("Got verify %v and object %q, but expected %v and %q", verify, object, tc.expectedVerify, tc.expectedObject)
+			}
+		})
+	}
+}
+
+func TestParseHierarchicalNamespace(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name               string
+		volumeContext      map[string]string
+		expectedEnabled    bool
+		expectedAutoDetect bool
+		expectedErr        bool
+	}{
+		{
+			name:          "not set",
+			volumeContext: map[string]string{},
+		},
+		{
+			name:            "enabled",
+			volumeContext:   map[string]string{VolumeContextKeyHierarchicalNamespace: "true"},
+			expectedEnabled: true,
+		},
+		{
+			name:          "disabled",
+			volumeContext: map[string]string{VolumeContextKeyHierarchicalNamespace: "false"},
+		},
+		{
+			name:               "auto-detection",
+			volumeContext:      map[string]string{VolumeContextKeyHierarchicalNamespace: "auto"},
+			expectedAutoDetect: true,
+		},
+		{
+			name:          "invalid value",
+			volumeContext: map[string]string{VolumeContextKeyHierarchicalNamespace: "sometimes"},
+			expectedErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := validateVolumeAttributes(tc.volumeContext)
+			if (err != nil) != tc.expectedErr {
+				t.Errorf("Got error %v, but expected error %v", err, tc.expectedErr)
+			}
+			if err != nil {
+				return
+			}
+			enabled, autoDetect := parseHierarchicalNamespace(tc.volumeContext)
+			if enabled != tc.expectedEnabled || autoDetect != tc.expectedAutoDetect {
+				t.Errorf("Got enabled %v and auto-detection %v, but expected %v and %v", enabled, autoDetect, tc.expectedEnabled, tc.expectedAutoDetect)
+			}
+		})
+	}
+}
+
+func TestHierarchicalNamespaceMountOptions(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name            string
+		options         []string
+		expectedOptions []string
+	}{
+		{
+			name:            "no options",
+			options:         []string{},
+			expectedOptions: []string{"enable-hns"},
+		},
+		{
+			name:            "implicit-dirs is removed",
+			options:         []string{"ro", "implicit-dirs", "implicit-dirs=true"},
+			expectedOptions: []string{"ro", "enable-hns"},
+		},
+		{
+			name:            "enable-hns set by the user",
+			options:         []string{"enable-hns=false"},
+			expectedOptions: []string{"enable-hns=false"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			options := hierarchicalNamespaceMountOptions(tc.options)
+			if diff := cmp.Diff(options, tc.expectedOptions); diff != "" {
+				t.Errorf("unexpected options (-got, +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestVolumeLogKeys(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name         string
+		req          interface{}
+		expectedKeys []interface{}
+	}{
+		{
+			name:         "node publish",
+			req:          &csi.NodePublishVolumeRequest{VolumeId: "bucket", TargetPath: "/target"},
+			expectedKeys: []interface{}{util.LogKeyVolume, "bucket", util.LogKeyTargetPath, "/target"},
+		},
+		{
+			name:         "node volume stats",
+			req:          &csi.NodeGetVolumeStatsRequest{VolumeId: "bucket", VolumePath: "/target"},
+			expectedKeys: []interface{}{util.LogKeyVolume, "bucket", util.LogKeyTargetPath, "/target"},
+		},
+		{
+			name:         "create volume without volume ID",
+			req:          &csi.CreateVolumeRequest{Name: "pv"},
+			expectedKeys: []interface{}{},
+		},
+		{
+			name:         "node info",
+			req:          &csi.NodeGetInfoRequest{},
+			expectedKeys: []interface{}{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if diff := cmp.Diff(volumeLogKeys(tc.req), tc.expectedKeys); diff != "" {
+				t.Errorf("unexpected log keys (-got, +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExperimentalFlagName(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		mountOption  string
+		expectedName string
+	}{
+		{mountOption: "implicit-dirs", expectedName: "implicit-dirs"},
+		{mountOption: "experimental-enable-json-read=true", expectedName: "experimental-enable-json-read"},
+		{mountOption: "app-name=team:job", expectedName: "app-name"},
+		{mountOption: "write:enable-streaming-writes:true", expectedName: "write:enable-streaming-writes"},
+		{mountOption: "file-system:fuse-options:allow_other=1", expectedName: "file-system:fuse-options"},
+		{mountOption: "gcs-connection:custom-endpoint:https://storage.example.com:443/storage/v1/", expectedName: "gcs-connection:custom-endpoint"},
+		{mountOption: "disable-metrics-for-gke:", expectedName: "disable-metrics-for-gke"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.mountOption, func(t *testing.T) {
+			t.Parallel()
+			if name := experimentalFlagName(tc.mountOption); name != tc.expectedName {
+				t.Errorf("got flag name %q, expected %q", name, tc.expectedName)
+			}
+		})
+	}
+}
+
+func TestParseExperimentalFlags(t *testing.T) {
+	t.Parallel()
+	allowlist := sets.New("experimental-enable-json-read", "write:enable-streaming-writes")
+	testCases := []struct {
+		name          string
+		volumeContext map[string]string
+		expectedFlags []string
+		expectedErr   bool
+	}{
+		{
+			name:          "no experimental flags",
+			volumeContext: map[string]string{},
+			expectedFlags: []string{},
+		},
+		{
+			name:          "allowed flags",
+			volumeContext: map[string]string{VolumeContextKeyGcsfuseExperimentalFlags: "experimental-enable-json-read=true, write:enable-streaming-writes:true"},
+			expectedFlags: []string{"experimental-enable-json-read=true", "write:enable-streaming-writes:true"},
+		},
+		{
+			name:          "flag not on the allowlist",
+			volumeContext: map[string]string{VolumeContextKeyGcsfuseExperimentalFlags: "experimental-enable-json-read,experimental-metadata-prefetch-on-mount=async"},
+			expectedErr:   true,
+		},
+		{
+			name:          "config file flag not on the allowlist",
+			volumeContext: map[string]string{VolumeContextKeyGcsfuseExperimentalFlags: "write:enable-streaming-writes:true,write:max-blocks-per-file:1"},
+			expectedErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			output, err := parseExperimentalFlags(tc.volumeContext, allowlist)
+			if (err != nil) != tc.expectedErr {
+				t.Errorf("Got error %v, but expected error %v", err, tc.expectedErr)
+			}
+
+			if diff := cmp.Diff(output, tc.expectedFlags); diff != "" {
+				t.Errorf("unexpected flags (-got, +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMultiWriterMountOptionWarnings(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name             string
+		accessMode       csi.VolumeCapability_AccessMode_Mode
+		mountOptions     []string
+		expectedWarnings int
+	}{
+		{
+			name:         "single writer with unsafe option",
+			accessMode:   csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			mountOptions: []string{"metadata-cache:ttl-secs:-1"},
+		},
+		{
+			name:         "multi node multi writer with safe options",
+			accessMode:   csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			mountOptions: []string{"metadata-cache:ttl-secs:60", "implicit-dirs"},
+		},
+		{
+			name:             "multi node multi writer with infinite metadata cache",
+			accessMode:       csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			mountOptions:     []string{"metadata-cache:ttl-secs:-1"},
+			expectedWarnings: 1,
+		},
+		{
+			name:             "multi node multi writer with infinite kernel list cache",
+			accessMode:       csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			mountOptions:     []string{"file-system:kernel-list-cache-ttl-secs:-1"},
+			expectedWarnings: 1,
+		},
+		{
+			name:             "single node multi writer with streaming writes",
+			accessMode:       csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
+			mountOptions:     []string{"write:enable-streaming-writes:true"},
+			expectedWarnings: 1,
+		},
+		{
+			name:         "read only multi writer with unsafe option",
+			accessMode:   csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			mountOptions: []string{"ro", "metadata-cache:ttl-secs:-1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			warnings := multiWriterMountOptionWarnings(tc.accessMode, tc.mountOptions)
+			if len(warnings) != tc.expectedWarnings {
+				t.Errorf("Got warnings %q, but expected %v warnings", warnings, tc.expectedWarnings)
+			}
+		})
+	}
+}
+
+func TestParsePrefixVolumeID(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		volumeID       string
+		expectedBucket string
+		expectedPrefix string
+		expectedOK     bool
+	}{
+		{volumeID: "test-bucket"},
+		{volumeID: "test-bucket:fake-handle"},
+		{volumeID: "test-bucket:/"},
+		{volumeID: ":pvc-1/"},
+		{volumeID: prefixVolumeID("test-bucket", "pvc-1/"), expectedBucket: "test-bucket", expectedPrefix: "pvc-1/", expectedOK: true},
+	}
+
+	for _, tc := range testCases {
+		bucketName, prefix, ok := parsePrefixVolumeID(tc.volumeID)
+		if bucketName != tc.expectedBucket || prefix != tc.expectedPrefix || ok != tc.expectedOK {
+			t.Errorf("volume ID %q: got %q, %q, %v, expected %q, %q, %v", tc.volumeID, bucketName, prefix, ok, tc.expectedBucket, tc.expectedPrefix, tc.expectedOK)
+		}
+	}
+}
+
+func TestBillingProject(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		mountOptions    []string
+		expectedProject string
+	}{
+		{mountOptions: nil},
+		{mountOptions: []string{"implicit-dirs", "only-dir=data"}},
+		{mountOptions: []string{"implicit-dirs", "billing-project=test-project"}, expectedProject: "test-project"},
+		{mountOptions: []string{"gcs-connection:billing-project:test-project"}, expectedProject: "test-project"},
+	}
+
+	for _, tc := range testCases {
+		if project := billingProject(tc.mountOptions); project != tc.expectedProject {
+			t.Errorf("mount options %v: got billing project %q, expected %q", tc.mountOptions, project, tc.expectedProject)
+		}
+	}
+}
+
+func TestValidateOnlyDir(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		mountOptions []string
+		expectErr    bool
+	}{
+		{mountOptions: nil},
+		{mountOptions: []string{"implicit-dirs", "only-dir=data"}},
+		{mountOptions: []string{"only-dir=data/nested/"}},
+		{mountOptions: []string{"only-dir=data..backup"}},
+		{mountOptions: []string{"only-dir=/data"}, expectErr: true},
+		{mountOptions: []string{"only-dir=../data"}, expectErr: true},
+		{mountOptions: []string{"only-dir=data/./nested"}, expectErr: true},
+		{mountOptions: []string{"only-dir=data/.."}, expectErr: true},
+		{mountOptions: []string{"only-dir=data\nnested"}, expectErr: true},
+		{mountOptions: []string{"only-dir=data", "only-dir=other"}, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		if err := validateOnlyDir(tc.mountOptions); (err != nil) != tc.expectErr {
+			t.Errorf("mount options %q: got error %v, expected error %v", tc.mountOptions, err, tc.expectErr)
+		}
+	}
+}
+
+func TestConnectionPoolMountOptions(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name                 string
+		mountOptions         []string
+		machineType          string
+		expectedMountOptions []string
+	}{
+		{
+			name:                 "general-purpose machine",
+			mountOptions:         []string{"implicit-dirs"},
+			machineType:          "n2-standard-32",
+			expectedMountOptions: []string{"implicit-dirs"},
+		},
+		{
+			name:                 "unknown machine type",
+			mountOptions:         []string{"implicit-dirs"},
+			expectedMountOptions: []string{"implicit-dirs"},
+		},
+		{
+			name:                 "single accelerator machine",
+			mountOptions:         []string{"implicit-dirs"},
+			machineType:          "a2-highgpu-1g",
+			expectedMountOptions: []string{"implicit-dirs"},
+		},
+		{
+			name:                 "8 GPU machine",
+			mountOptions:         []string{"implicit-dirs"},
+			machineType:          "a3-highgpu-8g",
+			expectedMountOptions: []string{"gcs-connection:max-conns-per-host:0", "gcs-connection:max-idle-conns-per-host:800", "implicit-dirs"},
+		},
+		{
+			name:                 "4 TPU machine",
+			machineType:          "ct5lp-hightpu-4t",
+			expectedMountOptions: []string{"gcs-connection:max-conns-per-host:0", "gcs-connection:max-idle-conns-per-host:400"},
+		},
+		{
+			name:                 "options set by the volume are kept",
+			mountOptions:         []string{"max-conns-per-host=10", "gcs-connection:max-idle-conns-per-host:50"},
+			machineType:          "a3-megagpu-8g",
+			expectedMountOptions: []string{"gcs-connection:max-idle-conns-per-host:50", "max-conns-per-host=10"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := connectionPoolMountOptions(tc.mountOptions, tc.machineType)
+			if diff := cmp.Diff(tc.expectedMountOptions, got, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+				t.Errorf("unexpected mount options (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPodDefaultMountOptions(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name                 string
+		mountOptions         []string
+		annotations          map[string]string
+		expectedMountOptions []string
+		expectErr            bool
+	}{
+		{
+			name:                 "no annotations",
+			mountOptions:         []string{"implicit-dirs"},
+			expectedMountOptions: []string{"implicit-dirs"},
+		},
+		{
+			name:         "annotations set the defaults",
+			mountOptions: []string{"implicit-dirs"},
+			annotations: map[string]string{
+				webhook.GcsfuseLoggingSeverityAnnotation:     "debug",
+				webhook.FileCacheCapacityAnnotation:          "10Gi",
+				webhook.FileCacheParallelDownloadsAnnotation: "true",
+				webhook.MetadataStatCacheCapacityAnnotation:  "-1",
+				webhook.MetadataTypeCacheCapacityAnnotation:  "64Mi",
+			},
+			expectedMountOptions: []string{
+				"implicit-dirs",
+				"logging:severity:debug",
+				"file-cache:max-size-mb:10240",
+				"file-cache:enable-parallel-downloads:true",
+				"metadata-cache:stat-cache-max-size-mb:-1",
+				"metadata-cache:type-cache-max-size-mb:64",
+			},
+		},
+		{
+			name:         "options set by the volume are kept",
+			mountOptions: []string{"logging:severity:error", "file-cache:max-size-mb:100"},
+			annotations: map[string]string{
+				webhook.GcsfuseLoggingSeverityAnnotation: "trace",
+				webhook.FileCacheCapacityAnnotation:      "10Gi",
+			},
+			expectedMountOptions: []string{"logging:severity:error", "file-cache:max-size-mb:100"},
+		},
+		{
+			name:                 "unrelated annotations are ignored",
+			mountOptions:         []string{"implicit-dirs"},
+			annotations:          map[string]string{webhook.GcsFuseVolumeEnableAnnotation: "true"},
+			expectedMountOptions: []string{"implicit-dirs"},
+		},
+		{
+			name:        "invalid annotation",
+			annotations: map[string]string{webhook.FileCacheCapacityAnnotation: "10Gb"},
+			expectErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+			got, err := podDefaultMountOptions(tc.mountOptions, pod)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectErr)
+			}
+			if tc.expectErr {
+				return
+			}
+			if diff := cmp.Diff(tc.expectedMountOptions, got, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+				t.Errorf("unexpected mount options (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/volumespec"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	// VolumeContextKeyVolumeAttributesVersion is the version of the volume attribute schema that the volume is written for.
+	VolumeContextKeyVolumeAttributesVersion = volumespec.AttributeVolumeAttributesVersion
+	// volumeAttributesVersionV1 is the current volume attribute schema. Volumes that declare it are rejected if they use
+	// deprecated volume attributes or mount options, while volumes without a version are translated to it.
+	volumeAttributesVersionV1 = volumespec.VolumeAttributesVersionV1
+
+	eventReasonDeprecatedVolumeAttributes = "GCSFuseDeprecatedVolumeAttributes"
+)
+
+// deprecatedVolumeAttributes maps the deprecated volume attributes to the ones that replace them.
+var deprecatedVolumeAttributes = map[string]string{
+	VolumeContextKeyMetadataCacheTtlSeconds: VolumeContextKeyMetadataCacheTTLSeconds,
+}
+
+// deprecatedMountOption is a deprecated gcsfuse flag, and the volume attribute that replaces it.
+type deprecatedMountOption struct {
+	replacement string
+	// translate converts the flag value to the value of the replacement volume attribute.
+	// It is nil if the value cannot be converted, in which case the flag is passed to gcsfuse as is.
+	translate func(value string) (int, error)
+}
+
+// deprecatedMountOptions maps the deprecated gcsfuse flags to the volume attributes that replace them.
+var deprecatedMountOptions = map[string]deprecatedMountOption{
+	// The stat cache capacity is a number of entries, while the replacement is a size, so gcsfuse converts it.
+	"stat-cache-capacity": {replacement: VolumeContextKeyMetadataStatCacheCapacity},
+	"stat-cache-ttl":      {replacement: VolumeContextKeyMetadataCacheTTLSeconds, translate: durationSeconds},
+	"type-cache-ttl":      {replacement: VolumeContextKeyMetadataCacheTTLSeconds, translate: durationSeconds},
+}
+
+// durationSeconds converts a duration to whole seconds. Positive durations below one second are rejected,
+// because they would be truncated to 0, which disables the cache instead of setting a short TTL.
+func durationSeconds(value string) (int, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d > 0 && d < time.Second {
+		return 0, fmt.Errorf("duration %v is shorter than one second, the shortest TTL the volume attribute accepts", d)
+	}
+
+	return int(d / time.Second), nil
+}
+
+// translateVolumeAttributes translates the deprecated volume attributes and mount options of a request to the current schema,
+// so that the volumes written for older driver versions keep working after a driver upgrade. It returns a copy of the request
+// with the translated volume attributes and mount flags, and a description of each deprecated setting for the users to update.
+func translateVolumeAttributes(req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeRequest, []string, error) {
+	version, versioned := req.GetVolumeContext()[VolumeContextKeyVolumeAttributesVersion]
+	if versioned && version != volumeAttributesVersionV1 {
+		return nil, nil, fmt.Errorf("volume attribute %v only accepts %q, got %q", VolumeContextKeyVolumeAttributesVersion, volumeAttributesVersionV1, version)
+	}
+
+	translated, _ := proto.Clone(req).(*csi.NodePublishVolumeRequest)
+	if translated.VolumeContext == nil {
+		translated.VolumeContext = map[string]string{}
+	}
+	vc := translated.VolumeContext
+	deprecations := []string{}
+
+	for _, deprecated := range sets.List(sets.KeySet(deprecatedVolumeAttributes)) {
+		value, ok := vc[deprecated]
+		if !ok {
+			continue
+		}
+
+		replacement := deprecatedVolumeAttributes[deprecated]
+		delete(vc, deprecated)
+		if _, ok := vc[replacement]; ok {
+			deprecations = append(deprecations, fmt.Sprintf("volume attribute %v is deprecated and ignored, because %v is set", deprecated, replacement))
+
+			continue
+		}
+		vc[replacement] = value
+		deprecations = append(deprecations, fmt.Sprintf("volume attribute %v is deprecated, use %v instead", deprecated, replacement))
+	}
+
+	// The values translated from the mount options, which are set on the volume context after all the options are translated,
+	// so that the options only replace the volume attributes that the volume does not set.
+	translatedValues := map[string]int{}
+	translateMountOptions := func(options []string) ([]string, error) {
+		var kept []string
+		for _, o := range options {
+			name, value, _ := strings.Cut(o, "=")
+			deprecated, ok := deprecatedMountOptions[name]
+			if !ok {
+				kept = append(kept, o)
+
+				continue
+			}
+
+			_, replacementSet := vc[deprecated.replacement]
+			switch {
+			case replacementSet:
+				deprecations = append(deprecations, fmt.Sprintf("mount option %v is deprecated and ignored, because the volume attribute %v is set", name, deprecated.replacement))
+			case deprecated.translate == nil:
+				deprecations = append(deprecations, fmt.Sprintf("mount option %v is deprecated, use the volume attribute %v instead", name, deprecated.replacement))
+				kept = append(kept, o)
+			default:
+				v, err := deprecated.translate(value)
+				if err != nil {
+					return nil, fmt.Errorf("failed to translate the deprecated mount option %q to the volume attribute %v: %w", o, deprecated.replacement, err)
+				}
+				// gcsfuse uses the shortest of the deprecated TTLs as the metadata cache TTL.
+				if previous, ok := translatedValues[deprecated.replacement]; !ok || v < previous {
+					translatedValues[deprecated.replacement] = v
+				}
+				deprecations = append(deprecations, fmt.Sprintf("mount option %v is deprecated, use the volume attribute %v instead", name, deprecated.replacement))
+			}
+		}
+
+		return kept, nil
+	}
+
+	if m := translated.GetVolumeCapability().GetMount(); m != nil {
+		flags, err := translateMountOptions(m.GetMountFlags())
+		if err != nil {
+			return nil, nil, err
+		}
+		m.MountFlags = flags
+	}
+	if mountOptions, ok := vc[VolumeContextKeyMountOptions]; ok {
+		options, err := translateMountOptions(strings.Split(mountOptions, ","))
+		if err != nil {
+			return nil, nil, err
+		}
+		vc[VolumeContextKeyMountOptions] = strings.Join(options, ",")
+	}
+	for attribute, value := range translatedValues {
+		vc[attribute] = strconv.Itoa(value)
+	}
+
+	if versioned && len(deprecations) > 0 {
+		return nil, nil, fmt.Errorf("volume attribute %v is %q, which does not accept deprecated settings: %v", VolumeContextKeyVolumeAttributesVersion, volumeAttributesVersionV1, strings.Join(deprecations, "; "))
+	}
+
+	return translated, deprecations, nil
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTranslateVolumeAttributes(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name                 string
+		mountFlags           []string
+		vc                   map[string]string
+		expectedMountFlags   []string
+		expectedVC           map[string]string
+		expectedDeprecations int
+		expectErr            bool
+	}{
+		{
+			name:               "current settings",
+			mountFlags:         []string{"implicit-dirs"},
+			vc:                 map[string]string{VolumeContextKeyMetadataCacheTTLSeconds: "60"},
+			expectedMountFlags: []string{"implicit-dirs"},
+			expectedVC:         map[string]string{VolumeContextKeyMetadataCacheTTLSeconds: "60"},
+		},
+		{
+			name:                 "deprecated volume attribute",
+			vc:                   map[string]string{VolumeContextKeyMetadataCacheTtlSeconds: "60"},
+			expectedVC:           map[string]string{VolumeContextKeyMetadataCacheTTLSeconds: "60"},
+			expectedDeprecations: 1,
+		},
+		{
+			name:                 "deprecated volume attribute with its replacement",
+			vc:                   map[string]string{VolumeContextKeyMetadataCacheTtlSeconds: "60", VolumeContextKeyMetadataCacheTTLSeconds: "30"},
+			expectedVC:           map[string]string{VolumeContextKeyMetadataCacheTTLSeconds: "30"},
+			expectedDeprecations: 1,
+		},
+		{
+			name:                 "deprecated mount options in the mount flags and the mountOptions volume attribute",
+			mountFlags:           []string{"implicit-dirs", "stat-cache-ttl=2m"},
+			vc:                   map[string]string{VolumeContextKeyMountOptions: "type-cache-ttl=90s,uid=1001"},
+			expectedMountFlags:   []string{"implicit-dirs"},
+			expectedVC:           map[string]string{VolumeContextKeyMountOptions: "uid=1001", VolumeContextKeyMetadataCacheTTLSeconds: "90"},
+			expectedDeprecations: 2,
+		},
+		{
+			name:                 "deprecated mount option without translation",
+			mountFlags:           []string{"stat-cache-capacity=1000"},
+			expectedMountFlags:   []string{"stat-cache-capacity=1000"},
+			expectedVC:           map[string]string{},
+			expectedDeprecations: 1,
+		},
+		{
+			name:       "invalid deprecated mount option",
+			mountFlags: []string{"stat-cache-ttl=forever"},
+			expectErr:  true,
+		},
+		{
+			name:       "sub-second deprecated mount option",
+			mountFlags: []string{"type-cache-ttl=500ms"},
+			expectErr:  true,
+		},
+		{
+			name:                 "deprecated mount option that disables the cache",
+			mountFlags:           []string{"stat-cache-ttl=0s"},
+			expectedVC:           map[string]string{VolumeContextKeyMetadataCacheTTLSeconds: "0"},
+			expectedDeprecations: 1,
+		},
+		{
+			name:               "current schema version",
+			mountFlags:         []string{"implicit-dirs"},
+			vc:                 map[string]string{VolumeContextKeyVolumeAttributesVersion: "v1"},
+			expectedMountFlags: []string{"implicit-dirs"},
+			expectedVC:         map[string]string{VolumeContextKeyVolumeAttributesVersion: "v1"},
+		},
+		{
+			name:      "current schema version rejects deprecated settings",
+			vc:        map[string]string{VolumeContextKeyVolumeAttributesVersion: "v1", VolumeContextKeyMetadataCacheTtlSeconds: "60"},
+			expectErr: true,
+		},
+		{
+			name:      "unknown schema version",
+			vc:        map[string]string{VolumeContextKeyVolumeAttributesVersion: "v2"},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := &csi.NodePublishVolumeRequest{
+				VolumeId: "test-bucket",
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{MountFlags: tc.mountFlags}},
+				},
+				VolumeContext: tc.vc,
+			}
+			translated, deprecations, err := translateVolumeAttributes(req)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectErr)
+			}
+			if tc.expectErr {
+				return
+			}
+
+			if diff := cmp.Diff(tc.expectedMountFlags, translated.GetVolumeCapability().GetMount().GetMountFlags()); diff != "" {
+				t.Errorf("unexpected mount flags (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.expectedVC, translated.GetVolumeContext()); diff != "" {
+				t.Errorf("unexpected volume context (-want +got):\n%s", diff)
+			}
+			if len(deprecations) != tc.expectedDeprecations {
+				t.Errorf("got deprecations %q, expected %d", deprecations, tc.expectedDeprecations)
+			}
+			// The request is not modified, so that the retries of kubelet are translated the same way.
+			if diff := cmp.Diff(tc.mountFlags, req.GetVolumeCapability().GetMount().GetMountFlags()); diff != "" {
+				t.Errorf("the request mount flags were modified (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"maps"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/storage"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// volumeUsageInterval is how often NodePublishVolume starts counting the objects of a published volume.
+	volumeUsageInterval = 10 * time.Minute
+	// volumeUsageTimeout bounds the object listing, so that a large bucket does not hold the count of the volume forever.
+	volumeUsageTimeout = 30 * time.Second
+)
+
+// startVolumeUsageCount counts the objects of a published volume in the background, unless they are already being counted,
+// so that the listing does not hold the volume lock nor delay NodePublishVolume. Call it while holding the volume lock.
+func (s *nodeServer) startVolumeUsageCount(ctx context.Context, vc map[string]string, vs *util.VolumeState, bucketName string) {
+	if !vs.StartUsageCount() {
+		return
+	}
+	vs.UsageCountedAt = time.Now()
+
+	// The volume state is only read while holding the volume lock.
+	bucket := &storage.ServiceBucket{Name: bucketName, BillingProject: billingProject(vs.PublishedMountOptions)}
+	prefix := onlyDirPrefix(vs.PublishedMountOptions)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), volumeUsageTimeout)
+	go func() {
+		defer cancel()
+		vs.EndUsageCount(s.countVolumeUsage(ctx, maps.Clone(vc), bucket, prefix))
+	}()
+}
+
+// countVolumeUsage counts the objects of the bucket under the prefix, and returns their number and total size,
+// or nil if they cannot be counted, which keeps the previous usage.
+func (s *nodeServer) countVolumeUsage(ctx context.Context, vc map[string]string, bucket *storage.ServiceBucket, prefix string) *util.VolumeUsage {
+	storageService, err := s.prepareStorageService(ctx, vc)
+	if err != nil {
+		klog.Warningf("failed to prepare storage service to count the objects of GCS bucket %q: %v", bucket.Name, err)
+
+		return nil
+	}
+	defer storageService.Close()
+
+	usage, err := storageService.GetObjectUsage(ctx, bucket, prefix, s.driver.config.VolumeStatsMaxObjects)
+	if err != nil {
+		klog.Warningf("failed to count the objects of GCS bucket %q: %v", bucket.Name, err)
+
+		return nil
+	}
+	if !usage.Complete {
+		klog.V(4).Infof("GCS bucket %q has more than %d objects, only the usage of the first objects is reported", bucket.Name, usage.Objects)
+	}
+
+	return &util.VolumeUsage{UsedBytes: usage.Bytes, UsedObjects: usage.Objects}
+}
+
+// volumeUsage returns the usage reported by NodeGetVolumeStats, in bytes and in inodes. Each object counts as one inode,
+// like a file of the gcsfuse mount. Directories without objects and the objects over the counted maximum are not counted.
+// Buckets have no capacity, so the total and available bytes and inodes are left unknown.
+func volumeUsage(usage *util.VolumeUsage) []*csi.VolumeUsage {
+	if usage == nil {
+		usage = &util.VolumeUsage{}
+	}
+
+	return []*csi.VolumeUsage{
+		{Unit: csi.VolumeUsage_BYTES, Used: usage.UsedBytes},
+		{Unit: csi.VolumeUsage_INODES, Used: usage.UsedObjects},
+	}
+}
@@ -45,6 +45,9 @@ const (
 	socketName                       = "socket"
 	readAheadKBMountFlagRegexPattern = "^read_ahead_kb=(.+)$"
 	readAheadKBMountFlag             = "read_ahead_kb"
+	// handshakeTimeout is how long to wait for the sidecar mounter handshake after the mount options were sent.
+	// Sidecar mounters that predate the handshake send nothing.
+	handshakeTimeout = 5 * time.Second
 )
 
 var readAheadKBMountFlagRegex = regexp.MustCompile(readAheadKBMountFlagRegexPattern)
@@ -144,7 +147,7 @@ func (m *Mounter) Mount(source string, target string, fstype string, options []s
 	}()
 
 	// Asynchronously waiting for the sidecar container to connect to the listener
-	go startAcceptConn(listener, logPrefix, msg, fd, cancel)
+	go startAcceptConn(listener, logPrefix, msg, fd, cancel, filepath.Join(util.GetSidecarEmptyDirPath(target, webhook.SidecarContainerTmpVolumeName), util.SidecarVersionFile))
 
 	return nil
 }
@@ -259,7 +262,7 @@ func (m *Mounter) cleanupSocket(target string) {
 	}
 }
 
-func startAcceptConn(l net.Listener, logPrefix string, msg []byte, fd int, cancel context.CancelFunc) {
+func startAcceptConn(l net.Listener, logPrefix string, msg []byte, fd int, cancel context.CancelFunc, versionFile string) {
 	defer cancel()
 
 	klog.V(4).Infof("%v start to accept connections to the listener.", logPrefix)
@@ -274,11 +277,53 @@ func startAcceptConn(l net.Listener, logPrefix string, msg []byte, fd int, cance
 	klog.V(4).Infof("%v start to send file descriptor and mount options", logPrefix)
 	if err = util.SendMsg(a, fd, msg); err != nil {
 		klog.Errorf("%v failed to send file descriptor and mount options: %v", logPrefix, err)
+
+		return
+	}
+
+	// Record the sidecar mounter version, so that NodePublishVolume can report sidecar container images that are too old.
+	// The sidecar mounter sends the handshake before it reads the mount options, so reading it after sending them
+	// never delays the mount, and sidecar mounters that predate the handshake close the connection instead.
+	version := readHandshake(a, logPrefix)
+	if err := os.WriteFile(versionFile, []byte(version), 0o644); err != nil {
+		klog.Errorf("%v failed to record the sidecar mounter version: %v", logPrefix, err)
 	}
 
 	klog.V(4).Infof("%v exiting the listener goroutine.", logPrefix)
 }
 
+// readHandshake returns the version the sidecar mounter sent after connecting to the socket,
+// or empty string if the sidecar mounter sent nothing.
+func readHandshake(c net.Conn, logPrefix string) string {
+	if err := c.SetReadDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		klog.Errorf("%v failed to set the handshake deadline: %v", logPrefix, err)
+
+		return ""
+	}
+	defer func() {
+		if err := c.SetReadDeadline(time.Time{}); err != nil {
+			klog.Errorf("%v failed to reset the handshake deadline: %v", logPrefix, err)
+		}
+	}()
+
+	buf := make([]byte, 1024)
+	n, err := c.Read(buf)
+	if err != nil {
+		klog.Warningf("%v the sidecar mounter did not send the handshake: %v", logPrefix, err)
+
+		return ""
+	}
+
+	var h sidecarmounter.Handshake
+	if err := json.Unmarshal(buf[:n], &h); err != nil {
+		klog.Warningf("%v failed to parse the sidecar mounter handshake %q: %v", logPrefix, buf[:n], err)
+
+		return ""
+	}
+
+	return h.Version
+}
+
 func prepareMountOptions(options []string) ([]string, []string, map[string]int64, error) {
 	allowedOptions := map[string]bool{
 		"exec":    true,
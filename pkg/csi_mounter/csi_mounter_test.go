@@ -19,6 +19,7 @@ package csimounter
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"reflect"
 	"testing"
@@ -136,3 +137,48 @@ func countOptionOccurrence(options []string) map[string]int {
 
 	return dict
 }
+
+func TestReadHandshake(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name            string
+		handshake       string
+		expectedVersion string
+	}{
+		{
+			name:            "should return the version sent by the sidecar mounter",
+			handshake:       `{"version":"v1.2.3"}`,
+			expectedVersion: "v1.2.3",
+		},
+		{
+			name:            "should return empty version when the sidecar mounter sends nothing",
+			expectedVersion: "",
+		},
+		{
+			name:            "should return empty version when the handshake is invalid",
+			handshake:       "invalid",
+			expectedVersion: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			t.Logf("test case: %s", tc.name)
+
+			server, client := net.Pipe()
+			defer server.Close()
+			go func() {
+				if tc.handshake != "" {
+					_, _ = client.Write([]byte(tc.handshake))
+				}
+				client.Close()
+			}()
+
+			if version := readHandshake(server, ""); version != tc.expectedVersion {
+				t.Errorf("Got version %q, but expected %q", version, tc.expectedVersion)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package debugbundle gathers what is needed to troubleshoot the gcsfuse volumes of a Pod into a gzipped tarball:
+// the Pod and its volumes, their events, the logs of the sidecar containers and of the driver node service,
+// and the gcsfuse mount table and volume socket states of the node.
+package debugbundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// errorsFile is the file of the tarball that lists what could not be collected.
+const errorsFile = "errors.txt"
+
+// bundle writes the files of a gzipped tarball, and records what could not be collected,
+// so that a partial bundle is still written when some of the sources are unavailable.
+type bundle struct {
+	gw   *gzip.Writer
+	tw   *tar.Writer
+	now  time.Time
+	errs []string
+}
+
+func newBundle(w io.Writer, now time.Time) *bundle {
+	gw := gzip.NewWriter(w)
+
+	return &bundle{gw: gw, tw: tar.NewWriter(gw), now: now}
+}
+
+func (b *bundle) add(name string, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    int64(len(data)),
+		ModTime: b.now,
+	}
+	if err := b.tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write %q to the tarball: %w", name, err)
+	}
+	if _, err := b.tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %q to the tarball: %w", name, err)
+	}
+
+	return nil
+}
+
+// addObject adds the object as YAML.
+func (b *bundle) addObject(name string, o runtime.Object) error {
+	data, err := yaml.Marshal(o)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %q: %w", name, err)
+	}
+
+	return b.add(name, data)
+}
+
+func (b *bundle) recordError(format string, args ...interface{}) {
+	b.errs = append(b.errs, fmt.Sprintf(format, args...))
+}
+
+// close adds the errors file, if any source could not be collected, and flushes the tarball.
+func (b *bundle) close() error {
+	if len(b.errs) > 0 {
+		if err := b.add(errorsFile, []byte(strings.Join(b.errs, "\n")+"\n")); err != nil {
+			return err
+		}
+	}
+	if err := b.tw.Close(); err != nil {
+		return fmt.Errorf("failed to close the tarball: %w", err)
+	}
+
+	return b.gw.Close()
+}
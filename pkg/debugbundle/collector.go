@@ -0,0 +1,367 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debugbundle
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/webhook"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	// DriverNodeLabelSelector selects the Pods of the driver node service.
+	DriverNodeLabelSelector = "k8s-app=gcs-fuse-csi-driver"
+	// driverContainerName is the container of the driver node service Pods.
+	driverContainerName = "gcs-fuse-csi-driver"
+	// collectorBinary is the path of the gcsfusecsi command in the driver image, which the node state collector Pod runs.
+	collectorBinary = "/gcsfusecsi"
+	// KubeletPodsDir is the directory where kubelet keeps the volumes of the Pods on the node.
+	KubeletPodsDir = "/var/lib/kubelet/pods"
+
+	nodeStatePollInterval = 2 * time.Second
+)
+
+// Collector gathers the debug bundle of a Pod.
+type Collector struct {
+	Client kubernetes.Interface
+	// DriverNamespace is the namespace of the driver node service Pods.
+	DriverNamespace string
+	// Since is how far back the logs are collected.
+	Since time.Duration
+	// NodeState makes the collector run a short-lived Pod on the node of the Pod to read the node state.
+	NodeState bool
+	// NodeStateTimeout is how long the collector waits for the node state collector Pod to complete.
+	NodeStateTimeout time.Duration
+}
+
+// Collect writes the debug bundle of the Pod to w as a gzipped tarball. The sources that cannot be collected,
+// such as the logs of a container that has not started, are listed in the errors.txt file of the tarball instead.
+// It returns an error if the Pod cannot be read or the tarball cannot be written.
+func (c *Collector) Collect(ctx context.Context, w io.Writer, namespace, name string) error {
+	pod, err := c.Client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get Pod %s/%s: %w", namespace, name, err)
+	}
+
+	b := newBundle(w, time.Now())
+	pod.APIVersion, pod.Kind = "v1", "Pod"
+	pod.ManagedFields = nil
+	if err := b.addObject("pod.yaml", pod); err != nil {
+		return err
+	}
+
+	uids := map[types.UID]bool{pod.UID: true}
+	pvNames := map[string]bool{}
+	for _, v := range pod.Spec.Volumes {
+		if v.PersistentVolumeClaim == nil {
+			continue
+		}
+		pvc, err := c.Client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, v.PersistentVolumeClaim.ClaimName, metav1.GetOptions{})
+		if err != nil {
+			b.recordError("failed to get PersistentVolumeClaim %s/%s: %v", namespace, v.PersistentVolumeClaim.ClaimName, err)
+
+			continue
+		}
+		uids[pvc.UID] = true
+		pvc.APIVersion, pvc.Kind = "v1", "PersistentVolumeClaim"
+		pvc.ManagedFields = nil
+		if err := b.addObject(path.Join("persistentvolumeclaims", pvc.Name+".yaml"), pvc); err != nil {
+			return err
+		}
+
+		if pvc.Spec.VolumeName == "" {
+			continue
+		}
+		pv, err := c.Client.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, metav1.GetOptions{})
+		if err != nil {
+			b.recordError("failed to get PersistentVolume %s: %v", pvc.Spec.VolumeName, err)
+
+			continue
+		}
+		pvNames[pv.Name] = true
+		pv.APIVersion, pv.Kind = "v1", "PersistentVolume"
+		pv.ManagedFields = nil
+		if err := b.addObject(path.Join("persistentvolumes", pv.Name+".yaml"), pv); err != nil {
+			return err
+		}
+	}
+
+	if err := b.add("events.txt", c.events(ctx, b, namespace, uids, pvNames)); err != nil {
+		return err
+	}
+
+	if err := c.addSidecarLogs(ctx, b, pod); err != nil {
+		return err
+	}
+
+	if pod.Spec.NodeName == "" {
+		b.recordError("Pod %s/%s is not scheduled to a node, so the driver logs and the node state are not collected", namespace, name)
+
+		return b.close()
+	}
+
+	driverPod, err := c.driverPod(ctx, pod.Spec.NodeName)
+	if err != nil {
+		b.recordError("%v", err)
+
+		return b.close()
+	}
+	if err := c.addLogs(ctx, b, driverPod, driverContainerName, false); err != nil {
+		return err
+	}
+
+	if c.NodeState {
+		state, err := c.nodeState(ctx, pod, driverPod)
+		if err != nil {
+			b.recordError("failed to collect the state of node %s: %v", pod.Spec.NodeName, err)
+		} else if err := b.add("node-state.txt", state); err != nil {
+			return err
+		}
+	}
+
+	return b.close()
+}
+
+// events returns the events of the Pod, its PersistentVolumeClaims and its PersistentVolumes, one per line, oldest first.
+func (c *Collector) events(ctx context.Context, b *bundle, namespace string, uids map[types.UID]bool, pvNames map[string]bool) []byte {
+	events := []corev1.Event{}
+	list, err := c.Client.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		b.recordError("failed to list the events in namespace %s: %v", namespace, err)
+	} else {
+		for _, e := range list.Items {
+			if uids[e.InvolvedObject.UID] {
+				events = append(events, e)
+			}
+		}
+	}
+
+	// The events of the cluster-scoped PersistentVolumes are recorded in the default namespace.
+	if len(pvNames) > 0 {
+		list, err := c.Client.CoreV1().Events(metav1.NamespaceDefault).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			b.recordError("failed to list the events in namespace %s: %v", metav1.NamespaceDefault, err)
+		} else {
+			for _, e := range list.Items {
+				if e.InvolvedObject.Kind == "PersistentVolume" && pvNames[e.InvolvedObject.Name] {
+					events = append(events, e)
+				}
+			}
+		}
+	}
+
+	slices.SortStableFunc(events, func(a, b corev1.Event) int {
+		return eventTime(a).Compare(eventTime(b))
+	})
+
+	var sb strings.Builder
+	for _, e := range events {
+		fmt.Fprintf(&sb, "%s %s %s %s/%s (x%d): %s\n", eventTime(e).UTC().Format(time.RFC3339), e.Type, e.Reason, e.InvolvedObject.Kind, e.InvolvedObject.Name, max(e.Count, 1), e.Message)
+	}
+
+	return []byte(sb.String())
+}
+
+func eventTime(e corev1.Event) time.Time {
+	switch {
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	default:
+		return e.CreationTimestamp.Time
+	}
+}
+
+// addSidecarLogs adds the logs of the sidecar containers that the webhook injected into the Pod,
+// and the logs of their previous instances if they restarted.
+func (c *Collector) addSidecarLogs(ctx context.Context, b *bundle, pod *corev1.Pod) error {
+	restarts := map[string]int32{}
+	for _, s := range slices.Concat(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses) {
+		restarts[s.Name] = s.RestartCount
+	}
+
+	found := false
+	for _, container := range slices.Concat(pod.Spec.InitContainers, pod.Spec.Containers) {
+		if container.Name != webhook.GcsFuseSidecarName && container.Name != webhook.MetadataPrefetchSidecarName {
+			continue
+		}
+		found = true
+		if err := c.addLogs(ctx, b, pod, container.Name, false); err != nil {
+			return err
+		}
+		if restarts[container.Name] > 0 {
+			if err := c.addLogs(ctx, b, pod, container.Name, true); err != nil {
+				return err
+			}
+		}
+	}
+	if !found {
+		b.recordError("Pod %s/%s has no %s container, check that the Pod has the %s: \"true\" annotation", pod.Namespace, pod.Name, webhook.GcsFuseSidecarName, webhook.GcsFuseVolumeEnableAnnotation)
+	}
+
+	return nil
+}
+
+// addLogs adds the logs of the container to logs/NAMESPACE_POD/CONTAINER.log, or CONTAINER.previous.log for the previous instance.
+func (c *Collector) addLogs(ctx context.Context, b *bundle, pod *corev1.Pod, container string, previous bool) error {
+	opts := &corev1.PodLogOptions{Container: container, Previous: previous, Timestamps: true}
+	if c.Since > 0 {
+		opts.SinceSeconds = ptr.To(int64(c.Since / time.Second))
+	}
+	name := container + ".log"
+	if previous {
+		name = container + ".previous.log"
+	}
+
+	logs, err := c.Client.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, opts).DoRaw(ctx)
+	if err != nil {
+		b.recordError("failed to get the logs of container %s of Pod %s/%s: %v", name, pod.Namespace, pod.Name, err)
+
+		return nil
+	}
+
+	return b.add(path.Join("logs", pod.Namespace+"_"+pod.Name, name), logs)
+}
+
+// driverPod returns the driver node service Pod on the node.
+func (c *Collector) driverPod(ctx context.Context, nodeName string) (*corev1.Pod, error) {
+	list, err := c.Client.CoreV1().Pods(c.DriverNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: DriverNodeLabelSelector,
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the driver Pods in namespace %s: %w", c.DriverNamespace, err)
+	}
+	for i := range list.Items {
+		// The fake clientset used in tests ignores the field selector.
+		if list.Items[i].Spec.NodeName == nodeName {
+			return &list.Items[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("found no Pod with labels %s on node %s in namespace %s, set the driver namespace", DriverNodeLabelSelector, nodeName, c.DriverNamespace)
+}
+
+// nodeState runs a Pod with the driver image on the node of the Pod, which prints the node state of the Pod volumes,
+// and returns its logs. The Pod is deleted once its logs are read.
+func (c *Collector) nodeState(ctx context.Context, pod, driverPod *corev1.Pod) ([]byte, error) {
+	image := ""
+	for _, container := range driverPod.Spec.Containers {
+		if container.Name == driverContainerName {
+			image = container.Image
+		}
+	}
+	if image == "" {
+		return nil, fmt.Errorf("driver Pod %s/%s has no %s container", driverPod.Namespace, driverPod.Name, driverContainerName)
+	}
+
+	collector, err := c.Client.CoreV1().Pods(c.DriverNamespace).Create(ctx, nodeStatePod(c.DriverNamespace, pod.Spec.NodeName, image, pod.UID), metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the node state collector Pod: %w", err)
+	}
+	defer func() {
+		if err := c.Client.CoreV1().Pods(collector.Namespace).Delete(context.Background(), collector.Name, metav1.DeleteOptions{}); err != nil {
+			klog.Warningf("failed to delete the node state collector Pod %s/%s: %v", collector.Namespace, collector.Name, err)
+		}
+	}()
+
+	phase := corev1.PodPending
+	err = wait.PollUntilContextTimeout(ctx, nodeStatePollInterval, c.NodeStateTimeout, true, func(ctx context.Context) (bool, error) {
+		p, err := c.Client.CoreV1().Pods(collector.Namespace).Get(ctx, collector.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		phase = p.Status.Phase
+
+		return phase == corev1.PodSucceeded || phase == corev1.PodFailed, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("node state collector Pod %s/%s did not complete, last phase %q: %w", collector.Namespace, collector.Name, phase, err)
+	}
+
+	logs, err := c.Client.CoreV1().Pods(collector.Namespace).GetLogs(collector.Name, &corev1.PodLogOptions{}).DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the logs of the node state collector Pod %s/%s: %w", collector.Namespace, collector.Name, err)
+	}
+	if phase == corev1.PodFailed {
+		return nil, fmt.Errorf("node state collector Pod %s/%s failed: %s", collector.Namespace, collector.Name, logs)
+	}
+
+	return logs, nil
+}
+
+// nodeStatePod returns the Pod that prints the node state of the Pod with the UID. It runs the gcsfusecsi command
+// of the driver image in the host PID namespace to read the node mount table, and mounts the kubelet Pods directory
+// with the host mounts propagated, so that the state of the gcsfuse mounts is visible.
+func nodeStatePod(namespace, nodeName, image string, podUID types.UID) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "gcsfuse-collect-debug-",
+			Namespace:    namespace,
+			Labels:       map[string]string{"app": "gcsfuse-collect-debug"},
+		},
+		Spec: corev1.PodSpec{
+			NodeName:      nodeName,
+			HostPID:       true,
+			RestartPolicy: corev1.RestartPolicyNever,
+			Tolerations:   []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+			Containers: []corev1.Container{
+				{
+					Name:    "collect-debug",
+					Image:   image,
+					Command: []string{collectorBinary, "collect-debug", "--node-local", "--pod-uid=" + string(podUID)},
+					SecurityContext: &corev1.SecurityContext{
+						Privileged:             ptr.To(true),
+						ReadOnlyRootFilesystem: ptr.To(true),
+					},
+					VolumeMounts: []corev1.VolumeMount{
+						{
+							Name:             "kubelet-pods-dir",
+							MountPath:        KubeletPodsDir,
+							ReadOnly:         true,
+							MountPropagation: ptr.To(corev1.MountPropagationHostToContainer),
+						},
+					},
+				},
+			},
+			Volumes: []corev1.Volume{
+				{
+					Name: "kubelet-pods-dir",
+					VolumeSource: corev1.VolumeSource{
+						HostPath: &corev1.HostPathVolumeSource{Path: KubeletPodsDir, Type: ptr.To(corev1.HostPathDirectory)},
+					},
+				},
+			},
+		},
+	}
+}
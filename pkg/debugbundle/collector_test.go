@@ -0,0 +1,205 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debugbundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/webhook"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func readTarball(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to read the gzip stream: %v", err)
+	}
+	tr := tar.NewReader(gr)
+	files := map[string]string{}
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files
+		}
+		if err != nil {
+			t.Fatalf("failed to read the tarball: %v", err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("failed to read %q: %v", header.Name, err)
+		}
+		files[header.Name] = string(content)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	t.Parallel()
+
+	now := metav1.NewTime(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "test-ns", UID: "pod-uid"},
+		Spec: corev1.PodSpec{
+			NodeName:       "test-node",
+			InitContainers: []corev1.Container{{Name: webhook.GcsFuseSidecarName}},
+			Containers:     []corev1.Container{{Name: "workload"}},
+			Volumes: []corev1.Volume{
+				{Name: "data", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "test-pvc"}}},
+				{Name: "missing", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "missing-pvc"}}},
+			},
+		},
+		Status: corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{{Name: webhook.GcsFuseSidecarName, RestartCount: 1}},
+		},
+	}
+	objects := []runtime.Object{
+		pod,
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "test-ns", UID: "pvc-uid"},
+			Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: "test-pv"},
+		},
+		&corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "test-pv"}},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "gcsfusecsi-node-abc", Namespace: "gcs-fuse-csi-driver", Labels: map[string]string{"k8s-app": "gcs-fuse-csi-driver"}},
+			Spec:       corev1.PodSpec{NodeName: "test-node", Containers: []corev1.Container{{Name: driverContainerName, Image: "driver-image"}}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "gcsfusecsi-node-other", Namespace: "gcs-fuse-csi-driver", Labels: map[string]string{"k8s-app": "gcs-fuse-csi-driver"}},
+			Spec:       corev1.PodSpec{NodeName: "other-node"},
+		},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "e2", Namespace: "test-ns"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "test-pod", UID: "pod-uid"},
+			Type:           corev1.EventTypeWarning, Reason: "FailedMount", Message: "mount failed", Count: 3,
+			LastTimestamp: metav1.NewTime(now.Add(time.Minute)),
+		},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "e1", Namespace: "test-ns"},
+			InvolvedObject: corev1.ObjectReference{Kind: "PersistentVolumeClaim", Name: "test-pvc", UID: "pvc-uid"},
+			Type:           corev1.EventTypeNormal, Reason: "Bound", Message: "bound",
+			LastTimestamp: now,
+		},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "e3", Namespace: "test-ns"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "other-pod", UID: "other-uid"},
+			Reason:         "Scheduled",
+		},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "e4", Namespace: metav1.NamespaceDefault},
+			InvolvedObject: corev1.ObjectReference{Kind: "PersistentVolume", Name: "test-pv"},
+			Type:           corev1.EventTypeWarning, Reason: "VolumeFailedDelete", Message: "pv event",
+			LastTimestamp: metav1.NewTime(now.Add(-time.Minute)),
+		},
+	}
+
+	c := &Collector{Client: fake.NewSimpleClientset(objects...), DriverNamespace: "gcs-fuse-csi-driver", Since: time.Hour}
+	var buf bytes.Buffer
+	if err := c.Collect(context.Background(), &buf, "test-ns", "test-pod"); err != nil {
+		t.Fatalf("Collect() got error %v", err)
+	}
+	files := readTarball(t, buf.Bytes())
+
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	expectedNames := []string{
+		"errors.txt",
+		"events.txt",
+		"logs/gcs-fuse-csi-driver_gcsfusecsi-node-abc/gcs-fuse-csi-driver.log",
+		"logs/test-ns_test-pod/gke-gcsfuse-sidecar.log",
+		"logs/test-ns_test-pod/gke-gcsfuse-sidecar.previous.log",
+		"persistentvolumeclaims/test-pvc.yaml",
+		"persistentvolumes/test-pv.yaml",
+		"pod.yaml",
+	}
+	if diff := cmp.Diff(expectedNames, names); diff != "" {
+		t.Errorf("unexpected files diff (-want +got):\n%s", diff)
+	}
+
+	expectedEvents := "2024-05-01T11:59:00Z Warning VolumeFailedDelete PersistentVolume/test-pv (x1): pv event\n" +
+		"2024-05-01T12:00:00Z Normal Bound PersistentVolumeClaim/test-pvc (x1): bound\n" +
+		"2024-05-01T12:01:00Z Warning FailedMount Pod/test-pod (x3): mount failed\n"
+	if diff := cmp.Diff(expectedEvents, files["events.txt"]); diff != "" {
+		t.Errorf("unexpected events diff (-want +got):\n%s", diff)
+	}
+	if !strings.Contains(files["pod.yaml"], "kind: Pod") || !strings.Contains(files["pod.yaml"], "nodeName: test-node") {
+		t.Errorf("unexpected pod.yaml:\n%s", files["pod.yaml"])
+	}
+	if !strings.Contains(files["errors.txt"], "missing-pvc") {
+		t.Errorf("expected errors.txt to report the missing PersistentVolumeClaim, got:\n%s", files["errors.txt"])
+	}
+}
+
+func TestCollectUnscheduledPod(t *testing.T) {
+	t.Parallel()
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "test-ns"}}
+	c := &Collector{Client: fake.NewSimpleClientset(pod), DriverNamespace: "gcs-fuse-csi-driver", NodeState: true}
+	var buf bytes.Buffer
+	if err := c.Collect(context.Background(), &buf, "test-ns", "test-pod"); err != nil {
+		t.Fatalf("Collect() got error %v", err)
+	}
+
+	errs := readTarball(t, buf.Bytes())["errors.txt"]
+	for _, expected := range []string{"has no gke-gcsfuse-sidecar container", "is not scheduled to a node"} {
+		if !strings.Contains(errs, expected) {
+			t.Errorf("expected errors.txt to contain %q, got:\n%s", expected, errs)
+		}
+	}
+}
+
+func TestCollectMissingPod(t *testing.T) {
+	t.Parallel()
+
+	c := &Collector{Client: fake.NewSimpleClientset()}
+	if err := c.Collect(context.Background(), io.Discard, "test-ns", "test-pod"); err == nil {
+		t.Error("Collect() got nil error for a missing Pod")
+	}
+}
+
+func TestNodeStatePod(t *testing.T) {
+	t.Parallel()
+
+	pod := nodeStatePod("gcs-fuse-csi-driver", "test-node", "driver-image", "pod-uid")
+	if pod.Spec.NodeName != "test-node" || !pod.Spec.HostPID {
+		t.Errorf("expected the Pod to run on the node in the host PID namespace, got %+v", pod.Spec)
+	}
+	expectedCommand := []string{"/gcsfusecsi", "collect-debug", "--node-local", "--pod-uid=pod-uid"}
+	if diff := cmp.Diff(expectedCommand, pod.Spec.Containers[0].Command); diff != "" {
+		t.Errorf("unexpected command diff (-want +got):\n%s", diff)
+	}
+	if pod.Spec.Containers[0].Image != "driver-image" {
+		t.Errorf("got image %q, want %q", pod.Spec.Containers[0].Image, "driver-image")
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debugbundle
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/webhook"
+)
+
+// maxStateFileSize is the size up to which the contents of the files in the sidecar volume directories are printed,
+// which covers the error and sidecar version files.
+const maxStateFileSize = 4096
+
+// WriteNodeState prints the node state of the CSI volumes of the Pod with the UID: the gcsfuse entries of the mount table,
+// and for each volume, whether its target path is accessible, and the socket, error and sidecar version files
+// that the driver and the sidecar container share in the sidecar emptyDir volume.
+func WriteNodeState(w io.Writer, mountInfoPath, kubeletPodsDir, podUID string) error {
+	if podUID == "" {
+		return errors.New("the Pod UID cannot be empty")
+	}
+
+	fmt.Fprintf(w, "== gcsfuse mounts in %s ==\n", mountInfoPath)
+	if err := writeMounts(w, mountInfoPath, podUID); err != nil {
+		fmt.Fprintf(w, "error: %v\n", err)
+	}
+
+	podDir := filepath.Join(kubeletPodsDir, podUID)
+	csiDir := filepath.Join(podDir, "volumes", "kubernetes.io~csi")
+	fmt.Fprintf(w, "\n== CSI volumes in %s ==\n", csiDir)
+	entries, err := os.ReadDir(csiDir)
+	if err != nil {
+		fmt.Fprintf(w, "error: %v\n", err)
+
+		return nil
+	}
+
+	for _, e := range entries {
+		targetPath := filepath.Join(csiDir, e.Name(), "mount")
+		fmt.Fprintf(w, "\nvolume %s:\n", e.Name())
+		if _, err := os.Stat(targetPath); err != nil {
+			fmt.Fprintf(w, "  target path: %v\n", err)
+		} else {
+			fmt.Fprintf(w, "  target path: %s is accessible\n", targetPath)
+		}
+		if data, err := os.ReadFile(filepath.Join(csiDir, e.Name(), "vol_data.json")); err == nil {
+			fmt.Fprintf(w, "  vol_data.json: %s\n", strings.TrimSpace(string(data)))
+		}
+
+		sidecarDir := util.GetSidecarEmptyDirPath(targetPath, webhook.SidecarContainerTmpVolumeName)
+		files, err := os.ReadDir(sidecarDir)
+		if err != nil {
+			fmt.Fprintf(w, "  sidecar directory: %v\n", err)
+
+			continue
+		}
+		for _, f := range files {
+			writeStateFile(w, filepath.Join(sidecarDir, f.Name()), f)
+		}
+	}
+
+	return nil
+}
+
+// writeMounts prints the mount table entries of the gcsfuse mounts, and of the other mounts of the Pod.
+func writeMounts(w io.Writer, mountInfoPath, podUID string) error {
+	f, err := os.Open(mountInfoPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.Contains(line, " - fuse.gcsfuse ") || strings.Contains(line, "/"+podUID+"/") {
+			fmt.Fprintln(w, line)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// writeStateFile prints the type of a file in the sidecar volume directory, and the contents of the small regular files.
+func writeStateFile(w io.Writer, path string, entry fs.DirEntry) {
+	info, err := entry.Info()
+	if err != nil {
+		fmt.Fprintf(w, "  %s: %v\n", entry.Name(), err)
+
+		return
+	}
+
+	switch mode := info.Mode(); {
+	case mode&fs.ModeSocket != 0:
+		fmt.Fprintf(w, "  %s: socket, modified %s\n", entry.Name(), info.ModTime().UTC().Format(time.RFC3339))
+	case mode.IsRegular() && info.Size() <= maxStateFileSize:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(w, "  %s: %v\n", entry.Name(), err)
+
+			return
+		}
+		fmt.Fprintf(w, "  %s: %q\n", entry.Name(), strings.TrimSpace(string(data)))
+	default:
+		fmt.Fprintf(w, "  %s: %s, %d bytes\n", entry.Name(), mode.Type(), info.Size())
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debugbundle
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteNodeState(t *testing.T) {
+	t.Parallel()
+
+	// t.TempDir paths are too long for the Unix socket of the volume, so the test uses a shorter directory.
+	dir, err := os.MkdirTemp("", "ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	mountInfo := filepath.Join(dir, "mountinfo")
+	mountTable := strings.Join([]string{
+		"22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw",
+		"100 22 0:50 / /var/lib/kubelet/pods/pod-uid/volumes/kubernetes.io~csi/data/mount rw,nosuid,nodev shared:50 - fuse.gcsfuse test-bucket rw,user_id=0",
+		"101 22 0:51 / /var/lib/kubelet/pods/pod-uid/volumes/kubernetes.io~empty-dir/gke-gcsfuse-tmp rw shared:51 - tmpfs tmpfs rw",
+	}, "\n")
+	if err := os.WriteFile(mountInfo, []byte(mountTable), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	podsDir := filepath.Join(dir, "p")
+	csiDir := filepath.Join(podsDir, "pod-uid", "volumes", "kubernetes.io~csi", "data")
+	sidecarDir := filepath.Join(podsDir, "pod-uid", "volumes", "kubernetes.io~empty-dir", "gke-gcsfuse-tmp", ".volumes", "data")
+	for _, d := range []string{filepath.Join(csiDir, "mount"), sidecarDir} {
+		if err := os.MkdirAll(d, 0o750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(csiDir, "vol_data.json"), []byte(`{"driverName":"gcsfuse.csi.storage.gke.io"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sidecarDir, "error"), []byte("gcsfuse exited with error: bucket not found\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("unix", filepath.Join(sidecarDir, "socket"))
+	if err != nil {
+		t.Fatalf("failed to create the volume socket: %v", err)
+	}
+	defer l.Close()
+
+	var buf bytes.Buffer
+	if err := WriteNodeState(&buf, mountInfo, podsDir, "pod-uid"); err != nil {
+		t.Fatalf("WriteNodeState() got error %v", err)
+	}
+	out := buf.String()
+
+	for _, expected := range []string{
+		"fuse.gcsfuse test-bucket",
+		"kubernetes.io~empty-dir/gke-gcsfuse-tmp rw",
+		"volume data:",
+		"is accessible",
+		`vol_data.json: {"driverName":"gcsfuse.csi.storage.gke.io"}`,
+		`error: "gcsfuse exited with error: bucket not found"`,
+		"socket: socket, modified",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected the node state to contain %q, got:\n%s", expected, out)
+		}
+	}
+	if strings.Contains(out, "/dev/sda1") {
+		t.Errorf("expected the node state to skip the other mounts, got:\n%s", out)
+	}
+}
+
+func TestWriteNodeStateMissingPod(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	if err := WriteNodeState(&buf, filepath.Join(t.TempDir(), "mountinfo"), t.TempDir(), "pod-uid"); err != nil {
+		t.Fatalf("WriteNodeState() got error %v", err)
+	}
+	if !strings.Contains(buf.String(), "no such file or directory") {
+		t.Errorf("expected the node state to report the missing directories, got:\n%s", buf.String())
+	}
+
+	if err := WriteNodeState(&buf, "", "", ""); err == nil {
+		t.Error("WriteNodeState() got nil error for an empty Pod UID")
+	}
+}
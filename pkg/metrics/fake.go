@@ -17,10 +17,62 @@ limitations under the License.
 
 package metrics
 
-type FakeMetricsManager struct{}
+import (
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+type FakeMetricsManager struct {
+	ProvisioningOperations []string
+	BucketCreationFailures []string
+	OrphanedBuckets        int
+	UnmountFlushes         []string
+	NodeGcsfuseMemory      int64
+	MemoryBudgetRejections int
+	NodeFUSESupported      *bool
+}
 
 func (*FakeMetricsManager) InitializeHTTPHandler() {}
 
 func (*FakeMetricsManager) RegisterMetricsCollector(_, _, _, _ string) {}
 
 func (*FakeMetricsManager) UnregisterMetricsCollector(_ string) {}
+
+func (*FakeMetricsManager) RegisterBuildInfo(_, _, _ string) {}
+
+func (*FakeMetricsManager) RegisterProvisioningMetrics() {}
+
+func (m *FakeMetricsManager) RecordProvisioningOperation(operation string, _ time.Duration, code codes.Code) {
+	m.ProvisioningOperations = append(m.ProvisioningOperations, operation+" "+code.String())
+}
+
+func (m *FakeMetricsManager) RecordBucketCreationFailure(errorCode string) {
+	m.BucketCreationFailures = append(m.BucketCreationFailures, errorCode)
+}
+
+func (m *FakeMetricsManager) RecordOrphanedBuckets(count int) {
+	m.OrphanedBuckets = count
+}
+
+func (*FakeMetricsManager) RegisterUnmountMetrics() {}
+
+func (m *FakeMetricsManager) RecordUnmountFlush(podNamespace, volumeName string, _ time.Duration) {
+	m.UnmountFlushes = append(m.UnmountFlushes, podNamespace+"/"+volumeName)
+}
+
+func (*FakeMetricsManager) RegisterNodeMemoryMetrics() {}
+
+func (m *FakeMetricsManager) RecordNodeGcsfuseMemory(bytes, _ int64) {
+	m.NodeGcsfuseMemory = bytes
+}
+
+func (m *FakeMetricsManager) RecordGcsfuseMemoryBudgetRejection() {
+	m.MemoryBudgetRejections++
+}
+
+func (*FakeMetricsManager) RegisterNodeFUSEMetrics() {}
+
+func (m *FakeMetricsManager) RecordNodeFUSESupported(supported bool) {
+	m.NodeFUSESupported = &supported
+}
@@ -21,19 +21,25 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/clientset"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/webhook"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
+	"google.golang.org/grpc/codes"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 )
@@ -43,12 +49,33 @@ const (
 
 	metricsPath = "/metrics"
 	unixURL     = "http://unix/"
+
+	ephemeralStorageUsageMetricName = "gke_gcsfuse_csi_sidecar_ephemeral_storage_usage_bytes"
+	// ephemeralStorageUsageWarningThreshold is the fraction of the sidecar container ephemeral storage limit
+	// that a volume can use before a warning event is recorded on the Pod.
+	ephemeralStorageUsageWarningThreshold = 0.8
+	eventReasonEphemeralStorageUsageHigh  = "GCSFuseEphemeralStorageUsageHigh"
+	// dirUsageRefreshInterval is how long the disk usage of the buffer and cache volumes is reused across scrapes,
+	// because walking a large file cache on every scrape is expensive.
+	dirUsageRefreshInterval = time.Minute
 )
 
 type Manager interface {
 	InitializeHTTPHandler()
 	RegisterMetricsCollector(targetPath, podNamespace, podName, bucketName string)
 	UnregisterMetricsCollector(targetPath string)
+	RegisterBuildInfo(driverVersion, gcsfuseVersion, sidecarImage string)
+	RegisterProvisioningMetrics()
+	RecordProvisioningOperation(operation string, duration time.Duration, code codes.Code)
+	RecordBucketCreationFailure(errorCode string)
+	RecordOrphanedBuckets(count int)
+	RegisterUnmountMetrics()
+	RecordUnmountFlush(podNamespace, volumeName string, duration time.Duration)
+	RegisterNodeMemoryMetrics()
+	RecordNodeGcsfuseMemory(bytes, budgetBytes int64)
+	RecordGcsfuseMemoryBudgetRejection()
+	RegisterNodeFUSEMetrics()
+	RecordNodeFUSESupported(supported bool)
 }
 
 type manager struct {
@@ -56,6 +83,16 @@ type manager struct {
 	metricsEndpoint string
 	fuseSocketDir   string
 	clientset       clientset.Interface
+
+	provisioningOperationDuration *prometheus.HistogramVec
+	bucketCreationFailures        *prometheus.CounterVec
+	quotaExhaustedOperations      *prometheus.CounterVec
+	orphanedBuckets               prometheus.Gauge
+	unmountFlushDuration          *prometheus.HistogramVec
+	nodeGcsfuseMemory             prometheus.Gauge
+	nodeGcsfuseMemoryBudget       prometheus.Gauge
+	memoryBudgetRejections        prometheus.Counter
+	nodeFUSESupported             prometheus.Gauge
 }
 
 func NewMetricsManager(metricsEndpoint, fuseSocketDir string, clientset clientset.Interface) Manager {
@@ -64,6 +101,44 @@ func NewMetricsManager(metricsEndpoint, fuseSocketDir string, clientset clientse
 		metricsEndpoint: metricsEndpoint,
 		fuseSocketDir:   fuseSocketDir,
 		clientset:       clientset,
+		provisioningOperationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "gke_gcsfuse_csi_provisioning_operation_duration_seconds",
+			Help:    "The duration of the CreateVolume and DeleteVolume calls, labeled by the operation and the returned gRPC code.",
+			Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		}, []string{"operation", "grpc_code"}),
+		bucketCreationFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gke_gcsfuse_csi_bucket_creation_failures_total",
+			Help: "The number of failed GCS bucket creations, labeled by the GCS error code.",
+		}, []string{"error_code"}),
+		quotaExhaustedOperations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gke_gcsfuse_csi_provisioning_quota_exhausted_total",
+			Help: "The number of CreateVolume and DeleteVolume calls that failed because a GCS quota or rate limit was exceeded.",
+		}, []string{"operation"}),
+		orphanedBuckets: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "gke_gcsfuse_csi_orphaned_buckets",
+			Help: "The number of GCS buckets provisioned by the controller for PersistentVolumes that no longer exist, found by the last garbage collection.",
+		}),
+		unmountFlushDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "gke_gcsfuse_csi_unmount_flush_duration_seconds",
+			Help:    "How long gcsfuse took to flush the pending writes of the volumes to GCS and exit after the sidecar container received SIGTERM, labeled by the Pod namespace and the volume name.",
+			Buckets: []float64{0.1, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300},
+		}, []string{"namespace", "volume_name"}),
+		nodeGcsfuseMemory: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "gke_gcsfuse_csi_node_gcsfuse_memory_bytes",
+			Help: "The total memory used by the sidecar containers that run gcsfuse for the Pods with volumes on the node.",
+		}),
+		nodeGcsfuseMemoryBudget: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "gke_gcsfuse_csi_node_gcsfuse_memory_budget_bytes",
+			Help: "The total gcsfuse memory above which the node refuses to mount new volumes, or 0 if there is no budget.",
+		}),
+		memoryBudgetRejections: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "gke_gcsfuse_csi_node_gcsfuse_memory_budget_rejections_total",
+			Help: "The number of volume mounts that the node refused because the gcsfuse memory on the node exceeded the budget.",
+		}),
+		nodeFUSESupported: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "gke_gcsfuse_csi_node_fuse_supported",
+			Help: "1 if the startup check of the node service found the FUSE device, the FUSE file system, the kernel and the mount capability that gcsfuse volumes need, 0 otherwise.",
+		}),
 	}
 
 	return mm
@@ -108,7 +183,11 @@ func (mm *manager) RegisterMetricsCollector(targetPath, podNamespace, podName, b
 	}
 
 	podUID, volumeName, _ := util.ParsePodIDVolumeFromTargetpath(targetPath)
-	c := NewMetricsCollector(socketBasePath, emptyDirBasePath, podNamespace, podName, podUID, volumeName, map[string]string{
+	usageDirs := map[string]string{
+		"buffer": util.GetSidecarEmptyDirPath(targetPath, webhook.SidecarContainerBufferVolumeName),
+		"cache":  util.GetSidecarEmptyDirPath(targetPath, webhook.SidecarContainerCacheVolumeName),
+	}
+	c := NewMetricsCollector(socketBasePath, emptyDirBasePath, usageDirs, podNamespace, podName, podUID, volumeName, map[string]string{
 		"pod_name":       podName,
 		"namespace_name": podNamespace,
 		"volume_name":    volumeName,
@@ -125,14 +204,109 @@ func (mm *manager) UnregisterMetricsCollector(targetPath string) {
 	podUID, volumeName, _ := util.ParsePodIDVolumeFromTargetpath(targetPath)
 
 	// metricsCollector uses a hash of pod UID and volume name as an identifier.
-	c := NewMetricsCollector("", "", "", "", podUID, volumeName, nil, nil)
+	c := NewMetricsCollector("", "", nil, "", "", podUID, volumeName, nil, nil)
 	if ok := mm.registry.Unregister(c); !ok {
 		klog.Infof("Unregister metrics collector for targetPath %q is not needed since the collector is not registered", targetPath)
 	}
 }
 
+// RegisterBuildInfo registers a metric that reports the driver version, the bundled gcsfuse version, and the sidecar container image,
+// so that fleet operators can inventory version skew across node pools.
+func (mm *manager) RegisterBuildInfo(driverVersion, gcsfuseVersion, sidecarImage string) {
+	buildInfo := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gke_gcsfuse_csi_build_info",
+		Help: "A metric with a constant '1' value labeled by the driver version, the gcsfuse version, and the sidecar container image.",
+		ConstLabels: prometheus.Labels{
+			"driver_version":  driverVersion,
+			"gcsfuse_version": gcsfuseVersion,
+			"sidecar_image":   sidecarImage,
+		},
+	})
+	buildInfo.Set(1)
+	if err := mm.registry.Register(buildInfo); err != nil {
+		klog.Errorf("failed to register the build info metric: %v", err)
+	}
+}
+
+// RegisterProvisioningMetrics registers the metrics of the controller provisioning operations,
+// so that the provisioning SLO can be monitored separately from the mount SLO.
+func (mm *manager) RegisterProvisioningMetrics() {
+	for _, c := range []prometheus.Collector{mm.provisioningOperationDuration, mm.bucketCreationFailures, mm.quotaExhaustedOperations, mm.orphanedBuckets} {
+		if err := mm.registry.Register(c); err != nil {
+			klog.Errorf("failed to register the provisioning metrics: %v", err)
+		}
+	}
+}
+
+// RecordProvisioningOperation records the duration of a provisioning operation, and whether it exhausted a GCS quota.
+func (mm *manager) RecordProvisioningOperation(operation string, duration time.Duration, code codes.Code) {
+	mm.provisioningOperationDuration.WithLabelValues(operation, code.String()).Observe(duration.Seconds())
+	if code == codes.ResourceExhausted {
+		mm.quotaExhaustedOperations.WithLabelValues(operation).Inc()
+	}
+}
+
+// RecordBucketCreationFailure records a failed GCS bucket creation.
+func (mm *manager) RecordBucketCreationFailure(errorCode string) {
+	mm.bucketCreationFailures.WithLabelValues(errorCode).Inc()
+}
+
+// RecordOrphanedBuckets records the number of orphaned buckets found by the last garbage collection.
+func (mm *manager) RecordOrphanedBuckets(count int) {
+	mm.orphanedBuckets.Set(float64(count))
+}
+
+// RegisterUnmountMetrics registers the metrics of the node unmount operations.
+func (mm *manager) RegisterUnmountMetrics() {
+	if err := mm.registry.Register(mm.unmountFlushDuration); err != nil {
+		klog.Errorf("failed to register the unmount metrics: %v", err)
+	}
+}
+
+// RecordUnmountFlush records how long gcsfuse took to flush the pending writes of a volume after the sidecar container received SIGTERM.
+func (mm *manager) RecordUnmountFlush(podNamespace, volumeName string, duration time.Duration) {
+	mm.unmountFlushDuration.WithLabelValues(podNamespace, volumeName).Observe(duration.Seconds())
+}
+
+// RegisterNodeMemoryMetrics registers the metrics of the gcsfuse memory on the node.
+func (mm *manager) RegisterNodeMemoryMetrics() {
+	for _, c := range []prometheus.Collector{mm.nodeGcsfuseMemory, mm.nodeGcsfuseMemoryBudget, mm.memoryBudgetRejections} {
+		if err := mm.registry.Register(c); err != nil {
+			klog.Errorf("failed to register the node memory metrics: %v", err)
+		}
+	}
+}
+
+// RecordNodeGcsfuseMemory records the total gcsfuse memory on the node, and the node budget.
+func (mm *manager) RecordNodeGcsfuseMemory(bytes, budgetBytes int64) {
+	mm.nodeGcsfuseMemory.Set(float64(bytes))
+	mm.nodeGcsfuseMemoryBudget.Set(float64(budgetBytes))
+}
+
+// RecordGcsfuseMemoryBudgetRejection records a volume mount refused because of the node gcsfuse memory budget.
+func (mm *manager) RecordGcsfuseMemoryBudgetRejection() {
+	mm.memoryBudgetRejections.Inc()
+}
+
+// RegisterNodeFUSEMetrics registers the metrics of the node FUSE compatibility.
+func (mm *manager) RegisterNodeFUSEMetrics() {
+	if err := mm.registry.Register(mm.nodeFUSESupported); err != nil {
+		klog.Errorf("failed to register the node FUSE metrics: %v", err)
+	}
+}
+
+// RecordNodeFUSESupported records whether the node supports the FUSE mounts of gcsfuse volumes.
+func (mm *manager) RecordNodeFUSESupported(supported bool) {
+	if supported {
+		mm.nodeFUSESupported.Set(1)
+	} else {
+		mm.nodeFUSESupported.Set(0)
+	}
+}
+
 type metricsCollector struct {
 	emptyDirBasePath string
+	usageDirs        map[string]string
 	constLabels      map[string]string
 	namespace        string
 	podName          string
@@ -140,18 +314,29 @@ type metricsCollector struct {
 	volumeName       string
 	httpClient       *http.Client
 	clientset        clientset.Interface
+
+	ephemeralStorageWarned atomic.Bool
+
+	dirUsageMu              sync.Mutex
+	dirUsageRefreshInterval time.Duration
+	dirUsageUpdated         time.Time
+	dirUsageCache           map[string]int64
 }
 
-// NewMetricsCollector returns a new Collector exposing metrics read from the give path.
-func NewMetricsCollector(socketBasePath, emptyDirBasePath, namespace, podName, podUID, volumeName string, labels map[string]string, clientset clientset.Interface) prometheus.Collector {
+// NewMetricsCollector returns a new Collector exposing metrics read from the give path,
+// and the disk usage of the usageDirs, keyed by the directory label value.
+func NewMetricsCollector(socketBasePath, emptyDirBasePath string, usageDirs map[string]string, namespace, podName, podUID, volumeName string, labels map[string]string, clientset clientset.Interface) prometheus.Collector {
 	c := &metricsCollector{
 		emptyDirBasePath: emptyDirBasePath,
+		usageDirs:        usageDirs,
 		constLabels:      labels,
 		namespace:        namespace,
 		podName:          podName,
 		podUID:           podUID,
 		volumeName:       volumeName,
 		clientset:        clientset,
+
+		dirUsageRefreshInterval: dirUsageRefreshInterval,
 	}
 
 	// Creating a new HTTP client that is configured to make HTTP requests over a unix domain socket.
@@ -183,6 +368,8 @@ func (c *metricsCollector) Collect(ch chan<- prometheus.Metric) {
 		return
 	}
 
+	c.emitEphemeralStorageUsage(pod, ch)
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -219,6 +406,126 @@ func (c *metricsCollector) Collect(ch chan<- prometheus.Metric) {
 	}
 }
 
+// emitEphemeralStorageUsage emits the disk usage of the volume in the sidecar container buffer and cache volumes,
+// and records a warning event on the Pod when the usage exceeds the threshold of the sidecar container ephemeral storage limit,
+// so that users notice the write staging pressure before the Pod is evicted.
+func (c *metricsCollector) emitEphemeralStorageUsage(pod *corev1.Pod, ch chan<- prometheus.Metric) {
+	labelNames := []string{"directory"}
+	for n := range c.constLabels {
+		labelNames = append(labelNames, n)
+	}
+	desc := prometheus.NewDesc(ephemeralStorageUsageMetricName, "The disk usage of the volume in the sidecar container buffer and cache volumes.", labelNames, nil)
+
+	var total int64
+	for dir, usage := range c.usageDirsUsage() {
+		total += usage
+		labelValues := []string{dir}
+		for _, n := range labelNames[1:] {
+			labelValues = append(labelValues, c.constLabels[n])
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(usage), labelValues...)
+	}
+
+	limit := sidecarEphemeralStorageLimit(pod)
+	if limit <= 0 {
+		return
+	}
+
+	if float64(total) < ephemeralStorageUsageWarningThreshold*float64(limit) {
+		c.ephemeralStorageWarned.Store(false)
+
+		return
+	}
+
+	if !c.ephemeralStorageWarned.Swap(true) {
+		c.clientset.Eventf(pod, corev1.EventTypeWarning, eventReasonEphemeralStorageUsageHigh,
+			"Volume %q uses %v bytes of the sidecar container buffer and cache volumes, over %v%% of the sidecar container ephemeral storage limit %v bytes. Increase the limit using the Pod annotation %q, or use custom buffer or cache volumes, to avoid the Pod eviction.",
+			c.volumeName, total, ephemeralStorageUsageWarningThreshold*100, limit, "gke-gcsfuse/ephemeral-storage-limit")
+	}
+}
+
+// usageDirsUsage returns the disk usage of the usageDirs keyed by the directory label value.
+// The usage is recalculated at most once per dirUsageRefreshInterval, and directories that do not exist are omitted.
+func (c *metricsCollector) usageDirsUsage() map[string]int64 {
+	c.dirUsageMu.Lock()
+	defer c.dirUsageMu.Unlock()
+
+	if c.dirUsageCache != nil && time.Since(c.dirUsageUpdated) < c.dirUsageRefreshInterval {
+		return c.dirUsageCache
+	}
+
+	usages := make(map[string]int64, len(c.usageDirs))
+	for dir, dirPath := range c.usageDirs {
+		usage, err := dirUsage(dirPath)
+		if err != nil {
+			// Custom buffer or cache volumes that are not emptyDir volumes are not on the node ephemeral storage.
+			if !os.IsNotExist(err) {
+				klog.Errorf("failed to calculate the disk usage of %q: %v", dirPath, err)
+			}
+
+			continue
+		}
+		usages[dir] = usage
+	}
+	c.dirUsageCache = usages
+	c.dirUsageUpdated = time.Now()
+
+	return usages
+}
+
+// sidecarEphemeralStorageLimit returns the ephemeral storage limit of the sidecar container in bytes, or zero if it is not set.
+func sidecarEphemeralStorageLimit(pod *corev1.Pod) int64 {
+	for _, cs := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, container := range cs {
+			if container.Name == webhook.GcsFuseSidecarName {
+				return container.Resources.Limits.StorageEphemeral().Value()
+			}
+		}
+	}
+
+	return 0
+}
+
+// dirUsage returns the disk space allocated to the files under dirPath, which is what kubelet
+// counts against the ephemeral storage limit. Sparse files, such as partially downloaded
+// file cache entries, only count their allocated blocks. Files removed during the walk are skipped.
+func dirUsage(dirPath string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(dirPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p != dirPath && os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+
+				return err
+			}
+			total += allocatedBytes(info)
+		}
+
+		return nil
+	})
+
+	return total, err
+}
+
+// allocatedBytes returns the disk space allocated to the file, falling back to its size.
+func allocatedBytes(info fs.FileInfo) int64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return st.Blocks * 512
+	}
+
+	return info.Size()
+}
+
 // ProcessMetricsData processes metrics that follow Prometheus text format: https://prometheus.io/docs/instrumenting/exposition_formats/,
 // returning its MetricFamily.
 func ProcessMetricsData(metricsReader io.Reader) (map[string]*dto.MetricFamily, error) {
@@ -0,0 +1,113 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/clientset"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/webhook"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestEmitEphemeralStorageUsage(t *testing.T) {
+	t.Parallel()
+
+	bufferDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bufferDir, "staged-object"), make([]byte, 900), 0o600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(bufferDir, "staged-object"))
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+	// The usage is the allocated disk space, which is at least one block for the 900-byte file.
+	expectedUsage := float64(allocatedBytes(info))
+
+	fakeClientset := &clientset.FakeClientset{}
+	c, _ := NewMetricsCollector("", "", map[string]string{
+		"buffer": bufferDir,
+		"cache":  filepath.Join(t.TempDir(), "not-exist"),
+	}, "test-ns", "test-pod", "test-pod-uid", "test-volume", map[string]string{"volume_name": "test-volume"}, fakeClientset).(*metricsCollector)
+
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name: webhook.GcsFuseSidecarName,
+					Resources: corev1.ResourceRequirements{
+						Limits: corev1.ResourceList{corev1.ResourceEphemeralStorage: resource.MustParse("1000")},
+					},
+				},
+			},
+		},
+	}
+
+	// The warning event is only recorded once while the usage stays over the threshold.
+	for range 2 {
+		ch := make(chan prometheus.Metric, 10)
+		c.emitEphemeralStorageUsage(pod, ch)
+		close(ch)
+
+		var metrics []prometheus.Metric
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		if len(metrics) != 1 {
+			t.Fatalf("got %v metrics, expected 1", len(metrics))
+		}
+
+		m := &dto.Metric{}
+		if err := metrics[0].Write(m); err != nil {
+			t.Fatalf("failed to write metric: %v", err)
+		}
+		if m.GetGauge().GetValue() != expectedUsage {
+			t.Errorf("got usage %v, expected %v", m.GetGauge().GetValue(), expectedUsage)
+		}
+	}
+
+	if len(fakeClientset.Events) != 1 || !strings.Contains(fakeClientset.Events[0], eventReasonEphemeralStorageUsageHigh) {
+		t.Errorf("got events %v, expected one %v event", fakeClientset.Events, eventReasonEphemeralStorageUsageHigh)
+	}
+
+	// The usage is reused across scrapes within the refresh interval.
+	if err := os.Remove(filepath.Join(bufferDir, "staged-object")); err != nil {
+		t.Fatalf("failed to remove file: %v", err)
+	}
+	if usage := c.usageDirsUsage()["buffer"]; float64(usage) != expectedUsage {
+		t.Errorf("got cached usage %v, expected %v", usage, expectedUsage)
+	}
+
+	// The event is recorded again after the usage drops below the threshold and exceeds it again.
+	c.dirUsageRefreshInterval = 0
+	c.emitEphemeralStorageUsage(pod, make(chan prometheus.Metric, 10))
+	if err := os.WriteFile(filepath.Join(bufferDir, "staged-object"), make([]byte, 900), 0o600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	c.emitEphemeralStorageUsage(pod, make(chan prometheus.Metric, 10))
+
+	if len(fakeClientset.Events) != 2 {
+		t.Errorf("got events %v, expected two %v events", fakeClientset.Events, eventReasonEphemeralStorageUsageHigh)
+	}
+}
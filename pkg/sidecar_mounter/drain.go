@@ -0,0 +1,209 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecarmounter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DrainStatusPath is the path of the sidecar API that reports whether the sidecar mounter is draining,
+	// and the staged writes and open files of each volume.
+	DrainStatusPath = "/v1/drain/status"
+
+	drainReportInterval = 5 * time.Second
+)
+
+// VolumeDrainStatus is the progress of the uploads of a volume.
+type VolumeDrainStatus struct {
+	Volume string `json:"volume"`
+	// PendingUploads is the number of files that gcsfuse staged and has not uploaded yet.
+	PendingUploads int `json:"pendingUploads"`
+	// OpenFiles is the number of open file descriptors of the gcsfuse process, including the staged files.
+	OpenFiles int `json:"openFiles"`
+}
+
+// DrainStatus is the response of the drain status API.
+type DrainStatus struct {
+	Draining bool                `json:"draining"`
+	Volumes  []VolumeDrainStatus `json:"volumes"`
+}
+
+// drainStatus returns the progress of the uploads of the volumes that have a running gcsfuse process, sorted by volume name.
+func (m *Mounter) drainStatus() (DrainStatus, error) {
+	m.mu.Lock()
+	draining := m.draining
+	processes := maps.Clone(m.processes)
+	m.mu.Unlock()
+
+	status := DrainStatus{Draining: draining, Volumes: []VolumeDrainStatus{}}
+	for _, v := range slices.Sorted(maps.Keys(processes)) {
+		open, staged, err := m.openFiles(processes[v])
+		if err != nil {
+			// The process exited, so there is nothing left to upload.
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return status, fmt.Errorf("failed to list the open files of the gcsfuse process of volume %q: %w", v, err)
+		}
+		status.Volumes = append(status.Volumes, VolumeDrainStatus{Volume: v, PendingUploads: staged, OpenFiles: open})
+	}
+
+	return status, nil
+}
+
+// Drain blocks until the gcsfuse processes have uploaded all the staged writes, or ctx is done. While it waits, it logs
+// the progress of each volume, and writes it to the drain status file of the volume, so that the CSI driver can report
+// the writes that are lost if the sidecar container is killed when the termination grace period expires.
+func (m *Mounter) Drain(ctx context.Context) error {
+	m.mu.Lock()
+	m.draining = true
+	m.mu.Unlock()
+
+	// The status directories are kept, because the processes are forgotten once they exit.
+	statusDirs := map[string]string{}
+	err := wait.PollUntilContextCancel(ctx, drainReportInterval, true, func(context.Context) (bool, error) {
+		m.mu.Lock()
+		for v, p := range m.processes {
+			statusDirs[v] = p.statusDir
+		}
+		m.mu.Unlock()
+
+		status, err := m.drainStatus()
+		if err != nil {
+			return false, err
+		}
+
+		pending := map[string]VolumeDrainStatus{}
+		for _, s := range status.Volumes {
+			if s.PendingUploads > 0 {
+				pending[s.Volume] = s
+			}
+		}
+		for v, dir := range statusDirs {
+			s, ok := pending[v]
+			if !ok {
+				removeDrainStatus(dir)
+
+				continue
+			}
+			klog.Infof("[%v] waiting for gcsfuse to upload %d staged files, gcsfuse has %d open files", v, s.PendingUploads, s.OpenFiles)
+			writeDrainStatus(dir, s)
+		}
+
+		return len(pending) == 0, nil
+	})
+	if err != nil {
+		return fmt.Errorf("staged writes are not uploaded yet: %w", err)
+	}
+
+	return nil
+}
+
+// writeDrainStatus describes the staged writes of the volume in its drain status file, for the CSI driver to report.
+func writeDrainStatus(dir string, s VolumeDrainStatus) {
+	if dir == "" {
+		return
+	}
+
+	message := fmt.Sprintf("%d staged files were not uploaded, gcsfuse had %d open files", s.PendingUploads, s.OpenFiles)
+	if err := writeFileAtomically(filepath.Join(dir, util.DrainStatusFile), []byte(message)); err != nil {
+		klog.Warningf("failed to write the drain status of volume %q: %v", s.Volume, err)
+	}
+}
+
+// removeDrainStatus removes the drain status file of the volume, once its staged writes are uploaded.
+func removeDrainStatus(dir string) {
+	if dir == "" {
+		return
+	}
+
+	path := filepath.Join(dir, util.DrainStatusFile)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		klog.Warningf("failed to remove the drain status file %q: %v", path, err)
+	}
+}
+
+// Terminate records that the sidecar mounter received SIGTERM. Each gcsfuse process flushes the pending writes of its volume
+// to GCS before it exits, so the time from SIGTERM until it exited is the flush duration of the volume.
+func (m *Mounter) Terminate() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.terminatedAt.IsZero() {
+		m.terminatedAt = time.Now()
+	}
+}
+
+// recordFlushDuration writes the flush duration of a volume, whose gcsfuse process exited, to its flush duration file
+// for the CSI driver to report. Nothing is written when gcsfuse exited before the sidecar mounter received SIGTERM.
+func (m *Mounter) recordFlushDuration(volumeName, dir string) {
+	m.mu.Lock()
+	terminatedAt := m.terminatedAt
+	m.mu.Unlock()
+
+	if terminatedAt.IsZero() || dir == "" {
+		return
+	}
+
+	duration := time.Since(terminatedAt).Round(time.Millisecond)
+	klog.Infof("[%v] gcsfuse flushed the pending writes and exited %v after SIGTERM", volumeName, duration)
+	if err := writeFileAtomically(filepath.Join(dir, util.FlushDurationFile), []byte(duration.String())); err != nil {
+		klog.Warningf("failed to write the flush duration of volume %q: %v", volumeName, err)
+	}
+}
+
+// removeFlushDuration removes the flush duration file that a previous run of the sidecar mounter left for the volume.
+func removeFlushDuration(dir string) {
+	if dir == "" {
+		return
+	}
+
+	path := filepath.Join(dir, util.FlushDurationFile)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		klog.Warningf("failed to remove the flush duration file %q: %v", path, err)
+	}
+}
+
+// handleDrainStatus responds with the drain status of the volumes in JSON.
+func (m *Mounter) handleDrainStatus(w http.ResponseWriter, _ *http.Request) {
+	status, err := m.drainStatus()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		klog.Warningf("failed to write the drain status: %v", err)
+	}
+}
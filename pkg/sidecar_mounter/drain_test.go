@@ -0,0 +1,151 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecarmounter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+)
+
+func TestHandleDrainStatus(t *testing.T) {
+	t.Parallel()
+
+	m := newFakeMounter(t)
+	m.draining = true
+	w := httptest.NewRecorder()
+	m.handleDrainStatus(w, httptest.NewRequest(http.MethodGet, DrainStatusPath, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %v, expected %v: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	status := DrainStatus{}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to decode the drain status %q: %v", w.Body.String(), err)
+	}
+	expected := DrainStatus{
+		Draining: true,
+		Volumes: []VolumeDrainStatus{
+			{Volume: "busy", PendingUploads: 2, OpenFiles: 3},
+			{Volume: "idle", PendingUploads: 0, OpenFiles: 2},
+		},
+	}
+	if diff := cmp.Diff(expected, status); diff != "" {
+		t.Errorf("unexpected drain status (-want +got):\n%s", diff)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	t.Parallel()
+
+	m := newFakeMounter(t)
+	statusDir := t.TempDir()
+	busy := m.processes["busy"]
+	busy.statusDir = statusDir
+	m.processes["busy"] = busy
+	statusFile := filepath.Join(statusDir, util.DrainStatusFile)
+
+	// The busy volume uploads its staged writes once the drain status is reported.
+	go func() {
+		for {
+			if _, err := os.Stat(statusFile); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		for _, fd := range []string{"1", "2"} {
+			_ = os.Remove(filepath.Join(m.procDir, "200", "fd", fd))
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := m.Drain(ctx); err != nil {
+		t.Fatalf("expected the staged writes to be uploaded, got error %v", err)
+	}
+	if _, err := os.Stat(statusFile); !os.IsNotExist(err) {
+		t.Errorf("expected the drain status file to be removed, got error %v", err)
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	t.Parallel()
+
+	m := newFakeMounter(t)
+	statusDir := t.TempDir()
+	busy := m.processes["busy"]
+	busy.statusDir = statusDir
+	m.processes["busy"] = busy
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := m.Drain(ctx); err == nil {
+		t.Fatal("expected an error while the staged writes are not uploaded")
+	}
+
+	status, err := os.ReadFile(filepath.Join(statusDir, util.DrainStatusFile))
+	if err != nil {
+		t.Fatalf("failed to read the drain status file: %v", err)
+	}
+	if expected := "2 staged files were not uploaded, gcsfuse had 3 open files"; string(status) != expected {
+		t.Errorf("got drain status %q, expected %q", status, expected)
+	}
+}
+
+func TestRecordFlushDuration(t *testing.T) {
+	t.Parallel()
+
+	m := newFakeMounter(t)
+	statusDir := t.TempDir()
+	flushDurationFile := filepath.Join(statusDir, util.FlushDurationFile)
+
+	// gcsfuse exited before SIGTERM, so there was no flush to report.
+	m.recordFlushDuration("busy", statusDir)
+	if _, err := os.Stat(flushDurationFile); !os.IsNotExist(err) {
+		t.Fatalf("expected no flush duration file before SIGTERM, got error %v", err)
+	}
+
+	m.Terminate()
+	terminatedAt := m.terminatedAt
+	m.Terminate()
+	if m.terminatedAt != terminatedAt {
+		t.Errorf("expected the first SIGTERM to be kept, got %v, expected %v", m.terminatedAt, terminatedAt)
+	}
+
+	m.recordFlushDuration("busy", statusDir)
+	content, err := os.ReadFile(flushDurationFile)
+	if err != nil {
+		t.Fatalf("failed to read the flush duration file: %v", err)
+	}
+	if duration, err := time.ParseDuration(string(content)); err != nil || duration < 0 || duration > time.Minute {
+		t.Errorf("got flush duration %q, expected a duration since SIGTERM: %v", content, err)
+	}
+
+	removeFlushDuration(statusDir)
+	if _, err := os.Stat(flushDurationFile); !os.IsNotExist(err) {
+		t.Errorf("expected the flush duration file to be removed, got error %v", err)
+	}
+}
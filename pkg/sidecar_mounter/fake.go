@@ -0,0 +1,131 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecarmounter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// FakeGcsfuseInvocation is a gcsfuse process started by FakeMountSyscalls.
+type FakeGcsfuseInvocation struct {
+	Path string
+	Args []string
+	FD   int
+}
+
+// FakeMountSyscalls serves the mount config messages of the volume sockets from memory, and records the gcsfuse invocations
+// instead of starting them. The fake gcsfuse processes run until the context of the mount is done.
+type FakeMountSyscalls struct {
+	// Messages maps the socket paths to the mount config messages that the CSI driver sends.
+	Messages map[string][]byte
+	// StartErr is returned by StartGcsfuse if it is set.
+	StartErr error
+
+	mu          sync.Mutex
+	nextFD      int
+	handshakes  map[string][]byte
+	invocations []FakeGcsfuseInvocation
+	closedFDs   []int
+}
+
+// NewFakeMountSyscalls returns a FakeMountSyscalls that serves the messages of the socket paths.
+func NewFakeMountSyscalls(messages map[string][]byte) *FakeMountSyscalls {
+	return &FakeMountSyscalls{
+		Messages:   messages,
+		nextFD:     100,
+		handshakes: map[string][]byte{},
+	}
+}
+
+func (f *FakeMountSyscalls) ReceiveMount(socketPath string, handshake []byte) (int, []byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	msg, ok := f.Messages[socketPath]
+	if !ok {
+		return 0, nil, fmt.Errorf("failed to connect to the socket %q: %w", socketPath, os.ErrNotExist)
+	}
+	f.handshakes[socketPath] = handshake
+	f.nextFD++
+
+	return f.nextFD, msg, nil
+}
+
+func (f *FakeMountSyscalls) StartGcsfuse(ctx context.Context, path string, args []string, fd int, _, _ io.Writer) (Process, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.StartErr != nil {
+		return nil, f.StartErr
+	}
+	f.invocations = append(f.invocations, FakeGcsfuseInvocation{Path: path, Args: args, FD: fd})
+
+	return &fakeGcsfuseProcess{ctx: ctx, pid: 1000 + len(f.invocations)}, nil
+}
+
+func (f *FakeMountSyscalls) CloseFD(fd int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closedFDs = append(f.closedFDs, fd)
+
+	return nil
+}
+
+// Handshake returns the handshake sent to the socket path.
+func (f *FakeMountSyscalls) Handshake(socketPath string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.handshakes[socketPath]
+}
+
+// Invocations returns the gcsfuse invocations in the order they were started.
+func (f *FakeMountSyscalls) Invocations() []FakeGcsfuseInvocation {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]FakeGcsfuseInvocation{}, f.invocations...)
+}
+
+// ClosedFDs returns the file descriptors that were closed.
+func (f *FakeMountSyscalls) ClosedFDs() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]int{}, f.closedFDs...)
+}
+
+type fakeGcsfuseProcess struct {
+	ctx context.Context
+	pid int
+}
+
+func (p *fakeGcsfuseProcess) Pid() int {
+	return p.pid
+}
+
+func (p *fakeGcsfuseProcess) Wait() error {
+	<-p.ctx.Done()
+
+	return nil
+}
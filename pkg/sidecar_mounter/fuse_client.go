@@ -0,0 +1,53 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecarmounter
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// FUSEClient is the FUSE client that the sidecar mounter starts for each volume, with the /dev/fuse file descriptor
+// that the CSI driver sends. The sidecar mounter receives the volumes, serves the tokens and tracks the processes,
+// and the FUSE client translates the mount options of the volume into its own arguments and config files.
+type FUSEClient interface {
+	// Name is the name of the FUSE client, which the --fuse-client flag selects.
+	Name() string
+	// Configure translates the mount options of the volume into the arguments of the FUSE client,
+	// and writes its config files.
+	Configure(mc *MountConfig) error
+	// Args returns the command line arguments of the FUSE client for the configured volume.
+	// The /dev/fuse file descriptor is passed as file descriptor 3.
+	Args(mc *MountConfig) []string
+}
+
+// fuseClients are the FUSE clients that the sidecar mounter can start, by name.
+var fuseClients = map[string]FUSEClient{
+	GcsfuseClientName: gcsfuseClient{},
+}
+
+// NewFUSEClient returns the FUSE client with the name.
+func NewFUSEClient(name string) (FUSEClient, error) {
+	client, ok := fuseClients[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown FUSE client %q, the supported FUSE clients are %q", name, slices.Sorted(maps.Keys(fuseClients)))
+	}
+
+	return client, nil
+}
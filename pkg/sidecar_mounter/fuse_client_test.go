@@ -0,0 +1,56 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecarmounter
+
+import (
+	"testing"
+)
+
+func TestNewFUSEClient(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name      string
+		client    string
+		expectErr bool
+	}{
+		{
+			name:   "gcsfuse",
+			client: GcsfuseClientName,
+		},
+		{
+			name:      "unknown client",
+			client:    "s3fs",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			client, err := NewFUSEClient(tc.client)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectErr)
+			}
+			if !tc.expectErr && client.Name() != tc.client {
+				t.Errorf("got FUSE client %q, expected %q", client.Name(), tc.client)
+			}
+		})
+	}
+}
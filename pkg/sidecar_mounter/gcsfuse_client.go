@@ -0,0 +1,301 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecarmounter
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
+)
+
+// GcsfuseClientName is the name of the gcsfuse FUSE client, the default of the sidecar mounter.
+const GcsfuseClientName = "gcsfuse"
+
+// gcsfuseClient translates the mount options of the volumes into the gcsfuse flags and config file.
+// All the gcsfuse specific flag handling of the sidecar mounter is in this file.
+type gcsfuseClient struct{}
+
+func (gcsfuseClient) Name() string {
+	return GcsfuseClientName
+}
+
+func (gcsfuseClient) Configure(mc *MountConfig) error {
+	mc.prepareMountArgs()
+	if err := mc.prepareConfigFile(); err != nil {
+		return fmt.Errorf("failed to create config file %q: %w", mc.ConfigFile, err)
+	}
+
+	return nil
+}
+
+func (gcsfuseClient) Args(mc *MountConfig) []string {
+	return mc.gcsfuseArgs()
+}
+
+var prometheusPort = 62990
+
+var disallowedFlags = map[string]bool{
+	"temp-dir":                             true,
+	"config-file":                          true,
+	"foreground":                           true,
+	"log-file":                             true,
+	"log-format":                           true,
+	"key-file":                             true,
+	"token-url":                            true,
+	"reuse-token-from-url":                 true,
+	"o":                                    true,
+	"logging:log-rotate:max-file-size-mb":  true,
+	"logging:log-rotate:backup-file-count": true,
+	"logging:log-rotate:compress":          true,
+	"cache-dir":                            true,
+	"experimental-local-file-cache":        true,
+	"prometheus-port":                      true,
+}
+
+var boolFlags = map[string]bool{
+	"implicit-dirs":                 true,
+	"enable-hns":                    true,
+	"enable-nonexistent-type-cache": true,
+	"debug_fuse_errors":             true,
+	"debug_fuse":                    true,
+	"debug_fs":                      true,
+	"debug_gcs":                     true,
+	"debug_http":                    true,
+	"debug_invariants":              true,
+	"debug_mutex":                   true,
+}
+
+// gcsfuseArgs returns the command line arguments of gcsfuse, with the flags sorted by name.
+func (mc *MountConfig) gcsfuseArgs() []string {
+	args := []string{}
+	for _, k := range slices.Sorted(maps.Keys(mc.FlagMap)) {
+		args = append(args, "--"+k)
+		if v := mc.FlagMap[k]; v != "" {
+			args = append(args, v)
+		}
+	}
+
+	args = append(args, mc.BucketName)
+	// gcsfuse supports the `/dev/fd/N` syntax
+	// the /dev/fuse is passed as ExtraFiles, and will always be FD 3
+	args = append(args, "/dev/fd/3")
+
+	return args
+}
+
+func (mc *MountConfig) prepareMountArgs() {
+	flagMap := map[string]string{
+		"app-name":    GCSFuseAppName,
+		"temp-dir":    mc.BufferDir + TempDir,
+		"config-file": mc.ConfigFile,
+		"foreground":  "",
+		"uid":         "0",
+		"gid":         "0",
+	}
+
+	configFileFlagMap := map[string]string{
+		"logging:file-path": "/dev/fd/1", // redirect the output to cmd stdout
+		"logging:format":    "json",
+		"cache-dir":         "", // by default the gcsfuse file cache is disabled on GKE
+	}
+
+	invalidArgs := []string{}
+
+	for _, arg := range mc.Options {
+		if strings.Contains(arg, ":") && !strings.Contains(arg, "https") {
+			i := strings.LastIndex(arg, ":")
+			f, v := arg[:i], arg[i+1:]
+
+			if f == util.DisableMetricsForGKE {
+				if v == util.FalseStr {
+					flagMap["prometheus-port"] = strconv.Itoa(prometheusPort)
+					// Use a new port each gcsfuse instance that we start.
+					prometheusPort++
+				}
+
+				continue
+			}
+
+			if disallowedFlags[f] {
+				invalidArgs = append(invalidArgs, arg)
+			} else {
+				configFileFlagMap[f] = v
+			}
+
+			// if the value of flag file-cache:max-size-mb is not 0,
+			// enable the file cache feature by passing the cache directory.
+			if f == "file-cache:max-size-mb" && v != "0" {
+				configFileFlagMap["cache-dir"] = mc.CacheDir
+			}
+
+			continue
+		}
+
+		argPair := strings.SplitN(arg, "=", 2)
+		if len(argPair) == 0 {
+			continue
+		}
+
+		flag := argPair[0]
+		if disallowedFlags[flag] {
+			invalidArgs = append(invalidArgs, arg)
+
+			continue
+		}
+
+		value := ""
+		if len(argPair) > 1 {
+			value = argPair[1]
+		}
+
+		if flag == identityProviderFlag {
+			mc.TokenServerIdentityProvider = value
+
+			continue
+		}
+
+		if flag == readOnlyTokenFlag {
+			mc.TokenServerReadOnly = true
+
+			continue
+		}
+
+		if flag == impersonateFlag {
+			mc.TokenServerServiceAccount = value
+
+			continue
+		}
+
+		if flag == util.WorkloadRecommendations {
+			mc.WorkloadRecommendations = value == util.TrueStr
+
+			continue
+		}
+
+		if flag == tokenRefreshFlag {
+			if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+				mc.TokenServerRefreshInterval = time.Duration(secs) * time.Second
+			} else {
+				invalidArgs = append(invalidArgs, arg)
+			}
+
+			continue
+		}
+
+		switch {
+		case boolFlags[flag] && value != "":
+			flag = flag + "=" + value
+			if value == util.TrueStr || value == util.FalseStr {
+				value = ""
+			} else {
+				invalidArgs = append(invalidArgs, flag)
+
+				continue
+			}
+		case strings.HasPrefix(flag, "experimental-") && (value == util.TrueStr || value == util.FalseStr):
+			// Experimental bool flags are not known in advance, so pass the value in the "--flag=value" form.
+			flag = flag + "=" + value
+			value = ""
+		case flag == "app-name":
+			value = GCSFuseAppName + "-" + value
+		}
+
+		flagMap[flag] = value
+	}
+
+	// gcsfuse reports the app name in the User-Agent of its requests, so the Cloud Storage access logs show the sidecar version.
+	if mc.SidecarVersion != "" {
+		flagMap["app-name"] += "/" + mc.SidecarVersion
+	}
+
+	// The workload analyzer reads the gcsfuse metrics, so serve them even if the metrics collection is disabled for the volume.
+	if _, ok := flagMap["prometheus-port"]; !ok && mc.WorkloadRecommendations {
+		flagMap["prometheus-port"] = strconv.Itoa(prometheusPort)
+		prometheusPort++
+	}
+
+	if len(invalidArgs) > 0 {
+		klog.Warningf("got invalid arguments for volume %q: %v. Will discard invalid args and continue to mount.",
+			invalidArgs, mc.VolumeName)
+	}
+	mc.FlagMap, mc.ConfigFileFlagMap = flagMap, configFileFlagMap
+}
+
+func (mc *MountConfig) prepareConfigFile() error {
+	if mc.ConfigFileFlagMap == nil {
+		return errors.New("got empty config file flag map")
+	}
+
+	configMap := map[string]interface{}{}
+
+	for f, v := range mc.ConfigFileFlagMap {
+		curLevel := configMap
+		tokens := strings.Split(f, ":")
+		for i, t := range tokens {
+			if i == len(tokens)-1 {
+				if _, ok := curLevel[t].(map[string]interface{}); ok {
+					return fmt.Errorf("invalid config file flag: %q", f)
+				}
+
+				if intVal, err := strconv.ParseInt(v, 10, 64); err == nil {
+					curLevel[t] = intVal
+				} else if boolVal, err := strconv.ParseBool(v); err == nil {
+					curLevel[t] = boolVal
+				} else {
+					curLevel[t] = v
+				}
+
+				break
+			}
+
+			if _, ok := curLevel[t]; !ok {
+				curLevel[t] = map[string]interface{}{}
+			}
+
+			if nextLevel, ok := curLevel[t].(map[string]interface{}); ok {
+				curLevel = nextLevel
+			} else {
+				return fmt.Errorf("invalid config file flag: %q", f)
+			}
+		}
+	}
+	if mc.tokenServerEnabled() {
+		configMap["gcs-auth"] = map[string]interface{}{
+			"token-url": unixSocketBasePath + filepath.Join(mc.TempDir, TokenFileName),
+		}
+	}
+
+	yamlData, err := yaml.Marshal(&configMap)
+	if err != nil {
+		return err
+	}
+
+	klog.Infof("gcsfuse config file content: %v", configMap)
+
+	return os.WriteFile(mc.ConfigFile, yamlData, 0o400)
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecarmounter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/metrics"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// workloadOpsScrapeInterval is how often the file system operations that gcsfuse served are charged to the io token bucket.
+const workloadOpsScrapeInterval = time.Second
+
+// IOTokenBucket is the token bucket that the metadata prefetch shares with the file system operations that gcsfuse serves
+// to the workload, so that the cache warmup only uses the capacity that the workload leaves, and never adds tail latency
+// to the workload reads. gcsfuse cannot hold the workload operations back, so they are charged to the bucket once they
+// were served, from the fs_ops_count metric of the gcsfuse processes, and the prefetch waits for the tokens that are left.
+type IOTokenBucket struct {
+	limiter *rate.Limiter
+	mu      sync.Mutex
+	// prefetchOps is the number of tokens granted to the prefetch since they were last deducted from the workload operations,
+	// because gcsfuse counts the listings of the prefetch in fs_ops_count too.
+	prefetchOps float64
+}
+
+// NewIOTokenBucket returns a token bucket that allows opsPerSecond file system operations per second to the workload
+// and the metadata prefetch together, or nil if opsPerSecond is not positive.
+func NewIOTokenBucket(opsPerSecond int) *IOTokenBucket {
+	if opsPerSecond <= 0 {
+		return nil
+	}
+
+	return &IOTokenBucket{limiter: rate.NewLimiter(rate.Limit(opsPerSecond), opsPerSecond)}
+}
+
+// acquire blocks until the bucket grants a token to the metadata prefetch, or ctx is done.
+func (b *IOTokenBucket) acquire(ctx context.Context) error {
+	if err := b.limiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.prefetchOps++
+
+	return nil
+}
+
+// chargeWorkload takes the tokens of the operations that gcsfuse served since the last charge, less the operations of the prefetch,
+// and returns how many operations of the workload were charged. The workload is never held back, so the bucket goes into debt and
+// the prefetch waits until the workload leaves capacity again. The debt is capped at the burst of the bucket, so that a busy
+// workload holds the prefetch back without starving it for long once the workload is idle.
+func (b *IOTokenBucket) chargeWorkload(ops float64) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ops, b.prefetchOps = max(ops-b.prefetchOps, 0), max(b.prefetchOps-ops, 0)
+	now := time.Now()
+	burst := b.limiter.Burst()
+	n := max(min(int(ops), int(math.Floor(b.limiter.TokensAt(now)))+burst), 0)
+	// A reservation cannot take more tokens than the burst. The reservations are never canceled, so their tokens stay taken.
+	for charged := 0; charged < n; charged += burst {
+		b.limiter.ReserveN(now, min(n-charged, burst))
+	}
+
+	return n
+}
+
+// trackWorkload charges the file system operations that the gcsfuse process of the volume serves to the bucket, until ctx is done.
+func (b *IOTokenBucket) trackWorkload(ctx context.Context, port, volumeName string) {
+	metricEndpoint := fmt.Sprintf(metricEndpointFmt, port)
+	last := -1.0
+
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		scrapeCtx, cancel := context.WithTimeout(ctx, workloadScrapeTimeout)
+		defer cancel()
+
+		var buf bytes.Buffer
+		if err := scrapeMetrics(scrapeCtx, metricEndpoint, &buf); err != nil {
+			klog.V(4).Infof("failed to scrape the gcsfuse metrics of volume %q for the io token bucket: %v", volumeName, err)
+
+			return
+		}
+		families, err := metrics.ProcessMetricsData(&buf)
+		if err != nil {
+			klog.Warningf("failed to parse the gcsfuse metrics of volume %q for the io token bucket: %v", volumeName, err)
+
+			return
+		}
+
+		ops := summarizeWorkload(families).totalOps
+		// The operations served before the first scrape were not competing with the prefetch.
+		if last >= 0 && ops > last {
+			b.chargeWorkload(ops - last)
+		}
+		last = ops
+	}, workloadOpsScrapeInterval)
+}
+
+// ServeIOTokens serves the io token API on the loopback interface, so that only the containers of the Pod can call it.
+// The webhook does not enable the API for Pods in the host network, whose loopback interface is shared by the node.
+func (b *IOTokenBucket) ServeIOTokens(port int) {
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	mux := http.NewServeMux()
+	mux.HandleFunc(util.IOTokenPath, b.handleIOToken)
+
+	// No write timeout, the requests block until a token is granted or the request is canceled.
+	server := http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	klog.Infof("serving the io token bucket at %v%v", address, util.IOTokenPath)
+	if err := server.ListenAndServe(); err != nil {
+		klog.Errorf("failed to serve the io token bucket at %q: %v", address, err)
+	}
+}
+
+// handleIOToken responds once the bucket grants a token to the metadata prefetch.
+func (b *IOTokenBucket) handleIOToken(w http.ResponseWriter, r *http.Request) {
+	if err := b.acquire(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+
+		return
+	}
+	fmt.Fprintln(w, "granted")
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecarmounter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+)
+
+func TestNewIOTokenBucket(t *testing.T) {
+	t.Parallel()
+
+	if b := NewIOTokenBucket(0); b != nil {
+		t.Errorf("got bucket %+v, expected nil", b)
+	}
+}
+
+func TestIOTokenBucketChargeWorkload(t *testing.T) {
+	t.Parallel()
+
+	b := NewIOTokenBucket(10)
+	for range 2 {
+		if err := b.acquire(context.Background()); err != nil {
+			t.Fatalf("failed to acquire a token: %v", err)
+		}
+	}
+
+	// The two listings of the prefetch are counted by gcsfuse too, and are not charged again.
+	if charged := b.chargeWorkload(5); charged != 3 {
+		t.Errorf("got %d charged operations, expected 3", charged)
+	}
+	// The debt is capped at the burst of the bucket.
+	if charged := b.chargeWorkload(100); charged != 15 {
+		t.Errorf("got %d charged operations, expected 15", charged)
+	}
+	if charged := b.chargeWorkload(100); charged != 0 {
+		t.Errorf("got %d charged operations, expected 0", charged)
+	}
+
+	// The busy workload left no tokens, so the prefetch waits.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := b.acquire(ctx); err == nil {
+		t.Error("got error nil, expected the prefetch to wait for the workload")
+	}
+}
+
+func TestHandleIOToken(t *testing.T) {
+	t.Parallel()
+
+	b := NewIOTokenBucket(1)
+	w := httptest.NewRecorder()
+	b.handleIOToken(w, httptest.NewRequest(http.MethodGet, util.IOTokenPath, nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("got status %v, expected %v: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	w = httptest.NewRecorder()
+	b.handleIOToken(w, httptest.NewRequest(http.MethodGet, util.IOTokenPath, nil).WithContext(ctx))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %v, expected %v: %s", w.Code, http.StatusServiceUnavailable, w.Body.String())
+	}
+}
+
+func TestIOTokenBucketTrackWorkload(t *testing.T) {
+	t.Parallel()
+
+	var ops atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintf(w, "# TYPE fs_ops_count counter\nfs_ops_count{fs_op=\"ReadFile\"} %d\n", ops.Add(1000))
+	}))
+	defer server.Close()
+
+	b := NewIOTokenBucket(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.trackWorkload(ctx, server.URL[strings.LastIndex(server.URL, ":")+1:], "test-volume")
+
+	// The workload serves 1000 operations per second, far above the rate of the bucket, so the prefetch gets no token.
+	time.Sleep(1500 * time.Millisecond)
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer waitCancel()
+	if err := b.acquire(waitCtx); err == nil {
+		t.Error("got error nil, expected the prefetch to wait for the workload")
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecarmounter
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// The golden test sends the mount config messages in testdata/mount to the sidecar mounter, as the CSI driver sends them
+// to the socket of a volume, with fake mount system calls instead of FUSE, and compares the gcsfuse invocations and
+// config files with the golden files next to them, so that changes of the mount option plumbing are caught in review.
+// To add a message, or to accept an intended change of the gcsfuse invocation, run:
+//
+//	go test ./pkg/sidecar_mounter -run TestMountGolden -update-golden
+var updateGolden = flag.Bool("update-golden", false, "update the golden files of the sidecar mounter golden test")
+
+const mountGoldenDir = "testdata/mount"
+
+func TestMountGolden(t *testing.T) {
+	t.Parallel()
+
+	messages, err := filepath.Glob(filepath.Join(mountGoldenDir, "*.json"))
+	if err != nil {
+		t.Fatalf("failed to list the mount config messages: %v", err)
+	}
+	if len(messages) == 0 {
+		t.Fatalf("no mount config messages found in %q", mountGoldenDir)
+	}
+
+	for _, message := range messages {
+		name := strings.TrimSuffix(filepath.Base(message), ".json")
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			msg, err := os.ReadFile(message)
+			if err != nil {
+				t.Fatalf("failed to read the mount config message: %v", err)
+			}
+			got := runFakeMount(t, msg)
+
+			goldenFile := filepath.Join(mountGoldenDir, name+".golden")
+			if *updateGolden {
+				if err := os.WriteFile(goldenFile, []byte(got), 0o644); err != nil {
+					t.Fatalf("failed to update the golden file: %v", err)
+				}
+
+				return
+			}
+
+			want, err := os.ReadFile(goldenFile)
+			if err != nil {
+				t.Fatalf("failed to read the golden file, run the test with -update-golden to create it: %v", err)
+			}
+			if diff := cmp.Diff(string(want), got); diff != "" {
+				t.Errorf("unexpected gcsfuse invocation, run the test with -update-golden to accept an intended change (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+// runFakeMount mounts a volume with the mount config message using fake mount system calls, and returns the gcsfuse invocation
+// and config file, or the error file of the volume if the mount failed. The paths are relative to the fake sidecar container root.
+func runFakeMount(t *testing.T, msg []byte) string {
+	t.Helper()
+
+	// A short root directory, because the path of the token server socket must fit in a unix socket address.
+	root, err := os.MkdirTemp("", "sm")
+	if err != nil {
+		t.Fatalf("failed to create the root dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	volumeDir := filepath.Join(root, "gcsfuse-tmp", ".volumes", "test-volume")
+	if err := os.MkdirAll(volumeDir, 0o750); err != nil {
+		t.Fatalf("failed to create the volume dir: %v", err)
+	}
+	socketPath := filepath.Join(volumeDir, "socket")
+	sys := NewFakeMountSyscalls(map[string][]byte{socketPath: msg})
+	m := &Mounter{
+		mounterPath: "/gcsfuse",
+		procDir:     "/proc",
+		sys:         sys,
+		bufferDir:   filepath.Join(root, "gcsfuse-buffer"),
+		cacheDir:    filepath.Join(root, "gcsfuse-cache"),
+		tmpDir:      filepath.Join(root, "gcsfuse-tmp"),
+		processes:   map[string]gcsfuseProcess{},
+	}
+
+	var b strings.Builder
+	mc := m.NewMountConfig(socketPath, "test-version")
+	if handshake := string(sys.Handshake(socketPath)); handshake != `{"version":"test-version"}` {
+		t.Errorf("got handshake %q, expected the sidecar mounter version", handshake)
+	}
+	if mc == nil {
+		errMsg, err := os.ReadFile(filepath.Join(volumeDir, "error"))
+		if err != nil {
+			t.Fatalf("failed to read the error file: %v", err)
+		}
+		fmt.Fprintf(&b, "# error\n%s\n", errMsg)
+
+		return b.String()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := m.Mount(ctx, mc); err != nil {
+		t.Fatalf("failed to mount: %v", err)
+	}
+	err = wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		if len(sys.ClosedFDs()) == 0 {
+			return false, nil
+		}
+		if mc.tokenServerEnabled() {
+			_, err := os.Stat(filepath.Join(volumeDir, TokenFileName))
+
+			return err == nil, nil
+		}
+
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("gcsfuse did not start: %v", err)
+	}
+	cancel()
+	m.WaitGroup.Wait()
+
+	invocations := sys.Invocations()
+	if len(invocations) != 1 {
+		t.Fatalf("got gcsfuse invocations %v, expected one", invocations)
+	}
+	if closed := sys.ClosedFDs(); len(closed) != 1 || closed[0] != invocations[0].FD {
+		t.Errorf("got closed file descriptors %v, expected the file descriptor %v passed to gcsfuse", closed, invocations[0].FD)
+	}
+
+	fmt.Fprintf(&b, "# args\n%s\n", strings.Join(invocations[0].Args, "\n"))
+	config, err := os.ReadFile(mc.ConfigFile)
+	if err != nil {
+		t.Fatalf("failed to read the config file: %v", err)
+	}
+	fmt.Fprintf(&b, "# config file %s\n%s", mc.ConfigFile, config)
+
+	return strings.ReplaceAll(b.String(), root, "")
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecarmounter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+	"k8s.io/klog/v2"
+)
+
+// MountSyscalls makes the system calls of the sidecar mounter around a FUSE mount: receiving the /dev/fuse file descriptor
+// of a volume from the CSI driver, and handing it over to a gcsfuse process. FakeMountSyscalls replaces it in tests,
+// so that the mount flow runs without FUSE or privileges.
+type MountSyscalls interface {
+	// ReceiveMount connects to the socket of a volume, sends the handshake, and returns the /dev/fuse file descriptor
+	// and the mount config message that the CSI driver sends back. The socket is removed afterwards.
+	ReceiveMount(socketPath string, handshake []byte) (int, []byte, error)
+	// StartGcsfuse starts the gcsfuse binary at path with args, passing fd as file descriptor 3.
+	// The process is terminated when ctx is done.
+	StartGcsfuse(ctx context.Context, path string, args []string, fd int, stdout, stderr io.Writer) (Process, error)
+	// CloseFD closes the file descriptor once gcsfuse has taken it over.
+	CloseFD(fd int) error
+}
+
+// Process is a started gcsfuse process.
+type Process interface {
+	Pid() int
+	// Wait waits for the process to exit.
+	Wait() error
+}
+
+type osMountSyscalls struct{}
+
+func (osMountSyscalls) ReceiveMount(socketPath string, handshake []byte) (int, []byte, error) {
+	klog.Infof("connecting to socket %q", socketPath)
+	c, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to connect to the socket %q: %w", socketPath, err)
+	}
+
+	// CSI drivers that predate the handshake never read it, which is harmless.
+	if _, err := c.Write(handshake); err != nil {
+		klog.Warningf("failed to send the handshake to the socket %q: %v", socketPath, err)
+	}
+
+	fd, msg, err := util.RecvMsg(c)
+	if err != nil {
+		c.Close()
+
+		return 0, nil, fmt.Errorf("failed to receive mount options from the socket %q: %w", socketPath, err)
+	}
+	// as we got all the information from the socket, closing the connection and deleting the socket
+	c.Close()
+	if err = syscall.Unlink(socketPath); err != nil {
+		klog.Errorf("failed to close socket %q: %v", socketPath, err)
+	}
+
+	return fd, msg, nil
+}
+
+func (osMountSyscalls) StartGcsfuse(ctx context.Context, path string, args []string, fd int, stdout, stderr io.Writer) (Process, error) {
+	//nolint: gosec
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.ExtraFiles = []*os.File{os.NewFile(uintptr(fd), "/dev/fuse")}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Cancel = func() error {
+		klog.V(4).Infof("sending SIGTERM to gcsfuse process: %v", cmd)
+
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	// when the ctx.Done() is closed,
+	// the main workload containers have exited,
+	// so it is safe to force kill the gcsfuse process.
+	go func() {
+		<-ctx.Done()
+		time.Sleep(time.Second * 5)
+		if cmd.ProcessState == nil || !cmd.ProcessState.Exited() {
+			klog.Warningf("after 5 seconds, process with id %v has not exited, force kill the process", cmd.Process.Pid)
+			if err := cmd.Process.Kill(); err != nil {
+				klog.Warningf("failed to force kill process with id %v", cmd.Process.Pid)
+			}
+		}
+	}()
+
+	return &cmdProcess{cmd: cmd}, nil
+}
+
+func (osMountSyscalls) CloseFD(fd int) error {
+	return syscall.Close(fd)
+}
+
+type cmdProcess struct {
+	cmd *exec.Cmd
+}
+
+func (p *cmdProcess) Pid() int {
+	return p.cmd.Process.Pid
+}
+
+func (p *cmdProcess) Wait() error {
+	return p.cmd.Wait()
+}
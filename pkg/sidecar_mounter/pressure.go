@@ -0,0 +1,166 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecarmounter
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// CgroupV2Dir is where the cgroup v2 hierarchy of the sidecar container is mounted.
+	// With the cgroup namespace of the container, the root of the hierarchy is the cgroup of the sidecar container.
+	CgroupV2Dir = "/sys/fs/cgroup"
+
+	pressureMemory = "memory"
+	pressureIO     = "io"
+
+	// pressureCheckInterval is how often the pressure is checked while the gcsfuse processes run.
+	pressureCheckInterval = 10 * time.Second
+	// pressureWaitInterval is how often the memory pressure is checked before the next gcsfuse process starts.
+	pressureWaitInterval = time.Second
+)
+
+// PressureMonitor watches the pressure stall information (PSI) of the sidecar container cgroup on cgroup v2 nodes.
+// gcsfuse cannot change its parallelism while it runs, so the monitor applies backpressure when the gcsfuse processes start,
+// and reports the pressure of the running processes, so that the mount options can be tuned before the container is OOM killed.
+type PressureMonitor struct {
+	cgroupDir string
+	// threshold is the share of time, in percent, that some tasks in the cgroup stalled on a resource over the last 10 seconds,
+	// above which the cgroup is under pressure.
+	threshold float64
+	// underPressure records the resources whose pressure was above the threshold at the last check.
+	underPressure map[string]bool
+}
+
+// NewPressureMonitor returns a monitor of the pressure of the cgroup, or nil if the cgroup does not report pressure,
+// which is the case on cgroup v1 nodes, and on cgroup v2 nodes with PSI disabled.
+func NewPressureMonitor(cgroupDir string, threshold float64) *PressureMonitor {
+	if threshold <= 0 {
+		return nil
+	}
+
+	if _, err := readPressure(pressureFile(cgroupDir, pressureMemory)); err != nil {
+		klog.Infof("the pressure of the sidecar container is not monitored: %v", err)
+
+		return nil
+	}
+
+	return &PressureMonitor{cgroupDir: cgroupDir, threshold: threshold, underPressure: map[string]bool{}}
+}
+
+// Wait blocks while the memory pressure is above the threshold, at most for the timeout, so that the next gcsfuse process
+// does not add to the memory usage peak while the sidecar container is already reclaiming memory.
+func (m *PressureMonitor) Wait(ctx context.Context, timeout time.Duration) {
+	logged := false
+	err := wait.PollUntilContextTimeout(ctx, pressureWaitInterval, timeout, true, func(context.Context) (bool, error) {
+		avg10, err := readPressure(pressureFile(m.cgroupDir, pressureMemory))
+		if err != nil {
+			return false, err
+		}
+		if avg10 > m.threshold && !logged {
+			klog.Warningf("memory pressure of the sidecar container is %.2f%%, above the threshold %.2f%%, waiting before starting the next gcsfuse process", avg10, m.threshold)
+			logged = true
+		}
+
+		return avg10 <= m.threshold, nil
+	})
+	if err != nil && logged {
+		klog.Warningf("memory pressure of the sidecar container is still above the threshold %.2f%% after %v, starting the next gcsfuse process", m.threshold, timeout)
+	}
+}
+
+// Run checks the memory and IO pressure until ctx is canceled, and logs when the pressure crosses the threshold.
+func (m *PressureMonitor) Run(ctx context.Context) {
+	wait.UntilWithContext(ctx, func(context.Context) {
+		for _, resource := range []string{pressureMemory, pressureIO} {
+			m.check(resource)
+		}
+	}, pressureCheckInterval)
+}
+
+func (m *PressureMonitor) check(resource string) {
+	avg10, err := readPressure(pressureFile(m.cgroupDir, resource))
+	if err != nil {
+		klog.V(4).Infof("failed to read the %v pressure of the sidecar container: %v", resource, err)
+
+		return
+	}
+
+	switch above := avg10 > m.threshold; {
+	case above && !m.underPressure[resource]:
+		klog.Warningf("%v pressure of the sidecar container is %.2f%%, above the threshold %.2f%%. %s", resource, avg10, m.threshold, pressureAdvice[resource])
+	case !above && m.underPressure[resource]:
+		klog.Infof("%v pressure of the sidecar container is %.2f%%, back below the threshold %.2f%%", resource, avg10, m.threshold)
+	}
+	m.underPressure[resource] = avg10 > m.threshold
+}
+
+// pressureAdvice describes how to relieve the pressure on each resource.
+var pressureAdvice = map[string]string{
+	pressureMemory: "The gcsfuse processes may be OOM killed. Increase the sidecar container memory limit, or lower the gcsfuse parallelism with the file-cache:max-parallel-downloads and write:max-blocks-per-file mount options.",
+	pressureIO:     "The gcsfuse processes stall on the buffer and cache volumes. Use a faster volume for the file cache, or lower the gcsfuse parallelism with the file-cache:max-parallel-downloads mount option.",
+}
+
+func pressureFile(cgroupDir, resource string) string {
+	return filepath.Join(cgroupDir, resource+".pressure")
+}
+
+// readPressure returns the share of time, in percent, that some tasks stalled on the resource over the last 10 seconds,
+// from the "some avg10" field of a cgroup v2 pressure file.
+func readPressure(path string) (float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "some" {
+			continue
+		}
+
+		for _, field := range fields[1:] {
+			if value, ok := strings.CutPrefix(field, "avg10="); ok {
+				avg10, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					return 0, fmt.Errorf("failed to parse %q in %q: %w", field, path, err)
+				}
+
+				return avg10, nil
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read %q: %w", path, err)
+	}
+
+	return 0, errors.New("no some avg10 field in " + path)
+}
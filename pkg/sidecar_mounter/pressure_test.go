@@ -0,0 +1,153 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecarmounter
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func writePressure(t *testing.T, dir, resource, some string) {
+	t.Helper()
+
+	content := "some " + some + "\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n"
+	if err := os.WriteFile(pressureFile(dir, resource), []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write the %v pressure file: %v", resource, err)
+	}
+}
+
+func TestReadPressure(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		some        string
+		expected    float64
+		expectedErr bool
+	}{
+		{
+			name:     "no pressure",
+			some:     "avg10=0.00 avg60=0.00 avg300=0.00 total=0",
+			expected: 0,
+		},
+		{
+			name:     "pressure",
+			some:     "avg10=42.57 avg60=12.03 avg300=3.10 total=123456",
+			expected: 42.57,
+		},
+		{
+			name:        "invalid value",
+			some:        "avg10=high avg60=0.00 avg300=0.00 total=0",
+			expectedErr: true,
+		},
+		{
+			name:        "missing field",
+			some:        "total=0",
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			writePressure(t, dir, pressureMemory, tc.some)
+			avg10, err := readPressure(pressureFile(dir, pressureMemory))
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectedErr)
+			}
+			if avg10 != tc.expected {
+				t.Errorf("got avg10 %v, expected %v", avg10, tc.expected)
+			}
+		})
+	}
+}
+
+func TestNewPressureMonitor(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if m := NewPressureMonitor(dir, 20); m != nil {
+		t.Error("expected no monitor without the memory pressure file")
+	}
+
+	writePressure(t, dir, pressureMemory, "avg10=0.00 avg60=0.00 avg300=0.00 total=0")
+	if m := NewPressureMonitor(dir, 0); m != nil {
+		t.Error("expected no monitor with a zero threshold")
+	}
+	if m := NewPressureMonitor(dir, 20); m == nil {
+		t.Error("expected a monitor with the memory pressure file")
+	}
+}
+
+func TestPressureMonitorCheck(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writePressure(t, dir, pressureMemory, "avg10=0.00 avg60=0.00 avg300=0.00 total=0")
+	m := NewPressureMonitor(dir, 20)
+
+	for _, tc := range []struct {
+		avg10    string
+		expected bool
+	}{
+		{"avg10=35.00", true},
+		{"avg10=25.00", true},
+		{"avg10=5.00", false},
+	} {
+		writePressure(t, dir, pressureMemory, tc.avg10)
+		m.check(pressureMemory)
+		if m.underPressure[pressureMemory] != tc.expected {
+			t.Errorf("with %v, got under pressure %v, expected %v", tc.avg10, m.underPressure[pressureMemory], tc.expected)
+		}
+	}
+
+	// The IO pressure file is missing, so the IO pressure is not reported.
+	m.check(pressureIO)
+	if m.underPressure[pressureIO] {
+		t.Error("expected no IO pressure without the IO pressure file")
+	}
+}
+
+func TestPressureMonitorWait(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writePressure(t, dir, pressureMemory, "avg10=50.00 avg60=0.00 avg300=0.00 total=0")
+	m := NewPressureMonitor(dir, 20)
+
+	start := time.Now()
+	m.Wait(context.Background(), 100*time.Millisecond)
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected Wait to block until the timeout under pressure, returned after %v", elapsed)
+	}
+
+	// The pressure is relieved while waiting.
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = os.WriteFile(pressureFile(dir, pressureMemory), []byte("some avg10=1.00 avg60=0.00 avg300=0.00 total=0\n"), 0o644)
+	}()
+	start = time.Now()
+	m.Wait(context.Background(), time.Minute)
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected Wait to return once the pressure is relieved, returned after %v", elapsed)
+	}
+}
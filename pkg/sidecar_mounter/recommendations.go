@@ -0,0 +1,226 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecarmounter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/metrics"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// workloadAnalysisInterval is how often the analyzer summarizes the gcsfuse metrics of a volume. gcsfuse is terminated
+	// when the volume is unmounted, so the last summary written before the termination is the one the CSI driver reports.
+	workloadAnalysisInterval = time.Minute
+	workloadScrapeTimeout    = 5 * time.Second
+
+	// minAnalyzedOps is the number of file system operations below which the workload is too small to recommend settings.
+	minAnalyzedOps = 100
+	// readHeavyRatio is the share of the read and write operations that are reads, above which the workload is read-heavy.
+	readHeavyRatio = 0.8
+	// sequentialReadRatio is the share of the Cloud Storage reads that are sequential, above which large files are read sequentially.
+	sequentialReadRatio = 0.8
+	// lowCacheHitRate is the file cache hit rate below which the file cache does not help the workload.
+	lowCacheHitRate = 0.5
+	// metadataHeavyRatio is the share of the file system operations that are metadata lookups, above which the stat cache is too small.
+	metadataHeavyRatio = 0.5
+)
+
+// workloadSummary is the I/O of a volume that gcsfuse counted since it started.
+type workloadSummary struct {
+	readOps, writeOps, metadataOps, totalOps float64
+	sequentialReads, randomReads             float64
+	readBytes                                float64
+	cacheHits, cacheMisses                   float64
+}
+
+// summarizeWorkload sums up the gcsfuse metrics that describe the I/O pattern of the workload.
+func summarizeWorkload(families map[string]*dto.MetricFamily) workloadSummary {
+	s := workloadSummary{}
+	for _, m := range families["fs_ops_count"].GetMetric() {
+		v := metricValue(m)
+		s.totalOps += v
+		switch labelValue(m, "fs_op") {
+		case "ReadFile":
+			s.readOps += v
+		case "WriteFile":
+			s.writeOps += v
+		case "LookUpInode", "GetInodeAttributes":
+			s.metadataOps += v
+		}
+	}
+	for _, m := range families["gcs_read_count"].GetMetric() {
+		if labelValue(m, "read_type") == "Random" {
+			s.randomReads += metricValue(m)
+		} else {
+			s.sequentialReads += metricValue(m)
+		}
+	}
+	for _, m := range families["gcs_read_bytes_count"].GetMetric() {
+		s.readBytes += metricValue(m)
+	}
+	for _, m := range families["file_cache_read_count"].GetMetric() {
+		if labelValue(m, "cache_hit") == util.TrueStr {
+			s.cacheHits += metricValue(m)
+		} else {
+			s.cacheMisses += metricValue(m)
+		}
+	}
+
+	return s
+}
+
+func metricValue(m *dto.Metric) float64 {
+	switch {
+	case m.GetCounter() != nil:
+		return m.GetCounter().GetValue()
+	case m.GetGauge() != nil:
+		return m.GetGauge().GetValue()
+	default:
+		return m.GetUntyped().GetValue()
+	}
+}
+
+func labelValue(m *dto.Metric, name string) string {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+
+	return ""
+}
+
+func ratio(part, total float64) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	return part / total
+}
+
+// String describes the workload in the terms the recommendations refer to.
+func (s workloadSummary) String() string {
+	parts := []string{
+		fmt.Sprintf("%.0f file system operations, %.0f reads and %.0f writes", s.totalOps, s.readOps, s.writeOps),
+	}
+	if gcsReads := s.sequentialReads + s.randomReads; gcsReads > 0 {
+		parts = append(parts, fmt.Sprintf("%.0f%% of the %.0f Cloud Storage reads are sequential, %.1f MiB per read on average",
+			100*ratio(s.sequentialReads, gcsReads), gcsReads, ratio(s.readBytes, gcsReads)/util.Mb))
+	}
+	if cacheReads := s.cacheHits + s.cacheMisses; cacheReads > 0 {
+		parts = append(parts, fmt.Sprintf("file cache hit rate %.0f%%", 100*ratio(s.cacheHits, cacheReads)))
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// recommendations returns the volume attributes that suit the workload better than the settings of the volume.
+func (s workloadSummary) recommendations(mc *MountConfig) []string {
+	if s.totalOps < minAnalyzedOps {
+		return nil
+	}
+
+	recommendations := []string{}
+	fileCacheEnabled := mc.ConfigFileFlagMap["cache-dir"] != ""
+	readHeavy := ratio(s.readOps, s.readOps+s.writeOps) >= readHeavyRatio
+	sequential := ratio(s.sequentialReads, s.sequentialReads+s.randomReads) >= sequentialReadRatio
+
+	switch {
+	case !fileCacheEnabled && readHeavy && s.sequentialReads+s.randomReads > 0:
+		recommendations = append(recommendations, "the workload is read-heavy, set fileCacheCapacity to cache the objects on the node")
+	case fileCacheEnabled && sequential && mc.ConfigFileFlagMap["file-cache:enable-parallel-downloads"] != util.TrueStr:
+		recommendations = append(recommendations, "the Cloud Storage reads are sequential, set fileCacheParallelDownloads to true to download large files faster")
+	}
+
+	if cacheReads := s.cacheHits + s.cacheMisses; fileCacheEnabled && cacheReads >= minAnalyzedOps && ratio(s.cacheHits, cacheReads) < lowCacheHitRate {
+		if !sequential && mc.ConfigFileFlagMap["file-cache:cache-file-for-range-read"] != util.TrueStr {
+			recommendations = append(recommendations, "the file cache hit rate is low for random reads, set fileCacheForRangeRead to true to cache the objects that are read at an offset")
+		} else {
+			recommendations = append(recommendations, "the file cache hit rate is low, increase fileCacheCapacity to keep the objects that are read again in the cache")
+		}
+	}
+
+	if ratio(s.metadataOps, s.totalOps) >= metadataHeavyRatio {
+		recommendations = append(recommendations, "most of the file system operations are metadata lookups, increase metadataStatCacheCapacity and metadataCacheTTLSeconds")
+	}
+
+	return recommendations
+}
+
+// formatRecommendations formats the content of the recommendations file of a volume.
+func formatRecommendations(s workloadSummary, recommendations []string) string {
+	if len(recommendations) == 0 {
+		return fmt.Sprintf("Observed %v. No gcsfuse setting changes are recommended.", s)
+	}
+
+	return fmt.Sprintf("Observed %v. Recommendations: %v.", s, strings.Join(recommendations, "; "))
+}
+
+// analyzeWorkload summarizes the gcsfuse metrics of a volume until ctx is canceled, and writes the summary with the recommended
+// settings to the recommendations file of the volume, so that the CSI driver can report them when the volume is unmounted.
+func analyzeWorkload(ctx context.Context, port string, mc *MountConfig) {
+	metricEndpoint := fmt.Sprintf(metricEndpointFmt, port)
+	path := filepath.Join(mc.TempDir, util.RecommendationsFile)
+
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		scrapeCtx, cancel := context.WithTimeout(ctx, workloadScrapeTimeout)
+		defer cancel()
+
+		var buf bytes.Buffer
+		if err := scrapeMetrics(scrapeCtx, metricEndpoint, &buf); err != nil {
+			klog.V(4).Infof("failed to scrape the gcsfuse metrics of volume %q for the workload analysis: %v", mc.VolumeName, err)
+
+			return
+		}
+		families, err := metrics.ProcessMetricsData(&buf)
+		if err != nil {
+			klog.Warningf("failed to parse the gcsfuse metrics of volume %q for the workload analysis: %v", mc.VolumeName, err)
+
+			return
+		}
+
+		s := summarizeWorkload(families)
+		if s.totalOps < minAnalyzedOps {
+			return
+		}
+		if err := writeFileAtomically(path, []byte(formatRecommendations(s, s.recommendations(mc)))); err != nil {
+			klog.Warningf("failed to write the workload recommendations of volume %q: %v", mc.VolumeName, err)
+		}
+	}, workloadAnalysisInterval)
+}
+
+// writeFileAtomically replaces the file with the content, so that the CSI driver never reads a partially written file.
+func writeFileAtomically(path string, content []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
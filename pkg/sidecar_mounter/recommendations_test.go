@@ -0,0 +1,148 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecarmounter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/metrics"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
+)
+
+const workloadMetrics = `# TYPE fs_ops_count counter
+fs_ops_count{fs_op="ReadFile"} 900
+fs_ops_count{fs_op="WriteFile"} 50
+fs_ops_count{fs_op="LookUpInode"} 40
+fs_ops_count{fs_op="GetInodeAttributes"} 10
+# TYPE gcs_read_count counter
+gcs_read_count{read_type="Sequential"} 90
+gcs_read_count{read_type="Random"} 10
+# TYPE gcs_read_bytes_count counter
+gcs_read_bytes_count 1.048576e+09
+# TYPE file_cache_read_count counter
+file_cache_read_count{cache_hit="true",read_type="Sequential"} 100
+file_cache_read_count{cache_hit="false",read_type="Sequential"} 300
+`
+
+func TestSummarizeWorkload(t *testing.T) {
+	t.Parallel()
+
+	families, err := metrics.ProcessMetricsData(strings.NewReader(workloadMetrics))
+	if err != nil {
+		t.Fatalf("failed to parse the metrics: %v", err)
+	}
+
+	s := summarizeWorkload(families)
+	expected := workloadSummary{
+		readOps:         900,
+		writeOps:        50,
+		metadataOps:     50,
+		totalOps:        1000,
+		sequentialReads: 90,
+		randomReads:     10,
+		readBytes:       1000 * util.Mb,
+		cacheHits:       100,
+		cacheMisses:     300,
+	}
+	if diff := cmp.Diff(expected, s, cmp.AllowUnexported(workloadSummary{})); diff != "" {
+		t.Errorf("unexpected workload summary (-want +got):\n%s", diff)
+	}
+
+	expectedString := "1000 file system operations, 900 reads and 50 writes; 90% of the 100 Cloud Storage reads are sequential, 10.0 MiB per read on average; file cache hit rate 25%"
+	if s.String() != expectedString {
+		t.Errorf("got workload summary %q, but expected %q", s.String(), expectedString)
+	}
+}
+
+func TestWorkloadRecommendations(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name              string
+		summary           workloadSummary
+		configFileFlagMap map[string]string
+		expected          []string
+	}{
+		{
+			name:    "too few operations",
+			summary: workloadSummary{readOps: 50, totalOps: 50, sequentialReads: 50},
+		},
+		{
+			name:     "read-heavy workload without file cache",
+			summary:  workloadSummary{readOps: 900, writeOps: 10, totalOps: 1000, sequentialReads: 100},
+			expected: []string{"the workload is read-heavy, set fileCacheCapacity to cache the objects on the node"},
+		},
+		{
+			name:              "sequential reads without parallel downloads",
+			summary:           workloadSummary{readOps: 900, totalOps: 1000, sequentialReads: 100, cacheHits: 80, cacheMisses: 20},
+			configFileFlagMap: map[string]string{"cache-dir": "/cache"},
+			expected:          []string{"the Cloud Storage reads are sequential, set fileCacheParallelDownloads to true to download large files faster"},
+		},
+		{
+			name:              "sequential reads with parallel downloads",
+			summary:           workloadSummary{readOps: 900, totalOps: 1000, sequentialReads: 100, cacheHits: 80, cacheMisses: 20},
+			configFileFlagMap: map[string]string{"cache-dir": "/cache", "file-cache:enable-parallel-downloads": "true"},
+			expected:          []string{},
+		},
+		{
+			name:              "low cache hit rate for random reads",
+			summary:           workloadSummary{readOps: 900, totalOps: 1000, randomReads: 100, cacheHits: 10, cacheMisses: 190},
+			configFileFlagMap: map[string]string{"cache-dir": "/cache"},
+			expected:          []string{"the file cache hit rate is low for random reads, set fileCacheForRangeRead to true to cache the objects that are read at an offset"},
+		},
+		{
+			name:              "low cache hit rate with range reads cached",
+			summary:           workloadSummary{readOps: 900, totalOps: 1000, randomReads: 100, cacheHits: 10, cacheMisses: 190},
+			configFileFlagMap: map[string]string{"cache-dir": "/cache", "file-cache:cache-file-for-range-read": "true"},
+			expected:          []string{"the file cache hit rate is low, increase fileCacheCapacity to keep the objects that are read again in the cache"},
+		},
+		{
+			name:     "metadata-heavy workload",
+			summary:  workloadSummary{readOps: 100, writeOps: 100, metadataOps: 800, totalOps: 1000},
+			expected: []string{"most of the file system operations are metadata lookups, increase metadataStatCacheCapacity and metadataCacheTTLSeconds"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			mc := &MountConfig{ConfigFileFlagMap: tc.configFileFlagMap}
+			if diff := cmp.Diff(tc.expected, tc.summary.recommendations(mc)); diff != "" {
+				t.Errorf("unexpected recommendations (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFormatRecommendations(t *testing.T) {
+	t.Parallel()
+
+	s := workloadSummary{readOps: 100, writeOps: 100, totalOps: 200}
+	expected := "Observed 200 file system operations, 100 reads and 100 writes. No gcsfuse setting changes are recommended."
+	if got := formatRecommendations(s, nil); got != expected {
+		t.Errorf("got %q, but expected %q", got, expected)
+	}
+
+	expected = "Observed 200 file system operations, 100 reads and 100 writes. Recommendations: a; b."
+	if got := formatRecommendations(s, []string{"a", "b"}); got != expected {
+		t.Errorf("got %q, but expected %q", got, expected)
+	}
+}
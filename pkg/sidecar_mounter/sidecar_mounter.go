@@ -27,119 +27,159 @@ import (
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	"cloud.google.com/go/compute/metadata"
 	credentials "cloud.google.com/go/iam/credentials/apiv1"
+	"cloud.google.com/go/storage"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/auth"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/metrics"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/webhook"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/common/expfmt"
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
 	"google.golang.org/api/option"
 	"google.golang.org/api/sts/v1"
 	"k8s.io/klog/v2"
 )
 
-const metricEndpointFmt = "http://localhost:%v/metrics"
+const (
+	metricEndpointFmt = "http://localhost:%v/metrics"
+
+	// processMetricsNamespace is the prefix of the CPU, memory, and file descriptor metrics of the gcsfuse process of a volume,
+	// so that the volume that uses up the sidecar container resources can be told apart.
+	processMetricsNamespace = "gke_gcsfuse_csi_sidecar_gcsfuse"
+)
 
 // Mounter will be used in the sidecar container to invoke gcsfuse.
 type Mounter struct {
 	mounterPath string
 	WaitGroup   sync.WaitGroup
+
+	// client is the FUSE client that serves the volumes, gcsfuse if it is not set.
+	client FUSEClient
+
+	// procDir is where the proc file system is mounted, to look up the files that the gcsfuse processes stage.
+	procDir string
+	// sys receives the file descriptors of the volumes and starts the gcsfuse processes.
+	sys MountSyscalls
+	// bufferDir, cacheDir and tmpDir are where the buffer, cache and temp volumes are mounted in the sidecar container.
+	bufferDir string
+	cacheDir  string
+	tmpDir    string
+	mu        sync.Mutex
+	// processes maps the volume names to their running gcsfuse processes.
+	processes map[string]gcsfuseProcess
+	// draining is set once the sidecar mounter received SIGTERM and waits for the staged writes to be uploaded.
+	draining bool
+	// terminatedAt is when the sidecar mounter received SIGTERM, from which the flush duration of each volume is measured.
+	terminatedAt time.Time
+
+	// IOTokens is charged with the file system operations that the gcsfuse processes serve, or nil.
+	IOTokens *IOTokenBucket
 }
 
 // New returns a Mounter for the current system.
-// It provides an option to specify the path to gcsfuse binary.
-func New(mounterPath string) *Mounter {
+// It provides an option to specify the path to the binary of the FUSE client.
+func New(mounterPath string, client FUSEClient) *Mounter {
 	return &Mounter{
 		mounterPath: mounterPath,
+		client:      client,
+		procDir:     "/proc",
+		sys:         osMountSyscalls{},
+		bufferDir:   webhook.SidecarContainerBufferVolumeMountPath,
+		cacheDir:    webhook.SidecarContainerCacheVolumeMountPath,
+		tmpDir:      webhook.SidecarContainerTmpVolumeMountPath,
+		processes:   map[string]gcsfuseProcess{},
 	}
 }
 
+// fuseClient returns the FUSE client that serves the volumes.
+func (m *Mounter) fuseClient() FUSEClient {
+	if m.client != nil {
+		return m.client
+	}
+
+	return gcsfuseClient{}
+}
+
 func (m *Mounter) Mount(ctx context.Context, mc *MountConfig) error {
-	// Start the token server for HostNetwork enabled pods.
-	if mc.TokenServerIdentityProvider != "" {
+	// Start the token server for HostNetwork enabled pods, for read-only volumes that use downscoped tokens,
+	// and for volumes that refresh their tokens periodically.
+	if mc.tokenServerEnabled() {
 		tp := filepath.Join(mc.TempDir, TokenFileName)
-		klog.Infof("Pod has hostNetwork enabled and token server feature is turned on. Starting Token Server on %s.", tp)
-		go StartTokenServer(ctx, tp, mc.TokenServerIdentityProvider)
+		klog.Infof("Starting Token Server on %s, hostNetwork token server enabled: %v, read-only tokens: %v, token refresh interval: %v, impersonated service account: %q.",
+			tp, mc.TokenServerIdentityProvider != "", mc.TokenServerReadOnly, mc.TokenServerRefreshInterval, mc.TokenServerServiceAccount)
+		go StartTokenServer(ctx, tp, newVolumeTokenSource(mc).Token, mc.TokenServerRefreshInterval)
 	}
 
 	klog.Infof("start to mount bucket %q for volume %q", mc.BucketName, mc.VolumeName)
 
+	// The emptyDir volume outlives the sidecar container, so clear the drain status and flush duration of a previous run.
+	removeDrainStatus(mc.TempDir)
+	removeFlushDuration(mc.TempDir)
+
 	if err := os.MkdirAll(mc.BufferDir+TempDir, os.ModePerm); err != nil {
 		return fmt.Errorf("failed to create temp dir %q: %w", mc.BufferDir+TempDir, err)
 	}
 
-	args := []string{}
-	for k, v := range mc.FlagMap {
-		args = append(args, "--"+k)
-		if v != "" {
-			args = append(args, v)
-		}
-	}
-
-	args = append(args, mc.BucketName)
-	// gcsfuse supports the `/dev/fd/N` syntax
-	// the /dev/fuse is passed as ExtraFiles below, and will always be FD 3
-	args = append(args, "/dev/fd/3")
-
-	klog.Infof("gcsfuse mounting with args %v...", args)
-	//nolint: gosec
-	cmd := exec.CommandContext(ctx, m.mounterPath, args...)
-	cmd.ExtraFiles = []*os.File{os.NewFile(uintptr(mc.FileDescriptor), "/dev/fuse")}
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = io.MultiWriter(os.Stderr, mc.ErrWriter)
-	cmd.Cancel = func() error {
-		klog.V(4).Infof("sending SIGTERM to gcsfuse process: %v", cmd)
-
-		return cmd.Process.Signal(syscall.SIGTERM)
-	}
-
-	// when the ctx.Done() is closed,
-	// the main workload containers have exited,
-	// so it is safe to force kill the gcsfuse process.
-	go func(cmd *exec.Cmd) {
-		<-ctx.Done()
-		time.Sleep(time.Second * 5)
-		if cmd.ProcessState == nil || !cmd.ProcessState.Exited() {
-			klog.Warningf("after 5 seconds, process with id %v has not exited, force kill the process", cmd.Process.Pid)
-			if err := cmd.Process.Kill(); err != nil {
-				klog.Warningf("failed to force kill process with id %v", cmd.Process.Pid)
-			}
-		}
-	}(cmd)
+	args := m.fuseClient().Args(mc)
+	klog.Infof("%v mounting with args %v...", m.fuseClient().Name(), args)
+	stderr := io.MultiWriter(os.Stderr, mc.ErrWriter)
 
 	m.WaitGroup.Add(1)
 	go func() {
 		defer m.WaitGroup.Done()
-		if err := cmd.Start(); err != nil {
+		process, err := m.sys.StartGcsfuse(ctx, m.mounterPath, args, mc.FileDescriptor, os.Stdout, stderr)
+		if err != nil {
 			mc.ErrWriter.WriteMsg(fmt.Sprintf("failed to start gcsfuse with error: %v\n", err))
 
 			return
 		}
 
-		klog.Infof("gcsfuse for bucket %q, volume %q started with process id %v", mc.BucketName, mc.VolumeName, cmd.Process.Pid)
+		pid := process.Pid()
+		klog.Infof("gcsfuse for bucket %q, volume %q started with process id %v", mc.BucketName, mc.VolumeName, pid)
+		m.addProcess(mc.VolumeName, gcsfuseProcess{
+			pid:             pid,
+			tempDir:         mc.BufferDir + TempDir,
+			statusDir:       mc.TempDir,
+			streamingWrites: mc.ConfigFileFlagMap["write:enable-streaming-writes"] == util.TrueStr,
+		})
+		defer m.removeProcess(mc.VolumeName)
 
 		loggingSeverity := mc.ConfigFileFlagMap["logging:severity"]
 		if loggingSeverity == "debug" || loggingSeverity == "trace" {
-			go logMemoryUsage(ctx, cmd.Process.Pid)
+			go logMemoryUsage(ctx, pid)
 			go logVolumeUsage(ctx, mc.BufferDir, mc.CacheDir)
 		}
 
 		promPort, ok := mc.FlagMap["prometheus-port"]
 		if ok && promPort != "0" {
 			klog.Infof("start to collect metrics from port %v for volume %q", promPort, mc.VolumeName)
-			go collectMetrics(ctx, promPort, mc.TempDir)
+			go collectMetrics(ctx, promPort, mc.TempDir, pid)
+			if mc.WorkloadRecommendations {
+				klog.Infof("start to analyze the workload of volume %q", mc.VolumeName)
+				go analyzeWorkload(ctx, promPort, mc)
+			}
+			if m.IOTokens != nil {
+				go m.IOTokens.trackWorkload(ctx, promPort, mc.VolumeName)
+			}
+		} else if m.IOTokens != nil {
+			klog.Warningf("the metrics of volume %q are disabled, so its workload operations are not charged to the io token bucket", mc.VolumeName)
 		}
 
 		// Since the gcsfuse has taken over the file descriptor,
 		// closing the file descriptor to avoid other process forking it.
-		syscall.Close(mc.FileDescriptor)
-		if err := cmd.Wait(); err != nil {
+		if err := m.sys.CloseFD(mc.FileDescriptor); err != nil {
+			klog.Warningf("failed to close the file descriptor of volume %q: %v", mc.VolumeName, err)
+		}
+		if err := process.Wait(); err != nil {
 			errMsg := fmt.Sprintf("gcsfuse exited with error: %v\n", err)
 			if strings.Contains(errMsg, "signal: terminated") {
 				klog.Infof("[%v] gcsfuse was terminated.", mc.VolumeName)
@@ -149,6 +189,7 @@ func (m *Mounter) Mount(ctx context.Context, mc *MountConfig) error {
 		} else {
 			klog.Infof("[%v] gcsfuse exited normally.", mc.VolumeName)
 		}
+		m.recordFlushDuration(mc.VolumeName, mc.TempDir)
 	}()
 
 	return nil
@@ -231,11 +272,12 @@ func logVolumeTotalSize(dirPath string) {
 	}
 }
 
-// collectMetrics collects metrics from the gcsfuse instance.
+// collectMetrics collects metrics from the gcsfuse instance, and the CPU, memory, and file descriptor metrics of the gcsfuse process.
 // Meanwhile, a server is created for each gcsfuse instance,
 // exposing a unix domain socket for CSI driver to connect.
-func collectMetrics(ctx context.Context, port, tempDir string) {
+func collectMetrics(ctx context.Context, port, tempDir string, pid int) {
 	metricEndpoint := fmt.Sprintf(metricEndpointFmt, port)
+	processRegistry := newProcessMetricsRegistry(pid)
 
 	// Create a unix domain socket and listen for incoming connections.
 	socketPath := filepath.Join(tempDir, metrics.SocketName)
@@ -253,6 +295,12 @@ func collectMetrics(ctx context.Context, port, tempDir string) {
 
 		if err := scrapeMetrics(timeoutCtx, metricEndpoint, w); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		if err := writeProcessMetrics(w, processRegistry); err != nil {
+			klog.Errorf("failed to write the process metrics of gcsfuse for %q: %v", tempDir, err)
 		}
 	})
 
@@ -267,8 +315,8 @@ func collectMetrics(ctx context.Context, port, tempDir string) {
 }
 
 // scrapeMetrics connects to the metrics endpoint and scrapes latest metrics sample.
-// The response is written to a new http.ResponseWriter.
-func scrapeMetrics(ctx context.Context, metricEndpoint string, w http.ResponseWriter) error {
+// The response is written to w.
+func scrapeMetrics(ctx context.Context, metricEndpoint string, w io.Writer) error {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metricEndpoint, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create HTTP request to %q: %w", metricEndpoint, err)
@@ -291,6 +339,33 @@ func scrapeMetrics(ctx context.Context, metricEndpoint string, w http.ResponseWr
 	return nil
 }
 
+// newProcessMetricsRegistry returns a registry of the metrics that the process collector reads from /proc for the process.
+func newProcessMetricsRegistry(pid int) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{
+		PidFn:     func() (int, error) { return pid, nil },
+		Namespace: processMetricsNamespace,
+	}))
+
+	return registry
+}
+
+// writeProcessMetrics writes the process metrics in the Prometheus text format, after the gcsfuse metrics.
+func writeProcessMetrics(w io.Writer, g prometheus.Gatherer) error {
+	families, err := g.Gather()
+	if err != nil {
+		return fmt.Errorf("failed to gather the process metrics: %w", err)
+	}
+
+	for _, mf := range families {
+		if _, err := expfmt.MetricFamilyToText(w, mf); err != nil {
+			return fmt.Errorf("failed to write metric family %q: %w", mf.GetName(), err)
+		}
+	}
+
+	return nil
+}
+
 func getK8sTokenFromFile(tokenPath string) (string, error) {
 	token, err := os.ReadFile(tokenPath)
 	if err != nil {
@@ -348,7 +423,173 @@ func getAudienceFromContextAndIdentityProvider(ctx context.Context, identityProv
 	return audience, nil
 }
 
-func StartTokenServer(ctx context.Context, tokenURLSocketPath string, identityProvider string) {
+// downscopeToken exchanges token for a token that only has the permissions of roles/storage.objectViewer on the bucket,
+// using a Credential Access Boundary, so that a leaked token of a read-only volume cannot modify or delete objects.
+func downscopeToken(ctx context.Context, token *oauth2.Token, bucketName string) (*oauth2.Token, error) {
+	stsService, err := sts.NewService(ctx, option.WithHTTPClient(&http.Client{}))
+	if err != nil {
+		return nil, fmt.Errorf("new STS service error: %w", err)
+	}
+
+	options, err := json.Marshal(map[string]interface{}{
+		"accessBoundary": map[string]interface{}{
+			"accessBoundaryRules": []map[string]interface{}{
+				{
+					"availableResource":    "//storage.googleapis.com/projects/_/buckets/" + bucketName,
+					"availablePermissions": []string{"inRole:roles/storage.objectViewer"},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the credential access boundary: %w", err)
+	}
+
+	stsRequest := &sts.GoogleIdentityStsV1ExchangeTokenRequest{
+		GrantType:          "urn:ietf:params:oauth:grant-type:token-exchange",
+		RequestedTokenType: "urn:ietf:params:oauth:token-type:access_token",
+		SubjectTokenType:   "urn:ietf:params:oauth:token-type:access_token",
+		SubjectToken:       token.AccessToken,
+		Options:            string(options),
+	}
+
+	stsResponse, err := stsService.V1.Token(stsRequest).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("downscoped token exchange error for bucket %q: %w", bucketName, err)
+	}
+
+	// The downscoped token expires together with the source token if the response has no expiry.
+	downscopedToken := &oauth2.Token{
+		AccessToken: stsResponse.AccessToken,
+		TokenType:   stsResponse.TokenType,
+		Expiry:      token.Expiry,
+	}
+	if stsResponse.ExpiresIn > 0 {
+		downscopedToken.Expiry = time.Now().Add(time.Second * time.Duration(stsResponse.ExpiresIn))
+	}
+
+	return downscopedToken, nil
+}
+
+// volumeTokenSource fetches the access tokens that the token server of one volume serves to gcsfuse.
+// Each volume has its own token source and token server, and tokens are never cached across volumes,
+// so that the volumes of a Pod can use different identities.
+type volumeTokenSource struct {
+	// identityProvider is set for Pods with hostNetwork enabled, which exchange the Kubernetes service account token
+	// for an IdentityBindingToken instead of getting a token from the GKE metadata server.
+	identityProvider string
+	// serviceAccount is the GCP service account that the volume impersonates with the Pod identity.
+	serviceAccount string
+	// downscopeBucket limits the tokens to read-only access to the bucket, unless it is empty.
+	downscopeBucket string
+	// scope is the Cloud Storage OAuth scope of the tokens. gcsfuse never changes the ACLs of the objects,
+	// so the tokens never need full control.
+	scope string
+}
+
+func newVolumeTokenSource(mc *MountConfig) *volumeTokenSource {
+	ts := &volumeTokenSource{identityProvider: mc.TokenServerIdentityProvider, serviceAccount: mc.TokenServerServiceAccount, scope: storage.ScopeReadWrite}
+	if mc.TokenServerReadOnly {
+		ts.downscopeBucket = mc.BucketName
+		ts.scope = storage.ScopeReadOnly
+	}
+
+	return ts
+}
+
+// Token returns the access token served to gcsfuse. Pods with hostNetwork enabled exchange the Kubernetes service account token
+// for an IdentityBindingToken, and the other Pods get the token of the Kubernetes service account from the GKE metadata server.
+// The token is then exchanged for a token of the impersonated GCP service account, and downscoped for read-only volumes.
+func (ts *volumeTokenSource) Token(ctx context.Context) (*oauth2.Token, error) {
+	var token *oauth2.Token
+	if ts.identityProvider != "" {
+		k8stoken, err := getK8sTokenFromFile(webhook.SidecarContainerSATokenVolumeMountPath + "/" + webhook.K8STokenPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get k8s token from path: %w", err)
+		}
+
+		token, err = fetchIdentityBindingToken(ctx, k8stoken, ts.identityProvider)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get sts token: %w", err)
+		}
+	} else {
+		// Impersonation needs a token with the cloud-platform scope to call the IAM credentials API.
+		tokenScope := ts.scope
+		if ts.serviceAccount != "" {
+			tokenScope = credentials.DefaultAuthScopes()[0]
+		}
+		defaultTS, err := google.DefaultTokenSource(ctx, tokenScope)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the default token source: %w", err)
+		}
+
+		token, err = tokenWithContext(ctx, defaultTS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get token from the default token source: %w", err)
+		}
+	}
+
+	if ts.serviceAccount != "" {
+		var err error
+		impersonated := &auth.ImpersonatedTokenSource{Base: oauth2.StaticTokenSource(token), ServiceAccount: ts.serviceAccount, Scopes: []string{ts.scope}}
+		if token, err = impersonated.TokenWithContext(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if ts.downscopeBucket == "" {
+		return token, nil
+	}
+
+	return downscopeToken(ctx, token, ts.downscopeBucket)
+}
+
+// tokenWithContext returns a token of the token source, or the error of ctx once it is done. The default token source
+// of the GKE metadata server does not take a context, so the token is fetched in the background, and a request that outlives
+// ctx is left to finish on its own.
+func tokenWithContext(ctx context.Context, ts oauth2.TokenSource) (*oauth2.Token, error) {
+	type result struct {
+		token *oauth2.Token
+		err   error
+	}
+	// The channel is buffered, so that the goroutine does not leak when ctx is done first.
+	ch := make(chan result, 1)
+	go func() {
+		token, err := ts.Token()
+		ch <- result{token, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.token, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// capTokenExpiry returns a copy of the token that expires no later than refreshInterval after now,
+// so that gcsfuse fetches a new token from the token server at least that often. A zero refreshInterval keeps the token as is.
+func capTokenExpiry(token *oauth2.Token, refreshInterval time.Duration, now time.Time) *oauth2.Token {
+	if refreshInterval <= 0 {
+		return token
+	}
+
+	capped := *token
+	if expiry := now.Add(refreshInterval); capped.Expiry.IsZero() || capped.Expiry.After(expiry) {
+		capped.Expiry = expiry
+	}
+
+	return &capped
+}
+
+// StartTokenServer serves the access tokens of fetchToken to gcsfuse on a unix domain socket that only the sidecar container user can access.
+// The socket is removed when ctx is done, so no credential endpoint outlives the volume.
+// Tokens are never written to disk or logged. When refreshInterval is set, the tokens expire within the interval,
+// so that gcsfuse picks up rotated Kubernetes service account tokens and IAM changes without a remount.
+func StartTokenServer(ctx context.Context, tokenURLSocketPath string, fetchToken func(context.Context) (*oauth2.Token, error), refreshInterval time.Duration) {
+	// Remove the socket left behind if the sidecar container crashed.
+	removeTokenSocket(tokenURLSocketPath)
+
 	// Create a unix domain socket and listen for incoming connections.
 	tokenSocketListener, err := net.Listen("unix", tokenURLSocketPath)
 	if err != nil {
@@ -356,29 +597,32 @@ func StartTokenServer(ctx context.Context, tokenURLSocketPath string, identityPr
 
 		return
 	}
+	defer removeTokenSocket(tokenURLSocketPath)
+
+	if err := os.Chmod(tokenURLSocketPath, 0o600); err != nil {
+		klog.Errorf("failed to set the permissions of socket %q: %v", tokenURLSocketPath, err)
+		tokenSocketListener.Close()
+
+		return
+	}
 	klog.Infof("created a listener using the socket path %s", tokenURLSocketPath)
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
-		ctx, cancel := context.WithCancel(ctx)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		// The token fetch is aborted when gcsfuse cancels the request or when the token server stops.
+		reqCtx, cancel := context.WithCancel(r.Context())
 		defer cancel()
+		stop := context.AfterFunc(ctx, cancel)
+		defer stop()
 
-		k8stoken, err := getK8sTokenFromFile(webhook.SidecarContainerSATokenVolumeMountPath + "/" + webhook.K8STokenPath)
-		var stsToken *oauth2.Token
-		if err != nil {
-			klog.Errorf("failed to get k8s token from path %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-
-			return
-		}
-		stsToken, err = fetchIdentityBindingToken(ctx, k8stoken, identityProvider)
+		token, err := fetchToken(reqCtx)
 		if err != nil {
-			klog.Errorf("failed to get sts token from path %v", err)
+			klog.Errorf("failed to fetch token: %v", err)
 			w.WriteHeader(http.StatusInternalServerError)
 
 			return
 		}
 		// Marshal the oauth2.Token object to JSON
-		jsonToken, err := json.Marshal(stsToken)
+		jsonToken, err := json.Marshal(capTokenExpiry(token, refreshInterval, time.Now()))
 		if err != nil {
 			klog.Errorf("failed to marshal token to JSON: %v", err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -396,7 +640,36 @@ func StartTokenServer(ctx context.Context, tokenURLSocketPath string, identityPr
 		WriteTimeout: 10 * time.Second,
 	}
 
+	go func() {
+		<-ctx.Done()
+		klog.V(4).Infof("closing the token server on %s", tokenURLSocketPath)
+		server.Close()
+	}()
+
 	if err := server.Serve(tokenSocketListener); !errors.Is(err, http.ErrServerClosed) {
 		klog.Errorf("Server for %q returns unexpected error: %v", tokenURLSocketPath, err)
 	}
 }
+
+// SweepStaleTokenSockets removes the token server sockets under the volume base path.
+// It must be called before any token server starts, because at that point all the sockets
+// were left behind by a previous sidecar container that did not exit cleanly.
+func SweepStaleTokenSockets(volumeBasePath string) {
+	paths, err := filepath.Glob(filepath.Join(volumeBasePath, "*", TokenFileName))
+	if err != nil {
+		klog.Errorf("failed to look up stale token sockets: %v", err)
+
+		return
+	}
+
+	for _, p := range paths {
+		klog.Infof("removing stale token socket %s", p)
+		removeTokenSocket(p)
+	}
+}
+
+func removeTokenSocket(tokenURLSocketPath string) {
+	if err := os.Remove(tokenURLSocketPath); err != nil && !os.IsNotExist(err) {
+		klog.Errorf("failed to remove token socket %q: %v", tokenURLSocketPath, err)
+	}
+}
@@ -19,18 +19,10 @@ package sidecarmounter
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
-	"net"
-	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
-	"syscall"
+	"time"
 
-	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
-	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/webhook"
-	"gopkg.in/yaml.v3"
 	"k8s.io/klog/v2"
 )
 
@@ -40,6 +32,9 @@ const (
 	unixSocketBasePath   = "unix://"
 	TokenFileName        = "token.sock" // #nosec G101
 	identityProviderFlag = "token-server-identity-provider"
+	readOnlyTokenFlag    = "token-server-read-only"
+	tokenRefreshFlag     = "token-server-refresh-secs"
+	impersonateFlag      = "token-server-impersonate-service-account"
 )
 
 // MountConfig contains the information gcsfuse needs.
@@ -56,77 +51,61 @@ type MountConfig struct {
 	FlagMap                     map[string]string     `json:"-"`
 	ConfigFileFlagMap           map[string]string     `json:"-"`
 	TokenServerIdentityProvider string                `json:"-"`
+	TokenServerReadOnly         bool                  `json:"-"`
+	TokenServerRefreshInterval  time.Duration         `json:"-"`
+	TokenServerServiceAccount   string                `json:"-"`
+	WorkloadRecommendations     bool                  `json:"-"`
+	// SidecarVersion is added to the gcsfuse app name, which gcsfuse reports in the User-Agent of its requests.
+	SidecarVersion string `json:"-"`
 }
 
-var prometheusPort = 62990
-
-var disallowedFlags = map[string]bool{
-	"temp-dir":                             true,
-	"config-file":                          true,
-	"foreground":                           true,
-	"log-file":                             true,
-	"log-format":                           true,
-	"key-file":                             true,
-	"token-url":                            true,
-	"reuse-token-from-url":                 true,
-	"o":                                    true,
-	"logging:log-rotate:max-file-size-mb":  true,
-	"logging:log-rotate:backup-file-count": true,
-	"logging:log-rotate:compress":          true,
-	"cache-dir":                            true,
-	"experimental-local-file-cache":        true,
-	"prometheus-port":                      true,
+// tokenServerEnabled returns whether gcsfuse gets its tokens from the sidecar token server.
+// This is the case for HostNetwork enabled pods, for read-only volumes that use downscoped tokens,
+// for volumes that refresh their tokens periodically to pick up identity changes,
+// and for volumes that impersonate a GCP service account.
+func (mc *MountConfig) tokenServerEnabled() bool {
+	return mc.TokenServerIdentityProvider != "" || mc.TokenServerReadOnly || mc.TokenServerRefreshInterval > 0 || mc.TokenServerServiceAccount != ""
 }
 
-var boolFlags = map[string]bool{
-	"implicit-dirs":                 true,
-	"enable-nonexistent-type-cache": true,
-	"debug_fuse_errors":             true,
-	"debug_fuse":                    true,
-	"debug_fs":                      true,
-	"debug_gcs":                     true,
-	"debug_http":                    true,
-	"debug_invariants":              true,
-	"debug_mutex":                   true,
+// Handshake is the message the sidecar mounter sends to the CSI driver right after connecting to the socket of a volume,
+// so that the CSI driver can detect sidecar container images that are too old.
+type Handshake struct {
+	Version string `json:"version"`
 }
 
-// Fetch the following information from a given socket path:
+// NewMountConfig fetches the following information from a given socket path:
 // 1. Pod volume name
 // 2. The file descriptor
 // 3. GCS bucket name
 // 4. Mount options passing to gcsfuse (passed by the csi mounter).
-func NewMountConfig(sp string) *MountConfig {
+// The sidecar mounter version is sent to the CSI driver before receiving the information.
+func (m *Mounter) NewMountConfig(sp, version string) *MountConfig {
 	// socket path pattern: /gcsfuse-tmp/.volumes/<volume-name>/socket
 	tempDir := filepath.Dir(sp)
 	volumeName := filepath.Base(tempDir)
 	mc := MountConfig{
 		VolumeName: volumeName,
-		BufferDir:  filepath.Join(webhook.SidecarContainerBufferVolumeMountPath, ".volumes", volumeName),
-		CacheDir:   filepath.Join(webhook.SidecarContainerCacheVolumeMountPath, ".volumes", volumeName),
+		BufferDir:  filepath.Join(m.bufferDir, ".volumes", volumeName),
+		CacheDir:   filepath.Join(m.cacheDir, ".volumes", volumeName),
 		TempDir:    tempDir,
-		ConfigFile: filepath.Join(webhook.SidecarContainerTmpVolumeMountPath, ".volumes", volumeName, "config.yaml"),
+		ConfigFile: filepath.Join(m.tmpDir, ".volumes", volumeName, "config.yaml"),
 		ErrWriter:  NewErrorWriter(filepath.Join(tempDir, "error")),
 	}
+	if version != "unknown" {
+		mc.SidecarVersion = version
+	}
 
-	klog.Infof("connecting to socket %q", sp)
-	c, err := net.Dial("unix", sp)
+	handshake, err := json.Marshal(Handshake{Version: version})
 	if err != nil {
-		mc.ErrWriter.WriteMsg(fmt.Sprintf("failed to connect to the socket %q: %v", sp, err))
-
-		return nil
+		klog.Warningf("failed to marshal the handshake: %v", err)
 	}
 
-	fd, msg, err := util.RecvMsg(c)
+	fd, msg, err := m.sys.ReceiveMount(sp, handshake)
 	if err != nil {
-		mc.ErrWriter.WriteMsg(fmt.Sprintf("failed to receive mount options from the socket %q: %v", sp, err))
+		mc.ErrWriter.WriteMsg(err.Error())
 
 		return nil
 	}
-	// as we got all the information from the socket, closing the connection and deleting the socket
-	c.Close()
-	if err = syscall.Unlink(sp); err != nil {
-		klog.Errorf("failed to close socket %q: %v", sp, err)
-	}
 
 	mc.FileDescriptor = fd
 
@@ -142,161 +121,11 @@ func NewMountConfig(sp string) *MountConfig {
 		return nil
 	}
 
-	mc.prepareMountArgs()
-	if err := mc.prepareConfigFile(); err != nil {
-		mc.ErrWriter.WriteMsg(fmt.Sprintf("failed to create config file %q: %v", mc.ConfigFile, err))
+	if err := m.fuseClient().Configure(&mc); err != nil {
+		mc.ErrWriter.WriteMsg(err.Error())
 
 		return nil
 	}
 
 	return &mc
 }
-
-func (mc *MountConfig) prepareMountArgs() {
-	flagMap := map[string]string{
-		"app-name":    GCSFuseAppName,
-		"temp-dir":    mc.BufferDir + TempDir,
-		"config-file": mc.ConfigFile,
-		"foreground":  "",
-		"uid":         "0",
-		"gid":         "0",
-	}
-
-	configFileFlagMap := map[string]string{
-		"logging:file-path": "/dev/fd/1", // redirect the output to cmd stdout
-		"logging:format":    "json",
-		"cache-dir":         "", // by default the gcsfuse file cache is disabled on GKE
-	}
-
-	invalidArgs := []string{}
-
-	for _, arg := range mc.Options {
-		if strings.Contains(arg, ":") && !strings.Contains(arg, "https") {
-			i := strings.LastIndex(arg, ":")
-			f, v := arg[:i], arg[i+1:]
-
-			if f == util.DisableMetricsForGKE {
-				if v == util.FalseStr {
-					flagMap["prometheus-port"] = strconv.Itoa(prometheusPort)
-					// Use a new port each gcsfuse instance that we start.
-					prometheusPort++
-				}
-
-				continue
-			}
-
-			if disallowedFlags[f] {
-				invalidArgs = append(invalidArgs, arg)
-			} else {
-				configFileFlagMap[f] = v
-			}
-
-			// if the value of flag file-cache:max-size-mb is not 0,
-			// enable the file cache feature by passing the cache directory.
-			if f == "file-cache:max-size-mb" && v != "0" {
-				configFileFlagMap["cache-dir"] = mc.CacheDir
-			}
-
-			continue
-		}
-
-		argPair := strings.SplitN(arg, "=", 2)
-		if len(argPair) == 0 {
-			continue
-		}
-
-		flag := argPair[0]
-		if disallowedFlags[flag] {
-			invalidArgs = append(invalidArgs, arg)
-
-			continue
-		}
-
-		value := ""
-		if len(argPair) > 1 {
-			value = argPair[1]
-		}
-
-		if flag == identityProviderFlag {
-			mc.TokenServerIdentityProvider = value
-
-			continue
-		}
-
-		switch {
-		case boolFlags[flag] && value != "":
-			flag = flag + "=" + value
-			if value == util.TrueStr || value == util.FalseStr {
-				value = ""
-			} else {
-				invalidArgs = append(invalidArgs, flag)
-
-				continue
-			}
-		case flag == "app-name":
-			value = GCSFuseAppName + "-" + value
-		}
-
-		flagMap[flag] = value
-	}
-
-	if len(invalidArgs) > 0 {
-		klog.Warningf("got invalid arguments for volume %q: %v. Will discard invalid args and continue to mount.",
-			invalidArgs, mc.VolumeName)
-	}
-	mc.FlagMap, mc.ConfigFileFlagMap = flagMap, configFileFlagMap
-}
-
-func (mc *MountConfig) prepareConfigFile() error {
-	if mc.ConfigFileFlagMap == nil {
-		return errors.New("got empty config file flag map")
-	}
-
-	configMap := map[string]interface{}{}
-
-	for f, v := range mc.ConfigFileFlagMap {
-		curLevel := configMap
-		tokens := strings.Split(f, ":")
-		for i, t := range tokens {
-			if i == len(tokens)-1 {
-				if _, ok := curLevel[t].(map[string]interface{}); ok {
-					return fmt.Errorf("invalid config file flag: %q", f)
-				}
-
-				if intVal, err := strconv.ParseInt(v, 10, 64); err == nil {
-					curLevel[t] = intVal
-				} else if boolVal, err := strconv.ParseBool(v); err == nil {
-					curLevel[t] = boolVal
-				} else {
-					curLevel[t] = v
-				}
-
-				break
-			}
-
-			if _, ok := curLevel[t]; !ok {
-				curLevel[t] = map[string]interface{}{}
-			}
-
-			if nextLevel, ok := curLevel[t].(map[string]interface{}); ok {
-				curLevel = nextLevel
-			} else {
-				return fmt.Errorf("invalid config file flag: %q", f)
-			}
-		}
-	}
-	if mc.TokenServerIdentityProvider != "" {
-		configMap["gcs-auth"] = map[string]interface{}{
-			"token-url": unixSocketBasePath + filepath.Join(mc.TempDir, TokenFileName),
-		}
-	}
-
-	yamlData, err := yaml.Marshal(&configMap)
-	if err != nil {
-		return err
-	}
-
-	klog.Infof("gcsfuse config file content: %v", configMap)
-
-	return os.WriteFile(mc.ConfigFile, yamlData, 0o400)
-}
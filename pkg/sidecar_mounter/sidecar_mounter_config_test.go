@@ -22,6 +22,7 @@ import (
 	"reflect"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/util"
 	"gopkg.in/yaml.v3"
@@ -65,10 +66,12 @@ func TestPrepareMountArgs(t *testing.T) {
 	t.Parallel()
 
 	testCases := []struct {
-		name                  string
-		mc                    *MountConfig
-		expectedArgs          map[string]string
-		expectedConfigMapArgs map[string]string
+		name                    string
+		mc                      *MountConfig
+		expectedArgs            map[string]string
+		expectedConfigMapArgs   map[string]string
+		expectedRefreshInterval time.Duration
+		expectedServiceAccount  string
 	}{
 		{
 			name: "should return valid args correctly",
@@ -88,7 +91,7 @@ func TestPrepareMountArgs(t *testing.T) {
 				BufferDir:  "test-buffer-dir",
 				CacheDir:   "test-cache-dir",
 				ConfigFile: "test-config-file",
-				Options:    []string{"uid=100", "gid=200", "token-server-identity-provider=https://fakeresource", "debug_gcs", "max-conns-per-host=10", "implicit-dirs", "write:create-empty-file:false", "logging:severity:error", "write:create-empty-file:true"},
+				Options:    []string{"uid=100", "gid=200", "token-server-identity-provider=https://fakeresource", "token-server-read-only", "debug_gcs", "max-conns-per-host=10", "implicit-dirs", "write:create-empty-file:false", "logging:severity:error", "write:create-empty-file:true"},
 			},
 			expectedArgs: map[string]string{
 				"implicit-dirs":      "",
@@ -131,6 +134,27 @@ func TestPrepareMountArgs(t *testing.T) {
 			},
 			expectedConfigMapArgs: defaultConfigFileFlagMap,
 		},
+		{
+			name: "should return valid args with experimental options correctly",
+			mc: &MountConfig{
+				BucketName: "test-bucket",
+				BufferDir:  "test-buffer-dir",
+				CacheDir:   "test-cache-dir",
+				ConfigFile: "test-config-file",
+				Options:    []string{"experimental-enable-json-read=true", "experimental-metadata-prefetch-on-mount=async"},
+			},
+			expectedArgs: map[string]string{
+				"experimental-enable-json-read=true":      "",
+				"experimental-metadata-prefetch-on-mount": "async",
+				"app-name":    GCSFuseAppName,
+				"temp-dir":    "test-buffer-dir/temp-dir",
+				"config-file": "test-config-file",
+				"foreground":  "",
+				"uid":         "0",
+				"gid":         "0",
+			},
+			expectedConfigMapArgs: defaultConfigFileFlagMap,
+		},
 		{
 			name: "should return valid args with error correctly",
 			mc: &MountConfig{
@@ -233,6 +257,32 @@ func TestPrepareMountArgs(t *testing.T) {
 			},
 			expectedConfigMapArgs: defaultConfigFileFlagMap,
 		},
+		{
+			name: "should parse the token refresh interval without passing it to gcsfuse",
+			mc: &MountConfig{
+				BucketName: "test-bucket",
+				BufferDir:  "test-buffer-dir",
+				CacheDir:   "test-cache-dir",
+				ConfigFile: "test-config-file",
+				Options:    []string{"token-server-refresh-secs=300"},
+			},
+			expectedArgs:            defaultFlagMap,
+			expectedConfigMapArgs:   defaultConfigFileFlagMap,
+			expectedRefreshInterval: 5 * time.Minute,
+		},
+		{
+			name: "should parse the impersonated service account without passing it to gcsfuse",
+			mc: &MountConfig{
+				BucketName: "test-bucket",
+				BufferDir:  "test-buffer-dir",
+				CacheDir:   "test-cache-dir",
+				ConfigFile: "test-config-file",
+				Options:    []string{"token-server-impersonate-service-account=sa-name@test-project.iam.gserviceaccount.com"},
+			},
+			expectedArgs:           defaultFlagMap,
+			expectedConfigMapArgs:  defaultConfigFileFlagMap,
+			expectedServiceAccount: "sa-name@test-project.iam.gserviceaccount.com",
+		},
 	}
 
 	prometheusPort := 62990
@@ -261,10 +311,70 @@ func TestPrepareMountArgs(t *testing.T) {
 			if !reflect.DeepEqual(tc.mc.ConfigFileFlagMap, tc.expectedConfigMapArgs) {
 				t.Errorf("Got config file args %v, but expected %v", tc.mc.ConfigFileFlagMap, tc.expectedConfigMapArgs)
 			}
+
+			if tc.mc.TokenServerRefreshInterval != tc.expectedRefreshInterval {
+				t.Errorf("Got token refresh interval %v, but expected %v", tc.mc.TokenServerRefreshInterval, tc.expectedRefreshInterval)
+			}
+
+			if tc.mc.TokenServerServiceAccount != tc.expectedServiceAccount {
+				t.Errorf("Got impersonated service account %q, but expected %q", tc.mc.TokenServerServiceAccount, tc.expectedServiceAccount)
+			}
 		})
 	}
 }
 
+// TestPrepareMountArgsWorkloadRecommendations does not run in parallel with the other tests, because it allocates prometheus ports.
+// The next port is restored afterwards, so that the other tests allocate the ports they expect.
+//
+//nolint:paralleltest
+func TestPrepareMountArgsWorkloadRecommendations(t *testing.T) {
+	nextPort := prometheusPort
+	t.Cleanup(func() { prometheusPort = nextPort })
+
+	testCases := []struct {
+		name                            string
+		options                         []string
+		expectedWorkloadRecommendations bool
+		expectedPrometheusPort          bool
+	}{
+		{
+			name:                            "should serve the metrics for the workload analysis",
+			options:                         []string{util.WorkloadRecommendations + "=true"},
+			expectedWorkloadRecommendations: true,
+			expectedPrometheusPort:          true,
+		},
+		{
+			name:    "should not analyze the workload when disabled",
+			options: []string{util.WorkloadRecommendations + "=false"},
+		},
+		{
+			name:                            "should keep the prometheus port of the metrics collection",
+			options:                         []string{util.DisableMetricsForGKE + ":false", util.WorkloadRecommendations + "=true"},
+			expectedWorkloadRecommendations: true,
+			expectedPrometheusPort:          true,
+		},
+	}
+
+	for _, tc := range testCases {
+		mc := &MountConfig{BucketName: "test-bucket", BufferDir: "test-buffer-dir", ConfigFile: "test-config-file", Options: tc.options}
+		port := prometheusPort
+		mc.prepareMountArgs()
+
+		if mc.WorkloadRecommendations != tc.expectedWorkloadRecommendations {
+			t.Errorf("%v: got workload recommendations %v, but expected %v", tc.name, mc.WorkloadRecommendations, tc.expectedWorkloadRecommendations)
+		}
+		if _, ok := mc.FlagMap["prometheus-port"]; ok != tc.expectedPrometheusPort {
+			t.Errorf("%v: got prometheus port %q, but expected it set: %v", tc.name, mc.FlagMap["prometheus-port"], tc.expectedPrometheusPort)
+		}
+		if tc.expectedPrometheusPort && prometheusPort != port+1 {
+			t.Errorf("%v: allocated %v prometheus ports, but expected 1", tc.name, prometheusPort-port)
+		}
+		if _, ok := mc.FlagMap[util.WorkloadRecommendations]; ok {
+			t.Errorf("%v: the %v option should not be passed to gcsfuse", tc.name, util.WorkloadRecommendations)
+		}
+	}
+}
+
 func TestPrepareConfigFile(t *testing.T) {
 	t.Parallel()
 
@@ -350,6 +460,44 @@ func TestPrepareConfigFile(t *testing.T) {
 				"gcs-auth":  map[string]interface{}{"token-url": "unix:///gcsfuse-tmp/.volumes/vol1/token.sock"},
 			},
 		},
+		{
+			name: "should create valid config file when read-only tokens are enabled",
+			mc: &MountConfig{
+				ConfigFile: "./test-config-file.yaml",
+				TempDir:    "/gcsfuse-tmp/.volumes/vol1",
+				ConfigFileFlagMap: map[string]string{
+					"logging:file-path": "/dev/fd/1",
+					"logging:format":    "json",
+				},
+				TokenServerReadOnly: true,
+			},
+			expectedConfig: map[string]interface{}{
+				"logging": map[string]interface{}{
+					"file-path": "/dev/fd/1",
+					"format":    "json",
+				},
+				"gcs-auth": map[string]interface{}{"token-url": "unix:///gcsfuse-tmp/.volumes/vol1/token.sock"},
+			},
+		},
+		{
+			name: "should create valid config file when the token refresh is enabled",
+			mc: &MountConfig{
+				ConfigFile: "./test-config-file.yaml",
+				TempDir:    "/gcsfuse-tmp/.volumes/vol1",
+				ConfigFileFlagMap: map[string]string{
+					"logging:file-path": "/dev/fd/1",
+					"logging:format":    "json",
+				},
+				TokenServerRefreshInterval: 5 * time.Minute,
+			},
+			expectedConfig: map[string]interface{}{
+				"logging": map[string]interface{}{
+					"file-path": "/dev/fd/1",
+					"format":    "json",
+				},
+				"gcs-auth": map[string]interface{}{"token-url": "unix:///gcsfuse-tmp/.volumes/vol1/token.sock"},
+			},
+		},
 		{
 			name: "should throw error when incorrect flag is passed",
 			mc: &MountConfig{
@@ -0,0 +1,368 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecarmounter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/metrics"
+
+	"golang.org/x/oauth2"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestStartTokenServer(t *testing.T) {
+	t.Parallel()
+
+	tokenPath := filepath.Join(t.TempDir(), TokenFileName)
+	// A stale socket left behind by a crashed sidecar container.
+	if err := os.WriteFile(tokenPath, nil, 0o644); err != nil {
+		t.Fatalf("failed to create the stale token socket: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		StartTokenServer(ctx, tokenPath, newVolumeTokenSource(&MountConfig{TokenServerIdentityProvider: "test-identity-provider"}).Token, 0)
+		close(done)
+	}()
+
+	err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		fi, err := os.Stat(tokenPath)
+
+		return err == nil && fi.Mode()&os.ModeSocket != 0, nil
+	})
+	if err != nil {
+		t.Fatalf("token socket was not created: %v", err)
+	}
+
+	fi, err := os.Stat(tokenPath)
+	if err != nil {
+		t.Fatalf("failed to stat the token socket: %v", err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0o600 {
+		t.Errorf("got token socket permissions %v, expected %v", perm, os.FileMode(0o600))
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("token server did not stop after the context was canceled")
+	}
+
+	if _, err := os.Stat(tokenPath); !os.IsNotExist(err) {
+		t.Errorf("got error %v, expected the token socket to be removed", err)
+	}
+}
+
+func TestStartTokenServerMixedIdentities(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Two volumes of the same Pod, each with the token source of its own identity.
+	tokens := map[string]string{"volume-a": "token-of-sa-a", "volume-b": "token-of-sa-b"}
+	tokenPaths := map[string]string{}
+	for volume, accessToken := range tokens {
+		tokenPaths[volume] = filepath.Join(t.TempDir(), TokenFileName)
+		fetchToken := func(context.Context) (*oauth2.Token, error) {
+			return &oauth2.Token{AccessToken: accessToken, Expiry: time.Now().Add(time.Hour)}, nil
+		}
+		go StartTokenServer(ctx, tokenPaths[volume], fetchToken, time.Minute)
+	}
+
+	for volume, tokenPath := range tokenPaths {
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", tokenPath)
+			},
+		}}
+
+		var token oauth2.Token
+		err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+			if err != nil {
+				return false, err
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return false, nil
+			}
+			defer resp.Body.Close()
+
+			return true, json.NewDecoder(resp.Body).Decode(&token)
+		})
+		if err != nil {
+			t.Fatalf("failed to get the token of %s: %v", volume, err)
+		}
+
+		if token.AccessToken != tokens[volume] {
+			t.Errorf("got token %q for %s, expected %q", token.AccessToken, volume, tokens[volume])
+		}
+		if time.Until(token.Expiry) > time.Minute {
+			t.Errorf("got token expiry %v for %s, expected it to be capped to the refresh interval", token.Expiry, volume)
+		}
+	}
+}
+
+func TestStartTokenServerCanceledRequest(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tokenPath := filepath.Join(t.TempDir(), TokenFileName)
+	started := make(chan struct{})
+	aborted := make(chan error, 1)
+	fetchToken := func(ctx context.Context) (*oauth2.Token, error) {
+		close(started)
+		<-ctx.Done()
+		aborted <- ctx.Err()
+
+		return nil, ctx.Err()
+	}
+	go StartTokenServer(ctx, tokenPath, fetchToken, 0)
+
+	err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		_, err := os.Stat(tokenPath)
+
+		return err == nil, nil
+	})
+	if err != nil {
+		t.Fatalf("token socket was not created: %v", err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", tokenPath)
+		},
+	}}
+	reqCtx, cancelReq := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, "http://localhost/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		<-started
+		cancelReq()
+	}()
+	if resp, err := client.Do(req); err == nil {
+		resp.Body.Close()
+		t.Fatal("got a response, expected the request to be canceled")
+	}
+
+	select {
+	case err := <-aborted:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got error %v, expected %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the token fetch was not aborted after the request was canceled")
+	}
+}
+
+func TestNewVolumeTokenSource(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		mc       *MountConfig
+		expected *volumeTokenSource
+	}{
+		{
+			name:     "Pod identity",
+			mc:       &MountConfig{BucketName: "test-bucket", TokenServerRefreshInterval: time.Minute},
+			expected: &volumeTokenSource{scope: storage.ScopeReadWrite},
+		},
+		{
+			name:     "impersonated service account",
+			mc:       &MountConfig{BucketName: "test-bucket", TokenServerServiceAccount: "sa-a@test-project.iam.gserviceaccount.com"},
+			expected: &volumeTokenSource{serviceAccount: "sa-a@test-project.iam.gserviceaccount.com", scope: storage.ScopeReadWrite},
+		},
+		{
+			name: "read-only volume of an impersonated service account with hostNetwork",
+			mc: &MountConfig{
+				BucketName:                  "test-bucket",
+				TokenServerIdentityProvider: "test-identity-provider",
+				TokenServerReadOnly:         true,
+				TokenServerServiceAccount:   "sa-b@test-project.iam.gserviceaccount.com",
+			},
+			expected: &volumeTokenSource{identityProvider: "test-identity-provider", serviceAccount: "sa-b@test-project.iam.gserviceaccount.com", downscopeBucket: "test-bucket", scope: storage.ScopeReadOnly},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if got := newVolumeTokenSource(tc.mc); *got != *tc.expected {
+				t.Errorf("got token source %+v, expected %+v", got, tc.expected)
+			}
+		})
+	}
+}
+
+// blockingTokenSource blocks until release is closed.
+type blockingTokenSource struct {
+	release chan struct{}
+}
+
+func (ts blockingTokenSource) Token() (*oauth2.Token, error) {
+	<-ts.release
+
+	return &oauth2.Token{AccessToken: "test-token"}, nil
+}
+
+func TestTokenWithContext(t *testing.T) {
+	t.Parallel()
+
+	ts := blockingTokenSource{release: make(chan struct{})}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := tokenWithContext(ctx, ts); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, expected %v", err, context.DeadlineExceeded)
+	}
+
+	close(ts.release)
+	token, err := tokenWithContext(context.Background(), ts)
+	if err != nil || token.AccessToken != "test-token" {
+		t.Errorf("got token %+v and error %v, expected the test token", token, err)
+	}
+}
+
+func TestCapTokenExpiry(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name            string
+		expiry          time.Time
+		refreshInterval time.Duration
+		expectedExpiry  time.Time
+	}{
+		{
+			name:           "keeps the expiry without a refresh interval",
+			expiry:         now.Add(time.Hour),
+			expectedExpiry: now.Add(time.Hour),
+		},
+		{
+			name:            "caps the expiry to the refresh interval",
+			expiry:          now.Add(time.Hour),
+			refreshInterval: 5 * time.Minute,
+			expectedExpiry:  now.Add(5 * time.Minute),
+		},
+		{
+			name:            "keeps an expiry within the refresh interval",
+			expiry:          now.Add(time.Minute),
+			refreshInterval: 5 * time.Minute,
+			expectedExpiry:  now.Add(time.Minute),
+		},
+		{
+			name:            "sets the expiry of a token that never expires",
+			refreshInterval: 5 * time.Minute,
+			expectedExpiry:  now.Add(5 * time.Minute),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			token := &oauth2.Token{AccessToken: "token", Expiry: tc.expiry}
+			capped := capTokenExpiry(token, tc.refreshInterval, now)
+			if !capped.Expiry.Equal(tc.expectedExpiry) {
+				t.Errorf("got expiry %v, expected %v", capped.Expiry, tc.expectedExpiry)
+			}
+			if capped.AccessToken != token.AccessToken {
+				t.Errorf("got access token %q, expected %q", capped.AccessToken, token.AccessToken)
+			}
+			if !token.Expiry.Equal(tc.expiry) {
+				t.Errorf("the expiry of the original token changed to %v", token.Expiry)
+			}
+		})
+	}
+}
+
+func TestSweepStaleTokenSockets(t *testing.T) {
+	t.Parallel()
+
+	volumeBasePath := t.TempDir()
+	for _, volume := range []string{"volume-1", "volume-2"} {
+		if err := os.MkdirAll(filepath.Join(volumeBasePath, volume), 0o750); err != nil {
+			t.Fatalf("failed to create the volume dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(volumeBasePath, volume, TokenFileName), nil, 0o600); err != nil {
+			t.Fatalf("failed to create the stale token socket: %v", err)
+		}
+	}
+	configFile := filepath.Join(volumeBasePath, "volume-1", "config.yaml")
+	if err := os.WriteFile(configFile, nil, 0o400); err != nil {
+		t.Fatalf("failed to create the config file: %v", err)
+	}
+
+	SweepStaleTokenSockets(volumeBasePath)
+
+	paths, err := filepath.Glob(filepath.Join(volumeBasePath, "*", TokenFileName))
+	if err != nil {
+		t.Fatalf("failed to look up token sockets: %v", err)
+	}
+	if len(paths) != 0 {
+		t.Errorf("got stale token sockets %v, expected none", paths)
+	}
+	if _, err := os.Stat(configFile); err != nil {
+		t.Errorf("expected the config file to be kept, got error %v", err)
+	}
+}
+
+func TestWriteProcessMetrics(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	if err := writeProcessMetrics(&buf, newProcessMetricsRegistry(os.Getpid())); err != nil {
+		t.Fatalf("failed to write the process metrics: %v", err)
+	}
+
+	families, err := metrics.ProcessMetricsData(&buf)
+	if err != nil {
+		t.Fatalf("failed to parse the process metrics: %v", err)
+	}
+
+	for _, name := range []string{
+		"gke_gcsfuse_csi_sidecar_gcsfuse_process_cpu_seconds_total",
+		"gke_gcsfuse_csi_sidecar_gcsfuse_process_resident_memory_bytes",
+		"gke_gcsfuse_csi_sidecar_gcsfuse_process_open_fds",
+	} {
+		mf, ok := families[name]
+		if !ok || len(mf.GetMetric()) != 1 {
+			t.Fatalf("got metric families %v, expected one %q metric", families, name)
+		}
+	}
+	if rss := families["gke_gcsfuse_csi_sidecar_gcsfuse_process_resident_memory_bytes"].GetMetric()[0].GetGauge().GetValue(); rss <= 0 {
+		t.Errorf("got resident memory %v bytes, expected a positive value", rss)
+	}
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecarmounter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// UploadBarrierPath is the path of the sidecar API that blocks until the writes staged by gcsfuse are uploaded to GCS.
+	// The volume query parameters select the volumes, all the volumes by default, and the timeout query parameter
+	// bounds the wait, for example /v1/uploads/wait?volume=checkpoints&timeout=60s.
+	UploadBarrierPath = "/v1/uploads/wait"
+
+	uploadBarrierPollInterval = time.Second
+)
+
+// gcsfuseProcess is a running gcsfuse process, and the directory where it stages the writes before uploading them.
+type gcsfuseProcess struct {
+	pid     int
+	tempDir string
+	// statusDir is the emptyDir path of the volume, where the sidecar mounter reports the drain progress to the CSI driver.
+	statusDir string
+	// streamingWrites is whether gcsfuse uploads the writes as they are written instead of staging them. The streamed writes
+	// are buffered in the memory of the process and have no open file descriptor, so the upload barrier cannot track them.
+	streamingWrites bool
+}
+
+func (m *Mounter) addProcess(volumeName string, p gcsfuseProcess) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.processes[volumeName] = p
+}
+
+func (m *Mounter) removeProcess(volumeName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.processes, volumeName)
+}
+
+// errUnknownVolume is returned for volumes that have no running gcsfuse process.
+var errUnknownVolume = errors.New("no gcsfuse process runs for the volume")
+
+// pendingUploads returns the number of files that the gcsfuse processes of the volumes have staged and not uploaded yet,
+// for the volumes that have any. gcsfuse unlinks the staged files as soon as it creates them, so they are found through
+// the open file descriptors of the process. No volumes selects all the volumes.
+func (m *Mounter) pendingUploads(volumes []string) (map[string]int, error) {
+	m.mu.Lock()
+	processes := map[string]gcsfuseProcess{}
+	if len(volumes) == 0 {
+		for v, p := range m.processes {
+			processes[v] = p
+		}
+	}
+	for _, v := range volumes {
+		p, ok := m.processes[v]
+		if !ok {
+			m.mu.Unlock()
+
+			return nil, fmt.Errorf("%w %q", errUnknownVolume, v)
+		}
+		processes[v] = p
+	}
+	m.mu.Unlock()
+
+	pending := map[string]int{}
+	for v, p := range processes {
+		_, staged, err := m.openFiles(p)
+		if err != nil {
+			// The process exited, so there is nothing left to upload.
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, fmt.Errorf("failed to list the open files of the gcsfuse process of volume %q: %w", v, err)
+		}
+		if staged > 0 {
+			pending[v] = staged
+		}
+	}
+
+	return pending, nil
+}
+
+// streamingVolumes returns the sorted names of the volumes whose gcsfuse processes stream the writes, so the pending
+// uploads of these volumes are not tracked. No volumes selects all the volumes.
+func (m *Mounter) streamingVolumes(volumes []string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(volumes) == 0 {
+		volumes = slices.Collect(maps.Keys(m.processes))
+	}
+	streaming := []string{}
+	for _, v := range volumes {
+		if m.processes[v].streamingWrites {
+			streaming = append(streaming, v)
+		}
+	}
+	slices.Sort(streaming)
+
+	return streaming
+}
+
+// openFiles returns the number of open file descriptors of the gcsfuse process, and how many of them are staged writes.
+func (m *Mounter) openFiles(p gcsfuseProcess) (int, int, error) {
+	fdDir := filepath.Join(m.procDir, strconv.Itoa(p.pid), "fd")
+	entries, err := os.ReadDir(fdDir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	staged := 0
+	for _, e := range entries {
+		// The file descriptor may be closed since the directory was listed.
+		target, err := os.Readlink(filepath.Join(fdDir, e.Name()))
+		if err == nil && strings.HasPrefix(target, p.tempDir+"/") {
+			staged++
+		}
+	}
+
+	return len(entries), staged, nil
+}
+
+// WaitForUploads blocks until the gcsfuse processes of the volumes have uploaded all the staged writes, or ctx is done.
+func (m *Mounter) WaitForUploads(ctx context.Context, volumes []string) error {
+	var pending map[string]int
+	err := wait.PollUntilContextCancel(ctx, uploadBarrierPollInterval, true, func(context.Context) (bool, error) {
+		var err error
+		pending, err = m.pendingUploads(volumes)
+
+		return len(pending) == 0, err
+	})
+	if err != nil && len(pending) > 0 {
+		return fmt.Errorf("staged writes are not uploaded yet, the number of staged files per volume is %v: %w", pending, err)
+	}
+
+	return err
+}
+
+// ServeUploadBarrier serves the upload barrier and the drain status APIs on the loopback interface, so that only the containers
+// of the Pod can call them.
+func (m *Mounter) ServeUploadBarrier(port int) {
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	mux := http.NewServeMux()
+	mux.HandleFunc(UploadBarrierPath, m.handleUploadBarrier)
+	mux.HandleFunc(DrainStatusPath, m.handleDrainStatus)
+
+	// No write timeout, the requests block until the uploads complete or the timeout of the request.
+	server := http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	klog.Infof("serving the upload barrier at %v%v", address, UploadBarrierPath)
+	if err := server.ListenAndServe(); err != nil {
+		klog.Errorf("failed to serve the upload barrier at %q: %v", address, err)
+	}
+}
+
+// handleUploadBarrier responds once the staged writes of the selected volumes are uploaded, or the timeout expires.
+func (m *Mounter) handleUploadBarrier(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		timeout, err := time.ParseDuration(t)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid timeout %q: %v", t, err), http.StatusBadRequest)
+
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := m.WaitForUploads(ctx, r.URL.Query()["volume"])
+	switch {
+	case errors.Is(err, errUnknownVolume):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusGatewayTimeout)
+	default:
+		klog.Infof("the staged writes are uploaded after %v", time.Since(start))
+		if streaming := m.streamingVolumes(r.URL.Query()["volume"]); len(streaming) > 0 {
+			klog.Warningf("the streaming writes of volumes %v are not tracked by the upload barrier", streaming)
+			fmt.Fprintf(w, "the staged writes are uploaded, the streaming writes of volumes %v are not tracked\n", streaming)
+
+			return
+		}
+		fmt.Fprintln(w, "the staged writes are uploaded")
+	}
+}
+
+// CallUploadBarrier calls the upload barrier API of the sidecar mounter at the port, and blocks until all the staged writes
+// are uploaded. The sidecar container preStop hook calls it, so that the gcsfuse processes are not terminated before.
+func CallUploadBarrier(ctx context.Context, port int) error {
+	url := "http://" + net.JoinHostPort("127.0.0.1", strconv.Itoa(port)) + UploadBarrierPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request to %q: %w", url, err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call the upload barrier %q: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+
+		return fmt.Errorf("the upload barrier %q returned status %v: %s", url, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecarmounter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeProcess creates the open file descriptors of a gcsfuse process in a fake proc file system.
+func fakeProcess(t *testing.T, procDir string, pid int, targets ...string) {
+	t.Helper()
+
+	fdDir := filepath.Join(procDir, strconv.Itoa(pid), "fd")
+	if err := os.MkdirAll(fdDir, 0o755); err != nil {
+		t.Fatalf("failed to create %q: %v", fdDir, err)
+	}
+	for i, target := range targets {
+		if err := os.Symlink(target, filepath.Join(fdDir, strconv.Itoa(i))); err != nil {
+			t.Fatalf("failed to create the file descriptor %v of process %v: %v", i, pid, err)
+		}
+	}
+}
+
+func newFakeMounter(t *testing.T) *Mounter {
+	t.Helper()
+
+	procDir := t.TempDir()
+	fakeProcess(t, procDir, 100, "/dev/null", "/gcsfuse-buffer/.volumes/idle/temp-dir-other/file")
+	fakeProcess(t, procDir, 200, "/dev/null", "/gcsfuse-buffer/.volumes/busy/temp-dir/file1 (deleted)", "/gcsfuse-buffer/.volumes/busy/temp-dir/file2 (deleted)")
+
+	return &Mounter{
+		procDir: procDir,
+		processes: map[string]gcsfuseProcess{
+			"idle":      {pid: 100, tempDir: "/gcsfuse-buffer/.volumes/idle/temp-dir"},
+			"busy":      {pid: 200, tempDir: "/gcsfuse-buffer/.volumes/busy/temp-dir"},
+			"exited":    {pid: 300, tempDir: "/gcsfuse-buffer/.volumes/exited/temp-dir"},
+			"streaming": {pid: 400, tempDir: "/gcsfuse-buffer/.volumes/streaming/temp-dir", streamingWrites: true},
+		},
+	}
+}
+
+func TestPendingUploads(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name      string
+		volumes   []string
+		expected  map[string]int
+		expectErr bool
+	}{
+		{
+			name:     "all volumes",
+			expected: map[string]int{"busy": 2},
+		},
+		{
+			name:     "volume without staged writes",
+			volumes:  []string{"idle", "exited"},
+			expected: map[string]int{},
+		},
+		{
+			name:      "unknown volume",
+			volumes:   []string{"idle", "unknown"},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pending, err := newFakeMounter(t).pendingUploads(tc.volumes)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectErr)
+			}
+			if diff := cmp.Diff(tc.expected, pending); !tc.expectErr && diff != "" {
+				t.Errorf("unexpected pending uploads (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestStreamingVolumes(t *testing.T) {
+	t.Parallel()
+
+	m := newFakeMounter(t)
+	if diff := cmp.Diff([]string{"streaming"}, m.streamingVolumes(nil)); diff != "" {
+		t.Errorf("unexpected streaming volumes of all the volumes (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{}, m.streamingVolumes([]string{"idle", "busy"})); diff != "" {
+		t.Errorf("unexpected streaming volumes (-want +got):\n%s", diff)
+	}
+}
+
+func TestHandleUploadBarrier(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		query        string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "uploaded",
+			query:        "?volume=idle",
+			expectedCode: http.StatusOK,
+			expectedBody: "the staged writes are uploaded\n",
+		},
+		{
+			name:         "streaming writes are not tracked",
+			query:        "?volume=idle&volume=streaming",
+			expectedCode: http.StatusOK,
+			expectedBody: "the staged writes are uploaded, the streaming writes of volumes [streaming] are not tracked\n",
+		},
+		{
+			name:         "timeout",
+			query:        "?volume=busy&timeout=10ms",
+			expectedCode: http.StatusGatewayTimeout,
+		},
+		{
+			name:         "unknown volume",
+			query:        "?volume=unknown",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "invalid timeout",
+			query:        "?timeout=soon",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			newFakeMounter(t).handleUploadBarrier(w, httptest.NewRequest(http.MethodGet, UploadBarrierPath+tc.query, nil))
+			if w.Code != tc.expectedCode {
+				t.Errorf("got status %v, expected %v: %s", w.Code, tc.expectedCode, w.Body.String())
+			}
+			if tc.expectedBody != "" && w.Body.String() != tc.expectedBody {
+				t.Errorf("got body %q, expected %q", w.Body.String(), tc.expectedBody)
+			}
+		})
+	}
+}
+
+func TestWaitForUploads(t *testing.T) {
+	t.Parallel()
+
+	m := newFakeMounter(t)
+	// The busy volume uploads its staged writes while waiting.
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		for _, fd := range []string{"1", "2"} {
+			_ = os.Remove(filepath.Join(m.procDir, "200", "fd", fd))
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := m.WaitForUploads(ctx, nil); err != nil {
+		t.Errorf("expected the staged writes to be uploaded, got error %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/klog/v2"
+)
+
+const (
+	LoggingFormatText = "text"
+	LoggingFormatJSON = "json"
+
+	// Structured log keys shared by all the binaries, so that Cloud Logging queries work across components.
+	LogKeyVolume     = "volume"
+	LogKeyBucket     = "bucket"
+	LogKeyPod        = "pod"
+	LogKeyTargetPath = "targetPath"
+)
+
+// InitLogging configures the klog output format. It must be called after the flags are parsed.
+// The text format keeps the default klog output.
+func InitLogging(format string) error {
+	switch format {
+	case LoggingFormatText:
+		return nil
+	case LoggingFormatJSON:
+		klog.SetLogger(logr.New(newJSONLogSink(os.Stderr)))
+
+		return nil
+	default:
+		return fmt.Errorf("unsupported logging format %q, must be %q or %q", format, LoggingFormatText, LoggingFormatJSON)
+	}
+}
+
+// jsonLogSink writes one JSON object per log entry, using the field names recognized by Cloud Logging.
+// klog applies the verbosity filter before calling the sink, so every entry is enabled.
+type jsonLogSink struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	name   string
+	values []interface{}
+}
+
+func newJSONLogSink(w io.Writer) *jsonLogSink {
+	return &jsonLogSink{mu: &sync.Mutex{}, w: w}
+}
+
+func (s *jsonLogSink) Init(logr.RuntimeInfo) {}
+
+func (s *jsonLogSink) Enabled(int) bool {
+	return true
+}
+
+func (s *jsonLogSink) Info(_ int, msg string, keysAndValues ...interface{}) {
+	s.write("INFO", msg, nil, keysAndValues)
+}
+
+func (s *jsonLogSink) Error(err error, msg string, keysAndValues ...interface{}) {
+	s.write("ERROR", msg, err, keysAndValues)
+}
+
+func (s *jsonLogSink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	c := *s
+	c.values = append(append([]interface{}{}, s.values...), keysAndValues...)
+
+	return &c
+}
+
+func (s *jsonLogSink) WithName(name string) logr.LogSink {
+	c := *s
+	if c.name != "" {
+		name = c.name + "/" + name
+	}
+	c.name = name
+
+	return &c
+}
+
+func (s *jsonLogSink) write(severity, msg string, err error, keysAndValues []interface{}) {
+	entry := map[string]interface{}{}
+	addKeysAndValues(entry, s.values)
+	addKeysAndValues(entry, keysAndValues)
+
+	entry["severity"] = severity
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["message"] = strings.TrimSuffix(msg, "\n")
+	if s.name != "" {
+		entry["logger"] = s.name
+	}
+	if err != nil {
+		entry["error"] = err.Error()
+	}
+
+	b, marshalErr := json.Marshal(entry)
+	if marshalErr != nil {
+		b, _ = json.Marshal(map[string]string{"severity": "ERROR", "message": fmt.Sprintf("failed to marshal log entry %q: %v", msg, marshalErr)})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, _ = s.w.Write(append(b, '\n'))
+}
+
+func addKeysAndValues(entry map[string]interface{}, keysAndValues []interface{}) {
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key := fmt.Sprint(keysAndValues[i])
+		var value interface{} = "(MISSING)"
+		if i+1 < len(keysAndValues) {
+			value = keysAndValues[i+1]
+		}
+
+		if e, ok := value.(error); ok {
+			value = e.Error()
+		}
+		if _, err := json.Marshal(value); err != nil {
+			value = fmt.Sprintf("%+v", value)
+		}
+
+		entry[key] = value
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestJSONLogSink(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	logger := logr.New(newJSONLogSink(buf)).WithName("node").WithValues(LogKeyPod, "default/test-pod")
+	logger.Info("volume mounted\n", LogKeyVolume, "test-volume", LogKeyBucket, "test-bucket")
+	logger.Error(errors.New("permission denied"), "failed to mount", LogKeyVolume, "test-volume", "cause", errors.New("403"))
+
+	dec := json.NewDecoder(buf)
+	expected := []map[string]string{
+		{"severity": "INFO", "message": "volume mounted", "logger": "node", LogKeyPod: "default/test-pod", LogKeyVolume: "test-volume", LogKeyBucket: "test-bucket"},
+		{"severity": "ERROR", "message": "failed to mount", "logger": "node", LogKeyPod: "default/test-pod", LogKeyVolume: "test-volume", "error": "permission denied", "cause": "403"},
+	}
+	for _, e := range expected {
+		entry := map[string]interface{}{}
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatalf("failed to decode the log entry: %v", err)
+		}
+		if _, ok := entry["time"]; !ok {
+			t.Errorf("got log entry %v without time", entry)
+		}
+		for k, v := range e {
+			if entry[k] != v {
+				t.Errorf("got %q=%v in log entry %v, expected %q", k, entry[k], entry, v)
+			}
+		}
+	}
+}
+
+func TestInitLogging(t *testing.T) {
+	t.Parallel()
+
+	if err := InitLogging(LoggingFormatText); err != nil {
+		t.Errorf("got error %v for the text logging format", err)
+	}
+	if err := InitLogging("xml"); err == nil {
+		t.Error("expected error for an unsupported logging format")
+	}
+}
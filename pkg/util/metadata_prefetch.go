@@ -0,0 +1,483 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/webhook"
+	"golang.org/x/time/rate"
+	"k8s.io/klog/v2"
+)
+
+// minPrefetchOpsPerSecond is the rate that the prefetch throttle never goes below, so that the prefetch still completes
+// while the workload keeps gcsfuse busy.
+const minPrefetchOpsPerSecond = 1
+
+// maxLoggedSkippedDirs is how many of the directories skipped at the maximum entries the walk logs.
+const maxLoggedSkippedDirs = 10
+
+// IOTokenPath is the path of the sidecar mounter API that blocks until the token bucket shared by the metadata prefetch
+// and the workload grants the next directory listing to the prefetch.
+const IOTokenPath = "/v1/io/token"
+
+// PrefetchThrottle paces the directory listings of the metadata prefetch with a token bucket, so that the cache warmup
+// leaves the capacity of gcsfuse and the Cloud Storage API to the workload. The throttle is shared by the workers of all the volumes.
+// With the io token port of the sidecar mounter, each listing also takes a token from the bucket that the sidecar mounter shares
+// between the prefetch and the file system operations that gcsfuse serves to the workload, so that the prefetch only uses the
+// capacity that the workload leaves. Otherwise, gcsfuse serves the workload reads in another container and cannot tell them
+// apart from the prefetch, so the throttle uses the latency of its own listings as the signal that the workload is busy:
+// a listing slower than the latency threshold halves the rate, and each fast listing grows it back by one listing per second,
+// up to the maximum rate.
+type PrefetchThrottle struct {
+	// mu serializes the rate updates of the listings that finish concurrently.
+	mu               sync.Mutex
+	limiter          *rate.Limiter
+	maxRate          rate.Limit
+	latencyThreshold time.Duration
+	// tokenURL is the io token API of the sidecar mounter, or empty if the bucket is not shared with the workload.
+	tokenURL string
+}
+
+// NewPrefetchThrottle returns a throttle that allows at most maxOpsPerSecond listings per second,
+// or nil if maxOpsPerSecond is not positive, which does not throttle the prefetch.
+// A non-zero ioTokenPort shares the token bucket of the sidecar mounter at the loopback port with the workload,
+// and a non-positive latencyThreshold keeps the rate at the maximum otherwise.
+func NewPrefetchThrottle(maxOpsPerSecond int, latencyThreshold time.Duration, ioTokenPort int) *PrefetchThrottle {
+	if maxOpsPerSecond <= 0 {
+		return nil
+	}
+
+	// A burst of one spreads the listings evenly, instead of sending them all at once after an idle period.
+	t := &PrefetchThrottle{
+		limiter:          rate.NewLimiter(rate.Limit(maxOpsPerSecond), 1),
+		maxRate:          rate.Limit(maxOpsPerSecond),
+		latencyThreshold: latencyThreshold,
+	}
+	if ioTokenPort != 0 {
+		t.tokenURL = "http://" + net.JoinHostPort("127.0.0.1", strconv.Itoa(ioTokenPort)) + IOTokenPath
+	}
+
+	return t
+}
+
+// Wait blocks until the next listing is allowed. If the token bucket of the sidecar mounter cannot be reached, for example
+// while the sidecar container restarts, the listing is only paced by the maximum rate.
+func (t *PrefetchThrottle) Wait(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+
+	if err := t.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	if t.tokenURL == "" {
+		return nil
+	}
+
+	if err := acquireIOToken(ctx, t.tokenURL); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		klog.V(4).Infof("failed to take a token from the sidecar mounter, the listing is only paced by the maximum rate: %v", err)
+	}
+
+	return nil
+}
+
+// acquireIOToken blocks until the io token API of the sidecar mounter grants a token.
+func acquireIOToken(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request to %q: %w", url, err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call the io token API %q: %w", url, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("the io token API %q returned status %v", url, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Observe adapts the rate to the latency of the last listing.
+// The latency is not needed with the shared token bucket, which is charged with the actual operations of the workload.
+func (t *PrefetchThrottle) Observe(latency time.Duration) {
+	if t == nil || t.latencyThreshold <= 0 || t.tokenURL != "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	limit := t.limiter.Limit()
+	if latency > t.latencyThreshold {
+		limit = max(limit/2, minPrefetchOpsPerSecond)
+	} else {
+		limit = min(limit+1, t.maxRate)
+	}
+	t.limiter.SetLimit(limit)
+}
+
+// Limit returns the current rate of the throttle in listings per second.
+func (t *PrefetchThrottle) Limit() rate.Limit {
+	if t == nil {
+		return rate.Inf
+	}
+
+	return t.limiter.Limit()
+}
+
+// PrefetchFilter restricts the metadata prefetch to the directories that the workload reads. The patterns are matched against the
+// path of a directory relative to its root, with the syntax of path.Match, one pattern element per path element.
+type PrefetchFilter struct {
+	include []string
+	exclude []string
+	// maxDepth is how many directory levels below the root are cached. Zero means no limit.
+	maxDepth int
+}
+
+// NewPrefetchFilter returns a filter that only lists the directories that match an include pattern, with their subdirectories and the
+// directories on the way to them, and that skips the directories that match an exclude pattern, with their subdirectories.
+// With a positive maxDepth, only the directories less than maxDepth levels below the root are listed, so that the entries up to
+// maxDepth levels deep are cached, like find -maxdepth. It returns nil if there are no patterns and no depth limit, which lists all the directories.
+func NewPrefetchFilter(include, exclude []string, maxDepth int) (*PrefetchFilter, error) {
+	if maxDepth < 0 {
+		return nil, fmt.Errorf("prefetch max depth must not be negative, got %d", maxDepth)
+	}
+	if len(include) == 0 && len(exclude) == 0 && maxDepth == 0 {
+		return nil, nil
+	}
+
+	for _, pattern := range slices.Concat(include, exclude) {
+		if err := webhook.ValidatePrefetchPattern(pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	return &PrefetchFilter{include: include, exclude: exclude, maxDepth: maxDepth}, nil
+}
+
+// visit returns whether the directory at the relative path is listed, and whether the directory and its subdirectories
+// are included, given whether its parent is included.
+func (f *PrefetchFilter) visit(rel string, parentIncluded bool) (bool, bool) {
+	if f == nil || rel == "" {
+		return true, f == nil || len(f.include) == 0
+	}
+
+	elements := strings.Split(rel, "/")
+	if f.maxDepth > 0 && len(elements) >= f.maxDepth {
+		return false, false
+	}
+	for _, pattern := range f.exclude {
+		if matched, _ := path.Match(pattern, rel); matched {
+			return false, false
+		}
+	}
+	if parentIncluded {
+		return true, true
+	}
+
+	for _, pattern := range f.include {
+		patternElements := strings.Split(strings.Trim(pattern, "/"), "/")
+		if len(elements) > len(patternElements) {
+			continue
+		}
+		// A directory on the way to the directories of the pattern is listed, but its other subdirectories are not.
+		if matched, _ := path.Match(path.Join(patternElements[:len(elements)]...), rel); matched {
+			if len(elements) == len(patternElements) {
+				return true, true
+			}
+
+			return true, false
+		}
+	}
+
+	return false, false
+}
+
+// PrefetchOptions configure the walk of PrefetchMetadata.
+type PrefetchOptions struct {
+	// Throttle paces the listings of all the workers, or is nil to not throttle them.
+	Throttle *PrefetchThrottle
+	// Filters hold the filter of each root, or are nil to list all the directories.
+	Filters []*PrefetchFilter
+	// Metrics report the progress of each root, or are nil.
+	Metrics *PrefetchMetrics
+	// Workers is the number of directories that are listed concurrently, at least one.
+	Workers int
+	// StatFiles makes the walk stat the files of each listed directory, so that gcsfuse also fills its stat cache for them,
+	// which the listings alone do not always do. Like each listing, each stat waits on the shared throttle, and the stats
+	// of a directory run in its worker.
+	StatFiles bool
+	// MaxEntries caps the entries that the listings of all the roots return, so that the walk does not fill the gcsfuse metadata
+	// caches beyond the memory of the sidecar container. Once the listings returned MaxEntries entries, the directories that
+	// are not listed yet are skipped. Zero means no cap.
+	MaxEntries int
+}
+
+// PrefetchMetadata lists all the directories under the roots with a pool of workers, so that gcsfuse fills its metadata caches
+// before the workload needs them. The workers share the directories of all the roots, so that the roots and their top-level
+// directories are listed concurrently, and the listings of all the workers are paced by the throttle of the options.
+// Directories that cannot be listed and files that cannot be stat'ed are logged and skipped, and the statistics of the walk
+// of each root are returned.
+func PrefetchMetadata(ctx context.Context, roots []string, opts PrefetchOptions) ([]PrefetchStats, error) {
+	w := &prefetchWalk{
+		stats:     make([]PrefetchStats, len(roots)),
+		remaining: make([]int, len(roots)),
+		pending:   len(roots),
+		opts:      opts,
+		start:     time.Now(),
+	}
+	w.cond = sync.NewCond(&w.mu)
+	for i, root := range roots {
+		_, rootIncluded := w.filter(i).visit("", false)
+		w.dirs = append(w.dirs, prefetchDir{path: root, root: i, included: rootIncluded})
+		w.remaining[i] = 1
+		opts.Metrics.setRemaining(i, 1)
+	}
+
+	var wg sync.WaitGroup
+	for range max(opts.Workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.work(ctx)
+		}()
+	}
+	wg.Wait()
+
+	if skipped := sumSkipped(w.stats); skipped > 0 {
+		klog.Warningf("Metadata prefetch reached the maximum of %d entries, skipped %d directories and their subdirectories, including %v", opts.MaxEntries, skipped, w.skipped)
+	}
+
+	return w.stats, w.err
+}
+
+// sumSkipped returns the number of directories skipped under all the roots.
+func sumSkipped(stats []PrefetchStats) int {
+	skipped := 0
+	for _, s := range stats {
+		skipped += s.Skipped
+	}
+
+	return skipped
+}
+
+// PrefetchStats counts the work of the metadata prefetch under a root.
+type PrefetchStats struct {
+	// Directories is the number of listed directories.
+	Directories int
+	// Entries is the number of files and directories that the listings returned.
+	Entries int
+	// Files is the number of files that were stat'ed, with PrefetchOptions.StatFiles.
+	Files int
+	// Errors is the number of directories that could not be listed, and of files that could not be stat'ed.
+	Errors int
+	// Skipped is the number of directories that were not listed because the walk reached PrefetchOptions.MaxEntries.
+	// Their subdirectories are not counted.
+	Skipped int
+}
+
+// prefetchDir is a directory to list, and the index of the root it is under.
+type prefetchDir struct {
+	path string
+	// rel is the path of the directory relative to the root.
+	rel  string
+	root int
+	// included is whether the directory matches an include pattern of the filter, or is under a directory that does.
+	included bool
+}
+
+// prefetchWalk is the state that the workers of PrefetchMetadata share.
+type prefetchWalk struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	// dirs are the directories waiting for a worker.
+	dirs []prefetchDir
+	// pending is the number of directories waiting for a worker or being listed. The walk is done when it drops to zero.
+	pending int
+	stats   []PrefetchStats
+	err     error
+	// remaining is the number of directories of each root waiting for a worker or being listed.
+	remaining []int
+	opts      PrefetchOptions
+	start     time.Time
+	// entries is the number of entries that the listings of all the roots returned.
+	entries int
+	// skipped are the directories skipped once the walk reached the maximum entries, of which the first maxLoggedSkippedDirs are logged.
+	skipped []string
+}
+
+// filter returns the filter of the root.
+func (w *prefetchWalk) filter(root int) *PrefetchFilter {
+	if root >= len(w.opts.Filters) {
+		return nil
+	}
+
+	return w.opts.Filters[root]
+}
+
+// work lists the directories of the walk until there are none left, or the walk fails.
+func (w *prefetchWalk) work(ctx context.Context) {
+	for {
+		w.mu.Lock()
+		for len(w.dirs) == 0 && w.pending > 0 && w.err == nil {
+			w.cond.Wait()
+		}
+		if w.pending == 0 || w.err != nil {
+			w.mu.Unlock()
+
+			return
+		}
+		// Take the last directory, so that the walk goes depth first and the queue stays short.
+		dir := w.dirs[len(w.dirs)-1]
+		w.dirs = w.dirs[:len(w.dirs)-1]
+		w.mu.Unlock()
+
+		subdirs, listing, err := listPrefetchDir(ctx, dir, w.opts.Throttle, w.filter(dir.root), w.opts.StatFiles)
+
+		w.mu.Lock()
+		switch {
+		case err != nil:
+			if w.err == nil {
+				w.err = err
+			}
+		case subdirs != nil:
+			w.stats[dir.root].Directories++
+			w.stats[dir.root].Entries += listing.Entries
+			w.stats[dir.root].Files += listing.Files
+			w.stats[dir.root].Errors += listing.Errors
+			w.opts.Metrics.addEntries(dir.root, listing.Entries)
+			w.opts.Metrics.addErrors(dir.root, listing.Errors)
+			w.dirs = append(w.dirs, subdirs...)
+			w.pending += len(subdirs)
+			w.remaining[dir.root] += len(subdirs)
+			w.entries += listing.Entries
+			if w.opts.MaxEntries > 0 && w.entries >= w.opts.MaxEntries {
+				w.skipDirs()
+			}
+		default:
+			w.stats[dir.root].Errors++
+			w.opts.Metrics.addErrors(dir.root, 1)
+		}
+		w.pending--
+		// The metrics of a canceled walk are left as they are, and reset by the next walk.
+		if err == nil {
+			w.remaining[dir.root]--
+			w.opts.Metrics.setRemaining(dir.root, w.remaining[dir.root])
+			if w.remaining[dir.root] == 0 {
+				w.opts.Metrics.walkCompleted(dir.root, w.start, time.Now())
+			}
+		}
+		w.cond.Broadcast()
+		w.mu.Unlock()
+	}
+}
+
+// skipDirs skips the directories waiting for a worker, once the walk reached the maximum entries. The caller must hold mu.
+func (w *prefetchWalk) skipDirs() {
+	for _, dir := range w.dirs {
+		w.stats[dir.root].Skipped++
+		w.remaining[dir.root]--
+		w.opts.Metrics.setRemaining(dir.root, w.remaining[dir.root])
+		if w.remaining[dir.root] == 0 {
+			w.opts.Metrics.walkCompleted(dir.root, w.start, time.Now())
+		}
+		if len(w.skipped) < maxLoggedSkippedDirs {
+			w.skipped = append(w.skipped, dir.path)
+		}
+	}
+	w.pending -= len(w.dirs)
+	w.dirs = nil
+}
+
+// listPrefetchDir lists the directory, and stats its files if statFiles is set. The listing and each stat wait for the throttle. It returns the subdirectories to list and the
+// statistics of the directory, or nil if the directory cannot be listed. An error is only returned if the prefetch is canceled.
+func listPrefetchDir(ctx context.Context, dir prefetchDir, throttle *PrefetchThrottle, filter *PrefetchFilter, statFiles bool) ([]prefetchDir, PrefetchStats, error) {
+	listing := PrefetchStats{}
+	if err := ctx.Err(); err != nil {
+		return nil, listing, err
+	}
+	if err := throttle.Wait(ctx); err != nil {
+		return nil, listing, err
+	}
+
+	start := time.Now()
+	entries, err := os.ReadDir(dir.path)
+	throttle.Observe(time.Since(start))
+	if err != nil {
+		klog.Warningf("failed to list directory %q: %v", dir.path, err)
+
+		return nil, listing, nil
+	}
+	listing.Entries = len(entries)
+
+	subdirs := []prefetchDir{}
+	for _, entry := range entries {
+		// Symlinks are not followed, like ls -R does not follow them.
+		if !entry.IsDir() {
+			if statFiles {
+				if err := ctx.Err(); err != nil {
+					return nil, listing, err
+				}
+				// Each stat is a request to gcsfuse, and to Cloud Storage on a stat cache miss, so it is paced like a listing.
+				if err := throttle.Wait(ctx); err != nil {
+					return nil, listing, err
+				}
+				// Lstat asks gcsfuse for the attributes of the file, so that a later open or stat of the workload hits the stat cache.
+				start := time.Now()
+				_, err := os.Lstat(filepath.Join(dir.path, entry.Name()))
+				throttle.Observe(time.Since(start))
+				if err != nil {
+					klog.Warningf("failed to stat file %q: %v", filepath.Join(dir.path, entry.Name()), err)
+					listing.Errors++
+				} else {
+					listing.Files++
+				}
+			}
+
+			continue
+		}
+
+		rel := path.Join(dir.rel, entry.Name())
+		if list, included := filter.visit(rel, dir.included); list {
+			subdirs = append(subdirs, prefetchDir{path: filepath.Join(dir.path, entry.Name()), rel: rel, root: dir.root, included: included})
+		}
+	}
+
+	return subdirs, listing, nil
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrefetchMetrics reports the progress of the metadata prefetch of each volume, so that users can tell when the cache warmup
+// is complete, and alert when the listings fail. The metrics are labeled by the volume name, in the order of the prefetch roots.
+type PrefetchMetrics struct {
+	volumes        []string
+	entries        *prometheus.CounterVec
+	errors         *prometheus.CounterVec
+	remaining      *prometheus.GaugeVec
+	walkDuration   *prometheus.GaugeVec
+	lastCompletion *prometheus.GaugeVec
+}
+
+// NewPrefetchMetrics returns the metrics of the prefetch of the volumes, and registers them.
+func NewPrefetchMetrics(registerer prometheus.Registerer, volumes []string) (*PrefetchMetrics, error) {
+	m := &PrefetchMetrics{
+		volumes: volumes,
+		entries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gke_gcsfuse_metadata_prefetch_entries_total",
+			Help: "The number of files and directories returned by the directory listings of the metadata prefetch, labeled by the volume name.",
+		}, []string{"volume_name"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gke_gcsfuse_metadata_prefetch_errors_total",
+			Help: "The number of directory listings and file stats of the metadata prefetch that failed, labeled by the volume name.",
+		}, []string{"volume_name"}),
+		remaining: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "gke_gcsfuse_metadata_prefetch_directories_remaining",
+			Help: "The number of directories that the current walk of the metadata prefetch still has to list, labeled by the volume name. It is 0 when no walk is running.",
+		}, []string{"volume_name"}),
+		walkDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "gke_gcsfuse_metadata_prefetch_walk_duration_seconds",
+			Help: "The duration of the last complete walk of the metadata prefetch, labeled by the volume name.",
+		}, []string{"volume_name"}),
+		lastCompletion: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "gke_gcsfuse_metadata_prefetch_last_completion_timestamp_seconds",
+			Help: "The time the last walk of the metadata prefetch completed, in seconds since the epoch, labeled by the volume name. It is 0 until the first walk completes.",
+		}, []string{"volume_name"}),
+	}
+
+	for _, c := range []prometheus.Collector{m.entries, m.errors, m.remaining, m.walkDuration, m.lastCompletion} {
+		if err := registerer.Register(c); err != nil {
+			return nil, fmt.Errorf("failed to register the metadata prefetch metrics: %w", err)
+		}
+	}
+	// Export all the series from the start, so that alerts on the errors and the completion see the volumes before their first walk.
+	for _, volume := range volumes {
+		m.entries.WithLabelValues(volume)
+		m.errors.WithLabelValues(volume)
+		m.remaining.WithLabelValues(volume)
+		m.walkDuration.WithLabelValues(volume)
+		m.lastCompletion.WithLabelValues(volume)
+	}
+
+	return m, nil
+}
+
+// ForVolumes returns the metrics of a walk of the given volumes, such as the volumes mounted after the first walk,
+// which share the series of the volumes with the same names.
+func (m *PrefetchMetrics) ForVolumes(volumes []string) *PrefetchMetrics {
+	if m == nil {
+		return nil
+	}
+	walkMetrics := *m
+	walkMetrics.volumes = volumes
+
+	return &walkMetrics
+}
+
+// volume returns the volume name of the root.
+func (m *PrefetchMetrics) volume(root int) string {
+	if root >= len(m.volumes) {
+		return fmt.Sprintf("root-%d", root)
+	}
+
+	return m.volumes[root]
+}
+
+func (m *PrefetchMetrics) addEntries(root, entries int) {
+	if m == nil {
+		return
+	}
+	m.entries.WithLabelValues(m.volume(root)).Add(float64(entries))
+}
+
+func (m *PrefetchMetrics) addErrors(root, errors int) {
+	if m == nil {
+		return
+	}
+	m.errors.WithLabelValues(m.volume(root)).Add(float64(errors))
+}
+
+func (m *PrefetchMetrics) setRemaining(root, remaining int) {
+	if m == nil {
+		return
+	}
+	m.remaining.WithLabelValues(m.volume(root)).Set(float64(remaining))
+}
+
+// walkCompleted records a complete walk of the root that started at the start time.
+func (m *PrefetchMetrics) walkCompleted(root int, start, now time.Time) {
+	if m == nil {
+		return
+	}
+	m.walkDuration.WithLabelValues(m.volume(root)).Set(now.Sub(start).Seconds())
+	m.lastCompletion.WithLabelValues(m.volume(root)).Set(float64(now.Unix()))
+}
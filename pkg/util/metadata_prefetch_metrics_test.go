@@ -0,0 +1,125 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestPrefetchMetrics(t *testing.T) {
+	t.Parallel()
+	root := t.TempDir()
+	for _, dir := range []string{"a/b/c", "a/d", "e"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+	}
+	writeTestFile(t, filepath.Join(root, "a", "file"), "data")
+
+	registry := prometheus.NewRegistry()
+	metrics, err := NewPrefetchMetrics(registry, []string{"data", "missing"})
+	if err != nil {
+		t.Fatalf("got error %v, expected nil", err)
+	}
+	if _, err := NewPrefetchMetrics(registry, []string{"data"}); err == nil {
+		t.Error("got error nil, expected an error for metrics registered twice")
+	}
+	if got := metricValue(t, metrics.lastCompletion.WithLabelValues("data")); got != 0 {
+		t.Errorf("got last completion %v before the walk, expected 0", got)
+	}
+
+	if _, err := PrefetchMetadata(context.Background(), []string{root, filepath.Join(root, "missing")}, PrefetchOptions{Metrics: metrics, Workers: 4}); err != nil {
+		t.Fatalf("got error %v, expected nil", err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		metric   prometheus.Metric
+		expected float64
+	}{
+		// a and e, then b, d and file, then c.
+		{"data entries", metrics.entries.WithLabelValues("data"), 6},
+		{"missing entries", metrics.entries.WithLabelValues("missing"), 0},
+		{"data errors", metrics.errors.WithLabelValues("data"), 0},
+		{"missing errors", metrics.errors.WithLabelValues("missing"), 1},
+		{"data remaining", metrics.remaining.WithLabelValues("data"), 0},
+		{"missing remaining", metrics.remaining.WithLabelValues("missing"), 0},
+	} {
+		if got := metricValue(t, tc.metric); got != tc.expected {
+			t.Errorf("got %s %v, expected %v", tc.name, got, tc.expected)
+		}
+	}
+	for _, volume := range []string{"data", "missing"} {
+		if got := metricValue(t, metrics.lastCompletion.WithLabelValues(volume)); got <= 0 {
+			t.Errorf("got last completion %v for volume %q, expected a timestamp", got, volume)
+		}
+	}
+}
+
+func TestPrefetchMetricsForVolumes(t *testing.T) {
+	t.Parallel()
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "a"), 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	metrics, err := NewPrefetchMetrics(prometheus.NewRegistry(), []string{"data", "other"})
+	if err != nil {
+		t.Fatalf("got error %v, expected nil", err)
+	}
+	if (*PrefetchMetrics)(nil).ForVolumes([]string{"data"}) != nil {
+		t.Error("got metrics for volumes of nil metrics, expected nil")
+	}
+
+	// The second volume walks alone, and a late volume gets its own series.
+	if _, err := PrefetchMetadata(context.Background(), []string{root, root}, PrefetchOptions{Metrics: metrics.ForVolumes([]string{"other", "late"}), Workers: 2}); err != nil {
+		t.Fatalf("got error %v, expected nil", err)
+	}
+	for _, tc := range []struct {
+		volume   string
+		expected float64
+	}{
+		{"data", 0},
+		{"other", 1},
+		{"late", 1},
+	} {
+		if got := metricValue(t, metrics.entries.WithLabelValues(tc.volume)); got != tc.expected {
+			t.Errorf("got entries %v for volume %q, expected %v", got, tc.volume, tc.expected)
+		}
+	}
+}
+
+func metricValue(t *testing.T, metric prometheus.Metric) float64 {
+	t.Helper()
+
+	m := &dto.Metric{}
+	if err := metric.Write(m); err != nil {
+		t.Fatalf("failed to write metric: %v", err)
+	}
+	if m.GetCounter() != nil {
+		return m.GetCounter().GetValue()
+	}
+
+	return m.GetGauge().GetValue()
+}
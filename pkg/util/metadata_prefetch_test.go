@@ -0,0 +1,336 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/time/rate"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+}
+
+func TestPrefetchThrottleObserve(t *testing.T) {
+	t.Parallel()
+	throttle := NewPrefetchThrottle(8, 100*time.Millisecond, 0)
+
+	steps := []struct {
+		latency       time.Duration
+		expectedLimit rate.Limit
+	}{
+		{latency: 10 * time.Millisecond, expectedLimit: 8},
+		{latency: time.Second, expectedLimit: 4},
+		{latency: time.Second, expectedLimit: 2},
+		{latency: time.Second, expectedLimit: 1},
+		{latency: time.Second, expectedLimit: 1},
+		{latency: 10 * time.Millisecond, expectedLimit: 2},
+		{latency: 10 * time.Millisecond, expectedLimit: 3},
+	}
+	for i, step := range steps {
+		throttle.Observe(step.latency)
+		if limit := throttle.Limit(); limit != step.expectedLimit {
+			t.Errorf("step %d: got limit %v, expected %v", i, limit, step.expectedLimit)
+		}
+	}
+}
+
+func TestPrefetchThrottleDisabled(t *testing.T) {
+	t.Parallel()
+	throttle := NewPrefetchThrottle(0, 100*time.Millisecond, 0)
+	if throttle != nil {
+		t.Fatalf("got throttle %+v, expected nil", throttle)
+	}
+
+	throttle.Observe(time.Second)
+	if err := throttle.Wait(context.Background()); err != nil {
+		t.Errorf("got error %v, expected nil", err)
+	}
+	if limit := throttle.Limit(); limit != rate.Inf {
+		t.Errorf("got limit %v, expected %v", limit, rate.Inf)
+	}
+}
+
+func TestPrefetchThrottleSharedTokens(t *testing.T) {
+	t.Parallel()
+
+	var tokens atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != IOTokenPath {
+			http.NotFound(w, r)
+
+			return
+		}
+		if tokens.Add(1) > 2 {
+			http.Error(w, "the sidecar mounter is terminating", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	port, err := strconv.Atoi(server.URL[strings.LastIndex(server.URL, ":")+1:])
+	if err != nil {
+		t.Fatalf("failed to parse the port of %q: %v", server.URL, err)
+	}
+
+	throttle := NewPrefetchThrottle(1000, 100*time.Millisecond, port)
+	// The listings that the API fails to grant are only paced by the maximum rate.
+	for i := range 3 {
+		if err := throttle.Wait(context.Background()); err != nil {
+			t.Errorf("listing %d: got error %v, expected nil", i, err)
+		}
+	}
+	if got := tokens.Load(); got != 3 {
+		t.Errorf("got %d token requests, expected 3", got)
+	}
+
+	// The shared token bucket is charged with the workload operations, so the latency does not change the rate.
+	throttle.Observe(time.Second)
+	if limit := throttle.Limit(); limit != 1000 {
+		t.Errorf("got limit %v, expected 1000", limit)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := throttle.Wait(ctx); err == nil {
+		t.Error("got error nil, expected the context to be canceled")
+	}
+}
+
+func TestPrefetchMetadata(t *testing.T) {
+	t.Parallel()
+	root := t.TempDir()
+	for _, dir := range []string{"a/b/c", "a/d", "e"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+	}
+	writeTestFile(t, filepath.Join(root, "a", "file"), "data")
+	if err := os.Symlink(filepath.Join(root, "a"), filepath.Join(root, "e", "link")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	for _, workers := range []int{0, 1, 4} {
+		stats, err := PrefetchMetadata(context.Background(), []string{root, filepath.Join(root, "a"), filepath.Join(root, "missing")}, PrefetchOptions{Throttle: NewPrefetchThrottle(1000, time.Second, 0), Workers: workers})
+		if err != nil {
+			t.Fatalf("%d workers: got error %v, expected nil", workers, err)
+		}
+		// The root, a, a/b, a/b/c, a/d and e with 7 entries, then a, a/b, a/b/c and a/d with 4 entries, and an error for the missing root.
+		expected := []PrefetchStats{{Directories: 6, Entries: 7}, {Directories: 4, Entries: 4}, {Errors: 1}}
+		if diff := cmp.Diff(expected, stats); diff != "" {
+			t.Errorf("%d workers: unexpected stats (-want +got):\n%s", workers, diff)
+		}
+	}
+
+	// The file a/file and the symlink e/link, which is not followed, then a/file.
+	stats, err := PrefetchMetadata(context.Background(), []string{root, filepath.Join(root, "a")}, PrefetchOptions{Workers: 4, StatFiles: true})
+	if err != nil {
+		t.Fatalf("stat files: got error %v, expected nil", err)
+	}
+	expected := []PrefetchStats{{Directories: 6, Entries: 7, Files: 2}, {Directories: 4, Entries: 4, Files: 1}}
+	if diff := cmp.Diff(expected, stats); diff != "" {
+		t.Errorf("stat files: unexpected stats (-want +got):\n%s", diff)
+	}
+
+	// Each listing and each stat takes a token of the throttle.
+	var tokens atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		tokens.Add(1)
+	}))
+	defer server.Close()
+	port, _ := strconv.Atoi(server.URL[strings.LastIndex(server.URL, ":")+1:])
+	if _, err := PrefetchMetadata(context.Background(), []string{root}, PrefetchOptions{Throttle: NewPrefetchThrottle(1000, 0, port), Workers: 4, StatFiles: true}); err != nil {
+		t.Fatalf("throttled stat files: got error %v, expected nil", err)
+	}
+	if got := tokens.Load(); got != 8 {
+		t.Errorf("got %d tokens, expected 8 for 6 listings and 2 stats", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := PrefetchMetadata(ctx, []string{root}, PrefetchOptions{Workers: 4}); err == nil {
+		t.Error("got error nil, expected an error for a canceled context")
+	}
+}
+
+func TestPrefetchMetadataWide(t *testing.T) {
+	t.Parallel()
+	root := t.TempDir()
+	for i := range 50 {
+		for j := range 5 {
+			if err := os.MkdirAll(filepath.Join(root, strconv.Itoa(i), strconv.Itoa(j)), 0o755); err != nil {
+				t.Fatalf("failed to create directory: %v", err)
+			}
+		}
+	}
+
+	stats, err := PrefetchMetadata(context.Background(), []string{root}, PrefetchOptions{Workers: 8})
+	if err != nil {
+		t.Fatalf("got error %v, expected nil", err)
+	}
+	if stats[0].Directories != 1+50+50*5 {
+		t.Errorf("got %d listed directories, expected %d", stats[0].Directories, 1+50+50*5)
+	}
+}
+
+func TestPrefetchMetadataMaxEntries(t *testing.T) {
+	t.Parallel()
+	root := t.TempDir()
+	for _, dir := range []string{"a/b", "c/d", "e"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+	}
+
+	// The root listing returns the 3 entries a, c and e, so a budget of 3 entries skips all of them. A single worker walks
+	// depth first from the last entry, so a budget of 4 entries lists e and c, and skips c/d and a.
+	testCases := []struct {
+		maxEntries int
+		expected   PrefetchStats
+	}{
+		{maxEntries: 0, expected: PrefetchStats{Directories: 6, Entries: 5}},
+		{maxEntries: 3, expected: PrefetchStats{Directories: 1, Entries: 3, Skipped: 3}},
+		{maxEntries: 4, expected: PrefetchStats{Directories: 3, Entries: 4, Skipped: 2}},
+		{maxEntries: 100, expected: PrefetchStats{Directories: 6, Entries: 5}},
+	}
+	for _, tc := range testCases {
+		stats, err := PrefetchMetadata(context.Background(), []string{root}, PrefetchOptions{Workers: 1, MaxEntries: tc.maxEntries})
+		if err != nil {
+			t.Fatalf("max entries %d: got error %v, expected nil", tc.maxEntries, err)
+		}
+		if diff := cmp.Diff([]PrefetchStats{tc.expected}, stats); diff != "" {
+			t.Errorf("max entries %d: unexpected stats (-want +got):\n%s", tc.maxEntries, diff)
+		}
+	}
+}
+
+func TestPrefetchMetadataFilter(t *testing.T) {
+	t.Parallel()
+	root := t.TempDir()
+	for _, dir := range []string{"data/train/shard-0", "data/train/shard-1", "data/eval", "data/train/tmp", "models/v1", "models/v2", "logs/2024"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+	}
+
+	testCases := []struct {
+		name           string
+		include        []string
+		exclude        []string
+		maxDepth       int
+		expectedListed int
+	}{
+		{
+			name:           "no patterns",
+			expectedListed: 12,
+		},
+		{
+			// The root, data, and data/train with its 3 subdirectories.
+			name:           "include a subtree",
+			include:        []string{"data/train"},
+			expectedListed: 6,
+		},
+		{
+			// The root, models with its 2 subdirectories, and data with its 5 subdirectories.
+			name:           "include with wildcards",
+			include:        []string{"models/*", "data"},
+			expectedListed: 10,
+		},
+		{
+			// All but logs, logs/2024 and data/train/tmp.
+			name:           "exclude subtrees",
+			exclude:        []string{"logs", "*/*/tmp"},
+			expectedListed: 9,
+		},
+		{
+			// The root, data, data/train, and its 2 shards.
+			name:           "include and exclude",
+			include:        []string{"data/train"},
+			exclude:        []string{"data/train/tmp"},
+			expectedListed: 5,
+		},
+		{
+			// The root only.
+			name:           "max depth 1",
+			maxDepth:       1,
+			expectedListed: 1,
+		},
+		{
+			// The root, and data, models and logs.
+			name:           "max depth 2",
+			maxDepth:       2,
+			expectedListed: 4,
+		},
+		{
+			// The root, and data.
+			name:           "max depth with include",
+			include:        []string{"data/train"},
+			maxDepth:       2,
+			expectedListed: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			filter, err := NewPrefetchFilter(tc.include, tc.exclude, tc.maxDepth)
+			if err != nil {
+				t.Fatalf("got error %v, expected nil", err)
+			}
+			stats, err := PrefetchMetadata(context.Background(), []string{root}, PrefetchOptions{Filters: []*PrefetchFilter{filter}, Workers: 4})
+			if err != nil {
+				t.Fatalf("got error %v, expected nil", err)
+			}
+			if stats[0].Directories != tc.expectedListed {
+				t.Errorf("got %d listed directories, expected %d", stats[0].Directories, tc.expectedListed)
+			}
+		})
+	}
+}
+
+func TestNewPrefetchFilter(t *testing.T) {
+	t.Parallel()
+
+	if filter, err := NewPrefetchFilter(nil, nil, 0); filter != nil || err != nil {
+		t.Errorf("got filter %v and error %v, expected nil", filter, err)
+	}
+	if _, err := NewPrefetchFilter(nil, nil, -1); err == nil {
+		t.Error("negative max depth: got error nil, expected an error")
+	}
+	for _, pattern := range []string{"/data", "data/[", ""} {
+		if _, err := NewPrefetchFilter([]string{pattern}, nil, 0); err == nil {
+			t.Errorf("pattern %q: got error nil, expected an error", pattern)
+		}
+	}
+}
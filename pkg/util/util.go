@@ -25,6 +25,7 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/volumespec"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/webhook"
 	"k8s.io/klog/v2"
 )
@@ -37,74 +38,37 @@ const (
 
 	// mount options that both CSI mounter and sidecar mounter should understand.
 	DisableMetricsForGKE = "disable-metrics-for-gke"
+	// WorkloadRecommendations makes the sidecar mounter analyze the I/O of the volume and recommend gcsfuse settings.
+	WorkloadRecommendations = "workload-recommendations"
+
+	// SidecarVersionFile is the file in the emptyDir path of a volume where the CSI driver records the version
+	// the sidecar mounter sent when it connected to the volume socket. The file is empty if no version was sent.
+	SidecarVersionFile = "sidecar-version"
+
+	// RecommendationsFile is the file in the emptyDir path of a volume where the sidecar mounter writes the summary of the
+	// I/O it observed, and the gcsfuse settings it recommends. The CSI driver reports the file content when the volume is unmounted.
+	RecommendationsFile = "recommendations"
+
+	// DrainStatusFile is the file in the emptyDir path of a volume where the sidecar mounter describes the staged writes
+	// that are not uploaded yet after it received SIGTERM. The file is removed once they are uploaded, so the CSI driver
+	// reports the writes that are lost when the file is left after the sidecar container was killed.
+	DrainStatusFile = "drain-status"
+
+	// FlushDurationFile is the file in the emptyDir path of a volume where the sidecar mounter writes how long gcsfuse took
+	// to exit after the sidecar mounter received SIGTERM, during which gcsfuse flushed the pending writes to GCS.
+	// The CSI driver reports the duration when the volume is unmounted.
+	FlushDurationFile = "flush-duration"
 )
 
 var (
-	targetPathRegexp       = regexp.MustCompile(`/var/lib/kubelet/pods/(.*)/volumes/kubernetes\.io~csi/(.*)/mount`)
-	emptyReplacementRegexp = regexp.MustCompile(`kubernetes\.io~csi/(.*)/mount`)
+	targetPathRegexp       = regexp.MustCompile(`/var/lib/kubelet/pods/([^/]+)/volumes/kubernetes\.io~csi/([^/]+)/mount$`)
+	emptyReplacementRegexp = regexp.MustCompile(`kubernetes\.io~csi/([^/]+)/mount$`)
 )
 
 // ConvertLabelsStringToMap converts the labels from string to map
 // example: "key1=value1,key2=value2" gets converted into {"key1": "value1", "key2": "value2"}
 func ConvertLabelsStringToMap(labels string) (map[string]string, error) {
-	const labelsDelimiter = ","
-	const labelsKeyValueDelimiter = "="
-
-	labelsMap := make(map[string]string)
-	if labels == "" {
-		return labelsMap, nil
-	}
-
-	// Following rules enforced for label keys
-	// 1. Keys have a minimum length of 1 character and a maximum length of 63 characters, and cannot be empty.
-	// 2. Keys and values can contain only lowercase letters, numeric characters, underscores, and dashes.
-	// 3. Keys must start with a lowercase letter.
-	regexKey := regexp.MustCompile(`^\p{Ll}[\p{Ll}0-9_-]{0,62}$`)
-	checkLabelKeyFn := func(key string) error {
-		if !regexKey.MatchString(key) {
-			return fmt.Errorf("label value %q is invalid (should start with lowercase letter / lowercase letter, digit, _ and - chars are allowed / 1-63 characters", key)
-		}
-
-		return nil
-	}
-
-	// Values can be empty, and have a maximum length of 63 characters.
-	regexValue := regexp.MustCompile(`^[\p{Ll}0-9_-]{0,63}$`)
-	checkLabelValueFn := func(value string) error {
-		if !regexValue.MatchString(value) {
-			return fmt.Errorf("label value %q is invalid (lowercase letter, digit, _ and - chars are allowed / 0-63 characters", value)
-		}
-
-		return nil
-	}
-
-	keyValueStrings := strings.Split(labels, labelsDelimiter)
-	for _, keyValue := range keyValueStrings {
-		keyValue := strings.Split(keyValue, labelsKeyValueDelimiter)
-
-		if len(keyValue) != 2 {
-			return nil, fmt.Errorf("labels %q are invalid, correct format: 'key1=value1,key2=value2'", labels)
-		}
-
-		key := strings.TrimSpace(keyValue[0])
-		if err := checkLabelKeyFn(key); err != nil {
-			return nil, err
-		}
-
-		value := strings.TrimSpace(keyValue[1])
-		if err := checkLabelValueFn(value); err != nil {
-			return nil, err
-		}
-
-		labelsMap[key] = value
-	}
-
-	const maxNumberOfLabels = 64
-	if len(labelsMap) > maxNumberOfLabels {
-		return nil, fmt.Errorf("more than %d labels is not allowed, given: %d", maxNumberOfLabels, len(labelsMap))
-	}
-
-	return labelsMap, nil
+	return volumespec.ParseLabels(labels)
 }
 
 func ParseEndpoint(endpoint string, cleanupSocket bool) (string, string, error) {
@@ -142,13 +106,51 @@ func ParsePodIDVolumeFromTargetpath(targetPath string) (string, string, error) {
 	return podID, volume, nil
 }
 
+// ValidateTargetPath returns an error if the target path is not the canonical path that kubelet creates to mount a CSI volume of a Pod,
+// or if the Pod directory contains a symbolic link on the way to the parent of the target path. The paths of the emptyDir volumes
+// of the sidecar container and of the volume sockets are derived from the target path, so a path that escapes the Pod directory
+// would make the CSI driver create and mount files outside of it. The target path itself is not checked, because it is the root
+// of the gcsfuse mount once the volume is published, which blocks or fails if the gcsfuse process hung or exited.
+func ValidateTargetPath(targetPath string) error {
+	if !filepath.IsAbs(targetPath) {
+		return fmt.Errorf("target path %q is not absolute", targetPath)
+	}
+	if filepath.Clean(targetPath) != targetPath {
+		return fmt.Errorf("target path %q is not canonical, expected %q", targetPath, filepath.Clean(targetPath))
+	}
+
+	matched := targetPathRegexp.FindStringSubmatchIndex(targetPath)
+	if matched == nil {
+		return fmt.Errorf("target path %q does not contain Pod ID or volume information", targetPath)
+	}
+
+	// The directories above the Pod directory belong to the node, and may be symbolic links if the kubelet root directory is moved.
+	podsDir := targetPath[:matched[2]-1]
+	dir := podsDir
+	for _, element := range strings.Split(filepath.Dir(targetPath)[matched[2]:], "/") {
+		dir = filepath.Join(dir, element)
+		info, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to check target path %q: %w", targetPath, err)
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("target path %q is invalid, %q is a symbolic link", targetPath, dir)
+		}
+	}
+
+	return nil
+}
+
 func PrepareEmptyDir(targetPath string, createEmptyDir bool) (string, error) {
 	_, _, err := ParsePodIDVolumeFromTargetpath(targetPath)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse volume name from target path %q: %w", targetPath, err)
 	}
 
-	emptyDirBasePath := emptyReplacementRegexp.ReplaceAllString(targetPath, fmt.Sprintf("kubernetes.io~empty-dir/%v/.volumes/$1", webhook.SidecarContainerTmpVolumeName))
+	emptyDirBasePath := GetSidecarEmptyDirPath(targetPath, webhook.SidecarContainerTmpVolumeName)
 
 	if createEmptyDir {
 		if err := os.MkdirAll(emptyDirBasePath, 0o750); err != nil {
@@ -159,6 +161,11 @@ func PrepareEmptyDir(targetPath string, createEmptyDir bool) (string, error) {
 	return emptyDirBasePath, nil
 }
 
+// GetSidecarEmptyDirPath returns the directory of the CSI volume of targetPath in the emptyDir volume of the sidecar container.
+func GetSidecarEmptyDirPath(targetPath, emptyDirVolumeName string) string {
+	return emptyReplacementRegexp.ReplaceAllString(targetPath, fmt.Sprintf("kubernetes.io~empty-dir/%v/.volumes/$1", emptyDirVolumeName))
+}
+
 func GetSocketBasePath(targetPath, fuseSocketDir string) string {
 	podID, volumeName, _ := ParsePodIDVolumeFromTargetpath(targetPath)
 
@@ -19,6 +19,8 @@ package util
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -282,6 +284,11 @@ func TestParsePodIDVolumeFromTargetpath(t *testing.T) {
 			expectedVolume: "",
 			expectedError:  true,
 		},
+		{
+			name:          "should return error for nested volume name",
+			targetPath:    "/var/lib/kubelet/pods/d2013878-3d56-45f9-89ec-0826612c89b6/volumes/kubernetes.io~csi/test-volume/mount/volumes/kubernetes.io~csi/other/mount/data",
+			expectedError: true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -307,6 +314,97 @@ func TestParsePodIDVolumeFromTargetpath(t *testing.T) {
 	}
 }
 
+func TestValidateTargetPath(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	podDir := filepath.Join(root, "node/var/lib/kubelet/pods/d2013878-3d56-45f9-89ec-0826612c89b6")
+	if err := os.MkdirAll(filepath.Join(podDir, "volumes/kubernetes.io~csi/test-volume/mount"), 0o750); err != nil {
+		t.Fatalf("failed to create the pod directory: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "elsewhere"), 0o750); err != nil {
+		t.Fatalf("failed to create the symbolic link target: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(root, "elsewhere"), filepath.Join(podDir, "volumes/kubernetes.io~csi/linked-volume")); err != nil {
+		t.Fatalf("failed to create the symbolic link: %v", err)
+	}
+	// The target path stands for the root of a gcsfuse mount, which is not checked.
+	if err := os.MkdirAll(filepath.Join(podDir, "volumes/kubernetes.io~csi/mounted-volume"), 0o750); err != nil {
+		t.Fatalf("failed to create the volume directory: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(root, "elsewhere"), filepath.Join(podDir, "volumes/kubernetes.io~csi/mounted-volume/mount")); err != nil {
+		t.Fatalf("failed to create the symbolic link: %v", err)
+	}
+	// The directories above the Pod directory may be symbolic links.
+	if err := os.Symlink(filepath.Join(root, "node"), filepath.Join(root, "linked-node")); err != nil {
+		t.Fatalf("failed to create the symbolic link: %v", err)
+	}
+
+	testCases := []struct {
+		name          string
+		targetPath    string
+		expectedError bool
+	}{
+		{
+			name:       "should accept an existing target path",
+			targetPath: filepath.Join(podDir, "volumes/kubernetes.io~csi/test-volume/mount"),
+		},
+		{
+			name:       "should accept a target path that does not exist yet",
+			targetPath: filepath.Join(podDir, "volumes/kubernetes.io~csi/new-volume/mount"),
+		},
+		{
+			name:       "should accept a symbolic link above the Pod directory",
+			targetPath: filepath.Join(root, "linked-node/var/lib/kubelet/pods/d2013878-3d56-45f9-89ec-0826612c89b6/volumes/kubernetes.io~csi/test-volume/mount"),
+		},
+		{
+			name:       "should not check the target path itself",
+			targetPath: filepath.Join(podDir, "volumes/kubernetes.io~csi/mounted-volume/mount"),
+		},
+		{
+			name:          "should reject a relative target path",
+			targetPath:    "var/lib/kubelet/pods/d2013878-3d56-45f9-89ec-0826612c89b6/volumes/kubernetes.io~csi/test-volume/mount",
+			expectedError: true,
+		},
+		{
+			name:          "should reject parent path elements",
+			targetPath:    podDir + "/volumes/kubernetes.io~csi/test-volume/../../../../other-pod/volumes/kubernetes.io~csi/test-volume/mount",
+			expectedError: true,
+		},
+		{
+			name:          "should reject a Pod ID of parent path elements",
+			targetPath:    "/var/lib/kubelet/pods/../volumes/kubernetes.io~csi/test-volume/mount",
+			expectedError: true,
+		},
+		{
+			name:          "should reject duplicate separators",
+			targetPath:    podDir + "//volumes/kubernetes.io~csi/test-volume/mount",
+			expectedError: true,
+		},
+		{
+			name:          "should reject a target path without Pod ID or volume information",
+			targetPath:    "/foo/bar/volumes",
+			expectedError: true,
+		},
+		{
+			name:          "should reject a symbolic link in the Pod directory",
+			targetPath:    filepath.Join(podDir, "volumes/kubernetes.io~csi/linked-volume/mount"),
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := ValidateTargetPath(tc.targetPath)
+			if (err != nil) != tc.expectedError {
+				t.Errorf("got error %v, but expected error %v", err, tc.expectedError)
+			}
+		})
+	}
+}
+
 func TestPrepareEmptyDir(t *testing.T) {
 	t.Parallel()
 	testCases := []struct {
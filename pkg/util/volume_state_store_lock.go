@@ -20,6 +20,7 @@ package util
 import (
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // VolumeStateStore provides a thread-safe map for storing volume states.
@@ -30,6 +31,91 @@ type VolumeStateStore struct {
 
 type VolumeState struct {
 	BucketAccessCheckPassed bool
+	// Published is set once the volume is mounted to the target path,
+	// along with the bucket name and mount options it was published with.
+	Published             bool
+	PublishedBucketName   string
+	PublishedMountOptions []string
+	// RequestedMountOptions are the mount options of the request that published the volume,
+	// before the CSI driver added the options derived from the Pod and the node, so that later requests are compared with them.
+	RequestedMountOptions []string
+	// ReportedMountOptionsChange is the last change of the requested mount options reported on the Pod,
+	// so that the change is only reported once.
+	ReportedMountOptionsChange string
+	// PublishedPodNamespace, PublishedPodName and PublishedServiceAccount identify the Pod the volume is published for,
+	// so that the unmount is audited with the Pod, which may no longer exist.
+	PublishedPodNamespace   string
+	PublishedPodName        string
+	PublishedServiceAccount string
+	// BucketHealthCheckedAt is the last time the bucket of the published volume was checked.
+	BucketHealthCheckedAt time.Time
+	// Abnormal and ConditionMessage are the volume condition reported by NodeGetVolumeStats.
+	Abnormal         bool
+	ConditionMessage string
+	// UsageCountedAt is the last time the objects of the published volume were counted.
+	UsageCountedAt time.Time
+	// SidecarVersionChecked is set once the version of the sidecar mounter that connected to the volume socket was checked.
+	SidecarVersionChecked bool
+
+	// stats is the snapshot of the volume condition, which NodeGetVolumeStats reads without the volume lock.
+	stats atomic.Pointer[VolumeStats]
+	// usage is the last usage of the objects of the published volume, which is counted in the background without the volume lock.
+	usage atomic.Pointer[VolumeUsage]
+	// countingUsage is set while the objects of the published volume are counted.
+	countingUsage atomic.Bool
+}
+
+// VolumeStats is a read-only snapshot of the condition of a published volume.
+type VolumeStats struct {
+	Abnormal         bool
+	ConditionMessage string
+}
+
+// VolumeUsage is the total size and the number of the objects of a published volume,
+// reported by NodeGetVolumeStats as the used bytes and inodes.
+type VolumeUsage struct {
+	UsedBytes   int64
+	UsedObjects int64
+}
+
+// PublishStats takes a snapshot of the volume condition. Call it while holding the volume lock,
+// after the volume is published, or its condition changed.
+func (vs *VolumeState) PublishStats() {
+	vs.stats.Store(&VolumeStats{
+		Abnormal:         vs.Abnormal,
+		ConditionMessage: vs.ConditionMessage,
+	})
+}
+
+// Stats returns the last snapshot of the volume condition, or nil if the volume was not published.
+// It is safe to call without the volume lock.
+func (vs *VolumeState) Stats() *VolumeStats {
+	return vs.stats.Load()
+}
+
+// StartUsageCount returns true if the objects of the volume are not being counted, and marks them as being counted.
+// EndUsageCount must be called once the count is done.
+func (vs *VolumeState) StartUsageCount() bool {
+	return vs.countingUsage.CompareAndSwap(false, true)
+}
+
+// EndUsageCount stores the usage of the volume if it is not nil, and marks the objects of the volume as no longer being counted.
+func (vs *VolumeState) EndUsageCount(usage *VolumeUsage) {
+	if usage != nil {
+		vs.usage.Store(usage)
+	}
+	vs.countingUsage.Store(false)
+}
+
+// CountingUsage returns true while the objects of the volume are counted.
+func (vs *VolumeState) CountingUsage() bool {
+	return vs.countingUsage.Load()
+}
+
+// Usage returns the last counted usage of the volume, or nil if the objects of the volume were not counted.
+// It is safe to call without the volume lock.
+func (vs *VolumeState) Usage() *VolumeUsage {
+	return vs.usage.Load()
 }
 
 // NewVolumeStateStore initializes the volume state store.
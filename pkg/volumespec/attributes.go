@@ -0,0 +1,276 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package volumespec builds the Kubernetes volumes of the Cloud Storage FUSE CSI driver, so that controllers can generate
+// PersistentVolumes and CSI ephemeral volumes with valid volume attributes, without depending on the driver internals.
+package volumespec
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// DefaultDriverName is the name of the CSI driver, unless it is installed with another name by the --driver-name flag.
+const DefaultDriverName = "gcsfuse.csi.storage.gke.io"
+
+// PodAnnotationVolumes is the Pod annotation that makes the webhook inject the sidecar container, which the volumes need.
+const PodAnnotationVolumes = "gke-gcsfuse/volumes"
+
+// The volume attributes that the CSI driver reads.
+const (
+	AttributeBucketName                 = "bucketName"
+	AttributeMountOptions               = "mountOptions"
+	AttributeVolumeAttributesVersion    = "volumeAttributesVersion"
+	AttributeFileCacheCapacity          = "fileCacheCapacity"
+	AttributeFileCacheForRangeRead      = "fileCacheForRangeRead"
+	AttributeFileCacheParallelDownloads = "fileCacheParallelDownloads"
+	AttributeMetadataStatCacheCapacity  = "metadataStatCacheCapacity"
+	AttributeMetadataTypeCacheCapacity  = "metadataTypeCacheCapacity"
+	AttributeMetadataCacheTTLSeconds    = "metadataCacheTTLSeconds"
+	AttributeMetadataPrefetchOnMount    = "gcsfuseMetadataPrefetchOnMount"
+	AttributeKernelListCacheTTLSeconds  = "kernelListCacheTTLSeconds"
+	AttributeGcsfuseLoggingSeverity     = "gcsfuseLoggingSeverity"
+	AttributeGcsfuseExperimentalFlags   = "gcsfuseExperimentalFlags"
+	AttributeSkipCSIBucketAccessCheck   = "skipCSIBucketAccessCheck"
+	AttributeDisableMetrics             = "disableMetrics"
+	AttributeImplicitDirsAutoDetect     = "implicitDirsAutoDetect"
+	AttributeVerifyReadOnMount          = "verifyReadOnMount"
+	AttributeVerifyReadObject           = "verifyReadObject"
+	AttributeHierarchicalNamespace      = "hierarchicalNamespace"
+	AttributeMaxConnsPerHost            = "maxConnsPerHost"
+	AttributeMaxIdleConnsPerHost        = "maxIdleConnsPerHost"
+	AttributeClientProtocol             = "clientProtocol"
+	AttributeFileMode                   = "fileMode"
+	AttributeDirMode                    = "dirMode"
+	AttributeTokenRefreshSeconds        = "tokenRefreshSeconds"
+	AttributeGCPServiceAccount          = "gcpServiceAccount"
+	AttributeWorkloadRecommendations    = "workloadRecommendations"
+	AttributePreventDestroyNonEmpty     = "preventDestroyNonEmpty"
+	AttributePreventDestroyMaxObjects   = "preventDestroyMaxObjects"
+)
+
+// The values of the enum volume attributes.
+const (
+	VolumeAttributesVersionV1 = "v1"
+	HierarchicalNamespaceAuto = "auto"
+)
+
+// ClientProtocols are the protocols of the clientProtocol volume attribute.
+var ClientProtocols = []string{"http1", "http2", "grpc"}
+
+// gcpServiceAccountRegEx matches the emails of the GCP service accounts, such as sa-name@project-id.iam.gserviceaccount.com.
+var gcpServiceAccountRegEx = regexp.MustCompile(`^[a-z0-9-]+@[a-z0-9.-]+\.gserviceaccount\.com$`)
+
+// attributeValidators validate the value of each volume attribute the way the CSI driver parses it.
+var attributeValidators = map[string]func(string) error{
+	AttributeBucketName:                 validateNonEmpty,
+	AttributeMountOptions:               validateAny,
+	AttributeVolumeAttributesVersion:    validateOneOf(VolumeAttributesVersionV1),
+	AttributeFileCacheCapacity:          validateQuantity,
+	AttributeFileCacheForRangeRead:      validateBool,
+	AttributeFileCacheParallelDownloads: validateBool,
+	AttributeMetadataStatCacheCapacity:  validateQuantity,
+	AttributeMetadataTypeCacheCapacity:  validateQuantity,
+	AttributeMetadataCacheTTLSeconds:    validateInt,
+	AttributeMetadataPrefetchOnMount:    validateBool,
+	AttributeKernelListCacheTTLSeconds:  validateInt,
+	AttributeGcsfuseLoggingSeverity:     validateAny,
+	AttributeGcsfuseExperimentalFlags:   validateAny,
+	AttributeSkipCSIBucketAccessCheck:   validateBool,
+	AttributeDisableMetrics:             validateBool,
+	AttributeImplicitDirsAutoDetect:     validateBool,
+	AttributeVerifyReadOnMount:          validateBool,
+	AttributeVerifyReadObject:           validateAny,
+	AttributeHierarchicalNamespace:      validateHierarchicalNamespace,
+	AttributeMaxConnsPerHost:            validateNonNegativeInt,
+	AttributeMaxIdleConnsPerHost:        validateNonNegativeInt,
+	AttributeClientProtocol:             validateOneOf(ClientProtocols...),
+	AttributeFileMode:                   validateMode,
+	AttributeDirMode:                    validateMode,
+	AttributeTokenRefreshSeconds:        validatePositiveInt,
+	AttributeGCPServiceAccount:          validateGCPServiceAccount,
+	AttributeWorkloadRecommendations:    validateBool,
+	AttributePreventDestroyNonEmpty:     validateBool,
+	AttributePreventDestroyMaxObjects:   validateNonNegativeInt,
+}
+
+// IsKnownAttribute returns whether the CSI driver reads the volume attribute.
+func IsKnownAttribute(key string) bool {
+	_, ok := attributeValidators[key]
+
+	return ok
+}
+
+// ValidateAttributes returns the reasons the CSI driver rejects the volume attributes, joined in a single error,
+// or nil if they are valid. Unknown volume attributes, such as the ones that kubelet sets, are not validated.
+func ValidateAttributes(attributes map[string]string) error {
+	errs := []error{}
+	for _, key := range slices.Sorted(maps.Keys(attributes)) {
+		if !IsKnownAttribute(key) {
+			continue
+		}
+		if err := ValidateAttribute(key, attributes[key]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	errs = append(errs, validateAttributeCombinations(attributes)...)
+
+	return errors.Join(errs...)
+}
+
+// validateAttributeCombinations checks the volume attributes that depend on each other.
+func validateAttributeCombinations(attributes map[string]string) []error {
+	errs := []error{}
+	if object := attributes[AttributeVerifyReadObject]; object != "" {
+		if verify, _ := strconv.ParseBool(attributes[AttributeVerifyReadOnMount]); !verify {
+			errs = append(errs, fmt.Errorf("volume attribute %v requires volume attribute %v to be true", AttributeVerifyReadObject, AttributeVerifyReadOnMount))
+		}
+	}
+
+	return errs
+}
+
+// ValidateBucketAttributes returns the reasons the CSI driver rejects the volume attributes for the bucket name, joined in a single error,
+// or nil if they are valid. The volume attributes that check the data of a single bucket cannot be used with the bucket name "_",
+// which mounts all the buckets the identity can access.
+func ValidateBucketAttributes(bucketName string, attributes map[string]string) error {
+	errs := []error{}
+	if bucketName == allBucketsName {
+		if hns := attributes[AttributeHierarchicalNamespace]; hns == HierarchicalNamespaceAuto || isTrue(hns) {
+			errs = append(errs, fmt.Errorf("volume attribute %v cannot be used when mounting all the buckets", AttributeHierarchicalNamespace))
+		}
+		if isTrue(attributes[AttributeVerifyReadOnMount]) {
+			errs = append(errs, fmt.Errorf("volume attribute %v cannot be used when mounting all the buckets", AttributeVerifyReadOnMount))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func isTrue(value string) bool {
+	b, _ := strconv.ParseBool(value)
+
+	return b
+}
+
+// ValidateAttribute returns an error if the CSI driver rejects the value of the volume attribute, or does not know the attribute.
+func ValidateAttribute(key, value string) error {
+	validate, ok := attributeValidators[key]
+	if !ok {
+		return fmt.Errorf("volume attribute %v is unknown", key)
+	}
+	if err := validate(value); err != nil {
+		return fmt.Errorf("volume attribute %v %w, got %q", key, err, value)
+	}
+
+	return nil
+}
+
+func validateAny(string) error {
+	return nil
+}
+
+func validateNonEmpty(value string) error {
+	if value == "" {
+		return errors.New("cannot be empty")
+	}
+
+	return nil
+}
+
+func validateBool(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return errors.New("only accepts a valid bool value")
+	}
+
+	return nil
+}
+
+func validateQuantity(value string) error {
+	if _, err := resource.ParseQuantity(value); err != nil {
+		return errors.New("only accepts a valid Quantity value")
+	}
+
+	return nil
+}
+
+func validateInt(value string) error {
+	if _, err := strconv.Atoi(value); err != nil {
+		return errors.New("only accepts a valid int value")
+	}
+
+	return nil
+}
+
+func validateNonNegativeInt(value string) error {
+	if i, err := strconv.Atoi(value); err != nil || i < 0 {
+		return errors.New("only accepts a non-negative int value")
+	}
+
+	return nil
+}
+
+func validatePositiveInt(value string) error {
+	if i, err := strconv.Atoi(value); err != nil || i <= 0 {
+		return errors.New("only accepts a positive int value")
+	}
+
+	return nil
+}
+
+func validateMode(value string) error {
+	if mode, err := strconv.ParseUint(value, 8, 32); err != nil || mode > 0o777 {
+		return errors.New("only accepts octal permission bits between 0 and 777")
+	}
+
+	return nil
+}
+
+func validateHierarchicalNamespace(value string) error {
+	if value == HierarchicalNamespaceAuto {
+		return nil
+	}
+	if _, err := strconv.ParseBool(value); err != nil {
+		return fmt.Errorf("only accepts a valid bool value or %q", HierarchicalNamespaceAuto)
+	}
+
+	return nil
+}
+
+func validateGCPServiceAccount(value string) error {
+	if !gcpServiceAccountRegEx.MatchString(value) {
+		return errors.New("only accepts a GCP service account email")
+	}
+
+	return nil
+}
+
+func validateOneOf(values ...string) func(string) error {
+	return func(value string) error {
+		if !slices.Contains(values, value) {
+			return fmt.Errorf("only accepts one of %q", values)
+		}
+
+		return nil
+	}
+}
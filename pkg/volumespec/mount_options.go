@@ -0,0 +1,48 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumespec
+
+// The gcsfuse mount option prefixes that the TTL volume attributes are translated to.
+const (
+	MountOptionMetadataCacheTTLSeconds   = "metadata-cache:ttl-secs:"
+	MountOptionKernelListCacheTTLSeconds = "file-system:kernel-list-cache-ttl-secs:"
+)
+
+// ttlAttributeMountOptions maps the TTL volume attributes to their gcsfuse mount option prefixes.
+var ttlAttributeMountOptions = map[string]string{
+	AttributeMetadataCacheTTLSeconds:   MountOptionMetadataCacheTTLSeconds,
+	AttributeKernelListCacheTTLSeconds: MountOptionKernelListCacheTTLSeconds,
+}
+
+// multiWriterUnsafeMountOptions are the gcsfuse mount options that are known to lose data
+// when multiple writers modify the same objects. GCS objects are immutable, so concurrent writers
+// follow last-writer-wins semantics and appends from different writers are never merged.
+var multiWriterUnsafeMountOptions = map[string]string{
+	MountOptionMetadataCacheTTLSeconds + "-1":   "the metadata cache never expires, so writers do not observe each other's changes",
+	"write:enable-streaming-writes:true":        "streaming writes do not support concurrent writers of the same object",
+	MountOptionKernelListCacheTTLSeconds + "-1": "the kernel list cache never expires, so readers do not observe the files created by other writers",
+}
+
+// MultiWriterUnsafeMountOption returns why the gcsfuse mount option can lose data when multiple writers modify the same objects,
+// or false if the option is safe. The driver accepts the unsafe options, because they are safe when the writers do not
+// modify the same objects, and reports them as warnings.
+func MultiWriterUnsafeMountOption(option string) (string, bool) {
+	reason, ok := multiWriterUnsafeMountOptions[option]
+
+	return reason, ok
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumespec
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// The StorageClass parameters that CreateVolume reads.
+const (
+	// User provided labels.
+	ParameterLabels = "labels"
+
+	// Source bucket and object prefix used to pre-populate the new bucket.
+	ParameterSeedBucketName   = "seedBucketName"
+	ParameterSeedObjectPrefix = "seedObjectPrefix"
+
+	// Existing bucket that volumes are provisioned in as object prefixes, instead of creating a bucket for each volume.
+	ParameterSharedBucketName = "sharedBucketName"
+	// IAM member granted access to the object prefix of a volume in the shared bucket, using an IAM condition.
+	// The member may contain the ${pvc.namespace} and ${pvc.name} placeholders.
+	ParameterPrefixIAMMember = "prefixIAMMember"
+	ParameterPrefixIAMRole   = "prefixIAMRole"
+	// Whether the directory placeholder object of a volume in the shared bucket is created, so that the volume
+	// can be mounted without the implicit-dirs flag. It defaults to false, because it needs permission to create objects.
+	ParameterCreateDir = "createDir"
+	// Whether an existing bucket with the name of the volume is adopted, instead of failing the provisioning.
+	// The bucket must carry the labels of the labels parameter.
+	ParameterAdoptExistingBucket = "adoptExistingBucket"
+	// Whether DeleteVolume refuses to delete the bucket, or the objects under the prefix of a volume in a shared bucket,
+	// while it holds more than preventDestroyMaxObjects objects, which defaults to 0.
+	ParameterPreventDestroyNonEmpty   = "preventDestroyNonEmpty"
+	ParameterPreventDestroyMaxObjects = "preventDestroyMaxObjects"
+)
+
+// externalProvisionerParameterPrefix is the prefix of the StorageClass parameters that the external-provisioner reads,
+// and of the parameters it adds to the CreateVolume requests, such as csi.storage.k8s.io/pvc/name.
+const externalProvisionerParameterPrefix = "csi.storage.k8s.io/"
+
+// knownStorageClassParameters are the StorageClass parameters that CreateVolume reads.
+var knownStorageClassParameters = sets.New(
+	ParameterLabels,
+	ParameterSeedBucketName,
+	ParameterSeedObjectPrefix,
+	ParameterSharedBucketName,
+	ParameterPrefixIAMMember,
+	ParameterPrefixIAMRole,
+	ParameterCreateDir,
+	ParameterAdoptExistingBucket,
+	ParameterPreventDestroyNonEmpty,
+	ParameterPreventDestroyMaxObjects,
+)
+
+// iamMemberTypes are the prefixes of the IAM members that the prefixIAMMember parameter accepts.
+var iamMemberTypes = []string{"user:", "serviceAccount:", "group:", "domain:", "principal:", "principalSet:"}
+
+// iamRolePrefixes are the prefixes of the predefined and custom IAM roles that the prefixIAMRole parameter accepts.
+var iamRolePrefixes = []string{"roles/", "projects/", "organizations/"}
+
+// ValidateStorageClassParameters returns an error that lists all the problems of the StorageClass parameters,
+// or nil if CreateVolume accepts them. It does not look up the buckets, so that the parameters of a StorageClass
+// can be validated when the StorageClass is created, before the first PersistentVolumeClaim is provisioned.
+func ValidateStorageClassParameters(parameters map[string]string) error {
+	problems := []string{}
+	for _, key := range sets.List(sets.KeySet(parameters)) {
+		if !knownStorageClassParameters.Has(key) && !strings.HasPrefix(key, externalProvisionerParameterPrefix) {
+			problems = append(problems, fmt.Sprintf("parameter %q is unknown, the supported parameters are %q", key, sets.List(knownStorageClassParameters)))
+		}
+	}
+
+	if _, err := ParseLabels(parameters[ParameterLabels]); err != nil {
+		problems = append(problems, fmt.Sprintf("parameters contain invalid labels parameter: %v", err))
+	}
+
+	_, hasSeedBucket := parameters[ParameterSeedBucketName]
+	if _, ok := parameters[ParameterSeedObjectPrefix]; ok && !hasSeedBucket {
+		problems = append(problems, fmt.Sprintf("parameter %q requires parameter %q to be set", ParameterSeedObjectPrefix, ParameterSeedBucketName))
+	}
+
+	sharedBucketName, hasSharedBucket := parameters[ParameterSharedBucketName]
+	if hasSharedBucket && hasSeedBucket {
+		problems = append(problems, fmt.Sprintf("volumes in shared bucket %q cannot be pre-populated", sharedBucketName))
+	}
+	for _, key := range []string{ParameterCreateDir, ParameterPrefixIAMMember, ParameterPrefixIAMRole} {
+		if _, ok := parameters[key]; ok && !hasSharedBucket {
+			problems = append(problems, fmt.Sprintf("parameter %q can only be used together with parameter %q", key, ParameterSharedBucketName))
+		}
+	}
+
+	for _, key := range []string{ParameterCreateDir, ParameterAdoptExistingBucket, ParameterPreventDestroyNonEmpty} {
+		if value, ok := parameters[key]; ok {
+			if _, err := strconv.ParseBool(value); err != nil {
+				problems = append(problems, fmt.Sprintf("parameter %q only accepts a valid bool value, got %q", key, value))
+			}
+		}
+	}
+
+	if value, ok := parameters[ParameterAdoptExistingBucket]; ok {
+		if hasSharedBucket {
+			problems = append(problems, fmt.Sprintf("parameter %q cannot be used together with parameter %q", ParameterAdoptExistingBucket, ParameterSharedBucketName))
+		}
+		if hasSeedBucket {
+			problems = append(problems, fmt.Sprintf("parameter %q cannot be used together with parameter %q", ParameterAdoptExistingBucket, ParameterSeedBucketName))
+		}
+		if adopt, _ := strconv.ParseBool(value); adopt && parameters[ParameterLabels] == "" {
+			problems = append(problems, fmt.Sprintf("parameter %q requires parameter %q to identify the buckets that can be adopted", ParameterAdoptExistingBucket, ParameterLabels))
+		}
+	}
+
+	if value, ok := parameters[ParameterPreventDestroyMaxObjects]; ok {
+		if maxObjects, err := strconv.Atoi(value); err != nil || maxObjects < 0 {
+			problems = append(problems, fmt.Sprintf("parameter %q only accepts a non-negative int value, got %q", ParameterPreventDestroyMaxObjects, value))
+		}
+		if prevent, _ := strconv.ParseBool(parameters[ParameterPreventDestroyNonEmpty]); !prevent {
+			problems = append(problems, fmt.Sprintf("parameter %q requires parameter %q to be true", ParameterPreventDestroyMaxObjects, ParameterPreventDestroyNonEmpty))
+		}
+	}
+
+	member, hasMember := parameters[ParameterPrefixIAMMember]
+	if hasMember && !slices.ContainsFunc(iamMemberTypes, func(t string) bool { return strings.HasPrefix(member, t) && len(member) > len(t) }) {
+		problems = append(problems, fmt.Sprintf("parameter %q must be an IAM principal starting with one of %q, got %q", ParameterPrefixIAMMember, iamMemberTypes, member))
+	}
+	if role, ok := parameters[ParameterPrefixIAMRole]; ok {
+		if !hasMember {
+			problems = append(problems, fmt.Sprintf("parameter %q requires parameter %q to be set", ParameterPrefixIAMRole, ParameterPrefixIAMMember))
+		}
+		if !slices.ContainsFunc(iamRolePrefixes, func(p string) bool { return strings.HasPrefix(role, p) && len(role) > len(p) }) {
+			problems = append(problems, fmt.Sprintf("parameter %q must be an IAM role starting with one of %q, got %q", ParameterPrefixIAMRole, iamRolePrefixes, role))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return errors.New(strings.Join(problems, "; "))
+}
+
+// ParseLabels converts the labels from string to map
+// example: "key1=value1,key2=value2" gets converted into {"key1": "value1", "key2": "value2"}
+func ParseLabels(labels string) (map[string]string, error) {
+	const labelsDelimiter = ","
+	const labelsKeyValueDelimiter = "="
+
+	labelsMap := make(map[string]string)
+	if labels == "" {
+		return labelsMap, nil
+	}
+
+	// Following rules enforced for label keys
+	// 1. Keys have a minimum length of 1 character and a maximum length of 63 characters, and cannot be empty.
+	// 2. Keys and values can contain only lowercase letters, numeric characters, underscores, and dashes.
+	// 3. Keys must start with a lowercase letter.
+	regexKey := regexp.MustCompile(`^\p{Ll}[\p{Ll}0-9_-]{0,62}$`)
+	checkLabelKeyFn := func(key string) error {
+		if !regexKey.MatchString(key) {
+			return fmt.Errorf("label value %q is invalid (should start with lowercase letter / lowercase letter, digit, _ and - chars are allowed / 1-63 characters", key)
+		}
+
+		return nil
+	}
+
+	// Values can be empty, and have a maximum length of 63 characters.
+	regexValue := regexp.MustCompile(`^[\p{Ll}0-9_-]{0,63}$`)
+	checkLabelValueFn := func(value string) error {
+		if !regexValue.MatchString(value) {
+			return fmt.Errorf("label value %q is invalid (lowercase letter, digit, _ and - chars are allowed / 0-63 characters", value)
+		}
+
+		return nil
+	}
+
+	keyValueStrings := strings.Split(labels, labelsDelimiter)
+	for _, keyValue := range keyValueStrings {
+		keyValue := strings.Split(keyValue, labelsKeyValueDelimiter)
+
+		if len(keyValue) != 2 {
+			return nil, fmt.Errorf("labels %q are invalid, correct format: 'key1=value1,key2=value2'", labels)
+		}
+
+		key := strings.TrimSpace(keyValue[0])
+		if err := checkLabelKeyFn(key); err != nil {
+			return nil, err
+		}
+
+		value := strings.TrimSpace(keyValue[1])
+		if err := checkLabelValueFn(value); err != nil {
+			return nil, err
+		}
+
+		labelsMap[key] = value
+	}
+
+	const maxNumberOfLabels = 64
+	if len(labelsMap) > maxNumberOfLabels {
+		return nil, fmt.Errorf("more than %d labels is not allowed, given: %d", maxNumberOfLabels, len(labelsMap))
+	}
+
+	return labelsMap, nil
+}
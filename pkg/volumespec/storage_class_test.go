@@ -0,0 +1,132 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumespec
+
+import (
+	"testing"
+)
+
+func TestValidateStorageClassParameters(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		parameters  map[string]string
+		expectedErr string
+	}{
+		{
+			name: "no parameters",
+		},
+		{
+			name: "valid bucket parameters",
+			parameters: map[string]string{
+				ParameterLabels:                              "team=ml",
+				ParameterAdoptExistingBucket:                 "true",
+				"csi.storage.k8s.io/provisioner-secret-name": "sa",
+			},
+		},
+		{
+			name: "valid shared bucket parameters",
+			parameters: map[string]string{
+				ParameterSharedBucketName: "shared",
+				ParameterCreateDir:        "false",
+				ParameterPrefixIAMMember:  "principalSet://iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/p.svc.id.goog/namespace/${pvc.namespace}",
+				ParameterPrefixIAMRole:    "roles/storage.objectViewer",
+			},
+		},
+		{
+			name: "valid deletion protection parameters",
+			parameters: map[string]string{
+				ParameterPreventDestroyNonEmpty:   "true",
+				ParameterPreventDestroyMaxObjects: "10",
+			},
+		},
+		{
+			name: "invalid deletion protection parameters",
+			parameters: map[string]string{
+				ParameterPreventDestroyNonEmpty:   "false",
+				ParameterPreventDestroyMaxObjects: "-1",
+			},
+			expectedErr: `parameter "preventDestroyMaxObjects" only accepts a non-negative int value, got "-1"; ` +
+				`parameter "preventDestroyMaxObjects" requires parameter "preventDestroyNonEmpty" to be true`,
+		},
+		{
+			name:        "unknown parameter",
+			parameters:  map[string]string{"location": "us-central1"},
+			expectedErr: `parameter "location" is unknown, the supported parameters are ["adoptExistingBucket" "createDir" "labels" "prefixIAMMember" "prefixIAMRole" "preventDestroyMaxObjects" "preventDestroyNonEmpty" "seedBucketName" "seedObjectPrefix" "sharedBucketName"]`,
+		},
+		{
+			name:        "invalid labels",
+			parameters:  map[string]string{ParameterLabels: "Team=ml"},
+			expectedErr: `parameters contain invalid labels parameter: label value "Team" is invalid (should start with lowercase letter / lowercase letter, digit, _ and - chars are allowed / 1-63 characters`,
+		},
+		{
+			name: "seed bucket with shared bucket",
+			parameters: map[string]string{
+				ParameterSharedBucketName: "shared",
+				ParameterSeedBucketName:   "seed",
+			},
+			expectedErr: `volumes in shared bucket "shared" cannot be pre-populated`,
+		},
+		{
+			name: "adopted bucket with seed bucket",
+			parameters: map[string]string{
+				ParameterLabels:              "team=ml",
+				ParameterAdoptExistingBucket: "true",
+				ParameterSeedBucketName:      "seed",
+			},
+			expectedErr: `parameter "adoptExistingBucket" cannot be used together with parameter "seedBucketName"`,
+		},
+		{
+			name: "all the problems are reported",
+			parameters: map[string]string{
+				ParameterCreateDir:           "yes",
+				ParameterPrefixIAMRole:       "storage.objectUser",
+				ParameterAdoptExistingBucket: "true",
+			},
+			expectedErr: `parameter "createDir" can only be used together with parameter "sharedBucketName"; ` +
+				`parameter "prefixIAMRole" can only be used together with parameter "sharedBucketName"; ` +
+				`parameter "createDir" only accepts a valid bool value, got "yes"; ` +
+				`parameter "adoptExistingBucket" requires parameter "labels" to identify the buckets that can be adopted; ` +
+				`parameter "prefixIAMRole" requires parameter "prefixIAMMember" to be set; ` +
+				`parameter "prefixIAMRole" must be an IAM role starting with one of ["roles/" "projects/" "organizations/"], got "storage.objectUser"`,
+		},
+		{
+			name: "invalid IAM member",
+			parameters: map[string]string{
+				ParameterSharedBucketName: "shared",
+				ParameterPrefixIAMMember:  "alice@example.com",
+			},
+			expectedErr: `parameter "prefixIAMMember" must be an IAM principal starting with one of ["user:" "serviceAccount:" "group:" "domain:" "principal:" "principalSet:"], got "alice@example.com"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := ValidateStorageClassParameters(tc.parameters)
+			switch {
+			case tc.expectedErr == "" && err != nil:
+				t.Errorf("got error %v, expected nil", err)
+			case tc.expectedErr != "" && (err == nil || err.Error() != tc.expectedErr):
+				t.Errorf("got error %v, expected %q", err, tc.expectedErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumespec
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// allBucketsName is the bucket name that mounts all the buckets the identity can access.
+const allBucketsName = "_"
+
+// Volume describes a Cloud Storage FUSE volume. Use New to create it, the With methods to configure it,
+// and EphemeralVolumeSource or PersistentVolume to generate the Kubernetes volume.
+type Volume struct {
+	driverName   string
+	bucketName   string
+	readOnly     bool
+	mountOptions []string
+	attributes   map[string]string
+}
+
+// New returns a volume that mounts the bucket, or all the buckets the identity can access if the bucket name is "_".
+// The volume declares the current volume attribute schema, so that the driver rejects deprecated settings.
+func New(bucketName string) *Volume {
+	return &Volume{
+		driverName: DefaultDriverName,
+		bucketName: bucketName,
+		attributes: map[string]string{AttributeVolumeAttributesVersion: VolumeAttributesVersionV1},
+	}
+}
+
+// WithDriverName sets the name of the CSI driver installation that mounts the volume, if it is not DefaultDriverName.
+func (v *Volume) WithDriverName(name string) *Volume {
+	v.driverName = name
+
+	return v
+}
+
+// WithReadOnly mounts the volume read-only.
+func (v *Volume) WithReadOnly(readOnly bool) *Volume {
+	v.readOnly = readOnly
+
+	return v
+}
+
+// WithMountOptions appends gcsfuse mount options, such as "implicit-dirs" or "file-cache:enable-parallel-downloads:true".
+func (v *Volume) WithMountOptions(options ...string) *Volume {
+	v.mountOptions = append(v.mountOptions, options...)
+
+	return v
+}
+
+// WithAttribute sets a volume attribute. The value is checked by Validate.
+func (v *Volume) WithAttribute(key, value string) *Volume {
+	v.attributes[key] = value
+
+	return v
+}
+
+// WithFileCacheCapacity sets the capacity of the file cache, where a negative value means unlimited.
+func (v *Volume) WithFileCacheCapacity(capacity resource.Quantity) *Volume {
+	return v.WithAttribute(AttributeFileCacheCapacity, capacity.String())
+}
+
+// WithMetadataCacheCapacity sets the capacities of the stat and type metadata caches, where a negative value means unlimited.
+func (v *Volume) WithMetadataCacheCapacity(statCapacity, typeCapacity resource.Quantity) *Volume {
+	return v.WithAttribute(AttributeMetadataStatCacheCapacity, statCapacity.String()).
+		WithAttribute(AttributeMetadataTypeCacheCapacity, typeCapacity.String())
+}
+
+// WithMetadataCacheTTL sets how long the metadata cache entries are valid, where a negative value means they never expire.
+func (v *Volume) WithMetadataCacheTTL(ttl time.Duration) *Volume {
+	return v.WithAttribute(AttributeMetadataCacheTTLSeconds, strconv.Itoa(durationSeconds(ttl)))
+}
+
+// WithMetadataPrefetchOnMount lists the bucket when the volume is mounted to fill the metadata cache.
+func (v *Volume) WithMetadataPrefetchOnMount(enabled bool) *Volume {
+	return v.WithAttribute(AttributeMetadataPrefetchOnMount, strconv.FormatBool(enabled))
+}
+
+// WithGCPServiceAccount makes the volume access the bucket as the GCP service account, instead of the Pod identity.
+func (v *Volume) WithGCPServiceAccount(email string) *Volume {
+	return v.WithAttribute(AttributeGCPServiceAccount, email)
+}
+
+// WithSkipBucketAccessCheck skips the bucket access check of the driver, so that the volume mounts faster.
+func (v *Volume) WithSkipBucketAccessCheck(skip bool) *Volume {
+	return v.WithAttribute(AttributeSkipCSIBucketAccessCheck, strconv.FormatBool(skip))
+}
+
+func durationSeconds(d time.Duration) int {
+	if d < 0 {
+		return -1
+	}
+
+	return int(d / time.Second)
+}
+
+// Validate returns the reasons the driver rejects the volume, joined in a single error, or nil if the volume is valid.
+func (v *Volume) Validate() error {
+	errs := []error{}
+	if v.bucketName == "" {
+		errs = append(errs, errors.New("bucket name cannot be empty"))
+	}
+	if v.driverName == "" {
+		errs = append(errs, errors.New("driver name cannot be empty"))
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(v.attributes)) {
+		if key == AttributeBucketName || key == AttributeMountOptions {
+			errs = append(errs, fmt.Errorf("volume attribute %v is set by the volume, use New or WithMountOptions instead", key))
+
+			continue
+		}
+		if err := ValidateAttribute(key, v.attributes[key]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, o := range v.mountOptions {
+		if strings.Contains(o, ",") {
+			errs = append(errs, fmt.Errorf("mount option %q cannot contain a comma, pass each option separately", o))
+		}
+	}
+
+	errs = append(errs, validateAttributeCombinations(v.attributes)...)
+	if err := ValidateBucketAttributes(v.bucketName, v.attributes); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Warnings returns the settings that the driver accepts, but that can lose data. Writable volumes can be published
+// to multiple writers, so the mount options that are unsafe for multiple writers are reported.
+func (v *Volume) Warnings() []string {
+	if v.readOnly {
+		return nil
+	}
+
+	options := slices.Clone(v.mountOptions)
+	for attribute, option := range ttlAttributeMountOptions {
+		if ttl, err := strconv.Atoi(v.attributes[attribute]); err == nil && ttl < 0 {
+			options = append(options, option+"-1")
+		}
+	}
+
+	warnings := []string{}
+	for _, o := range options {
+		if reason, ok := MultiWriterUnsafeMountOption(o); ok {
+			warnings = append(warnings, fmt.Sprintf("mount option %q can lose data when multiple writers modify the same objects: %s", o, reason))
+		}
+	}
+	slices.Sort(warnings)
+
+	return warnings
+}
+
+// EphemeralVolumeSource returns the CSI ephemeral volume source of the volume, for the volumes of a Pod spec.
+// The Pod also needs the PodAnnotationVolumes annotation set to "true".
+func (v *Volume) EphemeralVolumeSource() (*corev1.CSIVolumeSource, error) {
+	if err := v.Validate(); err != nil {
+		return nil, err
+	}
+
+	attributes := maps.Clone(v.attributes)
+	attributes[AttributeBucketName] = v.bucketName
+	if len(v.mountOptions) > 0 {
+		attributes[AttributeMountOptions] = strings.Join(v.mountOptions, ",")
+	}
+
+	return &corev1.CSIVolumeSource{
+		Driver:           v.driverName,
+		ReadOnly:         &v.readOnly,
+		VolumeAttributes: attributes,
+	}, nil
+}
+
+// PersistentVolume returns a statically provisioned PersistentVolume of the volume. The driver ignores the capacity,
+// which only has to match the storage request of the PersistentVolumeClaim that binds to the PersistentVolume.
+func (v *Volume) PersistentVolume(name string, capacity resource.Quantity) (*corev1.PersistentVolume, error) {
+	if err := v.Validate(); err != nil {
+		return nil, err
+	}
+
+	accessMode := corev1.ReadWriteMany
+	if v.readOnly {
+		accessMode = corev1.ReadOnlyMany
+	}
+
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: corev1.PersistentVolumeSpec{
+			AccessModes:                   []corev1.PersistentVolumeAccessMode{accessMode},
+			Capacity:                      corev1.ResourceList{corev1.ResourceStorage: capacity},
+			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimRetain,
+			StorageClassName:              "",
+			MountOptions:                  slices.Clone(v.mountOptions),
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:           v.driverName,
+					VolumeHandle:     v.bucketName,
+					ReadOnly:         v.readOnly,
+					VolumeAttributes: maps.Clone(v.attributes),
+				},
+			},
+		},
+	}, nil
+}
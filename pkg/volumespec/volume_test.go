@@ -0,0 +1,299 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumespec
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestValidateAttribute(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		key     string
+		value   string
+		wantErr bool
+	}{
+		{key: AttributeFileCacheCapacity, value: "10Gi"},
+		{key: AttributeFileCacheCapacity, value: "-1"},
+		{key: AttributeFileCacheCapacity, value: "10GB!", wantErr: true},
+		{key: AttributeFileCacheForRangeRead, value: "true"},
+		{key: AttributeFileCacheForRangeRead, value: "yes", wantErr: true},
+		{key: AttributeMetadataCacheTTLSeconds, value: "-1"},
+		{key: AttributeMetadataCacheTTLSeconds, value: "1m", wantErr: true},
+		{key: AttributeMaxConnsPerHost, value: "0"},
+		{key: AttributeMaxConnsPerHost, value: "-1", wantErr: true},
+		{key: AttributeTokenRefreshSeconds, value: "0", wantErr: true},
+		{key: AttributeFileMode, value: "644"},
+		{key: AttributeFileMode, value: "1777", wantErr: true},
+		{key: AttributeClientProtocol, value: "grpc"},
+		{key: AttributeClientProtocol, value: "http3", wantErr: true},
+		{key: AttributeHierarchicalNamespace, value: "auto"},
+		{key: AttributeHierarchicalNamespace, value: "false"},
+		{key: AttributeHierarchicalNamespace, value: "maybe", wantErr: true},
+		{key: AttributeGCPServiceAccount, value: "reader@my-project.iam.gserviceaccount.com"},
+		{key: AttributeGCPServiceAccount, value: "reader@example.com", wantErr: true},
+		{key: AttributeWorkloadRecommendations, value: "true"},
+		{key: AttributeWorkloadRecommendations, value: "yes", wantErr: true},
+		{key: AttributeVolumeAttributesVersion, value: "v2", wantErr: true},
+		{key: AttributeGcsfuseLoggingSeverity, value: "trace"},
+		{key: "unknownAttribute", value: "true", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.key+"="+tc.value, func(t *testing.T) {
+			t.Parallel()
+			if err := ValidateAttribute(tc.key, tc.value); (err != nil) != tc.wantErr {
+				t.Errorf("ValidateAttribute(%q, %q) got error %v, want error %v", tc.key, tc.value, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateAttributes(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name       string
+		attributes map[string]string
+		wantErrs   int
+	}{
+		{
+			name:       "valid attributes",
+			attributes: map[string]string{AttributeFileCacheCapacity: "10Gi", AttributeVerifyReadOnMount: "true", AttributeVerifyReadObject: "canary"},
+		},
+		{
+			name:       "unknown attributes are not validated",
+			attributes: map[string]string{"csi.storage.k8s.io/pod.name": "test-pod", "unknownAttribute": "true"},
+		},
+		{
+			name:       "every invalid value is reported",
+			attributes: map[string]string{AttributeFileCacheForRangeRead: "yes", AttributeMaxConnsPerHost: "-1"},
+			wantErrs:   2,
+		},
+		{
+			name:       "verify read object without verification",
+			attributes: map[string]string{AttributeVerifyReadObject: "canary"},
+			wantErrs:   1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			errs := 0
+			if err := ValidateAttributes(tc.attributes); err != nil {
+				errs = len(strings.Split(err.Error(), "\n"))
+			}
+			if errs != tc.wantErrs {
+				t.Errorf("ValidateAttributes(%v) got %v errors, want %v", tc.attributes, errs, tc.wantErrs)
+			}
+		})
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		volume       *Volume
+		wantWarnings int
+	}{
+		{
+			name:   "safe writable volume",
+			volume: New("test-bucket").WithMountOptions("implicit-dirs").WithMetadataCacheTTL(time.Minute),
+		},
+		{
+			name:         "unsafe mount option",
+			volume:       New("test-bucket").WithMountOptions("write:enable-streaming-writes:true"),
+			wantWarnings: 1,
+		},
+		{
+			name:         "metadata cache that never expires",
+			volume:       New("test-bucket").WithMetadataCacheTTL(-1),
+			wantWarnings: 1,
+		},
+		{
+			name:   "read-only volume",
+			volume: New("test-bucket").WithReadOnly(true).WithMetadataCacheTTL(-1).WithMountOptions("write:enable-streaming-writes:true"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if warnings := tc.volume.Warnings(); len(warnings) != tc.wantWarnings {
+				t.Errorf("Warnings() got %q, want %v warnings", warnings, tc.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		volume      *Volume
+		expectedErr []string
+	}{
+		{
+			name: "valid volume",
+			volume: New("test-bucket").
+				WithMountOptions("implicit-dirs").
+				WithFileCacheCapacity(resource.MustParse("10Gi")).
+				WithMetadataCacheTTL(-time.Second).
+				WithGCPServiceAccount("reader@my-project.iam.gserviceaccount.com"),
+		},
+		{
+			name:        "empty bucket name",
+			volume:      New(""),
+			expectedErr: []string{"bucket name cannot be empty"},
+		},
+		{
+			name:        "all the invalid attributes are reported",
+			volume:      New("test-bucket").WithAttribute(AttributeFileMode, "999").WithAttribute("unknown", "x"),
+			expectedErr: []string{"volume attribute fileMode only accepts octal permission bits", "volume attribute unknown is unknown"},
+		},
+		{
+			name:        "mount options attribute",
+			volume:      New("test-bucket").WithAttribute(AttributeMountOptions, "implicit-dirs"),
+			expectedErr: []string{"use New or WithMountOptions instead"},
+		},
+		{
+			name:        "mount option with a comma",
+			volume:      New("test-bucket").WithMountOptions("implicit-dirs,uid=1001"),
+			expectedErr: []string{"cannot contain a comma"},
+		},
+		{
+			name:        "verify read when mounting all the buckets",
+			volume:      New("_").WithAttribute(AttributeVerifyReadOnMount, "true"),
+			expectedErr: []string{"volume attribute verifyReadOnMount cannot be used when mounting all the buckets"},
+		},
+		{
+			name:        "verify read object without verify read",
+			volume:      New("test-bucket").WithAttribute(AttributeVerifyReadObject, "ready"),
+			expectedErr: []string{"requires volume attribute verifyReadOnMount to be true"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := tc.volume.Validate()
+			if len(tc.expectedErr) == 0 {
+				if err != nil {
+					t.Errorf("got error %v, want nil", err)
+				}
+
+				return
+			}
+			if err == nil {
+				t.Fatalf("got nil error, want %q", tc.expectedErr)
+			}
+			for _, expected := range tc.expectedErr {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("got error %q, want it to contain %q", err, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestEphemeralVolumeSource(t *testing.T) {
+	t.Parallel()
+
+	source, err := New("test-bucket").
+		WithReadOnly(true).
+		WithMountOptions("implicit-dirs", "uid=1001").
+		WithMetadataPrefetchOnMount(true).
+		EphemeralVolumeSource()
+	if err != nil {
+		t.Fatalf("EphemeralVolumeSource() got error %v", err)
+	}
+
+	readOnly := true
+	expected := &corev1.CSIVolumeSource{
+		Driver:   DefaultDriverName,
+		ReadOnly: &readOnly,
+		VolumeAttributes: map[string]string{
+			AttributeBucketName:              "test-bucket",
+			AttributeMountOptions:            "implicit-dirs,uid=1001",
+			AttributeMetadataPrefetchOnMount: "true",
+			AttributeVolumeAttributesVersion: VolumeAttributesVersionV1,
+		},
+	}
+	if diff := cmp.Diff(expected, source); diff != "" {
+		t.Errorf("EphemeralVolumeSource() unexpected diff (-want +got):\n%s", diff)
+	}
+
+	custom, err := New("test-bucket").WithDriverName("gcsfuse-canary.csi.storage.gke.io").EphemeralVolumeSource()
+	if err != nil {
+		t.Fatalf("EphemeralVolumeSource() got error %v", err)
+	}
+	if custom.Driver != "gcsfuse-canary.csi.storage.gke.io" {
+		t.Errorf("got driver %q, want the custom driver name", custom.Driver)
+	}
+
+	if _, err := New("test-bucket").WithAttribute(AttributeDisableMetrics, "no").EphemeralVolumeSource(); err == nil {
+		t.Error("EphemeralVolumeSource() got nil error for an invalid volume")
+	}
+}
+
+func TestPersistentVolume(t *testing.T) {
+	t.Parallel()
+
+	pv, err := New("test-bucket").
+		WithReadOnly(true).
+		WithMountOptions("implicit-dirs").
+		WithSkipBucketAccessCheck(true).
+		PersistentVolume("test-pv", resource.MustParse("5Gi"))
+	if err != nil {
+		t.Fatalf("PersistentVolume() got error %v", err)
+	}
+
+	if pv.Name != "test-pv" {
+		t.Errorf("got name %q, want %q", pv.Name, "test-pv")
+	}
+	if diff := cmp.Diff([]corev1.PersistentVolumeAccessMode{corev1.ReadOnlyMany}, pv.Spec.AccessModes); diff != "" {
+		t.Errorf("unexpected access modes diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"implicit-dirs"}, pv.Spec.MountOptions); diff != "" {
+		t.Errorf("unexpected mount options diff (-want +got):\n%s", diff)
+	}
+
+	expected := &corev1.CSIPersistentVolumeSource{
+		Driver:       DefaultDriverName,
+		VolumeHandle: "test-bucket",
+		ReadOnly:     true,
+		VolumeAttributes: map[string]string{
+			AttributeSkipCSIBucketAccessCheck: "true",
+			AttributeVolumeAttributesVersion:  VolumeAttributesVersionV1,
+		},
+	}
+	if diff := cmp.Diff(expected, pv.Spec.CSI); diff != "" {
+		t.Errorf("unexpected CSI source diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// Reasons of the admission requests that the AdmissionLimiter rejects.
+const (
+	shedReasonOverloaded = "overloaded"
+	shedReasonTimeout    = "timeout"
+)
+
+// AdmissionLimiter protects the sidecar injection from admission storms, such as large Deployment rollouts. It admits the Pods
+// that do not use the driver without waiting, bounds the number of Pods that are injected concurrently, and answers within the
+// timeout, which must be shorter than the timeoutSeconds of the MutatingWebhookConfiguration. Otherwise, the API server waits
+// for the webhook until its own timeout on every Pod creation of the cluster, and then applies the failure policy, which either
+// creates the Pods without the sidecar container, or rejects all the Pods that the webhook matches.
+// A Pod that cannot be injected in time is rejected, so that its controller retries the creation with a backoff.
+type AdmissionLimiter struct {
+	handler admission.Handler
+	// slots holds a token for each injection in flight, or is nil if the injections are not limited.
+	slots   chan struct{}
+	timeout time.Duration
+
+	shedRequests *prometheus.CounterVec
+	inFlight     prometheus.Gauge
+}
+
+// NewAdmissionLimiter returns a limiter that runs at most maxInFlight injections of the handler concurrently, and answers
+// within the timeout, and registers its metrics. A non-positive maxInFlight does not limit the injections, and a non-positive
+// timeout does not bound the injection time.
+func NewAdmissionLimiter(handler admission.Handler, maxInFlight int, timeout time.Duration, registerer prometheus.Registerer) (*AdmissionLimiter, error) {
+	l := &AdmissionLimiter{
+		handler: handler,
+		timeout: timeout,
+		shedRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gke_gcsfuse_webhook_shed_requests_total",
+			Help: "The number of sidecar injection requests that the webhook rejected, labeled by the reason: overloaded when no injection slot was free within the timeout, or timeout when the injection did not complete within the timeout.",
+		}, []string{"reason"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "gke_gcsfuse_webhook_injections_in_flight",
+			Help: "The number of sidecar injections that the webhook is running.",
+		}),
+	}
+	if maxInFlight > 0 {
+		l.slots = make(chan struct{}, maxInFlight)
+	}
+
+	for _, c := range []prometheus.Collector{l.shedRequests, l.inFlight} {
+		if err := registerer.Register(c); err != nil {
+			return nil, fmt.Errorf("failed to register the admission limiter metrics: %w", err)
+		}
+	}
+	for _, reason := range []string{shedReasonOverloaded, shedReasonTimeout} {
+		l.shedRequests.WithLabelValues(reason)
+	}
+
+	return l, nil
+}
+
+// Handle runs the injection of the Pods that use the driver within the limits, and passes the other requests to the handler.
+func (l *AdmissionLimiter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if !requestsInjection(req) {
+		return l.handler.Handle(ctx, req)
+	}
+
+	if l.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.timeout)
+		defer cancel()
+	}
+
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return l.shed(req, shedReasonOverloaded, http.StatusTooManyRequests, "the webhook is injecting too many Pods, retry later")
+		}
+	}
+
+	// The injection keeps its slot until it returns, even after the request timed out, so that the slow injections
+	// still count towards the limit.
+	l.inFlight.Inc()
+	responses := make(chan admission.Response, 1)
+	go func() {
+		defer func() {
+			l.inFlight.Dec()
+			if l.slots != nil {
+				<-l.slots
+			}
+		}()
+		responses <- l.handler.Handle(ctx, req)
+	}()
+
+	select {
+	case resp := <-responses:
+		return resp
+	case <-ctx.Done():
+		return l.shed(req, shedReasonTimeout, http.StatusServiceUnavailable, fmt.Sprintf("the sidecar injection did not complete within %v, retry later", l.timeout))
+	}
+}
+
+// shed rejects the Pod of the request, and records the reason.
+func (l *AdmissionLimiter) shed(req admission.Request, reason string, code int32, message string) admission.Response {
+	l.shedRequests.WithLabelValues(reason).Inc()
+	klog.Warningf("rejected the sidecar injection of Pod: Name %q, Namespace %q: %s", req.Name, req.Namespace, message)
+
+	return admission.Errored(code, errors.New(message))
+}
+
+// requestsInjection returns whether the request creates a Pod that needs the sidecar container. It only reads the
+// annotations of the Pod, so that the other Pods are admitted without waiting for the injections. The handler quickly
+// rejects the malformed requests, so they do not wait either.
+func requestsInjection(req admission.Request) bool {
+	if req.Operation != admissionv1.Create {
+		return false
+	}
+
+	pod := &metav1.PartialObjectMetadata{}
+	if err := json.Unmarshal(req.Object.Raw, pod); err != nil {
+		return false
+	}
+	enabled, err := ParseBool(pod.Annotations[GcsFuseVolumeEnableAnnotation])
+
+	return err == nil && enabled
+}
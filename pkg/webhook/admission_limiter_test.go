@@ -0,0 +1,107 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// blockingHandler allows every request after it receives from release.
+type blockingHandler struct {
+	release chan struct{}
+}
+
+func (h *blockingHandler) Handle(_ context.Context, _ admission.Request) admission.Response {
+	<-h.release
+
+	return admission.Allowed("injected")
+}
+
+func TestAdmissionLimiter(t *testing.T) {
+	t.Parallel()
+
+	podRequest := func(annotations map[string]string) admission.Request {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Annotations: annotations}}
+
+		return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: serialize(t, pod)},
+		}}
+	}
+	gcsfusePod := podRequest(map[string]string{GcsFuseVolumeEnableAnnotation: "true"})
+	otherPod := podRequest(nil)
+
+	handler := &blockingHandler{release: make(chan struct{})}
+	limiter, err := NewAdmissionLimiter(handler, 1, 50*time.Millisecond, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("got error %v, expected nil", err)
+	}
+
+	// The first injection times out, and keeps its slot until the handler returns.
+	resp := limiter.Handle(context.Background(), gcsfusePod)
+	if resp.Allowed || resp.Result.Code != http.StatusServiceUnavailable {
+		t.Errorf("slow injection: got allowed %v and code %d, expected a rejection with code %d", resp.Allowed, resp.Result.Code, http.StatusServiceUnavailable)
+	}
+
+	// The next injection does not get a slot.
+	resp = limiter.Handle(context.Background(), gcsfusePod)
+	if resp.Allowed || resp.Result.Code != http.StatusTooManyRequests {
+		t.Errorf("overloaded injection: got allowed %v and code %d, expected a rejection with code %d", resp.Allowed, resp.Result.Code, http.StatusTooManyRequests)
+	}
+
+	// The Pods that do not use the driver and the other operations do not wait for a slot.
+	otherResponses := make(chan admission.Response, 2)
+	go func() {
+		otherResponses <- limiter.Handle(context.Background(), otherPod)
+		otherResponses <- limiter.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Operation: admissionv1.Delete}})
+	}()
+	handler.release <- struct{}{}
+	for range 2 {
+		handler.release <- struct{}{}
+		if resp := <-otherResponses; !resp.Allowed {
+			t.Errorf("got a rejection %v, expected the response of the handler", resp.Result)
+		}
+	}
+
+	// The slot is free again.
+	go func() { handler.release <- struct{}{} }()
+	if resp := limiter.Handle(context.Background(), gcsfusePod); !resp.Allowed {
+		t.Errorf("got a rejection %v after the slot was freed, expected the response of the handler", resp.Result)
+	}
+
+	for reason, expected := range map[string]float64{shedReasonTimeout: 1, shedReasonOverloaded: 1} {
+		m := &dto.Metric{}
+		if err := limiter.shedRequests.WithLabelValues(reason).Write(m); err != nil {
+			t.Fatalf("failed to write metric: %v", err)
+		}
+		if got := m.GetCounter().GetValue(); got != expected {
+			t.Errorf("got %v shed requests with reason %q, expected %v", got, reason, expected)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	DefaultLookupCacheTTL = time.Minute
+
+	// lookupTimeout bounds a lookup shared by concurrent callers, which is not canceled with the context of any of them.
+	lookupTimeout = 10 * time.Second
+
+	projectIDLookupKey            = "project-id"
+	nativeSidecarSupportLookupKey = "native-sidecar-support"
+)
+
+// lookupCache coalesces concurrent lookups that share the same key and caches
+// successful results for a bounded TTL. It keeps admission latency low at high pod churn,
+// where every admission request would otherwise list all the cluster nodes or call the metadata server.
+// The zero value is ready to use.
+type lookupCache struct {
+	group   singleflight.Group
+	mux     sync.Mutex
+	entries map[string]lookupCacheEntry
+}
+
+type lookupCacheEntry struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+// get returns the cached value for key if it has not expired. Otherwise, it calls fn,
+// sharing a single in-flight call among all the concurrent callers, and caches the result for ttl.
+// Errors are never cached. When ttl is not positive, concurrent calls are still coalesced but results are not cached.
+// The shared call gets a context without the cancellation of the caller that started it, bounded by lookupTimeout,
+// so that one canceled admission request does not fail the others, and each caller stops waiting when its own ctx is done.
+func (c *lookupCache) get(ctx context.Context, key string, ttl time.Duration, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	c.mux.Lock()
+	if e, ok := c.entries[key]; ok && time.Now().Before(e.expiresAt) {
+		c.mux.Unlock()
+
+		return e.value, nil
+	}
+	c.mux.Unlock()
+
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		lookupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), lookupTimeout)
+		defer cancel()
+
+		v, err := fn(lookupCtx)
+		if err != nil || ttl <= 0 {
+			return v, err
+		}
+
+		c.mux.Lock()
+		defer c.mux.Unlock()
+		if c.entries == nil {
+			c.entries = map[string]lookupCacheEntry{}
+		}
+		c.entries[key] = lookupCacheEntry{value: v, expiresAt: time.Now().Add(ttl)}
+
+		return v, nil
+	})
+
+	select {
+	case r := <-ch:
+		return r.Val, r.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLookupCache(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		ttl           time.Duration
+		fnErr         error
+		calls         int
+		expectedCalls int32
+	}{
+		{
+			name:          "results are cached within ttl",
+			ttl:           time.Hour,
+			calls:         3,
+			expectedCalls: 1,
+		},
+		{
+			name:          "results are not cached when ttl is zero",
+			ttl:           0,
+			calls:         3,
+			expectedCalls: 3,
+		},
+		{
+			name:          "errors are not cached",
+			ttl:           time.Hour,
+			fnErr:         errors.New("lookup failed"),
+			calls:         3,
+			expectedCalls: 3,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var c lookupCache
+			var calls int32
+			fn := func(context.Context) (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+
+				return "value", tc.fnErr
+			}
+
+			for range tc.calls {
+				v, err := c.get(context.Background(), "key", tc.ttl, fn)
+				if !errors.Is(err, tc.fnErr) {
+					t.Errorf("got error %v, expected %v", err, tc.fnErr)
+				}
+				if err == nil && v != "value" {
+					t.Errorf("got value %v, expected %q", v, "value")
+				}
+			}
+
+			if calls != tc.expectedCalls {
+				t.Errorf("got %v lookup calls, expected %v", calls, tc.expectedCalls)
+			}
+		})
+	}
+}
+
+func TestLookupCacheCoalescesConcurrentCalls(t *testing.T) {
+	t.Parallel()
+
+	var c lookupCache
+	var calls int32
+	release := make(chan struct{})
+	fn := func(context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+
+		return true, nil
+	}
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.get(context.Background(), "key", 0, fn); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+
+	// Give the goroutines a chance to join the in-flight call before it returns.
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("got %v lookup calls, expected 1", calls)
+	}
+}
+
+func TestLookupCacheIgnoresCanceledCaller(t *testing.T) {
+	t.Parallel()
+
+	var c lookupCache
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		<-release
+
+		return true, ctx.Err()
+	}
+
+	// The first caller starts the shared call and is canceled while the call is in flight.
+	firstCtx, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := c.get(firstCtx, "key", 0, fn)
+		firstErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	followerErr := make(chan error, 1)
+	go func() {
+		_, err := c.get(context.Background(), "key", 0, fn)
+		followerErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v for the canceled caller, expected %v", err, context.Canceled)
+	}
+	close(release)
+	if err := <-followerErr; err != nil {
+		t.Errorf("got error %v for the follower, expected the shared call to succeed", err)
+	}
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+const (
+	eventReasonCertExpiring     = "WebhookCertificateExpiring"
+	eventReasonCABundleMismatch = "WebhookCABundleMismatch"
+)
+
+// CertMonitor reports the expiry of the serving certificate of the webhook and of the caBundle of its MutatingWebhookConfiguration,
+// and whether the caBundle verifies the serving certificate. When either is wrong, the API server cannot call the webhook,
+// and the Pods fail to be created, or are created without the sidecar container, with errors that do not point to the certificates.
+type CertMonitor struct {
+	certPath         string
+	client           kubernetes.Interface
+	configName       string
+	warningThreshold time.Duration
+	recorder         record.EventRecorder
+
+	servingCertExpiry prometheus.Gauge
+	caBundleExpiry    prometheus.Gauge
+	caBundleMismatch  prometheus.Gauge
+}
+
+// NewCertMonitor returns a monitor of the serving certificate in certPath and the caBundle of the MutatingWebhookConfiguration,
+// and registers its metrics. The monitor warns about certificates that expire within the warning threshold.
+func NewCertMonitor(certPath string, client kubernetes.Interface, configName string, warningThreshold time.Duration, recorder record.EventRecorder, registerer prometheus.Registerer) (*CertMonitor, error) {
+	m := &CertMonitor{
+		certPath:         certPath,
+		client:           client,
+		configName:       configName,
+		warningThreshold: warningThreshold,
+		recorder:         recorder,
+		servingCertExpiry: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "gke_gcsfuse_webhook_serving_cert_expiry_timestamp_seconds",
+			Help: "The notAfter time of the serving certificate of the webhook, in seconds since the epoch.",
+		}),
+		caBundleExpiry: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "gke_gcsfuse_webhook_ca_bundle_expiry_timestamp_seconds",
+			Help: "The earliest notAfter time of the certificates in the caBundle of the MutatingWebhookConfiguration, in seconds since the epoch.",
+		}),
+		caBundleMismatch: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "gke_gcsfuse_webhook_ca_bundle_mismatch",
+			Help: "1 if the caBundle of the MutatingWebhookConfiguration does not verify the serving certificate of the webhook, 0 otherwise.",
+		}),
+	}
+
+	for _, c := range []prometheus.Collector{m.servingCertExpiry, m.caBundleExpiry, m.caBundleMismatch} {
+		if err := registerer.Register(c); err != nil {
+			return nil, fmt.Errorf("failed to register the certificate metrics: %w", err)
+		}
+	}
+
+	return m, nil
+}
+
+// certWarning is a problem with the certificates, and the reason of its event.
+type certWarning struct {
+	reason  string
+	message string
+}
+
+// Check updates the metrics, and logs a warning and records a warning event on the MutatingWebhookConfiguration
+// for each problem with the certificates. The warning messages are returned.
+func (m *CertMonitor) Check(ctx context.Context, now time.Time) []string {
+	warnings := []certWarning{}
+	cert, err := readCertificate(m.certPath)
+	if err != nil {
+		klog.Warningf("Unable to check the serving certificate of the webhook: %v", err)
+	} else {
+		m.servingCertExpiry.Set(float64(cert.NotAfter.Unix()))
+		if message := m.expiryWarning("the serving certificate", cert, now); message != "" {
+			warnings = append(warnings, certWarning{reason: eventReasonCertExpiring, message: message})
+		}
+	}
+
+	var config *admissionregistrationv1.MutatingWebhookConfiguration
+	if c, err := m.client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, m.configName, metav1.GetOptions{}); err != nil {
+		klog.Warningf("Unable to check the caBundle of MutatingWebhookConfiguration %q: %v", m.configName, err)
+	} else {
+		config = c
+		warnings = append(warnings, m.checkCABundle(config, cert, now)...)
+	}
+
+	// The warnings are recorded on the MutatingWebhookConfiguration, because it is the object that
+	// the cluster administrator looks at when the API server fails to call the webhook.
+	messages := []string{}
+	for _, w := range warnings {
+		klog.Warningf("MutatingWebhookConfiguration %q: %s", m.configName, w.message)
+		if config != nil {
+			m.recorder.Event(config, corev1.EventTypeWarning, w.reason, w.message)
+		}
+		messages = append(messages, w.message)
+	}
+
+	return messages
+}
+
+// checkCABundle updates the caBundle metrics, and returns the warnings about the caBundle of the webhooks in the configuration.
+// The serving certificate is not verified if it is nil.
+func (m *CertMonitor) checkCABundle(config *admissionregistrationv1.MutatingWebhookConfiguration, cert *x509.Certificate, now time.Time) []certWarning {
+	warnings := []certWarning{}
+	var earliestExpiry *x509.Certificate
+	mismatch := false
+	for _, wh := range config.Webhooks {
+		// An empty caBundle makes the API server verify the serving certificate with the system trust roots.
+		if len(wh.ClientConfig.CABundle) == 0 {
+			continue
+		}
+
+		roots, err := parseCABundle(wh.ClientConfig.CABundle)
+		if err != nil {
+			warnings = append(warnings, certWarning{reason: eventReasonCABundleMismatch, message: fmt.Sprintf("the caBundle of webhook %q is invalid: %v", wh.Name, err)})
+			mismatch = true
+
+			continue
+		}
+		pool := x509.NewCertPool()
+		for _, root := range roots {
+			pool.AddCert(root)
+			if earliestExpiry == nil || root.NotAfter.Before(earliestExpiry.NotAfter) {
+				earliestExpiry = root
+			}
+		}
+
+		if cert == nil {
+			continue
+		}
+		if err := verifyServingCert(cert, pool, now); err != nil {
+			warnings = append(warnings, certWarning{reason: eventReasonCABundleMismatch, message: fmt.Sprintf("the caBundle of webhook %q does not verify the serving certificate: %v", wh.Name, err)})
+			mismatch = true
+		}
+	}
+
+	if earliestExpiry != nil {
+		m.caBundleExpiry.Set(float64(earliestExpiry.NotAfter.Unix()))
+		if message := m.expiryWarning("the caBundle certificate", earliestExpiry, now); message != "" {
+			warnings = append(warnings, certWarning{reason: eventReasonCertExpiring, message: message})
+		}
+	}
+	if mismatch {
+		m.caBundleMismatch.Set(1)
+	} else {
+		m.caBundleMismatch.Set(0)
+	}
+
+	return warnings
+}
+
+// expiryWarning returns a warning if the certificate expired or expires within the warning threshold, or an empty string.
+func (m *CertMonitor) expiryWarning(name string, cert *x509.Certificate, now time.Time) string {
+	if now.After(cert.NotAfter) {
+		return fmt.Sprintf("%s %q expired at %v", name, cert.Subject.CommonName, cert.NotAfter)
+	}
+	if cert.NotAfter.Sub(now) < m.warningThreshold {
+		return fmt.Sprintf("%s %q expires at %v, in less than %v", name, cert.Subject.CommonName, cert.NotAfter, m.warningThreshold)
+	}
+
+	return ""
+}
+
+// parseCABundle returns the PEM certificates of a caBundle.
+func parseCABundle(data []byte) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM certificate found")
+	}
+
+	return certs, nil
+}
+
+// verifyServingCert checks that the serving certificate chains to the roots. Expired certificates are reported separately,
+// so the chain is verified at a time when the serving certificate is valid.
+func verifyServingCert(cert *x509.Certificate, roots *x509.CertPool, now time.Time) error {
+	verifyTime := now
+	if verifyTime.After(cert.NotAfter) {
+		verifyTime = cert.NotAfter
+	}
+	_, err := cert.Verify(x509.VerifyOptions{Roots: roots, CurrentTime: verifyTime, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired {
+		return nil
+	}
+
+	return err
+}
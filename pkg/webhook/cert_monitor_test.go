@@ -0,0 +1,142 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestCertMonitorCheck(t *testing.T) {
+	t.Parallel()
+
+	notBefore := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := notBefore.Add(365 * 24 * time.Hour)
+	certPath := writeTestCert(t, notBefore, notAfter)
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		t.Fatalf("failed to read certificate: %v", err)
+	}
+	otherCertPEM, err := os.ReadFile(writeTestCert(t, notBefore, notAfter))
+	if err != nil {
+		t.Fatalf("failed to read certificate: %v", err)
+	}
+
+	testCases := []struct {
+		name             string
+		caBundle         []byte
+		now              time.Time
+		expectedReasons  []string
+		expectedMismatch float64
+	}{
+		{
+			name:     "valid certificate and caBundle",
+			caBundle: certPEM,
+			now:      notBefore.Add(time.Hour),
+		},
+		{
+			name:            "certificate and caBundle expire soon",
+			caBundle:        certPEM,
+			now:             notAfter.Add(-24 * time.Hour),
+			expectedReasons: []string{eventReasonCertExpiring, eventReasonCertExpiring},
+		},
+		{
+			name:            "expired certificate is not a mismatch",
+			caBundle:        certPEM,
+			now:             notAfter.Add(time.Hour),
+			expectedReasons: []string{eventReasonCertExpiring, eventReasonCertExpiring},
+		},
+		{
+			name:             "caBundle of another certificate",
+			caBundle:         otherCertPEM,
+			now:              notBefore.Add(time.Hour),
+			expectedReasons:  []string{eventReasonCABundleMismatch},
+			expectedMismatch: 1,
+		},
+		{
+			name:             "invalid caBundle",
+			caBundle:         []byte("not a certificate"),
+			now:              notBefore.Add(time.Hour),
+			expectedReasons:  []string{eventReasonCABundleMismatch},
+			expectedMismatch: 1,
+		},
+		{
+			name: "empty caBundle",
+			now:  notBefore.Add(time.Hour),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			client := fake.NewSimpleClientset(&admissionregistrationv1.MutatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: DefaultMutatingWebhookConfigurationName},
+				Webhooks: []admissionregistrationv1.MutatingWebhook{
+					{Name: DefaultMutatingWebhookConfigurationName, ClientConfig: admissionregistrationv1.WebhookClientConfig{CABundle: tc.caBundle}},
+				},
+			})
+			recorder := record.NewFakeRecorder(10)
+			m, err := NewCertMonitor(certPath, client, DefaultMutatingWebhookConfigurationName, 30*24*time.Hour, recorder, prometheus.NewRegistry())
+			if err != nil {
+				t.Fatalf("failed to create the certificate monitor: %v", err)
+			}
+
+			warnings := m.Check(context.Background(), tc.now)
+			if len(warnings) != len(tc.expectedReasons) {
+				t.Errorf("got warnings %v, expected %d warnings", warnings, len(tc.expectedReasons))
+			}
+			close(recorder.Events)
+			reasons := []string{}
+			for event := range recorder.Events {
+				reasons = append(reasons, strings.Fields(event)[1])
+			}
+			if strings.Join(reasons, ",") != strings.Join(tc.expectedReasons, ",") {
+				t.Errorf("got event reasons %v, expected %v", reasons, tc.expectedReasons)
+			}
+
+			if got := gaugeValue(t, m.servingCertExpiry); got != float64(notAfter.Unix()) {
+				t.Errorf("got serving certificate expiry %v, expected %v", got, notAfter.Unix())
+			}
+			if got := gaugeValue(t, m.caBundleMismatch); got != tc.expectedMismatch {
+				t.Errorf("got caBundle mismatch %v, expected %v", got, tc.expectedMismatch)
+			}
+		})
+	}
+}
+
+func gaugeValue(t *testing.T, g prometheus.Gauge) float64 {
+	t.Helper()
+
+	m := &dto.Metric{}
+	if err := g.Write(m); err != nil {
+		t.Fatalf("failed to write metric: %v", err)
+	}
+
+	return m.GetGauge().GetValue()
+}
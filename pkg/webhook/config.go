@@ -27,11 +27,18 @@ import (
 	"k8s.io/klog/v2"
 )
 
+// imagePullPolicyKey is the annotation key, after the sidecar prefix, that overrides the sidecar container image pull policy
+// on Pods and namespaces, for example gke-gcsfuse/image-pull-policy.
+const imagePullPolicyKey = "image-pull-policy"
+
 type Config struct {
 	ShouldInjectSAVolume  bool   `json:"-"`
 	PodHostNetworkSetting bool   `json:"-"`
 	ContainerImage        string `json:"-"`
-	ImagePullPolicy       string `json:"-"`
+	//nolint:tagliatelle
+	ImagePullPolicy string `json:"image-pull-policy,omitempty"`
+	// LoggingFormat is passed to the sidecar container with the --logging-format flag if set.
+	LoggingFormat string `json:"-"`
 	//nolint:tagliatelle
 	CPURequest resource.Quantity `json:"cpu-request,omitempty"`
 	//nolint:tagliatelle
@@ -44,6 +51,8 @@ type Config struct {
 	EphemeralStorageRequest resource.Quantity `json:"ephemeral-storage-request,omitempty"`
 	//nolint:tagliatelle
 	EphemeralStorageLimit resource.Quantity `json:"ephemeral-storage-limit,omitempty"`
+	//nolint:tagliatelle
+	CPUBoostRequest resource.Quantity `json:"cpu-boost-request,omitempty"`
 }
 
 func LoadConfig(containerImage, imagePullPolicy, cpuRequest, cpuLimit, memoryRequest, memoryLimit, ephemeralStorageRequest, ephemeralStorageLimit string) *Config {
@@ -125,11 +134,24 @@ func (si *SidecarInjector) prepareConfig(prefix string, pod corev1.Pod) (*Config
 		return nil, err
 	}
 
+	// The namespace annotation overrides the default image pull policy, and the Pod annotation overrides both.
+	if policy := si.namespaceImagePullPolicy(prefix, pod.Namespace); policy != "" {
+		namespaceConfig := *defaultConfig
+		namespaceConfig.ImagePullPolicy = policy
+		defaultConfig = &namespaceConfig
+	}
+
 	config, err := getConfigFromAnnotation(*defaultConfig, prefix, pod.Annotations)
 	if err != nil {
 		return nil, err
 	}
 
+	switch corev1.PullPolicy(config.ImagePullPolicy) {
+	case corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever:
+	default:
+		return nil, fmt.Errorf("invalid sidecar container image pull policy %q, must be one of %q, %q or %q", config.ImagePullPolicy, corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever)
+	}
+
 	populateResource(&config.CPURequest, &config.CPULimit, defaultConfig.CPURequest, defaultConfig.CPULimit)
 	populateResource(&config.MemoryRequest, &config.MemoryLimit, defaultConfig.MemoryRequest, defaultConfig.MemoryLimit)
 	populateResource(&config.EphemeralStorageRequest, &config.EphemeralStorageLimit, defaultConfig.EphemeralStorageRequest, defaultConfig.EphemeralStorageLimit)
@@ -164,7 +186,27 @@ func getConfigFromAnnotation(defaultConfig Config, prefix string, annotations ma
 	return config, nil
 }
 
+// namespaceImagePullPolicy returns the image pull policy set by the annotation of the namespace, or an empty string if it is not set.
+// The namespace is looked up from the informer cache, so the default image pull policy is used if the namespace is not cached yet.
+func (si *SidecarInjector) namespaceImagePullPolicy(prefix, namespace string) string {
+	if si.NamespaceLister == nil || namespace == "" {
+		return ""
+	}
+
+	ns, err := si.NamespaceLister.Get(namespace)
+	if err != nil {
+		klog.Warningf("failed to get namespace %q, using the default sidecar container image pull policy: %v", namespace, err)
+
+		return ""
+	}
+
+	return ns.Annotations[prefix+imagePullPolicyKey]
+}
+
 func (si *SidecarInjector) getDefaultConfig(prefix string) (*Config, error) {
+	si.configMux.RLock()
+	defer si.configMux.RUnlock()
+
 	switch prefix {
 	case sidecarPrefixMap[GcsFuseSidecarName]:
 		return si.Config, nil
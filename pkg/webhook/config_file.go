@@ -0,0 +1,129 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// configFileReloadInterval is how often the webhook checks the config file for changes.
+// ConfigMap volumes are updated by kubelet within about a minute, so a short interval does not delay the reloads.
+const configFileReloadInterval = 10 * time.Second
+
+// configFile is the content of the webhook config file, which overrides the default sidecar container configs
+// set by the webhook flags, and sets the default annotations of the Pods. Fields that are not set keep the flag values.
+type configFile struct {
+	//nolint:tagliatelle
+	Sidecar *Config `json:"sidecar,omitempty"`
+	//nolint:tagliatelle
+	MetadataPrefetchSidecar *Config `json:"metadata-prefetch-sidecar,omitempty"`
+	//nolint:tagliatelle
+	DefaultAnnotations []DefaultAnnotations `json:"default-annotations,omitempty"`
+}
+
+// ParseConfigFile applies the YAML or JSON content of the webhook config file to copies of the default configs
+// of the gcsfuse sidecar container and the metadata prefetch sidecar container, and returns the default annotations of the Pods.
+func ParseConfigFile(data []byte, config, metadataPrefetchConfig *Config) (*Config, *Config, []DefaultAnnotations, error) {
+	sidecarConfig, metadataConfig := *config, *metadataPrefetchConfig
+	file := &configFile{Sidecar: &sidecarConfig, MetadataPrefetchSidecar: &metadataConfig}
+	if err := yaml.UnmarshalStrict(data, file); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse the webhook config file: %w", err)
+	}
+
+	for _, c := range []*Config{&sidecarConfig, &metadataConfig} {
+		switch corev1.PullPolicy(c.ImagePullPolicy) {
+		case corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever:
+		default:
+			return nil, nil, nil, fmt.Errorf("invalid sidecar container image pull policy %q, must be one of %q, %q or %q", c.ImagePullPolicy, corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever)
+		}
+	}
+
+	for i := range file.DefaultAnnotations {
+		if err := file.DefaultAnnotations[i].validate(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	return &sidecarConfig, &metadataConfig, file.DefaultAnnotations, nil
+}
+
+// LoadConfigFile reads the webhook config file and applies it to the default configs.
+// A missing file does not override the default configs, so the ConfigMap of the file is optional.
+func LoadConfigFile(path string, config, metadataPrefetchConfig *Config) (*Config, *Config, []DefaultAnnotations, error) {
+	data, err := readConfigFile(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return ParseConfigFile(data, config, metadataPrefetchConfig)
+}
+
+func readConfigFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	return data, err
+}
+
+// SetDefaultConfigs replaces the default sidecar container configs and the default annotations while the webhook serves admission requests.
+func (si *SidecarInjector) SetDefaultConfigs(config, metadataPrefetchConfig *Config, defaultAnnotations []DefaultAnnotations) {
+	si.configMux.Lock()
+	defer si.configMux.Unlock()
+	si.Config, si.MetadataPrefetchConfig, si.defaultAnnotations = config, metadataPrefetchConfig, defaultAnnotations
+}
+
+// WatchConfigFile reloads the webhook config file until ctx is canceled, and applies each change to the default
+// configs of the flags. The webhook replicas mount the same ConfigMap, so they all serve the new defaults shortly
+// after the ConfigMap is updated, without a restart. An invalid change is logged, and the previous configs are kept.
+func (si *SidecarInjector) WatchConfigFile(ctx context.Context, path string, config, metadataPrefetchConfig *Config) {
+	var last []byte
+	loaded := false
+	wait.UntilWithContext(ctx, func(context.Context) {
+		data, err := readConfigFile(path)
+		if err != nil {
+			klog.Errorf("Failed to read the webhook config file %q: %v", path, err)
+
+			return
+		}
+		if loaded && bytes.Equal(data, last) {
+			return
+		}
+		last, loaded = data, true
+
+		sidecarConfig, metadataConfig, defaultAnnotations, err := ParseConfigFile(data, config, metadataPrefetchConfig)
+		if err != nil {
+			klog.Errorf("Failed to reload the webhook config file %q, keeping the previous config: %v", path, err)
+
+			return
+		}
+		si.SetDefaultConfigs(sidecarConfig, metadataConfig, defaultAnnotations)
+		klog.Infof("Loaded the webhook config file %q", path)
+	}, configFileReloadInterval)
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseConfigFile(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name                     string
+		data                     string
+		expectErr                bool
+		expectPullPolicy         string
+		expectCPURequest         string
+		expectMemoryLimit        string
+		expectMetadataCPURequest string
+		expectMetadataPullPolicy string
+	}{
+		{
+			name:                     "empty file keeps the flag values",
+			expectPullPolicy:         "Always",
+			expectCPURequest:         "250m",
+			expectMemoryLimit:        "256Mi",
+			expectMetadataCPURequest: "10m",
+			expectMetadataPullPolicy: "Always",
+		},
+		{
+			name: "overrides only the set fields",
+			data: `sidecar:
+  cpu-request: 500m
+  image-pull-policy: IfNotPresent
+metadata-prefetch-sidecar:
+  cpu-request: 20m
+`,
+			expectPullPolicy:         "IfNotPresent",
+			expectCPURequest:         "500m",
+			expectMemoryLimit:        "256Mi",
+			expectMetadataCPURequest: "20m",
+			expectMetadataPullPolicy: "Always",
+		},
+		{
+			name:      "unknown field",
+			data:      "sidecar:\n  cpu-requests: 500m\n",
+			expectErr: true,
+		},
+		{
+			name:      "invalid quantity",
+			data:      "sidecar:\n  memory-limit: lots\n",
+			expectErr: true,
+		},
+		{
+			name:      "invalid image pull policy",
+			data:      "metadata-prefetch-sidecar:\n  image-pull-policy: Sometimes\n",
+			expectErr: true,
+		},
+		{
+			name: "default annotations",
+			data: `default-annotations:
+- namespaces: [ml]
+  annotations:
+    gke-gcsfuse/memory-limit: 1Gi
+`,
+			expectPullPolicy:         "Always",
+			expectCPURequest:         "250m",
+			expectMemoryLimit:        "256Mi",
+			expectMetadataCPURequest: "10m",
+			expectMetadataPullPolicy: "Always",
+		},
+		{
+			name:      "invalid default annotations",
+			data:      "default-annotations:\n- annotations:\n    gke-gcsfuse/volumes: \"true\"\n",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			base, metadataBase := FakeConfig(), FakePrefetchConfig()
+
+			config, metadataConfig, _, err := ParseConfigFile([]byte(tc.data), base, metadataBase)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectErr)
+			}
+			if tc.expectErr {
+				return
+			}
+
+			if config.ImagePullPolicy != tc.expectPullPolicy {
+				t.Errorf("got image pull policy %q, expected %q", config.ImagePullPolicy, tc.expectPullPolicy)
+			}
+			if got := config.CPURequest.String(); got != tc.expectCPURequest {
+				t.Errorf("got CPU request %q, expected %q", got, tc.expectCPURequest)
+			}
+			if got := config.MemoryLimit.String(); got != tc.expectMemoryLimit {
+				t.Errorf("got memory limit %q, expected %q", got, tc.expectMemoryLimit)
+			}
+			if config.ContainerImage != base.ContainerImage {
+				t.Errorf("got image %q, expected the flag image %q", config.ContainerImage, base.ContainerImage)
+			}
+			if got := metadataConfig.CPURequest.String(); got != tc.expectMetadataCPURequest {
+				t.Errorf("got metadata prefetch CPU request %q, expected %q", got, tc.expectMetadataCPURequest)
+			}
+			if metadataConfig.ImagePullPolicy != tc.expectMetadataPullPolicy {
+				t.Errorf("got metadata prefetch image pull policy %q, expected %q", metadataConfig.ImagePullPolicy, tc.expectMetadataPullPolicy)
+			}
+			if got := base.CPURequest.String(); got != "250m" {
+				t.Errorf("the flag config was changed to CPU request %q", got)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	base, metadataBase := FakeConfig(), FakePrefetchConfig()
+
+	config, _, _, err := LoadConfigFile(filepath.Join(dir, "missing.yaml"), base, metadataBase)
+	if err != nil {
+		t.Fatalf("failed to load a missing config file: %v", err)
+	}
+	if config.ImagePullPolicy != base.ImagePullPolicy {
+		t.Errorf("got image pull policy %q for a missing config file, expected the flag value %q", config.ImagePullPolicy, base.ImagePullPolicy)
+	}
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte("sidecar:\n  image-pull-policy: Never\n"), 0o600); err != nil {
+		t.Fatalf("failed to write the config file: %v", err)
+	}
+	config, _, _, err = LoadConfigFile(path, base, metadataBase)
+	if err != nil {
+		t.Fatalf("failed to load the config file: %v", err)
+	}
+	if config.ImagePullPolicy != string(corev1.PullNever) {
+		t.Errorf("got image pull policy %q, expected %q", config.ImagePullPolicy, corev1.PullNever)
+	}
+}
+
+func TestSetDefaultConfigs(t *testing.T) {
+	t.Parallel()
+
+	si := &SidecarInjector{Config: FakeConfig(), MetadataPrefetchConfig: FakePrefetchConfig()}
+	config, metadataConfig, defaultAnnotations, err := ParseConfigFile([]byte("sidecar:\n  cpu-request: 1\n"), si.Config, si.MetadataPrefetchConfig)
+	if err != nil {
+		t.Fatalf("failed to parse the config file: %v", err)
+	}
+	si.SetDefaultConfigs(config, metadataConfig, defaultAnnotations)
+
+	got, err := si.getDefaultConfig(sidecarPrefixMap[GcsFuseSidecarName])
+	if err != nil {
+		t.Fatalf("failed to get the default config: %v", err)
+	}
+	if got.CPURequest.String() != "1" {
+		t.Errorf("got CPU request %q, expected the reloaded value %q", got.CPURequest.String(), "1")
+	}
+}
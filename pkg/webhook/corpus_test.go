@@ -0,0 +1,182 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/google/go-cmp/cmp"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// The corpus test feeds the Pod manifests in testdata/corpus to the webhook, and compares the mutated Pods
+// with the golden files next to them. The manifests are shaped like the Pods of common workloads and other
+// mutating webhooks, so that changes of the injection output are caught in review.
+// To add a manifest, or to accept an intended change of the injection output, run:
+//
+//	go test ./pkg/webhook -run TestWebhookCorpus -update-corpus
+var updateCorpus = flag.Bool("update-corpus", false, "update the golden files of the webhook corpus test")
+
+const corpusDir = "testdata/corpus"
+
+// corpusResult is the injection output of a corpus Pod manifest.
+type corpusResult struct {
+	Allowed  bool        `json:"allowed"`
+	Message  string      `json:"message,omitempty"`
+	Warnings []string    `json:"warnings,omitempty"`
+	Pod      *corev1.Pod `json:"pod,omitempty"`
+}
+
+func TestWebhookCorpus(t *testing.T) {
+	t.Parallel()
+
+	manifests, err := filepath.Glob(filepath.Join(corpusDir, "*.yaml"))
+	if err != nil {
+		t.Fatalf("failed to list the corpus: %v", err)
+	}
+	if len(manifests) == 0 {
+		t.Fatalf("no Pod manifests found in %q", corpusDir)
+	}
+
+	nodeSets := map[string][]corev1.Node{
+		"native-sidecar-nodes":  nativeSupportNodes(),
+		"regular-sidecar-nodes": regularSidecarSupportNodes(),
+	}
+
+	for _, manifest := range manifests {
+		name := strings.TrimSuffix(filepath.Base(manifest), ".yaml")
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			raw := readCorpusPod(t, manifest)
+			got := map[string]corpusResult{}
+			for nodeSet, nodes := range nodeSets {
+				got[nodeSet] = injectCorpusPod(t, raw, nodes)
+			}
+
+			gotJSON, err := json.MarshalIndent(got, "", "  ")
+			if err != nil {
+				t.Fatalf("failed to marshal the injection output: %v", err)
+			}
+			gotJSON = append(gotJSON, '\n')
+
+			goldenFile := filepath.Join(corpusDir, name+".golden.json")
+			if *updateCorpus {
+				if err := os.WriteFile(goldenFile, gotJSON, 0o600); err != nil {
+					t.Fatalf("failed to update golden file %q: %v", goldenFile, err)
+				}
+
+				return
+			}
+
+			wantJSON, err := os.ReadFile(goldenFile)
+			if err != nil {
+				t.Fatalf("failed to read golden file %q, run the test with -update-corpus to create it: %v", goldenFile, err)
+			}
+			if diff := cmp.Diff(string(wantJSON), string(gotJSON)); diff != "" {
+				t.Errorf("injection output of %q differs from %q (-want, +got):\n%s", manifest, goldenFile, diff)
+			}
+		})
+	}
+}
+
+// readCorpusPod returns the JSON encoding of the Pod manifest, as it is sent to the webhook.
+func readCorpusPod(t *testing.T, manifest string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(manifest)
+	if err != nil {
+		t.Fatalf("failed to read %q: %v", manifest, err)
+	}
+
+	pod := &corev1.Pod{}
+	if err := yaml.NewYAMLToJSONDecoder(bytes.NewReader(data)).Decode(pod); err != nil {
+		t.Fatalf("failed to decode %q: %v", manifest, err)
+	}
+
+	return serialize(t, pod)
+}
+
+// injectCorpusPod runs the webhook on the Pod on a cluster with the nodes, and applies the returned patch to the Pod.
+func injectCorpusPod(t *testing.T, raw []byte, nodes []corev1.Node) corpusResult {
+	t.Helper()
+	fakeClient := fake.NewSimpleClientset()
+	for _, node := range nodes {
+		n := node
+		if _, err := fakeClient.CoreV1().Nodes().Create(context.Background(), &n, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("failed to create node: %v", err)
+		}
+	}
+
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(fakeClient, time.Second*1, informers.WithNamespace(metav1.NamespaceAll))
+	si := SidecarInjector{
+		Config:                 FakeConfig(),
+		MetadataPrefetchConfig: FakePrefetchConfig(),
+		Decoder:                admission.NewDecoder(runtime.NewScheme()),
+		NodeLister:             informerFactory.Core().V1().Nodes().Lister(),
+	}
+
+	stopCh := make(<-chan struct{})
+	informerFactory.Start(stopCh)
+	informerFactory.WaitForCacheSync(stopCh)
+
+	resp := si.Handle(context.Background(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+
+	result := corpusResult{Allowed: resp.Allowed, Warnings: resp.Warnings}
+	if resp.Result != nil {
+		result.Message = resp.Result.Message
+	}
+	if len(resp.Patches) == 0 {
+		return result
+	}
+
+	patch, err := jsonpatch.DecodePatch(serialize(t, resp.Patches))
+	if err != nil {
+		t.Fatalf("failed to decode the patch: %v", err)
+	}
+	patched, err := patch.Apply(raw)
+	if err != nil {
+		t.Fatalf("failed to apply the patch: %v", err)
+	}
+	result.Pod = &corev1.Pod{}
+	if err := json.Unmarshal(patched, result.Pod); err != nil {
+		t.Fatalf("failed to unmarshal the patched Pod: %v", err)
+	}
+
+	return result
+}
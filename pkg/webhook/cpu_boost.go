@@ -0,0 +1,117 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	cpuBoostRequestAnnotation = "gke-gcsfuse/cpu-boost-request"
+	// CPUBoostDurationAnnotation specifies how long the sidecar container keeps the boosted CPU after it starts.
+	CPUBoostDurationAnnotation = "gke-gcsfuse/cpu-boost-duration"
+	// CPUBoostRestoreRequestAnnotation and CPUBoostRestoreLimitAnnotation record the sidecar container CPU request and limit
+	// to restore once the CPU boost window ends. They are set by the webhook and should not be set by users.
+	CPUBoostRestoreRequestAnnotation = "gke-gcsfuse/cpu-boost-restore-request"
+	CPUBoostRestoreLimitAnnotation   = "gke-gcsfuse/cpu-boost-restore-limit"
+
+	DefaultCPUBoostDuration = 2 * time.Minute
+)
+
+// CPUBoost describes the sidecar container CPU resources to restore using in-place Pod resize once the CPU boost window ends.
+type CPUBoost struct {
+	Request resource.Quantity
+	// Limit is nil when the CPU limit was not raised by the boost.
+	Limit    *resource.Quantity
+	Duration time.Duration
+}
+
+// applyCPUBoost raises the CPU request, and the CPU limit if it is lower, of the sidecar container to the boost request,
+// and records the original values on the Pod so that the CSI driver can scale the sidecar container down later.
+func applyCPUBoost(pod *corev1.Pod, container *corev1.Container, config *Config) error {
+	if config.CPUBoostRequest.IsZero() {
+		return nil
+	}
+
+	if d, ok := pod.Annotations[CPUBoostDurationAnnotation]; ok {
+		if duration, err := time.ParseDuration(d); err != nil || duration <= 0 {
+			return fmt.Errorf("the value of %q must be a positive duration, got %q", CPUBoostDurationAnnotation, d)
+		}
+	}
+
+	request := container.Resources.Requests[corev1.ResourceCPU]
+	if config.CPUBoostRequest.Cmp(request) <= 0 {
+		return fmt.Errorf("the value of %q must be greater than the sidecar container CPU request %q, got %q", cpuBoostRequestAnnotation, request.String(), config.CPUBoostRequest.String())
+	}
+
+	if container.Resources.Requests == nil {
+		container.Resources.Requests = corev1.ResourceList{}
+	}
+	container.Resources.Requests[corev1.ResourceCPU] = config.CPUBoostRequest
+	pod.Annotations[CPUBoostRestoreRequestAnnotation] = request.String()
+
+	if limit, ok := container.Resources.Limits[corev1.ResourceCPU]; ok && config.CPUBoostRequest.Cmp(limit) > 0 {
+		container.Resources.Limits[corev1.ResourceCPU] = config.CPUBoostRequest
+		pod.Annotations[CPUBoostRestoreLimitAnnotation] = limit.String()
+	}
+
+	container.ResizePolicy = []corev1.ContainerResizePolicy{
+		{
+			ResourceName:  corev1.ResourceCPU,
+			RestartPolicy: corev1.NotRequired,
+		},
+	}
+
+	return nil
+}
+
+// ParseCPUBoost returns the CPU boost recorded on the Pod by the webhook.
+func ParseCPUBoost(pod *corev1.Pod) (*CPUBoost, bool, error) {
+	r, ok := pod.Annotations[CPUBoostRestoreRequestAnnotation]
+	if !ok {
+		return nil, false, nil
+	}
+
+	request, err := resource.ParseQuantity(r)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse %q: %w", CPUBoostRestoreRequestAnnotation, err)
+	}
+	boost := &CPUBoost{Request: request, Duration: DefaultCPUBoostDuration}
+
+	if l, ok := pod.Annotations[CPUBoostRestoreLimitAnnotation]; ok {
+		limit, err := resource.ParseQuantity(l)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to parse %q: %w", CPUBoostRestoreLimitAnnotation, err)
+		}
+		boost.Limit = &limit
+	}
+
+	if d, ok := pod.Annotations[CPUBoostDurationAnnotation]; ok {
+		duration, err := time.ParseDuration(d)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to parse %q: %w", CPUBoostDurationAnnotation, err)
+		}
+		boost.Duration = duration
+	}
+
+	return boost, true, nil
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestApplyCPUBoost(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name                string
+		annotations         map[string]string
+		cpuLimit            string
+		expectedRequest     string
+		expectedLimit       string
+		expectedAnnotations map[string]string
+		expectErr           bool
+	}{
+		{
+			name:            "no CPU boost",
+			annotations:     map[string]string{},
+			cpuLimit:        "250m",
+			expectedRequest: "250m",
+			expectedLimit:   "250m",
+		},
+		{
+			name:            "CPU boost raises request and limit",
+			annotations:     map[string]string{cpuBoostRequestAnnotation: "2"},
+			cpuLimit:        "250m",
+			expectedRequest: "2",
+			expectedLimit:   "2",
+			expectedAnnotations: map[string]string{
+				CPUBoostRestoreRequestAnnotation: "250m",
+				CPUBoostRestoreLimitAnnotation:   "250m",
+			},
+		},
+		{
+			name:            "CPU boost keeps a higher limit",
+			annotations:     map[string]string{cpuBoostRequestAnnotation: "2", CPUBoostDurationAnnotation: "5m"},
+			cpuLimit:        "4",
+			expectedRequest: "2",
+			expectedLimit:   "4",
+			expectedAnnotations: map[string]string{
+				CPUBoostRestoreRequestAnnotation: "250m",
+			},
+		},
+		{
+			name:        "CPU boost lower than request",
+			annotations: map[string]string{cpuBoostRequestAnnotation: "100m"},
+			cpuLimit:    "250m",
+			expectErr:   true,
+		},
+		{
+			name:        "invalid CPU boost duration",
+			annotations: map[string]string{cpuBoostRequestAnnotation: "2", CPUBoostDurationAnnotation: "soon"},
+			cpuLimit:    "250m",
+			expectErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+			config := FakeConfig()
+			config.CPULimit = resource.MustParse(tc.cpuLimit)
+			if b, ok := tc.annotations[cpuBoostRequestAnnotation]; ok {
+				config.CPUBoostRequest = resource.MustParse(b)
+			}
+			container := GetSidecarContainerSpec(config)
+
+			err := applyCPUBoost(pod, &container, config)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectErr)
+			}
+			if tc.expectErr {
+				return
+			}
+
+			if got := container.Resources.Requests[corev1.ResourceCPU]; got.String() != tc.expectedRequest {
+				t.Errorf("got CPU request %q, expected %q", got.String(), tc.expectedRequest)
+			}
+			if got := container.Resources.Limits[corev1.ResourceCPU]; got.String() != tc.expectedLimit {
+				t.Errorf("got CPU limit %q, expected %q", got.String(), tc.expectedLimit)
+			}
+			for _, key := range []string{CPUBoostRestoreRequestAnnotation, CPUBoostRestoreLimitAnnotation} {
+				if pod.Annotations[key] != tc.expectedAnnotations[key] {
+					t.Errorf("got annotation %q value %q, expected %q", key, pod.Annotations[key], tc.expectedAnnotations[key])
+				}
+			}
+		})
+	}
+}
+
+func TestParseCPUBoost(t *testing.T) {
+	t.Parallel()
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		CPUBoostRestoreRequestAnnotation: "250m",
+		CPUBoostDurationAnnotation:       "30s",
+	}}}
+
+	boost, ok, err := ParseCPUBoost(pod)
+	if err != nil || !ok {
+		t.Fatalf("got ok %v, error %v, expected a CPU boost", ok, err)
+	}
+	if boost.Request.String() != "250m" {
+		t.Errorf("got request %q, expected %q", boost.Request.String(), "250m")
+	}
+	if boost.Limit != nil {
+		t.Errorf("got limit %q, expected nil", boost.Limit.String())
+	}
+	if boost.Duration != 30*time.Second {
+		t.Errorf("got duration %v, expected %v", boost.Duration, 30*time.Second)
+	}
+
+	if _, ok, err := ParseCPUBoost(&corev1.Pod{}); ok || err != nil {
+		t.Errorf("got ok %v, error %v, expected no CPU boost", ok, err)
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// gcsFuseAnnotationPrefix is the prefix of the Pod annotations that configure the sidecar containers.
+const gcsFuseAnnotationPrefix = "gke-gcsfuse/"
+
+// DefaultAnnotations are gke-gcsfuse annotations that the webhook applies to the Pods of the selected namespaces, so that
+// operators can set a policy, such as the sidecar container memory limit, without changing every workload manifest.
+// A Pod annotation overrides the default. A rule without namespaces and namespace selector applies to all the namespaces.
+type DefaultAnnotations struct {
+	// Namespaces are the names of the namespaces that the rule applies to.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NamespaceSelector selects the namespaces that the rule applies to by their labels.
+	//nolint:tagliatelle
+	NamespaceSelector *metav1.LabelSelector `json:"namespace-selector,omitempty"`
+	Annotations       map[string]string     `json:"annotations"`
+
+	selector labels.Selector
+}
+
+// validate checks the annotations of the rule and parses its namespace selector.
+func (d *DefaultAnnotations) validate() error {
+	if len(d.Annotations) == 0 {
+		return errors.New("default annotations must set at least one annotation")
+	}
+	for key := range d.Annotations {
+		if !strings.HasPrefix(key, gcsFuseAnnotationPrefix) {
+			return fmt.Errorf("default annotation %q must have the %q prefix", key, gcsFuseAnnotationPrefix)
+		}
+		// The webhook only mutates the Pods that opt in to the sidecar container injection, and it sets the CPU boost restore annotations itself.
+		if slices.Contains([]string{GcsFuseVolumeEnableAnnotation, CPUBoostRestoreRequestAnnotation, CPUBoostRestoreLimitAnnotation}, key) {
+			return fmt.Errorf("annotation %q cannot be defaulted", key)
+		}
+	}
+
+	d.selector = labels.Everything()
+	if d.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(d.NamespaceSelector)
+		if err != nil {
+			return fmt.Errorf("invalid default annotations namespace selector: %w", err)
+		}
+		d.selector = selector
+	}
+
+	return nil
+}
+
+// matches returns whether the rule applies to the namespace. The namespace labels are only looked up for rules with a namespace selector.
+func (d *DefaultAnnotations) matches(namespace string, namespaceLabels func() (labels.Set, bool)) bool {
+	if len(d.Namespaces) == 0 && d.NamespaceSelector == nil {
+		return true
+	}
+	if slices.Contains(d.Namespaces, namespace) {
+		return true
+	}
+	if d.NamespaceSelector == nil {
+		return false
+	}
+	nsLabels, ok := namespaceLabels()
+
+	return ok && d.selector.Matches(nsLabels)
+}
+
+// applyDefaultAnnotations sets the default annotations of the rules that match the namespace of the Pod, unless the Pod or the namespace
+// sets them. When several rules set the same annotation, the first rule wins. It returns the keys of the applied annotations.
+func (si *SidecarInjector) applyDefaultAnnotations(pod *corev1.Pod, namespace string) []string {
+	si.configMux.RLock()
+	rules := si.defaultAnnotations
+	si.configMux.RUnlock()
+
+	var nsLabels labels.Set
+	looked, found := false, false
+	namespaceLabels := func() (labels.Set, bool) {
+		if !looked {
+			looked = true
+			nsLabels, found = si.namespaceLabels(namespace)
+		}
+
+		return nsLabels, found
+	}
+
+	applied := []string{}
+	for i := range rules {
+		if !rules[i].matches(namespace, namespaceLabels) {
+			continue
+		}
+		for key, value := range rules[i].Annotations {
+			if _, ok := pod.Annotations[key]; ok || si.namespaceOverrides(key, namespace) {
+				continue
+			}
+			if pod.Annotations == nil {
+				pod.Annotations = map[string]string{}
+			}
+			pod.Annotations[key] = value
+			applied = append(applied, key)
+		}
+	}
+	slices.Sort(applied)
+
+	return applied
+}
+
+// namespaceOverrides returns whether the namespace sets the annotation, which takes precedence over the default annotations
+// like it takes precedence over the webhook flags. Only the image pull policy annotations are read from the namespaces.
+func (si *SidecarInjector) namespaceOverrides(key, namespace string) bool {
+	for _, prefix := range sidecarPrefixMap {
+		if key == prefix+imagePullPolicyKey {
+			return si.namespaceImagePullPolicy(prefix, namespace) != ""
+		}
+	}
+
+	return false
+}
+
+// namespaceLabels returns the labels of the namespace from the informer cache, and whether the namespace was found.
+func (si *SidecarInjector) namespaceLabels(namespace string) (labels.Set, bool) {
+	if si.NamespaceLister == nil || namespace == "" {
+		return nil, false
+	}
+
+	ns, err := si.NamespaceLister.Get(namespace)
+	if err != nil {
+		klog.Warningf("failed to get namespace %q, skipping the default annotations with a namespace selector: %v", namespace, err)
+
+		return nil, false
+	}
+
+	return labels.Set(ns.Labels), true
+}
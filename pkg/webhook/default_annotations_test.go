@@ -0,0 +1,196 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestDefaultAnnotationsValidate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name      string
+		rule      DefaultAnnotations
+		expectErr bool
+	}{
+		{
+			name: "valid rule",
+			rule: DefaultAnnotations{
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "ml"}},
+				Annotations:       map[string]string{memoryLimitAnnotation: "1Gi"},
+			},
+		},
+		{
+			name:      "no annotations",
+			rule:      DefaultAnnotations{Namespaces: []string{"ml"}},
+			expectErr: true,
+		},
+		{
+			name:      "annotation without the prefix",
+			rule:      DefaultAnnotations{Annotations: map[string]string{"example.com/memory-limit": "1Gi"}},
+			expectErr: true,
+		},
+		{
+			name:      "volumes annotation",
+			rule:      DefaultAnnotations{Annotations: map[string]string{GcsFuseVolumeEnableAnnotation: "true"}},
+			expectErr: true,
+		},
+		{
+			name:      "CPU boost restore annotation",
+			rule:      DefaultAnnotations{Annotations: map[string]string{CPUBoostRestoreRequestAnnotation: "1"}},
+			expectErr: true,
+		},
+		{
+			name: "invalid namespace selector",
+			rule: DefaultAnnotations{
+				NamespaceSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "team", Operator: "Near"}}},
+				Annotations:       map[string]string{memoryLimitAnnotation: "1Gi"},
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tc.rule.validate(); (err != nil) != tc.expectErr {
+				t.Errorf("got error %v, expected error %v", err, tc.expectErr)
+			}
+		})
+	}
+}
+
+func TestApplyDefaultAnnotations(t *testing.T) {
+	t.Parallel()
+
+	rules := []DefaultAnnotations{
+		{
+			Namespaces:  []string{"ml"},
+			Annotations: map[string]string{memoryLimitAnnotation: "2Gi"},
+		},
+		{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "data"}},
+			Annotations:       map[string]string{memoryLimitAnnotation: "1Gi", metadataPrefetchMaxOpsPerSecondAnnotation: "20"},
+		},
+		{
+			Namespaces:  []string{"dev"},
+			Annotations: map[string]string{imagePullPolicyAnnotation: "Never", memoryLimitAnnotation: "1Gi"},
+		},
+		{
+			Annotations: map[string]string{GcsfuseLoggingSeverityAnnotation: "warning"},
+		},
+	}
+	for i := range rules {
+		if err := rules[i].validate(); err != nil {
+			t.Fatalf("invalid rule: %v", err)
+		}
+	}
+
+	nsIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	_ = nsIndexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ml", Labels: map[string]string{"team": "data"}}})
+	_ = nsIndexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "analytics", Labels: map[string]string{"team": "data"}}})
+	_ = nsIndexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "dev", Annotations: map[string]string{imagePullPolicyAnnotation: "Always"}}})
+
+	testCases := []struct {
+		name                string
+		namespace           string
+		annotations         map[string]string
+		expectedAnnotations map[string]string
+		expectedApplied     []string
+	}{
+		{
+			name:                "rule for all namespaces",
+			namespace:           "default",
+			expectedAnnotations: map[string]string{GcsfuseLoggingSeverityAnnotation: "warning"},
+			expectedApplied:     []string{GcsfuseLoggingSeverityAnnotation},
+		},
+		{
+			name:      "first matching rule wins",
+			namespace: "ml",
+			expectedAnnotations: map[string]string{
+				memoryLimitAnnotation:                     "2Gi",
+				metadataPrefetchMaxOpsPerSecondAnnotation: "20",
+				GcsfuseLoggingSeverityAnnotation:          "warning",
+			},
+			expectedApplied: []string{GcsfuseLoggingSeverityAnnotation, memoryLimitAnnotation, metadataPrefetchMaxOpsPerSecondAnnotation},
+		},
+		{
+			name:      "namespace selector",
+			namespace: "analytics",
+			expectedAnnotations: map[string]string{
+				memoryLimitAnnotation:                     "1Gi",
+				metadataPrefetchMaxOpsPerSecondAnnotation: "20",
+				GcsfuseLoggingSeverityAnnotation:          "warning",
+			},
+			expectedApplied: []string{GcsfuseLoggingSeverityAnnotation, memoryLimitAnnotation, metadataPrefetchMaxOpsPerSecondAnnotation},
+		},
+		{
+			name:        "pod annotation overrides the default",
+			namespace:   "analytics",
+			annotations: map[string]string{GcsFuseVolumeEnableAnnotation: "true", memoryLimitAnnotation: "512Mi"},
+			expectedAnnotations: map[string]string{
+				GcsFuseVolumeEnableAnnotation:             "true",
+				memoryLimitAnnotation:                     "512Mi",
+				metadataPrefetchMaxOpsPerSecondAnnotation: "20",
+				GcsfuseLoggingSeverityAnnotation:          "warning",
+			},
+			expectedApplied: []string{GcsfuseLoggingSeverityAnnotation, metadataPrefetchMaxOpsPerSecondAnnotation},
+		},
+		{
+			name:      "namespace annotation overrides the default",
+			namespace: "dev",
+			expectedAnnotations: map[string]string{
+				memoryLimitAnnotation:            "1Gi",
+				GcsfuseLoggingSeverityAnnotation: "warning",
+			},
+			expectedApplied: []string{GcsfuseLoggingSeverityAnnotation, memoryLimitAnnotation},
+		},
+		{
+			name:                "namespace not in the cache",
+			namespace:           "unknown",
+			expectedAnnotations: map[string]string{GcsfuseLoggingSeverityAnnotation: "warning"},
+			expectedApplied:     []string{GcsfuseLoggingSeverityAnnotation},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			si := &SidecarInjector{NamespaceLister: listersv1.NewNamespaceLister(nsIndexer)}
+			si.SetDefaultConfigs(FakeConfig(), FakePrefetchConfig(), rules)
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+
+			applied := si.applyDefaultAnnotations(pod, tc.namespace)
+			if diff := cmp.Diff(tc.expectedApplied, applied); diff != "" {
+				t.Errorf("unexpected applied annotations (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.expectedAnnotations, pod.Annotations); diff != "" {
+				t.Errorf("unexpected annotations (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
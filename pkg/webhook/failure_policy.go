@@ -0,0 +1,163 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultMutatingWebhookConfigurationName is the name of the MutatingWebhookConfiguration of the sidecar injection webhook.
+	DefaultMutatingWebhookConfigurationName = "gcsfuse-sidecar-injector.csi.storage.gke.io"
+
+	// kubeSystemNamespace runs the cluster components that must be recreated to recover the cluster.
+	kubeSystemNamespace = "kube-system"
+)
+
+// FailurePolicy is the failurePolicy of the sidecar injection webhook, and the namespaces whose Pods bypass the webhook.
+type FailurePolicy struct {
+	Policy             admissionregistrationv1.FailurePolicyType
+	ExcludedNamespaces []string
+}
+
+// ParseFailurePolicy validates the failurePolicy of the sidecar injection webhook and the namespaces excluded from it.
+func ParseFailurePolicy(policy string, excludedNamespaces []string) (*FailurePolicy, error) {
+	switch admissionregistrationv1.FailurePolicyType(policy) {
+	case admissionregistrationv1.Ignore, admissionregistrationv1.Fail:
+	default:
+		return nil, fmt.Errorf("invalid failure policy %q, must be either %q or %q", policy, admissionregistrationv1.Ignore, admissionregistrationv1.Fail)
+	}
+
+	for _, ns := range excludedNamespaces {
+		if errs := validation.IsDNS1123Label(ns); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid excluded namespace %q: %v", ns, errs)
+		}
+	}
+
+	return &FailurePolicy{Policy: admissionregistrationv1.FailurePolicyType(policy), ExcludedNamespaces: excludedNamespaces}, nil
+}
+
+// namespaceSelector merges the requirement that excludes the namespaces from the webhook into the namespace selector.
+// The other labels and requirements of the selector, for example ones added by the cluster administrator, are kept.
+// The requirement on the namespace name label with the NotIn operator belongs to the webhook, and is replaced.
+func (p *FailurePolicy) namespaceSelector(existing *metav1.LabelSelector) *metav1.LabelSelector {
+	selector := &metav1.LabelSelector{}
+	if existing != nil {
+		selector.MatchLabels = existing.MatchLabels
+		for _, r := range existing.MatchExpressions {
+			if r.Key == corev1.LabelMetadataName && r.Operator == metav1.LabelSelectorOpNotIn {
+				continue
+			}
+			selector.MatchExpressions = append(selector.MatchExpressions, *r.DeepCopy())
+		}
+	}
+
+	if len(p.ExcludedNamespaces) > 0 {
+		selector.MatchExpressions = append(selector.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key: corev1.LabelMetadataName, Operator: metav1.LabelSelectorOpNotIn, Values: p.ExcludedNamespaces,
+		})
+	}
+
+	if len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0 {
+		return nil
+	}
+
+	return selector
+}
+
+// EnsureFailurePolicy updates the failurePolicy of the webhooks in the MutatingWebhookConfiguration, merges the excluded
+// namespaces into their namespaceSelector, and keeps the other fields, such as the caBundle, as they are.
+func EnsureFailurePolicy(ctx context.Context, client kubernetes.Interface, name string, p *FailurePolicy) error {
+	configs := client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+	config, err := configs.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get MutatingWebhookConfiguration %q: %w", name, err)
+	}
+
+	updated := config.DeepCopy()
+	for i := range updated.Webhooks {
+		policy := p.Policy
+		updated.Webhooks[i].FailurePolicy = &policy
+		updated.Webhooks[i].NamespaceSelector = p.namespaceSelector(updated.Webhooks[i].NamespaceSelector)
+	}
+	if apiequality.Semantic.DeepEqual(config.Webhooks, updated.Webhooks) {
+		return nil
+	}
+
+	klog.Infof("updating MutatingWebhookConfiguration %q to failure policy %q, excluding namespaces %v", name, p.Policy, p.ExcludedNamespaces)
+	if _, err := configs.Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update MutatingWebhookConfiguration %q: %w", name, err)
+	}
+
+	return nil
+}
+
+// FailurePolicyWarnings returns the ways the webhooks of the MutatingWebhookConfiguration could keep the cluster from recovering.
+// While no webhook replica is available, the API server rejects the Pods that a webhook with the Fail policy matches,
+// including the webhook Pods themselves and the cluster components in kube-system.
+func FailurePolicyWarnings(config *admissionregistrationv1.MutatingWebhookConfiguration) []string {
+	warnings := []string{}
+	for _, wh := range config.Webhooks {
+		if wh.FailurePolicy == nil || *wh.FailurePolicy != admissionregistrationv1.Fail {
+			continue
+		}
+
+		namespaces := []string{kubeSystemNamespace}
+		if wh.ClientConfig.Service != nil && !slices.Contains(namespaces, wh.ClientConfig.Service.Namespace) {
+			namespaces = append(namespaces, wh.ClientConfig.Service.Namespace)
+		}
+		for _, ns := range namespaces {
+			matched, err := matchesNamespace(wh.NamespaceSelector, ns)
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("webhook %q has an invalid namespace selector: %v", wh.Name, err))
+
+				break
+			}
+			if matched {
+				warnings = append(warnings, fmt.Sprintf("webhook %q has failure policy %q and matches the Pods in namespace %q, so these Pods cannot be created while no webhook replica is available; exclude the namespace with the namespace selector", wh.Name, admissionregistrationv1.Fail, ns))
+			}
+		}
+	}
+
+	return warnings
+}
+
+// matchesNamespace returns true if the namespace selector matches the namespace, using the label that is set on every namespace.
+func matchesNamespace(namespaceSelector *metav1.LabelSelector, namespace string) (bool, error) {
+	if namespaceSelector == nil {
+		return true, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(namespaceSelector)
+	if err != nil {
+		return false, err
+	}
+
+	return selector.Matches(labels.Set{corev1.LabelMetadataName: namespace}), nil
+}
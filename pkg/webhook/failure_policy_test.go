@@ -0,0 +1,226 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+func TestParseFailurePolicy(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name               string
+		policy             string
+		excludedNamespaces []string
+		expectErr          bool
+	}{
+		{
+			name:               "fail closed with kube-system excluded",
+			policy:             "Fail",
+			excludedNamespaces: []string{"kube-system"},
+		},
+		{
+			name:   "fail open without excluded namespaces",
+			policy: "Ignore",
+		},
+		{
+			name:      "lower case policy",
+			policy:    "fail",
+			expectErr: true,
+		},
+		{
+			name:      "empty policy",
+			expectErr: true,
+		},
+		{
+			name:               "invalid namespace",
+			policy:             "Fail",
+			excludedNamespaces: []string{"Kube_System"},
+			expectErr:          true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			p, err := ParseFailurePolicy(tc.policy, tc.excludedNamespaces)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectErr)
+			}
+			if err == nil && string(p.Policy) != tc.policy {
+				t.Errorf("got policy %q, expected %q", p.Policy, tc.policy)
+			}
+		})
+	}
+}
+
+func TestEnsureFailurePolicy(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	caBundle := []byte("test-ca-bundle")
+	client := fake.NewSimpleClientset(&admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultMutatingWebhookConfigurationName},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{
+			{
+				Name:          DefaultMutatingWebhookConfigurationName,
+				FailurePolicy: ptr.To(admissionregistrationv1.Ignore),
+				ClientConfig:  admissionregistrationv1.WebhookClientConfig{CABundle: caBundle},
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"gcsfuse-injection": "enabled"},
+					MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: "environment", Operator: metav1.LabelSelectorOpIn, Values: []string{"prod"}},
+						{Key: corev1.LabelMetadataName, Operator: metav1.LabelSelectorOpNotIn, Values: []string{"kube-system"}},
+					},
+				},
+			},
+		},
+	})
+
+	policy, err := ParseFailurePolicy("Fail", []string{"kube-system", "gcs-fuse-csi-driver"})
+	if err != nil {
+		t.Fatalf("failed to parse the failure policy: %v", err)
+	}
+	if err := EnsureFailurePolicy(ctx, client, DefaultMutatingWebhookConfigurationName, policy); err != nil {
+		t.Fatalf("failed to ensure the failure policy: %v", err)
+	}
+
+	config, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, DefaultMutatingWebhookConfigurationName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get the MutatingWebhookConfiguration: %v", err)
+	}
+	wh := config.Webhooks[0]
+	if *wh.FailurePolicy != admissionregistrationv1.Fail {
+		t.Errorf("got failure policy %q, expected %q", *wh.FailurePolicy, admissionregistrationv1.Fail)
+	}
+	// The excluded namespaces replace the previous ones, and the labels and requirements of the administrator are kept.
+	expectedSelector := &metav1.LabelSelector{
+		MatchLabels: map[string]string{"gcsfuse-injection": "enabled"},
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "environment", Operator: metav1.LabelSelectorOpIn, Values: []string{"prod"}},
+			{Key: corev1.LabelMetadataName, Operator: metav1.LabelSelectorOpNotIn, Values: []string{"kube-system", "gcs-fuse-csi-driver"}},
+		},
+	}
+	if diff := cmp.Diff(wh.NamespaceSelector, expectedSelector); diff != "" {
+		t.Errorf("unexpected namespace selector (-got, +want)\n%s", diff)
+	}
+	if string(wh.ClientConfig.CABundle) != string(caBundle) {
+		t.Errorf("got caBundle %q, expected it to be kept", wh.ClientConfig.CABundle)
+	}
+
+	// Without excluded namespaces, only the requirement of the webhook is removed.
+	noExclusions, err := ParseFailurePolicy("Fail", nil)
+	if err != nil {
+		t.Fatalf("failed to parse the failure policy: %v", err)
+	}
+	if err := EnsureFailurePolicy(ctx, client, DefaultMutatingWebhookConfigurationName, noExclusions); err != nil {
+		t.Fatalf("failed to ensure the failure policy: %v", err)
+	}
+	config, err = client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, DefaultMutatingWebhookConfigurationName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get the MutatingWebhookConfiguration: %v", err)
+	}
+	expectedSelector.MatchExpressions = expectedSelector.MatchExpressions[:1]
+	if diff := cmp.Diff(config.Webhooks[0].NamespaceSelector, expectedSelector); diff != "" {
+		t.Errorf("unexpected namespace selector without excluded namespaces (-got, +want)\n%s", diff)
+	}
+
+	if err := EnsureFailurePolicy(ctx, client, "missing", policy); err == nil {
+		t.Error("expected an error for a missing MutatingWebhookConfiguration")
+	}
+}
+
+func TestFailurePolicyWarnings(t *testing.T) {
+	t.Parallel()
+
+	service := &admissionregistrationv1.ServiceReference{Namespace: "gcs-fuse-csi-driver", Name: "gcs-fuse-csi-driver-webhook"}
+	excluding := func(namespaces ...string) *metav1.LabelSelector {
+		return &metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: corev1.LabelMetadataName, Operator: metav1.LabelSelectorOpNotIn, Values: namespaces},
+			},
+		}
+	}
+
+	testCases := []struct {
+		name              string
+		policy            admissionregistrationv1.FailurePolicyType
+		namespaceSelector *metav1.LabelSelector
+		expectWarnings    int
+	}{
+		{
+			name:           "fail open",
+			policy:         admissionregistrationv1.Ignore,
+			expectWarnings: 0,
+		},
+		{
+			name:           "fail closed without namespace selector",
+			policy:         admissionregistrationv1.Fail,
+			expectWarnings: 2,
+		},
+		{
+			name:              "fail closed excluding kube-system",
+			policy:            admissionregistrationv1.Fail,
+			namespaceSelector: excluding("kube-system"),
+			expectWarnings:    1,
+		},
+		{
+			name:              "fail closed excluding kube-system and the webhook namespace",
+			policy:            admissionregistrationv1.Fail,
+			namespaceSelector: excluding("kube-system", "gcs-fuse-csi-driver"),
+			expectWarnings:    0,
+		},
+		{
+			name:   "fail closed with invalid namespace selector",
+			policy: admissionregistrationv1.Fail,
+			namespaceSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{Key: corev1.LabelMetadataName, Operator: "Unknown"}},
+			},
+			expectWarnings: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			config := &admissionregistrationv1.MutatingWebhookConfiguration{
+				Webhooks: []admissionregistrationv1.MutatingWebhook{
+					{
+						Name:              DefaultMutatingWebhookConfigurationName,
+						FailurePolicy:     ptr.To(tc.policy),
+						NamespaceSelector: tc.namespaceSelector,
+						ClientConfig:      admissionregistrationv1.WebhookClientConfig{Service: service},
+					},
+				},
+			}
+
+			if warnings := FailurePolicyWarnings(config); len(warnings) != tc.expectWarnings {
+				t.Errorf("got warnings %q, expected %v warnings", warnings, tc.expectWarnings)
+			}
+		})
+	}
+}
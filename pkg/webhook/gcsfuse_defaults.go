@@ -0,0 +1,63 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// The Pod annotations that set the gcsfuse defaults of all the CSI volumes of the Pod.
+// The CSI driver reads them when it mounts a volume, and the volume attributes and mount options of the volume take precedence.
+const (
+	GcsfuseLoggingSeverityAnnotation     = "gke-gcsfuse/logging-severity"
+	FileCacheCapacityAnnotation          = "gke-gcsfuse/file-cache-capacity"
+	FileCacheParallelDownloadsAnnotation = "gke-gcsfuse/file-cache-parallel-downloads"
+	MetadataStatCacheCapacityAnnotation  = "gke-gcsfuse/metadata-stat-cache-capacity"
+	MetadataTypeCacheCapacityAnnotation  = "gke-gcsfuse/metadata-type-cache-capacity"
+)
+
+// gcsfuseLoggingSeverities are the log severities that gcsfuse accepts.
+var gcsfuseLoggingSeverities = []string{"trace", "debug", "info", "warning", "error", "off"}
+
+// validateGCSFuseDefaults rejects the Pods with invalid gcsfuse default annotations at admission,
+// instead of failing to mount all their volumes.
+func validateGCSFuseDefaults(pod *corev1.Pod) error {
+	if value, ok := pod.Annotations[GcsfuseLoggingSeverityAnnotation]; ok && !slices.Contains(gcsfuseLoggingSeverities, value) {
+		return fmt.Errorf("the acceptable values for %q are %q, got %q", GcsfuseLoggingSeverityAnnotation, gcsfuseLoggingSeverities, value)
+	}
+
+	if value, ok := pod.Annotations[FileCacheParallelDownloadsAnnotation]; ok {
+		if _, err := ParseBool(value); err != nil {
+			return fmt.Errorf("the acceptable values for %q are 'True', 'true', 'false' or 'False', got %q", FileCacheParallelDownloadsAnnotation, value)
+		}
+	}
+
+	for _, annotation := range []string{FileCacheCapacityAnnotation, MetadataStatCacheCapacityAnnotation, MetadataTypeCacheCapacityAnnotation} {
+		if value, ok := pod.Annotations[annotation]; ok {
+			if _, err := resource.ParseQuantity(value); err != nil {
+				return fmt.Errorf("%q only accepts a valid Quantity value, got %q: %w", annotation, value, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateGCSFuseDefaults(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		expectErr   bool
+	}{
+		{
+			name: "no annotations",
+		},
+		{
+			name: "valid annotations",
+			annotations: map[string]string{
+				GcsfuseLoggingSeverityAnnotation:     "debug",
+				FileCacheCapacityAnnotation:          "10Gi",
+				FileCacheParallelDownloadsAnnotation: "True",
+				MetadataStatCacheCapacityAnnotation:  "-1",
+				MetadataTypeCacheCapacityAnnotation:  "64Mi",
+			},
+		},
+		{
+			name:        "invalid logging severity",
+			annotations: map[string]string{GcsfuseLoggingSeverityAnnotation: "verbose"},
+			expectErr:   true,
+		},
+		{
+			name:        "invalid parallel downloads",
+			annotations: map[string]string{FileCacheParallelDownloadsAnnotation: "yes"},
+			expectErr:   true,
+		},
+		{
+			name:        "invalid cache capacity",
+			annotations: map[string]string{MetadataTypeCacheCapacityAnnotation: "64MB"},
+			expectErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+			if err := validateGCSFuseDefaults(pod); (err != nil) != tc.expectErr {
+				t.Errorf("got error %v, expected error %v", err, tc.expectErr)
+			}
+		})
+	}
+}
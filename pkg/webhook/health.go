@@ -0,0 +1,74 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+// CertValidityChecker returns a readiness check that fails while the serving certificate in certPath is not valid,
+// so that the API server does not send admission requests to a replica whose certificate it would reject.
+// The webhook server reloads the certificate when the file changes, and the check reads the same file.
+func CertValidityChecker(certPath string) healthz.Checker {
+	return func(_ *http.Request) error {
+		return checkCertValidity(certPath, time.Now())
+	}
+}
+
+func checkCertValidity(certPath string, now time.Time) error {
+	cert, err := readCertificate(certPath)
+	if err != nil {
+		return err
+	}
+
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("the serving certificate is not valid before %v", cert.NotBefore)
+	}
+	if now.After(cert.NotAfter) {
+		return fmt.Errorf("the serving certificate expired at %v", cert.NotAfter)
+	}
+
+	return nil
+}
+
+// readCertificate returns the first certificate in the PEM file at certPath, which is the serving certificate of a chain.
+func readCertificate(certPath string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the serving certificate: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("failed to decode the serving certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the serving certificate: %w", err)
+	}
+
+	return cert, nil
+}
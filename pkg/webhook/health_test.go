@@ -0,0 +1,107 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckCertValidity(t *testing.T) {
+	t.Parallel()
+
+	notBefore := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := notBefore.Add(365 * 24 * time.Hour)
+	certPath := writeTestCert(t, notBefore, notAfter)
+
+	testCases := []struct {
+		name      string
+		certPath  string
+		now       time.Time
+		expectErr bool
+	}{
+		{
+			name:     "valid certificate",
+			certPath: certPath,
+			now:      notBefore.Add(time.Hour),
+		},
+		{
+			name:      "certificate not valid yet",
+			certPath:  certPath,
+			now:       notBefore.Add(-time.Hour),
+			expectErr: true,
+		},
+		{
+			name:      "expired certificate",
+			certPath:  certPath,
+			now:       notAfter.Add(time.Hour),
+			expectErr: true,
+		},
+		{
+			name:      "missing certificate",
+			certPath:  filepath.Join(t.TempDir(), "missing.pem"),
+			now:       notBefore.Add(time.Hour),
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if err := checkCertValidity(tc.certPath, tc.now); (err != nil) != tc.expectErr {
+				t.Errorf("got error %v, expected error %v", err, tc.expectErr)
+			}
+		})
+	}
+}
+
+// writeTestCert writes a self-signed PEM certificate that is valid between notBefore and notAfter, and returns its path.
+func writeTestCert(t *testing.T, notBefore, notAfter time.Time) string {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "gcs-fuse-csi-driver-webhook.gcs-fuse-csi-driver.svc"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "cert.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+
+	return path
+}
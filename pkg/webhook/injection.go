@@ -18,6 +18,7 @@ limitations under the License.
 package webhook
 
 import (
+	"context"
 	"fmt"
 
 	corev1 "k8s.io/api/core/v1"
@@ -63,7 +64,23 @@ func (si *SidecarInjector) injectAsNativeSidecar(pod *corev1.Pod) (bool, error)
 	return nativeSidecarEnabled && supportsNativeSidecar, nil
 }
 
+// supportsNativeSidecar reports whether the cluster supports native sidecar containers.
+// The result is shared across admission requests for si.LookupCacheTTL because listing all the nodes
+// on every request is expensive at high pod churn.
 func (si *SidecarInjector) supportsNativeSidecar() (bool, error) {
+	v, err := si.lookups.get(context.Background(), nativeSidecarSupportLookupKey, si.LookupCacheTTL, func(context.Context) (interface{}, error) {
+		return si.checkNativeSidecarSupport()
+	})
+	if err != nil {
+		return false, err
+	}
+
+	supported, _ := v.(bool)
+
+	return supported, nil
+}
+
+func (si *SidecarInjector) checkNativeSidecarSupport() (bool, error) {
 	if si.ServerVersion != nil && !si.ServerVersion.AtLeast(minimumSupportedVersion) {
 		return false, nil
 	}
@@ -126,6 +143,41 @@ func (si *SidecarInjector) injectSidecarContainer(containerName string, pod *cor
 		index = getInjectIndexAfterContainer(pod.Spec.Containers, containerIndexOrderMap[containerName])
 	}
 
+	if containerName == GcsFuseSidecarName {
+		if err := applyCPUBoost(pod, &containerSpec, config); err != nil {
+			return err
+		}
+
+		protect, err := si.oomProtectionEnabled(pod)
+		if err != nil {
+			return err
+		}
+		if protect {
+			applyOOMProtection(pod, &containerSpec)
+		}
+
+		if err := applyUploadBarrier(pod, &containerSpec); err != nil {
+			return err
+		}
+		if injectAsNativeSidecar {
+			applyDrainTimeout(pod, &containerSpec)
+		}
+		if err := applyIOTokenBucket(pod, &containerSpec); err != nil {
+			return err
+		}
+
+		if err := validateGCSFuseDefaults(pod); err != nil {
+			return err
+		}
+	}
+
+	// The volumes of the annotation are validated even if none of them is prefetched, so that typos are not silently ignored.
+	if containerName == MetadataPrefetchSidecarName {
+		if err := applyMetadataPrefetchVolumes(pod, &containerSpec); err != nil {
+			return err
+		}
+	}
+
 	// Skip metadata prefetch sidecar injection if no volumes are requesting metadata prefetch.
 	if containerName == MetadataPrefetchSidecarName && len(containerSpec.VolumeMounts) == 0 {
 		klog.Info("no volumes are requesting metadata prefetch, skipping metadata prefetch sidecar injection")
@@ -133,6 +185,30 @@ func (si *SidecarInjector) injectSidecarContainer(containerName string, pod *cor
 		return nil
 	}
 
+	if containerName == MetadataPrefetchSidecarName {
+		if err := applyMetadataPrefetchThrottle(pod, &containerSpec); err != nil {
+			return err
+		}
+		if err := applyMetadataPrefetchPaths(pod, &containerSpec); err != nil {
+			return err
+		}
+		if err := applyMetadataPrefetchMaxDepth(pod, &containerSpec); err != nil {
+			return err
+		}
+		if err := applyMetadataPrefetchBudget(pod, &containerSpec); err != nil {
+			return err
+		}
+		if err := applyMetadataPrefetchRefreshInterval(pod, &containerSpec); err != nil {
+			return err
+		}
+		if err := applyMetadataPrefetchStatFiles(pod, &containerSpec); err != nil {
+			return err
+		}
+		if err := applyMetadataPrefetchPorts(pod, &containerSpec); err != nil {
+			return err
+		}
+	}
+
 	// This should not happen as we always inject the sidecar after injecting our primary gcsfuse sidecar.
 	if containerName == MetadataPrefetchSidecarName && index == 0 {
 		klog.Warningf("%s not found when attempting to inject container. skipping injection", containerIndexOrderMap[containerName])
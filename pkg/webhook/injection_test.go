@@ -760,7 +760,7 @@ func TestInjectMetadataPrefetchSidecar(t *testing.T) {
 							Name: "my-volume",
 							VolumeSource: corev1.VolumeSource{
 								CSI: &corev1.CSIVolumeSource{
-									Driver: gcsFuseCsiDriverName,
+									Driver: DefaultCSIDriverName,
 									VolumeAttributes: map[string]string{
 										gcsFuseMetadataPrefetchOnMountVolumeAttribute: "false",
 									},
@@ -799,7 +799,7 @@ func TestInjectMetadataPrefetchSidecar(t *testing.T) {
 							Name: "my-volume",
 							VolumeSource: corev1.VolumeSource{
 								CSI: &corev1.CSIVolumeSource{
-									Driver: gcsFuseCsiDriverName,
+									Driver: DefaultCSIDriverName,
 									VolumeAttributes: map[string]string{
 										gcsFuseMetadataPrefetchOnMountVolumeAttribute: "false",
 									},
@@ -842,7 +842,7 @@ func TestInjectMetadataPrefetchSidecar(t *testing.T) {
 							Name: "my-volume",
 							VolumeSource: corev1.VolumeSource{
 								CSI: &corev1.CSIVolumeSource{
-									Driver: gcsFuseCsiDriverName,
+									Driver: DefaultCSIDriverName,
 									VolumeAttributes: map[string]string{
 										gcsFuseMetadataPrefetchOnMountVolumeAttribute: "true",
 									},
@@ -878,7 +878,7 @@ func TestInjectMetadataPrefetchSidecar(t *testing.T) {
 							Name: "my-volume",
 							VolumeSource: corev1.VolumeSource{
 								CSI: &corev1.CSIVolumeSource{
-									Driver: gcsFuseCsiDriverName,
+									Driver: DefaultCSIDriverName,
 									VolumeAttributes: map[string]string{
 										gcsFuseMetadataPrefetchOnMountVolumeAttribute: "true",
 									},
@@ -920,7 +920,7 @@ func TestInjectMetadataPrefetchSidecar(t *testing.T) {
 							Name: "my-volume",
 							VolumeSource: corev1.VolumeSource{
 								CSI: &corev1.CSIVolumeSource{
-									Driver: gcsFuseCsiDriverName,
+									Driver: DefaultCSIDriverName,
 									VolumeAttributes: map[string]string{
 										gcsFuseMetadataPrefetchOnMountVolumeAttribute: "true",
 									},
@@ -947,7 +947,7 @@ func TestInjectMetadataPrefetchSidecar(t *testing.T) {
 								Requests: requests,
 								Limits:   limits,
 							},
-							VolumeMounts: []corev1.VolumeMount{{Name: "my-volume", ReadOnly: true, MountPath: "/volumes/my-volume"}},
+							VolumeMounts: []corev1.VolumeMount{{Name: "my-volume", ReadOnly: true, MountPath: "/volumes/my-volume", MountPropagation: ptr.To(corev1.MountPropagationHostToContainer)}},
 						},
 						{
 							Name: "two",
@@ -972,7 +972,7 @@ func TestInjectMetadataPrefetchSidecar(t *testing.T) {
 							Name: "my-volume",
 							VolumeSource: corev1.VolumeSource{
 								CSI: &corev1.CSIVolumeSource{
-									Driver: gcsFuseCsiDriverName,
+									Driver: DefaultCSIDriverName,
 									VolumeAttributes: map[string]string{
 										gcsFuseMetadataPrefetchOnMountVolumeAttribute: "true",
 									},
@@ -1017,7 +1017,7 @@ func TestInjectMetadataPrefetchSidecar(t *testing.T) {
 							Name: "my-volume",
 							VolumeSource: corev1.VolumeSource{
 								CSI: &corev1.CSIVolumeSource{
-									Driver: gcsFuseCsiDriverName,
+									Driver: DefaultCSIDriverName,
 									VolumeAttributes: map[string]string{
 										gcsFuseMetadataPrefetchOnMountVolumeAttribute: "true",
 									},
@@ -1056,7 +1056,7 @@ func TestInjectMetadataPrefetchSidecar(t *testing.T) {
 								Requests: customRequests,
 								Limits:   customLimits,
 							},
-							VolumeMounts: []corev1.VolumeMount{{Name: "my-volume", ReadOnly: true, MountPath: "/volumes/my-volume"}},
+							VolumeMounts: []corev1.VolumeMount{{Name: "my-volume", ReadOnly: true, MountPath: "/volumes/my-volume", MountPropagation: ptr.To(corev1.MountPropagationHostToContainer)}},
 						},
 						{
 							Name: "two",
@@ -1081,7 +1081,7 @@ func TestInjectMetadataPrefetchSidecar(t *testing.T) {
 							Name: "my-volume",
 							VolumeSource: corev1.VolumeSource{
 								CSI: &corev1.CSIVolumeSource{
-									Driver: gcsFuseCsiDriverName,
+									Driver: DefaultCSIDriverName,
 									VolumeAttributes: map[string]string{
 										gcsFuseMetadataPrefetchOnMountVolumeAttribute: "true",
 									},
@@ -1134,7 +1134,7 @@ func TestInjectMetadataPrefetchSidecar(t *testing.T) {
 							Name: "my-volume",
 							VolumeSource: corev1.VolumeSource{
 								CSI: &corev1.CSIVolumeSource{
-									Driver: gcsFuseCsiDriverName,
+									Driver: DefaultCSIDriverName,
 									VolumeAttributes: map[string]string{
 										gcsFuseMetadataPrefetchOnMountVolumeAttribute: "true",
 									},
@@ -1172,7 +1172,7 @@ func TestInjectMetadataPrefetchSidecar(t *testing.T) {
 								Requests: customRequests,
 								Limits:   customLimits,
 							},
-							VolumeMounts: []corev1.VolumeMount{{Name: "my-volume", ReadOnly: true, MountPath: "/volumes/my-volume"}},
+							VolumeMounts: []corev1.VolumeMount{{Name: "my-volume", ReadOnly: true, MountPath: "/volumes/my-volume", MountPropagation: ptr.To(corev1.MountPropagationHostToContainer)}},
 						},
 						{
 							Name: "two",
@@ -1197,7 +1197,7 @@ func TestInjectMetadataPrefetchSidecar(t *testing.T) {
 							Name: "my-volume",
 							VolumeSource: corev1.VolumeSource{
 								CSI: &corev1.CSIVolumeSource{
-									Driver: gcsFuseCsiDriverName,
+									Driver: DefaultCSIDriverName,
 									VolumeAttributes: map[string]string{
 										gcsFuseMetadataPrefetchOnMountVolumeAttribute: "true",
 									},
@@ -1242,7 +1242,7 @@ func TestInjectMetadataPrefetchSidecar(t *testing.T) {
 							Name: "my-volume",
 							VolumeSource: corev1.VolumeSource{
 								CSI: &corev1.CSIVolumeSource{
-									Driver: gcsFuseCsiDriverName,
+									Driver: DefaultCSIDriverName,
 									VolumeAttributes: map[string]string{
 										gcsFuseMetadataPrefetchOnMountVolumeAttribute: "true",
 									},
@@ -1253,7 +1253,7 @@ func TestInjectMetadataPrefetchSidecar(t *testing.T) {
 							Name: "my-other-volume",
 							VolumeSource: corev1.VolumeSource{
 								CSI: &corev1.CSIVolumeSource{
-									Driver: gcsFuseCsiDriverName,
+									Driver: DefaultCSIDriverName,
 									VolumeAttributes: map[string]string{
 										gcsFuseMetadataPrefetchOnMountVolumeAttribute: "false",
 									},
@@ -1306,7 +1306,7 @@ func TestInjectMetadataPrefetchSidecar(t *testing.T) {
 								Requests: requests,
 								Limits:   limits,
 							},
-							VolumeMounts: []corev1.VolumeMount{{Name: "my-volume", ReadOnly: true, MountPath: "/volumes/my-volume"}},
+							VolumeMounts: []corev1.VolumeMount{{Name: "my-volume", ReadOnly: true, MountPath: "/volumes/my-volume", MountPropagation: ptr.To(corev1.MountPropagationHostToContainer)}},
 						},
 						{
 							Name: "two",
@@ -1331,7 +1331,7 @@ func TestInjectMetadataPrefetchSidecar(t *testing.T) {
 							Name: "my-volume",
 							VolumeSource: corev1.VolumeSource{
 								CSI: &corev1.CSIVolumeSource{
-									Driver: gcsFuseCsiDriverName,
+									Driver: DefaultCSIDriverName,
 									VolumeAttributes: map[string]string{
 										gcsFuseMetadataPrefetchOnMountVolumeAttribute: "true",
 									},
@@ -1342,7 +1342,7 @@ func TestInjectMetadataPrefetchSidecar(t *testing.T) {
 							Name: "my-other-volume",
 							VolumeSource: corev1.VolumeSource{
 								CSI: &corev1.CSIVolumeSource{
-									Driver: gcsFuseCsiDriverName,
+									Driver: DefaultCSIDriverName,
 									VolumeAttributes: map[string]string{
 										gcsFuseMetadataPrefetchOnMountVolumeAttribute: "false",
 									},
@@ -1402,7 +1402,7 @@ func TestInjectMetadataPrefetchSidecar(t *testing.T) {
 							Name: "my-volume",
 							VolumeSource: corev1.VolumeSource{
 								CSI: &corev1.CSIVolumeSource{
-									Driver: gcsFuseCsiDriverName,
+									Driver: DefaultCSIDriverName,
 									VolumeAttributes: map[string]string{
 										gcsFuseMetadataPrefetchOnMountVolumeAttribute: "true",
 									},
@@ -1429,7 +1429,7 @@ func TestInjectMetadataPrefetchSidecar(t *testing.T) {
 							},
 							Image:           "my-private-image",
 							ImagePullPolicy: corev1.PullPolicy(FakePrefetchConfig().ImagePullPolicy),
-							VolumeMounts:    []corev1.VolumeMount{{Name: "my-volume", ReadOnly: true, MountPath: "/volumes/my-volume"}},
+							VolumeMounts:    []corev1.VolumeMount{{Name: "my-volume", ReadOnly: true, MountPath: "/volumes/my-volume", MountPropagation: ptr.To(corev1.MountPropagationHostToContainer)}},
 						},
 						{
 							Name: "two",
@@ -1454,7 +1454,7 @@ func TestInjectMetadataPrefetchSidecar(t *testing.T) {
 							Name: "my-volume",
 							VolumeSource: corev1.VolumeSource{
 								CSI: &corev1.CSIVolumeSource{
-									Driver: gcsFuseCsiDriverName,
+									Driver: DefaultCSIDriverName,
 									VolumeAttributes: map[string]string{
 										gcsFuseMetadataPrefetchOnMountVolumeAttribute: "true",
 									},
@@ -1500,7 +1500,7 @@ func TestInjectMetadataPrefetchSidecar(t *testing.T) {
 							Name: "my-volume",
 							VolumeSource: corev1.VolumeSource{
 								CSI: &corev1.CSIVolumeSource{
-									Driver: gcsFuseCsiDriverName,
+									Driver: DefaultCSIDriverName,
 									VolumeAttributes: map[string]string{
 										gcsFuseMetadataPrefetchOnMountVolumeAttribute: "true",
 									},
@@ -1539,7 +1539,7 @@ func TestInjectMetadataPrefetchSidecar(t *testing.T) {
 							Name: "my-volume",
 							VolumeSource: corev1.VolumeSource{
 								CSI: &corev1.CSIVolumeSource{
-									Driver: gcsFuseCsiDriverName,
+									Driver: DefaultCSIDriverName,
 									VolumeAttributes: map[string]string{
 										gcsFuseMetadataPrefetchOnMountVolumeAttribute: "true",
 									},
@@ -0,0 +1,101 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// LintPod returns the reasons the webhook rejects the Pod, and the warnings it returns when the Pod is admitted,
+// using the default sidecar container configs of the injector. The PersistentVolumeClaims and PersistentVolumes
+// of the Pod volumes are looked up with the listers of the injector, which must be set.
+func (si *SidecarInjector) LintPod(pod *corev1.Pod) ([]string, []string) {
+	errs, warnings := []string{}, []string{}
+
+	gcsFuseVolumes := []string{}
+	for _, v := range pod.Spec.Volumes {
+		if v.PersistentVolumeClaim != nil {
+			pvc, err := si.GetPVC(pod.Namespace, v.PersistentVolumeClaim.ClaimName)
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("volume %q is not linted, because its PersistentVolumeClaim %q is not found: %v", v.Name, v.PersistentVolumeClaim.ClaimName, err))
+
+				continue
+			}
+			if pvc.Spec.VolumeName == "" {
+				warnings = append(warnings, fmt.Sprintf("volume %q is not linted, because its PersistentVolumeClaim %q is not bound to a PersistentVolume", v.Name, pvc.Name))
+
+				continue
+			}
+			if _, err := si.GetPV(pvc.Spec.VolumeName); err != nil {
+				warnings = append(warnings, fmt.Sprintf("volume %q is not linted, because its PersistentVolume %q is not found: %v", v.Name, pvc.Spec.VolumeName, err))
+
+				continue
+			}
+		}
+
+		isGcsFuseCSIVolume, _, _, err := si.isGcsFuseCSIVolume(v, pod.Namespace)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("failed to determine if volume %q is a Cloud Storage FUSE volume: %v", v.Name, err))
+		}
+		if isGcsFuseCSIVolume {
+			gcsFuseVolumes = append(gcsFuseVolumes, v.Name)
+		}
+	}
+
+	value, ok := pod.Annotations[GcsFuseVolumeEnableAnnotation]
+	if !ok {
+		if len(gcsFuseVolumes) > 0 {
+			warnings = append(warnings, fmt.Sprintf("the Pod has Cloud Storage FUSE volumes %q without the annotation '%v: true', so the sidecar container is not injected and the volumes fail to mount", gcsFuseVolumes, GcsFuseVolumeEnableAnnotation))
+		}
+
+		return errs, warnings
+	}
+
+	shouldInjectSidecar, err := ParseBool(value)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("the acceptable values for %q are 'True', 'true', 'false' or 'False', got %q", GcsFuseVolumeEnableAnnotation, value))
+
+		return errs, warnings
+	}
+	if !shouldInjectSidecar {
+		if len(gcsFuseVolumes) > 0 {
+			warnings = append(warnings, fmt.Sprintf("the Pod has Cloud Storage FUSE volumes %q with the annotation '%v: false', so the sidecar container is not injected and the volumes fail to mount", gcsFuseVolumes, GcsFuseVolumeEnableAnnotation))
+		}
+
+		return errs, warnings
+	}
+
+	if sidecarInjected, _ := ValidatePodHasSidecarContainerInjected(pod); sidecarInjected {
+		return errs, warnings
+	}
+	warnings = append(warnings, si.workloadWarnings(pod)...)
+
+	// Inject the sidecar containers into a copy of the Pod, so that the annotations are validated the same way as on admission.
+	injected := pod.DeepCopy()
+	for _, name := range []string{GcsFuseSidecarName, MetadataPrefetchSidecarName} {
+		if err := si.injectSidecarContainer(name, injected, false); err != nil {
+			errs = append(errs, err.Error())
+
+			break
+		}
+	}
+
+	return errs, warnings
+}
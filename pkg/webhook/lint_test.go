@@ -0,0 +1,150 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestLintPod(t *testing.T) {
+	t.Parallel()
+
+	ephemeralVolume := corev1.Volume{
+		Name: "data",
+		VolumeSource: corev1.VolumeSource{
+			CSI: &corev1.CSIVolumeSource{Driver: DefaultCSIDriverName, VolumeAttributes: map[string]string{"bucketName": "test-bucket"}},
+		},
+	}
+	pvcVolume := func(claimName string) corev1.Volume {
+		return corev1.Volume{
+			Name:         "data",
+			VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claimName}},
+		}
+	}
+	pod := func(annotations map[string]string, volumes ...corev1.Volume) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default", Annotations: annotations},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "workload", Image: "busybox", VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/data"}}}},
+				Volumes:    volumes,
+			},
+		}
+	}
+	enabled := map[string]string{GcsFuseVolumeEnableAnnotation: "true"}
+
+	testCases := []struct {
+		name             string
+		pod              *corev1.Pod
+		expectedErrors   []string
+		expectedWarnings []string
+	}{
+		{
+			name: "valid pod",
+			pod:  pod(enabled, ephemeralVolume),
+		},
+		{
+			name:             "volume without annotation",
+			pod:              pod(nil, ephemeralVolume),
+			expectedWarnings: []string{`volumes ["data"] without the annotation`},
+		},
+		{
+			name:             "volume with disabled annotation",
+			pod:              pod(map[string]string{GcsFuseVolumeEnableAnnotation: "false"}, ephemeralVolume),
+			expectedWarnings: []string{`volumes ["data"] with the annotation 'gke-gcsfuse/volumes: false'`},
+		},
+		{
+			name:           "invalid annotation value",
+			pod:            pod(map[string]string{GcsFuseVolumeEnableAnnotation: "yes"}, ephemeralVolume),
+			expectedErrors: []string{`the acceptable values for "gke-gcsfuse/volumes"`},
+		},
+		{
+			name:           "invalid resource annotation",
+			pod:            pod(map[string]string{GcsFuseVolumeEnableAnnotation: "true", memoryLimitAnnotation: "lots"}, ephemeralVolume),
+			expectedErrors: []string{"failed to parse sidecar container resource allocation"},
+		},
+		{
+			name:           "invalid image pull policy annotation",
+			pod:            pod(map[string]string{GcsFuseVolumeEnableAnnotation: "true", imagePullPolicyAnnotation: "Sometimes"}, ephemeralVolume),
+			expectedErrors: []string{`invalid sidecar container image pull policy "Sometimes"`},
+		},
+		{
+			name: "persistent volume",
+			pod:  pod(enabled, pvcVolume("bound-pvc")),
+		},
+		{
+			name:             "persistent volume without annotation",
+			pod:              pod(nil, pvcVolume("bound-pvc")),
+			expectedWarnings: []string{`volumes ["data"] without the annotation`},
+		},
+		{
+			name:             "missing persistent volume claim",
+			pod:              pod(enabled, pvcVolume("missing-pvc")),
+			expectedWarnings: []string{`PersistentVolumeClaim "missing-pvc" is not found`},
+		},
+		{
+			name:             "unbound persistent volume claim",
+			pod:              pod(enabled, pvcVolume("unbound-pvc")),
+			expectedWarnings: []string{`PersistentVolumeClaim "unbound-pvc" is not bound`},
+		},
+	}
+
+	pvIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	_ = pvIndexer.Add(&corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pv"},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{Driver: DefaultCSIDriverName, VolumeHandle: "test-bucket"}},
+		},
+	})
+	pvcIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	_ = pvcIndexer.Add(&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "bound-pvc", Namespace: "default"}, Spec: corev1.PersistentVolumeClaimSpec{VolumeName: "test-pv"}})
+	_ = pvcIndexer.Add(&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "unbound-pvc", Namespace: "default"}})
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			si := &SidecarInjector{
+				Config:                 FakeConfig(),
+				MetadataPrefetchConfig: FakePrefetchConfig(),
+				PvLister:               listersv1.NewPersistentVolumeLister(pvIndexer),
+				PvcLister:              listersv1.NewPersistentVolumeClaimLister(pvcIndexer),
+			}
+			errs, warnings := si.LintPod(tc.pod)
+			for _, c := range []struct {
+				kind     string
+				got      []string
+				expected []string
+			}{{"errors", errs, tc.expectedErrors}, {"warnings", warnings, tc.expectedWarnings}} {
+				if len(c.got) != len(c.expected) {
+					t.Fatalf("got %s %q, expected %d %s", c.kind, c.got, len(c.expected), c.kind)
+				}
+				for i, e := range c.expected {
+					if !strings.Contains(c.got[i], e) {
+						t.Errorf("got %q, expected it to contain %q", c.got[i], e)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// metadataPrefetchMaxOpsPerSecondAnnotation limits the directory listings per second of the metadata prefetch sidecar container,
+// which slows down further while gcsfuse is busy serving the workload, so that the cache warmup does not add latency to the workload reads.
+const metadataPrefetchMaxOpsPerSecondAnnotation = "gke-gcsfuse/metadata-prefetch-max-ops-per-second"
+
+// metadataPrefetchIOTokenPortAnnotation makes the sidecar container serve a token bucket on the loopback port of its value,
+// which the metadata prefetch sidecar container shares with the file system operations that gcsfuse serves to the workload.
+// The bucket allows the operations per second of metadataPrefetchMaxOpsPerSecondAnnotation to the workload and the prefetch together,
+// so that the prefetch only uses the capacity that the workload leaves. It cannot be used by Pods in the host network, whose loopback
+// interface is shared by the node.
+const metadataPrefetchIOTokenPortAnnotation = "gke-gcsfuse/metadata-prefetch-io-token-port"
+
+// metadataPrefetchConcurrencyAnnotation limits the listings and stats that the metadata prefetch sidecar container sends to gcsfuse at the same time,
+// so that the prefetch leaves gcsfuse threads to the workload I/O.
+const metadataPrefetchConcurrencyAnnotation = "gke-gcsfuse/metadata-prefetch-concurrency"
+
+// metadataPrefetchIncludePathsAnnotation and metadataPrefetchExcludePathsAnnotation restrict the metadata prefetch sidecar container
+// to the directories that the workload reads, with comma-separated path patterns relative to the root of each volume.
+const (
+	metadataPrefetchIncludePathsAnnotation = "gke-gcsfuse/metadata-prefetch-include-paths"
+	metadataPrefetchExcludePathsAnnotation = "gke-gcsfuse/metadata-prefetch-exclude-paths"
+)
+
+// metadataPrefetchMaxDepthAnnotation limits the directory levels of each volume that the metadata prefetch sidecar container lists.
+const metadataPrefetchMaxDepthAnnotation = "gke-gcsfuse/metadata-prefetch-max-depth"
+
+// metadataPrefetchMaxEntriesAnnotation and metadataPrefetchMemoryBudgetMBAnnotation limit the entries that each walk of the metadata prefetch
+// sidecar container lists, so that the prefetch of very large buckets does not fill the gcsfuse metadata caches beyond the sidecar container memory.
+const (
+	metadataPrefetchMaxEntriesAnnotation     = "gke-gcsfuse/metadata-prefetch-max-entries"
+	metadataPrefetchMemoryBudgetMBAnnotation = "gke-gcsfuse/metadata-prefetch-memory-budget-mb"
+)
+
+// metadataPrefetchRefreshIntervalAnnotation makes the metadata prefetch sidecar container walk the volumes again after the interval,
+// so that the metadata caches of long-running workloads stay warm after their TTL expires.
+const metadataPrefetchRefreshIntervalAnnotation = "gke-gcsfuse/metadata-prefetch-refresh-interval"
+
+// metadataPrefetchStatFilesAnnotation makes the metadata prefetch sidecar container stat every file it lists, so that the stat cache
+// is warm for the first open of each file by the workload.
+const metadataPrefetchStatFilesAnnotation = "gke-gcsfuse/metadata-prefetch-stat-files"
+
+// metadataPrefetchMetricsPortAnnotation makes the metadata prefetch sidecar container serve the Prometheus metrics of its progress on the port,
+// so that users can tell when the cache warmup is complete, and alert when it fails.
+const metadataPrefetchMetricsPortAnnotation = "gke-gcsfuse/metadata-prefetch-metrics-port"
+
+// metadataPrefetchReadinessPortAnnotation makes the metadata prefetch sidecar container serve the /ready path on the port,
+// which succeeds once the first walk of the volumes completed, so that the workload can wait for the metadata caches to be warm.
+const metadataPrefetchReadinessPortAnnotation = "gke-gcsfuse/metadata-prefetch-readiness-port"
+
+// metadataPrefetchVolumesAnnotation selects the volumes of the Pod whose metadata the metadata prefetch sidecar container prefetches,
+// with comma-separated volume names, overriding the gcsfuseMetadataPrefetchOnMount volume attribute of the volumes.
+const metadataPrefetchVolumesAnnotation = "gke-gcsfuse/metadata-prefetch-volumes"
+
+// metadataPrefetchVolumes returns the names of the volumes that the Pod annotation selects for the metadata prefetch,
+// or false if the annotation is not set.
+func metadataPrefetchVolumes(pod *corev1.Pod) ([]string, bool) {
+	value, ok := pod.Annotations[metadataPrefetchVolumesAnnotation]
+	if !ok {
+		return nil, false
+	}
+
+	volumes := []string{}
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			volumes = append(volumes, name)
+		}
+	}
+
+	return volumes, true
+}
+
+// applyMetadataPrefetchVolumes validates the volume names of the Pod annotation, and passes the selected volumes that the
+// metadata prefetch sidecar container mounts to it.
+func applyMetadataPrefetchVolumes(pod *corev1.Pod, container *corev1.Container) error {
+	volumes, ok := metadataPrefetchVolumes(pod)
+	if !ok {
+		return nil
+	}
+
+	for _, name := range volumes {
+		if !slices.ContainsFunc(pod.Spec.Volumes, func(v corev1.Volume) bool { return v.Name == name }) {
+			return fmt.Errorf("the value of %q names volume %q, which is not a volume of the Pod", metadataPrefetchVolumesAnnotation, name)
+		}
+	}
+
+	mounted := []string{}
+	for _, m := range container.VolumeMounts {
+		mounted = append(mounted, m.Name)
+	}
+	if len(mounted) > 0 {
+		container.Args = append(container.Args, "--volumes="+strings.Join(mounted, ","))
+	}
+
+	return nil
+}
+
+// applyMetadataPrefetchThrottle passes the rate and concurrency limits of the Pod annotations to the metadata prefetch sidecar container.
+func applyMetadataPrefetchThrottle(pod *corev1.Pod, container *corev1.Container) error {
+	if value, ok := pod.Annotations[metadataPrefetchMaxOpsPerSecondAnnotation]; ok {
+		if ops, err := strconv.Atoi(value); err != nil || ops < 1 {
+			return fmt.Errorf("the value of %q must be a positive integer, got %q", metadataPrefetchMaxOpsPerSecondAnnotation, value)
+		}
+		container.Args = append(container.Args, "--max-ops-per-second="+value)
+	}
+	if port, ok := pod.Annotations[metadataPrefetchIOTokenPortAnnotation]; ok {
+		container.Args = append(container.Args, "--io-token-port="+port)
+	}
+
+	if value, ok := pod.Annotations[metadataPrefetchConcurrencyAnnotation]; ok {
+		if concurrency, err := strconv.Atoi(value); err != nil || concurrency < 1 {
+			return fmt.Errorf("the value of %q must be a positive integer, got %q", metadataPrefetchConcurrencyAnnotation, value)
+		}
+		container.Args = append(container.Args, "--concurrency="+value)
+	}
+
+	return nil
+}
+
+// applyIOTokenBucket makes the sidecar container serve the token bucket that the metadata prefetch shares with the workload,
+// if the Pod annotation sets its port. The port is refused for Pods in the host network, where it would collide with the ports
+// of the other hostNetwork Pods on the node and let any process on the node take the tokens.
+func applyIOTokenBucket(pod *corev1.Pod, container *corev1.Container) error {
+	port, ok := pod.Annotations[metadataPrefetchIOTokenPortAnnotation]
+	if !ok {
+		return nil
+	}
+
+	if pod.Spec.HostNetwork {
+		return fmt.Errorf("%q cannot be used by Pods with hostNetwork enabled", metadataPrefetchIOTokenPortAnnotation)
+	}
+	if err := validateLoopbackPort(pod, metadataPrefetchIOTokenPortAnnotation, port); err != nil {
+		return err
+	}
+	if port == pod.Annotations[uploadBarrierPortAnnotation] {
+		return fmt.Errorf("the port %v set by %q is already used by %q", port, metadataPrefetchIOTokenPortAnnotation, uploadBarrierPortAnnotation)
+	}
+	ops, ok := pod.Annotations[metadataPrefetchMaxOpsPerSecondAnnotation]
+	if !ok {
+		return fmt.Errorf("%q requires %q to set the rate of the token bucket", metadataPrefetchIOTokenPortAnnotation, metadataPrefetchMaxOpsPerSecondAnnotation)
+	}
+	if n, err := strconv.Atoi(ops); err != nil || n < 1 {
+		return fmt.Errorf("the value of %q must be a positive integer, got %q", metadataPrefetchMaxOpsPerSecondAnnotation, ops)
+	}
+	container.Args = append(container.Args, "--io-token-port="+port, "--io-ops-per-second="+ops)
+
+	return nil
+}
+
+// applyMetadataPrefetchMaxDepth passes the depth limit of the Pod annotation to the metadata prefetch sidecar container.
+func applyMetadataPrefetchMaxDepth(pod *corev1.Pod, container *corev1.Container) error {
+	value, ok := pod.Annotations[metadataPrefetchMaxDepthAnnotation]
+	if !ok {
+		return nil
+	}
+
+	if depth, err := strconv.Atoi(value); err != nil || depth < 1 {
+		return fmt.Errorf("the value of %q must be a positive integer, got %q", metadataPrefetchMaxDepthAnnotation, value)
+	}
+	container.Args = append(container.Args, "--max-depth="+value)
+
+	return nil
+}
+
+// applyMetadataPrefetchBudget passes the entry and memory limits of the Pod annotations to the metadata prefetch sidecar container.
+func applyMetadataPrefetchBudget(pod *corev1.Pod, container *corev1.Container) error {
+	for _, a := range []struct{ annotation, flag string }{
+		{metadataPrefetchMaxEntriesAnnotation, "--max-entries"},
+		{metadataPrefetchMemoryBudgetMBAnnotation, "--memory-budget-mb"},
+	} {
+		value, ok := pod.Annotations[a.annotation]
+		if !ok {
+			continue
+		}
+
+		if limit, err := strconv.Atoi(value); err != nil || limit < 1 {
+			return fmt.Errorf("the value of %q must be a positive integer, got %q", a.annotation, value)
+		}
+		container.Args = append(container.Args, a.flag+"="+value)
+	}
+
+	return nil
+}
+
+// applyMetadataPrefetchRefreshInterval passes the refresh interval of the Pod annotation to the metadata prefetch sidecar container.
+func applyMetadataPrefetchRefreshInterval(pod *corev1.Pod, container *corev1.Container) error {
+	value, ok := pod.Annotations[metadataPrefetchRefreshIntervalAnnotation]
+	if !ok {
+		return nil
+	}
+
+	if interval, err := time.ParseDuration(value); err != nil || interval <= 0 {
+		return fmt.Errorf("the value of %q must be a positive duration, such as 1h, got %q", metadataPrefetchRefreshIntervalAnnotation, value)
+	}
+	container.Args = append(container.Args, "--refresh-interval="+value)
+
+	return nil
+}
+
+// applyMetadataPrefetchStatFiles passes the stat files mode of the Pod annotation to the metadata prefetch sidecar container.
+func applyMetadataPrefetchStatFiles(pod *corev1.Pod, container *corev1.Container) error {
+	value, ok := pod.Annotations[metadataPrefetchStatFilesAnnotation]
+	if !ok {
+		return nil
+	}
+
+	statFiles, err := ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("the value of %q is invalid: %w", metadataPrefetchStatFilesAnnotation, err)
+	}
+	if statFiles {
+		container.Args = append(container.Args, "--stat-files")
+	}
+
+	return nil
+}
+
+// applyMetadataPrefetchPorts passes the metrics and readiness ports of the Pod annotations to the metadata prefetch sidecar container.
+// Both annotations can set the same port, where the metadata prefetch serves both paths.
+func applyMetadataPrefetchPorts(pod *corev1.Pod, container *corev1.Container) error {
+	for _, a := range []struct{ annotation, flag string }{
+		{metadataPrefetchMetricsPortAnnotation, "--metrics-address"},
+		{metadataPrefetchReadinessPortAnnotation, "--readiness-address"},
+	} {
+		value, ok := pod.Annotations[a.annotation]
+		if !ok {
+			continue
+		}
+
+		if port, err := strconv.Atoi(value); err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("the value of %q must be a port number between 1 and 65535, got %q", a.annotation, value)
+		}
+		container.Args = append(container.Args, a.flag+"=:"+value)
+	}
+
+	return nil
+}
+
+// applyMetadataPrefetchPaths passes the path patterns of the Pod annotations to the metadata prefetch sidecar container.
+func applyMetadataPrefetchPaths(pod *corev1.Pod, container *corev1.Container) error {
+	for _, a := range []struct{ annotation, flag string }{
+		{metadataPrefetchIncludePathsAnnotation, "--include-paths"},
+		{metadataPrefetchExcludePathsAnnotation, "--exclude-paths"},
+	} {
+		value, ok := pod.Annotations[a.annotation]
+		if !ok {
+			continue
+		}
+
+		for _, pattern := range strings.Split(value, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern == "" {
+				continue
+			}
+			if err := ValidatePrefetchPattern(pattern); err != nil {
+				return fmt.Errorf("the value of %q is invalid: %w", a.annotation, err)
+			}
+		}
+		container.Args = append(container.Args, a.flag+"="+value)
+	}
+
+	return nil
+}
+
+// ValidatePrefetchPattern returns an error if the pattern is not a relative path pattern of path.Match.
+// The webhook validates the patterns of the Pod annotations with it, and the metadata prefetch sidecar container the patterns of its flags.
+func ValidatePrefetchPattern(pattern string) error {
+	if pattern == "" || path.IsAbs(pattern) {
+		return fmt.Errorf("path pattern %q must be a relative path", pattern)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid path pattern %q: %w", pattern, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,577 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestApplyMetadataPrefetchThrottle(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		annotations  map[string]string
+		expectedArgs []string
+		expectErr    bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:         "annotation sets the rate",
+			annotations:  map[string]string{metadataPrefetchMaxOpsPerSecondAnnotation: "20"},
+			expectedArgs: []string{"--max-ops-per-second=20"},
+		},
+		{
+			name:        "zero rate",
+			annotations: map[string]string{metadataPrefetchMaxOpsPerSecondAnnotation: "0"},
+			expectErr:   true,
+		},
+		{
+			name:        "invalid rate",
+			annotations: map[string]string{metadataPrefetchMaxOpsPerSecondAnnotation: "fast"},
+			expectErr:   true,
+		},
+		{
+			name:         "annotation sets the concurrency",
+			annotations:  map[string]string{metadataPrefetchConcurrencyAnnotation: "4"},
+			expectedArgs: []string{"--concurrency=4"},
+		},
+		{
+			name:         "annotations set the rate and the concurrency",
+			annotations:  map[string]string{metadataPrefetchMaxOpsPerSecondAnnotation: "20", metadataPrefetchConcurrencyAnnotation: "4"},
+			expectedArgs: []string{"--max-ops-per-second=20", "--concurrency=4"},
+		},
+		{
+			name:         "annotations set the rate and the io token port",
+			annotations:  map[string]string{metadataPrefetchMaxOpsPerSecondAnnotation: "20", metadataPrefetchIOTokenPortAnnotation: "9931"},
+			expectedArgs: []string{"--max-ops-per-second=20", "--io-token-port=9931"},
+		},
+		{
+			name:        "zero concurrency",
+			annotations: map[string]string{metadataPrefetchConcurrencyAnnotation: "0"},
+			expectErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+			container := corev1.Container{Name: MetadataPrefetchSidecarName}
+
+			err := applyMetadataPrefetchThrottle(pod, &container)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectErr)
+			}
+			if diff := cmp.Diff(tc.expectedArgs, container.Args); diff != "" {
+				t.Errorf("unexpected args (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestApplyIOTokenBucket(t *testing.T) {
+	t.Parallel()
+
+	workload := corev1.Container{Name: "workload", Ports: []corev1.ContainerPort{{ContainerPort: 8080}}}
+	testCases := []struct {
+		name         string
+		annotations  map[string]string
+		hostNetwork  bool
+		expectedArgs []string
+		expectErr    bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:        "rate without the port",
+			annotations: map[string]string{metadataPrefetchMaxOpsPerSecondAnnotation: "20"},
+		},
+		{
+			name:         "annotations set the port and the rate",
+			annotations:  map[string]string{metadataPrefetchIOTokenPortAnnotation: "9931", metadataPrefetchMaxOpsPerSecondAnnotation: "20"},
+			expectedArgs: []string{"--io-token-port=9931", "--io-ops-per-second=20"},
+		},
+		{
+			name:        "port without the rate",
+			annotations: map[string]string{metadataPrefetchIOTokenPortAnnotation: "9931"},
+			expectErr:   true,
+		},
+		{
+			name:        "invalid rate",
+			annotations: map[string]string{metadataPrefetchIOTokenPortAnnotation: "9931", metadataPrefetchMaxOpsPerSecondAnnotation: "fast"},
+			expectErr:   true,
+		},
+		{
+			name:        "invalid port",
+			annotations: map[string]string{metadataPrefetchIOTokenPortAnnotation: "0", metadataPrefetchMaxOpsPerSecondAnnotation: "20"},
+			expectErr:   true,
+		},
+		{
+			name:        "port used by a workload container",
+			annotations: map[string]string{metadataPrefetchIOTokenPortAnnotation: "8080", metadataPrefetchMaxOpsPerSecondAnnotation: "20"},
+			expectErr:   true,
+		},
+		{
+			name: "port used by the upload barrier",
+			annotations: map[string]string{
+				metadataPrefetchIOTokenPortAnnotation:     "9930",
+				metadataPrefetchMaxOpsPerSecondAnnotation: "20",
+				uploadBarrierPortAnnotation:               "9930",
+			},
+			expectErr: true,
+		},
+		{
+			name:        "host network",
+			annotations: map[string]string{metadataPrefetchIOTokenPortAnnotation: "9931", metadataPrefetchMaxOpsPerSecondAnnotation: "20"},
+			hostNetwork: true,
+			expectErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{workload}, HostNetwork: tc.hostNetwork},
+			}
+			container := corev1.Container{}
+
+			err := applyIOTokenBucket(pod, &container)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectErr)
+			}
+			if diff := cmp.Diff(tc.expectedArgs, container.Args); diff != "" {
+				t.Errorf("unexpected args (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestApplyMetadataPrefetchMaxDepth(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		annotations  map[string]string
+		expectedArgs []string
+		expectErr    bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:         "annotation sets the depth",
+			annotations:  map[string]string{metadataPrefetchMaxDepthAnnotation: "3"},
+			expectedArgs: []string{"--max-depth=3"},
+		},
+		{
+			name:        "zero depth",
+			annotations: map[string]string{metadataPrefetchMaxDepthAnnotation: "0"},
+			expectErr:   true,
+		},
+		{
+			name:        "invalid depth",
+			annotations: map[string]string{metadataPrefetchMaxDepthAnnotation: "deep"},
+			expectErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+			container := corev1.Container{Name: MetadataPrefetchSidecarName}
+
+			err := applyMetadataPrefetchMaxDepth(pod, &container)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectErr)
+			}
+			if diff := cmp.Diff(tc.expectedArgs, container.Args); diff != "" {
+				t.Errorf("unexpected args (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestApplyMetadataPrefetchBudget(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		annotations  map[string]string
+		expectedArgs []string
+		expectErr    bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:         "annotation sets the maximum entries",
+			annotations:  map[string]string{metadataPrefetchMaxEntriesAnnotation: "1000000"},
+			expectedArgs: []string{"--max-entries=1000000"},
+		},
+		{
+			name:         "annotations set the maximum entries and the memory budget",
+			annotations:  map[string]string{metadataPrefetchMaxEntriesAnnotation: "1000000", metadataPrefetchMemoryBudgetMBAnnotation: "512"},
+			expectedArgs: []string{"--max-entries=1000000", "--memory-budget-mb=512"},
+		},
+		{
+			name:        "zero entries",
+			annotations: map[string]string{metadataPrefetchMaxEntriesAnnotation: "0"},
+			expectErr:   true,
+		},
+		{
+			name:        "invalid memory budget",
+			annotations: map[string]string{metadataPrefetchMemoryBudgetMBAnnotation: "1Gi"},
+			expectErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+			container := corev1.Container{Name: MetadataPrefetchSidecarName}
+
+			err := applyMetadataPrefetchBudget(pod, &container)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectErr)
+			}
+			if diff := cmp.Diff(tc.expectedArgs, container.Args); diff != "" {
+				t.Errorf("unexpected args (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestApplyMetadataPrefetchRefreshInterval(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		annotations  map[string]string
+		expectedArgs []string
+		expectErr    bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:         "annotation sets the interval",
+			annotations:  map[string]string{metadataPrefetchRefreshIntervalAnnotation: "1h30m"},
+			expectedArgs: []string{"--refresh-interval=1h30m"},
+		},
+		{
+			name:        "zero interval",
+			annotations: map[string]string{metadataPrefetchRefreshIntervalAnnotation: "0s"},
+			expectErr:   true,
+		},
+		{
+			name:        "interval without unit",
+			annotations: map[string]string{metadataPrefetchRefreshIntervalAnnotation: "3600"},
+			expectErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+			container := corev1.Container{Name: MetadataPrefetchSidecarName}
+
+			err := applyMetadataPrefetchRefreshInterval(pod, &container)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectErr)
+			}
+			if diff := cmp.Diff(tc.expectedArgs, container.Args); diff != "" {
+				t.Errorf("unexpected args (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestApplyMetadataPrefetchPorts(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		annotations  map[string]string
+		expectedArgs []string
+		expectErr    bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:         "annotation sets the port",
+			annotations:  map[string]string{metadataPrefetchMetricsPortAnnotation: "9920"},
+			expectedArgs: []string{"--metrics-address=:9920"},
+		},
+		{
+			name:        "port out of range",
+			annotations: map[string]string{metadataPrefetchMetricsPortAnnotation: "65536"},
+			expectErr:   true,
+		},
+		{
+			name:        "named port",
+			annotations: map[string]string{metadataPrefetchMetricsPortAnnotation: "metrics"},
+			expectErr:   true,
+		},
+		{
+			name:         "annotation sets the readiness port",
+			annotations:  map[string]string{metadataPrefetchReadinessPortAnnotation: "9921"},
+			expectedArgs: []string{"--readiness-address=:9921"},
+		},
+		{
+			name:         "metrics and readiness on the same port",
+			annotations:  map[string]string{metadataPrefetchMetricsPortAnnotation: "9920", metadataPrefetchReadinessPortAnnotation: "9920"},
+			expectedArgs: []string{"--metrics-address=:9920", "--readiness-address=:9920"},
+		},
+		{
+			name:        "invalid readiness port",
+			annotations: map[string]string{metadataPrefetchReadinessPortAnnotation: "0"},
+			expectErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+			container := corev1.Container{Name: MetadataPrefetchSidecarName}
+
+			err := applyMetadataPrefetchPorts(pod, &container)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectErr)
+			}
+			if diff := cmp.Diff(tc.expectedArgs, container.Args); diff != "" {
+				t.Errorf("unexpected args (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestApplyMetadataPrefetchStatFiles(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		annotations  map[string]string
+		expectedArgs []string
+		expectErr    bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:         "annotation enables the stats",
+			annotations:  map[string]string{metadataPrefetchStatFilesAnnotation: "true"},
+			expectedArgs: []string{"--stat-files"},
+		},
+		{
+			name:        "annotation disables the stats",
+			annotations: map[string]string{metadataPrefetchStatFilesAnnotation: "False"},
+		},
+		{
+			name:        "invalid value",
+			annotations: map[string]string{metadataPrefetchStatFilesAnnotation: "yes"},
+			expectErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+			container := corev1.Container{Name: MetadataPrefetchSidecarName}
+
+			err := applyMetadataPrefetchStatFiles(pod, &container)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectErr)
+			}
+			if diff := cmp.Diff(tc.expectedArgs, container.Args); diff != "" {
+				t.Errorf("unexpected args (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestApplyMetadataPrefetchPaths(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		annotations  map[string]string
+		expectedArgs []string
+		expectErr    bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name: "include and exclude paths",
+			annotations: map[string]string{
+				metadataPrefetchIncludePathsAnnotation: "data/*,models",
+				metadataPrefetchExcludePathsAnnotation: "data/tmp",
+			},
+			expectedArgs: []string{"--include-paths=data/*,models", "--exclude-paths=data/tmp"},
+		},
+		{
+			name:         "exclude paths only",
+			annotations:  map[string]string{metadataPrefetchExcludePathsAnnotation: "*/checkpoints"},
+			expectedArgs: []string{"--exclude-paths=*/checkpoints"},
+		},
+		{
+			name:        "absolute path",
+			annotations: map[string]string{metadataPrefetchIncludePathsAnnotation: "/data"},
+			expectErr:   true,
+		},
+		{
+			name:         "empty patterns are ignored",
+			annotations:  map[string]string{metadataPrefetchExcludePathsAnnotation: "data, ,models"},
+			expectedArgs: []string{"--exclude-paths=data, ,models"},
+		},
+		{
+			name:        "malformed pattern",
+			annotations: map[string]string{metadataPrefetchIncludePathsAnnotation: "data/[a-"},
+			expectErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+			container := corev1.Container{Name: MetadataPrefetchSidecarName}
+
+			err := applyMetadataPrefetchPaths(pod, &container)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectErr)
+			}
+			if diff := cmp.Diff(tc.expectedArgs, container.Args); diff != "" {
+				t.Errorf("unexpected args (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestApplyMetadataPrefetchVolumes(t *testing.T) {
+	t.Parallel()
+
+	prefetchVolume := func(name, enabled string) corev1.Volume {
+		attributes := map[string]string{}
+		if enabled != "" {
+			attributes[gcsFuseMetadataPrefetchOnMountVolumeAttribute] = enabled
+		}
+
+		return corev1.Volume{
+			Name: name,
+			VolumeSource: corev1.VolumeSource{
+				CSI: &corev1.CSIVolumeSource{Driver: DefaultCSIDriverName, VolumeAttributes: attributes},
+			},
+		}
+	}
+	volumes := []corev1.Volume{
+		prefetchVolume("vol-a", ""),
+		prefetchVolume("vol-b", "true"),
+		prefetchVolume("vol-c", "false"),
+		{Name: "scratch", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+	}
+
+	testCases := []struct {
+		name          string
+		annotations   map[string]string
+		expectedMount []string
+		expectedArgs  []string
+		expectErr     bool
+	}{
+		{
+			name:          "no annotation uses the volume attributes",
+			expectedMount: []string{"vol-b"},
+		},
+		{
+			name:          "annotation opts volumes in and out",
+			annotations:   map[string]string{metadataPrefetchVolumesAnnotation: "vol-a, vol-c"},
+			expectedMount: []string{"vol-a", "vol-c"},
+			expectedArgs:  []string{"--volumes=vol-a,vol-c"},
+		},
+		{
+			name:          "non gcsfuse volume is not prefetched",
+			annotations:   map[string]string{metadataPrefetchVolumesAnnotation: "vol-a,scratch"},
+			expectedMount: []string{"vol-a"},
+			expectedArgs:  []string{"--volumes=vol-a"},
+		},
+		{
+			name:        "empty annotation opts all the volumes out",
+			annotations: map[string]string{metadataPrefetchVolumesAnnotation: ""},
+		},
+		{
+			name:          "unknown volume",
+			annotations:   map[string]string{metadataPrefetchVolumesAnnotation: "vol-a,vol-d"},
+			expectedMount: []string{"vol-a"},
+			expectErr:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations},
+				Spec:       corev1.PodSpec{Volumes: volumes},
+			}
+			si := &SidecarInjector{}
+			container := si.GetMetadataPrefetchSidecarContainerSpec(pod, getDefaultMetadataPrefetchConfig("fake-image"))
+			mounted := []string{}
+			for _, m := range container.VolumeMounts {
+				mounted = append(mounted, m.Name)
+			}
+			if diff := cmp.Diff(tc.expectedMount, mounted, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("unexpected volume mounts (-want +got):\n%s", diff)
+			}
+
+			args := len(container.Args)
+			err := applyMetadataPrefetchVolumes(pod, &container)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectErr)
+			}
+			if diff := cmp.Diff(tc.expectedArgs, container.Args[args:], cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("unexpected args (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
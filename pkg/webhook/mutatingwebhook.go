@@ -22,11 +22,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
+	"time"
 
 	"cloud.google.com/go/compute/metadata"
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/version"
+	nodev1 "k8s.io/client-go/kubernetes/typed/node/v1"
 	listersv1 "k8s.io/client-go/listers/core/v1"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -44,6 +47,7 @@ const (
 	ephemeralStorageRequestAnnotation       = "gke-gcsfuse/ephemeral-storage-request"
 	metadataPrefetchMemoryLimitAnnotation   = "gke-gcsfuse/metadata-prefetch-memory-limit"
 	metadataPrefetchMemoryRequestAnnotation = "gke-gcsfuse/metadata-prefetch-memory-request"
+	imagePullPolicyAnnotation               = "gke-gcsfuse/image-pull-policy"
 )
 
 type SidecarInjector struct {
@@ -56,6 +60,30 @@ type SidecarInjector struct {
 	PvcLister              listersv1.PersistentVolumeClaimLister
 	PvLister               listersv1.PersistentVolumeLister
 	ServerVersion          *version.Version
+	// LookupCacheTTL is how long the results of cluster-wide lookups, such as the
+	// native sidecar support and the project ID, are reused across admission requests.
+	LookupCacheTTL time.Duration
+	// DriverName is the name of the CSI driver whose volumes are served by the injected sidecar container.
+	// It defaults to gcsfuse.csi.storage.gke.io when empty.
+	DriverName string
+	// NamespaceLister looks up the namespace annotations that override the default image pull policy. It is optional.
+	NamespaceLister listersv1.NamespaceLister
+	// OOMProtectionPriorityClasses are the priority classes of the Pods whose sidecar container memory request is raised
+	// to the highest memory request of the workload containers, so that the workload containers are OOM killed first.
+	// The gke-gcsfuse/oom-protection Pod annotation overrides it.
+	OOMProtectionPriorityClasses []string
+	// SandboxedRuntimeHandlers are the RuntimeClass handlers that run the Pods in a sandbox, such as runsc of gVisor, where the sidecar
+	// container cannot serve the volumes. The Pods whose RuntimeClass has one of these handlers and that request the sidecar container
+	// are rejected. It is empty by default, which admits them.
+	SandboxedRuntimeHandlers []string
+	// RuntimeClasses looks up the handlers of the RuntimeClasses of the Pods for SandboxedRuntimeHandlers. It is optional.
+	RuntimeClasses nodev1.RuntimeClassInterface
+
+	lookups lookupCache
+	// defaultAnnotations are the default Pod annotations of the webhook config file.
+	defaultAnnotations []DefaultAnnotations
+	// configMux guards Config, MetadataPrefetchConfig and defaultAnnotations, which are replaced when the webhook config file is reloaded.
+	configMux sync.RWMutex
 }
 
 // Handle injects a gcsfuse sidecar container and a emptyDir to incoming qualified pods.
@@ -89,10 +117,21 @@ func (si *SidecarInjector) Handle(ctx context.Context, req admission.Request) ad
 		return admission.Allowed(fmt.Sprintf("found annotation '%v: false' for Pod: Name %q, GenerateName %q, Namespace %q, no injection required.", GcsFuseVolumeEnableAnnotation, pod.Name, pod.GenerateName, pod.Namespace))
 	}
 
+	// The Pods with a manually injected sidecar container are checked too.
+	if err := si.validateSandbox(ctx, pod); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
 	sidecarInjected, _ := ValidatePodHasSidecarContainerInjected(pod)
 	if sidecarInjected {
 		return admission.Allowed("The sidecar container was injected, no injection required.")
 	}
+	// Apply the default annotations before the annotations are read.
+	if applied := si.applyDefaultAnnotations(pod, req.Namespace); len(applied) > 0 {
+		klog.Infof("applied the default annotations %v to Pod: Name %q, GenerateName %q, Namespace %q", applied, pod.Name, pod.GenerateName, req.Namespace)
+	}
+	// Collect the warnings before the sidecar containers and volumes are injected.
+	warnings := si.workloadWarnings(pod)
+
 	// Check support for native sidecar.
 	injectAsNativeSidecar, err := si.injectAsNativeSidecar(pod)
 	if err != nil {
@@ -108,8 +147,8 @@ func (si *SidecarInjector) Handle(ctx context.Context, req admission.Request) ad
 		return admission.Errored(http.StatusBadRequest, err)
 	}
 	// Inject service account volume
-	if si.Config.ShouldInjectSAVolume && pod.Spec.HostNetwork {
-		projectID, err := metadata.ProjectIDWithContext(ctx)
+	if config, _ := si.getDefaultConfig(sidecarPrefixMap[GcsFuseSidecarName]); config.ShouldInjectSAVolume && pod.Spec.HostNetwork {
+		projectID, err := si.projectID(ctx)
 		if err != nil {
 			return admission.Errored(http.StatusInternalServerError, fmt.Errorf("failed to get project id: %w", err))
 		}
@@ -132,5 +171,19 @@ func (si *SidecarInjector) Handle(ctx context.Context, req admission.Request) ad
 		return admission.Errored(http.StatusBadRequest, fmt.Errorf("failed to marshal pod: %w", err))
 	}
 
-	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod).WithWarnings(warnings...)
+}
+
+// projectID returns the project ID from the metadata server, coalescing concurrent lookups.
+func (si *SidecarInjector) projectID(ctx context.Context) (string, error) {
+	v, err := si.lookups.get(ctx, projectIDLookupKey, si.LookupCacheTTL, func(ctx context.Context) (interface{}, error) {
+		return metadata.ProjectIDWithContext(ctx)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	projectID, _ := v.(string)
+
+	return projectID, nil
 }
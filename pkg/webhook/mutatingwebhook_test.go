@@ -303,6 +303,35 @@ func TestPrepareConfig(t *testing.T) {
 			},
 			expectErr: false,
 		},
+		{
+			name:   "image pull policy is specified",
+			prefix: sidecarPrefixMap[GcsFuseSidecarName],
+			annotations: map[string]string{
+				GcsFuseVolumeEnableAnnotation: "true",
+				imagePullPolicyAnnotation:     "IfNotPresent",
+			},
+			wantConfig: &Config{
+				ContainerImage:          FakeConfig().ContainerImage,
+				ImagePullPolicy:         "IfNotPresent",
+				CPULimit:                FakeConfig().CPULimit,
+				CPURequest:              FakeConfig().CPURequest,
+				MemoryLimit:             FakeConfig().MemoryLimit,
+				MemoryRequest:           FakeConfig().MemoryRequest,
+				EphemeralStorageLimit:   FakeConfig().EphemeralStorageLimit,
+				EphemeralStorageRequest: FakeConfig().EphemeralStorageRequest,
+			},
+			expectErr: false,
+		},
+		{
+			name:   "invalid image pull policy should throw error",
+			prefix: sidecarPrefixMap[GcsFuseSidecarName],
+			annotations: map[string]string{
+				GcsFuseVolumeEnableAnnotation: "true",
+				imagePullPolicyAnnotation:     "Sometimes",
+			},
+			wantConfig: nil,
+			expectErr:  true,
+		},
 		{
 			name:   "invalid resource Quantity should throw error",
 			prefix: sidecarPrefixMap[GcsFuseSidecarName],
@@ -338,6 +367,64 @@ func TestPrepareConfig(t *testing.T) {
 	}
 }
 
+func TestPrepareConfigNamespaceImagePullPolicy(t *testing.T) {
+	t.Parallel()
+
+	fakeClient := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "dev", Annotations: map[string]string{imagePullPolicyAnnotation: "Always"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "prod"}},
+	)
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(fakeClient, time.Second*1, informers.WithNamespace(metav1.NamespaceAll))
+	si := SidecarInjector{
+		Config:                 LoadConfig(FakeConfig().ContainerImage, "IfNotPresent", "250m", "250m", "256Mi", "256Mi", "5Gi", "5Gi"),
+		MetadataPrefetchConfig: FakePrefetchConfig(),
+		NamespaceLister:        informerFactory.Core().V1().Namespaces().Lister(),
+	}
+	stopCh := make(<-chan struct{})
+	informerFactory.Start(stopCh)
+	informerFactory.WaitForCacheSync(stopCh)
+
+	testCases := []struct {
+		name           string
+		namespace      string
+		annotations    map[string]string
+		wantPullPolicy string
+	}{
+		{
+			name:           "namespace without the annotation uses the default",
+			namespace:      "prod",
+			wantPullPolicy: "IfNotPresent",
+		},
+		{
+			name:           "unknown namespace uses the default",
+			namespace:      "unknown",
+			wantPullPolicy: "IfNotPresent",
+		},
+		{
+			name:           "namespace annotation overrides the default",
+			namespace:      "dev",
+			wantPullPolicy: "Always",
+		},
+		{
+			name:           "pod annotation overrides the namespace annotation",
+			namespace:      "dev",
+			annotations:    map[string]string{imagePullPolicyAnnotation: "Never"},
+			wantPullPolicy: "Never",
+		},
+	}
+
+	for _, tc := range testCases {
+		pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: tc.namespace, Annotations: tc.annotations}}
+		config, err := si.prepareConfig(sidecarPrefixMap[GcsFuseSidecarName], pod)
+		if err != nil {
+			t.Fatalf(`for "%s", unexpected error: %v`, tc.name, err)
+		}
+		if config.ImagePullPolicy != tc.wantPullPolicy {
+			t.Errorf(`for "%s", got image pull policy %q, but want %q`, tc.name, config.ImagePullPolicy, tc.wantPullPolicy)
+		}
+	}
+}
+
 func TestValidateMutatingWebhookResponse(t *testing.T) {
 	t.Parallel()
 
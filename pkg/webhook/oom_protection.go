@@ -0,0 +1,108 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+)
+
+// oomProtectionAnnotation overrides whether the sidecar container is protected from the OOM killer,
+// regardless of the priority class of the Pod.
+const oomProtectionAnnotation = "gke-gcsfuse/oom-protection"
+
+// oomProtectionEnabled reports whether the sidecar container of the Pod should be protected from the OOM killer.
+// It is enabled for Pods in the priority classes of si.OOMProtectionPriorityClasses, unless the Pod annotation disables it.
+func (si *SidecarInjector) oomProtectionEnabled(pod *corev1.Pod) (bool, error) {
+	if value, ok := pod.Annotations[oomProtectionAnnotation]; ok {
+		enabled, err := ParseBool(value)
+		if err != nil {
+			return false, fmt.Errorf("the acceptable values for %q are 'True', 'true', 'false' or 'False', got %q", oomProtectionAnnotation, value)
+		}
+
+		return enabled, nil
+	}
+
+	return pod.Spec.PriorityClassName != "" && slices.Contains(si.OOMProtectionPriorityClasses, pod.Spec.PriorityClassName), nil
+}
+
+// applyOOMProtection raises the memory request of the sidecar container to the highest memory request of the workload containers,
+// and the memory limit if it is lower. The kubelet sets the oom_score_adj of each container of a Burstable Pod from its memory request,
+// the higher the request the lower the score, so under node memory pressure the kernel kills a workload container before the sidecar
+// container. A killed workload container only restarts itself, while a killed sidecar container fails the I/O of all the containers
+// that use the volumes.
+func applyOOMProtection(pod *corev1.Pod, container *corev1.Container) {
+	// All the containers of a Guaranteed Pod have the same oom_score_adj, whatever their memory requests.
+	if isGuaranteed(slices.Concat(pod.Spec.InitContainers, pod.Spec.Containers, []corev1.Container{*container})) {
+		return
+	}
+
+	var highest resource.Quantity
+	for _, c := range workloadContainers(pod) {
+		if request, ok := c.Resources.Requests[corev1.ResourceMemory]; ok && request.Cmp(highest) > 0 {
+			highest = request
+		}
+	}
+
+	request := container.Resources.Requests[corev1.ResourceMemory]
+	if highest.Cmp(request) <= 0 {
+		return
+	}
+
+	if container.Resources.Requests == nil {
+		container.Resources.Requests = corev1.ResourceList{}
+	}
+	container.Resources.Requests[corev1.ResourceMemory] = highest
+	if limit, ok := container.Resources.Limits[corev1.ResourceMemory]; ok && highest.Cmp(limit) > 0 {
+		container.Resources.Limits[corev1.ResourceMemory] = highest
+	}
+	klog.Infof("raised the sidecar container memory request from %q to %q for Pod: Name %q, GenerateName %q, Namespace %q, so that the workload containers are OOM killed first", request.String(), highest.String(), pod.Name, pod.GenerateName, pod.Namespace)
+}
+
+// workloadContainers returns the containers that run alongside the sidecar container, including native sidecar containers.
+func workloadContainers(pod *corev1.Pod) []corev1.Container {
+	containers := slices.Clone(pod.Spec.Containers)
+	for _, c := range pod.Spec.InitContainers {
+		if c.RestartPolicy != nil && *c.RestartPolicy == corev1.ContainerRestartPolicyAlways {
+			containers = append(containers, c)
+		}
+	}
+
+	return containers
+}
+
+// isGuaranteed reports whether the containers have CPU and memory limits equal to their requests, as in the Guaranteed QoS class.
+func isGuaranteed(containers []corev1.Container) bool {
+	for _, c := range containers {
+		for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			limit, ok := c.Resources.Limits[name]
+			if !ok || limit.IsZero() {
+				return false
+			}
+			if request, ok := c.Resources.Requests[name]; ok && request.Cmp(limit) != 0 {
+				return false
+			}
+		}
+	}
+
+	return true
+}
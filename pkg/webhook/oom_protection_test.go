@@ -0,0 +1,166 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func TestOOMProtectionEnabled(t *testing.T) {
+	t.Parallel()
+
+	si := &SidecarInjector{OOMProtectionPriorityClasses: []string{"high-priority"}}
+	testCases := []struct {
+		name          string
+		priorityClass string
+		annotations   map[string]string
+		expected      bool
+		expectErr     bool
+	}{
+		{
+			name: "no priority class",
+		},
+		{
+			name:          "other priority class",
+			priorityClass: "low-priority",
+		},
+		{
+			name:          "protected priority class",
+			priorityClass: "high-priority",
+			expected:      true,
+		},
+		{
+			name:          "annotation disables protection",
+			priorityClass: "high-priority",
+			annotations:   map[string]string{oomProtectionAnnotation: "false"},
+		},
+		{
+			name:        "annotation enables protection",
+			annotations: map[string]string{oomProtectionAnnotation: "true"},
+			expected:    true,
+		},
+		{
+			name:        "invalid annotation",
+			annotations: map[string]string{oomProtectionAnnotation: "always"},
+			expectErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations},
+				Spec:       corev1.PodSpec{PriorityClassName: tc.priorityClass},
+			}
+			enabled, err := si.oomProtectionEnabled(pod)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectErr)
+			}
+			if enabled != tc.expected {
+				t.Errorf("got OOM protection %v, expected %v", enabled, tc.expected)
+			}
+		})
+	}
+}
+
+func TestApplyOOMProtection(t *testing.T) {
+	t.Parallel()
+
+	workload := func(memoryRequest, memoryLimit string) corev1.Container {
+		c := corev1.Container{Name: "workload", Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{}, Limits: corev1.ResourceList{}}}
+		if memoryRequest != "" {
+			c.Resources.Requests[corev1.ResourceMemory] = resource.MustParse(memoryRequest)
+		}
+		if memoryLimit != "" {
+			c.Resources.Limits[corev1.ResourceMemory] = resource.MustParse(memoryLimit)
+		}
+
+		return c
+	}
+	guaranteed := corev1.Container{Name: "workload", Resources: corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("4Gi")},
+		Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("4Gi")},
+	}}
+	nativeSidecar := workload("8Gi", "")
+	nativeSidecar.RestartPolicy = ptr.To(corev1.ContainerRestartPolicyAlways)
+
+	testCases := []struct {
+		name            string
+		containers      []corev1.Container
+		initContainers  []corev1.Container
+		expectedRequest string
+		expectedLimit   string
+	}{
+		{
+			name:            "workload request lower than sidecar request",
+			containers:      []corev1.Container{workload("128Mi", "")},
+			expectedRequest: "256Mi",
+			expectedLimit:   "256Mi",
+		},
+		{
+			name:            "workload without memory request",
+			containers:      []corev1.Container{workload("", "")},
+			expectedRequest: "256Mi",
+			expectedLimit:   "256Mi",
+		},
+		{
+			name:            "raises request and limit to the highest workload request",
+			containers:      []corev1.Container{workload("1Gi", ""), workload("2Gi", "4Gi")},
+			expectedRequest: "2Gi",
+			expectedLimit:   "2Gi",
+		},
+		{
+			name:            "native sidecar containers are workload containers",
+			containers:      []corev1.Container{workload("1Gi", "")},
+			initContainers:  []corev1.Container{nativeSidecar, workload("16Gi", "")},
+			expectedRequest: "8Gi",
+			expectedLimit:   "8Gi",
+		},
+		{
+			name:            "guaranteed Pod",
+			containers:      []corev1.Container{guaranteed},
+			expectedRequest: "256Mi",
+			expectedLimit:   "256Mi",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: tc.containers, InitContainers: tc.initContainers}}
+			container := GetSidecarContainerSpec(FakeConfig())
+
+			applyOOMProtection(pod, &container)
+
+			if got := container.Resources.Requests[corev1.ResourceMemory]; got.String() != tc.expectedRequest {
+				t.Errorf("got memory request %q, expected %q", got.String(), tc.expectedRequest)
+			}
+			if got := container.Resources.Limits[corev1.ResourceMemory]; got.String() != tc.expectedLimit {
+				t.Errorf("got memory limit %q, expected %q", got.String(), tc.expectedLimit)
+			}
+		})
+	}
+}
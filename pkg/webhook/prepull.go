@@ -0,0 +1,164 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	prepullDaemonSetNamePrefix = "gcsfusecsi-prepull-"
+	prepullAppLabel            = "gcsfusecsi-prepull"
+	// PrepullImagesHashLabel records the hash of the images pre-pulled by a DaemonSet.
+	PrepullImagesHashLabel = "gke-gcsfuse/prepull-images-hash"
+
+	DefaultPrepullPauseImage = "registry.k8s.io/pause:3.10"
+)
+
+// PrepullImage is a container image to pre-pull on nodes.
+// Command must exit successfully in the image, because the image is pulled by running it as an init container.
+type PrepullImage struct {
+	Image   string
+	Command []string
+}
+
+// SidecarPrepullImages returns the sidecar container images injected by the webhook.
+// The images are distroless, so the command runs a binary that is known to be present in each image.
+func SidecarPrepullImages(sidecarImage, metadataPrefetchSidecarImage string) []PrepullImage {
+	return []PrepullImage{
+		{Image: sidecarImage, Command: []string{"/gcsfuse", "--version"}},
+		{Image: metadataPrefetchSidecarImage, Command: []string{"/bin/ls"}},
+	}
+}
+
+// PrepullDaemonSet returns a DaemonSet that pulls the images on every node that matches the node selector.
+// The DaemonSet name contains a hash of the images, so a new DaemonSet is created for each image version.
+func PrepullDaemonSet(namespace string, images []PrepullImage, nodeSelector map[string]string, pauseImage string) *appsv1.DaemonSet {
+	hash := prepullImagesHash(images)
+	labels := map[string]string{
+		"app":                  prepullAppLabel,
+		PrepullImagesHashLabel: hash,
+	}
+
+	initContainers := make([]corev1.Container, 0, len(images))
+	for i, img := range images {
+		initContainers = append(initContainers, corev1.Container{
+			Name:            fmt.Sprintf("prepull-%d", i),
+			Image:           img.Image,
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			Command:         img.Command,
+			SecurityContext: GetSecurityContext(),
+			Resources:       prepullResources(),
+		})
+	}
+
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      prepullDaemonSetNamePrefix + hash,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					NodeSelector:                  nodeSelector,
+					InitContainers:                initContainers,
+					Containers:                    []corev1.Container{{Name: "pause", Image: pauseImage, SecurityContext: GetSecurityContext(), Resources: prepullResources()}},
+					Tolerations:                   []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+					AutomountServiceAccountToken:  ptr.To(false),
+					TerminationGracePeriodSeconds: ptr.To[int64](0),
+				},
+			},
+		},
+	}
+}
+
+// EnsurePrepullDaemonSet creates or updates the pre-pull DaemonSet, and deletes the pre-pull DaemonSets of other image versions.
+func EnsurePrepullDaemonSet(ctx context.Context, client kubernetes.Interface, ds *appsv1.DaemonSet) error {
+	daemonSets := client.AppsV1().DaemonSets(ds.Namespace)
+	existing, err := daemonSets.Get(ctx, ds.Name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		klog.Infof("creating image pre-pull DaemonSet %s/%s", ds.Namespace, ds.Name)
+		if _, err := daemonSets.Create(ctx, ds, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create DaemonSet %s/%s: %w", ds.Namespace, ds.Name, err)
+		}
+	case err != nil:
+		return fmt.Errorf("failed to get DaemonSet %s/%s: %w", ds.Namespace, ds.Name, err)
+	default:
+		existing.Spec.Template = ds.Spec.Template
+		if _, err := daemonSets.Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update DaemonSet %s/%s: %w", ds.Namespace, ds.Name, err)
+		}
+	}
+
+	list, err := daemonSets.List(ctx, metav1.ListOptions{LabelSelector: "app=" + prepullAppLabel})
+	if err != nil {
+		return fmt.Errorf("failed to list pre-pull DaemonSets in namespace %q: %w", ds.Namespace, err)
+	}
+
+	for _, old := range list.Items {
+		if old.Name == ds.Name || !strings.HasPrefix(old.Name, prepullDaemonSetNamePrefix) {
+			continue
+		}
+
+		klog.Infof("deleting image pre-pull DaemonSet %s/%s of previous images", old.Namespace, old.Name)
+		if err := daemonSets.Delete(ctx, old.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete DaemonSet %s/%s: %w", old.Namespace, old.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func prepullImagesHash(images []PrepullImage) string {
+	h := sha256.New()
+	for _, img := range images {
+		h.Write([]byte(img.Image))
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:10]
+}
+
+func prepullResources() corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("1m"),
+			corev1.ResourceMemory: resource.MustParse("8Mi"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceMemory: resource.MustParse("64Mi"),
+		},
+	}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPrepullDaemonSet(t *testing.T) {
+	t.Parallel()
+
+	images := SidecarPrepullImages("sidecar:v1", "prefetch:v1")
+	ds := PrepullDaemonSet("test-ns", images, map[string]string{"pool": "inference"}, DefaultPrepullPauseImage)
+
+	if ds.Namespace != "test-ns" {
+		t.Errorf("got namespace %q, expected %q", ds.Namespace, "test-ns")
+	}
+	if got := ds.Spec.Template.Spec.NodeSelector["pool"]; got != "inference" {
+		t.Errorf("got node selector %v, expected pool=inference", ds.Spec.Template.Spec.NodeSelector)
+	}
+	initContainers := ds.Spec.Template.Spec.InitContainers
+	if len(initContainers) != 2 || initContainers[0].Image != "sidecar:v1" || initContainers[1].Image != "prefetch:v1" {
+		t.Errorf("got init containers %v, expected the sidecar and metadata prefetch images", initContainers)
+	}
+
+	if other := PrepullDaemonSet("test-ns", images, nil, DefaultPrepullPauseImage); other.Name != ds.Name {
+		t.Errorf("got name %q for the same images, expected %q", other.Name, ds.Name)
+	}
+	if other := PrepullDaemonSet("test-ns", SidecarPrepullImages("sidecar:v2", "prefetch:v1"), nil, DefaultPrepullPauseImage); other.Name == ds.Name {
+		t.Errorf("got the same name %q for different images", other.Name)
+	}
+}
+
+func TestEnsurePrepullDaemonSet(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	oldDS := PrepullDaemonSet("test-ns", SidecarPrepullImages("sidecar:v1", "prefetch:v1"), nil, DefaultPrepullPauseImage)
+	newDS := PrepullDaemonSet("test-ns", SidecarPrepullImages("sidecar:v2", "prefetch:v2"), nil, DefaultPrepullPauseImage)
+
+	if err := EnsurePrepullDaemonSet(ctx, client, oldDS); err != nil {
+		t.Fatalf("failed to ensure the DaemonSet: %v", err)
+	}
+	if err := EnsurePrepullDaemonSet(ctx, client, oldDS); err != nil {
+		t.Fatalf("failed to ensure the existing DaemonSet: %v", err)
+	}
+	if err := EnsurePrepullDaemonSet(ctx, client, newDS); err != nil {
+		t.Fatalf("failed to ensure the new DaemonSet: %v", err)
+	}
+
+	list, err := client.AppsV1().DaemonSets("test-ns").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list DaemonSets: %v", err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Name != newDS.Name {
+		t.Errorf("got DaemonSets %v, expected only %q", list.Items, newDS.Name)
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// runtimeClassHandlerLookupKeyPrefix prefixes the names of the RuntimeClasses whose handlers are cached in the lookup cache.
+const runtimeClassHandlerLookupKeyPrefix = "runtime-class-handler/"
+
+// validateSandbox returns an error if the Pod runs in a RuntimeClass whose handler is one of si.SandboxedRuntimeHandlers,
+// such as `runsc` of gVisor. The gcsfuse sidecar container of a sandboxed Pod cannot serve the FUSE mounts that the CSI driver
+// creates on the node, so the volume mounts of the Pod would hang instead of failing, and the Pod is rejected at admission with the reason.
+// The Pod is admitted if the handler of its RuntimeClass cannot be looked up, because the node service checks it again at mount time.
+func (si *SidecarInjector) validateSandbox(ctx context.Context, pod *corev1.Pod) error {
+	if len(si.SandboxedRuntimeHandlers) == 0 || si.RuntimeClasses == nil || pod.Spec.RuntimeClassName == nil {
+		return nil
+	}
+
+	name := *pod.Spec.RuntimeClassName
+	v, err := si.lookups.get(ctx, runtimeClassHandlerLookupKeyPrefix+name, si.LookupCacheTTL, func(ctx context.Context) (interface{}, error) {
+		runtimeClass, err := si.RuntimeClasses.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+
+		return runtimeClass.Handler, nil
+	})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			klog.Warningf("failed to get the handler of RuntimeClass %q, skipping the sandbox check: %v", name, err)
+		}
+
+		return nil
+	}
+	handler, _ := v.(string)
+	if !slices.Contains(si.SandboxedRuntimeHandlers, handler) {
+		return nil
+	}
+
+	return fmt.Errorf("the Pod uses RuntimeClass %q with handler %q, which runs the Pod in a sandbox where the %s container cannot serve the Cloud Storage FUSE volumes, "+
+		"so the volume mounts would hang. Remove the runtimeClassName of the Pod, or the %q annotation if the Pod does not use Cloud Storage FUSE volumes. "+
+		"If the sandbox supports FUSE, ask the cluster admin to remove the handler from the --sandboxed-runtime-handlers flag of the webhook",
+		name, handler, GcsFuseSidecarName, GcsFuseVolumeEnableAnnotation)
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+func TestValidateSandbox(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name             string
+		handlers         []string
+		runtimeClassName *string
+		expectErr        bool
+	}{
+		{
+			name:     "no runtime class",
+			handlers: []string{"runsc"},
+		},
+		{
+			name:             "other runtime class",
+			handlers:         []string{"runsc"},
+			runtimeClassName: ptr.To("nvidia"),
+		},
+		{
+			name:             "sandboxed runtime class",
+			handlers:         []string{"runsc"},
+			runtimeClassName: ptr.To("gvisor"),
+			expectErr:        true,
+		},
+		{
+			name:             "renamed sandboxed runtime class",
+			handlers:         []string{"runsc"},
+			runtimeClassName: ptr.To("sandbox"),
+			expectErr:        true,
+		},
+		{
+			name:             "unknown runtime class",
+			handlers:         []string{"runsc"},
+			runtimeClassName: ptr.To("missing"),
+		},
+		{
+			name:             "no sandboxed runtime handlers",
+			runtimeClassName: ptr.To("gvisor"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			client := fake.NewSimpleClientset(
+				&nodev1.RuntimeClass{ObjectMeta: metav1.ObjectMeta{Name: "gvisor"}, Handler: "runsc"},
+				&nodev1.RuntimeClass{ObjectMeta: metav1.ObjectMeta{Name: "sandbox"}, Handler: "runsc"},
+				&nodev1.RuntimeClass{ObjectMeta: metav1.ObjectMeta{Name: "nvidia"}, Handler: "nvidia"},
+			)
+			si := &SidecarInjector{SandboxedRuntimeHandlers: tc.handlers, RuntimeClasses: client.NodeV1().RuntimeClasses()}
+			pod := &corev1.Pod{Spec: corev1.PodSpec{RuntimeClassName: tc.runtimeClassName}}
+			err := si.validateSandbox(context.Background(), pod)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), `handler "runsc"`) {
+				t.Errorf("got error %q, expected the RuntimeClass handler of the Pod", err)
+			}
+		})
+	}
+}
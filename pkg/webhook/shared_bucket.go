@@ -0,0 +1,109 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/volumespec"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// sharedBucketWarningsAnnotation set to "false" disables the warnings of the Pod about buckets shared across namespaces,
+// for the Pods whose identities were all granted access to the bucket.
+const sharedBucketWarningsAnnotation = "gke-gcsfuse/shared-bucket-warnings"
+
+// sharedBucketWarnings returns admission warnings for the PersistentVolumes of the Pod whose bucket is also claimed from
+// other namespaces through PersistentVolumes with a different identity. Each namespace then needs its own access to the
+// bucket, and a missing IAM binding only shows up as 403 errors when the volume is used. Only sharing a bucket across
+// namespaces with the same gcpServiceAccount volume attribute on all the PersistentVolumes does not get a warning,
+// because without the attribute, the Pods of each namespace use their own Kubernetes ServiceAccount.
+func (si *SidecarInjector) sharedBucketWarnings(pod *corev1.Pod) []string {
+	warnings := []string{}
+	if value, ok := pod.Annotations[sharedBucketWarningsAnnotation]; ok {
+		enabled, err := ParseBool(value)
+		if err != nil {
+			return []string{fmt.Sprintf("the value of %q is invalid, the shared bucket warnings are enabled: %v", sharedBucketWarningsAnnotation, err)}
+		}
+		if !enabled {
+			return warnings
+		}
+	}
+
+	serviceAccountName := pod.Spec.ServiceAccountName
+	if serviceAccountName == "" {
+		serviceAccountName = "default"
+	}
+	for _, v := range pod.Spec.Volumes {
+		if v.PersistentVolumeClaim == nil {
+			continue
+		}
+
+		pvc, err := si.GetPVC(pod.Namespace, v.PersistentVolumeClaim.ClaimName)
+		if err != nil {
+			continue
+		}
+		pv, ok, err := si.GetPreprovisionCSIVolume(si.csiDriverName(), pvc)
+		if err != nil || !ok || pv.Spec.CSI.VolumeHandle == "_" {
+			continue
+		}
+
+		// The lister serves the PersistentVolumes from the informer cache, so the lookup does not call the API server.
+		pvs, err := si.PvLister.List(labels.Everything())
+		if err != nil {
+			klog.Warningf("failed to list the PersistentVolumes of bucket %q: %v", pv.Spec.CSI.VolumeHandle, err)
+
+			continue
+		}
+		slices.SortFunc(pvs, func(a, b *corev1.PersistentVolume) int { return strings.Compare(a.Name, b.Name) })
+
+		identity := volumeIdentity(pv.Spec.CSI.VolumeAttributes, pod.Namespace, serviceAccountName)
+		for _, other := range pvs {
+			if other.Name == pv.Name || other.Spec.CSI == nil || other.Spec.CSI.Driver != pv.Spec.CSI.Driver || other.Spec.CSI.VolumeHandle != pv.Spec.CSI.VolumeHandle {
+				continue
+			}
+			if other.Spec.ClaimRef == nil || other.Spec.ClaimRef.Namespace == pod.Namespace {
+				continue
+			}
+			// The Pods that mount the other PersistentVolume are not known, only the namespace of its claim.
+			if otherIdentity := volumeIdentity(other.Spec.CSI.VolumeAttributes, other.Spec.ClaimRef.Namespace, ""); otherIdentity != identity {
+				warnings = append(warnings, fmt.Sprintf("volume %q mounts bucket %q through PersistentVolume %q as %s, but PersistentVolume %q mounts the same bucket in namespace %q as %s. Grant each identity access to the bucket, or set the same %v volume attribute on the PersistentVolumes, otherwise the volume fails with 403 errors. Set the %q annotation to \"false\" to disable this warning.",
+					v.Name, pv.Spec.CSI.VolumeHandle, pv.Name, identity, other.Name, other.Spec.ClaimRef.Namespace, otherIdentity, volumespec.AttributeGCPServiceAccount, sharedBucketWarningsAnnotation))
+			}
+		}
+	}
+
+	return warnings
+}
+
+// volumeIdentity describes the identity that a volume claimed from the namespace uses to access the bucket, given the
+// Kubernetes ServiceAccount of the Pod, or an empty serviceAccountName if the Pods of the volume are not known.
+func volumeIdentity(volumeAttributes map[string]string, namespace, serviceAccountName string) string {
+	if sa := volumeAttributes[volumespec.AttributeGCPServiceAccount]; sa != "" {
+		return fmt.Sprintf("the GCP service account %q", sa)
+	}
+	if serviceAccountName == "" {
+		return fmt.Sprintf("the Kubernetes ServiceAccounts of namespace %q", namespace)
+	}
+
+	return fmt.Sprintf("the Kubernetes ServiceAccount %q of namespace %q", serviceAccountName, namespace)
+}
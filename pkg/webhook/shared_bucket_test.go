@@ -0,0 +1,156 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestSharedBucketWarnings(t *testing.T) {
+	t.Parallel()
+
+	pv := func(name, bucket, claimNamespace, gcpServiceAccount string) *corev1.PersistentVolume {
+		pv := &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: corev1.PersistentVolumeSpec{
+				PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:           DefaultCSIDriverName,
+					VolumeHandle:     bucket,
+					VolumeAttributes: map[string]string{},
+				}},
+			},
+		}
+		if claimNamespace != "" {
+			pv.Spec.ClaimRef = &corev1.ObjectReference{Namespace: claimNamespace, Name: "data"}
+		}
+		if gcpServiceAccount != "" {
+			pv.Spec.CSI.VolumeAttributes["gcpServiceAccount"] = gcpServiceAccount
+		}
+
+		return pv
+	}
+	const (
+		saA = "reader-a@test-project.iam.gserviceaccount.com"
+		saB = "reader-b@test-project.iam.gserviceaccount.com"
+	)
+
+	testCases := []struct {
+		name             string
+		annotations      map[string]string
+		podPV            *corev1.PersistentVolume
+		otherPVs         []*corev1.PersistentVolume
+		expectedWarnings []string
+	}{
+		{
+			name:     "same GCP service account in another namespace",
+			podPV:    pv("pv-default", "shared-bucket", "default", saA),
+			otherPVs: []*corev1.PersistentVolume{pv("pv-team", "shared-bucket", "team", saA)},
+		},
+		{
+			name:             "Pod identities in every namespace",
+			podPV:            pv("pv-default", "shared-bucket", "default", ""),
+			otherPVs:         []*corev1.PersistentVolume{pv("pv-team", "shared-bucket", "team", "")},
+			expectedWarnings: []string{`as the Kubernetes ServiceAccount "reader" of namespace "default", but PersistentVolume "pv-team" mounts the same bucket in namespace "team" as the Kubernetes ServiceAccounts of namespace "team"`},
+		},
+		{
+			name:        "warnings disabled",
+			annotations: map[string]string{sharedBucketWarningsAnnotation: "false"},
+			podPV:       pv("pv-default", "shared-bucket", "default", ""),
+			otherPVs:    []*corev1.PersistentVolume{pv("pv-team", "shared-bucket", "team", "")},
+		},
+		{
+			name:             "invalid annotation",
+			annotations:      map[string]string{sharedBucketWarningsAnnotation: "no"},
+			podPV:            pv("pv-default", "shared-bucket", "default", saA),
+			otherPVs:         []*corev1.PersistentVolume{pv("pv-team", "shared-bucket", "team", saA)},
+			expectedWarnings: []string{`the value of "gke-gcsfuse/shared-bucket-warnings" is invalid`},
+		},
+		{
+			name:             "different GCP service accounts in another namespace",
+			podPV:            pv("pv-default", "shared-bucket", "default", saA),
+			otherPVs:         []*corev1.PersistentVolume{pv("pv-team", "shared-bucket", "team", saB)},
+			expectedWarnings: []string{`PersistentVolume "pv-team" mounts the same bucket in namespace "team" as the GCP service account "reader-b@`},
+		},
+		{
+			name:             "Pod identity in another namespace",
+			podPV:            pv("pv-default", "shared-bucket", "default", saA),
+			otherPVs:         []*corev1.PersistentVolume{pv("pv-team", "shared-bucket", "team", "")},
+			expectedWarnings: []string{`as the Kubernetes ServiceAccounts of namespace "team"`},
+		},
+		{
+			name:     "different identities in the same namespace",
+			podPV:    pv("pv-default", "shared-bucket", "default", saA),
+			otherPVs: []*corev1.PersistentVolume{pv("pv-other", "shared-bucket", "default", saB)},
+		},
+		{
+			name:     "different bucket",
+			podPV:    pv("pv-default", "shared-bucket", "default", saA),
+			otherPVs: []*corev1.PersistentVolume{pv("pv-team", "other-bucket", "team", saB)},
+		},
+		{
+			name:     "unclaimed PersistentVolume",
+			podPV:    pv("pv-default", "shared-bucket", "default", saA),
+			otherPVs: []*corev1.PersistentVolume{pv("pv-team", "shared-bucket", "", saB)},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pvIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			for _, pv := range append([]*corev1.PersistentVolume{tc.podPV}, tc.otherPVs...) {
+				_ = pvIndexer.Add(pv)
+			}
+			pvcIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			_ = pvcIndexer.Add(&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "default"}, Spec: corev1.PersistentVolumeClaimSpec{VolumeName: tc.podPV.Name}})
+
+			si := &SidecarInjector{
+				Config:    FakeConfig(),
+				PvLister:  listersv1.NewPersistentVolumeLister(pvIndexer),
+				PvcLister: listersv1.NewPersistentVolumeClaimLister(pvcIndexer),
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default", Annotations: tc.annotations},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: "reader",
+					Volumes: []corev1.Volume{{
+						Name:         "data",
+						VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}},
+					}},
+				},
+			}
+
+			warnings := si.sharedBucketWarnings(pod)
+			if len(warnings) != len(tc.expectedWarnings) {
+				t.Fatalf("got warnings %q, expected %d warnings", warnings, len(tc.expectedWarnings))
+			}
+			for i, w := range tc.expectedWarnings {
+				if !strings.Contains(warnings[i], w) {
+					t.Errorf("got warning %q, expected it to contain %q", warnings[i], w)
+				}
+			}
+		})
+	}
+}
@@ -19,7 +19,9 @@ package webhook
 
 import (
 	"path/filepath"
+	"slices"
 
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/volumespec"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 	"k8s.io/utils/ptr"
@@ -39,7 +41,7 @@ const (
 	K8STokenPath                           = "token"             // #nosec G101
 
 	// Webhook relevant volume attributes.
-	gcsFuseMetadataPrefetchOnMountVolumeAttribute = "gcsfuseMetadataPrefetchOnMount"
+	gcsFuseMetadataPrefetchOnMountVolumeAttribute = volumespec.AttributeMetadataPrefetchOnMount
 
 	// See the nonroot user discussion: https://github.com/GoogleContainerTools/distroless/issues/443
 	NobodyUID           = 65534
@@ -115,9 +117,9 @@ func GetSidecarContainerSpec(c *Config) corev1.Container {
 		Image:           c.ContainerImage,
 		ImagePullPolicy: corev1.PullPolicy(c.ImagePullPolicy),
 		SecurityContext: GetSecurityContext(),
-		Args: []string{
+		Args: append([]string{
 			"--v=5",
-		},
+		}, loggingFormatArgs(c)...),
 		Resources: corev1.ResourceRequirements{
 			Limits:   limits,
 			Requests: requests,
@@ -128,6 +130,16 @@ func GetSidecarContainerSpec(c *Config) corev1.Container {
 	return container
 }
 
+// loggingFormatArgs returns the sidecar container arguments that set the logging format.
+// No argument is added for the default format, so that sidecar images without the flag keep working.
+func loggingFormatArgs(c *Config) []string {
+	if c.LoggingFormat == "" {
+		return nil
+	}
+
+	return []string{"--logging-format=" + c.LoggingFormat}
+}
+
 // GetSecurityContext ensures the sidecar that uses it follows Restricted Pod Security Standard.
 // See https://kubernetes.io/docs/concepts/security/pod-security-standards/#restricted
 func GetSecurityContext() *corev1.SecurityContext {
@@ -161,6 +173,7 @@ func (si *SidecarInjector) GetMetadataPrefetchSidecarContainerSpec(pod *corev1.P
 		Image:           c.ContainerImage,
 		ImagePullPolicy: corev1.PullPolicy(c.ImagePullPolicy),
 		SecurityContext: GetSecurityContext(),
+		Args:            loggingFormatArgs(c),
 		Resources: corev1.ResourceRequirements{
 			Limits:   limits,
 			Requests: requests,
@@ -168,6 +181,7 @@ func (si *SidecarInjector) GetMetadataPrefetchSidecarContainerSpec(pod *corev1.P
 		VolumeMounts: []corev1.VolumeMount{},
 	}
 
+	selectedVolumes, volumesSelected := metadataPrefetchVolumes(pod)
 	for _, v := range pod.Spec.Volumes {
 		isGcsFuseCSIVolume, isDynamicMount, volumeAttributes, err := si.isGcsFuseCSIVolume(v, pod.Namespace)
 		if err != nil {
@@ -181,19 +195,32 @@ func (si *SidecarInjector) GetMetadataPrefetchSidecarContainerSpec(pod *corev1.P
 		}
 
 		if isGcsFuseCSIVolume {
-			enableMetaPrefetchRaw, ok := volumeAttributes[gcsFuseMetadataPrefetchOnMountVolumeAttribute]
-			// We disable metadata prefetch by default, so we skip injection of volume mount when not set.
-			if !ok {
-				continue
-			}
+			var enableMetaPrefetch bool
+			if volumesSelected {
+				// The Pod annotation overrides the volume attribute, so that Pods can opt in or out without changing shared PersistentVolumes.
+				enableMetaPrefetch = slices.Contains(selectedVolumes, v.Name)
+			} else {
+				enableMetaPrefetchRaw, ok := volumeAttributes[gcsFuseMetadataPrefetchOnMountVolumeAttribute]
+				// We disable metadata prefetch by default, so we skip injection of volume mount when not set.
+				if !ok {
+					continue
+				}
 
-			enableMetaPrefetch, err := ParseBool(enableMetaPrefetchRaw)
-			if err != nil {
-				klog.Errorf(`failed to determine if metadata prefetch is needed for volume "%s": %v`, v.Name, err)
+				enableMetaPrefetch, err = ParseBool(enableMetaPrefetchRaw)
+				if err != nil {
+					klog.Errorf(`failed to determine if metadata prefetch is needed for volume "%s": %v`, v.Name, err)
+				}
 			}
 
 			if enableMetaPrefetch {
-				container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: v.Name, MountPath: filepath.Join("/volumes/", v.Name), ReadOnly: true})
+				// The volume is remounted when the gcsfuse sidecar container restarts, and the new mount only shows up
+				// in the metadata prefetch sidecar container with the HostToContainer propagation.
+				container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+					Name:             v.Name,
+					MountPath:        filepath.Join("/volumes/", v.Name),
+					ReadOnly:         true,
+					MountPropagation: ptr.To(corev1.MountPropagationHostToContainer),
+				})
 			}
 		}
 	}
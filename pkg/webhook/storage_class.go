@@ -0,0 +1,68 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// StorageClassValidator rejects the StorageClasses of the CSI driver whose parameters CreateVolume would reject,
+// so that the mistakes are reported when the StorageClass is created, instead of when the first PersistentVolumeClaim is provisioned.
+type StorageClassValidator struct {
+	Decoder admission.Decoder
+	// DriverName is the provisioner of the StorageClasses that are validated. It defaults to gcsfuse.csi.storage.gke.io when empty.
+	DriverName string
+	// ValidateParameters returns an error that lists the problems of the StorageClass parameters.
+	ValidateParameters func(map[string]string) error
+}
+
+// Handle validates the parameters of the StorageClasses of the CSI driver. StorageClass parameters are immutable,
+// so only the creations are validated.
+func (v *StorageClassValidator) Handle(_ context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create {
+		return admission.Allowed(fmt.Sprintf("No validation required for operation %v.", req.Operation))
+	}
+
+	sc := &storagev1.StorageClass{}
+	if err := v.Decoder.Decode(req, sc); err != nil {
+		klog.Errorf("Could not decode request: name %q, error: %v", req.Name, err)
+
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	driverName := v.DriverName
+	if driverName == "" {
+		driverName = DefaultCSIDriverName
+	}
+	if sc.Provisioner != driverName {
+		return admission.Allowed(fmt.Sprintf("The provisioner %q is not %q, no validation required.", sc.Provisioner, driverName))
+	}
+
+	if err := v.ValidateParameters(sc.Parameters); err != nil {
+		return admission.Denied(fmt.Sprintf("invalid parameters for StorageClass %q: %v", sc.Name, err))
+	}
+
+	return admission.Allowed("The StorageClass parameters are valid.")
+}
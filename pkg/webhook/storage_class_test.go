@@ -0,0 +1,106 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestStorageClassValidator(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		operation     admissionv1.Operation
+		provisioner   string
+		parameters    map[string]string
+		expectAllowed bool
+		expectMessage string
+	}{
+		{
+			name:          "valid parameters",
+			operation:     admissionv1.Create,
+			provisioner:   DefaultCSIDriverName,
+			parameters:    map[string]string{"labels": "team=ml"},
+			expectAllowed: true,
+		},
+		{
+			name:          "invalid parameters",
+			operation:     admissionv1.Create,
+			provisioner:   DefaultCSIDriverName,
+			parameters:    map[string]string{"location": "us"},
+			expectMessage: `invalid parameters for StorageClass "test-sc": parameter "location" is unknown`,
+		},
+		{
+			name:          "other provisioner",
+			operation:     admissionv1.Create,
+			provisioner:   "pd.csi.storage.gke.io",
+			parameters:    map[string]string{"location": "us"},
+			expectAllowed: true,
+		},
+		{
+			name:          "update",
+			operation:     admissionv1.Update,
+			provisioner:   DefaultCSIDriverName,
+			parameters:    map[string]string{"location": "us"},
+			expectAllowed: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			v := &StorageClassValidator{
+				Decoder: admission.NewDecoder(runtime.NewScheme()),
+				ValidateParameters: func(parameters map[string]string) error {
+					if _, ok := parameters["location"]; ok {
+						return errors.New(`parameter "location" is unknown`)
+					}
+
+					return nil
+				},
+			}
+			sc := &storagev1.StorageClass{
+				ObjectMeta:  metav1.ObjectMeta{Name: "test-sc"},
+				Provisioner: tc.provisioner,
+				Parameters:  tc.parameters,
+			}
+			req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: tc.operation,
+				Object:    runtime.RawExtension{Raw: serialize(t, sc)},
+			}}
+
+			resp := v.Handle(context.Background(), req)
+			if resp.Allowed != tc.expectAllowed {
+				t.Fatalf("got allowed %v, expected %v: %v", resp.Allowed, tc.expectAllowed, resp.Result)
+			}
+			if !tc.expectAllowed && resp.Result.Message != tc.expectMessage {
+				t.Errorf("got message %q, expected %q", resp.Result.Message, tc.expectMessage)
+			}
+		})
+	}
+}
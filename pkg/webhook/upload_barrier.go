@@ -0,0 +1,97 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// uploadBarrierPortAnnotation enables the upload barrier API of the sidecar mounter on the loopback port of its value.
+	// The workload containers can call the API to wait for the writes staged by gcsfuse to be uploaded to Cloud Storage,
+	// and the sidecar container waits for them in its preStop hook before gcsfuse is terminated.
+	uploadBarrierPortAnnotation = "gke-gcsfuse/upload-barrier-port"
+
+	sidecarMounterPath = "/gcs-fuse-csi-driver-sidecar-mounter"
+
+	// drainExitMargin is the part of the termination grace period of the Pod that is left to gcsfuse to exit after the drain.
+	drainExitMargin = 5 * time.Second
+)
+
+// applyUploadBarrier enables the upload barrier API of the sidecar container if the Pod annotation sets its port,
+// and adds a preStop hook that blocks until the staged writes are uploaded. A native sidecar container is only stopped
+// after the workload containers exit, so the hook keeps gcsfuse running until the last writes of the workload are uploaded,
+// which prevents losing checkpoints when a completed Job is deleted right away.
+func applyUploadBarrier(pod *corev1.Pod, container *corev1.Container) error {
+	value, ok := pod.Annotations[uploadBarrierPortAnnotation]
+	if !ok {
+		return nil
+	}
+
+	if err := validateLoopbackPort(pod, uploadBarrierPortAnnotation, value); err != nil {
+		return err
+	}
+
+	portArg := "--upload-barrier-port=" + value
+	container.Args = append(container.Args, portArg)
+	container.Lifecycle = &corev1.Lifecycle{
+		PreStop: &corev1.LifecycleHandler{
+			Exec: &corev1.ExecAction{Command: []string{sidecarMounterPath, portArg, "--wait-for-uploads"}},
+		},
+	}
+
+	return nil
+}
+
+// applyDrainTimeout bounds how long a native sidecar container waits on SIGTERM for the staged writes to be uploaded,
+// so that gcsfuse is still terminated before the termination grace period of the Pod expires. Pods with a grace period
+// shorter than the margin terminate gcsfuse right away.
+func applyDrainTimeout(pod *corev1.Pod, container *corev1.Container) {
+	grace := corev1.DefaultTerminationGracePeriodSeconds * time.Second
+	if pod.Spec.TerminationGracePeriodSeconds != nil {
+		grace = time.Duration(*pod.Spec.TerminationGracePeriodSeconds) * time.Second
+	}
+	if grace <= drainExitMargin {
+		return
+	}
+
+	container.Args = append(container.Args, "--drain-timeout="+(grace-drainExitMargin).String())
+}
+
+// validateLoopbackPort checks that the value of the annotation is a port number that no container of the Pod declares,
+// so that a sidecar container API can listen on it on the loopback interface.
+func validateLoopbackPort(pod *corev1.Pod, annotation, value string) error {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("the value of %q must be a port number between 1 and 65535, got %q", annotation, value)
+	}
+	for _, c := range slices.Concat(pod.Spec.InitContainers, pod.Spec.Containers) {
+		for _, p := range c.Ports {
+			if int(p.ContainerPort) == port {
+				return fmt.Errorf("the port %v set by %q is already used by container %q", port, annotation, c.Name)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func TestApplyUploadBarrier(t *testing.T) {
+	t.Parallel()
+
+	workload := corev1.Container{Name: "workload", Ports: []corev1.ContainerPort{{ContainerPort: 8080}}}
+	testCases := []struct {
+		name              string
+		annotations       map[string]string
+		expectedArgs      []string
+		expectedLifecycle *corev1.Lifecycle
+		expectErr         bool
+	}{
+		{
+			name:         "no annotation",
+			expectedArgs: []string{"--v=5"},
+		},
+		{
+			name:         "annotation sets the port",
+			annotations:  map[string]string{uploadBarrierPortAnnotation: "9930"},
+			expectedArgs: []string{"--v=5", "--upload-barrier-port=9930"},
+			expectedLifecycle: &corev1.Lifecycle{
+				PreStop: &corev1.LifecycleHandler{
+					Exec: &corev1.ExecAction{Command: []string{"/gcs-fuse-csi-driver-sidecar-mounter", "--upload-barrier-port=9930", "--wait-for-uploads"}},
+				},
+			},
+		},
+		{
+			name:        "invalid port",
+			annotations: map[string]string{uploadBarrierPortAnnotation: "70000"},
+			expectErr:   true,
+		},
+		{
+			name:        "port used by a workload container",
+			annotations: map[string]string{uploadBarrierPortAnnotation: "8080"},
+			expectErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{workload}},
+			}
+			container := GetSidecarContainerSpec(FakeConfig())
+
+			err := applyUploadBarrier(pod, &container)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectErr)
+			}
+			if tc.expectErr {
+				return
+			}
+
+			if diff := cmp.Diff(tc.expectedArgs, container.Args); diff != "" {
+				t.Errorf("unexpected args (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.expectedLifecycle, container.Lifecycle); diff != "" {
+				t.Errorf("unexpected lifecycle (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestApplyDrainTimeout(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		gracePeriod  *int64
+		expectedArgs []string
+	}{
+		{
+			name:         "default grace period",
+			expectedArgs: []string{"--v=5", "--drain-timeout=25s"},
+		},
+		{
+			name:         "long grace period",
+			gracePeriod:  ptr.To[int64](600),
+			expectedArgs: []string{"--v=5", "--drain-timeout=9m55s"},
+		},
+		{
+			name:         "grace period within the margin",
+			gracePeriod:  ptr.To[int64](5),
+			expectedArgs: []string{"--v=5"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pod := &corev1.Pod{Spec: corev1.PodSpec{TerminationGracePeriodSeconds: tc.gracePeriod}}
+			container := GetSidecarContainerSpec(FakeConfig())
+
+			applyDrainTimeout(pod, &container)
+			if diff := cmp.Diff(tc.expectedArgs, container.Args); diff != "" {
+				t.Errorf("unexpected args (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -18,13 +18,22 @@ limitations under the License.
 package webhook
 
 import (
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/volumespec"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 )
 
-const (
-	gcsFuseCsiDriverName = "gcsfuse.csi.storage.gke.io"
-)
+// DefaultCSIDriverName is the name of the CSI driver when the webhook is not configured with another name.
+const DefaultCSIDriverName = volumespec.DefaultDriverName
+
+// csiDriverName returns the name of the CSI driver whose volumes the webhook injects the sidecar container for.
+func (si *SidecarInjector) csiDriverName() string {
+	if si.DriverName != "" {
+		return si.DriverName
+	}
+
+	return DefaultCSIDriverName
+}
 
 // isGcsFuseCSIVolume checks if the given volume is backed by gcsfuse csi driver.
 //
@@ -38,7 +47,7 @@ func (si *SidecarInjector) isGcsFuseCSIVolume(volume corev1.Volume, namespace st
 
 	// Check if it is ephemeral volume.
 	if volume.CSI != nil {
-		if volume.CSI.Driver == gcsFuseCsiDriverName {
+		if volume.CSI.Driver == si.csiDriverName() {
 			// Ephemeral volume is using dynamic mounting,
 			// See details: https://cloud.google.com/storage/docs/gcsfuse-mount#dynamic-mount
 			if val, ok := volume.CSI.VolumeAttributes["bucketName"]; ok && val == "_" {
@@ -63,7 +72,7 @@ func (si *SidecarInjector) isGcsFuseCSIVolume(volume corev1.Volume, namespace st
 	}
 
 	// Check if the PVC is a preprovisioned gcsfuse volume.
-	pv, ok, err := si.GetPreprovisionCSIVolume(gcsFuseCsiDriverName, pvcObj)
+	pv, ok, err := si.GetPreprovisionCSIVolume(si.csiDriverName(), pvcObj)
 	if err != nil || pv == nil {
 		klog.Warningf("unable to determine if PVC %s/%s is a pre-provisioned gcsfuse volume: %v", namespace, pvcName, err)
 
@@ -0,0 +1,80 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestIsGcsFuseCSIVolumeDriverName(t *testing.T) {
+	t.Parallel()
+
+	ephemeralVolume := func(driver string) corev1.Volume {
+		return corev1.Volume{
+			Name: "test-volume",
+			VolumeSource: corev1.VolumeSource{
+				CSI: &corev1.CSIVolumeSource{
+					Driver:           driver,
+					VolumeAttributes: map[string]string{"bucketName": "test-bucket"},
+				},
+			},
+		}
+	}
+
+	testCases := []struct {
+		name       string
+		driverName string
+		volume     corev1.Volume
+		expected   bool
+	}{
+		{
+			name:     "default driver name",
+			volume:   ephemeralVolume(DefaultCSIDriverName),
+			expected: true,
+		},
+		{
+			name:       "custom driver name",
+			driverName: "canary.gcsfuse.csi.storage.gke.io",
+			volume:     ephemeralVolume("canary.gcsfuse.csi.storage.gke.io"),
+			expected:   true,
+		},
+		{
+			name:       "volume of another driver installation",
+			driverName: "canary.gcsfuse.csi.storage.gke.io",
+			volume:     ephemeralVolume(DefaultCSIDriverName),
+			expected:   false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			si := &SidecarInjector{DriverName: tc.driverName}
+			got, _, _, err := si.isGcsFuseCSIVolume(tc.volume, "default")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.expected {
+				t.Errorf("got isGcsFuseCSIVolume %v, expected %v", got, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+)
+
+// databaseImages are container image names of databases that require file locking and in-place writes,
+// which Cloud Storage FUSE does not support.
+var databaseImages = []string{"mysql", "mariadb", "postgres", "mongo", "redis", "cassandra", "elasticsearch", "etcd"}
+
+// databaseFileSuffixes are file name suffixes of embedded database files.
+var databaseFileSuffixes = []string{".db", ".sqlite", ".sqlite3"}
+
+// minWritableEphemeralStorageLimit is the sidecar container ephemeral storage limit below which
+// writes to a Cloud Storage FUSE volume are likely to get the Pod evicted. gcsfuse stages files
+// in the buffer volume until they are flushed, and the buffer volume uses the sidecar container ephemeral storage by default.
+var minWritableEphemeralStorageLimit = resource.MustParse("1Gi")
+
+// workloadWarnings returns admission warnings for Pod patterns that are known to perform poorly on Cloud Storage FUSE volumes.
+// The warnings do not block the Pod creation.
+func (si *SidecarInjector) workloadWarnings(pod *corev1.Pod) []string {
+	warnings := []string{}
+	gcsFuseVolumes := map[string]bool{}
+	writable := false
+	for _, v := range pod.Spec.Volumes {
+		isGcsFuseCSIVolume, _, volumeAttributes, err := si.isGcsFuseCSIVolume(v, pod.Namespace)
+		if err != nil {
+			klog.Errorf("failed to determine if %s is a GcsFuseCSI backed volume: %v", v.Name, err)
+		}
+
+		if !isGcsFuseCSIVolume {
+			continue
+		}
+
+		gcsFuseVolumes[v.Name] = true
+		if !(v.CSI != nil && v.CSI.ReadOnly != nil && *v.CSI.ReadOnly) && !hasReadOnlyMountOption(volumeAttributes) {
+			writable = true
+		}
+	}
+
+	if len(gcsFuseVolumes) == 0 {
+		return warnings
+	}
+	warnings = append(warnings, si.sharedBucketWarnings(pod)...)
+
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, c := range containers {
+		if c.Name == GcsFuseSidecarName || c.Name == MetadataPrefetchSidecarName {
+			continue
+		}
+
+		for _, vm := range c.VolumeMounts {
+			if !gcsFuseVolumes[vm.Name] {
+				continue
+			}
+
+			if image, ok := databaseImage(c.Image); ok {
+				warnings = append(warnings, fmt.Sprintf("container %q runs %s on the Cloud Storage FUSE volume %q. Cloud Storage FUSE does not support file locking or in-place writes that databases rely on, use a Persistent Disk volume instead.", c.Name, image, vm.Name))
+			}
+
+			for _, p := range []string{vm.MountPath, vm.SubPath} {
+				if hasDatabaseFileSuffix(p) {
+					warnings = append(warnings, fmt.Sprintf("container %q mounts the database file %q from the Cloud Storage FUSE volume %q. Cloud Storage FUSE does not support file locking or in-place writes that databases rely on, use a Persistent Disk volume instead.", c.Name, p, vm.Name))
+				}
+			}
+		}
+	}
+
+	if !writable || hasVolume(pod, SidecarContainerBufferVolumeName) {
+		return warnings
+	}
+
+	config, err := si.prepareConfig(sidecarPrefixMap[GcsFuseSidecarName], *pod)
+	if err != nil {
+		return warnings
+	}
+
+	if !config.EphemeralStorageLimit.IsZero() && config.EphemeralStorageLimit.Cmp(minWritableEphemeralStorageLimit) < 0 {
+		warnings = append(warnings, fmt.Sprintf("the sidecar container ephemeral storage limit %q is smaller than %q, and Cloud Storage FUSE stages written files in the sidecar container ephemeral storage. Writing large files may get the Pod evicted. Increase the limit or configure a custom buffer volume %q.", config.EphemeralStorageLimit.String(), minWritableEphemeralStorageLimit.String(), SidecarContainerBufferVolumeName))
+	}
+
+	return warnings
+}
+
+// databaseImage returns the database name if the container image is a known database image.
+func databaseImage(image string) (string, bool) {
+	name := image[strings.LastIndex(image, "/")+1:]
+	if i := strings.IndexAny(name, ":@"); i >= 0 {
+		name = name[:i]
+	}
+
+	for _, db := range databaseImages {
+		if name == db || strings.HasPrefix(name, db+"-") {
+			return db, true
+		}
+	}
+
+	return "", false
+}
+
+func hasDatabaseFileSuffix(path string) bool {
+	for _, s := range databaseFileSuffixes {
+		if strings.HasSuffix(path, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasReadOnlyMountOption(volumeAttributes map[string]string) bool {
+	for _, o := range strings.Split(volumeAttributes["mountOptions"], ",") {
+		if strings.TrimSpace(o) == "ro" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasVolume(pod *corev1.Pod, name string) bool {
+	for _, v := range pod.Spec.Volumes {
+		if v.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWorkloadWarnings(t *testing.T) {
+	t.Parallel()
+
+	gcsFuseVolume := func(mountOptions string) corev1.Volume {
+		return corev1.Volume{
+			Name: "data",
+			VolumeSource: corev1.VolumeSource{
+				CSI: &corev1.CSIVolumeSource{
+					Driver:           DefaultCSIDriverName,
+					VolumeAttributes: map[string]string{"bucketName": "test-bucket", "mountOptions": mountOptions},
+				},
+			},
+		}
+	}
+	pod := func(image string, volumeMount corev1.VolumeMount, annotations map[string]string, volumes ...corev1.Volume) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "workload", Image: image, VolumeMounts: []corev1.VolumeMount{volumeMount}}},
+				Volumes:    volumes,
+			},
+		}
+	}
+	smallEphemeralStorage := map[string]string{"gke-gcsfuse/ephemeral-storage-limit": "100Mi"}
+
+	testCases := []struct {
+		name             string
+		pod              *corev1.Pod
+		expectedWarnings []string
+	}{
+		{
+			name: "no anti-patterns",
+			pod:  pod("busybox", corev1.VolumeMount{Name: "data", MountPath: "/data"}, nil, gcsFuseVolume("")),
+		},
+		{
+			name:             "database image",
+			pod:              pod("docker.io/library/postgres:16", corev1.VolumeMount{Name: "data", MountPath: "/var/lib/postgresql/data"}, nil, gcsFuseVolume("")),
+			expectedWarnings: []string{`container "workload" runs postgres on the Cloud Storage FUSE volume "data"`},
+		},
+		{
+			name: "database image without gcsfuse volume",
+			pod: pod("mysql:8", corev1.VolumeMount{Name: "data", MountPath: "/var/lib/mysql"}, nil, corev1.Volume{
+				Name:         "data",
+				VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+			}),
+		},
+		{
+			name:             "sqlite file",
+			pod:              pod("busybox", corev1.VolumeMount{Name: "data", MountPath: "/app/state.sqlite", SubPath: "state.sqlite"}, nil, gcsFuseVolume("")),
+			expectedWarnings: []string{`database file "/app/state.sqlite"`, `database file "state.sqlite"`},
+		},
+		{
+			name:             "small ephemeral storage with writable volume",
+			pod:              pod("busybox", corev1.VolumeMount{Name: "data", MountPath: "/data"}, smallEphemeralStorage, gcsFuseVolume("")),
+			expectedWarnings: []string{`the sidecar container ephemeral storage limit "100Mi" is smaller than "1Gi"`},
+		},
+		{
+			name: "small ephemeral storage with read-only volume",
+			pod:  pod("busybox", corev1.VolumeMount{Name: "data", MountPath: "/data"}, smallEphemeralStorage, gcsFuseVolume("implicit-dirs,ro")),
+		},
+		{
+			name: "small ephemeral storage with custom buffer volume",
+			pod: pod("busybox", corev1.VolumeMount{Name: "data", MountPath: "/data"}, smallEphemeralStorage, gcsFuseVolume(""), corev1.Volume{
+				Name:         SidecarContainerBufferVolumeName,
+				VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+			}),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			si := &SidecarInjector{Config: FakeConfig()}
+			warnings := si.workloadWarnings(tc.pod)
+			if len(warnings) != len(tc.expectedWarnings) {
+				t.Fatalf("got warnings %q, expected %d warnings", warnings, len(tc.expectedWarnings))
+			}
+			for i, w := range tc.expectedWarnings {
+				if !strings.Contains(warnings[i], w) {
+					t.Errorf("got warning %q, expected it to contain %q", warnings[i], w)
+				}
+			}
+		})
+	}
+}
@@ -27,6 +27,7 @@ import (
 
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/clientset"
 	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/metadata"
+	driver "github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/csi_driver"
 	"github.com/onsi/ginkgo/v2"
 	"github.com/onsi/gomega"
 	"k8s.io/client-go/tools/clientcmd"
@@ -42,7 +43,13 @@ var (
 	c              clientset.Interface
 	m              metadata.Service
 	clientProtocol = flag.String("client-protocol", "http", "the test bucket location")
+	csiDriverName  = flag.String("driver-name", driver.DefaultName, "the name of the CSI driver under test")
 	bucketLocation = flag.String("test-bucket-location", "us-central1", "the test bucket location")
+	crossProjectID = flag.String("cross-project-id", "", "the project to create the test bucket in for the cross-project tests, which are skipped if it is empty")
+	vpcSCDenied    = flag.String("vpc-sc-denied-project-id", "", "the project in a VPC Service Controls perimeter without the cluster project, to create the test bucket in for the VPC Service Controls tests, which are skipped if it is empty")
+	vpcSCAllowed   = flag.String("vpc-sc-allowed-project-id", "", "the project in the VPC Service Controls perimeter of the cluster project, to create the test bucket in for the VPC Service Controls tests, which are skipped if it is empty")
+	mountOptions   = flag.String("extra-mount-options", "", "comma-separated mount options added to all the test volumes")
+	volumeAttrs    = flag.String("extra-volume-attributes", "", "comma-separated key=value volume attributes added to the ephemeral and pre-provisioned test volumes")
 	skipGcpSaTest  = flag.Bool("skip-gcp-sa-test", true, "skip GCP SA test")
 	apiEnv         = flag.String("api-env", "prod", "cluster API env")
 )
@@ -114,9 +121,12 @@ var _ = ginkgo.Describe("E2E Test Suite", func() {
 		testsuites.InitGcsFuseCSIMetricsTestSuite,
 		testsuites.InitGcsFuseCSIMetadataPrefetchTestSuite,
 		testsuites.InitGcsFuseMountTestSuite,
+		testsuites.InitGcsFuseCSIBucketAccessTestSuite,
+		testsuites.InitGcsFuseCSIVPCSCTestSuite,
+		testsuites.InitGcsFuseCSIVolumeAttributesFuzzTestSuite,
 	}
 
-	testDriver := specs.InitGCSFuseCSITestDriver(c, m, *bucketLocation, *skipGcpSaTest, false, *clientProtocol)
+	testDriver := specs.InitGCSFuseCSITestDriver(c, m, *csiDriverName, *bucketLocation, *crossProjectID, *vpcSCDenied, *vpcSCAllowed, *skipGcpSaTest, false, *clientProtocol, *mountOptions, *volumeAttrs)
 
 	ginkgo.Context(fmt.Sprintf("[Driver: %s]", testDriver.GetDriverInfo().Name), func() {
 		storageframework.DefineTestSuites(testDriver, GCSFuseCSITestSuites)
@@ -128,7 +138,7 @@ var _ = ginkgo.Describe("E2E Test Suite", func() {
 		testsuites.InitGcsFuseCSIGCSFuseIntegrationFileCacheParallelDownloadsTestSuite,
 	}
 
-	testDriverHNS := specs.InitGCSFuseCSITestDriver(c, m, *bucketLocation, *skipGcpSaTest, true, *clientProtocol)
+	testDriverHNS := specs.InitGCSFuseCSITestDriver(c, m, *csiDriverName, *bucketLocation, *crossProjectID, *vpcSCDenied, *vpcSCAllowed, *skipGcpSaTest, true, *clientProtocol, *mountOptions, *volumeAttrs)
 
 	ginkgo.Context(fmt.Sprintf("[Driver: %s HNS]", testDriverHNS.GetDriverInfo().Name), func() {
 		storageframework.DefineTestSuites(testDriverHNS, GCSFuseCSITestSuitesHNS)
@@ -22,6 +22,7 @@ import (
 	"os"
 	"strings"
 
+	driver "github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/csi_driver"
 	"k8s.io/klog/v2"
 	"local/test/e2e/utils"
 )
@@ -45,13 +46,19 @@ var (
 	boskosResourceType = flag.String("boskos-resource-type", "gke-internal-project", "name of the boskos resource type to reserve")
 
 	// Driver flags.
-	imageRegistry          = flag.String("image-registry", "", "name of image to stage to")
-	buildGcsFuseCsiDriver  = flag.Bool("build-gcs-fuse-csi-driver", false, "whether or not to build GCS FUSE CSI Driver images")
-	buildGcsFuseFromSource = flag.Bool("build-gcs-fuse-from-source", false, "whether or not to build GCS FUSE from source code")
-	buildArm               = flag.Bool("build-arm", false, "whether or not to build the image for Arm nodes")
-	deployOverlayName      = flag.String("deploy-overlay-name", "stable", "which kustomize overlay to deploy the driver with")
-	useGKEManagedDriver    = flag.Bool("use-gke-managed-driver", false, "use GKE managed GCS FUSE CSI driver for the tests")
-	gcsfuseClientProtocol  = flag.String("gcsfuse-client-protocol", "http", "type of protocol gcsfuse uses to communicate with gcs")
+	imageRegistry           = flag.String("image-registry", "", "name of image to stage to")
+	buildGcsFuseCsiDriver   = flag.Bool("build-gcs-fuse-csi-driver", false, "whether or not to build GCS FUSE CSI Driver images")
+	buildGcsFuseFromSource  = flag.Bool("build-gcs-fuse-from-source", false, "whether or not to build GCS FUSE from source code")
+	buildArm                = flag.Bool("build-arm", false, "whether or not to build the image for Arm nodes")
+	deployOverlayName       = flag.String("deploy-overlay-name", "stable", "which kustomize overlay to deploy the driver with")
+	useGKEManagedDriver     = flag.Bool("use-gke-managed-driver", false, "use GKE managed GCS FUSE CSI driver for the tests")
+	gcsfuseClientProtocol   = flag.String("gcsfuse-client-protocol", "http", "type of protocol gcsfuse uses to communicate with gcs")
+	driverName              = flag.String("csi-driver-name", driver.DefaultName, "name of the CSI driver under test")
+	testCrossProjectID      = flag.String("cross-project-id", "", "project to create the GCS bucket in for the cross-project tests, which are skipped if it is empty")
+	testVPCSCDeniedProject  = flag.String("vpc-sc-denied-project-id", "", "project in a VPC Service Controls perimeter without the cluster project, to create the GCS bucket in for the VPC Service Controls tests, which are skipped if it is empty")
+	testVPCSCAllowedProject = flag.String("vpc-sc-allowed-project-id", "", "project in the VPC Service Controls perimeter of the cluster project, to create the GCS bucket in for the VPC Service Controls tests, which are skipped if it is empty")
+	extraMountOptions       = flag.String("extra-mount-options", "", "comma-separated mount options added to all the test volumes, to run the tests with a different gcsfuse configuration")
+	extraVolumeAttributes   = flag.String("extra-volume-attributes", "", "comma-separated key=value volume attributes added to the ephemeral and pre-provisioned test volumes")
 
 	// Ginkgo flags.
 	ginkgoFocus         = flag.String("ginkgo-focus", "", "pass to ginkgo run --focus flag")
@@ -105,6 +112,12 @@ func main() {
 		GinkgoSkipGcpSaTest:    *ginkgoSkipGcpSaTest,
 		IstioVersion:           *istioVersion,
 		GcsfuseClientProtocol:  *gcsfuseClientProtocol,
+		DriverName:             *driverName,
+		CrossProjectID:         *testCrossProjectID,
+		VPCSCDeniedProjectID:   *testVPCSCDeniedProject,
+		VPCSCAllowedProjectID:  *testVPCSCAllowedProject,
+		ExtraMountOptions:      *extraMountOptions,
+		ExtraVolumeAttributes:  *extraVolumeAttributes,
 	}
 
 	if strings.Contains(testParams.GinkgoFocus, "performance") {
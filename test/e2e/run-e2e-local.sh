@@ -36,6 +36,10 @@ readonly ginkgo_procs="${E2E_TEST_GINKGO_PROCS:-10}"
 readonly ginkgo_timeout="${E2E_TEST_GINKGO_TIMEOUT:-4h}"
 readonly ginkgo_flake_attempts="${E2E_TEST_GINKGO_FLAKE_ATTEMPTS:-2}"
 readonly gcsfuse_client_protocol=${GCSFUSE_CLIENT_PROTOCOL:-http1}
+readonly extra_mount_options="${E2E_TEST_EXTRA_MOUNT_OPTIONS:-}"
+readonly extra_volume_attributes="${E2E_TEST_EXTRA_VOLUME_ATTRIBUTES:-}"
+readonly vpc_sc_denied_project_id="${E2E_TEST_VPC_SC_DENIED_PROJECT_ID:-}"
+readonly vpc_sc_allowed_project_id="${E2E_TEST_VPC_SC_ALLOWED_PROJECT_ID:-}"
 
 # Initialize ginkgo.
 export PATH=${PATH}:$(go env GOPATH)/bin
@@ -68,6 +72,10 @@ base_cmd="${PKGDIR}/bin/e2e-test-ci \
             --ginkgo-procs=${ginkgo_procs} \
             --ginkgo-timeout=${ginkgo_timeout} \
             --gcsfuse-client-protocol=${gcsfuse_client_protocol} \
+            --extra-mount-options=${extra_mount_options} \
+            --extra-volume-attributes=${extra_volume_attributes} \
+            --vpc-sc-denied-project-id=${vpc_sc_denied_project_id} \
+            --vpc-sc-allowed-project-id=${vpc_sc_allowed_project_id} \
             --ginkgo-flake-attempts=${ginkgo_flake_attempts}"
 
 eval "$base_cmd"
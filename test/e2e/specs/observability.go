@@ -0,0 +1,185 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package specs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	metricspkg "github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/metrics"
+	"github.com/onsi/gomega"
+	"github.com/onsi/gomega/types"
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/kubernetes/test/e2e/framework"
+	e2eevents "k8s.io/kubernetes/test/e2e/framework/events"
+)
+
+// Reasons of the events that the CSI driver emits on Pods.
+const (
+	EventReasonGCSFuseMountOptions = "GCSFuseMountOptions"
+	EventReasonSidecarTooOld       = "GCSFuseSidecarTooOld"
+	EventReasonVolumeAbnormal      = "GCSFuseVolumeAbnormal"
+	EventReasonVPCSCDenied         = "GCSFuseVPCServiceControlsDenied"
+)
+
+const (
+	// csiDriverMetricsPort is the port of the Prometheus metrics endpoint of the CSI driver node and controller servers.
+	csiDriverMetricsPort = 9920
+	// webhookMetricsPort is the port of the Prometheus metrics endpoint of the webhook.
+	webhookMetricsPort = 22032
+
+	csiDriverControllerLabelSelector = "app=gcs-fuse-csi-driver"
+	webhookLabelSelector             = "app=gcs-fuse-csi-driver-webhook"
+)
+
+// WaitForEvent waits for an event of the type and reason on the Pod, with a message that contains msg.
+func (t *TestPod) WaitForEvent(ctx context.Context, eventType, reason, msg string) {
+	err := e2eevents.WaitTimeoutForEvent(
+		ctx,
+		t.client,
+		t.namespace.Name,
+		t.eventSelector(eventType, reason),
+		msg,
+		pollTimeoutSlow)
+	framework.ExpectNoError(err, "while waiting for %v event %q on Pod %q", eventType, reason, t.pod.Name)
+}
+
+// VerifyNoEvent verifies that the Pod has no event of the type and reason.
+func (t *TestPod) VerifyNoEvent(ctx context.Context, eventType, reason string) {
+	events, err := t.client.CoreV1().Events(t.namespace.Name).List(ctx, metav1.ListOptions{FieldSelector: t.eventSelector(eventType, reason)})
+	framework.ExpectNoError(err)
+
+	messages := []string{}
+	for _, e := range events.Items {
+		messages = append(messages, e.Message)
+	}
+	gomega.Expect(messages).To(gomega.BeEmpty(), fmt.Sprintf("Pod %q should not have %v event %q", t.pod.Name, eventType, reason))
+}
+
+func (t *TestPod) eventSelector(eventType, reason string) string {
+	return fields.Set{
+		"involvedObject.kind": "Pod",
+		"involvedObject.name": t.pod.Name,
+		"type":                eventType,
+		"reason":              reason,
+	}.AsSelector().String()
+}
+
+// GetCSIDriverNodeMetrics scrapes the Prometheus metrics of the CSI driver node server on the node of the Pod.
+// The metrics are fetched from the given container of the Pod, because the metrics endpoint is only reachable from the cluster network.
+func (t *TestPod) GetCSIDriverNodeMetrics(ctx context.Context, f *framework.Framework, containerName string) map[string]*dto.MetricFamily {
+	return t.scrapeMetrics(f, containerName, t.GetCSIDriverNodePodIP(ctx), csiDriverMetricsPort)
+}
+
+// GetCSIDriverControllerMetrics scrapes the Prometheus metrics of the CSI driver controller servers from the given container of the Pod.
+// It returns false if the controller server does not run in the cluster, which is the case with the managed GKE add-on.
+func (t *TestPod) GetCSIDriverControllerMetrics(ctx context.Context, f *framework.Framework, containerName string) (map[string]*dto.MetricFamily, bool) {
+	return t.scrapeReplicaMetrics(ctx, f, containerName, csiDriverControllerLabelSelector, csiDriverMetricsPort)
+}
+
+// GetWebhookMetrics scrapes the Prometheus metrics of the webhook replicas from the given container of the Pod.
+// It returns false if the webhook does not run in the cluster, which is the case with the managed GKE add-on.
+func (t *TestPod) GetWebhookMetrics(ctx context.Context, f *framework.Framework, containerName string) (map[string]*dto.MetricFamily, bool) {
+	return t.scrapeReplicaMetrics(ctx, f, containerName, webhookLabelSelector, webhookMetricsPort)
+}
+
+// scrapeReplicaMetrics scrapes the metrics of all the running Pods that match the label selector in any namespace,
+// and merges the metrics of each family, because any replica may have served the requests of the test.
+func (t *TestPod) scrapeReplicaMetrics(ctx context.Context, f *framework.Framework, containerName, labelSelector string, port int) (map[string]*dto.MetricFamily, bool) {
+	pods, err := t.client.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		LabelSelector: labelSelector,
+		FieldSelector: "status.phase=" + string(corev1.PodRunning),
+	})
+	framework.ExpectNoError(err)
+
+	merged := map[string]*dto.MetricFamily{}
+	for _, pod := range pods.Items {
+		if pod.Status.PodIP == "" {
+			continue
+		}
+
+		for name, family := range t.scrapeMetrics(f, containerName, pod.Status.PodIP, port) {
+			if m, ok := merged[name]; ok {
+				m.Metric = append(m.Metric, family.GetMetric()...)
+			} else {
+				merged[name] = family
+			}
+		}
+	}
+
+	return merged, len(merged) > 0
+}
+
+func (t *TestPod) scrapeMetrics(f *framework.Framework, containerName, podIP string, port int) map[string]*dto.MetricFamily {
+	output := t.VerifyExecInPodSucceedWithOutput(f, containerName, fmt.Sprintf("wget -q -O - http://%v:%v/metrics", podIP, port))
+
+	families, err := metricspkg.ProcessMetricsData(strings.NewReader(output))
+	framework.ExpectNoError(err, "while parsing the metrics of %v:%v", podIP, port)
+
+	return families
+}
+
+// FilterMetrics returns the metrics of the metric family name that have all the given label values.
+func FilterMetrics(families map[string]*dto.MetricFamily, name string, labels map[string]string) []*dto.Metric {
+	metrics := []*dto.Metric{}
+
+metricLoop:
+	for _, m := range families[name].GetMetric() {
+		for _, pair := range m.GetLabel() {
+			if value, ok := labels[pair.GetName()]; ok && value != pair.GetValue() {
+				continue metricLoop
+			}
+		}
+
+		metrics = append(metrics, m)
+	}
+
+	return metrics
+}
+
+// VerifyMetricValue verifies that the metrics of the metric family name with the given label values exist,
+// and that the sum of their values matches. Histograms and summaries are measured by their sample count.
+func VerifyMetricValue(families map[string]*dto.MetricFamily, name string, labels map[string]string, matcher types.GomegaMatcher) {
+	metrics := FilterMetrics(families, name, labels)
+	gomega.Expect(metrics).NotTo(gomega.BeEmpty(), fmt.Sprintf("metric %q with labels %v should exist", name, labels))
+
+	var sum float64
+	for _, m := range metrics {
+		sum += metricValue(m)
+	}
+	gomega.Expect(sum).To(matcher, fmt.Sprintf("value of metric %q with labels %v", name, labels))
+}
+
+func metricValue(m *dto.Metric) float64 {
+	switch {
+	case m.GetCounter() != nil:
+		return m.GetCounter().GetValue()
+	case m.GetGauge() != nil:
+		return m.GetGauge().GetValue()
+	case m.GetHistogram() != nil:
+		return float64(m.GetHistogram().GetSampleCount())
+	case m.GetSummary() != nil:
+		return float64(m.GetSummary().GetSampleCount())
+	default:
+		return m.GetUntyped().GetValue()
+	}
+}
@@ -81,6 +81,15 @@ const (
 	SkipCSIBucketAccessCheckAndInvalidMountOptionsVolumePrefix = "gcsfuse-csi-skip-bucket-access-check-invalid-mount-options-volume"
 	SkipCSIBucketAccessCheckAndNonRootVolumePrefix             = "gcsfuse-csi-skip-bucket-access-check-non-root-volume"
 	SkipCSIBucketAccessCheckAndImplicitDirsVolumePrefix        = "gcsfuse-csi-skip-bucket-access-check-implicit-dirs-volume"
+	RequesterPaysVolumePrefix                                  = "gcsfuse-csi-requester-pays-volume"
+	RequesterPaysWithoutBillingProjectVolumePrefix             = "gcsfuse-csi-requester-pays-without-billing-project-volume"
+	CrossProjectVolumePrefix                                   = "gcsfuse-csi-cross-project-volume"
+	TokenRefreshVolumePrefix                                   = "gcsfuse-csi-token-refresh-volume"
+	VPCSCDeniedVolumePrefix                                    = "gcsfuse-csi-vpc-sc-denied-volume"
+	VPCSCAllowedVolumePrefix                                   = "gcsfuse-csi-vpc-sc-allowed-volume"
+
+	// TokenRefreshSeconds is the interval at which the token refresh volumes fetch a new token.
+	TokenRefreshSeconds = "60"
 
 	// Read ahead config custom settings to verify testing.
 	ReadAheadCustomReadAheadKb = "15360"
@@ -214,6 +223,17 @@ func (t *TestPod) VerifyExecInPodFail(f *framework.Framework, containerName, shE
 		fmt.Sprintf("%q should fail with exit code %d, but exit without error\nstdout: %s\nstderr: %s", shExec, exitCode, stdout, stderr))
 }
 
+// WaitForExecInPodResult waits until shell cmd in target pod succeeds, or fails if succeed is false.
+func (t *TestPod) WaitForExecInPodResult(ctx context.Context, f *framework.Framework, containerName, shExec string, succeed bool) {
+	err := wait.PollUntilContextTimeout(ctx, pollIntervalSlow, pollTimeoutSlow, true, func(context.Context) (bool, error) {
+		stdout, stderr, err := e2epod.ExecCommandInContainerWithFullOutput(f, t.pod.Name, containerName, "/bin/sh", "-c", shExec)
+		framework.Logf("%q returned error %v\nstdout: %s\nstderr: %s", shExec, err, stdout, stderr)
+
+		return (err == nil) == succeed, nil
+	})
+	framework.ExpectNoError(err, "%q did not return the expected result, succeed: %v", shExec, succeed)
+}
+
 func (t *TestPod) WaitForRunning(ctx context.Context) {
 	err := e2epod.WaitTimeoutForPodRunningInNamespace(ctx, t.client, t.pod.Name, t.pod.Namespace, pollTimeoutSlow)
 	framework.ExpectNoError(err)
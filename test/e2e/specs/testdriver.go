@@ -20,6 +20,7 @@ package specs
 import (
 	"context"
 	"fmt"
+	"maps"
 	"strings"
 
 	"github.com/google/uuid"
@@ -45,6 +46,11 @@ type GCSFuseCSITestDriver struct {
 	storageServiceManager       storage.ServiceManager
 	volumeStore                 []*gcsVolume
 	bucketLocation              string
+	crossProjectID              string
+	vpcSCDeniedProjectID        string
+	vpcSCAllowedProjectID       string
+	extraMountOptions           []string
+	extraVolumeAttributes       map[string]string
 	ClientProtocol              string
 	skipGcpSaTest               bool
 	EnableHierarchicalNamespace bool
@@ -52,6 +58,7 @@ type GCSFuseCSITestDriver struct {
 
 type gcsVolume struct {
 	bucketName              string
+	billingProject          string
 	serviceAccountNamespace string
 	mountOptions            string
 	fileCacheCapacity       string
@@ -60,18 +67,39 @@ type gcsVolume struct {
 	skipBucketAccessCheck   bool
 	metadataPrefetch        bool
 	enableMetrics           bool
+	tokenRefreshSeconds     string
 }
 
 // InitGCSFuseCSITestDriver returns GCSFuseCSITestDriver that implements TestDriver interface.
-func InitGCSFuseCSITestDriver(c clientset.Interface, m metadata.Service, bl string, skipGcpSaTest, enableHierarchicalNamespace bool, clientProtocol string) storageframework.TestDriver {
-	ssm, err := storage.NewGCSServiceManager()
+// The comma-separated extraMountOptions are added to all the volumes, and the comma-separated key=value pairs of
+// extraVolumeAttributes are added to the ephemeral and pre-provisioned volumes, so that all the test suites can run with a different configuration.
+// The VPC Service Controls tests create their buckets in vpcSCDeniedProjectID, in a service perimeter that does not include the cluster project,
+// and in vpcSCAllowedProjectID, in the service perimeter of the cluster project. They are skipped if the project is empty.
+func InitGCSFuseCSITestDriver(c clientset.Interface, m metadata.Service, driverName, bl, crossProjectID, vpcSCDeniedProjectID, vpcSCAllowedProjectID string, skipGcpSaTest, enableHierarchicalNamespace bool, clientProtocol, extraMountOptions, extraVolumeAttributes string) storageframework.TestDriver {
+	ssm, err := storage.NewGCSServiceManager("", storage.RequestLabels{})
 	if err != nil {
 		e2eframework.Failf("Failed to set up storage service manager: %v", err)
 	}
 
+	var mountOptions []string
+	if extraMountOptions != "" {
+		mountOptions = strings.Split(extraMountOptions, ",")
+	}
+
+	volumeAttributes := map[string]string{}
+	if extraVolumeAttributes != "" {
+		for _, attr := range strings.Split(extraVolumeAttributes, ",") {
+			k, v, ok := strings.Cut(attr, "=")
+			if !ok {
+				e2eframework.Failf("Invalid extra volume attribute %q, must be in the key=value format", attr)
+			}
+			volumeAttributes[k] = v
+		}
+	}
+
 	return &GCSFuseCSITestDriver{
 		driverInfo: storageframework.DriverInfo{
-			Name:        driver.DefaultName,
+			Name:        driverName,
 			MaxFileSize: storageframework.FileSizeLarge,
 			SupportedFsType: sets.NewString(
 				"", // Default fsType
@@ -86,6 +114,11 @@ func InitGCSFuseCSITestDriver(c clientset.Interface, m metadata.Service, bl stri
 		storageServiceManager:       ssm,
 		volumeStore:                 []*gcsVolume{},
 		bucketLocation:              bl,
+		crossProjectID:              crossProjectID,
+		vpcSCDeniedProjectID:        vpcSCDeniedProjectID,
+		vpcSCAllowedProjectID:       vpcSCAllowedProjectID,
+		extraMountOptions:           mountOptions,
+		extraVolumeAttributes:       volumeAttributes,
 		skipGcpSaTest:               skipGcpSaTest,
 		ClientProtocol:              clientProtocol,
 		EnableHierarchicalNamespace: enableHierarchicalNamespace,
@@ -129,7 +162,7 @@ func (n *GCSFuseCSITestDriver) PrepareTest(ctx context.Context, f *e2eframework.
 
 	ginkgo.DeferCleanup(func() {
 		for _, v := range n.volumeStore {
-			if err := n.deleteBucket(ctx, v.bucketName); err != nil {
+			if err := n.deleteBucket(ctx, v.bucketName, v.billingProject); err != nil {
 				e2eframework.Logf("failed to delete bucket: %v", err)
 			}
 		}
@@ -147,7 +180,7 @@ func (n *GCSFuseCSITestDriver) PrepareTest(ctx context.Context, f *e2eframework.
 func (n *GCSFuseCSITestDriver) CreateVolume(ctx context.Context, config *storageframework.PerTestConfig, volType storageframework.TestVolType) storageframework.TestVolume {
 	switch volType {
 	case storageframework.PreprovisionedPV:
-		var bucketName string
+		var bucketName, billingProject string
 		isMultipleBucketsPrefix := false
 
 		switch config.Prefix {
@@ -174,6 +207,34 @@ func (n *GCSFuseCSITestDriver) CreateVolume(ctx context.Context, config *storage
 
 			// Use config.Prefix to pass the bucket names back to the test suite.
 			config.Prefix = strings.Join(l, ",")
+		case RequesterPaysVolumePrefix, RequesterPaysWithoutBillingProjectVolumePrefix:
+			billingProject = n.meta.GetProjectID()
+			bucketName = n.createRequesterPaysBucket(ctx, config.Framework.Namespace.Name)
+		case CrossProjectVolumePrefix:
+			if n.crossProjectID == "" || n.skipGcpSaTest {
+				e2eskipper.Skipf("cross-project tests require a GCP service account and the cross-project-id flag")
+			}
+
+			// Do not grant access to the bucket, so that the test suite can verify the IAM error first.
+			bucketName = n.newBucket(ctx, &storage.ServiceBucket{Project: n.crossProjectID}).Name
+
+			// Use config.Prefix to pass the bucket name back to the test suite.
+			config.Prefix = bucketName
+		case TokenRefreshVolumePrefix:
+			bucketName = n.createBucket(ctx, config.Framework.Namespace.Name)
+		case VPCSCDeniedVolumePrefix, VPCSCAllowedVolumePrefix:
+			projectID := n.vpcSCAllowedProjectID
+			if config.Prefix == VPCSCDeniedVolumePrefix {
+				projectID = n.vpcSCDeniedProjectID
+			}
+			if projectID == "" {
+				e2eskipper.Skipf("VPC Service Controls tests require the vpc-sc-denied-project-id and vpc-sc-allowed-project-id flags")
+			}
+
+			// The test identity is granted access to the bucket, so that only the service perimeter can deny the requests.
+			bucket := n.newBucket(ctx, &storage.ServiceBucket{Project: projectID})
+			n.SetIAMPolicy(ctx, bucket, config.Framework.Namespace.Name, K8sServiceAccountName)
+			bucketName = bucket.Name
 		case SubfolderInBucketPrefix:
 			if len(n.volumeStore) == 0 {
 				bucketName = n.createBucket(ctx, config.Framework.Namespace.Name)
@@ -192,6 +253,7 @@ func (n *GCSFuseCSITestDriver) CreateVolume(ctx context.Context, config *storage
 
 		v := &gcsVolume{
 			bucketName:              bucketName,
+			billingProject:          billingProject,
 			serviceAccountNamespace: config.Framework.Namespace.Name,
 		}
 		mountOptions := "logging:severity:info"
@@ -236,9 +298,17 @@ func (n *GCSFuseCSITestDriver) CreateVolume(ctx context.Context, config *storage
 			v.metadataPrefetch = true
 		case EnableCustomReadAhead:
 			mountOptions += ",read_ahead_kb=" + ReadAheadCustomReadAheadKb
+		case RequesterPaysVolumePrefix:
+			mountOptions += ",billing-project=" + billingProject
 		case EnableMetadataPrefetchAndInvalidMountOptionsVolumePrefix:
 			mountOptions += ",file-system:kernel-list-cache-ttl-secs:-1,invalid-option"
 			v.metadataPrefetch = true
+		case TokenRefreshVolumePrefix:
+			v.tokenRefreshSeconds = TokenRefreshSeconds
+		}
+
+		if len(n.extraMountOptions) > 0 {
+			mountOptions += "," + strings.Join(n.extraMountOptions, ",")
 		}
 
 		v.mountOptions = mountOptions
@@ -248,7 +318,7 @@ func (n *GCSFuseCSITestDriver) CreateVolume(ctx context.Context, config *storage
 		}
 
 		switch config.Prefix {
-		case "", EnableFileCachePrefix, EnableFileCacheWithLargeCapacityPrefix, EnableFileCacheAndMetricsPrefix:
+		case "", EnableFileCachePrefix, EnableFileCacheWithLargeCapacityPrefix, EnableFileCacheAndMetricsPrefix, TokenRefreshVolumePrefix:
 			// Use config.Prefix to pass the bucket names back to the test suite.
 			config.Prefix = bucketName
 		}
@@ -292,6 +362,12 @@ func (n *GCSFuseCSITestDriver) GetPersistentVolumeSource(readOnly bool, _ string
 		va[driver.VolumeContextKeyDisableMetrics] = util.FalseStr
 	}
 
+	if gv.tokenRefreshSeconds != "" {
+		va[driver.VolumeContextKeyTokenRefreshSeconds] = gv.tokenRefreshSeconds
+	}
+
+	maps.Copy(va, n.extraVolumeAttributes)
+
 	return &corev1.PersistentVolumeSource{
 		CSI: &corev1.CSIPersistentVolumeSource{
 			Driver:           n.driverInfo.Name,
@@ -330,6 +406,12 @@ func (n *GCSFuseCSITestDriver) GetVolume(config *storageframework.PerTestConfig,
 		va[driver.VolumeContextKeyDisableMetrics] = util.FalseStr
 	}
 
+	if gv.tokenRefreshSeconds != "" {
+		va[driver.VolumeContextKeyTokenRefreshSeconds] = gv.tokenRefreshSeconds
+	}
+
+	maps.Copy(va, n.extraVolumeAttributes)
+
 	return va, gv.shared, gv.readOnly
 }
 
@@ -339,10 +421,7 @@ func (n *GCSFuseCSITestDriver) GetCSIDriverName(_ *storageframework.PerTestConfi
 
 func (n *GCSFuseCSITestDriver) GetDynamicProvisionStorageClass(ctx context.Context, config *storageframework.PerTestConfig, _ string) *storagev1.StorageClass {
 	// Set up the GCP Project IAM Policy
-	member := fmt.Sprintf("serviceAccount:%v.svc.id.goog[%v/%v]", n.meta.GetProjectID(), config.Framework.Namespace.Name, K8sServiceAccountName)
-	if !n.skipGcpSaTest {
-		member = fmt.Sprintf("serviceAccount:%v@%v.iam.gserviceaccount.com", prepareGcpSAName(config.Framework.Namespace.Name), n.meta.GetProjectID())
-	}
+	member := n.serviceAccountMember(config.Framework.Namespace.Name, K8sServiceAccountName)
 	testGCPProjectIAMPolicyBinding := NewTestGCPProjectIAMPolicyBinding(n.meta.GetProjectID(), member, "roles/storage.admin", "")
 	testGCPProjectIAMPolicyBinding.Create(ctx)
 
@@ -372,6 +451,7 @@ func (n *GCSFuseCSITestDriver) GetDynamicProvisionStorageClass(ctx context.Conte
 	case InvalidMountOptionsVolumePrefix:
 		mountOptions = append(mountOptions, "invalid-option")
 	}
+	mountOptions = append(mountOptions, n.extraMountOptions...)
 
 	return &storagev1.StorageClass{
 		ObjectMeta: metav1.ObjectMeta{
@@ -401,10 +481,7 @@ func (n *GCSFuseCSITestDriver) SetIAMPolicy(ctx context.Context, bucket *storage
 		e2eframework.Failf("Failed to prepare storage service: %v", err)
 	}
 
-	member := fmt.Sprintf("serviceAccount:%v.svc.id.goog[%v/%v]", n.meta.GetProjectID(), serviceAccountNamespace, serviceAccountName)
-	if !n.skipGcpSaTest {
-		member = fmt.Sprintf("serviceAccount:%v@%v.iam.gserviceaccount.com", prepareGcpSAName(serviceAccountNamespace), n.meta.GetProjectID())
-	}
+	member := n.serviceAccountMember(serviceAccountNamespace, serviceAccountName)
 	if err := storageService.SetIAMPolicy(ctx, bucket, member, "roles/storage.admin"); err != nil {
 		e2eframework.Failf("Failed to set the IAM policy for the new GCS bucket: %v", err)
 	}
@@ -417,17 +494,48 @@ func (n *GCSFuseCSITestDriver) RemoveIAMPolicy(ctx context.Context, bucket *stor
 		e2eframework.Failf("Failed to prepare storage service: %v", err)
 	}
 
-	member := fmt.Sprintf("serviceAccount:%v.svc.id.goog[%v/%v]", n.meta.GetProjectID(), serviceAccountNamespace, serviceAccountName)
-	if !n.skipGcpSaTest {
-		member = fmt.Sprintf("serviceAccount:%v@%v.iam.gserviceaccount.com", prepareGcpSAName(serviceAccountNamespace), n.meta.GetProjectID())
-	}
+	member := n.serviceAccountMember(serviceAccountNamespace, serviceAccountName)
 	if err := storageService.RemoveIAMPolicy(ctx, bucket, member, "roles/storage.admin"); err != nil {
 		e2eframework.Failf("Failed to remove the IAM policy from the GCS bucket: %v", err)
 	}
 }
 
+// serviceAccountMember returns the IAM member of the Kubernetes service account, or the GCP service account it impersonates.
+func (n *GCSFuseCSITestDriver) serviceAccountMember(serviceAccountNamespace, serviceAccountName string) string {
+	if !n.skipGcpSaTest {
+		return fmt.Sprintf("serviceAccount:%v@%v.iam.gserviceaccount.com", prepareGcpSAName(serviceAccountNamespace), n.meta.GetProjectID())
+	}
+
+	return fmt.Sprintf("serviceAccount:%v.svc.id.goog[%v/%v]", n.meta.GetProjectID(), serviceAccountNamespace, serviceAccountName)
+}
+
 // createBucket creates a GCS bucket.
 func (n *GCSFuseCSITestDriver) createBucket(ctx context.Context, serviceAccountNamespace string) string {
+	bucket := n.newBucket(ctx, &storage.ServiceBucket{Project: n.meta.GetProjectID()})
+	n.SetIAMPolicy(ctx, bucket, serviceAccountNamespace, K8sServiceAccountName)
+
+	return bucket.Name
+}
+
+// createRequesterPaysBucket creates a requester pays GCS bucket, and allows the test service account
+// to bill the requests to the bucket to the cluster project.
+func (n *GCSFuseCSITestDriver) createRequesterPaysBucket(ctx context.Context, serviceAccountNamespace string) string {
+	projectID := n.meta.GetProjectID()
+	bucket := n.newBucket(ctx, &storage.ServiceBucket{Project: projectID, EnableRequesterPays: true, BillingProject: projectID})
+	n.SetIAMPolicy(ctx, &storage.ServiceBucket{Name: bucket.Name, BillingProject: projectID}, serviceAccountNamespace, K8sServiceAccountName)
+
+	member := n.serviceAccountMember(serviceAccountNamespace, K8sServiceAccountName)
+	testGCPProjectIAMPolicyBinding := NewTestGCPProjectIAMPolicyBinding(projectID, member, "roles/serviceusage.serviceUsageConsumer", "")
+	testGCPProjectIAMPolicyBinding.Create(ctx)
+	ginkgo.DeferCleanup(func() {
+		testGCPProjectIAMPolicyBinding.Cleanup(ctx)
+	})
+
+	return bucket.Name
+}
+
+// newBucket creates a GCS bucket with a new unique name, using the project and the requester pays settings of b.
+func (n *GCSFuseCSITestDriver) newBucket(ctx context.Context, b *storage.ServiceBucket) *storage.ServiceBucket {
 	storageService, err := n.prepareStorageService(ctx)
 	if err != nil {
 		e2eframework.Failf("Failed to prepare storage service: %v", err)
@@ -435,26 +543,26 @@ func (n *GCSFuseCSITestDriver) createBucket(ctx context.Context, serviceAccountN
 	// the GCS bucket name is always new and unique,
 	// so there is no need to check if the bucket already exists
 	newBucket := &storage.ServiceBucket{
-		Project:                        n.meta.GetProjectID(),
+		Project:                        b.Project,
 		Name:                           uuid.NewString(),
 		Location:                       n.bucketLocation,
 		EnableUniformBucketLevelAccess: true,
 		EnableHierarchicalNamespace:    n.EnableHierarchicalNamespace,
+		EnableRequesterPays:            b.EnableRequesterPays,
+		BillingProject:                 b.BillingProject,
 	}
 
-	ginkgo.By(fmt.Sprintf("Creating bucket %q", newBucket.Name))
+	ginkgo.By(fmt.Sprintf("Creating bucket %q in project %q", newBucket.Name, newBucket.Project))
 	bucket, err := storageService.CreateBucket(ctx, newBucket)
 	if err != nil {
 		e2eframework.Failf("Failed to create a new GCS bucket: %v", err)
 	}
 
-	n.SetIAMPolicy(ctx, bucket, serviceAccountNamespace, K8sServiceAccountName)
-
-	return bucket.Name
+	return bucket
 }
 
 // deleteBucket deletes the GCS bucket.
-func (n *GCSFuseCSITestDriver) deleteBucket(ctx context.Context, bucketName string) error {
+func (n *GCSFuseCSITestDriver) deleteBucket(ctx context.Context, bucketName, billingProject string) error {
 	if bucketName == InvalidVolume {
 		return nil
 	}
@@ -465,7 +573,7 @@ func (n *GCSFuseCSITestDriver) deleteBucket(ctx context.Context, bucketName stri
 	}
 
 	ginkgo.By(fmt.Sprintf("Deleting bucket %q", bucketName))
-	err = storageService.DeleteBucket(ctx, &storage.ServiceBucket{Name: bucketName})
+	err = storageService.DeleteBucket(ctx, &storage.ServiceBucket{Name: bucketName, BillingProject: billingProject})
 	if err != nil {
 		return fmt.Errorf("failed to delete the GCS bucket: %w", err)
 	}
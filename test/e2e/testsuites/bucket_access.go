@@ -0,0 +1,200 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testsuites
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/cloud_provider/storage"
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/webhook"
+	"github.com/onsi/ginkgo/v2"
+	"google.golang.org/grpc/codes"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/kubernetes/test/e2e/framework"
+	e2evolume "k8s.io/kubernetes/test/e2e/framework/volume"
+	storageframework "k8s.io/kubernetes/test/e2e/storage/framework"
+	admissionapi "k8s.io/pod-security-admission/api"
+	"local/test/e2e/specs"
+)
+
+type gcsFuseCSIBucketAccessTestSuite struct {
+	tsInfo storageframework.TestSuiteInfo
+}
+
+// InitGcsFuseCSIBucketAccessTestSuite returns gcsFuseCSIBucketAccessTestSuite that implements TestSuite interface.
+func InitGcsFuseCSIBucketAccessTestSuite() storageframework.TestSuite {
+	return &gcsFuseCSIBucketAccessTestSuite{
+		tsInfo: storageframework.TestSuiteInfo{
+			Name: "bucketAccess",
+			TestPatterns: []storageframework.TestPattern{
+				storageframework.DefaultFsCSIEphemeralVolume,
+				storageframework.DefaultFsPreprovisionedPV,
+			},
+		},
+	}
+}
+
+func (t *gcsFuseCSIBucketAccessTestSuite) GetTestSuiteInfo() storageframework.TestSuiteInfo {
+	return t.tsInfo
+}
+
+func (t *gcsFuseCSIBucketAccessTestSuite) SkipUnsupportedTests(_ storageframework.TestDriver, _ storageframework.TestPattern) {
+}
+
+func (t *gcsFuseCSIBucketAccessTestSuite) DefineTests(driver storageframework.TestDriver, pattern storageframework.TestPattern) {
+	type local struct {
+		config         *storageframework.PerTestConfig
+		volumeResource *storageframework.VolumeResource
+	}
+	var l local
+	ctx := context.Background()
+
+	// Beware that it also registers an AfterEach which renders f unusable. Any code using
+	// f must run inside an It or Context callback.
+	f := framework.NewFrameworkWithCustomTimeouts("bucket-access", storageframework.GetDriverTimeouts(driver))
+	f.NamespacePodSecurityEnforceLevel = admissionapi.LevelPrivileged
+
+	init := func(configPrefix ...string) {
+		l = local{}
+		l.config = driver.PrepareTest(ctx, f)
+		if len(configPrefix) > 0 {
+			l.config.Prefix = configPrefix[0]
+		}
+		l.volumeResource = storageframework.CreateVolumeResource(ctx, driver, l.config, pattern, e2evolume.SizeRange{})
+	}
+
+	cleanup := func() {
+		var cleanUpErrs []error
+		cleanUpErrs = append(cleanUpErrs, l.volumeResource.CleanupResource(ctx))
+		err := utilerrors.NewAggregate(cleanUpErrs)
+		framework.ExpectNoError(err, "while cleaning up")
+	}
+
+	ginkgo.It("should mount a requester pays bucket with a billing project", func() {
+		init(specs.RequesterPaysVolumePrefix)
+		defer cleanup()
+
+		ginkgo.By("Configuring the pod")
+		tPod := specs.NewTestPod(f.ClientSet, f.Namespace)
+		tPod.SetupVolume(l.volumeResource, volumeName, mountPath, false)
+
+		ginkgo.By("Deploying the pod")
+		tPod.Create(ctx)
+		defer tPod.Cleanup(ctx)
+
+		ginkgo.By("Checking that the pod is running")
+		tPod.WaitForRunning(ctx)
+
+		ginkgo.By("Checking that gcsfuse bills the requests to the billing project")
+		tPod.WaitForLog(ctx, webhook.GcsFuseSidecarName, "--billing-project")
+
+		ginkgo.By("Checking that the pod command exits with no error")
+		tPod.VerifyExecInPodSucceed(f, specs.TesterContainerName, fmt.Sprintf("mount | grep %v | grep rw,", mountPath))
+		tPod.VerifyExecInPodSucceed(f, specs.TesterContainerName, fmt.Sprintf("echo 'hello world' > %v/data && grep 'hello world' %v/data", mountPath, mountPath))
+	})
+
+	ginkgo.It("should fail to mount a requester pays bucket without a billing project", func() {
+		init(specs.RequesterPaysWithoutBillingProjectVolumePrefix)
+		defer cleanup()
+
+		ginkgo.By("Configuring the pod")
+		tPod := specs.NewTestPod(f.ClientSet, f.Namespace)
+		tPod.SetupVolume(l.volumeResource, volumeName, mountPath, false)
+
+		ginkgo.By("Deploying the pod")
+		tPod.Create(ctx)
+		defer tPod.Cleanup(ctx)
+
+		ginkgo.By("Checking that the pod has failed mount error")
+		tPod.WaitForFailedMountError(ctx, "no user project provided")
+	})
+
+	ginkgo.It("should mount a bucket in a different project with service account impersonation", func() {
+		init(specs.CrossProjectVolumePrefix)
+		defer cleanup()
+
+		// The test driver uses config.Prefix to pass the bucket name back to the test suite.
+		bucketName := l.config.Prefix
+
+		ginkgo.By("Configuring the pod")
+		tPod := specs.NewTestPod(f.ClientSet, f.Namespace)
+		tPod.SetupVolume(l.volumeResource, volumeName, mountPath, false)
+
+		ginkgo.By("Deploying the pod")
+		tPod.Create(ctx)
+		defer tPod.Cleanup(ctx)
+
+		ginkgo.By("Checking that the pod has failed mount error PermissionDenied")
+		tPod.WaitForFailedMountError(ctx, codes.PermissionDenied.String())
+		tPod.WaitForFailedMountError(ctx, "does not have storage.objects.list access to the Google Cloud Storage bucket.")
+
+		ginkgo.By("Granting the GCP service account access to the bucket")
+		gcsfuseCSITestDriver, ok := driver.(*specs.GCSFuseCSITestDriver)
+		if !ok {
+			framework.Failf("Failed to cast driver to GCSFuseCSITestDriver")
+		}
+		gcsfuseCSITestDriver.SetIAMPolicy(ctx, &storage.ServiceBucket{Name: bucketName}, f.Namespace.Name, specs.K8sServiceAccountName)
+
+		ginkgo.By("Checking that the pod is running")
+		tPod.WaitForRunning(ctx)
+
+		ginkgo.By("Checking that the pod command exits with no error")
+		tPod.VerifyExecInPodSucceed(f, specs.TesterContainerName, fmt.Sprintf("echo 'hello world' > %v/data && grep 'hello world' %v/data", mountPath, mountPath))
+	})
+
+	ginkgo.It("should pick up IAM changes without remounting when the token refresh is enabled", func() {
+		init(specs.TokenRefreshVolumePrefix)
+		defer cleanup()
+
+		// The test driver uses config.Prefix to pass the bucket name back to the test suite.
+		bucketName := l.config.Prefix
+
+		gcsfuseCSITestDriver, ok := driver.(*specs.GCSFuseCSITestDriver)
+		if !ok {
+			framework.Failf("Failed to cast driver to GCSFuseCSITestDriver")
+		}
+
+		ginkgo.By("Configuring the pod")
+		tPod := specs.NewTestPod(f.ClientSet, f.Namespace)
+		tPod.SetupVolume(l.volumeResource, volumeName, mountPath, false)
+
+		ginkgo.By("Deploying the pod")
+		tPod.Create(ctx)
+		defer tPod.Cleanup(ctx)
+
+		ginkgo.By("Checking that the pod is running")
+		tPod.WaitForRunning(ctx)
+
+		ginkgo.By("Checking that the pod command exits with no error")
+		tPod.VerifyExecInPodSucceed(f, specs.TesterContainerName, fmt.Sprintf("echo 'hello world' > %v/data && grep 'hello world' %v/data", mountPath, mountPath))
+
+		ginkgo.By("Revoking the access to the bucket")
+		gcsfuseCSITestDriver.RemoveIAMPolicy(ctx, &storage.ServiceBucket{Name: bucketName}, f.Namespace.Name, specs.K8sServiceAccountName)
+
+		ginkgo.By("Checking that the volume loses the access to the bucket without a remount")
+		tPod.WaitForExecInPodResult(ctx, f, specs.TesterContainerName, fmt.Sprintf("echo 'revoked' > %v/data-$(date +%%s)", mountPath), false)
+
+		ginkgo.By("Granting the access to the bucket again")
+		gcsfuseCSITestDriver.SetIAMPolicy(ctx, &storage.ServiceBucket{Name: bucketName}, f.Namespace.Name, specs.K8sServiceAccountName)
+
+		ginkgo.By("Checking that the volume regains the access to the bucket without a remount")
+		tPod.WaitForExecInPodResult(ctx, f, specs.TesterContainerName, fmt.Sprintf("echo 'granted' > %v/data-$(date +%%s)", mountPath), true)
+		tPod.VerifyExecInPodSucceed(f, specs.TesterContainerName, fmt.Sprintf("grep 'hello world' %v/data", mountPath))
+	})
+}
@@ -28,7 +28,7 @@ import (
 	metricspkg "github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/metrics"
 	"github.com/onsi/ginkgo/v2"
 	"github.com/onsi/gomega"
-	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/kubernetes/test/e2e/framework"
 	e2evolume "k8s.io/kubernetes/test/e2e/framework/volume"
@@ -52,6 +52,33 @@ var expectedMetricNames = map[string]int{
 	"file_cache_read_latencies":   1,
 }
 
+// The metric names below are an API: dashboards and alerts query them, so renaming any of them breaks users.
+// The "should expose the stable metric names" test fails when one of them is no longer exported.
+var (
+	// csiDriverNodeMetricNames are the series that the CSI driver node server exports for each volume.
+	csiDriverNodeMetricNames = []string{
+		"gke_gcsfuse_csi_sidecar_ephemeral_storage_usage_bytes",
+	}
+	// sidecarMetricNames are the gcsfuse series of each volume that the CSI driver node server scrapes from the sidecar container.
+	sidecarMetricNames = []string{
+		"fs_ops_count",
+		"fs_ops_latency",
+		"gcs_read_count",
+		"gcs_read_bytes_count",
+		"gcs_request_count",
+		"gcs_request_latencies",
+	}
+	// webhookMetricNames are the series of the admission requests, the certificates and the injection limits that the webhook exports.
+	webhookMetricNames = []string{
+		"controller_runtime_webhook_requests_total",
+		"controller_runtime_webhook_latency_seconds",
+		"gke_gcsfuse_webhook_serving_cert_expiry_timestamp_seconds",
+		"gke_gcsfuse_webhook_ca_bundle_mismatch",
+		"gke_gcsfuse_webhook_shed_requests_total",
+		"gke_gcsfuse_webhook_injections_in_flight",
+	}
+)
+
 type gcsFuseCSIMetricsTestSuite struct {
 	tsInfo storageframework.TestSuiteInfo
 }
@@ -213,38 +240,14 @@ func (t *gcsFuseCSIMetricsTestSuite) DefineTests(driver storageframework.TestDri
 		}
 		podName := tPod.GetPodName()
 
+		labels := map[string]string{
+			"bucket_name":    bucketName,
+			"pod_name":       podName,
+			"volume_name":    volume,
+			"namespace_name": f.Namespace.Name,
+		}
 		for metricName, metricCount := range expectedMetricNames {
-			metricsList := []*dto.Metric{}
-			metricFamily, ok := families[metricName]
-			if ok {
-			metricLoop:
-				for _, m := range metricFamily.GetMetric() {
-					for _, pair := range m.GetLabel() {
-						name, value := pair.GetName(), pair.GetValue()
-						switch name {
-						case "bucket_name":
-							if value != bucketName {
-								continue metricLoop
-							}
-						case "pod_name":
-							if value != podName {
-								continue metricLoop
-							}
-						case "volume_name":
-							if value != volume {
-								continue metricLoop
-							}
-						case "namespace_name":
-							if value != f.Namespace.Name {
-								continue metricLoop
-							}
-						}
-					}
-
-					metricsList = append(metricsList, m)
-				}
-			}
-
+			metricsList := specs.FilterMetrics(families, metricName, labels)
 			gomega.Expect(metricsList).To(gomega.HaveLen(metricCount), fmt.Sprintf("Found metric %q count: %v, expected count: %v", metricName, len(metricsList), metricCount))
 			ginkgo.By(fmt.Sprintf("Found metric %q count: %v", metricName, len(metricsList)))
 		}
@@ -272,6 +275,13 @@ func (t *gcsFuseCSIMetricsTestSuite) DefineTests(driver storageframework.TestDri
 		tPod.WaitForRunning(ctx)
 
 		verifyMetrics(tPod, l.volumeResourceList[0], mountPath, volumeName)
+
+		ginkgo.By("Checking that the mount options event is emitted")
+		tPod.WaitForEvent(ctx, corev1.EventTypeNormal, specs.EventReasonGCSFuseMountOptions, "is mounted with gcsfuse mount options")
+
+		ginkgo.By("Checking the build info metric of the CSI driver node server")
+		families := tPod.GetCSIDriverNodeMetrics(ctx, f, specs.TesterContainerName)
+		specs.VerifyMetricValue(families, "gke_gcsfuse_csi_build_info", nil, gomega.Equal(1.0))
 	})
 
 	// This tests below configuration:
@@ -302,4 +312,55 @@ func (t *gcsFuseCSIMetricsTestSuite) DefineTests(driver storageframework.TestDri
 			verifyMetrics(tPod, vr, fmt.Sprintf("%v/%v", mountPath, i), fmt.Sprintf("%v-%v", volumeName, i))
 		}
 	})
+
+	// This tests that the metric names that users depend on are exported by all the metrics endpoints during a workload run.
+	ginkgo.It("should expose the stable metric names of the driver, webhook and sidecar endpoints", func() {
+		init(1, specs.EnableFileCacheAndMetricsPrefix)
+		defer cleanup()
+
+		ginkgo.By("Configuring the pod")
+		tPod := specs.NewTestPod(f.ClientSet, f.Namespace)
+		tPod.SetupVolume(l.volumeResourceList[0], volumeName, mountPath, false)
+
+		ginkgo.By("Deploying the pod")
+		tPod.Create(ctx)
+		defer tPod.Cleanup(ctx)
+
+		ginkgo.By("Checking that the pod is running")
+		tPod.WaitForRunning(ctx)
+
+		ginkgo.By("Running a workload on the volume")
+		tPod.VerifyExecInPodSucceed(f, specs.TesterContainerName, fmt.Sprintf("echo 'hello world' > %v/data && cat %v/data && ls %v", mountPath, mountPath, mountPath))
+
+		ginkgo.By("Checking the metrics of the CSI driver node server and the sidecar container")
+		volumeLabels := map[string]string{"pod_name": tPod.GetPodName(), "namespace_name": f.Namespace.Name}
+		families := tPod.GetCSIDriverNodeMetrics(ctx, f, specs.TesterContainerName)
+		specs.VerifyMetricValue(families, "gke_gcsfuse_csi_build_info", nil, gomega.Equal(1.0))
+		for _, name := range csiDriverNodeMetricNames {
+			specs.VerifyMetricValue(families, name, volumeLabels, gomega.BeNumerically(">=", 0))
+		}
+		for _, name := range sidecarMetricNames {
+			specs.VerifyMetricValue(families, name, volumeLabels, gomega.BeNumerically(">", 0))
+		}
+
+		ginkgo.By("Checking the metrics of the webhook")
+		if families, ok := tPod.GetWebhookMetrics(ctx, f, specs.TesterContainerName); ok {
+			for _, name := range webhookMetricNames {
+				specs.VerifyMetricValue(families, name, map[string]string{"webhook": "/inject"}, gomega.BeNumerically(">", 0))
+			}
+		} else {
+			ginkgo.By("The webhook does not run in the cluster, skipping its metrics")
+		}
+
+		ginkgo.By("Checking the metrics of the CSI driver controller server")
+		if families, ok := tPod.GetCSIDriverControllerMetrics(ctx, f, specs.TesterContainerName); ok {
+			specs.VerifyMetricValue(families, "gke_gcsfuse_csi_build_info", nil, gomega.BeNumerically(">", 0))
+			specs.VerifyMetricValue(families, "gke_gcsfuse_csi_orphaned_buckets", nil, gomega.BeNumerically(">=", 0))
+			if pattern.VolType == storageframework.DynamicPV {
+				specs.VerifyMetricValue(families, "gke_gcsfuse_csi_provisioning_operation_duration_seconds", map[string]string{"operation": "CreateVolume"}, gomega.BeNumerically(">", 0))
+			}
+		} else {
+			ginkgo.By("The CSI driver controller server does not run in the cluster, skipping its metrics")
+		}
+	})
 }
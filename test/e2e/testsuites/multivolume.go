@@ -23,10 +23,12 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/onsi/ginkgo/v2"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/test/e2e/framework"
 	e2eskipper "k8s.io/kubernetes/test/e2e/framework/skipper"
@@ -163,6 +165,66 @@ func (t *gcsFuseCSIMultiVolumeTestSuite) DefineTests(driver storageframework.Tes
 		}
 	}
 
+	// testConcurrentWriters verifies the last-writer-wins semantics of Cloud Storage objects.
+	// Each writer repeatedly replaces the whole object with its own content, and a write may fail when it races with the other writer.
+	// Every successful read must return the complete content of one writer, and all the readers must eventually read the same content.
+	testConcurrentWriters := func() {
+		const (
+			writerCount = 2
+			writeCount  = 20
+			lineCount   = 100000
+		)
+		objectPath := mountPath + "/concurrent-data"
+		// readCmd prints the writer of the object, and fails when the object mixes the content of different writers or is truncated.
+		readCmd := fmt.Sprintf(`cat %v > /tmp/read || exit 0; [ "$(sort -u /tmp/read | wc -l)" -eq 1 ] && [ "$(wc -l < /tmp/read)" -eq %v ] && head -n 1 /tmp/read`, objectPath, lineCount)
+
+		tPods := make([]*specs.TestPod, writerCount)
+		for i := range tPods {
+			ginkgo.By(fmt.Sprintf("Configuring the pod %v", i))
+			tPods[i] = specs.NewTestPod(f.ClientSet, f.Namespace)
+			tPods[i].SetupVolume(l.volumeResourceList[0], volumeName, mountPath, false)
+			if i > 0 {
+				tPods[i].SetNodeAffinity(tPods[0].GetNode(), false)
+			}
+
+			ginkgo.By(fmt.Sprintf("Deploying the pod %v", i))
+			tPods[i].Create(ctx)
+			defer tPods[i].Cleanup(ctx)
+
+			ginkgo.By(fmt.Sprintf("Checking that the pod %v is running", i))
+			tPods[i].WaitForRunning(ctx)
+		}
+
+		ginkgo.By("Writing the same object from all the pods concurrently")
+		var wg sync.WaitGroup
+		for i, tPod := range tPods {
+			wg.Add(1)
+			go func() {
+				defer ginkgo.GinkgoRecover()
+				defer wg.Done()
+
+				tPod.VerifyExecInPodSucceed(f, specs.TesterContainerName, fmt.Sprintf("for i in $(seq %v); do yes writer-%v | head -n %v > %v; (%v) || exit 1; done", writeCount, i, lineCount, objectPath, readCmd))
+			}()
+		}
+		wg.Wait()
+
+		ginkgo.By("Checking that all the pods eventually read the content of the same writer")
+		var writers []string
+		for range 20 {
+			writers = []string{}
+			for _, tPod := range tPods {
+				writers = append(writers, strings.TrimSpace(tPod.VerifyExecInPodSucceedWithOutput(f, specs.TesterContainerName, readCmd)))
+			}
+
+			if writers[0] != "" && len(sets.New(writers...)) == 1 {
+				return
+			}
+
+			time.Sleep(10 * time.Second)
+		}
+		framework.Failf("The pods read the content of different writers %v", writers)
+	}
+
 	// This tests below configuration:
 	//          [node1]
 	//          /    \
@@ -323,4 +385,19 @@ func (t *gcsFuseCSIMultiVolumeTestSuite) DefineTests(driver storageframework.Tes
 			tPod.VerifyExecInPodSucceed(f, specs.TesterContainerName, fmt.Sprintf("echo 'hello world' > %v/%v/data-%v && grep 'hello world' %v/%v/data-%v", mountPath, bucketName, i, mountPath, bucketName, i))
 		}
 	})
+
+	// This tests below configuration:
+	//    [node1]    [node2]
+	//       |          |
+	//    [pod1]     [pod2]
+	//          \    /
+	//         [volume1]
+	//             |
+	//         [bucket1]
+	ginkgo.It("should resolve concurrent writes to the same object from different Pods with last-writer-wins", func() {
+		init(1)
+		defer cleanup()
+
+		testConcurrentWriters()
+	})
 }
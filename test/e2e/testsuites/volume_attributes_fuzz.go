@@ -0,0 +1,193 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testsuites
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math/rand"
+	"slices"
+	"strings"
+
+	"github.com/googlecloudplatform/gcs-fuse-csi-driver/pkg/volumespec"
+	"github.com/onsi/ginkgo/v2"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/kubernetes/test/e2e/framework"
+	e2evolume "k8s.io/kubernetes/test/e2e/framework/volume"
+	storageframework "k8s.io/kubernetes/test/e2e/storage/framework"
+	admissionapi "k8s.io/pod-security-admission/api"
+	"local/test/e2e/specs"
+)
+
+// volumeAttributesFuzzIterations is the number of random volumes each test mounts.
+const volumeAttributesFuzzIterations = 5
+
+// fuzzVolumeAttributeValues are the volume attributes the fuzz test picks from, with values that keep the volume
+// writable on a plain bucket. The attributes that need extra setup, such as verifyReadObject,
+// hierarchicalNamespace or gcpServiceAccount, are covered by their own test suites. The fuzz volumes are inline volumes, which
+// are published as multi-writer volumes, so the unlimited metadata and kernel list cache TTLs of -1 are left out, because the
+// driver rejects them on multi-writer volumes.
+var fuzzVolumeAttributeValues = map[string][]string{
+	volumespec.AttributeFileCacheCapacity:          {"0", "10Mi", "100Mi", "-1"},
+	volumespec.AttributeFileCacheForRangeRead:      {"true", "false"},
+	volumespec.AttributeFileCacheParallelDownloads: {"true", "false"},
+	volumespec.AttributeMetadataStatCacheCapacity:  {"0", "32Mi", "-1"},
+	volumespec.AttributeMetadataTypeCacheCapacity:  {"0", "4Mi", "-1"},
+	volumespec.AttributeMetadataCacheTTLSeconds:    {"0", "60"},
+	volumespec.AttributeMetadataPrefetchOnMount:    {"true", "false"},
+	volumespec.AttributeKernelListCacheTTLSeconds:  {"0", "60"},
+	volumespec.AttributeGcsfuseLoggingSeverity:     {"trace", "debug", "info", "warning", "error"},
+	volumespec.AttributeSkipCSIBucketAccessCheck:   {"true", "false"},
+	volumespec.AttributeDisableMetrics:             {"true", "false"},
+	volumespec.AttributeImplicitDirsAutoDetect:     {"true", "false"},
+	volumespec.AttributeMaxConnsPerHost:            {"0", "10", "100"},
+	volumespec.AttributeMaxIdleConnsPerHost:        {"0", "10", "100"},
+	volumespec.AttributeClientProtocol:             {"http1", "http2"},
+	volumespec.AttributeFileMode:                   {"644", "664", "666"},
+	volumespec.AttributeDirMode:                    {"755", "775", "777"},
+}
+
+// fuzzMountOptions are the mount options the fuzz test picks from.
+var fuzzMountOptions = []string{
+	"implicit-dirs",
+	"rename-dir-limit=100",
+	"metadata-cache:negative-ttl-secs:0",
+}
+
+// randomVolumeAttributes picks a random subset of the fuzz volume attributes and mount options, and retries until
+// the volume passes the validation of the driver, so that every mount is expected to succeed.
+func randomVolumeAttributes(r *rand.Rand, bucketName string) (map[string]string, []string) {
+	for {
+		attributes := map[string]string{}
+		for _, key := range slices.Sorted(maps.Keys(fuzzVolumeAttributeValues)) {
+			if r.Intn(2) == 0 {
+				values := fuzzVolumeAttributeValues[key]
+				attributes[key] = values[r.Intn(len(values))]
+			}
+		}
+
+		mountOptions := []string{}
+		for _, o := range fuzzMountOptions {
+			if r.Intn(2) == 0 {
+				mountOptions = append(mountOptions, o)
+			}
+		}
+
+		v := volumespec.New(bucketName).WithMountOptions(mountOptions...)
+		for key, value := range attributes {
+			v.WithAttribute(key, value)
+		}
+		if err := v.Validate(); err == nil {
+			return attributes, mountOptions
+		}
+	}
+}
+
+type gcsFuseCSIVolumeAttributesFuzzTestSuite struct {
+	tsInfo storageframework.TestSuiteInfo
+}
+
+// InitGcsFuseCSIVolumeAttributesFuzzTestSuite returns gcsFuseCSIVolumeAttributesFuzzTestSuite that implements TestSuite interface.
+func InitGcsFuseCSIVolumeAttributesFuzzTestSuite() storageframework.TestSuite {
+	return &gcsFuseCSIVolumeAttributesFuzzTestSuite{
+		tsInfo: storageframework.TestSuiteInfo{
+			Name: "volumeAttributesFuzz",
+			TestPatterns: []storageframework.TestPattern{
+				storageframework.DefaultFsCSIEphemeralVolume,
+			},
+		},
+	}
+}
+
+func (t *gcsFuseCSIVolumeAttributesFuzzTestSuite) GetTestSuiteInfo() storageframework.TestSuiteInfo {
+	return t.tsInfo
+}
+
+func (t *gcsFuseCSIVolumeAttributesFuzzTestSuite) SkipUnsupportedTests(_ storageframework.TestDriver, _ storageframework.TestPattern) {
+}
+
+func (t *gcsFuseCSIVolumeAttributesFuzzTestSuite) DefineTests(driver storageframework.TestDriver, pattern storageframework.TestPattern) {
+	type local struct {
+		config         *storageframework.PerTestConfig
+		volumeResource *storageframework.VolumeResource
+	}
+	var l local
+	ctx := context.Background()
+
+	// Beware that it also registers an AfterEach which renders f unusable. Any code using
+	// f must run inside an It or Context callback.
+	f := framework.NewFrameworkWithCustomTimeouts("volumes", storageframework.GetDriverTimeouts(driver))
+	f.NamespacePodSecurityEnforceLevel = admissionapi.LevelPrivileged
+
+	init := func() {
+		l = local{}
+		l.config = driver.PrepareTest(ctx, f)
+		l.volumeResource = storageframework.CreateVolumeResource(ctx, driver, l.config, pattern, e2evolume.SizeRange{})
+	}
+
+	cleanup := func() {
+		var cleanUpErrs []error
+		cleanUpErrs = append(cleanUpErrs, l.volumeResource.CleanupResource(ctx))
+		err := utilerrors.NewAggregate(cleanUpErrs)
+		framework.ExpectNoError(err, "while cleaning up")
+	}
+
+	testCaseMountRandomVolume := func(r *rand.Rand, i int) {
+		attributes, mountOptions := randomVolumeAttributes(r, l.config.Prefix)
+		ginkgo.By(fmt.Sprintf("Configuring the pod with the volume attributes %v and the mount options %v", attributes, mountOptions))
+		tPod := specs.NewTestPod(f.ClientSet, f.Namespace)
+		tPod.SetupVolume(l.volumeResource, volumeName, mountPath, false)
+
+		// The volume source is shared with the volume resource, so the attributes of each pod are set on a copy.
+		csi := tPod.GetPodVols()[0].CSI.DeepCopy()
+		maps.Copy(csi.VolumeAttributes, attributes)
+		if len(mountOptions) > 0 {
+			csi.VolumeAttributes[volumespec.AttributeMountOptions] = strings.Trim(csi.VolumeAttributes[volumespec.AttributeMountOptions]+","+strings.Join(mountOptions, ","), ",")
+		}
+		tPod.GetPodVols()[0].CSI = csi
+
+		ginkgo.By("Deploying the pod")
+		tPod.Create(ctx)
+		defer tPod.Cleanup(ctx)
+
+		ginkgo.By("Checking that the pod is running")
+		tPod.WaitForRunning(ctx)
+
+		ginkgo.By("Checking that the pod can write and read the volume")
+		dir := fmt.Sprintf("%v/fuzz-%v", mountPath, i)
+		tPod.VerifyExecInPodSucceed(f, specs.TesterContainerName, fmt.Sprintf("mount | grep %v | grep rw,", mountPath))
+		tPod.VerifyExecInPodSucceed(f, specs.TesterContainerName, fmt.Sprintf("mkdir -p %v && echo 'hello world' > %v/data && grep 'hello world' %v/data", dir, dir, dir))
+		tPod.VerifyExecInPodSucceed(f, specs.TesterContainerName, fmt.Sprintf("mv %v/data %v/renamed && ls %v | grep renamed", dir, dir, dir))
+		tPod.VerifyExecInPodSucceed(f, specs.TesterContainerName, fmt.Sprintf("rm -r %v && [ ! -e %v ]", dir, dir))
+	}
+
+	ginkgo.It("should mount and use volumes with random valid volume attributes", func() {
+		init()
+		defer cleanup()
+
+		// The seed is the ginkgo random seed, so that a failure can be reproduced with --ginkgo.seed.
+		seed := ginkgo.GinkgoRandomSeed()
+		ginkgo.By(fmt.Sprintf("Generating the volume attributes with the seed %v", seed))
+		r := rand.New(rand.NewSource(seed)) //nolint:gosec
+
+		for i := range volumeAttributesFuzzIterations {
+			testCaseMountRandomVolume(r, i)
+		}
+	})
+}
@@ -71,6 +71,12 @@ type TestParameters struct {
 	SupportSAVolInjection bool
 	IstioVersion          string
 	GcsfuseClientProtocol string
+	DriverName            string
+	CrossProjectID        string
+	VPCSCDeniedProjectID  string
+	VPCSCAllowedProjectID string
+	ExtraMountOptions     string
+	ExtraVolumeAttributes string
 }
 
 const (
@@ -206,6 +212,12 @@ func Handle(testParams *TestParameters) error {
 		testParams.PkgDir+"/test/e2e/",
 		"--",
 		"--client-protocol", testParams.GcsfuseClientProtocol,
+		"--driver-name", testParams.DriverName,
+		"--cross-project-id", testParams.CrossProjectID,
+		"--vpc-sc-denied-project-id", testParams.VPCSCDeniedProjectID,
+		"--vpc-sc-allowed-project-id", testParams.VPCSCAllowedProjectID,
+		"--extra-mount-options", testParams.ExtraMountOptions,
+		"--extra-volume-attributes", testParams.ExtraVolumeAttributes,
 		"--provider", "skeleton",
 		"--test-bucket-location", testParams.GkeClusterRegion,
 		"--skip-gcp-sa-test", strconv.FormatBool(testParams.GinkgoSkipGcpSaTest),